@@ -12,6 +12,16 @@ static void call_progress_callback(progress_callback_t callback, int current, in
         callback(current, total, description);
     }
 }
+
+// Define the byte progress callback function pointer type
+typedef void (*byte_progress_callback_t)(int layer, int layers, long long downloaded, long long download_total, long long written);
+
+// Helper function to call the byte progress callback from Go
+static void call_byte_progress_callback(byte_progress_callback_t callback, int layer, int layers, long long downloaded, long long download_total, long long written) {
+    if (callback != NULL) {
+        callback(layer, layers, downloaded, download_total, written);
+    }
+}
 */
 import "C"
 import (
@@ -108,6 +118,11 @@ func free_string(str *C.char) {
 
 //export export_image_filesystem_with_options
 func export_image_filesystem_with_options(image_ref *C.char, output_path *C.char, auth_json *C.char, compress C.int, progress_callback unsafe.Pointer) C.int {
+	return export_image_filesystem_with_byte_progress(image_ref, output_path, auth_json, compress, progress_callback, nil)
+}
+
+//export export_image_filesystem_with_byte_progress
+func export_image_filesystem_with_byte_progress(image_ref *C.char, output_path *C.char, auth_json *C.char, compress C.int, progress_callback unsafe.Pointer, byte_progress_callback unsafe.Pointer) C.int {
 	imageRef := C.GoString(image_ref)
 	outputPath := C.GoString(output_path)
 	authJSON := C.GoString(auth_json)
@@ -147,6 +162,20 @@ func export_image_filesystem_with_options(image_ref *C.char, output_path *C.char
 		}
 	}
 
+	// Set up byte progress callback if provided
+	if byte_progress_callback != nil {
+		opts.ByteProgress = func(progress lib.ByteProgress) {
+			C.call_byte_progress_callback(
+				C.byte_progress_callback_t(byte_progress_callback),
+				C.int(progress.Layer),
+				C.int(progress.Layers),
+				C.longlong(progress.Downloaded),
+				C.longlong(progress.DownloadTotal),
+				C.longlong(progress.Written),
+			)
+		}
+	}
+
 	exporter := lib.NewImageExporter()
 	err := exporter.ExportImageFilesystemWithOptions(imageRef, outputPath, auth, opts)
 	if err != nil {
@@ -10,6 +10,13 @@ extern "C" {
 // Parameters: current step (0-based), total steps, description of current operation
 typedef void (*progress_callback_t)(int current, int total, const char* description);
 
+// Byte progress callback function type
+// Called as layers are downloaded and as the archive is written
+// Parameters: zero-based layer index, layer count, compressed bytes downloaded so far,
+// compressed size of all layers, bytes written to the output file so far
+typedef void (*byte_progress_callback_t)(int layer, int layers, long long downloaded,
+                                         long long download_total, long long written);
+
 // Get image configuration as JSON string
 // Returns: JSON string (must be freed with free_string), or NULL on error
 // Parameters:
@@ -37,6 +44,20 @@ int export_image_filesystem_with_options(const char* image_ref, const char* outp
                                         const char* auth_json, int compress,
                                         progress_callback_t progress_callback);
 
+// Export image filesystem to file with step and byte progress
+// Returns: 0 on success, -1 on error
+// Parameters:
+//   image_ref: Docker image reference
+//   output_path: File path to write archive (.gz extension added if compress=1 and not present)
+//   auth_json: Authentication JSON or NULL/empty for default auth
+//   compress: 1 to enable gzip compression, 0 to disable
+//   progress_callback: Function pointer for step updates, or NULL to disable
+//   byte_progress_callback: Function pointer for byte counts, or NULL to disable
+int export_image_filesystem_with_byte_progress(const char* image_ref, const char* output_path,
+                                               const char* auth_json, int compress,
+                                               progress_callback_t progress_callback,
+                                               byte_progress_callback_t byte_progress_callback);
+
 // Get library version string
 // Returns: Version string (must be freed with free_string)
 char* get_version(void);
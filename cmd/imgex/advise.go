@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// adviseCmd handles the 'advise' subcommand for planning repository cleanup.
+// It lists digests a retention policy allows deleting without deleting anything.
+var adviseCmd = &cobra.Command{
+	Use:   "advise <repository>",
+	Short: "List tags and digests safe to delete under a retention policy",
+	Long: `Compute a deletion plan for a repository from a retention policy.
+
+Every tag is resolved to its digest. A digest is kept when any of its tags is
+kept by --keep-last (most recently created digests), --keep-semver (highest
+semantic version tags) or --keep-tag (glob patterns). Digests still referenced
+are never offered for deletion: manifests listed in a kept index stay, and
+signatures or attestations attached to a kept digest (sha256-<hex>.sig tags)
+stay with it.
+
+Nothing is deleted. By default one repository@digest reference is printed per
+line, attachments before their subjects, ready to pipe into a deletion tool;
+--json prints the full plan with the tags and reason for every digest.
+
+Examples:
+  imgex advise --keep-last 10 --keep-semver 3 registry.example.com/team/app
+  imgex advise --keep-last 5 --keep-tag 'release-*' ghcr.io/org/app | xargs -n1 crane delete
+  imgex advise --keep-semver 3 --json registry.example.com/team/app > plan.json`,
+	Args: schemaArgs(cobra.ExactArgs(1)),
+	RunE: runAdviseCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(adviseCmd)
+	adviseCmd.Flags().Int("keep-last", 0,
+		"Keep the N most recently created digests")
+	adviseCmd.Flags().Int("keep-semver", 0,
+		"Keep the N highest semantic version tags")
+	adviseCmd.Flags().StringArray("keep-tag", nil,
+		"Keep tags matching this glob pattern (repeatable)")
+	adviseCmd.Flags().Bool("schema", false,
+		"Print the JSON Schema of the --json plan and exit")
+}
+
+// runAdviseCommand implements the logic for the 'advise' subcommand.
+// It prints the deletion plan as references or JSON.
+func runAdviseCommand(cmd *cobra.Command, args []string) error {
+	if printed, err := printSchema(cmd, "retention-plan"); printed || err != nil {
+		return err
+	}
+	keepLast, _ := cmd.Flags().GetInt("keep-last")
+	keepSemver, _ := cmd.Flags().GetInt("keep-semver")
+	keepTags, _ := cmd.Flags().GetStringArray("keep-tag")
+
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	var plan *lib.RetentionPlan
+	err = withInteractiveAuth(exporter, args[0], buildAuthConfig(), func(auth *lib.AuthConfig) (err error) {
+		plan, err = exporter.AdviseRetention(args[0], auth, &lib.RetentionPolicy{
+			KeepLast:   keepLast,
+			KeepSemver: keepSemver,
+			KeepTags:   keepTags,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to advise retention: %w", err)
+	}
+
+	if jsonMode {
+		return printDocument(plan)
+	}
+	for _, ref := range plan.References() {
+		fmt.Println(ref)
+	}
+	fmt.Fprintf(os.Stderr, "Keeping %d digests, %d safe to delete\n", len(plan.Keep), len(plan.Delete))
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// auditCmd reports the files of an image that matter in a hardening review
+var auditCmd = &cobra.Command{
+	Use:   "audit <image-reference>",
+	Short: "Report setuid, world-writable and capability-bearing files of an image",
+	Long: `Report the files of an image that widen what a container process can do, for a
+quick hardening review:
+
+  setuid, setgid   executables that run with the identity of their owner or group
+  world-writable   files and directories anyone can modify (sticky directories
+                   like /tmp are fine)
+  capabilities     executables granted Linux capabilities with setcap; those that
+                   allow privilege escalation, like CAP_SYS_ADMIN, are flagged
+
+The filesystem is audited as 'filesystem' would export it.
+
+Examples:
+  imgex audit debian:bookworm
+  imgex audit --json ghcr.io/org/app:v1 | jq '.findings[] | select(.dangerous)'`,
+	Args: schemaArgs(cobra.ExactArgs(1)),
+	RunE: runAuditCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.Flags().String("platform", "",
+		"Platform of a multi-arch image to audit, e.g. linux/arm64 (default linux/amd64)")
+	auditCmd.Flags().Bool("schema", false,
+		"Print the JSON Schema of the --json output and exit")
+}
+
+// runAuditCommand implements the logic for the 'audit' subcommand.
+func runAuditCommand(cmd *cobra.Command, args []string) error {
+	if printed, err := printSchema(cmd, "audit-report"); printed || err != nil {
+		return err
+	}
+	imageRef := args[0]
+	opts := &lib.AuditOptions{}
+	opts.Platform, _ = cmd.Flags().GetString("platform")
+	cmd.SilenceUsage = true
+
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	var report *lib.AuditReport
+	err = withInteractiveAuth(exporter, imageRef, buildAuthConfig(), func(auth *lib.AuthConfig) (err error) {
+		report, err = exporter.Audit(imageRef, auth, opts)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to audit %s: %w", imageRef, err)
+	}
+	if jsonMode {
+		return printDocument(report)
+	}
+
+	table := &table{header: []string{"KIND", "MODE", "OWNER", "PATH", "CAPABILITIES"}}
+	dangerous := 0
+	for _, finding := range report.Findings {
+		style := styleYellow
+		if len(finding.Dangerous) > 0 {
+			style = styleRed
+			dangerous++
+		}
+		table.add(cell{text: finding.Kind, style: style}, cell{text: finding.Mode},
+			cell{text: fmt.Sprintf("%d:%d", finding.UID, finding.GID)}, cell{text: finding.Path},
+			cell{text: strings.Join(finding.Capabilities, ",")})
+	}
+	if len(report.Findings) > 0 {
+		table.render(newTerminal(os.Stdout))
+	}
+	printLine(os.Stderr, "%d findings in %s, %d with dangerous capabilities", len(report.Findings), imageRef, dangerous)
+	return nil
+}
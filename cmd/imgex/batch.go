@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/kenichi/imgex/lib"
+)
+
+// batchItem is one image of a --input file
+type batchItem struct {
+	image  string
+	output string // file name within --output-dir
+	line   int
+}
+
+// readBatchInput reads a --input file ("-" for stdin): one image reference per
+// line, optionally followed by the output file name. Blank lines and lines
+// starting with # are skipped.
+func readBatchInput(path string) ([]batchItem, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open input file: %w", err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	var items []batchItem
+	outputs := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s:%d: expected an image reference and an optional output name", path, number)
+		}
+		if _, err := name.ParseReference(fields[0]); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid image reference %q: %w", path, number, fields[0], err)
+		}
+
+		item := batchItem{image: fields[0], output: batchOutputName(fields[0]), line: number}
+		if len(fields) == 2 {
+			if !filepath.IsLocal(fields[1]) {
+				return nil, fmt.Errorf("%s:%d: output name %q must stay within --output-dir", path, number, fields[1])
+			}
+			item.output = fields[1]
+		}
+		if previous, ok := outputs[item.output]; ok {
+			return nil, fmt.Errorf("%s:%d: output %s is already written by line %d", path, number, item.output, previous)
+		}
+		outputs[item.output] = number
+		items = append(items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read input file: %w", err)
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%s lists no images", path)
+	}
+	return items, nil
+}
+
+// batchOutputName derives a file name from an image reference, e.g.
+// "ghcr.io/org/app:v1" becomes "ghcr.io_org_app_v1.tar"
+func batchOutputName(imageRef string) string {
+	return strings.NewReplacer("/", "_", ":", "_", "@", "_").Replace(imageRef) + ".tar"
+}
+
+// exportBatch exports every image of a --input file into outputDir, parallel
+// images at a time, going on after failures. The images share the exporter and
+// with it the blob cache and registry tokens. Each image is reported on stderr
+// as it finishes, or with JSON events when events is non-nil, and the error
+// counts the images that failed.
+func exportBatch(exporter lib.ImageExporter, items []batchItem, outputDir string, auth *lib.AuthConfig, opts *lib.ExportOptions, events *progressEvents, guard *exportGuard, parallel int) error {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	stderr := newTerminal(os.Stderr)
+
+	var mu sync.Mutex // guards failed and the report lines
+	failed := 0
+	export := func(item batchItem) {
+		outputPath := filepath.Join(outputDir, item.output)
+		if opts.Compress && !strings.HasSuffix(outputPath, ".gz") {
+			outputPath += ".gz"
+		}
+		itemOpts := *opts
+		var itemEvents *progressEvents
+		if events != nil {
+			itemEvents = events.forImage(item.image)
+			itemEvents.attach(&itemOpts)
+		} else if warn := opts.Warning; warn != nil {
+			itemOpts.Warning = func(warning lib.Warning) {
+				warning.Message = item.image + ": " + warning.Message
+				warn(warning)
+			}
+		}
+
+		start := time.Now()
+		skipped, err := exportBatchItem(exporter, item.image, outputPath, auth, &itemOpts, itemEvents, guard)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			failed++
+			logger.Warn("export failed", "image", item.image, "output", outputPath, "error", err)
+			if itemEvents != nil {
+				itemEvents.failed(err)
+			} else {
+				printLine(os.Stderr, "%s %s: %v", stderr.paint(styleRed, "FAILED"), item.image, err)
+			}
+			return
+		}
+		if skipped {
+			return
+		}
+		logger.Info("export complete", "image", item.image, "output", outputPath,
+			"duration", time.Since(start).Round(time.Millisecond))
+		if itemEvents != nil {
+			itemEvents.done(opts.Platform, outputPath)
+		} else {
+			printLine(os.Stderr, "%s %s -> %s (%s)", stderr.paint(styleGreen, "OK"), item.image, outputPath,
+				time.Since(start).Round(100*time.Millisecond))
+		}
+	}
+
+	// A pool of workers takes the images in file order
+	work := make(chan batchItem)
+	var wg sync.WaitGroup
+	for i := 0; i < min(parallel, len(items)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				export(item)
+			}
+		}()
+	}
+	for _, item := range items {
+		work <- item
+	}
+	close(work)
+	wg.Wait()
+
+	if events == nil {
+		summary := fmt.Sprintf("%d of %d images exported", len(items)-failed, len(items))
+		style := styleGreen
+		if failed > 0 {
+			summary += fmt.Sprintf(", %d failed", failed)
+			style = styleRed
+		}
+		printLine(os.Stderr, "%s", stderr.paint(style, summary))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d images failed to export", failed, len(items))
+	}
+	return nil
+}
+
+// exportBatchItem exports one image of a batch, reporting whether it was
+// skipped by --skip-if-unchanged
+func exportBatchItem(exporter lib.ImageExporter, imageRef, outputPath string, auth *lib.AuthConfig, opts *lib.ExportOptions, events *progressEvents, guard *exportGuard) (bool, error) {
+	var itemGuard *exportGuard
+	if guard != nil {
+		copied := *guard
+		itemGuard = &copied
+	}
+
+	skipped := false
+	err := withInteractiveAuth(exporter, imageRef, auth, func(auth *lib.AuthConfig) error {
+		exportRef := imageRef
+		if itemGuard != nil {
+			if err := itemGuard.resolve(exporter, imageRef, auth); err != nil {
+				return err
+			}
+			unchanged, err := itemGuard.unchanged(opts.Platform, outputPath)
+			if err != nil {
+				return err
+			}
+			if unchanged {
+				itemGuard.skipped(opts.Platform, outputPath, events)
+				skipped = true
+				return nil
+			}
+			exportRef = itemGuard.pinned
+		}
+		return exporter.ExportImageFilesystemWithOptions(exportRef, outputPath, auth, opts)
+	})
+	if err != nil || skipped || itemGuard == nil {
+		return skipped, err
+	}
+	return false, itemGuard.done(opts.Platform, outputPath)
+}
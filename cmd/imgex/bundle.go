@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// bundleExportCmd writes an image bundle for air-gapped transfer
+var bundleExportCmd = &cobra.Command{
+	Use:   "bundle-export <image-reference> <bundle.tar>",
+	Short: "Write an image's manifest, config and layers to a bundle for offline use",
+	Long: `Write everything needed to export an image later without registry access to a
+single tar: its manifest (or multi-arch index), config and compressed layers,
+exactly as the registry serves them. Carry the bundle to the offline machine and
+load it with 'imgex bundle-import'.
+
+The bundle is an OCI image layout, so 'tar -x' also turns it into a layout
+directory other OCI tools can read. For a multi-arch image every platform is
+included unless --platform selects some; the index is kept unchanged, so the
+tag still resolves to the same digest offline. The platforms bundled are recorded
+in index.json, and --platform-policy warns about or rejects a bundle none of
+whose platforms can run on this host. "-" writes the bundle to stdout.
+
+Examples:
+  imgex bundle-export registry.example.com/app:v1 app-v1.bundle.tar
+  imgex bundle-export --platform linux/arm64 alpine:3 alpine.bundle.tar`,
+	Args: cobra.ExactArgs(2),
+	RunE: runBundleExportCommand,
+}
+
+// bundleImportCmd loads a bundle into the blob cache
+var bundleImportCmd = &cobra.Command{
+	Use:   "bundle-import <bundle.tar>",
+	Short: "Load a bundle into the blob cache for --offline exports",
+	Long: `Load a bundle written by 'imgex bundle-export' into the blob cache: --cache-dir,
+or the work directory cache otherwise. Every blob is verified against its digest
+and the bundled tag is recorded, so that the image can then be exported with
+--offline exactly as if it had been pulled. "-" reads the bundle from stdin.
+
+Examples:
+  imgex bundle-import app-v1.bundle.tar
+  imgex --offline filesystem --output app.tar registry.example.com/app:v1`,
+	Args: cobra.ExactArgs(1),
+	RunE: runBundleImportCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(bundleExportCmd)
+	rootCmd.AddCommand(bundleImportCmd)
+	bundleExportCmd.Flags().StringArray("platform", nil,
+		"Only bundle this platform of a multi-arch image, e.g. linux/arm64 (repeatable)")
+	bundleExportCmd.Flags().String("platform-policy", "ignore",
+		"Action when no bundled platform can run on this host: ignore, warn or fail")
+}
+
+// runBundleExportCommand implements the logic for the 'bundle-export' subcommand.
+func runBundleExportCommand(cmd *cobra.Command, args []string) error {
+	platforms, _ := cmd.Flags().GetStringArray("platform")
+	policyFlag, _ := cmd.Flags().GetString("platform-policy")
+	platformPolicy, err := parsePlatformPolicy(policyFlag)
+	if err != nil {
+		return err
+	}
+	imageRef, bundlePath := args[0], args[1]
+
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	defer logCacheStats(exporter)
+
+	var w io.Writer = os.Stdout
+	var file *os.File
+	if bundlePath == "-" {
+		if err := requireStdout("bundle", "a file path"); err != nil {
+			return err
+		}
+	} else {
+		if file, err = os.Create(bundlePath); err != nil {
+			return fmt.Errorf("failed to create bundle: %w", err)
+		}
+		w = file
+	}
+	err = exporter.ExportBundle(imageRef, w, buildAuthConfig(), &lib.BundleOptions{
+		Platforms:      platforms,
+		PlatformPolicy: platformPolicy,
+	})
+	if file != nil {
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to write bundle: %w", closeErr)
+		}
+		if err != nil {
+			os.Remove(bundlePath)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		printLine(os.Stderr, "Bundle of %s written to %s", imageRef, bundlePath)
+	}
+	return nil
+}
+
+// runBundleImportCommand implements the logic for the 'bundle-import' subcommand.
+func runBundleImportCommand(cmd *cobra.Command, args []string) error {
+	// A bundle is only useful in a cache, so default to the work directory's
+	useCache = true
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to open bundle: %w", err)
+		}
+		defer file.Close()
+		r = file
+	}
+	refs, err := exporter.ImportBundle(r)
+	if err != nil {
+		return fmt.Errorf("failed to import %s: %w", args[0], err)
+	}
+	for _, ref := range refs {
+		printLine(os.Stderr, "Imported %s", ref)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// copyCmd copies an image between repositories or registries
+var copyCmd = &cobra.Command{
+	Use:   "copy <source> <destination>",
+	Short: "Copy an image from one registry or repository to another",
+	Long: `Copy an image to another repository, on the same or another registry, without a
+local daemon: blobs are streamed from the source registry to the destination and
+never stored on disk. Blobs the destination already has are skipped, and within
+one registry they are mounted from the source repository instead of uploaded.
+
+A multi-arch index is copied with every platform (and its attestations), keeping
+its digest. --platform copies a single platform instead, which the destination
+then holds as a plain image.
+
+Both registries are accessed with the same credentials: --registry scopes the
+flags to one of them, and the other uses the docker config or --auth-file.
+The digest written is printed on stdout.
+
+Examples:
+  imgex copy alpine:3 registry.example.com/mirror/alpine:3
+  imgex copy --platform linux/arm64 ghcr.io/org/app:v1 registry.example.com/app:v1-arm64
+  imgex --auth-file registries.yaml copy quay.io/org/app:v1 ecr.example.com/app:v1`,
+	Args: cobra.ExactArgs(2),
+	RunE: runCopyCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(copyCmd)
+	copyCmd.Flags().String("platform", "",
+		"Copy only this platform of a multi-arch image, e.g. linux/arm64 (default: every platform)")
+}
+
+// runCopyCommand implements the logic for the 'copy' subcommand.
+func runCopyCommand(cmd *cobra.Command, args []string) error {
+	platform, _ := cmd.Flags().GetString("platform")
+	// From here on, errors come from the registries rather than the command line
+	cmd.SilenceUsage = true
+
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	digest, err := exporter.CopyImage(args[0], args[1], buildAuthConfig(), &lib.CopyOptions{Platform: platform})
+	if err != nil {
+		return err
+	}
+	printLine(os.Stdout, "%s", digest)
+	printLine(os.Stderr, "Copied %s to %s", args[0], args[1])
+	return nil
+}
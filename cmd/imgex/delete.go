@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// deleteCmd handles the 'delete' subcommand for removing manifests and tags.
+// It executes deletion plans such as those printed by 'advise'.
+var deleteCmd = &cobra.Command{
+	Use:   "delete <image-reference>...",
+	Short: "Delete manifests or tags from a registry",
+	Long: `Delete manifests from a registry using the registry delete API.
+
+References must name a digest (repo@sha256:...), which deletes the manifest and
+every tag pointing at it. --tag also accepts tag references and deletes only
+the tag, on registries that support tag deletion.
+
+References can also be read with --from-file, one per line, or from the JSON
+plan written by 'advise --json'; "-" reads them from stdin. Every reference is
+resolved first, then a confirmation is asked before anything is deleted unless
+--yes is given. --dry-run only lists what would be deleted.
+
+Examples:
+  imgex delete registry.example.com/team/app@sha256:4c5f...
+  imgex delete --tag registry.example.com/team/app:pr-1234
+  imgex advise --keep-last 10 --json registry.example.com/team/app > plan.json
+  imgex delete --dry-run --from-file plan.json
+  imgex advise --keep-semver 3 registry.example.com/team/app | imgex delete --yes --from-file -`,
+	RunE: runDeleteCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(deleteCmd)
+	deleteCmd.Flags().Bool("tag", false,
+		"Allow tag references, deleting only the tag")
+	deleteCmd.Flags().Bool("dry-run", false,
+		"Resolve and list the references without deleting them")
+	deleteCmd.Flags().BoolP("yes", "y", false,
+		"Delete without asking for confirmation")
+	deleteCmd.Flags().String("from-file", "",
+		"Read references from a file (one per line, or an 'advise --json' plan); - for stdin")
+}
+
+// runDeleteCommand implements the logic for the 'delete' subcommand.
+// It resolves every reference, asks for confirmation and deletes them in order.
+func runDeleteCommand(cmd *cobra.Command, args []string) error {
+	allowTag, _ := cmd.Flags().GetBool("tag")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	yes, _ := cmd.Flags().GetBool("yes")
+	fromFile, _ := cmd.Flags().GetString("from-file")
+
+	refs := args
+	if fromFile != "" {
+		var r io.Reader = os.Stdin
+		if fromFile != "-" {
+			f, err := os.Open(fromFile)
+			if err != nil {
+				return fmt.Errorf("failed to open %s: %w", fromFile, err)
+			}
+			defer f.Close()
+			r = f
+		} else if !yes && !dryRun {
+			return fmt.Errorf("--from-file - reads stdin, so the confirmation prompt needs --yes")
+		}
+		planned, err := readDeleteReferences(r)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, planned...)
+	}
+	if len(refs) == 0 {
+		return fmt.Errorf("nothing to delete; give image references or --from-file")
+	}
+
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	auth := buildAuthConfig()
+
+	// Resolve everything before deleting anything, so typos fail the whole run
+	for _, ref := range refs {
+		digest, err := exporter.Delete(ref, auth, &lib.DeleteOptions{AllowTag: allowTag, DryRun: true})
+		if err != nil {
+			return err
+		}
+		printLine(os.Stderr, "%s (%s)", ref, digest)
+	}
+	if dryRun {
+		printLine(os.Stderr, "Dry run: %d references would be deleted", len(refs))
+		return nil
+	}
+	if !yes && jsonMode {
+		return fmt.Errorf("--json cannot ask for confirmation; pass --yes or --dry-run")
+	}
+	if !yes {
+		ok, err := confirm(os.Stdin, os.Stderr, fmt.Sprintf("Delete %d references?", len(refs)))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("aborted")
+		}
+	}
+
+	var failed int
+	for _, ref := range refs {
+		if _, err := exporter.Delete(ref, auth, &lib.DeleteOptions{AllowTag: allowTag}); err != nil {
+			printLine(os.Stderr, "Error: %v", err)
+			failed++
+			continue
+		}
+		printLine(os.Stdout, "Deleted %s", ref)
+	}
+	if failed > 0 {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("%d of %d deletions failed", failed, len(refs))
+	}
+	return nil
+}
+
+// readDeleteReferences reads image references one per line, skipping blank
+// lines and # comments, or the deletions of a JSON plan from 'advise --json'.
+func readDeleteReferences(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read references: %w", err)
+	}
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		var plan lib.RetentionPlan
+		if err := json.Unmarshal(data, &plan); err != nil {
+			return nil, fmt.Errorf("failed to parse retention plan: %w", err)
+		}
+		return plan.References(), nil
+	}
+
+	var refs []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			refs = append(refs, line)
+		}
+	}
+	return refs, nil
+}
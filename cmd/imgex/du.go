@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// duCmd breaks down the size of an image
+var duCmd = &cobra.Command{
+	Use:   "du <image-reference>",
+	Short: "Show the size of each layer and the largest directories and files of an image",
+	Long: `Show where the size of an image comes from:
+
+  layers        the compressed and uncompressed size of each layer, with the
+                instruction that produced it, and how much of it is wasted on
+                files a later layer replaces or deletes
+  directories   the largest directories of the flattened filesystem, counting
+                every file below them
+  files         the largest files of the flattened filesystem
+
+Examples:
+  imgex du node:20
+  imgex du --top 25 --json ghcr.io/org/app:v1 | jq '.layers[] | select(.wasted_size > 0)'`,
+	Args: schemaArgs(cobra.ExactArgs(1)),
+	RunE: runDuCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(duCmd)
+	duCmd.Flags().String("platform", "",
+		"Platform of a multi-arch image to measure, e.g. linux/arm64 (default linux/amd64)")
+	duCmd.Flags().Int("top", 10,
+		"Number of largest directories and files to show")
+	duCmd.Flags().Bool("schema", false,
+		"Print the JSON Schema of the --json output and exit")
+}
+
+// runDuCommand implements the logic for the 'du' subcommand.
+func runDuCommand(cmd *cobra.Command, args []string) error {
+	if printed, err := printSchema(cmd, "disk-usage"); printed || err != nil {
+		return err
+	}
+	imageRef := args[0]
+	opts := &lib.DiskUsageOptions{}
+	opts.Platform, _ = cmd.Flags().GetString("platform")
+	opts.Top, _ = cmd.Flags().GetInt("top")
+	if opts.Top <= 0 {
+		return fmt.Errorf("--top must be positive, got %d", opts.Top)
+	}
+	cmd.SilenceUsage = true
+
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	var report *lib.DiskUsageReport
+	err = withInteractiveAuth(exporter, imageRef, buildAuthConfig(), func(auth *lib.AuthConfig) (err error) {
+		report, err = exporter.DiskUsage(imageRef, auth, opts)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to measure %s: %w", imageRef, err)
+	}
+	if jsonMode {
+		return printDocument(report)
+	}
+
+	terminal := newTerminal(os.Stdout)
+	layers := &table{header: []string{"LAYER", "DIGEST", "SIZE", "UNCOMPRESSED", "WASTED", "CREATED BY"}}
+	for _, layer := range report.Layers {
+		wasted := cell{text: "-"}
+		if layer.WastedSize > 0 {
+			wasted = cell{text: formatBytes(layer.WastedSize), style: styleYellow}
+		}
+		layers.add(cell{text: strconv.Itoa(layer.Index)}, cell{text: shortDigest(layer.Digest)},
+			cell{text: formatBytes(layer.Size)}, cell{text: formatBytes(layer.UncompressedSize)}, wasted,
+			cell{text: layer.CreatedBy})
+	}
+	layers.render(terminal)
+
+	for _, section := range []struct {
+		title string
+		paths []lib.PathUsage
+	}{{"DIRECTORY", report.Directories}, {"FILE", report.Files}} {
+		if len(section.paths) == 0 {
+			continue
+		}
+		printLine(os.Stdout, "")
+		paths := &table{header: []string{"SIZE", section.title}}
+		for _, usage := range section.paths {
+			paths.add(cell{text: formatBytes(usage.Size)}, cell{text: usage.Path})
+		}
+		paths.render(terminal)
+	}
+
+	printLine(os.Stderr, "%s compressed, %s uncompressed, %s of files in %s",
+		formatBytes(report.CompressedSize), formatBytes(report.UncompressedSize), formatBytes(report.FilesystemSize), imageRef)
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// runExportEstimate implements 'filesystem --dry-run': it prints what the
+// export of imageRef would download and write instead of exporting it.
+func runExportEstimate(cmd *cobra.Command, imageRef string, auth *lib.AuthConfig, opts *lib.ExportOptions) error {
+	cmd.SilenceUsage = true
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	var estimate *lib.ExportEstimate
+	err = withInteractiveAuth(exporter, imageRef, auth, func(auth *lib.AuthConfig) (err error) {
+		estimate, err = exporter.EstimateExport(imageRef, auth, opts)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to estimate the export of %s: %w", imageRef, err)
+	}
+	if jsonMode {
+		return printDocument(estimate)
+	}
+
+	table := &table{header: []string{"LAYER", "DIGEST", "SIZE", "UNCOMPRESSED", "DOWNLOAD"}}
+	for _, layer := range estimate.Layers {
+		download := cell{text: formatBytes(layer.Size)}
+		switch {
+		case layer.Skipped:
+			download = cell{text: "skipped (foreign)", style: styleYellow}
+		case layer.Cached:
+			download = cell{text: "cached", style: styleGreen}
+		}
+		uncompressed := formatBytes(layer.UncompressedSize)
+		if layer.UncompressedSize != layer.Size && !layer.Skipped {
+			uncompressed = "~" + uncompressed
+		}
+		table.add(cell{text: strconv.Itoa(layer.Index)}, cell{text: shortDigest(layer.Digest)},
+			cell{text: formatBytes(layer.Size)}, cell{text: uncompressed}, download)
+	}
+	table.render(newTerminal(os.Stdout))
+
+	approximate := "~"
+	if estimate.Exact && !opts.Compress {
+		approximate = "at most "
+	}
+	printLine(os.Stdout, "Download: %s of %s (%s cached)", formatBytes(estimate.DownloadSize),
+		formatBytes(estimate.CompressedSize), formatBytes(estimate.CachedSize))
+	printLine(os.Stdout, "Output:   %s%s", approximate, formatBytes(estimate.OutputSize))
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// extractCmd handles the 'extract' subcommand for reading single files of an image.
+var extractCmd = &cobra.Command{
+	Use:   "extract <image-reference> <path>",
+	Short: "Extract a single file from the image filesystem",
+	Long: `Extract one file from the flattened filesystem of an image, as a running
+container would see it: later layers and whiteouts are applied, and symlinks
+along the path are followed. The file is written to stdout, or to --output with
+the permissions it has in the image.
+
+The --decompress flag transparently decompresses gzip, bzip2, xz and zstd files
+(detected from their content, e.g. man pages or kernel configs) before writing;
+other files are written unchanged. --platform-policy warns about or rejects
+images whose os/architecture cannot run on this host, as for 'imgex filesystem'.
+
+Examples:
+  imgex extract alpine:latest /etc/os-release
+  imgex extract docker-archive:app.tar /etc/os-release
+  imgex extract --decompress ubuntu:24.04 /usr/share/man/man1/ls.1.gz | man -l -
+  imgex extract --decompress --output ls.1 ubuntu:24.04 /usr/share/man/man1/ls.1.gz
+  imgex extract --platform linux/arm64 --output busybox app:v1 /bin/busybox`,
+	Args: cobra.ExactArgs(2),
+	RunE: runExtractCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(extractCmd)
+	extractCmd.Flags().StringP("output", "o", "",
+		"Output file path (default: stdout)")
+	extractCmd.Flags().Bool("decompress", false,
+		"Decompress gzip, bzip2, xz and zstd files before writing")
+	extractCmd.Flags().String("platform", "",
+		"Platform to extract from a multi-arch image, e.g. linux/arm64")
+	extractCmd.Flags().String("platform-policy", "ignore",
+		"Action when the image os/arch cannot run on this host: ignore, warn or fail")
+}
+
+// runExtractCommand implements the logic for the 'extract' subcommand.
+func runExtractCommand(cmd *cobra.Command, args []string) error {
+	imageRef, filePath := args[0], args[1]
+	outputPath, _ := cmd.Flags().GetString("output")
+	decompress, _ := cmd.Flags().GetBool("decompress")
+	platform, _ := cmd.Flags().GetString("platform")
+	policyFlag, _ := cmd.Flags().GetString("platform-policy")
+	platformPolicy, err := parsePlatformPolicy(policyFlag)
+	if err != nil {
+		return err
+	}
+	if outputPath == "" {
+		if err := requireStdout("file", "--output"); err != nil {
+			return err
+		}
+	}
+
+	auth := buildAuthConfig()
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	defer logCacheStats(exporter)
+
+	var content io.ReadCloser
+	var header *tar.Header
+	opts := &lib.FileOptions{Platform: platform, PlatformPolicy: platformPolicy, Decompress: decompress}
+	err = withInteractiveAuth(exporter, imageRef, auth, func(auth *lib.AuthConfig) (err error) {
+		content, header, err = exporter.OpenFile(imageRef, filePath, auth, opts)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", filePath, err)
+	}
+	defer content.Close()
+
+	if outputPath == "" {
+		if _, err := io.Copy(os.Stdout, content); err != nil {
+			return fmt.Errorf("failed to write %s: %w", filePath, err)
+		}
+		return nil
+	}
+	mode := fs.FileMode(header.Mode).Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	file, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", outputPath, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", outputPath, err)
+	}
+	printLine(os.Stderr, "%s extracted to %s", header.Name, outputPath)
+	return nil
+}
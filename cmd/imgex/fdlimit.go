@@ -0,0 +1,22 @@
+package main
+
+// Open files budgeted for parallel exports. Each export holds its output file,
+// a staging file, the layer blob being read, cache files being written and its
+// registry connections; the rest of the process keeps a few more open.
+const (
+	filesPerExport = 16
+	reservedFiles  = 32
+)
+
+// batchWorkers returns how many of parallel exports fit in maxOpenFiles open
+// file descriptors, at least one. A maxOpenFiles of zero or less means the soft
+// RLIMIT_NOFILE of the process, and no bound when it cannot be read.
+func batchWorkers(parallel, maxOpenFiles int) int {
+	if maxOpenFiles <= 0 {
+		maxOpenFiles = openFileLimit()
+	}
+	if maxOpenFiles <= 0 {
+		return parallel
+	}
+	return max(1, min(parallel, (maxOpenFiles-reservedFiles)/filesPerExport))
+}
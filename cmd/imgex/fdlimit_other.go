@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// openFileLimit returns 0: there is no open file limit to read on this platform
+func openFileLimit() int {
+	return 0
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultServer is the address 'imgex serve' listens on by default
+const defaultServer = "http://127.0.0.1:8080"
+
+// jobInfo describes a filesystem export running in 'imgex serve', as listed by
+// GET /v1/jobs
+type jobInfo struct {
+	ID         string    `json:"id"`
+	Image      string    `json:"image"`
+	Platform   string    `json:"platform,omitempty"`
+	Client     string    `json:"client"`
+	Started    time.Time `json:"started"`
+	Downloaded int64     `json:"downloaded"`
+	Written    int64     `json:"written"`
+	Canceled   bool      `json:"canceled,omitempty"`
+}
+
+// jobsCmd groups the subcommands managing the exports of a running server
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "List and cancel exports running in 'imgex serve'",
+	Long: `List and cancel the filesystem exports running in an 'imgex serve' instance,
+e.g. to abort a runaway export that is using up bandwidth.
+
+The server is --server, IMGEX_SERVER or http://127.0.0.1:8080. Canceling a job
+stops its downloads within a chunk; its client receives an error, or a truncated
+stream if the archive had started.
+
+Examples:
+  imgex jobs list
+  imgex jobs cancel 3f9a1c2e
+  imgex jobs --server http://exporter.internal:8080 list --json`,
+}
+
+// jobsListCmd prints the running jobs
+var jobsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List running exports",
+	Args:  cobra.NoArgs,
+	RunE:  runJobsListCommand,
+}
+
+// jobsCancelCmd cancels running jobs
+var jobsCancelCmd = &cobra.Command{
+	Use:   "cancel <id>...",
+	Short: "Cancel running exports",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runJobsCancelCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(jobsCmd)
+	jobsCmd.AddCommand(jobsListCmd)
+	jobsCmd.AddCommand(jobsCancelCmd)
+	jobsCmd.PersistentFlags().String("server", "",
+		"URL of the imgex server (env: IMGEX_SERVER, default "+defaultServer+")")
+}
+
+// runJobsListCommand implements the logic for the 'jobs list' subcommand.
+func runJobsListCommand(cmd *cobra.Command, args []string) error {
+	var jobs []jobInfo
+	if err := callServer(cmd, http.MethodGet, "/v1/jobs", &jobs); err != nil {
+		return err
+	}
+
+	if jsonMode {
+		return printDocument(jobs)
+	}
+
+	if len(jobs) == 0 {
+		fmt.Fprintln(os.Stderr, "No running jobs")
+		return nil
+	}
+	tb := &table{header: []string{"ID", "ELAPSED", "DOWNLOADED", "WRITTEN", "CLIENT", "IMAGE"}}
+	for _, job := range jobs {
+		image := job.Image
+		if job.Platform != "" {
+			image += " (" + job.Platform + ")"
+		}
+		tb.add(
+			cell{text: job.ID, style: styleBold},
+			cell{text: time.Since(job.Started).Round(time.Second).String()},
+			cell{text: formatBytes(job.Downloaded)},
+			cell{text: formatBytes(job.Written)},
+			cell{text: job.Client},
+			cell{text: image},
+		)
+	}
+	tb.render(newTerminal(os.Stdout))
+	return nil
+}
+
+// runJobsCancelCommand implements the logic for the 'jobs cancel' subcommand.
+func runJobsCancelCommand(cmd *cobra.Command, args []string) error {
+	stderr := newTerminal(os.Stderr)
+	failed := 0
+	for _, id := range args {
+		var job jobInfo
+		if err := callServer(cmd, http.MethodDelete, "/v1/jobs/"+url.PathEscape(id), &job); err != nil {
+			printLine(os.Stderr, "%s %s: %v", stderr.paint(styleRed, "FAILED"), id, err)
+			failed++
+			continue
+		}
+		printLine(os.Stderr, "Canceled job %s (%s, %s downloaded)", job.ID, job.Image, formatBytes(job.Downloaded))
+	}
+	if failed > 0 {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("%d of %d jobs could not be canceled", failed, len(args))
+	}
+	return nil
+}
+
+// callServer sends a request to the imgex server and decodes its JSON response
+// into v, turning {"error": ...} responses into errors
+func callServer(cmd *cobra.Command, method, path string, v any) error {
+	server, _ := cmd.Flags().GetString("server")
+	if server == "" {
+		server = os.Getenv("IMGEX_SERVER")
+	}
+	if server == "" {
+		server = defaultServer
+	}
+	if !strings.Contains(server, "://") {
+		server = "http://" + server
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(server, "/")+path, nil)
+	if err != nil {
+		return fmt.Errorf("invalid server %q: %w", server, err)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach the imgex server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read the server response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s", apiErr.Error)
+		}
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to parse the server response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// lockCmd writes a lockfile pinning image references to their digests
+var lockCmd = &cobra.Command{
+	Use:   "lock [image-reference]...",
+	Short: "Pin image references to their digests in a lockfile",
+	Long: `Resolve image references to the digests they currently point to and write
+them to a lockfile, so that builds can pin exactly the same images later.
+
+Each image is recorded with its repository, digest, media type and compressed
+size; for a multi-arch index every platform is listed with its own digest and
+size. Only manifests and image configs are fetched.
+
+References are given as arguments or read with --input, one per line (blank
+lines and # comments are skipped; "-" reads stdin). The lockfile is written to
+--output, or stdout, as JSON, or as YAML with --format yaml or an output file
+ending in .yaml or .yml.
+
+Examples:
+  imgex lock alpine:3 ghcr.io/org/app:v1 > images.lock.json
+  imgex lock --input images.txt --output images.lock.yaml
+  imgex lock --schema`,
+	Args: schemaArgs(cobra.ArbitraryArgs),
+	RunE: runLockCommand,
+}
+
+// verifyLockCmd checks that a lockfile still matches its registries
+var verifyLockCmd = &cobra.Command{
+	Use:   "verify-lock <lockfile>",
+	Short: "Check that the images of a lockfile are still pinned",
+	Long: `Check every image of a lockfile written by 'imgex lock': its locked digest must
+still exist in the repository, and its reference must still resolve to it.
+
+Each image is reported as OK, MOVED (the reference now points to another digest),
+MISSING (the locked digest was deleted) or ERROR (e.g. the tag is gone or access
+was refused). The command exits with an error unless every image is OK, so CI
+can notice when a lockfile needs refreshing. Only manifest HEAD requests are made
+where registries support them.
+
+Examples:
+  imgex verify-lock images.lock.yaml
+  imgex verify-lock --json images.lock.json`,
+	Args: schemaArgs(cobra.ExactArgs(1)),
+	RunE: runVerifyLockCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(lockCmd)
+	rootCmd.AddCommand(verifyLockCmd)
+	lockCmd.Flags().StringP("output", "o", "",
+		"Lockfile path (default: stdout)")
+	lockCmd.Flags().String("input", "",
+		"Read image references from a file, one per line; - for stdin")
+	lockCmd.Flags().String("format", "",
+		"Lockfile format: json or yaml (default: from the --output extension, else json)")
+	lockCmd.Flags().Bool("schema", false,
+		"Print the JSON Schema of the lockfile and exit")
+	verifyLockCmd.Flags().Bool("schema", false,
+		"Print the JSON Schema of the --json results and exit")
+}
+
+// runLockCommand implements the logic for the 'lock' subcommand.
+func runLockCommand(cmd *cobra.Command, args []string) error {
+	if printed, err := printSchema(cmd, "lockfile"); printed || err != nil {
+		return err
+	}
+	outputPath, _ := cmd.Flags().GetString("output")
+	inputPath, _ := cmd.Flags().GetString("input")
+	format, _ := cmd.Flags().GetString("format")
+
+	if format == "" {
+		format = "json"
+		if ext := strings.ToLower(filepath.Ext(outputPath)); ext == ".yaml" || ext == ".yml" {
+			format = "yaml"
+		}
+	}
+	if format != "json" && format != "yaml" {
+		return fmt.Errorf("invalid --format %q (expected json or yaml)", format)
+	}
+	if outputPath == "" && format == "yaml" {
+		if err := requireStdout("YAML lockfile", "--output"); err != nil {
+			return err
+		}
+	}
+
+	refs := args
+	if inputPath != "" {
+		listed, err := readLockInput(inputPath)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, listed...)
+	}
+	if len(refs) == 0 {
+		return fmt.Errorf("nothing to lock; give image references or --input")
+	}
+
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	lock, err := exporter.Lock(refs, buildAuthConfig())
+	if err != nil {
+		return err
+	}
+
+	if outputPath == "" && jsonMode {
+		return printDocument(lock)
+	}
+
+	var data []byte
+	if format == "yaml" {
+		data, err = lock.EncodeYAML()
+	} else {
+		data, err = json.MarshalIndent(lock, "", "  ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode lockfile: %w", err)
+	}
+
+	if outputPath == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(outputPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write lockfile: %w", err)
+	}
+	printLine(os.Stderr, "Locked %d images to %s", len(lock.Images), outputPath)
+	return nil
+}
+
+// runVerifyLockCommand implements the logic for the 'verify-lock' subcommand.
+// It prints a result per image and fails unless every image is still pinned.
+func runVerifyLockCommand(cmd *cobra.Command, args []string) error {
+	if printed, err := printSchema(cmd, "lock-report"); printed || err != nil {
+		return err
+	}
+	lock, err := lib.LoadLockfile(args[0])
+	if err != nil {
+		return err
+	}
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	verification, err := exporter.VerifyLock(lock, buildAuthConfig())
+	if err != nil {
+		return fmt.Errorf("failed to verify lockfile: %w", err)
+	}
+
+	drifted := 0
+	for _, result := range verification.Results {
+		if result.Status != lib.LockOK {
+			drifted++
+		}
+	}
+	if jsonMode {
+		printDocument(verification)
+	} else {
+		results := &table{header: []string{"STATUS", "REFERENCE", "DETAIL"}}
+		for _, result := range verification.Results {
+			style, detail := styleGreen, shortDigest(result.Locked)
+			switch result.Status {
+			case lib.LockMoved:
+				style, detail = styleYellow, shortDigest(result.Locked)+" -> "+shortDigest(result.Current)
+			case lib.LockMissing, lib.LockError:
+				style, detail = styleRed, result.Error
+			}
+			results.add(
+				cell{text: strings.ToUpper(string(result.Status)), style: style},
+				cell{text: result.Reference},
+				cell{text: detail},
+			)
+		}
+		results.render(newTerminal(os.Stdout))
+		summary := fmt.Sprintf("Checked %d images, %d drifted", len(verification.Results), drifted)
+		style := styleGreen
+		if drifted > 0 {
+			style = styleRed
+		}
+		fmt.Fprintln(os.Stderr, newTerminal(os.Stderr).paint(style, summary))
+	}
+
+	if drifted > 0 {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("%d of %d locked images drifted from %s", drifted, len(verification.Results), args[0])
+	}
+	return nil
+}
+
+// shortDigest abbreviates a digest for tables, e.g. "sha256:4c5f1e2a9b3d"
+func shortDigest(digest string) string {
+	algorithm, hex, found := strings.Cut(digest, ":")
+	if !found || len(hex) <= 12 {
+		return digest
+	}
+	return algorithm + ":" + hex[:12]
+}
+
+// readLockInput reads image references one per line from a file ("-" for
+// stdin), skipping blank lines and # comments
+func readLockInput(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open input file: %w", err)
+		}
+		defer file.Close()
+		r = file
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input file: %w", err)
+	}
+
+	var refs []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			refs = append(refs, line)
+		}
+	}
+	return refs, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/logs"
+	"github.com/kenichi/imgex/lib"
+)
+
+// levelNone disables logging; it is above every level slog emits
+const levelNone = slog.Level(100)
+
+// logger receives structured diagnostics for --log-level. It discards everything
+// until setupLogging runs, so commands can log unconditionally.
+var logger = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: levelNone}))
+
+// parseLogLevel converts a --log-level value
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	case "", "none", "off":
+		return levelNone, nil
+	default:
+		return 0, fmt.Errorf("invalid --log-level %q: use debug, info, warn, error or none", level)
+	}
+}
+
+// setupLogging configures logger from --log-level (or IMGEX_LOG_LEVEL) and
+// --log-format, writing to w. Retries reported by the registry client are
+// forwarded as warnings.
+func setupLogging(w io.Writer) error {
+	if logLevel == "" {
+		logLevel = os.Getenv("IMGEX_LOG_LEVEL")
+	}
+	level, err := parseLogLevel(logLevel)
+	if err != nil {
+		return err
+	}
+	if level == levelNone {
+		return nil
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	switch logFormat {
+	case "text":
+		logger = slog.New(slog.NewTextHandler(w, options))
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(w, options))
+	default:
+		return fmt.Errorf("invalid --log-format %q: use text or json", logFormat)
+	}
+
+	// The registry client logs retries through its own package-level logger
+	logs.Warn = log.New(&slogWriter{level: slog.LevelWarn, msg: "registry client"}, "", 0)
+	return nil
+}
+
+// slogWriter forwards lines written by a standard library logger to logger
+type slogWriter struct {
+	level slog.Level
+	msg   string
+}
+
+// Write implements io.Writer
+func (w *slogWriter) Write(p []byte) (int, error) {
+	logger.Log(context.Background(), w.level, w.msg, "detail", string(bytes.TrimSpace(p)))
+	return len(p), nil
+}
+
+// logTransport logs every registry request: successful ones at debug level,
+// server errors and throttling (which the client retries) as warnings. Failed
+// pings are expected while the client probes for HTTPS and stay at debug level.
+type logTransport struct {
+	next http.RoundTripper
+}
+
+// wrapLogTransport implements lib.TransportWrapper
+func wrapLogTransport(next http.RoundTripper) http.RoundTripper {
+	return &logTransport{next: next}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *logTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	// Query strings can carry signed blob URLs, so only the path is logged
+	attrs := []any{"method", req.Method, "host", req.URL.Host, "path", req.URL.Path,
+		"duration", time.Since(start).Round(time.Millisecond)}
+	switch {
+	case err != nil && req.URL.Path == "/v2/":
+		logger.Debug("registry ping failed", append(attrs, "error", err)...)
+	case err != nil:
+		logger.Warn("registry request failed", append(attrs, "error", err)...)
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		logger.Warn("registry request failed", append(attrs, "status", resp.StatusCode)...)
+	default:
+		logger.Debug("registry request", append(attrs, "status", resp.StatusCode)...)
+	}
+	return resp, err
+}
+
+// logCacheStats reports blob cache activity for a finished command
+func logCacheStats(exporter lib.ImageExporter) {
+	stats := exporter.CacheStats()
+	if stats.Hits+stats.Misses == 0 {
+		return
+	}
+	logger.Info("blob cache", "hits", stats.Hits, "misses", stats.Misses,
+		"bytes_reused", stats.BytesReused, "bytes_fetched", stats.BytesFetched)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// loginCmd handles the 'login' subcommand for storing registry credentials.
+var loginCmd = &cobra.Command{
+	Use:   "login [registry]",
+	Short: "Validate and store credentials for a registry",
+	Long: `Validate credentials against a registry and store them for later commands.
+
+Credentials come from --username with --password, --password-stdin or --token,
+or the IMGEX_* environment variables. They are checked against the registry
+and saved in the docker config.json (--docker-config, DOCKER_CONFIG or
+~/.docker), using its credential store or helpers like 'docker login', or with
+the helper given by --cred-helper. The registry defaults to Docker Hub.
+
+When config.json names no credential store, the secret is kept in the OS
+keyring (macOS Keychain, Windows Credential Manager or the Secret Service)
+through its docker credential helper, if installed, instead of in the file.
+--keyring always fails rather than writing the file; --keyring never skips the keyring.
+
+Examples:
+  imgex login --username user ghcr.io --password-stdin < token.txt
+  imgex login --keyring always --username ci --password-stdin registry.example.com < token.txt
+  imgex --docker-config ./ci-docker login --username ci --password "$PASS" registry.example.com`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runLoginCommand,
+}
+
+// logoutCmd handles the 'logout' subcommand for removing stored credentials.
+var logoutCmd = &cobra.Command{
+	Use:   "logout [registry]",
+	Short: "Remove stored credentials for a registry",
+	Long: `Remove the credentials stored for a registry by 'imgex login' or 'docker login'.
+The registry defaults to Docker Hub.
+
+Examples:
+  imgex logout ghcr.io`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runLogoutCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(loginCmd)
+	rootCmd.AddCommand(logoutCmd)
+	loginCmd.Flags().String("keyring", "auto",
+		"Store credentials in the OS keyring: auto (when its credential helper is installed), always or never")
+}
+
+// runLoginCommand implements the logic for the 'login' subcommand.
+func runLoginCommand(cmd *cobra.Command, args []string) error {
+	var target string
+	if len(args) > 0 {
+		target = args[0]
+	}
+
+	auth := buildAuthConfig()
+	if auth == nil {
+		return fmt.Errorf("login requires --username with --password, --password-stdin or --token")
+	}
+	if target == "" {
+		target = auth.Registry
+	}
+
+	keyringFlag, _ := cmd.Flags().GetString("keyring")
+	keyring, err := lib.ParseKeyringMode(keyringFlag)
+	if err != nil {
+		return err
+	}
+
+	exporter, err := newExporter(lib.WithKeyring(keyring))
+	if err != nil {
+		return err
+	}
+	if err := exporter.Login(target, auth); err != nil {
+		return err
+	}
+	printLine(os.Stderr, "Login Succeeded")
+	return nil
+}
+
+// runLogoutCommand implements the logic for the 'logout' subcommand.
+func runLogoutCommand(cmd *cobra.Command, args []string) error {
+	var target string
+	if len(args) > 0 {
+		target = args[0]
+	}
+
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	if err := exporter.Logout(target); err != nil {
+		return err
+	}
+	if target == "" {
+		target = "Docker Hub"
+	}
+	printLine(os.Stderr, "Removed credentials for %s", target)
+	return nil
+}
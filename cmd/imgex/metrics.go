@@ -0,0 +1,273 @@
+//go:build !noserver
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kenichi/imgex/lib"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histograms;
+// filesystem exports of large images take minutes
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// histogram counts observations in latencyBuckets
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// observe records one value in seconds
+func (h *histogram) observe(seconds float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// serverMetrics collects the metrics of 'imgex serve' and writes them in the
+// Prometheus text exposition format
+type serverMetrics struct {
+	exporter lib.ImageExporter // for blob cache statistics, read at scrape time
+
+	mu               sync.Mutex
+	inFlight         int
+	requests         map[[2]string]uint64 // by handler and status code
+	durations        map[string]*histogram
+	responseBytes    map[string]uint64
+	exports          map[[2]string]uint64 // by kind and result
+	registryErrors   map[string]uint64    // by reason
+	registryDuration histogram
+}
+
+// newServerMetrics creates empty metrics
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		requests:       make(map[[2]string]uint64),
+		durations:      make(map[string]*histogram),
+		responseBytes:  make(map[string]uint64),
+		exports:        make(map[[2]string]uint64),
+		registryErrors: make(map[string]uint64),
+	}
+}
+
+// instrument counts the requests, status codes, latency and response bytes of
+// a handler under a fixed name, keeping label values bounded
+func (m *serverMetrics) instrument(handler string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		m.inFlight++
+		m.mu.Unlock()
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.inFlight--
+			m.requests[[2]string{handler, strconv.Itoa(recorder.status)}]++
+			if m.durations[handler] == nil {
+				m.durations[handler] = &histogram{}
+			}
+			m.durations[handler].observe(time.Since(start).Seconds())
+			m.responseBytes[handler] += uint64(recorder.bytes)
+		}()
+		next(recorder, r)
+	}
+}
+
+// export counts a finished config or filesystem export
+func (m *serverMetrics) export(kind string, err error) {
+	result := "success"
+	switch {
+	case lib.IsCanceled(err):
+		result = "canceled"
+	case err != nil:
+		result = "error"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.exports[[2]string{kind, result}]++
+}
+
+// wrapTransport implements lib.TransportWrapper, timing registry requests and
+// counting failures. Failed pings and the 401 challenge of /v2/ are part of
+// every authentication and are not errors.
+func (m *serverMetrics) wrapTransport(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		start := time.Now()
+		resp, err := next.RoundTrip(req)
+
+		reason := ""
+		ping := req.URL.Path == "/v2/"
+		switch {
+		case err != nil && !ping:
+			reason = "network"
+		case err != nil:
+		case resp.StatusCode == http.StatusUnauthorized && ping:
+		case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+			reason = "unauthorized"
+		case resp.StatusCode == http.StatusNotFound:
+			reason = "not_found"
+		case resp.StatusCode == http.StatusTooManyRequests:
+			reason = "rate_limited"
+		case resp.StatusCode >= 500:
+			reason = "server_error"
+		case resp.StatusCode >= 400:
+			reason = "client_error"
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.registryDuration.observe(time.Since(start).Seconds())
+		if reason != "" {
+			m.registryErrors[reason]++
+		}
+		return resp, err
+	})
+}
+
+// roundTripperFunc adapts a function to http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format
+func (m *serverMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.writeTo(w)
+}
+
+// writeTo writes every metric, sorted by labels for stable output
+func (m *serverMetrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	info := lib.GetBuildInfo()
+	writeMetricHeader(w, "imgex_build_info", "gauge", "Version of the running imgex, always 1")
+	fmt.Fprintf(w, "imgex_build_info%s 1\n", metricLabels("version", info.Version, "go_version", runtime.Version()))
+
+	writeMetricHeader(w, "imgex_http_requests_in_flight", "gauge", "HTTP requests being served")
+	fmt.Fprintf(w, "imgex_http_requests_in_flight %d\n", m.inFlight)
+
+	writeMetricHeader(w, "imgex_http_requests_total", "counter", "HTTP requests served, by handler and status code")
+	for _, key := range sortedKeys(m.requests) {
+		fmt.Fprintf(w, "imgex_http_requests_total%s %d\n", metricLabels("handler", key[0], "code", key[1]), m.requests[key])
+	}
+
+	writeMetricHeader(w, "imgex_http_request_duration_seconds", "histogram", "Time to serve HTTP requests, by handler")
+	handlers := make([]string, 0, len(m.durations))
+	for handler := range m.durations {
+		handlers = append(handlers, handler)
+	}
+	sort.Strings(handlers)
+	for _, handler := range handlers {
+		writeHistogram(w, "imgex_http_request_duration_seconds", m.durations[handler], "handler", handler)
+	}
+
+	writeMetricHeader(w, "imgex_http_response_bytes_total", "counter", "Bytes of HTTP response bodies served, by handler")
+	for _, handler := range sortedKeys(m.responseBytes) {
+		fmt.Fprintf(w, "imgex_http_response_bytes_total%s %d\n", metricLabels("handler", handler), m.responseBytes[handler])
+	}
+
+	writeMetricHeader(w, "imgex_exports_total", "counter", "Config and filesystem exports, by kind and result (success, error or canceled)")
+	for _, key := range sortedKeys(m.exports) {
+		fmt.Fprintf(w, "imgex_exports_total%s %d\n", metricLabels("kind", key[0], "result", key[1]), m.exports[key])
+	}
+
+	writeMetricHeader(w, "imgex_registry_request_duration_seconds", "histogram", "Time of registry requests")
+	writeHistogram(w, "imgex_registry_request_duration_seconds", &m.registryDuration)
+
+	writeMetricHeader(w, "imgex_registry_errors_total", "counter", "Failed registry requests, by reason")
+	for _, reason := range sortedKeys(m.registryErrors) {
+		fmt.Fprintf(w, "imgex_registry_errors_total%s %d\n", metricLabels("reason", reason), m.registryErrors[reason])
+	}
+
+	stats := m.exporter.CacheStats()
+	ratio := 0.0
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		ratio = float64(stats.Hits) / float64(lookups)
+	}
+	for _, metric := range []struct {
+		name, kind, help string
+		value            string
+	}{
+		{"imgex_blob_cache_hits_total", "counter", "Blobs served from the blob cache", strconv.Itoa(stats.Hits)},
+		{"imgex_blob_cache_misses_total", "counter", "Blobs downloaded into the blob cache", strconv.Itoa(stats.Misses)},
+		{"imgex_blob_cache_reused_bytes_total", "counter", "Compressed bytes served from the blob cache", strconv.FormatInt(stats.BytesReused, 10)},
+		{"imgex_blob_cache_fetched_bytes_total", "counter", "Compressed bytes downloaded into the blob cache", strconv.FormatInt(stats.BytesFetched, 10)},
+		{"imgex_blob_cache_hit_ratio", "gauge", "Fraction of blob lookups served from the cache, 0 before the first", formatMetricValue(ratio)},
+	} {
+		writeMetricHeader(w, metric.name, metric.kind, metric.help)
+		fmt.Fprintf(w, "%s %s\n", metric.name, metric.value)
+	}
+}
+
+// writeMetricHeader writes the HELP and TYPE lines of a metric
+func writeMetricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// writeHistogram writes the cumulative buckets, sum and count of a histogram
+func writeHistogram(w io.Writer, name string, h *histogram, labels ...string) {
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		if h.counts != nil {
+			cumulative += h.counts[i]
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, metricLabels(append(labels, "le", formatMetricValue(bound))...), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, metricLabels(append(labels, "le", "+Inf")...), h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, metricLabels(labels...), formatMetricValue(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, metricLabels(labels...), h.count)
+}
+
+// metricLabels formats name/value pairs as a label set, empty without labels
+func metricLabels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	labels := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, pairs[i]+`="`+escaper.Replace(pairs[i+1])+`"`)
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// formatMetricValue formats a float sample value
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of a metric map in order
+func sortedKeys[K string | [2]string, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+	return keys
+}
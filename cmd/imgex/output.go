@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// ANSI styles used for human-readable output
+const (
+	styleRed    = "31"
+	styleYellow = "33"
+	styleGreen  = "32"
+	styleBold   = "1"
+)
+
+// terminal renders human-readable output for one stream, adding color and
+// fitting tables to the window only when the stream is an interactive terminal.
+// Machine formats (JSON, reference lists) are written directly and never styled.
+type terminal struct {
+	out   io.Writer
+	color bool
+	width int // 0 for unlimited
+}
+
+// newTerminal inspects f and the --color flag, NO_COLOR, FORCE_COLOR and COLUMNS
+func newTerminal(f *os.File) *terminal {
+	return &terminal{out: f, color: colorEnabled(f), width: terminalWidth(f)}
+}
+
+// validateColorMode checks the --color flag
+func validateColorMode(mode string) error {
+	switch mode {
+	case "auto", "always", "never":
+		return nil
+	default:
+		return fmt.Errorf("invalid --color %q: use auto, always or never", mode)
+	}
+}
+
+// colorEnabled decides whether to style output for f. --color always/never win,
+// then NO_COLOR (https://no-color.org) and FORCE_COLOR, then whether f is a terminal.
+func colorEnabled(f *os.File) bool {
+	if jsonMode {
+		return false
+	}
+	switch colorMode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	if force := os.Getenv("FORCE_COLOR"); force != "" && force != "0" {
+		return true
+	}
+	return isTerminal(f) && os.Getenv("TERM") != "dumb"
+}
+
+// isTerminal reports whether f is a character device such as a TTY
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// terminalWidth returns the column count for f: the size of the terminal, else
+// COLUMNS when set, 80 for other terminals, and 0 (unlimited) when output is
+// redirected
+func terminalWidth(f *os.File) int {
+	if !isTerminal(f) {
+		return 0
+	}
+	if columns, _, err := term.GetSize(int(f.Fd())); err == nil && columns > 0 {
+		return columns
+	}
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	return 80
+}
+
+// paint wraps s in an ANSI style when color is enabled
+func (t *terminal) paint(style, s string) string {
+	if !t.color || style == "" || s == "" {
+		return s
+	}
+	return "\x1b[" + style + "m" + s + "\x1b[0m"
+}
+
+// cell is one table value with an optional style
+type cell struct {
+	text  string
+	style string
+}
+
+// table renders aligned columns. Widths are measured in runes so that
+// non-ASCII paths and translated text line up, and the last column is
+// shortened with an ellipsis when the terminal is too narrow.
+type table struct {
+	header []string
+	rows   [][]cell
+}
+
+// add appends a row
+func (tb *table) add(cells ...cell) {
+	tb.rows = append(tb.rows, cells)
+}
+
+// render writes the table to the terminal, or records its lines for the
+// result document in --json mode
+func (tb *table) render(t *terminal) {
+	if jsonMode {
+		var buf strings.Builder
+		tb.write(&terminal{out: &buf})
+		for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+			jsonResult.line(line)
+		}
+		return
+	}
+	tb.write(t)
+}
+
+// write renders the table for t
+func (tb *table) write(t *terminal) {
+	widths := make([]int, len(tb.header))
+	for i, title := range tb.header {
+		widths[i] = utf8.RuneCountInString(title)
+	}
+	for _, row := range tb.rows {
+		for i, c := range row {
+			if n := utf8.RuneCountInString(c.text); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	last := len(widths) - 1
+	if t.width > 0 {
+		used := 0
+		for _, w := range widths[:last] {
+			used += w + 2
+		}
+		// Keep at least a few characters of the last column visible
+		if available := t.width - used; available >= 4 && widths[last] > available {
+			widths[last] = available
+		}
+	}
+
+	header := make([]cell, len(tb.header))
+	for i, title := range tb.header {
+		header[i] = cell{text: title, style: styleBold}
+	}
+	for _, row := range append([][]cell{header}, tb.rows...) {
+		var line strings.Builder
+		for i, c := range row {
+			text := truncate(c.text, widths[i])
+			line.WriteString(t.paint(c.style, text))
+			if i < last {
+				line.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(text)+2))
+			}
+		}
+		fmt.Fprintln(t.out, line.String())
+	}
+}
+
+// truncate shortens s to width runes, ending in an ellipsis when cut
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:width-1]) + "…"
+}
+
+// In --json mode every command prints exactly one JSON document on stdout and
+// nothing else. Commands with a document of their own hand it to printDocument;
+// everything they would print for people goes through printLine, tables and
+// printWarning, and ends up in a lib.CommandResult instead, which is also what
+// failed commands print.
+
+// jsonResult collects the output of the running command for --json mode
+var jsonResult = &commandOutput{}
+
+// commandOutput is what a command printed in --json mode. Batch exports report
+// from several goroutines, hence the lock.
+type commandOutput struct {
+	mu       sync.Mutex
+	document interface{}
+	lines    []string
+	warnings []lib.Warning
+}
+
+// line records a line of human-readable output
+func (o *commandOutput) line(line string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.lines = append(o.lines, line)
+}
+
+// warning records a warning
+func (o *commandOutput) warning(warning lib.Warning) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.warnings = append(o.warnings, warning)
+}
+
+// printDocument prints a command's JSON document on stdout; in --json mode it
+// becomes the result printed when the command ends
+func printDocument(v interface{}) error {
+	if jsonMode {
+		jsonResult.mu.Lock()
+		defer jsonResult.mu.Unlock()
+		jsonResult.document = v
+		return nil
+	}
+	output, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal output: %w", err)
+	}
+	fmt.Println(string(output))
+	return nil
+}
+
+// printLine prints a line of human-readable output on w (stdout or stderr);
+// in --json mode it is recorded in the result document instead
+func printLine(w io.Writer, format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	if jsonMode {
+		jsonResult.line(line)
+		return
+	}
+	fmt.Fprintln(w, line)
+}
+
+// printResult prints the result of a command in --json mode: its document when
+// it set one, or a lib.CommandResult, which also reports errors. A command that
+// fails after setting its document (a verification that found drift) still
+// prints the document; the exit status tells the failure.
+func printResult(cmd *cobra.Command, err error) {
+	jsonResult.mu.Lock()
+	defer jsonResult.mu.Unlock()
+
+	document := jsonResult.document
+	if document == nil {
+		command := ""
+		if cmd != nil {
+			command = strings.TrimPrefix(cmd.CommandPath(), rootCmd.Name()+" ")
+		}
+		commandResult := &lib.CommandResult{
+			SchemaVersion: lib.SchemaVersion,
+			Command:       command,
+			OK:            err == nil,
+			Output:        jsonResult.lines,
+			Warnings:      jsonResult.warnings,
+		}
+		if err != nil {
+			commandResult.Error = err.Error()
+		}
+		document = commandResult
+	}
+
+	output, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		output = []byte(fmt.Sprintf(`{"schema_version": %d, "ok": false, "error": %q}`, lib.SchemaVersion, err.Error()))
+	}
+	fmt.Println(string(output))
+}
+
+// requireStdout fails in --json mode when a command would write data (an
+// archive, a file) to stdout, where only the result document may go
+func requireStdout(what, flag string) error {
+	if jsonMode {
+		return fmt.Errorf("--json keeps stdout for the result; write the %s with %s", what, flag)
+	}
+	return nil
+}
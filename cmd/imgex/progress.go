@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// progressRedraw limits how often the progress line is redrawn
+const progressRedraw = 100 * time.Millisecond
+
+// progressBar draws a single, continuously updated status line on stderr:
+// the current export step, and while layers download, a bar with the bytes
+// read, the transfer rate and an estimate of the time left for the layer.
+type progressBar struct {
+	term *terminal
+
+	mu          sync.Mutex
+	status      string
+	layer       int
+	layers      int
+	read, size  int64
+	downloading bool
+	layerStart  time.Time
+	lastDraw    time.Time
+	lastWidth   int
+	start       time.Time
+}
+
+// newProgressBar creates a progress bar writing to t
+func newProgressBar(t *terminal) *progressBar {
+	return &progressBar{term: t, start: time.Now()}
+}
+
+// step implements lib.ProgressCallback
+func (p *progressBar) step(current, total int, description string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if current == 0 && total > 0 && p.status == "" {
+		p.start = time.Now()
+	}
+	p.status = description
+	if current == total {
+		p.draw(true)
+		fmt.Fprintf(p.term.out, "\nDone in %s\n", time.Since(p.start).Round(100*time.Millisecond))
+		p.status, p.lastWidth = "", 0
+		return
+	}
+	p.draw(true)
+}
+
+// layerProgress implements lib.LayerProgressCallback
+func (p *progressBar) layerProgress(layer, layers int, read, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if read == 0 {
+		p.layerStart = time.Now()
+	}
+	p.layer, p.layers, p.read, p.size = layer, layers, read, size
+	p.downloading = read < size
+	p.draw(read == 0 || read == size)
+}
+
+// draw redraws the line, at most every progressRedraw unless forced
+func (p *progressBar) draw(force bool) {
+	now := time.Now()
+	if !force && now.Sub(p.lastDraw) < progressRedraw {
+		return
+	}
+	p.lastDraw = now
+
+	line := p.status
+	if p.downloading {
+		line = fmt.Sprintf("Layer %d/%d %s %s / %s", p.layer+1, p.layers, p.bar(), formatBytes(p.read), formatBytes(p.size))
+		if elapsed := now.Sub(p.layerStart).Seconds(); elapsed > 0.5 && p.read > 0 && p.read < p.size {
+			rate := float64(p.read) / elapsed
+			eta := time.Duration(float64(p.size-p.read)/rate) * time.Second
+			line += fmt.Sprintf("  %s/s  ETA %s", formatBytes(int64(rate)), eta.Round(time.Second))
+		}
+	}
+	if p.term.width > 0 {
+		line = truncate(line, p.term.width-1)
+	}
+
+	// Pad over the previous line, since clearing escapes are not safe on every stream
+	width := utf8.RuneCountInString(line)
+	padding := ""
+	if width < p.lastWidth {
+		padding = strings.Repeat(" ", p.lastWidth-width)
+	}
+	p.lastWidth = width
+	fmt.Fprintf(p.term.out, "\r%s%s", line, padding)
+}
+
+// bar renders the fraction of the current layer read so far
+func (p *progressBar) bar() string {
+	const width = 24
+	filled := width
+	if p.size > 0 {
+		filled = int(float64(width) * float64(p.read) / float64(p.size))
+	}
+	if filled > width {
+		filled = width
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat("-", width-filled) + "]"
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, exp := float64(n)/unit, 0
+	for value >= unit && exp < 4 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[exp])
+}
+
+// Progress display modes for the filesystem command
+const (
+	progressModeAuto = "auto" // bar when stderr is a terminal, nothing otherwise
+	progressModeBar  = "bar"  // always draw the bar
+	progressModeJSON = "json" // one JSON event per line
+	progressModeNone = "none" // no progress output
+)
+
+// progressArgs returns the --progress mode and the remaining arguments. The flag
+// may be given bare (meaning bar), so "--progress json image" parses as a bare
+// flag followed by two arguments; the mode is taken back from the arguments.
+func progressArgs(cmd *cobra.Command, args []string) (string, []string, error) {
+	mode, _ := cmd.Flags().GetString("progress")
+	images := 1
+	if input, _ := cmd.Flags().GetString("input"); input != "" {
+		images = 0
+	}
+	if mode == progressModeBar && len(args) == images+1 && cmd.Flags().Changed("progress") {
+		switch args[0] {
+		case progressModeAuto, progressModeBar, progressModeJSON, progressModeNone:
+			mode, args = args[0], args[1:]
+		}
+	}
+	switch mode {
+	case progressModeAuto, progressModeBar, progressModeJSON, progressModeNone:
+		return mode, args, nil
+	default:
+		return "", nil, fmt.Errorf("invalid --progress %q: use auto, bar, json or none", mode)
+	}
+}
+
+// progressEvents writes --progress json output: one JSON object per line, each
+// with an "event" name and a timestamp. Byte counts are reported at most every
+// progressRedraw, while layer and export events are always written.
+type progressEvents struct {
+	mu        *sync.Mutex // shared by the events of a batch
+	enc       *json.Encoder
+	start     time.Time
+	started   int // last layer started, -1 before the first
+	finished  int // last layer finished, -1 before the first
+	lastBytes time.Time
+	bytes     lib.ByteProgress
+	image     string // image of the export, reported with --input
+}
+
+// eventHeader is common to all progress events
+type eventHeader struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+}
+
+// stepEvent reports an export step such as "Fetching image manifest"
+type stepEvent struct {
+	eventHeader
+	Step        int    `json:"step"`
+	Steps       int    `json:"steps"`
+	Description string `json:"description"`
+}
+
+// layerEvent reports that a layer started or finished downloading
+type layerEvent struct {
+	eventHeader
+	Layer  int   `json:"layer"`
+	Layers int   `json:"layers"`
+	Size   int64 `json:"size"`
+}
+
+// bytesEvent reports bytes transferred, per layer and overall
+type bytesEvent struct {
+	eventHeader
+	Layer         int   `json:"layer"`
+	Layers        int   `json:"layers"`
+	Read          int64 `json:"read"`
+	Size          int64 `json:"size"`
+	Downloaded    int64 `json:"downloaded"`
+	DownloadTotal int64 `json:"download_total"`
+	Written       int64 `json:"written"`
+}
+
+// doneEvent reports a completed export
+type doneEvent struct {
+	eventHeader
+	Image      string `json:"image,omitempty"`
+	Platform   string `json:"platform,omitempty"`
+	Output     string `json:"output,omitempty"`
+	Downloaded int64  `json:"downloaded"`
+	Written    int64  `json:"written"`
+	ElapsedMS  int64  `json:"elapsed_ms"`
+}
+
+// skippedEvent reports an export skipped by --skip-if-unchanged
+type skippedEvent struct {
+	eventHeader
+	Image    string `json:"image,omitempty"`
+	Platform string `json:"platform,omitempty"`
+	Output   string `json:"output"`
+	Digest   string `json:"digest"`
+}
+
+// failedEvent reports an export that stopped with an error
+type failedEvent struct {
+	eventHeader
+	Image string `json:"image,omitempty"`
+	Error string `json:"error"`
+}
+
+// warningEvent reports a non-fatal problem
+type warningEvent struct {
+	eventHeader
+	Image   string      `json:"image,omitempty"`
+	Warning lib.Warning `json:"warning"`
+}
+
+// newProgressEvents creates an event writer for w
+func newProgressEvents(w io.Writer) *progressEvents {
+	p := &progressEvents{mu: &sync.Mutex{}, enc: json.NewEncoder(w)}
+	p.reset()
+	return p
+}
+
+// reset prepares for the next export
+func (p *progressEvents) reset() {
+	p.start = time.Now()
+	p.started, p.finished = -1, -1
+	p.lastBytes = time.Time{}
+	p.bytes = lib.ByteProgress{}
+}
+
+// header stamps an event name with the current time
+func (p *progressEvents) header(event string) eventHeader {
+	return eventHeader{Event: event, Time: time.Now().UTC()}
+}
+
+// emit writes one event line; write errors are ignored like other stderr output
+func (p *progressEvents) emit(event interface{}) {
+	p.enc.Encode(event)
+}
+
+// step implements lib.ProgressCallback
+func (p *progressEvents) step(current, total int, description string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.emit(stepEvent{p.header("step"), current, total, description})
+}
+
+// layerProgress implements lib.LayerProgressCallback, deriving the layer
+// started and done events; an empty layer starts and finishes at once
+func (p *progressEvents) layerProgress(layer, layers int, read, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if layer > p.started {
+		p.started = layer
+		p.emit(layerEvent{p.header("layer_started"), layer, layers, size})
+	}
+	if read == size && layer > p.finished {
+		p.finished = layer
+		p.emit(layerEvent{p.header("layer_done"), layer, layers, size})
+	}
+}
+
+// byteProgress implements lib.ByteProgressCallback
+func (p *progressEvents) byteProgress(progress lib.ByteProgress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.bytes = progress
+	now := time.Now()
+	if now.Sub(p.lastBytes) < progressRedraw {
+		return
+	}
+	p.lastBytes = now
+	p.emit(bytesEvent{p.header("bytes"), progress.Layer, progress.Layers, progress.LayerRead, progress.LayerSize,
+		progress.Downloaded, progress.DownloadTotal, progress.Written})
+}
+
+// warning implements lib.WarningCallback, keeping warnings in the event stream
+func (p *progressEvents) warning(warning lib.Warning) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.emit(warningEvent{p.header("warning"), p.image, warning})
+}
+
+// done reports a completed export of platform (empty for the default) to output
+// (empty for stdout) and resets the counters for the next export
+func (p *progressEvents) done(platform, output string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.emit(doneEvent{p.header("export_done"), p.image, platform, output, p.bytes.Downloaded, p.bytes.Written,
+		time.Since(p.start).Milliseconds()})
+	p.reset()
+}
+
+// skipped reports that output was left alone because digest was exported before
+func (p *progressEvents) skipped(platform, output, digest string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.emit(skippedEvent{p.header("export_skipped"), p.image, platform, output, digest})
+}
+
+// failed reports an export error
+func (p *progressEvents) failed(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.emit(failedEvent{p.header("export_failed"), p.image, err.Error()})
+	p.reset()
+}
+
+// forImage returns the events of one image of a batch, which name the image in
+// done, skipped, failed and warning events and share the output of p, so that
+// images can be exported in parallel
+func (p *progressEvents) forImage(image string) *progressEvents {
+	events := &progressEvents{mu: p.mu, enc: p.enc, image: image}
+	events.reset()
+	return events
+}
+
+// attach routes the progress and warning callbacks of opts to the event stream
+func (p *progressEvents) attach(opts *lib.ExportOptions) {
+	opts.Progress = p.step
+	opts.LayerProgress = p.layerProgress
+	opts.ByteProgress = p.byteProgress
+	opts.Warning = p.warning
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/kenichi/imgex/lib"
+	"golang.org/x/term"
+)
+
+// withInteractiveAuth runs op with the configured credentials. When the registry
+// refuses access, no credentials were given, --interactive-auth is set and stdin
+// is a terminal, it asks for a username and password, runs op again with them
+// and offers to save them like 'imgex login'.
+func withInteractiveAuth(exporter lib.ImageExporter, ref string, auth *lib.AuthConfig, op func(auth *lib.AuthConfig) error) error {
+	err := op(auth)
+	if err == nil || !interactiveAuth || auth != nil || !lib.IsUnauthorized(err) || !isTerminal(os.Stdin) {
+		return err
+	}
+	resolved, parseErr := exporter.ResolveName(ref)
+	if parseErr != nil {
+		return err
+	}
+	parsed, parseErr := name.ParseReference(resolved)
+	if parseErr != nil {
+		return err
+	}
+	host := parsed.Context().RegistryStr()
+
+	fmt.Fprintf(os.Stderr, "%s requires authentication\n", host)
+	prompted, promptErr := promptCredentials(os.Stdin, os.Stderr)
+	if promptErr != nil {
+		return promptErr
+	}
+	prompted.Registry = host
+	if err := op(prompted); err != nil {
+		return err
+	}
+
+	save, confirmErr := confirm(os.Stdin, os.Stderr, "Save credentials for "+host+"?")
+	if confirmErr != nil || !save {
+		return nil
+	}
+	if err := exporter.Login(host, prompted); err != nil {
+		printWarning(lib.Warning{Message: fmt.Sprintf("credentials were not saved: %v", err)})
+	}
+	return nil
+}
+
+// promptCredentials asks for a username and a password, masking the password
+func promptCredentials(tty *os.File, w io.Writer) (*lib.AuthConfig, error) {
+	fmt.Fprint(w, "Username: ")
+	user, err := readLine(tty)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read username: %w", err)
+	}
+	fmt.Fprint(w, "Password: ")
+	secret, err := readSecret(tty)
+	fmt.Fprintln(w)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password: %w", err)
+	}
+	if user == "" || secret == "" {
+		return nil, fmt.Errorf("username and password are required")
+	}
+	return &lib.AuthConfig{Username: user, Password: secret}, nil
+}
+
+// readSecret reads a line from a terminal with echo turned off
+func readSecret(tty *os.File) (string, error) {
+	fd := int(tty.Fd())
+	state, err := term.GetState(fd)
+	if err != nil {
+		return "", err
+	}
+
+	// Turn echo back on even if the prompt is interrupted
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	done := make(chan struct{})
+	defer func() {
+		signal.Stop(interrupted)
+		close(done)
+	}()
+	go func() {
+		select {
+		case <-interrupted:
+			_ = term.Restore(fd, state)
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+
+	secret, err := term.ReadPassword(fd)
+	return string(secret), err
+}
+
+// readLine reads one line a byte at a time, so that nothing after it is
+// consumed from a terminal that is read again later
+func readLine(r io.Reader) (string, error) {
+	var line strings.Builder
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				break
+			}
+			line.WriteByte(buf[0])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimRight(line.String(), "\r"), nil
+}
+
+// confirm asks a yes/no question on w and reads the answer from r; anything
+// but y or yes is a no.
+func confirm(r io.Reader, w io.Writer, question string) (bool, error) {
+	fmt.Fprintf(w, "%s [y/N] ", question)
+	answer, err := readLine(r)
+	if err != nil {
+		return false, fmt.Errorf("failed to read confirmation: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
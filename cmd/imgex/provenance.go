@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// provenanceCmd shows the SLSA provenance of an image
+var provenanceCmd = &cobra.Command{
+	Use:   "provenance <image-reference>",
+	Short: "Show the SLSA provenance attached to an image",
+	Long: `Show how an image was built, from the SLSA provenance attestations attached to
+it: the builder, the build run and the materials (sources and base images) it
+was built from. Provenance is found where 'sbom' finds SBOMs, such as the
+attestations of 'docker buildx build --provenance' and of the SLSA GitHub
+generator.
+
+Every attestation must name the digest it is attached to as its subject;
+otherwise the command fails, as the attestation describes another image.
+Signatures of the attestations are not verified. --json prints the complete
+predicates.
+
+Examples:
+  imgex provenance ghcr.io/org/app:v1
+  imgex provenance --platform linux/arm64 ghcr.io/org/app:v1
+  imgex provenance --json ghcr.io/org/app:v1 | jq '.provenance[].predicate'`,
+	Args: schemaArgs(cobra.ExactArgs(1)),
+	RunE: runProvenanceCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(provenanceCmd)
+	provenanceCmd.Flags().String("platform", "",
+		"Platform whose provenance is shown besides that of a multi-arch index, e.g. linux/arm64 (default linux/amd64)")
+	provenanceCmd.Flags().Bool("schema", false,
+		"Print the JSON Schema of the --json output and exit")
+}
+
+// runProvenanceCommand implements the logic for the 'provenance' subcommand.
+func runProvenanceCommand(cmd *cobra.Command, args []string) error {
+	if printed, err := printSchema(cmd, "provenance"); printed || err != nil {
+		return err
+	}
+	platform, _ := cmd.Flags().GetString("platform")
+	cmd.SilenceUsage = true
+
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	list, err := exporter.Provenance(args[0], buildAuthConfig(), &lib.ProvenanceOptions{Platform: platform})
+	if err != nil {
+		return err
+	}
+	if jsonMode {
+		return printDocument(list)
+	}
+	if len(list.Provenance) == 0 {
+		return fmt.Errorf("no SLSA provenance attached to %s", list.Reference)
+	}
+
+	t := newTerminal(os.Stdout)
+	for i, provenance := range list.Provenance {
+		if i > 0 {
+			printLine(os.Stdout, "")
+		}
+		printLine(os.Stdout, "%s %s", t.paint(styleBold, "Provenance"), provenance.Digest)
+		for _, field := range [][2]string{
+			{"Attached to", provenance.Subject + " (" + provenance.Source + ")"},
+			{"Predicate", provenance.PredicateType},
+			{"Builder", provenance.BuilderID},
+			{"Build type", provenance.BuildType},
+			{"Invocation", provenance.InvocationID},
+			{"Started", provenance.StartedOn},
+			{"Finished", provenance.FinishedOn},
+		} {
+			if field[1] != "" {
+				printLine(os.Stdout, "  %-12s %s", field[0], field[1])
+			}
+		}
+		if len(provenance.Materials) == 0 {
+			continue
+		}
+		materials := &table{header: []string{"  MATERIAL", "DIGEST"}}
+		for _, material := range provenance.Materials {
+			materials.add(cell{text: "  " + material.URI}, cell{text: material.Digest, style: styleYellow})
+		}
+		materials.render(t)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// fingerprintIgnored lists the filesystem flags that do not change the exported
+// archive, so changing them does not invalidate an export record
+var fingerprintIgnored = map[string]bool{
+	"skip-if-unchanged": true,
+	"record-file":       true,
+	"output":            true,
+	"input":             true,
+	"output-dir":        true,
+	"parallel":          true,
+	"max-open-files":    true,
+	"interval":          true, // watch
+	"exec":              true,
+	"platform":          true, // recorded separately, per output
+	"platform-policy":   true,
+	"progress":          true,
+	"no-progress":       true,
+	"staging-dir":       true,
+	"max-staging-size":  true,
+	"write-timeout":     true,
+	"stall-warning":     true,
+	"digest-out":        true,
+	"reproducible":      true,
+	"limit-layer-size":  true,
+	"limit-output-size": true,
+	"limit-files":       true,
+	"limit-file-size":   true,
+	"strict-paths":      true,
+	"stats":             true,
+	"stats-format":      true,
+}
+
+// exportGuard implements --skip-if-unchanged: it pins the image to the digest
+// it resolves to, skips outputs whose record shows an export of that digest
+// with the same options, and records each export that succeeds
+type exportGuard struct {
+	recordFile string // --record-file, may hold {platform}; empty for the work directory
+	options    string
+	image      string
+	digest     string
+	pinned     string // repository@digest, exported instead of the tag
+}
+
+// newExportGuard creates the guard of a filesystem command
+func newExportGuard(cmd *cobra.Command, recordFile string) *exportGuard {
+	var options []string
+	cmd.LocalNonPersistentFlags().Visit(func(flag *pflag.Flag) {
+		if !fingerprintIgnored[flag.Name] {
+			options = append(options, flag.Name+"="+flag.Value.String())
+		}
+	})
+	// SOURCE_DATE_EPOCH sets the timestamps of the archive like a flag would
+	if epoch := os.Getenv(lib.EnvSourceDateEpoch); epoch != "" {
+		options = append(options, lib.EnvSourceDateEpoch+"="+epoch)
+	}
+	return &exportGuard{recordFile: recordFile, options: strings.Join(options, " ")}
+}
+
+// resolve looks up the digest imageRef points to
+func (g *exportGuard) resolve(exporter lib.ImageExporter, imageRef string, auth *lib.AuthConfig) error {
+	resolved, err := exporter.ResolveName(imageRef)
+	if err != nil {
+		return err
+	}
+	ref, err := name.ParseReference(resolved)
+	if err != nil {
+		return fmt.Errorf("invalid image reference %q: %w", imageRef, err)
+	}
+	digest, err := exporter.ResolveDigest(imageRef, auth)
+	if err != nil {
+		return err
+	}
+	g.image, g.digest, g.pinned = imageRef, digest, ref.Context().Digest(digest).String()
+	return nil
+}
+
+// record returns the export record the guard would write for platform and output
+func (g *exportGuard) record(platform, output string) (*lib.ExportRecord, error) {
+	abs, err := filepath.Abs(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", output, err)
+	}
+	return &lib.ExportRecord{
+		SchemaVersion: lib.SchemaVersion,
+		Image:         g.image,
+		Digest:        g.digest,
+		Platform:      platform,
+		Output:        abs,
+		Options:       g.options,
+		Time:          time.Now().UTC(),
+	}, nil
+}
+
+// recordPath returns where the record of output is kept
+func (g *exportGuard) recordPath(platform, output string) (string, error) {
+	if g.recordFile != "" {
+		return strings.ReplaceAll(g.recordFile, "{platform}", strings.ReplaceAll(platform, "/", "-")), nil
+	}
+	dir, err := resolveWorkDir()
+	if err != nil {
+		return "", err
+	}
+	return dir.ExportRecordPath(output)
+}
+
+// unchanged reports whether output already holds an export of the resolved
+// digest with the same options, per its record
+func (g *exportGuard) unchanged(platform, output string) (bool, error) {
+	path, err := g.recordPath(platform, output)
+	if err != nil {
+		return false, err
+	}
+	previous, err := lib.ReadExportRecord(path)
+	if err != nil || previous == nil {
+		return false, err
+	}
+	current, err := g.record(platform, output)
+	if err != nil {
+		return false, err
+	}
+	if !previous.Matches(current) {
+		return false, nil
+	}
+	// A record is no use once its output is gone
+	if _, err := os.Stat(output); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// done records a successful export of platform to output
+func (g *exportGuard) done(platform, output string) error {
+	path, err := g.recordPath(platform, output)
+	if err != nil {
+		return err
+	}
+	current, err := g.record(platform, output)
+	if err != nil {
+		return err
+	}
+	return current.Write(path)
+}
+
+// skipped reports an output left alone because its source did not change
+func (g *exportGuard) skipped(platform, output string, events *progressEvents) {
+	logger.Info("export skipped", "image", g.image, "digest", g.digest, "platform", platform, "output", output)
+	if events != nil {
+		events.skipped(platform, output, g.digest)
+		return
+	}
+	printLine(os.Stderr, "%s is up to date with %s (%s), skipping export", output, g.image, g.digest)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// referrersCmd lists the artifacts attached to an image
+var referrersCmd = &cobra.Command{
+	Use:   "referrers <image-reference>",
+	Short: "List the signatures, SBOMs and attestations attached to an image",
+	Long: `List the artifacts attached to an image through the OCI referrers API, with
+their artifact types and digests: signatures, SBOMs, attestations and anything
+else pushed with the image as its subject.
+
+Registries without the referrers API are queried through the sha256-<hex>
+fallback tag that tools attaching artifacts maintain for them. Artifacts are
+attached to one manifest: a multi-platform tag lists the artifacts of its index,
+a platform digest (see 'imgex lock') those of that platform.
+
+Examples:
+  imgex referrers ghcr.io/org/app:v1
+  imgex referrers --artifact-type application/spdx+json ghcr.io/org/app:v1
+  imgex referrers --json ghcr.io/org/app@sha256:...`,
+	Args: schemaArgs(cobra.ExactArgs(1)),
+	RunE: runReferrersCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(referrersCmd)
+	referrersCmd.Flags().String("artifact-type", "",
+		"Only list artifacts of this type (e.g. application/vnd.dev.sigstore.bundle.v0.3+json)")
+	referrersCmd.Flags().Bool("schema", false,
+		"Print the JSON Schema of the --json output and exit")
+}
+
+// runReferrersCommand implements the logic for the 'referrers' subcommand.
+func runReferrersCommand(cmd *cobra.Command, args []string) error {
+	if printed, err := printSchema(cmd, "referrers"); printed || err != nil {
+		return err
+	}
+	artifactType, _ := cmd.Flags().GetString("artifact-type")
+	cmd.SilenceUsage = true
+
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	list, err := exporter.Referrers(args[0], buildAuthConfig(), &lib.ReferrersOptions{ArtifactType: artifactType})
+	if err != nil {
+		return err
+	}
+	if jsonMode {
+		return printDocument(list)
+	}
+
+	referrers := &table{header: []string{"DIGEST", "ARTIFACT TYPE", "SIZE", "CREATED"}}
+	for _, referrer := range list.Referrers {
+		kind := cell{text: referrer.ArtifactType}
+		if kind.text == "" {
+			kind = cell{text: referrer.MediaType, style: styleYellow}
+		}
+		referrers.add(cell{text: referrer.Digest}, kind, cell{text: formatBytes(referrer.Size)},
+			cell{text: referrer.Annotations["org.opencontainers.image.created"]})
+	}
+	referrers.render(newTerminal(os.Stdout))
+	printLine(os.Stderr, "%d referrers of %s (%s)", len(list.Referrers), list.Reference, list.Digest)
+	return nil
+}
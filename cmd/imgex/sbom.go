@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// sbomCmd downloads the SBOMs attached to an image
+var sbomCmd = &cobra.Command{
+	Use:   "sbom <image-reference>",
+	Short: "Download the SBOM attached to an image",
+	Long: `Download the software bill of materials attached to an image, wherever the tool
+that produced it stored it: as an OCI referrer, with 'cosign attach sbom' or
+'cosign attest', or as a BuildKit attestation ('docker buildx build --sbom').
+
+SBOMs of a multi-platform image are attached to its index or to the image of
+each platform: those of the index and of --platform (linux/amd64 by default)
+are considered. When several SBOMs are attached, select one with --format or
+--digest; --list shows them all.
+
+Attestations hold the SBOM as the predicate of an in-toto statement, usually in
+a DSSE envelope. They are written as stored, or with --predicate as the SBOM
+document itself. Their signatures are not verified.
+
+--generate builds an SBOM from the image contents instead: the image is
+flattened and the apk, dpkg and rpm databases found in it are read, with
+--lockfiles also the package-lock.json, poetry.lock, requirements.txt and
+Cargo.lock files. It is written as SPDX 2.3 JSON, or CycloneDX 1.5 JSON with
+--format cyclonedx; --json prints the package inventory. Software installed
+without a package manager is not listed.
+
+Examples:
+  imgex sbom ghcr.io/org/app:v1 > sbom.spdx.json
+  imgex sbom --list ghcr.io/org/app:v1
+  imgex sbom --format cyclonedx --predicate --platform linux/arm64 -o bom.json ghcr.io/org/app:v1
+  imgex sbom --digest sha256:... --output sbom.json ghcr.io/org/app:v1
+  imgex sbom --generate --format cyclonedx -o bom.json debian:bookworm
+  imgex sbom --generate --lockfiles --json node:22 | jq '.packages[].purl'`,
+	Args: schemaArgs(cobra.ExactArgs(1)),
+	RunE: runSBOMCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(sbomCmd)
+	sbomCmd.Flags().StringP("output", "o", "",
+		"Output file path (default: stdout)")
+	sbomCmd.Flags().String("platform", "",
+		"Platform whose SBOMs are considered besides those of a multi-arch index, e.g. linux/arm64 (default linux/amd64)")
+	sbomCmd.Flags().String("format", "",
+		"Only consider SBOMs of this format: spdx, cyclonedx or syft (with --generate: the format written, spdx or cyclonedx)")
+	sbomCmd.Flags().String("digest", "",
+		"Download the SBOM stored in this blob, as listed by --list")
+	sbomCmd.Flags().Bool("predicate", false,
+		"Write the SBOM predicate of an attestation instead of the in-toto statement or DSSE envelope")
+	sbomCmd.Flags().Bool("list", false,
+		"List the attached SBOMs instead of downloading one (implied by --json)")
+	sbomCmd.Flags().Bool("generate", false,
+		"Generate an SBOM from the package databases in the image instead of downloading an attached one")
+	sbomCmd.Flags().Bool("lockfiles", false,
+		"With --generate, also list the dependencies pinned by language lockfiles")
+	sbomCmd.Flags().Bool("schema", false,
+		"Print the JSON Schema of the --json output and exit")
+}
+
+// runSBOMCommand implements the logic for the 'sbom' subcommand.
+func runSBOMCommand(cmd *cobra.Command, args []string) error {
+	if generate, _ := cmd.Flags().GetBool("generate"); generate {
+		return runGenerateSBOM(cmd, args)
+	}
+	if printed, err := printSchema(cmd, "sbom"); printed || err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("lockfiles") {
+		return fmt.Errorf("--lockfiles requires --generate")
+	}
+	imageRef := args[0]
+	outputPath, _ := cmd.Flags().GetString("output")
+	digest, _ := cmd.Flags().GetString("digest")
+	predicate, _ := cmd.Flags().GetBool("predicate")
+	list, _ := cmd.Flags().GetBool("list")
+	opts := &lib.SBOMOptions{}
+	opts.Platform, _ = cmd.Flags().GetString("platform")
+	opts.Format, _ = cmd.Flags().GetString("format")
+	cmd.SilenceUsage = true
+
+	auth := buildAuthConfig()
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	sboms, err := exporter.SBOMs(imageRef, auth, opts)
+	if err != nil {
+		return err
+	}
+	if jsonMode {
+		return printDocument(sboms)
+	}
+	if list {
+		table := &table{header: []string{"FORMAT", "SOURCE", "ATTACHED TO", "DIGEST", "SIZE"}}
+		for _, sbom := range sboms.SBOMs {
+			table.add(cell{text: sbom.Format}, cell{text: sbom.Source}, cell{text: shortDigest(sbom.Subject)},
+				cell{text: sbom.Digest}, cell{text: formatBytes(sbom.Size)})
+		}
+		table.render(newTerminal(os.Stdout))
+		printLine(os.Stderr, "%d SBOMs attached to %s (%s)", len(sboms.SBOMs), sboms.Reference, sboms.Digest)
+		return nil
+	}
+
+	var selected []lib.SBOM
+	for _, sbom := range sboms.SBOMs {
+		if digest == "" || sbom.Digest == digest {
+			selected = append(selected, sbom)
+		}
+	}
+	switch {
+	case len(selected) == 0 && digest != "":
+		return fmt.Errorf("no SBOM %s attached to %s", digest, imageRef)
+	case len(selected) == 0:
+		return fmt.Errorf("no SBOM attached to %s", imageRef)
+	case len(selected) > 1:
+		return fmt.Errorf("%d SBOMs attached to %s: select one with --format or --digest (see --list)", len(selected), imageRef)
+	}
+
+	content, err := exporter.ReadSBOM(imageRef, selected[0], auth, predicate)
+	if err != nil {
+		return err
+	}
+	if outputPath == "" {
+		_, err = os.Stdout.Write(content)
+		return err
+	}
+	if err := os.WriteFile(outputPath, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", outputPath, err)
+	}
+	printLine(os.Stderr, "Wrote %s SBOM (%s) to %s", selected[0].Format, formatBytes(int64(len(content))), outputPath)
+	return nil
+}
+
+// runGenerateSBOM implements 'sbom --generate'.
+func runGenerateSBOM(cmd *cobra.Command, args []string) error {
+	if printed, err := printSchema(cmd, "packages"); printed || err != nil {
+		return err
+	}
+	outputPath, _ := cmd.Flags().GetString("output")
+	format, _ := cmd.Flags().GetString("format")
+	if format == "" {
+		format = lib.SBOMFormatSPDX
+	}
+	if format != lib.SBOMFormatSPDX && format != lib.SBOMFormatCycloneDX {
+		return fmt.Errorf("invalid --format %q with --generate: must be spdx or cyclonedx", format)
+	}
+	for _, flag := range []string{"digest", "predicate", "list"} {
+		if cmd.Flags().Changed(flag) {
+			return fmt.Errorf("--%s cannot be used with --generate", flag)
+		}
+	}
+	opts := &lib.GenerateSBOMOptions{}
+	opts.Platform, _ = cmd.Flags().GetString("platform")
+	opts.Lockfiles, _ = cmd.Flags().GetBool("lockfiles")
+	cmd.SilenceUsage = true
+
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	inventory, err := exporter.GenerateSBOM(args[0], buildAuthConfig(), opts)
+	if err != nil {
+		return err
+	}
+	if jsonMode {
+		return printDocument(inventory)
+	}
+
+	content, err := inventory.Encode(format)
+	if err != nil {
+		return err
+	}
+	if outputPath == "" {
+		_, err = os.Stdout.Write(content)
+		return err
+	}
+	if err := os.WriteFile(outputPath, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", outputPath, err)
+	}
+	printLine(os.Stderr, "Wrote %s SBOM of %d packages to %s", format, len(inventory.Packages), outputPath)
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// scanSecretsCmd looks for credentials in the filesystem of an image
+var scanSecretsCmd = &cobra.Command{
+	Use:   "scan-secrets <image-reference>",
+	Short: "Find credentials left in the filesystem of an image",
+	Long: `Scan the filesystem of an image, as 'filesystem' would export it, for likely
+credentials: private keys, AWS access keys, GitHub and npm tokens, .env files
+and registry credentials in .docker/config.json or containers/auth.json.
+Secret values are redacted in the output.
+
+Files deleted or replaced by a later layer are not exported, but remain in the
+layer blobs anyone pulling the image can read: --all-layers scans them too.
+
+Files over 10 MiB, binary files, documentation and the test files of installed
+language packages are not scanned. The command fails when anything is found.
+
+Examples:
+  imgex scan-secrets ghcr.io/org/app:v1
+  imgex scan-secrets --all-layers --json ghcr.io/org/app:v1 | jq '.findings[].path'`,
+	Args: schemaArgs(cobra.ExactArgs(1)),
+	RunE: runScanSecretsCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(scanSecretsCmd)
+	scanSecretsCmd.Flags().String("platform", "",
+		"Platform of a multi-arch image to scan, e.g. linux/arm64 (default linux/amd64)")
+	scanSecretsCmd.Flags().Bool("all-layers", false,
+		"Also scan the files of every layer that were deleted or replaced by a later layer")
+	scanSecretsCmd.Flags().Bool("schema", false,
+		"Print the JSON Schema of the --json output and exit")
+}
+
+// runScanSecretsCommand implements the logic for the 'scan-secrets' subcommand.
+func runScanSecretsCommand(cmd *cobra.Command, args []string) error {
+	if printed, err := printSchema(cmd, "secret-report"); printed || err != nil {
+		return err
+	}
+	imageRef := args[0]
+	opts := &lib.SecretScanOptions{}
+	opts.Platform, _ = cmd.Flags().GetString("platform")
+	opts.AllLayers, _ = cmd.Flags().GetBool("all-layers")
+	cmd.SilenceUsage = true
+
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	var report *lib.SecretReport
+	err = withInteractiveAuth(exporter, imageRef, buildAuthConfig(), func(auth *lib.AuthConfig) (err error) {
+		report, err = exporter.ScanSecrets(imageRef, auth, opts)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan %s: %w", imageRef, err)
+	}
+
+	if jsonMode {
+		printDocument(report)
+	} else if len(report.Findings) > 0 {
+		table := &table{header: []string{"PATH", "LINE", "RULE", "MATCH", "LAYER"}}
+		for _, finding := range report.Findings {
+			line, layer := "-", ""
+			if finding.Line > 0 {
+				line = strconv.Itoa(finding.Line)
+			}
+			style := styleRed
+			if finding.Removed {
+				layer, style = shortDigest(finding.Layer)+" (removed)", styleYellow
+			}
+			table.add(cell{text: finding.Path, style: style}, cell{text: line}, cell{text: finding.Rule},
+				cell{text: finding.Match}, cell{text: layer})
+		}
+		table.render(newTerminal(os.Stdout))
+	}
+	printLine(os.Stderr, "Scanned %d files of %s: %d likely credentials", report.Scanned, imageRef, len(report.Findings))
+
+	if len(report.Findings) > 0 {
+		return fmt.Errorf("credentials found in %s", imageRef)
+	}
+	return nil
+}
@@ -0,0 +1,421 @@
+//go:build !noserver
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// serveCmd runs imgex as a long-lived HTTP service
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve image configurations and filesystems over HTTP",
+	Long: `Run an HTTP server exposing imgex to other programs:
+
+  GET /v1/config?image=REF                  image configuration as JSON
+  GET /v1/filesystem?image=REF              flattened filesystem as a tar stream,
+      [&platform=OS/ARCH][&compress=true]   optionally gzip-compressed
+  GET /v1/jobs                              running filesystem exports as JSON
+  DELETE /v1/jobs/ID                        cancel a running filesystem export
+  GET /v1/version                           build information as JSON
+  GET /metrics                              Prometheus metrics
+  GET /healthz                              liveness check
+
+Registry credentials can be sent with each request, either as an X-Registry-Auth
+header holding base64-encoded JSON ({"username", "password", "registry",
+"registry_token"}) or as an Authorization header (Basic or Bearer), which is
+forwarded to the registry. Requests without credentials use the global flags,
+environment, --auth-file and docker config like every other command; credentials
+from flags or the environment need --registry and are only sent to that registry.
+
+Errors are returned as {"error": "..."} with status 400 for bad requests, 401
+when the registry refuses access, 403 for repositories refused by
+--allow-repository or --deny-repository, 404 for unknown images, 422 for images
+beyond the --limit-* flags and 502 for other registry failures. A filesystem stream that fails after it has started is cut
+off, so clients must treat a truncated tar as an error.
+
+Every filesystem export is a job, named in the X-Imgex-Job response header and
+listed by /v1/jobs with its image, client and bytes downloaded and written.
+Canceling a job ('imgex jobs cancel ID') stops its downloads within a chunk; the
+client gets a 503 error, or a truncated stream once the archive has started.
+
+/metrics counts requests, exports, response bytes, registry errors and blob
+cache hits (use --cache for a shared cache), with latency histograms for
+requests and registry round trips.
+
+The server has no authentication of its own and listens on localhost by
+default; put it behind a proxy that authenticates clients before exposing it.
+--allow-repository and --deny-repository restrict the repositories clients can
+make it contact, e.g. to keep it from reaching internal registries. Images on
+the server's host (docker-archive:, oci:, containerd: and docker-daemon:
+references, or --source daemon) are never served and fail with status 403.
+The --limit-* flags of 'imgex filesystem' bound every export, so an image
+beyond them fails instead of filling the memory of the server.
+
+Examples:
+  imgex serve
+  imgex serve --listen :8080 --cache
+  imgex serve --allow-repository registry.example.com --allow-repository docker.io/library
+  imgex serve --limit-layer-size 10G --limit-files 1000000
+  curl 'http://localhost:8080/v1/config?image=alpine:latest'
+  curl -u user:pass 'http://localhost:8080/v1/filesystem?image=registry.example.com/app:v1' > app.tar`,
+	Args: cobra.NoArgs,
+	RunE: runServeCommand,
+}
+
+func init() {
+	lib.RegisterFeature(lib.FeatureServer)
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().String("listen", "127.0.0.1:8080",
+		"Address to listen on (host:port)")
+	serveCmd.Flags().Duration("shutdown-timeout", 30*time.Second,
+		"How long to wait for running requests on SIGINT or SIGTERM")
+	serveCmd.Flags().StringArray("allow-repository", nil,
+		"Only contact repositories under this pattern, e.g. ghcr.io/org (repeatable)")
+	serveCmd.Flags().StringArray("deny-repository", nil,
+		"Never contact repositories under this pattern (repeatable)")
+	addLimitFlags(serveCmd)
+}
+
+// runServeCommand implements the logic for the 'serve' subcommand.
+func runServeCommand(cmd *cobra.Command, args []string) error {
+	listen, _ := cmd.Flags().GetString("listen")
+	shutdownTimeout, _ := cmd.Flags().GetDuration("shutdown-timeout")
+	allow, _ := cmd.Flags().GetStringArray("allow-repository")
+	deny, _ := cmd.Flags().GetStringArray("deny-repository")
+	if jsonMode {
+		return fmt.Errorf("serve runs until stopped and has no single result for --json; use --log-format json for machine-readable logs")
+	}
+
+	limits, err := parseLimitFlags(cmd)
+	if err != nil {
+		return err
+	}
+	s, err := newServer(allow, deny, lib.WithLimits(limits))
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", listen, err)
+	}
+	httpServer := &http.Server{
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- httpServer.Serve(listener)
+	}()
+	fmt.Fprintf(os.Stderr, "Listening on http://%s\n", listener.Addr())
+	logger.Info("server started", "address", listener.Addr().String())
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	logger.Info("server stopping")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to stop server: %w", err)
+	}
+	return nil
+}
+
+// server handles the HTTP API of 'imgex serve'
+type server struct {
+	exporter lib.ImageExporter
+	auth     *lib.AuthConfig // credentials from flags and environment, nil for the keychain
+	metrics  *serverMetrics
+	jobs     *serverJobs
+}
+
+// newServer creates the server of 'imgex serve' for the repository policy of
+// allow and deny. One exporter serves every request, sharing registry clients
+// and the blob cache; it never reads images from the host.
+func newServer(allow, deny []string, extra ...lib.ExporterOption) (*server, error) {
+	metrics := newServerMetrics()
+	opts := []lib.ExporterOption{
+		lib.WithTransportWrapper(metrics.wrapTransport),
+		lib.WithRepositoryPolicy(allow, deny),
+		lib.WithLocalSources(false),
+	}
+	// Clients choose the registry, so the server's own credentials must be
+	// scoped to one registry or any client could have them sent elsewhere
+	auth := buildAuthConfig()
+	if auth != nil && auth.Registry == "" {
+		return nil, fmt.Errorf("serve needs --registry (or %s) to scope the credentials of --username, --password, --token or the environment", lib.EnvRegistry)
+	}
+	exporter, err := newExporter(append(opts, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	metrics.exporter = exporter
+	return &server{exporter: exporter, auth: auth, metrics: metrics, jobs: newServerJobs()}, nil
+}
+
+// routes returns the handler for every endpoint
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/config", s.metrics.instrument("config", s.handleConfig))
+	mux.HandleFunc("GET /v1/filesystem", s.metrics.instrument("filesystem", s.handleFilesystem))
+	mux.HandleFunc("GET /v1/jobs", s.metrics.instrument("jobs", s.handleJobs))
+	mux.HandleFunc("DELETE /v1/jobs/{id}", s.metrics.instrument("cancel_job", s.handleCancelJob))
+	mux.HandleFunc("GET /v1/version", s.metrics.instrument("version", s.handleVersion))
+	mux.Handle("GET /metrics", s.metrics)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	return logRequests(mux)
+}
+
+// handleConfig serves GET /v1/config
+func (s *server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	imageRef, auth, ok := s.imageRequest(w, r)
+	if !ok {
+		return
+	}
+	if r.URL.Query().Has("platform") {
+		writeError(w, http.StatusBadRequest, errors.New("/v1/config does not select platforms; use the digest of the platform's manifest"))
+		return
+	}
+
+	config, err := s.exporter.GetImageConfig(imageRef, auth)
+	s.metrics.export("config", err)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, config)
+}
+
+// handleFilesystem serves GET /v1/filesystem, streaming the tar as it is written
+func (s *server) handleFilesystem(w http.ResponseWriter, r *http.Request) {
+	imageRef, auth, ok := s.imageRequest(w, r)
+	if !ok {
+		return
+	}
+	opts := &lib.ExportOptions{Platform: r.URL.Query().Get("platform")}
+	if opts.Platform != "" {
+		if _, err := v1.ParsePlatform(opts.Platform); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid platform %q: %w", opts.Platform, err))
+			return
+		}
+	}
+	if value := r.URL.Query().Get("compress"); value != "" {
+		compress, err := strconv.ParseBool(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid compress value %q", value))
+			return
+		}
+		opts.Compress = compress
+	}
+
+	// The export runs as a job until it ends or the client goes away
+	job := s.jobs.start(r, imageRef, opts)
+	defer s.jobs.finish(job)
+	w.Header().Set("X-Imgex-Job", job.ID())
+
+	// Headers are only sent with the first byte of the archive, so registry
+	// errors before that still get a proper status
+	out := &lazyHeaderWriter{w: w, contentType: "application/x-tar"}
+	if opts.Compress {
+		out.contentType = "application/gzip"
+	}
+	err := s.exporter.ExportImageFilesystemToWriterWithOptions(imageRef, out, auth, opts)
+	s.metrics.export("filesystem", err)
+	if err == nil {
+		return
+	}
+	if job.canceled() {
+		logger.Warn("filesystem export canceled", "job", job.ID(), "image", imageRef)
+		err = fmt.Errorf("export canceled (job %s)", job.ID())
+	}
+	if !out.started {
+		if job.canceled() {
+			writeError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		writeRegistryError(w, err)
+		return
+	}
+	// The status is already sent: abort the response so the client sees a truncated stream
+	logger.Warn("filesystem stream failed", "image", imageRef, "error", err)
+	panic(http.ErrAbortHandler)
+}
+
+// handleJobs serves GET /v1/jobs
+func (s *server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.jobs.list())
+}
+
+// handleCancelJob serves DELETE /v1/jobs/{id}
+func (s *server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.cancel(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no running job %q", r.PathValue("id")))
+		return
+	}
+	logger.Info("job canceled", "job", job.ID, "image", job.Image)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// handleVersion serves GET /v1/version
+func (s *server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, lib.GetBuildInfo())
+}
+
+// imageRequest validates the image parameter and resolves the credentials of a
+// request, writing an error response and returning false if either is invalid
+func (s *server) imageRequest(w http.ResponseWriter, r *http.Request) (string, *lib.AuthConfig, bool) {
+	imageRef := r.URL.Query().Get("image")
+	if imageRef == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing image parameter"))
+		return "", nil, false
+	}
+	if _, err := name.ParseReference(imageRef); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid image reference %q: %w", imageRef, err))
+		return "", nil, false
+	}
+	auth, err := requestAuth(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return "", nil, false
+	}
+	if auth == nil {
+		auth = s.auth
+	}
+	return imageRef, auth, true
+}
+
+// requestAuth returns the registry credentials sent with a request, or nil
+func requestAuth(r *http.Request) (*lib.AuthConfig, error) {
+	if header := r.Header.Get("X-Registry-Auth"); header != "" {
+		data, err := base64.StdEncoding.DecodeString(header)
+		if err != nil {
+			data, err = base64.URLEncoding.DecodeString(header)
+		}
+		if err != nil {
+			return nil, errors.New("invalid X-Registry-Auth header: not base64")
+		}
+		auth := &lib.AuthConfig{}
+		if err := json.Unmarshal(data, auth); err != nil {
+			return nil, fmt.Errorf("invalid X-Registry-Auth header: %w", err)
+		}
+		return auth, nil
+	}
+
+	if username, password, ok := r.BasicAuth(); ok {
+		return &lib.AuthConfig{Username: username, Password: password}, nil
+	}
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return &lib.AuthConfig{RegistryToken: bearer}, nil
+	}
+	return nil, nil
+}
+
+// writeRegistryError maps an exporter error to an HTTP status
+func writeRegistryError(w http.ResponseWriter, err error) {
+	var attestationErr *lib.AttestationError
+	switch {
+	case errors.As(err, &attestationErr):
+		writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, lib.ErrRepositoryDenied):
+		writeError(w, http.StatusForbidden, err)
+	case errors.Is(err, lib.ErrLimitExceeded):
+		writeError(w, http.StatusUnprocessableEntity, err)
+	case lib.IsUnauthorized(err):
+		writeError(w, http.StatusUnauthorized, err)
+	case lib.IsNotFound(err):
+		writeError(w, http.StatusNotFound, err)
+	default:
+		writeError(w, http.StatusBadGateway, err)
+	}
+}
+
+// writeError writes an error response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// lazyHeaderWriter sets the content type and status of a response when the
+// first byte is written
+type lazyHeaderWriter struct {
+	w           http.ResponseWriter
+	contentType string
+	started     bool
+}
+
+// Write implements io.Writer
+func (l *lazyHeaderWriter) Write(p []byte) (int, error) {
+	if !l.started {
+		l.started = true
+		l.w.Header().Set("Content-Type", l.contentType)
+		l.w.WriteHeader(http.StatusOK)
+	}
+	return l.w.Write(p)
+}
+
+// statusRecorder captures the status and body size of a response for logging
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// Write implements http.ResponseWriter
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// WriteHeader implements http.ResponseWriter
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// logRequests logs every request with its status and duration
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		logger.Info("http request", "method", r.Method, "path", r.URL.Path,
+			"image", r.URL.Query().Get("image"), "status", recorder.status, "bytes", recorder.bytes,
+			"duration", time.Since(start).Round(time.Millisecond))
+	})
+}
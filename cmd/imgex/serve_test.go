@@ -0,0 +1,371 @@
+//go:build !noserver
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/kenichi/imgex/lib"
+)
+
+// newTestServer starts the HTTP API of 'imgex serve'
+func newTestServer(t *testing.T, extra ...lib.ExporterOption) *httptest.Server {
+	t.Helper()
+	s, err := newServer(nil, nil, extra...)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server := httptest.NewServer(s.routes())
+	t.Cleanup(server.Close)
+	return server
+}
+
+// getError requests path with the image parameter and returns the status and error message
+func getError(t *testing.T, server *httptest.Server, path, imageRef string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(server.URL + path + "?image=" + url.QueryEscape(imageRef))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body.Error
+}
+
+func TestServeRefusesLocalSources(t *testing.T) {
+	// The references parse as registry references, so only the exporter can refuse them
+	server := newTestServer(t, lib.WithOffline())
+
+	for _, imageRef := range []string{
+		lib.DockerArchivePrefix + "/etc/x.tar",
+		lib.OCILayoutPrefix + "/var/lib/x",
+		lib.ContainerdPrefix + "alpine",
+		lib.DockerDaemonPrefix + "alpine",
+	} {
+		for _, path := range []string{"/v1/config", "/v1/filesystem"} {
+			status, message := getError(t, server, path, imageRef)
+			if status != http.StatusForbidden {
+				t.Errorf("Expected %s of %s to be forbidden, got %d: %s", path, imageRef, status, message)
+			}
+		}
+	}
+}
+
+func TestServeScopesCredentials(t *testing.T) {
+	defer func(u, r string) { username, registry = u, r }(username, registry)
+	username, registry = "operator", ""
+	if _, err := newServer(nil, nil, lib.WithOffline()); err == nil {
+		t.Error("Expected credentials without --registry to be refused")
+	}
+
+	registry = "registry.example.com"
+	s, err := newServer(nil, nil, lib.WithOffline())
+	if err != nil {
+		t.Fatalf("Expected scoped credentials to be accepted, got %v", err)
+	}
+	if s.auth == nil || s.auth.Registry != "registry.example.com" {
+		t.Errorf("Expected credentials scoped to registry.example.com, got %+v", s.auth)
+	}
+}
+
+// stubExporter replaces the filesystem export of an exporter
+type stubExporter struct {
+	lib.ImageExporter
+	export func(w io.Writer, opts *lib.ExportOptions) error
+}
+
+// ExportImageFilesystemToWriterWithOptions implements lib.ImageExporter
+func (s *stubExporter) ExportImageFilesystemToWriterWithOptions(imageRef string, w io.Writer, auth *lib.AuthConfig, opts *lib.ExportOptions) error {
+	return s.export(w, opts)
+}
+
+// newStubServer starts the HTTP API of 'imgex serve' with a stubbed filesystem export
+func newStubServer(t *testing.T, export func(w io.Writer, opts *lib.ExportOptions) error) (*server, *httptest.Server) {
+	t.Helper()
+	s := &server{exporter: &stubExporter{export: export}, metrics: newServerMetrics(), jobs: newServerJobs()}
+	server := httptest.NewServer(s.routes())
+	t.Cleanup(server.Close)
+	return s, server
+}
+
+func TestRequestAuth(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte(`{"username":"user","password":"pass","registry":"registry.example.com"}`))
+	urlEncoded := base64.URLEncoding.EncodeToString([]byte(`{"registry_token":"t?>"}`))
+
+	tests := []struct {
+		name     string
+		header   string
+		value    string
+		expected *lib.AuthConfig
+		invalid  bool
+	}{
+		{"none", "", "", nil, false},
+		{"registry auth", "X-Registry-Auth", encoded, &lib.AuthConfig{Username: "user", Password: "pass", Registry: "registry.example.com"}, false},
+		{"url-safe registry auth", "X-Registry-Auth", urlEncoded, &lib.AuthConfig{RegistryToken: "t?>"}, false},
+		{"registry auth not base64", "X-Registry-Auth", "not base64!", nil, true},
+		{"registry auth not JSON", "X-Registry-Auth", base64.StdEncoding.EncodeToString([]byte("user:pass")), nil, true},
+		{"basic", "Authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass")), &lib.AuthConfig{Username: "user", Password: "pass"}, false},
+		{"bearer", "Authorization", "Bearer token", &lib.AuthConfig{RegistryToken: "token"}, false},
+		{"unknown scheme", "Authorization", "Digest x", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/config?image=alpine", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			auth, err := requestAuth(r)
+			if tt.invalid {
+				if err == nil {
+					t.Errorf("Expected an error, got %+v", auth)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if !reflect.DeepEqual(auth, tt.expected) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, auth)
+			}
+		})
+	}
+}
+
+func TestServeRoutes(t *testing.T) {
+	server := newTestServer(t, lib.WithOffline())
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/healthz", http.StatusOK},
+		{http.MethodGet, "/v1/version", http.StatusOK},
+		{http.MethodGet, "/v1/jobs", http.StatusOK},
+		{http.MethodGet, "/metrics", http.StatusOK},
+		{http.MethodPost, "/v1/config?image=alpine", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/v1/unknown", http.StatusNotFound},
+		{http.MethodGet, "/v1/config", http.StatusBadRequest},
+		{http.MethodGet, "/v1/config?image=Not+A+Reference", http.StatusBadRequest},
+		{http.MethodGet, "/v1/config?image=alpine&platform=linux/arm64", http.StatusBadRequest},
+		{http.MethodGet, "/v1/filesystem?image=alpine&platform=linux/arm/v7/x", http.StatusBadRequest},
+		{http.MethodGet, "/v1/filesystem?image=alpine&compress=maybe", http.StatusBadRequest},
+		{http.MethodDelete, "/v1/jobs/unknown", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, server.URL+tt.path, nil)
+		if err != nil {
+			t.Fatalf("Invalid request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("Expected %s %s to return %d, got %d", tt.method, tt.path, tt.status, resp.StatusCode)
+		}
+		if tt.status == http.StatusBadRequest && resp.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected a JSON error for %s, got %s", tt.path, resp.Header.Get("Content-Type"))
+		}
+	}
+}
+
+// pushRandomImage pushes an image of two random layers to an in-memory
+// registry, returning the registry host
+func pushRandomImage(t *testing.T, repository string) string {
+	t.Helper()
+	registryServer := httptest.NewServer(ggcrregistry.New(ggcrregistry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(registryServer.Close)
+	host := strings.TrimPrefix(registryServer.URL, "http://")
+	image, err := random.Image(256, 2)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	ref, err := name.ParseReference(host + "/" + repository)
+	if err != nil {
+		t.Fatalf("Invalid reference: %v", err)
+	}
+	if err := remote.Write(ref, image); err != nil {
+		t.Fatalf("Failed to push test image: %v", err)
+	}
+	return host
+}
+
+func TestServeImages(t *testing.T) {
+	host := pushRandomImage(t, "app:v1")
+	server := newTestServer(t)
+
+	status, message := getError(t, server, "/v1/config", host+"/app:v1")
+	if status != http.StatusOK {
+		t.Errorf("Expected the config, got %d: %s", status, message)
+	}
+	status, message = getError(t, server, "/v1/config", host+"/missing:v1")
+	if status != http.StatusNotFound || message == "" {
+		t.Errorf("Expected a 404 error for a missing image, got %d: %q", status, message)
+	}
+	// Registry errors before the archive starts still get their status
+	status, message = getError(t, server, "/v1/filesystem", host+"/missing:v1")
+	if status != http.StatusNotFound || message == "" {
+		t.Errorf("Expected a 404 error for a missing filesystem, got %d: %q", status, message)
+	}
+
+	for compress, contentType := range map[string]string{"false": "application/x-tar", "true": "application/gzip"} {
+		resp, err := http.Get(server.URL + "/v1/filesystem?image=" + url.QueryEscape(host+"/app:v1") + "&compress=" + compress)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to read the archive: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != contentType {
+			t.Errorf("Expected %s with status 200, got %s with %d", contentType, resp.Header.Get("Content-Type"), resp.StatusCode)
+		}
+		if resp.Header.Get("X-Imgex-Job") == "" {
+			t.Error("Expected the job in X-Imgex-Job")
+		}
+		if compress == "false" {
+			if _, err := tar.NewReader(bytes.NewReader(body)).Next(); err != nil {
+				t.Errorf("Expected a tar stream, got %v", err)
+			}
+		} else if _, err := gzip.NewReader(bytes.NewReader(body)); err != nil {
+			t.Errorf("Expected a gzip stream, got %v", err)
+		}
+	}
+}
+
+func TestServeAbortsFailedStream(t *testing.T) {
+	_, server := newStubServer(t, func(w io.Writer, opts *lib.ExportOptions) error {
+		// More than the response buffer, so the headers are sent
+		w.Write(make([]byte, 64<<10))
+		return errors.New("registry went away")
+	})
+
+	resp, err := http.Get(server.URL + "/v1/filesystem?image=alpine")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-tar" {
+		t.Errorf("Expected the archive to have started, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Error("Expected the stream to be cut off")
+	}
+}
+
+// jobsRequest sends a jobs API request and decodes the JSON response into v
+func jobsRequest(t *testing.T, server *httptest.Server, method, path string, v any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, nil)
+	if err != nil {
+		t.Fatalf("Invalid request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("Failed to decode the response: %v", err)
+	}
+	return resp.StatusCode
+}
+
+func TestServeCancelJob(t *testing.T) {
+	for _, started := range []bool{false, true} {
+		running := make(chan struct{})
+		_, server := newStubServer(t, func(w io.Writer, opts *lib.ExportOptions) error {
+			if started {
+				w.Write(make([]byte, 64<<10))
+			}
+			opts.ByteProgress(lib.ByteProgress{Downloaded: 1024, Written: 512})
+			close(running)
+			<-opts.Context.Done()
+			return opts.Context.Err()
+		})
+
+		type result struct {
+			resp *http.Response
+			err  error
+		}
+		results := make(chan result, 1)
+		go func() {
+			resp, err := http.Get(server.URL + "/v1/filesystem?image=alpine&platform=linux/arm64")
+			results <- result{resp, err}
+		}()
+		<-running
+
+		var jobs []jobInfo
+		if status := jobsRequest(t, server, http.MethodGet, "/v1/jobs", &jobs); status != http.StatusOK || len(jobs) != 1 {
+			t.Fatalf("Expected one running job, got %d: %+v", status, jobs)
+		}
+		if job := jobs[0]; job.Image != "alpine" || job.Platform != "linux/arm64" || job.Downloaded != 1024 || job.Written != 512 || job.Canceled {
+			t.Errorf("Expected the running export, got %+v", job)
+		}
+
+		var canceled jobInfo
+		if status := jobsRequest(t, server, http.MethodDelete, "/v1/jobs/"+jobs[0].ID, &canceled); status != http.StatusAccepted {
+			t.Errorf("Expected the job to be canceled with 202, got %d", status)
+		}
+		if canceled.ID != jobs[0].ID || !canceled.Canceled {
+			t.Errorf("Expected the canceled job, got %+v", canceled)
+		}
+
+		res := <-results
+		if started {
+			// The archive had started: the stream is cut off
+			if res.err == nil {
+				_, res.err = io.ReadAll(res.resp.Body)
+				res.resp.Body.Close()
+			}
+			if res.err == nil {
+				t.Error("Expected a canceled stream to be cut off")
+			}
+		} else {
+			if res.err != nil {
+				t.Fatalf("Request failed: %v", res.err)
+			}
+			res.resp.Body.Close()
+			if res.resp.StatusCode != http.StatusServiceUnavailable {
+				t.Errorf("Expected a canceled export to return 503, got %d", res.resp.StatusCode)
+			}
+		}
+
+		if jobsRequest(t, server, http.MethodGet, "/v1/jobs", &jobs); len(jobs) != 0 {
+			t.Errorf("Expected no running jobs once the export ended, got %+v", jobs)
+		}
+	}
+}
+
+func TestServeLimits(t *testing.T) {
+	host := pushRandomImage(t, "app:v1")
+	server := newTestServer(t, lib.WithLimits(lib.ExportLimits{MaxFiles: 1}))
+
+	status, message := getError(t, server, "/v1/filesystem", host+"/app:v1")
+	if status != http.StatusUnprocessableEntity || !strings.Contains(message, "files") {
+		t.Errorf("Expected the file limit to fail the export with 422, got %d: %s", status, message)
+	}
+}
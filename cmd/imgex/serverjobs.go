@@ -0,0 +1,128 @@
+//go:build !noserver
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kenichi/imgex/lib"
+)
+
+// serverJobs tracks the filesystem exports running in 'imgex serve', so that
+// operators can list and cancel them
+type serverJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*serverJob
+}
+
+// serverJob is one running export
+type serverJob struct {
+	cancelFunc context.CancelFunc
+
+	mu   sync.Mutex
+	info jobInfo
+}
+
+// newServerJobs creates an empty job table
+func newServerJobs() *serverJobs {
+	return &serverJobs{jobs: make(map[string]*serverJob)}
+}
+
+// start registers the export of a request, setting the context and byte
+// progress of opts; the job ends with the request or when it is canceled
+func (j *serverJobs) start(r *http.Request, imageRef string, opts *lib.ExportOptions) *serverJob {
+	ctx, cancel := context.WithCancel(r.Context())
+	job := &serverJob{
+		cancelFunc: cancel,
+		info: jobInfo{
+			Image:    imageRef,
+			Platform: opts.Platform,
+			Client:   r.RemoteAddr,
+			Started:  time.Now().UTC(),
+		},
+	}
+	opts.Context = ctx
+	opts.ByteProgress = func(progress lib.ByteProgress) {
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		job.info.Downloaded, job.info.Written = progress.Downloaded, progress.Written
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for {
+		job.info.ID = newJobID()
+		if _, taken := j.jobs[job.info.ID]; !taken {
+			break
+		}
+	}
+	j.jobs[job.info.ID] = job
+	return job
+}
+
+// finish removes a job that ended
+func (j *serverJobs) finish(job *serverJob) {
+	job.cancelFunc()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.jobs, job.info.ID)
+}
+
+// list returns the running jobs, oldest first
+func (j *serverJobs) list() []jobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	jobs := make([]jobInfo, 0, len(j.jobs))
+	for _, job := range j.jobs {
+		jobs = append(jobs, job.snapshot())
+	}
+	sort.Slice(jobs, func(a, b int) bool {
+		return jobs[a].Started.Before(jobs[b].Started)
+	})
+	return jobs
+}
+
+// cancel stops a running job, returning its state
+func (j *serverJobs) cancel(id string) (jobInfo, bool) {
+	j.mu.Lock()
+	job, ok := j.jobs[id]
+	j.mu.Unlock()
+	if !ok {
+		return jobInfo{}, false
+	}
+	job.mu.Lock()
+	job.info.Canceled = true
+	job.mu.Unlock()
+	job.cancelFunc()
+	return job.snapshot(), true
+}
+
+// snapshot returns a copy of the job's state
+func (job *serverJob) snapshot() jobInfo {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.info
+}
+
+// ID returns the job ID, which never changes once the job is registered
+func (job *serverJob) ID() string {
+	return job.info.ID
+}
+
+// canceled reports whether the job was canceled through the API
+func (job *serverJob) canceled() bool {
+	return job.snapshot().Canceled
+}
+
+// newJobID returns a short random job ID
+func newJobID() string {
+	id := make([]byte, 4)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// simulateCmd handles the 'simulate' subcommand for checking how a container would start.
+var simulateCmd = &cobra.Command{
+	Use:   "simulate <image-reference> [-- command [arg...]]",
+	Short: "Report what would happen when a container of the image starts",
+	Long: `Report what would happen at container start, without running anything.
+
+The command line (ENTRYPOINT followed by CMD), the final environment and the
+user are resolved like Docker and runc do: --user names are looked up in the
+image's /etc/passwd and /etc/group, and PATH and HOME get their runtime
+defaults. The report shows whether the working directory exists (runtimes
+create a missing one) and whether the program exists on PATH, is executable by
+the user, and has its interpreter (the #! line of a script or the dynamic loader
+of a binary) in the image.
+
+--env, --user, --entrypoint and --working-dir override the image like the flags
+of 'docker run'; --env NAME without a value takes it from this environment.
+Arguments after the image replace CMD (use -- before arguments starting with a
+dash). The command exits with an error if the container would fail to start.
+
+Examples:
+  imgex simulate nginx:alpine
+  imgex simulate app:v1 --env FOO=bar --user 1001
+  imgex simulate --entrypoint /bin/sh alpine:latest -- -c 'echo hi'
+  imgex simulate --json app:v1 > start.json`,
+	Args: schemaArgs(cobra.MinimumNArgs(1)),
+	RunE: runSimulateCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(simulateCmd)
+	simulateCmd.Flags().StringArrayP("env", "e", nil,
+		"Set an environment variable, NAME=VALUE or NAME to take it from this environment (repeatable)")
+	simulateCmd.Flags().String("user", "",
+		"User to run as, name|uid[:group|gid], instead of the image USER")
+	simulateCmd.Flags().String("entrypoint", "",
+		"Entrypoint instead of the image ENTRYPOINT, clearing CMD; empty for none")
+	simulateCmd.Flags().String("working-dir", "",
+		"Working directory instead of the image WORKDIR")
+	simulateCmd.Flags().String("platform", "",
+		"Platform to simulate from a multi-arch image, e.g. linux/arm64")
+	simulateCmd.Flags().Bool("schema", false,
+		"Print the JSON Schema of the --json report and exit")
+}
+
+// runSimulateCommand implements the logic for the 'simulate' subcommand.
+func runSimulateCommand(cmd *cobra.Command, args []string) error {
+	if printed, err := printSchema(cmd, "start-report"); printed || err != nil {
+		return err
+	}
+	imageRef := args[0]
+	envFlags, _ := cmd.Flags().GetStringArray("env")
+
+	opts := &lib.SimulateOptions{Cmd: args[1:]}
+	opts.Platform, _ = cmd.Flags().GetString("platform")
+	opts.User, _ = cmd.Flags().GetString("user")
+	opts.WorkingDir, _ = cmd.Flags().GetString("working-dir")
+	if cmd.Flags().Changed("entrypoint") {
+		entrypoint, _ := cmd.Flags().GetString("entrypoint")
+		opts.Entrypoint = []string{}
+		if entrypoint != "" {
+			opts.Entrypoint = []string{entrypoint}
+		}
+	}
+	for _, env := range envFlags {
+		if strings.Contains(env, "=") {
+			opts.Env = append(opts.Env, env)
+		} else if value, ok := os.LookupEnv(env); ok {
+			opts.Env = append(opts.Env, env+"="+value)
+		}
+	}
+
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	var report *lib.StartReport
+	err = withInteractiveAuth(exporter, imageRef, buildAuthConfig(), func(auth *lib.AuthConfig) (err error) {
+		report, err = exporter.SimulateStart(imageRef, auth, opts)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to simulate container start: %w", err)
+	}
+
+	if jsonMode {
+		printDocument(report)
+	} else {
+		printStartReport(report)
+	}
+
+	if !report.OK() {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("a container of %s would fail to start", imageRef)
+	}
+	return nil
+}
+
+// printStartReport prints a start simulation for people, problems on stderr
+func printStartReport(report *lib.StartReport) {
+	out, errs := newTerminal(os.Stdout), newTerminal(os.Stderr)
+
+	quoted := make([]string, len(report.Command))
+	for i, arg := range report.Command {
+		quoted[i] = arg
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$") {
+			quoted[i] = strconv.Quote(arg)
+		}
+	}
+	command := strings.Join(quoted, " ")
+	if command == "" {
+		command = "(none)"
+	}
+	fmt.Fprintf(out.out, "%s  %s\n", out.paint(styleBold, "Command:    "), command)
+
+	if exe := report.Executable; exe.Path != "" {
+		line := exe.Path
+		if exe.Interpreter != "" {
+			line += " (interpreter " + exe.Interpreter + ")"
+		}
+		fmt.Fprintf(out.out, "%s  %s\n", out.paint(styleBold, "Executable: "), line)
+	}
+
+	user := report.User
+	line := fmt.Sprintf("uid=%d", user.UID)
+	if user.Name != "" {
+		line += "(" + user.Name + ")"
+	}
+	line += fmt.Sprintf(" gid=%d", user.GID)
+	if user.Group != "" {
+		line += "(" + user.Group + ")"
+	}
+	if len(user.AdditionalGIDs) > 0 {
+		groups := make([]string, len(user.AdditionalGIDs))
+		for i, gid := range user.AdditionalGIDs {
+			groups[i] = strconv.Itoa(gid)
+		}
+		line += " groups=" + strings.Join(groups, ",")
+	}
+	fmt.Fprintf(out.out, "%s  %s\n", out.paint(styleBold, "User:       "), line)
+
+	line = report.WorkingDir.Path
+	if !report.WorkingDir.Exists {
+		line += " (missing, created at start)"
+	}
+	fmt.Fprintf(out.out, "%s  %s\n", out.paint(styleBold, "Working dir:"), line)
+
+	fmt.Fprintln(out.out, out.paint(styleBold, "Environment:"))
+	for _, env := range report.Env {
+		fmt.Fprintf(out.out, "  %s\n", env)
+	}
+
+	if report.OK() {
+		fmt.Fprintln(os.Stderr, errs.paint(styleGreen, "No problems found"))
+		return
+	}
+	for _, problem := range report.Problems {
+		fmt.Fprintf(os.Stderr, "%s %s\n", errs.paint(styleRed, "Problem:"), problem)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// stateCmd groups the subcommands managing the work directory
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Inspect and clean the work directory",
+	Long: `Inspect and clean the work directory, the single location where imgex keeps
+data between runs: the blob cache (disposable) and job state.
+
+The work directory is --workdir (or IMGEX_WORKDIR), which holds everything under
+<dir>/cache and <dir>/state. Without either, caches go to $XDG_CACHE_HOME/imgex
+(~/.cache/imgex) and state to $XDG_STATE_HOME/imgex (~/.local/state/imgex), or the
+native per-user folders on macOS and Windows.
+
+Examples:
+  imgex state path
+  imgex state clean --area blobs --older-than 720h
+  imgex --workdir /var/lib/imgex state clean --dry-run`,
+}
+
+// statePathCmd prints the work directory locations
+var statePathCmd = &cobra.Command{
+	Use:   "path",
+	Short: "Print the work directory locations",
+	Args:  cobra.NoArgs,
+	RunE:  runStatePathCommand,
+}
+
+// stateCleanCmd removes files from the work directory
+var stateCleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "Remove cached data and state from the work directory",
+	Long: `Remove files from the work directory. Every area is cleaned unless --area
+selects some (blobs, jobs). --older-than keeps files
+modified more recently, and --dry-run only reports what would be removed.
+Only the imgex areas are touched, never other files under the work directory.
+
+Examples:
+  imgex state clean
+  imgex state clean --area blobs --older-than 720h
+  imgex state clean --area jobs --dry-run`,
+	Args: cobra.NoArgs,
+	RunE: runStateCleanCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(stateCmd)
+	stateCmd.AddCommand(statePathCmd)
+	stateCmd.AddCommand(stateCleanCmd)
+	stateCleanCmd.Flags().StringArray("area", nil,
+		"Area to clean: blobs or jobs (repeatable, default: all)")
+	stateCleanCmd.Flags().Duration("older-than", 0,
+		"Only remove files not modified for this long, e.g. 720h")
+	stateCleanCmd.Flags().Bool("dry-run", false,
+		"Report what would be removed without deleting anything")
+}
+
+// runStatePathCommand implements the logic for the 'state path' subcommand.
+func runStatePathCommand(cmd *cobra.Command, args []string) error {
+	dir, err := resolveWorkDir()
+	if err != nil {
+		return err
+	}
+	out := newTerminal(os.Stdout)
+	tb := &table{header: []string{"AREA", "PATH"}}
+	for _, area := range []string{lib.WorkDirBlobs, lib.WorkDirJobs} {
+		tb.add(cell{text: area}, cell{text: dir.Path(area)})
+	}
+	tb.render(out)
+	return nil
+}
+
+// runStateCleanCommand implements the logic for the 'state clean' subcommand.
+func runStateCleanCommand(cmd *cobra.Command, args []string) error {
+	areas, _ := cmd.Flags().GetStringArray("area")
+	olderThan, _ := cmd.Flags().GetDuration("older-than")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	dir, err := resolveWorkDir()
+	if err != nil {
+		return err
+	}
+	results, err := dir.Clean(&lib.CleanOptions{Areas: areas, OlderThan: olderThan, DryRun: dryRun})
+	if err != nil {
+		return err
+	}
+
+	out := newTerminal(os.Stdout)
+	tb := &table{header: []string{"AREA", "FILES", "SIZE", "PATH"}}
+	var total int64
+	for _, result := range results {
+		tb.add(cell{text: result.Area}, cell{text: strconv.Itoa(result.Files)},
+			cell{text: formatBytes(result.Bytes)}, cell{text: result.Path})
+		total += result.Bytes
+	}
+	tb.render(out)
+	if dryRun {
+		printLine(os.Stdout, "Would free %s", formatBytes(total))
+	} else {
+		printLine(os.Stdout, "Freed %s", formatBytes(total))
+	}
+	return nil
+}
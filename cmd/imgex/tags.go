@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// tagsCmd lists the tags of a repository, or the history of one tag
+var tagsCmd = &cobra.Command{
+	Use:   "tags <repository | image-reference>",
+	Short: "List the tags of a repository, or the digests a tag pointed to",
+	Long: `List the tags of a repository, one per line, following the registry's pagination.
+
+With --history, list the digests a tag pointed to over time instead, with when
+it was set and when it moved on, to find out which image a deployment ran during
+an incident. Registries only serve where a tag points now through their image
+API, so the history is read from vendor APIs where available:
+
+  Quay     every digest of the tag, with the times it was set and replaced
+           (private repositories need an OAuth application token, see --token)
+  Harbor   tag pushes and deletions from the project audit log; digests are
+           matched to artifacts by push time and are blank when the artifact
+           was deleted or pushed before
+
+Other registries fail with an error saying they do not expose tag history.
+
+Examples:
+  imgex tags ghcr.io/org/app
+  imgex tags --history quay.io/org/app:latest
+  imgex tags --history --json harbor.example.com/proj/app:v1`,
+	Args: schemaArgs(cobra.ExactArgs(1)),
+	RunE: runTagsCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(tagsCmd)
+	tagsCmd.Flags().Bool("history", false,
+		"List the digests the tag pointed to over time (Quay and Harbor)")
+	tagsCmd.Flags().Bool("schema", false,
+		"Print the JSON Schema of the --history --json output and exit")
+}
+
+// runTagsCommand implements the logic for the 'tags' subcommand.
+func runTagsCommand(cmd *cobra.Command, args []string) error {
+	if printed, err := printSchema(cmd, "tag-history"); printed || err != nil {
+		return err
+	}
+	showHistory, _ := cmd.Flags().GetBool("history")
+	// From here on, errors come from the registry rather than the command line
+	cmd.SilenceUsage = true
+
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+
+	if !showHistory {
+		tags, err := exporter.ListTags(args[0], buildAuthConfig(), nil)
+		if err != nil {
+			return err
+		}
+		for tags.HasNext() {
+			page, err := tags.Next()
+			if err != nil {
+				return err
+			}
+			for _, tag := range page {
+				printLine(os.Stdout, "%s", tag)
+			}
+		}
+		return nil
+	}
+
+	history, err := exporter.TagHistory(args[0], buildAuthConfig())
+	if err != nil {
+		return err
+	}
+	if jsonMode {
+		return printDocument(history)
+	}
+
+	entries := &table{header: []string{"DIGEST", "FROM", "UNTIL"}}
+	for _, entry := range history.Entries {
+		digest, until := cell{text: entry.Digest}, cell{text: "now", style: styleGreen}
+		if entry.Digest == "" {
+			digest = cell{text: "unknown", style: styleYellow}
+		}
+		if entry.End != nil {
+			until = cell{text: entry.End.Local().Format(time.RFC3339)}
+		}
+		entries.add(digest, cell{text: entry.Start.Local().Format(time.RFC3339)}, until)
+	}
+	entries.render(newTerminal(os.Stdout))
+	printLine(os.Stderr, "%d entries for %s (from the %s API)", len(history.Entries), history.Reference, history.Provider)
+	return nil
+}
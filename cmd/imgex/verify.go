@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// verifyExtractionCmd handles the 'verify-extraction' subcommand for validating extracted trees.
+// It compares a directory against the image metadata and reports any drift.
+var verifyExtractionCmd = &cobra.Command{
+	Use:   "verify-extraction <image-reference> <directory>",
+	Short: "Verify an extracted directory against the image",
+	Long: `Verify that a directory holding an extracted image filesystem matches the image.
+
+Every path in the image is checked for existence, file type, permissions, size,
+SHA-256 content hash and symlink target. Ownership is not compared. The command
+exits with an error if any drift is found, which makes it suitable for validating
+provisioned devices in scripts.
+
+Examples:
+  imgex verify-extraction alpine:latest /srv/rootfs
+  imgex verify-extraction --ignore-modes --report-extra alpine:latest ./rootfs
+  imgex verify-extraction --json alpine:latest /srv/rootfs`,
+	Args: schemaArgs(cobra.ExactArgs(2)),
+	RunE: runVerifyExtractionCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(verifyExtractionCmd)
+	verifyExtractionCmd.Flags().Bool("ignore-modes", false,
+		"Do not compare permission bits")
+	verifyExtractionCmd.Flags().Bool("report-extra", false,
+		"Report paths on disk that are not in the image")
+	verifyExtractionCmd.Flags().Bool("schema", false,
+		"Print the JSON Schema of the --json report and exit")
+}
+
+// runVerifyExtractionCommand implements the logic for the 'verify-extraction' subcommand.
+// It prints each drift entry (or a JSON report) and fails if the tree does not match.
+func runVerifyExtractionCommand(cmd *cobra.Command, args []string) error {
+	if printed, err := printSchema(cmd, "verify-report"); printed || err != nil {
+		return err
+	}
+	imageRef, dir := args[0], args[1]
+	ignoreModes, _ := cmd.Flags().GetBool("ignore-modes")
+	reportExtra, _ := cmd.Flags().GetBool("report-extra")
+
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	var report *lib.VerificationReport
+	err = withInteractiveAuth(exporter, imageRef, buildAuthConfig(), func(auth *lib.AuthConfig) (err error) {
+		report, err = exporter.VerifyExtraction(imageRef, dir, auth, &lib.VerifyOptions{
+			IgnoreModes: ignoreModes,
+			ReportExtra: reportExtra,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to verify extraction: %w", err)
+	}
+
+	if jsonMode {
+		printDocument(report)
+	} else {
+		if len(report.Drift) > 0 {
+			drifts := &table{header: []string{"KIND", "EXPECTED", "FOUND", "PATH"}}
+			for _, drift := range report.Drift {
+				drifts.add(
+					cell{text: strings.ToUpper(string(drift.Kind)), style: styleRed},
+					cell{text: drift.Expected},
+					cell{text: drift.Actual},
+					cell{text: drift.Path},
+				)
+			}
+			drifts.render(newTerminal(os.Stdout))
+		}
+		summary := fmt.Sprintf("Checked %d paths, %d differences", report.Checked, len(report.Drift))
+		style := styleGreen
+		if !report.OK() {
+			style = styleRed
+		}
+		fmt.Fprintln(os.Stderr, newTerminal(os.Stderr).paint(style, summary))
+	}
+
+	if !report.OK() {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("%s does not match %s", dir, imageRef)
+	}
+	return nil
+}
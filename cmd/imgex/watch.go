@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// watchCmd re-exports an image whenever its tag moves
+var watchCmd = &cobra.Command{
+	Use:   "watch <image-reference>",
+	Short: "Re-export an image filesystem whenever its tag points to a new digest",
+	Long: `Poll the digest an image tag points to and export its filesystem to --output
+each time it changes, then run the --exec hook, until interrupted. This makes
+simple daemon-less deployment pipelines out of a tag and a command.
+
+The archive is written next to --output and renamed into place, so readers never
+see a partial export. Like 'filesystem --skip-if-unchanged', the last export is
+recorded (in the work directory or --record-file), so a restarted watch does not
+export a digest again. Registry and export failures are reported and retried at
+the next poll.
+
+The hook runs through the shell (sh -c, or cmd /C on Windows) with
+IMGEX_IMAGE, IMGEX_DIGEST, IMGEX_PREVIOUS_DIGEST (empty at first) and
+IMGEX_OUTPUT set; a failing hook is reported and does not stop the watch.
+
+Examples:
+  imgex watch --output /srv/app.tar registry.example.com/app:stable
+  imgex watch --interval 1m --output app.tar --exec 'systemctl restart app' app:stable
+  imgex watch --platform linux/arm64 --output rootfs.tar.gz --compress alpine:3`,
+	Args: cobra.ExactArgs(1),
+	RunE: runWatchCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+	watchCmd.Flags().StringP("output", "o", "",
+		"Output file path (required)")
+	watchCmd.Flags().Duration("interval", 5*time.Minute,
+		"How often to check the tag's digest")
+	watchCmd.Flags().String("exec", "",
+		"Shell command run after each export")
+	watchCmd.Flags().BoolP("compress", "z", false,
+		"Compress output with gzip")
+	watchCmd.Flags().String("platform", "",
+		"Platform to export from a multi-arch image, e.g. linux/arm64")
+	watchCmd.Flags().String("record-file", "",
+		"File recording the last export (defaults to the state directory)")
+	watchCmd.MarkFlagRequired("output")
+}
+
+// runWatchCommand implements the logic for the 'watch' subcommand.
+func runWatchCommand(cmd *cobra.Command, args []string) error {
+	imageRef := args[0]
+	outputPath, _ := cmd.Flags().GetString("output")
+	interval, _ := cmd.Flags().GetDuration("interval")
+	hook, _ := cmd.Flags().GetString("exec")
+	compress, _ := cmd.Flags().GetBool("compress")
+	platform, _ := cmd.Flags().GetString("platform")
+	recordFile, _ := cmd.Flags().GetString("record-file")
+
+	if interval < time.Second {
+		return fmt.Errorf("--interval must be at least 1s")
+	}
+	if jsonMode {
+		return fmt.Errorf("watch runs until stopped and has no single result for --json; use --log-format json for machine-readable events")
+	}
+	if compress && !strings.HasSuffix(outputPath, ".gz") {
+		outputPath += ".gz"
+	}
+
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	defer logCacheStats(exporter)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cmd.SilenceUsage = true
+
+	w := &watcher{
+		exporter: exporter,
+		imageRef: imageRef,
+		output:   outputPath,
+		hook:     hook,
+		auth:     buildAuthConfig(),
+		opts:     &lib.ExportOptions{Compress: compress, Platform: platform, Warning: printWarning, Context: ctx},
+		guard:    newExportGuard(cmd, recordFile),
+	}
+	fmt.Fprintf(os.Stderr, "Watching %s every %s\n", imageRef, interval)
+	for {
+		w.check()
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.Info("watch stopped", "image", imageRef)
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// watcher holds the state of 'imgex watch' between polls
+type watcher struct {
+	exporter lib.ImageExporter
+	imageRef string
+	output   string
+	hook     string
+	auth     *lib.AuthConfig
+	opts     *lib.ExportOptions
+	guard    *exportGuard
+	previous string // digest of the last export, empty before the first
+}
+
+// check exports the image if its digest changed, reporting failures without
+// stopping the watch
+func (w *watcher) check() {
+	stderr := newTerminal(os.Stderr)
+	digest, err := w.export()
+	if err != nil {
+		if w.opts.Context.Err() == nil {
+			logger.Warn("watch export failed", "image", w.imageRef, "error", err)
+			fmt.Fprintf(os.Stderr, "%s %s %v\n", time.Now().Format(time.DateTime), stderr.paint(styleRed, "FAILED"), err)
+		}
+		return
+	}
+	if digest == "" {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s %s %s exported to %s\n", time.Now().Format(time.DateTime),
+		stderr.paint(styleGreen, "EXPORTED"), digest, w.output)
+
+	previous := w.previous
+	w.previous = digest
+	if w.hook == "" {
+		return
+	}
+	if err := w.runHook(digest, previous); err != nil {
+		logger.Warn("watch hook failed", "image", w.imageRef, "digest", digest, "error", err)
+		fmt.Fprintf(os.Stderr, "%s %s hook: %v\n", time.Now().Format(time.DateTime), stderr.paint(styleYellow, "WARNING"), err)
+	}
+}
+
+// export resolves the tag and exports it when the digest differs from the
+// last recorded export, returning the exported digest or "" if unchanged
+func (w *watcher) export() (string, error) {
+	if err := w.guard.resolve(w.exporter, w.imageRef, w.auth); err != nil {
+		return "", err
+	}
+	unchanged, err := w.guard.unchanged(w.opts.Platform, w.output)
+	if err != nil {
+		return "", err
+	}
+	if unchanged {
+		logger.Debug("image unchanged", "image", w.imageRef, "digest", w.guard.digest)
+		if w.previous == "" {
+			w.previous = w.guard.digest
+		}
+		return "", nil
+	}
+
+	// Write beside the output and rename, so readers never see a partial archive
+	start := time.Now()
+	partial := w.output + ".partial"
+	if err := w.exporter.ExportImageFilesystemWithOptions(w.guard.pinned, partial, w.auth, w.opts); err != nil {
+		os.Remove(partial)
+		return "", fmt.Errorf("failed to export %s: %w", w.guard.pinned, err)
+	}
+	if err := os.Rename(partial, w.output); err != nil {
+		os.Remove(partial)
+		return "", fmt.Errorf("failed to replace %s: %w", w.output, err)
+	}
+	if err := w.guard.done(w.opts.Platform, w.output); err != nil {
+		return "", err
+	}
+	logger.Info("export complete", "image", w.imageRef, "digest", w.guard.digest, "output", w.output,
+		"duration", time.Since(start).Round(time.Millisecond))
+	return w.guard.digest, nil
+}
+
+// runHook runs the --exec command through the shell for an exported digest
+func (w *watcher) runHook(digest, previous string) error {
+	var hook *exec.Cmd
+	if runtime.GOOS == "windows" {
+		hook = exec.CommandContext(w.opts.Context, "cmd", "/C", w.hook)
+	} else {
+		hook = exec.CommandContext(w.opts.Context, "sh", "-c", w.hook)
+	}
+	hook.Stdout, hook.Stderr = os.Stdout, os.Stderr
+	hook.Env = append(os.Environ(),
+		"IMGEX_IMAGE="+w.imageRef,
+		"IMGEX_DIGEST="+digest,
+		"IMGEX_PREVIOUS_DIGEST="+previous,
+		"IMGEX_OUTPUT="+w.output,
+	)
+	logger.Info("running watch hook", "command", w.hook, "digest", digest)
+	return hook.Run()
+}
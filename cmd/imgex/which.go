@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/kenichi/imgex/lib"
+	"github.com/spf13/cobra"
+)
+
+// whichCmd tells which layer last changed a path of an image
+var whichCmd = &cobra.Command{
+	Use:   "which <image-reference> <path>",
+	Short: "Show which layer last wrote or deleted a path of an image",
+	Long: `Show the layer that last wrote or deleted a path of an image, with its digest
+and the history line (usually the Dockerfile instruction) that produced it,
+followed by every earlier layer that added, replaced or deleted the path.
+
+Layers are replayed like 'filesystem' does, so symlinked parent directories
+and whiteouts are taken into account, but no file content is kept.
+
+Examples:
+  imgex which nginx:alpine /etc/nginx/nginx.conf
+  imgex which --json ghcr.io/org/app:v1 /app/node_modules | jq '.changes[-1]'`,
+	Args: schemaArgs(cobra.ExactArgs(2)),
+	RunE: runWhichCommand,
+}
+
+func init() {
+	rootCmd.AddCommand(whichCmd)
+	whichCmd.Flags().String("platform", "",
+		"Platform of a multi-arch image to inspect, e.g. linux/arm64 (default linux/amd64)")
+	whichCmd.Flags().Bool("schema", false,
+		"Print the JSON Schema of the --json output and exit")
+}
+
+// runWhichCommand implements the logic for the 'which' subcommand.
+func runWhichCommand(cmd *cobra.Command, args []string) error {
+	if printed, err := printSchema(cmd, "path-blame"); printed || err != nil {
+		return err
+	}
+	imageRef, filePath := args[0], args[1]
+	opts := &lib.WhichOptions{}
+	opts.Platform, _ = cmd.Flags().GetString("platform")
+	cmd.SilenceUsage = true
+
+	exporter, err := newExporter()
+	if err != nil {
+		return err
+	}
+	var blame *lib.PathBlame
+	err = withInteractiveAuth(exporter, imageRef, buildAuthConfig(), func(auth *lib.AuthConfig) (err error) {
+		blame, err = exporter.Which(imageRef, filePath, auth, opts)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s: %w", imageRef, err)
+	}
+	if jsonMode {
+		return printDocument(blame)
+	}
+	if len(blame.Changes) == 0 {
+		return fmt.Errorf("no layer of %s has %s", imageRef, blame.Path)
+	}
+
+	table := &table{header: []string{"LAYER", "DIGEST", "ACTION", "TYPE", "SIZE", "CREATED BY"}}
+	for i := len(blame.Changes) - 1; i >= 0; i-- {
+		change := blame.Changes[i]
+		// The last change comes first and stands out
+		var style string
+		if i == len(blame.Changes)-1 {
+			style = styleBold
+			if change.Action == lib.PathDeleted {
+				style = styleRed
+			}
+		}
+		size := ""
+		if change.Size > 0 {
+			size = formatBytes(change.Size)
+		}
+		table.add(cell{text: strconv.Itoa(change.Layer), style: style}, cell{text: shortDigest(change.Digest)},
+			cell{text: change.Action, style: style}, cell{text: change.Type}, cell{text: size},
+			cell{text: change.CreatedBy})
+	}
+	table.render(newTerminal(os.Stdout))
+	if !blame.Exists {
+		printLine(os.Stderr, "%s is not in the filesystem of %s", blame.Path, imageRef)
+	}
+	return nil
+}
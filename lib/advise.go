@@ -0,0 +1,396 @@
+package lib
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// RetentionPolicy decides which tags of a repository are kept by AdviseRetention.
+// A digest is kept when any of its tags is kept by any rule.
+type RetentionPolicy struct {
+	// KeepLast keeps the N most recently created digests
+	KeepLast int `json:"keep_last,omitempty"`
+
+	// KeepSemver keeps the N highest semantic version tags (e.g. v1.2.3, 1.2.3-rc.1)
+	KeepSemver int `json:"keep_semver,omitempty"`
+
+	// KeepTags keeps tags matching any of these patterns (path.Match syntax, e.g. "release-*")
+	KeepTags []string `json:"keep_tags,omitempty"`
+}
+
+// RetentionCandidate is one manifest in a retention plan with every tag pointing at it
+type RetentionCandidate struct {
+	// Digest is the manifest digest
+	Digest string `json:"digest"`
+
+	// Tags lists the tags pointing at the digest, sorted
+	Tags []string `json:"tags"`
+
+	// Created is the image creation time; for indexes, the newest child image
+	Created time.Time `json:"created,omitempty"`
+
+	// Reason explains why the digest is kept or deleted
+	Reason string `json:"reason"`
+}
+
+// RetentionPlan is the result of AdviseRetention. Deleting every Delete
+// candidate by digest applies the policy; nothing is deleted by imgex itself.
+type RetentionPlan struct {
+	// SchemaVersion is the version of this JSON document (see SchemaVersion)
+	SchemaVersion int `json:"schema_version"`
+
+	// Repository is the advised repository
+	Repository string `json:"repository"`
+
+	// Policy is the policy the plan was computed with
+	Policy RetentionPolicy `json:"policy"`
+
+	// Keep lists the digests the policy keeps
+	Keep []RetentionCandidate `json:"keep"`
+
+	// Delete lists the digests safe to delete, signatures and attestations first
+	Delete []RetentionCandidate `json:"delete"`
+}
+
+// References returns a repository@digest reference for each deletion, in plan order
+func (p *RetentionPlan) References() []string {
+	refs := make([]string, 0, len(p.Delete))
+	for _, candidate := range p.Delete {
+		refs = append(refs, p.Repository+"@"+candidate.Digest)
+	}
+	return refs
+}
+
+// attachedTagPattern matches the tag schema cosign and other tools use to attach
+// signatures, attestations and SBOMs to a subject digest (sha256-<hex>.sig)
+var attachedTagPattern = regexp.MustCompile(`^(sha256)-([a-f0-9]{64})(\..+)?$`)
+
+// semverPattern matches MAJOR.MINOR.PATCH tags with an optional v prefix and pre-release
+var semverPattern = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// AdviseRetention computes which tags and digests of a repository can be deleted
+// under a retention policy, without deleting anything.
+//
+// Every tag is resolved to its digest; the digest is kept when any of its tags
+// is kept by the policy. Digests are never offered for deletion while they are
+// still referenced: manifests listed in a kept index stay, as do signatures and
+// attestations attached (as sha256-<hex>.* tags) to a kept digest. Attachments
+// of deleted or missing subjects are deleted with them.
+//
+// Parameters:
+//   - repository: Repository name (e.g., "registry.com/org/image")
+//   - auth: Optional authentication configuration for private registries
+//   - policy: What to keep; a policy that keeps nothing is rejected
+//
+// Returns:
+//   - *RetentionPlan: Digests to keep and to delete
+//   - error: Any error encountered while listing or resolving tags
+//
+// Example:
+//
+//	plan, err := exporter.AdviseRetention("registry.com/org/image", nil, &RetentionPolicy{KeepLast: 10, KeepSemver: 3})
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	for _, ref := range plan.References() {
+//	    fmt.Println(ref)
+//	}
+func (e *imageExporter) AdviseRetention(repository string, auth *AuthConfig, policy *RetentionPolicy) (*RetentionPlan, error) {
+	if policy == nil || (policy.KeepLast <= 0 && policy.KeepSemver <= 0 && len(policy.KeepTags) == 0) {
+		return nil, fmt.Errorf("retention policy keeps nothing; set keep-last, keep-semver or keep-tags")
+	}
+	for _, pattern := range policy.KeepTags {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid tag pattern %q: %w", pattern, err)
+		}
+	}
+
+	repo, err := e.parseRepository(repository)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse repository %s: %w", repository, err)
+	}
+
+	tags, err := e.ListTags(repository, auth, nil)
+	if err != nil {
+		return nil, err
+	}
+	var allTags []string
+	for tags.HasNext() {
+		page, err := tags.Next()
+		if err != nil {
+			return nil, err
+		}
+		allTags = append(allTags, page...)
+	}
+
+	// Resolve tags to digests, setting attachment tags aside
+	candidates := make(map[string]*RetentionCandidate)
+	children := make(map[string]string) // child digest -> index digest
+	attached := make(map[string][]string)
+	var subjects []string
+	for _, tag := range allTags {
+		if m := attachedTagPattern.FindStringSubmatch(tag); m != nil {
+			subject := m[1] + ":" + m[2]
+			if _, ok := attached[subject]; !ok {
+				subjects = append(subjects, subject)
+			}
+			attached[subject] = append(attached[subject], tag)
+			continue
+		}
+		if err := e.resolveRetentionTag(repo.Tag(tag), auth, candidates, children); err != nil {
+			return nil, err
+		}
+	}
+
+	ordered := make([]*RetentionCandidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		sort.Strings(candidate.Tags)
+		ordered = append(ordered, candidate)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if !ordered[i].Created.Equal(ordered[j].Created) {
+			return ordered[i].Created.After(ordered[j].Created)
+		}
+		return ordered[i].Digest < ordered[j].Digest
+	})
+
+	// Apply the policy rules; the first matching rule is the reason
+	reasons := make(map[string]string)
+	for i, candidate := range ordered {
+		if i < policy.KeepLast {
+			reasons[candidate.Digest] = fmt.Sprintf("keep-last: #%d most recent", i+1)
+		}
+	}
+	for _, tag := range highestSemverTags(allTags, policy.KeepSemver) {
+		for _, candidate := range ordered {
+			if _, kept := reasons[candidate.Digest]; !kept && containsString(candidate.Tags, tag) {
+				reasons[candidate.Digest] = "keep-semver: " + tag
+			}
+		}
+	}
+	for _, candidate := range ordered {
+		if _, kept := reasons[candidate.Digest]; kept {
+			continue
+		}
+		for _, tag := range candidate.Tags {
+			if matchesAnyPattern(policy.KeepTags, tag) {
+				reasons[candidate.Digest] = "keep-tags: " + tag
+				break
+			}
+		}
+	}
+
+	// Manifests listed in a kept index are still referenced
+	for child, index := range children {
+		if _, kept := reasons[index]; kept {
+			if _, ok := reasons[child]; !ok {
+				reasons[child] = "referenced by index " + index
+			}
+		}
+	}
+
+	plan := &RetentionPlan{SchemaVersion: SchemaVersion, Repository: repo.String(), Policy: *policy, Keep: []RetentionCandidate{}, Delete: []RetentionCandidate{}}
+	var deletions []RetentionCandidate
+	for _, candidate := range ordered {
+		if reason, kept := reasons[candidate.Digest]; kept {
+			candidate.Reason = reason
+			plan.Keep = append(plan.Keep, *candidate)
+		} else {
+			candidate.Reason = "not kept by policy"
+			deletions = append(deletions, *candidate)
+		}
+	}
+
+	// Attachments follow their subject and are deleted before it
+	for _, subject := range subjects {
+		kept := reasons[subject] != ""
+		for _, tag := range attached[subject] {
+			desc, err := remote.Head(repo.Tag(tag), e.remoteOptions(auth)...)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve %s: %w", repo.Tag(tag), err)
+			}
+			candidate := RetentionCandidate{Digest: desc.Digest.String(), Tags: []string{tag}}
+			if kept {
+				candidate.Reason = "attached to kept " + subject
+				plan.Keep = append(plan.Keep, candidate)
+			} else {
+				candidate.Reason = "attached to deleted " + subject
+				if candidates[subject] == nil && children[subject] == "" {
+					candidate.Reason = "attached to missing " + subject
+				}
+				plan.Delete = append(plan.Delete, candidate)
+			}
+		}
+	}
+	plan.Delete = append(plan.Delete, deletions...)
+
+	return plan, nil
+}
+
+// resolveRetentionTag records the digest and creation time of a tag, and the
+// children of an index
+func (e *imageExporter) resolveRetentionTag(tag name.Tag, auth *AuthConfig, candidates map[string]*RetentionCandidate, children map[string]string) error {
+	desc, err := remote.Get(tag, e.remoteOptions(auth)...)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", tag, err)
+	}
+	digest := desc.Digest.String()
+	if candidate, ok := candidates[digest]; ok {
+		candidate.Tags = append(candidate.Tags, tag.TagStr())
+		return nil
+	}
+	candidate := &RetentionCandidate{Digest: digest, Tags: []string{tag.TagStr()}}
+	candidates[digest] = candidate
+
+	if !desc.MediaType.IsIndex() {
+		// Artifacts without an image config have no creation time and sort last
+		if image, err := desc.Image(); err == nil {
+			if config, err := e.withCache(image).ConfigFile(); err == nil {
+				candidate.Created = config.Created.Time
+			}
+		}
+		return nil
+	}
+
+	index, err := desc.ImageIndex()
+	if err != nil {
+		return fmt.Errorf("failed to read index %s: %w", tag, err)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return fmt.Errorf("failed to read index %s: %w", tag, err)
+	}
+	for _, child := range manifest.Manifests {
+		children[child.Digest.String()] = digest
+		if !child.MediaType.IsImage() {
+			continue
+		}
+		image, err := index.Image(child.Digest)
+		if err != nil {
+			continue
+		}
+		if config, err := e.withCache(image).ConfigFile(); err == nil && config.Created.After(candidate.Created) {
+			candidate.Created = config.Created.Time
+		}
+	}
+	return nil
+}
+
+// semver is a parsed semantic version tag
+type semver struct {
+	major, minor, patch uint64
+	prerelease          string
+}
+
+// parseSemver parses a semantic version tag, reporting whether it is one
+func parseSemver(tag string) (semver, bool) {
+	m := semverPattern.FindStringSubmatch(tag)
+	if m == nil {
+		return semver{}, false
+	}
+	var v semver
+	var err error
+	if v.major, err = strconv.ParseUint(m[1], 10, 64); err != nil {
+		return semver{}, false
+	}
+	if v.minor, err = strconv.ParseUint(m[2], 10, 64); err != nil {
+		return semver{}, false
+	}
+	if v.patch, err = strconv.ParseUint(m[3], 10, 64); err != nil {
+		return semver{}, false
+	}
+	v.prerelease = m[4]
+	return v, true
+}
+
+// less orders versions by semver precedence
+func (v semver) less(o semver) bool {
+	if v.major != o.major {
+		return v.major < o.major
+	}
+	if v.minor != o.minor {
+		return v.minor < o.minor
+	}
+	if v.patch != o.patch {
+		return v.patch < o.patch
+	}
+	// A pre-release sorts before its release
+	if v.prerelease == "" || o.prerelease == "" {
+		return v.prerelease != "" && o.prerelease == ""
+	}
+	a, b := strings.Split(v.prerelease, "."), strings.Split(o.prerelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		an, aErr := strconv.ParseUint(a[i], 10, 64)
+		bn, bErr := strconv.ParseUint(b[i], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			return an < bn
+		case aErr == nil:
+			return true
+		case bErr == nil:
+			return false
+		default:
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// highestSemverTags returns the n highest semantic version tags, highest first
+func highestSemverTags(tags []string, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	type versionedTag struct {
+		tag     string
+		version semver
+	}
+	var versions []versionedTag
+	for _, tag := range tags {
+		if v, ok := parseSemver(tag); ok {
+			versions = append(versions, versionedTag{tag, v})
+		}
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[j].version.less(versions[i].version)
+	})
+	if len(versions) > n {
+		versions = versions[:n]
+	}
+	result := make([]string, len(versions))
+	for i, v := range versions {
+		result[i] = v.tag
+	}
+	return result
+}
+
+// matchesAnyPattern reports whether s matches any path.Match pattern
+func matchesAnyPattern(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// newDatedTestImage builds a single-layer image created on the given day of 2024
+func newDatedTestImage(t *testing.T, content string, day int) v1.Image {
+	t.Helper()
+
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "id", content: content}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	image, err = mutate.CreatedAt(image, v1.Time{Time: time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatalf("Failed to set creation time: %v", err)
+	}
+	return image
+}
+
+// testDigest returns the digest of a test image
+func testDigest(t *testing.T, image v1.Image) string {
+	t.Helper()
+
+	digest, err := image.Digest()
+	if err != nil {
+		t.Fatalf("Failed to get digest: %v", err)
+	}
+	return digest.String()
+}
+
+func TestAdviseRetention(t *testing.T) {
+	host := newTestRegistry(t)
+	repo := host + "/team/app"
+
+	img1 := newDatedTestImage(t, "1", 1)
+	img2 := newDatedTestImage(t, "2", 2)
+	img3 := newDatedTestImage(t, "3", 3)
+	img4 := newDatedTestImage(t, "4", 4)
+	img5 := newDatedTestImage(t, "5", 5)
+	img6 := newDatedTestImage(t, "6", 6)
+	for tag, image := range map[string]v1.Image{
+		"v1.0.0":      img1,
+		"v1.1.0":      img2,
+		"dev-2":       img2,
+		"dev-3":       img3,
+		"v2.0.0-rc.1": img4,
+		"v2.0.0":      img5,
+		"latest":      img5,
+	} {
+		pushTestImage(t, repo+":"+tag, image)
+	}
+
+	// An index listing a tagged image keeps it referenced
+	index := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: img3, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+		mutate.IndexAddendum{Add: img6, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64"}}},
+	)
+	ref, err := name.ParseReference(repo + ":multi")
+	if err != nil {
+		t.Fatalf("Failed to parse reference: %v", err)
+	}
+	if err := remote.WriteIndex(ref, index); err != nil {
+		t.Fatalf("Failed to push test index: %v", err)
+	}
+	indexDigest, err := index.Digest()
+	if err != nil {
+		t.Fatalf("Failed to get index digest: %v", err)
+	}
+
+	// Signatures of a deleted and a missing subject
+	sig1 := newDatedTestImage(t, "sig1", 1)
+	sigMissing := newDatedTestImage(t, "sig-missing", 1)
+	pushTestImage(t, repo+":sha256-"+strings.TrimPrefix(testDigest(t, img1), "sha256:")+".sig", sig1)
+	pushTestImage(t, repo+":sha256-"+strings.Repeat("0", 64)+".sig", sigMissing)
+
+	// A signature of a kept digest stays
+	sig5 := newDatedTestImage(t, "sig5", 5)
+	pushTestImage(t, repo+":sha256-"+strings.TrimPrefix(testDigest(t, img5), "sha256:")+".sig", sig5)
+
+	plan, err := NewImageExporter().AdviseRetention(repo, nil, &RetentionPolicy{KeepLast: 2, KeepSemver: 2})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	kept := make(map[string]string)
+	for _, candidate := range plan.Keep {
+		kept[candidate.Digest] = candidate.Reason
+	}
+	for digest, reason := range map[string]string{
+		indexDigest.String(): "keep-last: #1 most recent",
+		testDigest(t, img5):  "keep-last: #2 most recent",
+		testDigest(t, img4):  "keep-semver: v2.0.0-rc.1",
+		testDigest(t, img3):  "referenced by index " + indexDigest.String(),
+		testDigest(t, sig5):  "attached to kept " + testDigest(t, img5),
+	} {
+		if kept[digest] != reason {
+			t.Errorf("Expected %s kept with %q, got %q", digest, reason, kept[digest])
+		}
+	}
+
+	var deleted []string
+	for _, candidate := range plan.Delete {
+		deleted = append(deleted, candidate.Digest)
+	}
+	signatures := map[string]bool{testDigest(t, sig1): true, testDigest(t, sigMissing): true}
+	if len(deleted) != 4 || !signatures[deleted[0]] || !signatures[deleted[1]] ||
+		deleted[2] != testDigest(t, img2) || deleted[3] != testDigest(t, img1) {
+		t.Errorf("Expected signatures, then %s and %s to be deleted, got %v", testDigest(t, img2), testDigest(t, img1), deleted)
+	}
+	for _, candidate := range plan.Delete {
+		if candidate.Digest == testDigest(t, img2) && fmt.Sprint(candidate.Tags) != "[dev-2 v1.1.0]" {
+			t.Errorf("Expected both tags of the deleted digest, got %v", candidate.Tags)
+		}
+	}
+
+	refs := plan.References()
+	if len(refs) != 4 || refs[3] != repo+"@"+testDigest(t, img1) {
+		t.Errorf("Expected repository@digest references, got %v", refs)
+	}
+}
+
+func TestAdviseRetentionRejectsEmptyPolicy(t *testing.T) {
+	_, err := NewImageExporter().AdviseRetention("registry.example.com/app", nil, &RetentionPolicy{})
+	if err == nil || !strings.Contains(err.Error(), "keeps nothing") {
+		t.Errorf("Expected an empty policy to be rejected, got %v", err)
+	}
+}
+
+func TestHighestSemverTags(t *testing.T) {
+	tags := []string{"latest", "1.2.3", "v1.10.0", "v1.9.9", "2.0.0-rc.2", "2.0.0-rc.10", "2.0.0-alpha", "1.2", "v01.0.0"}
+	expected := "[2.0.0-rc.10 2.0.0-rc.2 2.0.0-alpha v1.10.0]"
+	if got := fmt.Sprint(highestSemverTags(tags, 4)); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
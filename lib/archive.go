@@ -0,0 +1,123 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// DockerArchivePrefix marks an image reference as a tarball written by
+// 'docker save' rather than an image in a registry:
+//
+//	docker-archive:app.tar          the only image of the archive
+//	docker-archive:app.tar:app:v1   the image tagged app:v1 in the archive
+//
+// Such references are accepted wherever an image configuration or filesystem
+// is read (GetImageConfig, the filesystem exports and extraction) and never
+// contact a registry.
+const DockerArchivePrefix = "docker-archive:"
+
+// openImage returns the image of imageRef: the image of a docker-archive:
+// tarball, oci: layout, containerd: store or docker-daemon:, or the image
+// fetched from its registry (see WithImageSource), resolving an index to
+// platform (linux/amd64 when nil)
+func (e *imageExporter) openImage(imageRef string, auth *AuthConfig, platform *v1.Platform) (v1.Image, error) {
+	var local func(string, *v1.Platform) (v1.Image, error)
+	switch {
+	case strings.HasPrefix(imageRef, DockerArchivePrefix):
+		local = dockerArchiveImage
+	case strings.HasPrefix(imageRef, OCILayoutPrefix):
+		local = ociLayoutImage
+	case strings.HasPrefix(imageRef, ContainerdPrefix):
+		local = e.containerdStore().image
+	case strings.HasPrefix(imageRef, DockerDaemonPrefix):
+		local = e.daemonImage
+	case e.source == ImageSourceDaemon:
+		imageRef = DockerDaemonPrefix + imageRef
+		local = e.daemonImage
+	}
+	if local != nil {
+		if err := e.checkLocalSource(imageRef); err != nil {
+			return nil, err
+		}
+	}
+	if local != nil && e.verifiesSignatures() {
+		return nil, fmt.Errorf("signatures can only be verified for registry images, not %s: %w", imageRef, ErrSignatureVerification)
+	}
+	if local != nil {
+		e.log().Debug("reading local image", "image", imageRef)
+		source := imageRef[strings.Index(imageRef, ":")+1:]
+		image, err := local(source, platform)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image %s: %w", imageRef, err)
+		}
+		return image, nil
+	}
+
+	ref, err := e.parseReference(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image reference %s: %w", imageRef, err)
+	}
+	e.log().Debug("fetching manifest", "image", imageRef, "platform", platform)
+	image, err := e.remoteImage(ref, auth, platform)
+	if err != nil && e.source == ImageSourceAuto && !e.verifiesSignatures() && e.localSourcesAllowed() {
+		e.log().Debug("falling back to the docker daemon", "image", imageRef, "error", err)
+		fallback, daemonErr := e.daemonImage(imageRef, platform)
+		if daemonErr == nil {
+			return fallback, nil
+		}
+		err = fmt.Errorf("%w (fallback: %v)", err, daemonErr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image %s: %w", imageRef, err)
+	}
+	e.logManifest(imageRef, image)
+	return e.withCache(image), nil
+}
+
+// dockerArchiveImage loads an image from a 'docker save' tarball given as
+// path[:reference]. Layers are read from the file as they are needed, so the
+// archive is never held in memory. The archive holds no index, so a platform
+// only checks that the image was built for it.
+func dockerArchiveImage(source string, platform *v1.Platform) (v1.Image, error) {
+	path, tagName, hasTag := strings.Cut(source, ":")
+	if path == "" {
+		return nil, fmt.Errorf("missing archive path")
+	}
+	var tag *name.Tag
+	if hasTag {
+		parsed, err := name.NewTag(tagName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag %q: %w", tagName, err)
+		}
+		tag = &parsed
+	}
+
+	image, err := tarball.ImageFromPath(path, tag)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkImagePlatform(image, platform); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// checkImagePlatform fails unless image was built for platform (any when nil),
+// for local images that cannot be resolved from an index
+func checkImagePlatform(image v1.Image, platform *v1.Platform) error {
+	if platform == nil {
+		return nil
+	}
+	configFile, err := image.ConfigFile()
+	if err != nil {
+		return fmt.Errorf("failed to get config file: %w", err)
+	}
+	if actual := configFile.Platform(); !platformMatches(actual, *platform) {
+		return fmt.Errorf("the image is %s/%s, not %s", configFile.OS, configFile.Architecture, platform)
+	}
+	return nil
+}
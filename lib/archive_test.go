@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// writeTestArchive writes images to a tarball in the format of 'docker save'
+func writeTestArchive(t *testing.T, images map[string]v1.Image) string {
+	t.Helper()
+	refs := make(map[name.Reference]v1.Image)
+	for tag, image := range images {
+		ref, err := name.NewTag(tag)
+		if err != nil {
+			t.Fatalf("Invalid tag %s: %v", tag, err)
+		}
+		refs[ref] = image
+	}
+	path := filepath.Join(t.TempDir(), "images.tar")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	defer file.Close()
+	if err := tarball.MultiRefWrite(refs, file); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	return path
+}
+
+func TestDockerArchive(t *testing.T) {
+	app, err := mutate.AppendLayers(empty.Image,
+		newTestLayer(t, testEntry{name: "etc/motd", content: "hello"}),
+		newTestLayer(t, testEntry{name: "etc/motd", content: "world"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	app, err = mutate.Config(app, v1.Config{Entrypoint: []string{"/app"}})
+	if err != nil {
+		t.Fatalf("Failed to set config: %v", err)
+	}
+	single := writeTestArchive(t, map[string]v1.Image{"app:v1": app})
+
+	// No registry is ever contacted
+	exporter := NewImageExporter(WithOffline())
+	config, err := exporter.GetImageConfig(DockerArchivePrefix+single, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(config.Entrypoint, []string{"/app"}) {
+		t.Errorf("Expected entrypoint [/app], got %v", config.Entrypoint)
+	}
+
+	var out bytes.Buffer
+	if err := exporter.ExportImageFilesystemToWriter(DockerArchivePrefix+single, &out, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if files := readTestTar(t, out.Bytes()); files["etc/motd"] != "world" {
+		t.Errorf("Expected the flattened filesystem, got %v", files)
+	}
+
+	content, _, err := exporter.OpenFile(DockerArchivePrefix+single, "etc/motd", nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	data, _ := io.ReadAll(content)
+	content.Close()
+	if string(data) != "world" {
+		t.Errorf("Expected world, got %q", data)
+	}
+
+	// Archives of several images need the tag
+	other, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "etc/motd", content: "other"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	multi := writeTestArchive(t, map[string]v1.Image{"app:v1": app, "example.com/other:v2": other})
+	if _, err := exporter.GetImageConfig(DockerArchivePrefix+multi, nil); err == nil {
+		t.Error("Expected an error without a tag for an archive of several images")
+	}
+	out.Reset()
+	if err := exporter.ExportImageFilesystemToWriter(DockerArchivePrefix+multi+":example.com/other:v2", &out, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if files := readTestTar(t, out.Bytes()); files["etc/motd"] != "other" {
+		t.Errorf("Expected the filesystem of example.com/other:v2, got %v", files)
+	}
+
+	err = exporter.ExportImageFilesystemToWriterWithOptions(DockerArchivePrefix+single, io.Discard, nil, &ExportOptions{Platform: "linux/s390x"})
+	if err == nil {
+		t.Error("Expected an error for a platform the image was not built for")
+	}
+	if _, err := exporter.GetImageConfig(DockerArchivePrefix+filepath.Join(t.TempDir(), "missing.tar"), nil); err == nil {
+		t.Error("Expected an error for a missing archive")
+	}
+}
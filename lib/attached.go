@@ -0,0 +1,197 @@
+package lib
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Where attached artifacts were found, see SBOM.Source
+const (
+	SourceReferrer            = "referrer"
+	SourceCosignAttachment    = "cosign-attachment"
+	SourceCosignAttestation   = "cosign-attestation"
+	SourceBuildKitAttestation = "buildkit-attestation"
+)
+
+// Media types of attestation layers
+const (
+	inTotoMediaType = "application/vnd.in-toto+json"
+	dsseMediaType   = "application/vnd.dsse.envelope.v1+json"
+)
+
+// maxAttachedBlob bounds the attached documents read into memory
+const maxAttachedBlob = 256 << 20
+
+// attachedLayer is one layer of an artifact attached to an image
+type attachedLayer struct {
+	source   string      // one of the Source constants
+	subject  v1.Hash     // manifest digest the artifact is attached to
+	manifest name.Digest // artifact manifest
+	layer    v1.Descriptor
+}
+
+// predicateType returns the in-toto predicate type of an attestation layer,
+// as annotated by cosign and BuildKit, empty if it is not annotated
+func (a *attachedLayer) predicateType() string {
+	if t := a.layer.Annotations["predicateType"]; t != "" {
+		return t
+	}
+	return a.layer.Annotations["in-toto.io/predicate-type"]
+}
+
+// attachedLayers lists the layers of the artifacts attached to subject in
+// repo: OCI referrers, the sha256-<hex>.sbom and .att tags of cosign, and the
+// attestation manifests BuildKit adds to index
+func (e *imageExporter) attachedLayers(repo name.Repository, subject v1.Hash, index *v1.IndexManifest, auth *AuthConfig) ([]attachedLayer, error) {
+	var layers []attachedLayer
+	add := func(source string, manifest name.Digest) error {
+		desc, err := e.getRemote(manifest, e.remoteOptions(auth)...)
+		if err != nil {
+			return fmt.Errorf("failed to fetch %s: %w", manifest, err)
+		}
+		if desc.MediaType.IsIndex() {
+			return nil
+		}
+		image, err := desc.Image()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", manifest, err)
+		}
+		m, err := image.Manifest()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", manifest, err)
+		}
+		for _, layer := range m.Layers {
+			layers = append(layers, attachedLayer{source: source, subject: subject, manifest: manifest, layer: layer})
+		}
+		return nil
+	}
+
+	referrers, err := remote.Referrers(repo.Digest(subject.String()), e.remoteOptions(auth)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list referrers of %s@%s: %w", repo, subject, err)
+	}
+	manifest, err := referrers.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list referrers of %s@%s: %w", repo, subject, err)
+	}
+	for _, desc := range manifest.Manifests {
+		if err := add(SourceReferrer, repo.Digest(desc.Digest.String())); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, attached := range []struct{ suffix, source string }{
+		{".sbom", SourceCosignAttachment},
+		{".att", SourceCosignAttestation},
+	} {
+		tag := repo.Tag(subject.Algorithm + "-" + subject.Hex + attached.suffix)
+		desc, err := e.getRemote(tag, e.remoteOptions(auth)...)
+		if IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %w", tag, err)
+		}
+		if err := add(attached.source, repo.Digest(desc.Digest.String())); err != nil {
+			return nil, err
+		}
+	}
+
+	if index != nil {
+		for _, child := range index.Manifests {
+			if child.Annotations[annotationReferenceType] == "attestation-manifest" &&
+				child.Annotations[annotationReferenceDigest] == subject.String() {
+				if err := add(SourceBuildKitAttestation, repo.Digest(child.Digest.String())); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return layers, nil
+}
+
+// readAttachedLayer reads the content of an attached layer
+func (e *imageExporter) readAttachedLayer(a attachedLayer, auth *AuthConfig) ([]byte, error) {
+	desc, err := e.getRemote(a.manifest, e.remoteOptions(auth)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", a.manifest, err)
+	}
+	image, err := desc.Image()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", a.manifest, err)
+	}
+	if a.layer.Size > maxAttachedBlob {
+		return nil, fmt.Errorf("%s is too large (%d bytes)", a.layer.Digest, a.layer.Size)
+	}
+	layer, err := image.LayerByDigest(a.layer.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", a.layer.Digest, err)
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", a.layer.Digest, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxAttachedBlob))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", a.layer.Digest, err)
+	}
+	return data, nil
+}
+
+// inTotoStatement is an in-toto attestation statement
+type inTotoStatement struct {
+	Type    string `json:"_type"`
+	Subject []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate"`
+}
+
+// parseStatement returns the in-toto statement of an attestation layer: the
+// statement itself, or the payload of a DSSE envelope. The envelope
+// signatures are not verified.
+func parseStatement(mediaType string, data []byte) (*inTotoStatement, error) {
+	if mediaType == dsseMediaType {
+		var envelope struct {
+			PayloadType string `json:"payloadType"`
+			Payload     string `json:"payload"`
+		}
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			return nil, fmt.Errorf("invalid DSSE envelope: %w", err)
+		}
+		if envelope.PayloadType != inTotoMediaType {
+			return nil, fmt.Errorf("unknown DSSE payload type %q", envelope.PayloadType)
+		}
+		payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DSSE payload: %w", err)
+		}
+		data = payload
+	} else if mediaType != inTotoMediaType {
+		return nil, fmt.Errorf("%s is not an attestation", mediaType)
+	}
+	var statement inTotoStatement
+	if err := json.Unmarshal(data, &statement); err != nil {
+		return nil, fmt.Errorf("invalid in-toto statement: %w", err)
+	}
+	if len(statement.Predicate) == 0 {
+		return nil, errors.New("in-toto statement has no predicate")
+	}
+	return &statement, nil
+}
+
+// isAttestationLayer reports whether a layer holds an in-toto statement,
+// possibly in a DSSE envelope
+func isAttestationLayer(mediaType string) bool {
+	return mediaType == inTotoMediaType || mediaType == dsseMediaType
+}
@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+// AttestationError is returned when a reference names attestations, signatures
+// or SBOMs attached to an image rather than an image with a filesystem, such as
+// a provenance-only index or a cosign .att tag. Exporting it would produce an
+// empty archive, so the image the attestations describe is suggested instead.
+type AttestationError struct {
+	// Reference is the reference that was requested
+	Reference string
+
+	// Subject is the repository@digest reference of the image the
+	// attestations describe, empty if it is not recorded
+	Subject string
+}
+
+// Error implements the error interface
+func (e *AttestationError) Error() string {
+	if e.Subject == "" {
+		return fmt.Sprintf("%s holds only attestations, no filesystem", e.Reference)
+	}
+	return fmt.Sprintf("%s holds only attestations, no filesystem; export the image they describe: %s", e.Reference, e.Subject)
+}
+
+// BuildKit marks the attestation manifests it adds to an index with these
+// annotations, the second naming the image they describe
+const (
+	annotationReferenceType   = "vnd.docker.reference.type"
+	annotationReferenceDigest = "vnd.docker.reference.digest"
+)
+
+// attestationMediaTypes are layer media types of in-toto attestations, DSSE
+// envelopes, sigstore signatures and bundles, and SBOM documents
+var attestationMediaTypes = []string{
+	"application/vnd.in-toto",
+	"application/vnd.dsse.envelope",
+	"application/vnd.dev.cosign.",
+	"application/vnd.dev.sigstore.",
+	"application/spdx",
+	"application/vnd.cyclonedx",
+	"application/vnd.syft",
+}
+
+// isAttestationMediaType reports whether a layer media type holds attestation
+// data instead of a filesystem tar
+func isAttestationMediaType(mediaType string) bool {
+	for _, prefix := range attestationMediaTypes {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAttestationIndex fails with an *AttestationError when every image of
+// an index is an attestation manifest
+func checkAttestationIndex(ref name.Reference, manifest *v1.IndexManifest) error {
+	subject, images := "", 0
+	for _, child := range manifest.Manifests {
+		if !child.MediaType.IsImage() {
+			continue
+		}
+		images++
+		attestation := child.Annotations[annotationReferenceType] == "attestation-manifest" ||
+			(child.Platform != nil && child.Platform.OS == "unknown" && child.Platform.Architecture == "unknown")
+		if !attestation {
+			return nil
+		}
+		if subject == "" {
+			subject = child.Annotations[annotationReferenceDigest]
+		}
+	}
+	if images == 0 {
+		return nil
+	}
+	return attestationError(ref, subject)
+}
+
+// checkAttestationImage fails with an *AttestationError when every layer of an
+// image holds attestation data
+func checkAttestationImage(ref name.Reference, image v1.Image) error {
+	manifest, err := image.Manifest()
+	if err != nil || len(manifest.Layers) == 0 {
+		return nil
+	}
+	for _, layer := range manifest.Layers {
+		if !isAttestationMediaType(string(layer.MediaType)) {
+			return nil
+		}
+	}
+
+	// OCI 1.1 artifacts name their subject; cosign tags encode it as sha256-<hex>.att
+	subject := ""
+	if manifest.Subject != nil {
+		subject = manifest.Subject.Digest.String()
+	} else if tag, ok := ref.(name.Tag); ok {
+		if m := attachedTagPattern.FindStringSubmatch(tag.TagStr()); m != nil {
+			subject = m[1] + ":" + m[2]
+		}
+	}
+	return attestationError(ref, subject)
+}
+
+// attestationError builds the error for an attestation reference, pointing at
+// the subject digest in the same repository
+func attestationError(ref name.Reference, subject string) error {
+	err := &AttestationError{Reference: ref.String()}
+	if subject != "" {
+		err.Subject = ref.Context().Digest(subject).String()
+	}
+	return err
+}
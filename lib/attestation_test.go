@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// newTestAttestation builds an image holding one in-toto statement
+func newTestAttestation(t *testing.T) v1.Image {
+	t.Helper()
+	layer := static.NewLayer([]byte(`{"_type": "https://in-toto.io/Statement/v1"}`), "application/vnd.in-toto+json")
+	image, err := mutate.AppendLayers(mutate.MediaType(empty.Image, types.OCIManifestSchema1), layer)
+	if err != nil {
+		t.Fatalf("Failed to build test attestation: %v", err)
+	}
+	return image
+}
+
+func TestExportAttestationIndex(t *testing.T) {
+	host := newTestRegistry(t)
+	subject := "sha256:" + strings.Repeat("a", 64)
+	index := mutate.AppendManifests(mutate.IndexMediaType(empty.Index, types.OCIImageIndex), mutate.IndexAddendum{
+		Add: newTestAttestation(t),
+		Descriptor: v1.Descriptor{
+			Platform: &v1.Platform{OS: "unknown", Architecture: "unknown"},
+			Annotations: map[string]string{
+				annotationReferenceType:   "attestation-manifest",
+				annotationReferenceDigest: subject,
+			},
+		},
+	})
+	imageRef := host + "/test/provenance:latest"
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		t.Fatalf("Failed to parse test reference: %v", err)
+	}
+	if err := remote.WriteIndex(ref, index); err != nil {
+		t.Fatalf("Failed to push test index: %v", err)
+	}
+
+	exporter := NewImageExporter()
+	var buf bytes.Buffer
+	err = exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &buf, nil, &ExportOptions{})
+	var attestationErr *AttestationError
+	if !errors.As(err, &attestationErr) {
+		t.Fatalf("Expected an AttestationError, got %v", err)
+	}
+	if expected := host + "/test/provenance@" + subject; attestationErr.Subject != expected {
+		t.Errorf("Expected subject %s, got %s", expected, attestationErr.Subject)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected no archive, got %d bytes", buf.Len())
+	}
+
+	digest, err := index.Digest()
+	if err != nil {
+		t.Fatalf("Failed to get index digest: %v", err)
+	}
+	if _, err := exporter.GetImageConfig(host+"/test/provenance@"+digest.String(), nil); !errors.As(err, &attestationErr) {
+		t.Errorf("Expected an AttestationError by digest, got %v", err)
+	}
+
+	// An index with a real platform still exports it
+	pushTestIndex(t, host+"/test/multiarch:latest")
+	if err := exporter.ExportImageFilesystemToWriter(host+"/test/multiarch:latest", &buf, nil); err != nil {
+		t.Errorf("Expected no error for a platform index, got %v", err)
+	}
+}
+
+func TestExportAttestationTag(t *testing.T) {
+	host := newTestRegistry(t)
+	subject := "sha256:" + strings.Repeat("b", 64)
+	imageRef := host + "/test/app:sha256-" + subject[len("sha256:"):] + ".att"
+	pushTestImage(t, imageRef, newTestAttestation(t))
+
+	exporter := NewImageExporter()
+	var buf bytes.Buffer
+	err := exporter.ExportImageFilesystemToWriter(imageRef, &buf, nil)
+	var attestationErr *AttestationError
+	if !errors.As(err, &attestationErr) {
+		t.Fatalf("Expected an AttestationError, got %v", err)
+	}
+	if expected := host + "/test/app@" + subject; attestationErr.Subject != expected {
+		t.Errorf("Expected subject %s, got %s", expected, attestationErr.Subject)
+	}
+}
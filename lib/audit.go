@@ -0,0 +1,270 @@
+package lib
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Kinds of AuditFinding
+const (
+	AuditSetuid        = "setuid"
+	AuditSetgid        = "setgid"
+	AuditWorldWritable = "world-writable"
+	AuditCapabilities  = "capabilities"
+)
+
+// xattrCapability is the PAX record of the security.capability extended
+// attribute, which holds the file capabilities set by setcap
+const xattrCapability = "SCHILY.xattr.security.capability"
+
+// capabilityNames are the Linux capabilities, by bit number
+var capabilityNames = []string{
+	"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_DAC_READ_SEARCH", "CAP_FOWNER",
+	"CAP_FSETID", "CAP_KILL", "CAP_SETGID", "CAP_SETUID", "CAP_SETPCAP",
+	"CAP_LINUX_IMMUTABLE", "CAP_NET_BIND_SERVICE", "CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN", "CAP_NET_RAW", "CAP_IPC_LOCK", "CAP_IPC_OWNER",
+	"CAP_SYS_MODULE", "CAP_SYS_RAWIO", "CAP_SYS_CHROOT", "CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT", "CAP_SYS_ADMIN", "CAP_SYS_BOOT", "CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE", "CAP_SYS_TIME", "CAP_SYS_TTY_CONFIG", "CAP_MKNOD",
+	"CAP_LEASE", "CAP_AUDIT_WRITE", "CAP_AUDIT_CONTROL", "CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE", "CAP_MAC_ADMIN", "CAP_SYSLOG", "CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND", "CAP_AUDIT_READ", "CAP_PERFMON", "CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+// dangerousCapabilities are the capabilities that let an unprivileged user
+// running the file gain root, escape the container or bypass file permissions
+var dangerousCapabilities = map[string]bool{
+	"CAP_CHOWN": true, "CAP_DAC_OVERRIDE": true, "CAP_DAC_READ_SEARCH": true,
+	"CAP_FOWNER": true, "CAP_FSETID": true, "CAP_SETGID": true, "CAP_SETUID": true,
+	"CAP_SETPCAP": true, "CAP_SETFCAP": true, "CAP_LINUX_IMMUTABLE": true,
+	"CAP_NET_ADMIN": true, "CAP_SYS_MODULE": true, "CAP_SYS_RAWIO": true,
+	"CAP_SYS_PTRACE": true, "CAP_SYS_ADMIN": true, "CAP_SYS_BOOT": true,
+	"CAP_SYS_TIME": true, "CAP_MKNOD": true, "CAP_MAC_OVERRIDE": true,
+	"CAP_MAC_ADMIN": true, "CAP_BPF": true,
+}
+
+// AuditOptions configures Audit
+type AuditOptions struct {
+	// Platform selects the image of a multi-platform index, e.g. "linux/arm64";
+	// empty for the default platform
+	Platform string
+}
+
+// AuditReport lists the files of an image that deserve a look in a hardening
+// review. It is returned by the Audit method.
+type AuditReport struct {
+	// SchemaVersion is the version of this JSON document (see SchemaVersion)
+	SchemaVersion int `json:"schema_version"`
+
+	// Reference is the image reference that was audited
+	Reference string `json:"reference"`
+
+	// Digest is the manifest digest of the image
+	Digest string `json:"digest"`
+
+	// Findings lists the files by path, then kind
+	Findings []AuditFinding `json:"findings"`
+}
+
+// AuditFinding is one file of an AuditReport
+type AuditFinding struct {
+	// Kind is AuditSetuid, AuditSetgid, AuditWorldWritable or AuditCapabilities
+	Kind string `json:"kind"`
+
+	// Path is the absolute path of the file in the image
+	Path string `json:"path"`
+
+	// Mode is the file mode as ls shows it, e.g. "-rwsr-xr-x"
+	Mode string `json:"mode"`
+
+	// UID and GID own the file
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+
+	// Capabilities are the permitted and inheritable file capabilities, e.g.
+	// "CAP_NET_RAW"; only for AuditCapabilities
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	// Dangerous lists the Capabilities that allow privilege escalation
+	Dangerous []string `json:"dangerous,omitempty"`
+}
+
+// Audit reports the files of the flattened filesystem of an image that widen
+// what a process of a container can do: setuid and setgid executables,
+// world-writable files and directories (except sticky directories like /tmp),
+// and executables with file capabilities, flagging the dangerous ones such as
+// CAP_SYS_ADMIN. Setgid directories, which only set the group of new files,
+// are not reported.
+//
+// Parameters:
+//   - imageRef: Image reference (e.g., "ghcr.io/org/app:v1")
+//   - auth: Optional authentication configuration for private registries
+//   - opts: Optional platform
+//
+// Returns:
+//   - *AuditReport: The findings, possibly none
+//   - error: Any error encountered during the operation
+//
+// Example:
+//
+//	report, err := exporter.Audit("ghcr.io/org/app:v1", nil, nil)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	for _, finding := range report.Findings {
+//	    fmt.Println(finding.Kind, finding.Path)
+//	}
+func (e *imageExporter) Audit(imageRef string, auth *AuthConfig, opts *AuditOptions) (*AuditReport, error) {
+	if opts == nil {
+		opts = &AuditOptions{}
+	}
+
+	image, filesystem, err := e.flattenImage(imageRef, auth, opts.Platform)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image digest: %w", err)
+	}
+
+	report := &AuditReport{
+		SchemaVersion: SchemaVersion,
+		Reference:     imageRef,
+		Digest:        digest.String(),
+		Findings:      []AuditFinding{},
+	}
+	paths := make([]string, 0, len(filesystem))
+	for p := range filesystem {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		findings, err := auditEntry(p, filesystem[p].header)
+		if err != nil {
+			return nil, err
+		}
+		report.Findings = append(report.Findings, findings...)
+	}
+	e.log().Debug("filesystem audited", "image", imageRef, "files", len(paths), "findings", len(report.Findings))
+	return report, nil
+}
+
+// auditEntry returns the findings of the entry at p
+func auditEntry(p string, header *tar.Header) ([]AuditFinding, error) {
+	regular := header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA || header.Typeflag == tar.TypeLink
+	dir := header.Typeflag == tar.TypeDir
+	if !regular && !dir {
+		return nil, nil
+	}
+	finding := AuditFinding{
+		Path: "/" + strings.TrimSuffix(p, "/"),
+		Mode: lsMode(header),
+		UID:  header.Uid,
+		GID:  header.Gid,
+	}
+
+	var findings []AuditFinding
+	add := func(kind string) {
+		f := finding
+		f.Kind = kind
+		findings = append(findings, f)
+	}
+	if regular && header.Mode&04000 != 0 {
+		add(AuditSetuid)
+	}
+	if regular && header.Mode&02000 != 0 {
+		add(AuditSetgid)
+	}
+	if header.Mode&0002 != 0 && !(dir && header.Mode&01000 != 0) {
+		add(AuditWorldWritable)
+	}
+	if value, ok := header.PAXRecords[xattrCapability]; ok && regular {
+		capabilities, err := parseFileCapabilities([]byte(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid capabilities of %s: %w", finding.Path, err)
+		}
+		finding.Capabilities = capabilities
+		for _, capability := range capabilities {
+			if dangerousCapabilities[capability] {
+				finding.Dangerous = append(finding.Dangerous, capability)
+			}
+		}
+		add(AuditCapabilities)
+	}
+	return findings, nil
+}
+
+// lsMode formats the mode of a file or directory as ls -l does, with the
+// setuid, setgid and sticky bits in place of the execute bits
+func lsMode(header *tar.Header) string {
+	mode := []byte("-rwxrwxrwx")
+	if header.Typeflag == tar.TypeDir {
+		mode[0] = 'd'
+	}
+	for i := 0; i < 9; i++ {
+		if header.Mode&(1<<(8-i)) == 0 {
+			mode[i+1] = '-'
+		}
+	}
+	for _, special := range []struct {
+		bit      int64
+		position int
+		char     byte
+	}{{04000, 3, 's'}, {02000, 6, 's'}, {01000, 9, 't'}} {
+		if header.Mode&special.bit == 0 {
+			continue
+		}
+		if mode[special.position] == '-' {
+			mode[special.position] = special.char - 'a' + 'A'
+		} else {
+			mode[special.position] = special.char
+		}
+	}
+	return string(mode)
+}
+
+// parseFileCapabilities decodes a security.capability attribute (struct
+// vfs_cap_data of revisions 1 to 3) into the names of its permitted and
+// inheritable capabilities
+func parseFileCapabilities(data []byte) ([]string, error) {
+	if len(data) < 4 {
+		return nil, errors.New("truncated capability data")
+	}
+	words := 1
+	switch revision := binary.LittleEndian.Uint32(data) & 0xff000000; revision {
+	case 0x01000000:
+	case 0x02000000, 0x03000000:
+		words = 2
+	default:
+		return nil, fmt.Errorf("unknown capability revision %#x", revision)
+	}
+	if len(data) < 4+8*words {
+		return nil, errors.New("truncated capability data")
+	}
+
+	// Each word holds the permitted then inheritable 32 capabilities
+	var set uint64
+	for word := 0; word < words; word++ {
+		permitted := binary.LittleEndian.Uint32(data[4+8*word:])
+		inheritable := binary.LittleEndian.Uint32(data[8+8*word:])
+		set |= uint64(permitted|inheritable) << (32 * word)
+	}
+	var names []string
+	for bit := 0; bit < 64; bit++ {
+		if set&(1<<bit) == 0 {
+			continue
+		}
+		if bit < len(capabilityNames) {
+			names = append(names, capabilityNames[bit])
+		} else {
+			names = append(names, fmt.Sprintf("CAP_%d", bit))
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"archive/tar"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestAudit(t *testing.T) {
+	host := newTestRegistry(t)
+	imageRef := host + "/test/app:v1"
+
+	// setcap cap_net_raw+ep (revision 2) and cap_sys_admin,cap_net_bind_service+ep
+	// (revision 3, namespaced to root 1000)
+	netRaw := "\x01\x00\x00\x02\x00\x20\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"
+	sysAdmin := "\x01\x00\x00\x03\x00\x04\x20\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xe8\x03\x00\x00"
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t,
+		testEntry{name: "usr/bin/passwd", content: "elf", mode: 04755},
+		testEntry{name: "usr/bin/wall", content: "elf", mode: 02755, gid: 5},
+		testEntry{name: "usr/bin/odd", content: "elf", mode: 04644},
+		testEntry{name: "usr/bin/ping", content: "elf", mode: 0755, pax: map[string]string{xattrCapability: netRaw}},
+		testEntry{name: "usr/local/bin/agent", content: "elf", mode: 0755, pax: map[string]string{xattrCapability: sysAdmin}},
+		testEntry{name: "tmp/", typeflag: tar.TypeDir, mode: 01777},
+		testEntry{name: "var/mail/", typeflag: tar.TypeDir, mode: 02775},
+		testEntry{name: "app/data/", typeflag: tar.TypeDir, mode: 0777, uid: 1000},
+		testEntry{name: "app/config.yaml", content: "x", mode: 0666},
+		testEntry{name: "app/link", typeflag: tar.TypeSymlink, linkname: "config.yaml", mode: 0777},
+	))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	pushTestImage(t, imageRef, image)
+
+	report, err := NewImageExporter().Audit(imageRef, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	want := []AuditFinding{
+		{Kind: AuditWorldWritable, Path: "/app/config.yaml", Mode: "-rw-rw-rw-"},
+		{Kind: AuditWorldWritable, Path: "/app/data", Mode: "drwxrwxrwx", UID: 1000},
+		{Kind: AuditSetuid, Path: "/usr/bin/odd", Mode: "-rwSr--r--"},
+		{Kind: AuditSetuid, Path: "/usr/bin/passwd", Mode: "-rwsr-xr-x"},
+		{Kind: AuditCapabilities, Path: "/usr/bin/ping", Mode: "-rwxr-xr-x", Capabilities: []string{"CAP_NET_RAW"}},
+		{Kind: AuditSetgid, Path: "/usr/bin/wall", Mode: "-rwxr-sr-x", GID: 5},
+		{Kind: AuditCapabilities, Path: "/usr/local/bin/agent", Mode: "-rwxr-xr-x",
+			Capabilities: []string{"CAP_NET_BIND_SERVICE", "CAP_SYS_ADMIN"}, Dangerous: []string{"CAP_SYS_ADMIN"}},
+	}
+	if !reflect.DeepEqual(report.Findings, want) {
+		t.Errorf("Expected findings %+v, got %+v", want, report.Findings)
+	}
+
+	if _, err := parseFileCapabilities([]byte("\x00\x00\x00\x09")); err == nil {
+		t.Error("Expected error for an unknown capability revision, got nil")
+	}
+}
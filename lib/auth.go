@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// AuthMode selects how credentials are obtained when no AuthConfig is given
+type AuthMode string
+
+const (
+	// AuthModeAuto uses the docker config and credential helpers, then falls back
+	// to cloud provider credentials for registries that are recognized (default)
+	AuthModeAuto AuthMode = ""
+
+	// AuthModeECR obtains tokens from AWS for Amazon ECR registries without
+	// consulting the docker config; other registries behave as in AuthModeAuto
+	AuthModeECR AuthMode = "ecr"
+
+	// AuthModeGoogle obtains tokens from Google Application Default Credentials for
+	// GCR and Artifact Registry without consulting the docker config
+	AuthModeGoogle AuthMode = "google"
+
+	// AuthModeACR obtains tokens from Azure AD for Azure Container Registry
+	// without consulting the docker config
+	AuthModeACR AuthMode = "acr"
+
+	// AuthModeAnonymous never looks up credentials: the docker config, credential
+	// helpers and cloud providers are all skipped. Credentials passed explicitly
+	// as an AuthConfig are still used.
+	AuthModeAnonymous AuthMode = "anonymous"
+)
+
+// ParseAuthMode converts a mode name ("auto", "ecr", "google", "acr", "anonymous") into an AuthMode
+func ParseAuthMode(mode string) (AuthMode, error) {
+	switch mode {
+	case "", "auto":
+		return AuthModeAuto, nil
+	case "ecr":
+		return cloudAuthMode(AuthModeECR)
+	case "google", "gcp":
+		return cloudAuthMode(AuthModeGoogle)
+	case "acr", "azure":
+		return cloudAuthMode(AuthModeACR)
+	case "anonymous", "none":
+		return AuthModeAnonymous, nil
+	default:
+		return "", fmt.Errorf("unknown auth mode %q", mode)
+	}
+}
+
+// cloudAuthMode returns mode, or an error when cloud credentials are not compiled in
+func cloudAuthMode(mode AuthMode) (AuthMode, error) {
+	if !HasFeature(FeatureCloudAuth) {
+		return "", fmt.Errorf("auth mode %q is not available in this build (built with the nocloud tag)", mode)
+	}
+	return mode, nil
+}
+
+// WithAuthMode selects how credentials are obtained for calls without an AuthConfig.
+func WithAuthMode(mode AuthMode) ExporterOption {
+	return func(e *imageExporter) {
+		e.authMode = mode
+	}
+}
+
+// WithAnonymousAuth skips every credential lookup for calls without an AuthConfig,
+// so that a missing or broken credential helper cannot break access to public
+// images. It is the same as WithAuthMode(AuthModeAnonymous).
+func WithAnonymousAuth() ExporterOption {
+	return WithAuthMode(AuthModeAnonymous)
+}
+
+// authKeychain returns the keychain used for calls without an AuthConfig,
+// consulting the auth file first when one is configured
+func (e *imageExporter) authKeychain() authn.Keychain {
+	if e.authFile != nil {
+		return authn.NewMultiKeychain(e.authFile, e.modeKeychain())
+	}
+	return e.modeKeychain()
+}
+
+// modeKeychain returns the keychain for the configured AuthMode
+func (e *imageExporter) modeKeychain() authn.Keychain {
+	switch e.authMode {
+	case AuthModeECR:
+		return authn.NewMultiKeychain(e.ecr, e.keychain)
+	case AuthModeGoogle:
+		return authn.NewMultiKeychain(e.google, e.keychain)
+	case AuthModeACR:
+		return authn.NewMultiKeychain(e.acr, e.keychain)
+	case AuthModeAnonymous:
+		// An empty keychain resolves every registry to anonymous access
+		return authn.NewMultiKeychain()
+	default:
+		// The first keychain returning non-anonymous credentials wins
+		return authn.NewMultiKeychain(e.keychain, e.ecr, e.google, e.acr)
+	}
+}
+
+// IsUnauthorized reports whether err is a registry refusing access because
+// credentials are missing or wrong, as opposed to the image not existing or a
+// network failure. Callers can use it to ask for credentials and retry.
+func IsUnauthorized(err error) bool {
+	var terr *transport.Error
+	if !errors.As(err, &terr) {
+		return false
+	}
+	if terr.StatusCode == http.StatusUnauthorized {
+		return true
+	}
+	for _, diagnostic := range terr.Errors {
+		if diagnostic.Code == transport.UnauthorizedErrorCode || diagnostic.Code == transport.DeniedErrorCode {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNotFound reports whether err is a registry reporting that an image, tag or
+// blob does not exist, as opposed to refusing access or a network failure.
+func IsNotFound(err error) bool {
+	var terr *transport.Error
+	if !errors.As(err, &terr) {
+		return false
+	}
+	if terr.StatusCode == http.StatusNotFound {
+		return true
+	}
+	for _, diagnostic := range terr.Errors {
+		switch diagnostic.Code {
+		case transport.ManifestUnknownErrorCode, transport.NameUnknownErrorCode, transport.BlobUnknownErrorCode:
+			return true
+		}
+	}
+	return false
+}
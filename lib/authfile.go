@@ -0,0 +1,223 @@
+package lib
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"gopkg.in/yaml.v3"
+)
+
+// AuthFile holds credentials for several registries, loaded by LoadAuthFile.
+// Each entry applies to a registry host or to a repository prefix on a host,
+// and the most specific entry matching a reference is used.
+type AuthFile struct {
+	entries map[string]*AuthConfig // credentials keyed by host or host/repository prefix
+	keys    []string               // entry keys, longest first
+}
+
+// dockerConfigAuth is one entry of the "auths" section of a docker config.json
+type dockerConfigAuth struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+	RegistryToken string `json:"registrytoken"`
+}
+
+// authFileContents accepts both supported layouts; exactly one section must be present
+type authFileContents struct {
+	Auths      map[string]dockerConfigAuth `json:"auths"`
+	Registries map[string]AuthConfig       `json:"registries"`
+}
+
+// LoadAuthFile reads a credential file covering multiple registries.
+//
+// Two layouts are accepted, written as JSON or YAML:
+//
+//   - a docker config.json / Kubernetes dockerconfigjson, with an "auths" map of
+//     server to {auth, username, password, identitytoken, registrytoken}
+//   - the imgex layout, with a "registries" map of server to AuthConfig fields
+//     (username, password, identity_token, registry_token)
+//
+// Servers may be a host ("ghcr.io"), a URL ("https://index.docker.io/v1/") or a
+// host with a repository prefix ("registry.example.com/team"). Credential helpers
+// named in a docker config are not consulted; use WithDockerConfig for those.
+//
+// Parameters:
+//   - path: Path of the credential file
+//
+// Returns:
+//   - *AuthFile: The parsed credentials, to pass to WithAuthFile or query with Lookup
+//   - error: The file could not be read, parsed, or contains no credentials
+//
+// Example:
+//
+//	file, err := LoadAuthFile("/etc/imgex/auth.yaml")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	exporter := NewImageExporter(WithAuthFile(file))
+func LoadAuthFile(path string) (*AuthFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth file: %w", err)
+	}
+	file, err := parseAuthFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse auth file %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// parseAuthFile decodes JSON or YAML credential file contents
+func parseAuthFile(data []byte) (*AuthFile, error) {
+	// YAML is a superset of JSON; convert through a generic document so that
+	// the JSON field names apply to both
+	var document interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	normalized, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	var contents authFileContents
+	if err := json.Unmarshal(normalized, &contents); err != nil {
+		return nil, err
+	}
+	if contents.Auths != nil && contents.Registries != nil {
+		return nil, fmt.Errorf(`"auths" and "registries" cannot be used together`)
+	}
+
+	file := &AuthFile{entries: make(map[string]*AuthConfig)}
+	add := func(server string, auth AuthConfig) error {
+		key, err := authFileKey(server)
+		if err != nil {
+			return err
+		}
+		if _, ok := file.entries[key]; ok {
+			return fmt.Errorf("duplicate credentials for %s", key)
+		}
+		auth.Registry = key
+		file.entries[key] = &auth
+		file.keys = append(file.keys, key)
+		return nil
+	}
+
+	for server, entry := range contents.Auths {
+		auth := AuthConfig{
+			Username:      entry.Username,
+			Password:      entry.Password,
+			IdentityToken: entry.IdentityToken,
+			RegistryToken: entry.RegistryToken,
+		}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth for %s: %w", server, err)
+			}
+			username, password, ok := strings.Cut(string(decoded), ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid auth for %s: expected username:password", server)
+			}
+			auth.Username, auth.Password = username, password
+		}
+		if err := add(server, auth); err != nil {
+			return nil, err
+		}
+	}
+	for server, auth := range contents.Registries {
+		if err := add(server, auth); err != nil {
+			return nil, err
+		}
+	}
+	if len(file.keys) == 0 {
+		return nil, fmt.Errorf(`no credentials found, expected an "auths" or "registries" map`)
+	}
+
+	// Longest keys first, so that repository prefixes win over their host
+	sort.Slice(file.keys, func(i, j int) bool {
+		if len(file.keys[i]) != len(file.keys[j]) {
+			return len(file.keys[i]) > len(file.keys[j])
+		}
+		return file.keys[i] < file.keys[j]
+	})
+	return file, nil
+}
+
+// authFileKey normalizes a server name to a host or host/repository prefix.
+// URLs name a registry only, as docker writes "https://index.docker.io/v1/".
+func authFileKey(server string) (string, error) {
+	if u, err := url.Parse(server); err == nil && u.Scheme != "" && u.Host != "" {
+		return normalizeRegistry(u.Host), nil
+	}
+	host, prefix, _ := strings.Cut(strings.Trim(server, "/"), "/")
+	if host == "" {
+		return "", fmt.Errorf("invalid server %q", server)
+	}
+	host = normalizeRegistry(host)
+	if prefix == "" {
+		return host, nil
+	}
+	return host + "/" + prefix, nil
+}
+
+// Lookup returns the credentials for an image reference or repository, choosing
+// the entry with the longest matching repository prefix before the host entry.
+//
+// Parameters:
+//   - ref: Image reference or repository (e.g., "ghcr.io/org/app:v1")
+//
+// Returns:
+//   - *AuthConfig: The matching credentials, with Registry set to the entry's server
+//   - bool: Whether an entry matched
+func (f *AuthFile) Lookup(ref string) (*AuthConfig, bool) {
+	var repository string
+	if parsed, err := name.ParseReference(ref); err == nil {
+		repository = parsed.Context().String()
+	} else if repo, err := name.NewRepository(ref); err == nil {
+		repository = repo.String()
+	} else {
+		repository = normalizeRegistry(ref)
+	}
+	return f.lookup(repository)
+}
+
+// lookup matches a normalized host or host/repository string
+func (f *AuthFile) lookup(target string) (*AuthConfig, bool) {
+	for _, key := range f.keys {
+		if target == key || strings.HasPrefix(target, key+"/") {
+			auth := *f.entries[key]
+			return &auth, true
+		}
+	}
+	return nil, false
+}
+
+// WithAuthFile adds the credentials of a multi-registry auth file. For every
+// request the most specific entry for the repository is used; registries the
+// file does not cover fall back to the docker config and cloud credentials.
+// The file takes precedence over the docker config, and explicit AuthConfig
+// credentials still take precedence over the file. Because the file is given
+// explicitly, it is also used in AuthModeAnonymous.
+func WithAuthFile(file *AuthFile) ExporterOption {
+	return func(e *imageExporter) {
+		e.authFile = file
+	}
+}
+
+// Resolve implements authn.Keychain
+func (f *AuthFile) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	auth, ok := f.lookup(target.String())
+	if !ok {
+		return authn.Anonymous, nil
+	}
+	return auth.authenticator(), nil
+}
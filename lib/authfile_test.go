@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestLoadAuthFile(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("hub-user:hub-pass"))
+	formats := map[string]string{
+		"config.json": `{"auths": {
+			"https://index.docker.io/v1/": {"auth": "` + auth + `"},
+			"ghcr.io": {"username": "gh-user", "password": "gh-pass"},
+			"ghcr.io/team": {"registrytoken": "team-token"}
+		}}`,
+		"auth.yaml": `
+registries:
+  docker.io:
+    username: hub-user
+    password: hub-pass
+  ghcr.io:
+    username: gh-user
+    password: gh-pass
+  ghcr.io/team:
+    registry_token: team-token
+`,
+	}
+
+	for filename, contents := range formats {
+		path := filepath.Join(t.TempDir(), filename)
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatalf("Failed to write auth file: %v", err)
+		}
+		file, err := LoadAuthFile(path)
+		if err != nil {
+			t.Fatalf("Expected %s to load, got %v", filename, err)
+		}
+
+		for ref, expected := range map[string]AuthConfig{
+			"alpine:latest":        {Username: "hub-user", Password: "hub-pass", Registry: "index.docker.io"},
+			"ghcr.io/other/app:v1": {Username: "gh-user", Password: "gh-pass", Registry: "ghcr.io"},
+			"ghcr.io/team/app@sha256:" + strings.Repeat("a", 64): {RegistryToken: "team-token", Registry: "ghcr.io/team"},
+			"ghcr.io/teammate/app":                               {Username: "gh-user", Password: "gh-pass", Registry: "ghcr.io"},
+		} {
+			got, ok := file.Lookup(ref)
+			if !ok {
+				t.Errorf("Expected %s to match an entry in %s", ref, filename)
+				continue
+			}
+			if *got != expected {
+				t.Errorf("Expected %+v for %s in %s, got %+v", expected, ref, filename, *got)
+			}
+		}
+		if got, ok := file.Lookup("quay.io/app"); ok {
+			t.Errorf("Expected no entry for quay.io in %s, got %+v", filename, got)
+		}
+	}
+}
+
+func TestLoadAuthFileErrors(t *testing.T) {
+	for contents, expected := range map[string]string{
+		`{}`: "no credentials found",
+		`{"auths": {"a.io": {}}, "registries": {"b.io": {}}}`:                                           "cannot be used together",
+		`{"auths": {"docker.io": {}, "https://index.docker.io/v1/": {}}}`:                               "duplicate credentials for index.docker.io",
+		`{"auths": {"a.io": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("nocolon")) + `"}}}`: "expected username:password",
+		"registries: [": "failed to parse auth file",
+	} {
+		path := filepath.Join(t.TempDir(), "auth.json")
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatalf("Failed to write auth file: %v", err)
+		}
+		_, err := LoadAuthFile(path)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q for %s, got %v", expected, contents, err)
+		}
+	}
+}
+
+func TestWithAuthFile(t *testing.T) {
+	first := newBasicAuthTestRegistry(t, "first", "one")
+	second := newBasicAuthTestRegistry(t, "second", "two")
+	contents := "registries:\n" +
+		"  " + first + ":\n    username: first\n    password: one\n" +
+		"  " + second + ":\n    username: second\n    password: two\n"
+	path := filepath.Join(t.TempDir(), "auth.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("Failed to write auth file: %v", err)
+	}
+	file, err := LoadAuthFile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for host, user := range map[string]*authn.Basic{first: {Username: "first", Password: "one"}, second: {Username: "second", Password: "two"}} {
+		ref, err := name.ParseReference(host + "/test/app:latest")
+		if err != nil {
+			t.Fatalf("Failed to parse reference: %v", err)
+		}
+		if err := remote.Write(ref, empty.Image, remote.WithAuth(user)); err != nil {
+			t.Fatalf("Failed to push test image: %v", err)
+		}
+	}
+
+	// One exporter reaches both registries with their own credentials
+	exporter := NewImageExporter(WithAuthFile(file), WithAnonymousAuth())
+	for _, host := range []string{first, second} {
+		if _, err := exporter.GetImageConfig(host+"/test/app:latest", nil); err != nil {
+			t.Errorf("Expected the auth file to authorize %s, got %v", host, err)
+		}
+	}
+
+	// Without the file the registries refuse access
+	if _, err := NewImageExporter(WithAnonymousAuth()).GetImageConfig(first+"/test/app:latest", nil); !IsUnauthorized(err) {
+		t.Errorf("Expected unauthorized without the auth file, got %v", err)
+	}
+}
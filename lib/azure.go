@@ -0,0 +1,237 @@
+//go:build !nocloud
+
+package lib
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+// acrNullUsername is the username ACR expects alongside an exchanged refresh token
+const acrNullUsername = "00000000-0000-0000-0000-000000000000"
+
+// acrResource identifies ACR when requesting Azure AD tokens
+const acrResource = "https://containerregistry.azure.net/"
+
+// acrTokenRefreshWindow renews cached tokens this long before they expire
+const acrTokenRefreshWindow = 5 * time.Minute
+
+// acrKeychain exchanges an Azure AD access token for an ACR refresh token. The
+// AD token comes from a service principal (AZURE_CLIENT_ID, AZURE_TENANT_ID and
+// AZURE_CLIENT_SECRET), AKS workload identity (AZURE_FEDERATED_TOKEN_FILE) or the
+// managed identity endpoint. Registries that are not ACR resolve anonymously.
+type acrKeychain struct {
+	transport http.RoundTripper
+
+	// credentials overrides the Azure AD token lookup, for tests
+	credentials func() (string, error)
+
+	// exchangeURL overrides the registry token exchange endpoint, for tests
+	exchangeURL func(registry string) string
+
+	mu     sync.Mutex
+	tokens map[string]acrToken
+}
+
+// acrToken is a cached ACR refresh token
+type acrToken struct {
+	refreshToken string
+	expiresAt    time.Time
+}
+
+// Resolve implements authn.Keychain
+func (k *acrKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	registry := target.RegistryStr()
+	if !IsACRRegistry(registry) {
+		return authn.Anonymous, nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	token, ok := k.tokens[registry]
+	if !ok || time.Until(token.expiresAt) < acrTokenRefreshWindow {
+		var err error
+		token, err = k.fetchToken(registry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ACR token for %s: %w", registry, err)
+		}
+		if k.tokens == nil {
+			k.tokens = make(map[string]acrToken)
+		}
+		k.tokens[registry] = token
+	}
+
+	return authn.FromConfig(authn.AuthConfig{
+		Username: acrNullUsername,
+		Password: token.refreshToken,
+	}), nil
+}
+
+// fetchToken obtains an Azure AD token and exchanges it with the registry
+func (k *acrKeychain) fetchToken(registry string) (acrToken, error) {
+	getCredentials := k.credentials
+	if getCredentials == nil {
+		getCredentials = k.azureADToken
+	}
+	accessToken, err := getCredentials()
+	if err != nil {
+		return acrToken{}, err
+	}
+
+	exchangeURL := "https://" + registry + "/oauth2/exchange"
+	if k.exchangeURL != nil {
+		exchangeURL = k.exchangeURL(registry)
+	}
+	form := url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {registry},
+		"access_token": {accessToken},
+	}
+	if tenant := os.Getenv("AZURE_TENANT_ID"); tenant != "" {
+		form.Set("tenant", tenant)
+	}
+
+	var result struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := k.postForm(exchangeURL, form, &result); err != nil {
+		return acrToken{}, fmt.Errorf("token exchange failed: %w", err)
+	}
+	if result.RefreshToken == "" {
+		return acrToken{}, fmt.Errorf("token exchange returned no refresh token")
+	}
+
+	return acrToken{refreshToken: result.RefreshToken, expiresAt: jwtExpiry(result.RefreshToken)}, nil
+}
+
+// azureADToken obtains an Azure AD access token for ACR from the environment
+// (service principal or workload identity), falling back to managed identity
+func (k *acrKeychain) azureADToken() (string, error) {
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+	tenant := os.Getenv("AZURE_TENANT_ID")
+	authority := os.Getenv("AZURE_AUTHORITY_HOST")
+	if authority == "" {
+		authority = "https://login.microsoftonline.com/"
+	}
+	tokenURL := strings.TrimSuffix(authority, "/") + "/" + tenant + "/oauth2/v2.0/token"
+
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+		"client_id":  {clientID},
+		"scope":      {acrResource + ".default"},
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+	}
+	switch {
+	case clientID != "" && tenant != "" && os.Getenv("AZURE_CLIENT_SECRET") != "":
+		form.Set("client_secret", os.Getenv("AZURE_CLIENT_SECRET"))
+	case clientID != "" && tenant != "" && os.Getenv("AZURE_FEDERATED_TOKEN_FILE") != "":
+		assertion, err := os.ReadFile(os.Getenv("AZURE_FEDERATED_TOKEN_FILE"))
+		if err != nil {
+			return "", fmt.Errorf("failed to read federated token: %w", err)
+		}
+		form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		form.Set("client_assertion", strings.TrimSpace(string(assertion)))
+	default:
+		return managedIdentityToken(clientID)
+	}
+
+	if err := k.postForm(tokenURL, form, &result); err != nil {
+		return "", fmt.Errorf("failed to get Azure AD token: %w", err)
+	}
+	if result.AccessToken == "" {
+		return "", fmt.Errorf("Azure AD returned no access token")
+	}
+	return result.AccessToken, nil
+}
+
+// postForm posts a form and decodes the JSON response
+func (k *acrKeychain) postForm(endpoint string, form url.Values, v interface{}) error {
+	client := &http.Client{Transport: k.transport, Timeout: 30 * time.Second}
+	resp, err := client.PostForm(endpoint, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s: %s", endpoint, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// managedIdentityToken fetches a token from the Azure instance metadata service,
+// selecting a user-assigned identity when clientID is set
+func managedIdentityToken(clientID string) (string, error) {
+	query := url.Values{
+		"api-version": {"2018-02-01"},
+		"resource":    {acrResource},
+	}
+	if clientID != "" {
+		query.Set("client_id", clientID)
+	}
+	endpoint := "http://169.254.169.254/metadata/identity/oauth2/token?" + query.Encode()
+
+	// App Service and Container Apps expose the identity endpoint through the environment
+	var header, secret string
+	if identityEndpoint := os.Getenv("IDENTITY_ENDPOINT"); identityEndpoint != "" {
+		query.Set("api-version", "2019-08-01")
+		endpoint = identityEndpoint + "?" + query.Encode()
+		header, secret = "X-IDENTITY-HEADER", os.Getenv("IDENTITY_HEADER")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+	if header != "" {
+		req.Header.Set(header, secret)
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := fetchMetadataJSON(req, &result); err != nil {
+		return "", fmt.Errorf("no Azure credentials found (set AZURE_CLIENT_ID/AZURE_TENANT_ID/AZURE_CLIENT_SECRET or run with a managed identity): %w", err)
+	}
+	return result.AccessToken, nil
+}
+
+// jwtExpiry reads the exp claim of a JWT without verifying it, defaulting to
+// one hour from now when the token cannot be decoded
+func jwtExpiry(token string) time.Time {
+	fallback := time.Now().Add(time.Hour)
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fallback
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fallback
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return fallback
+	}
+	return time.Unix(claims.Exp, 0)
+}
@@ -0,0 +1,123 @@
+//go:build !nocloud
+
+package lib
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestIsACRRegistry(t *testing.T) {
+	tests := map[string]bool{
+		"myregistry.azurecr.io": true,
+		"myregistry.azurecr.cn": true,
+		"azurecr.io":            false,
+		".azurecr.io":           false,
+		"index.docker.io":       false,
+	}
+	for registry, expected := range tests {
+		if got := IsACRRegistry(registry); got != expected {
+			t.Errorf("Expected IsACRRegistry(%q) = %v, got %v", registry, expected, got)
+		}
+	}
+}
+
+func TestACRKeychain(t *testing.T) {
+	expiry := time.Now().Add(3 * time.Hour).Unix()
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, expiry)))
+	refreshToken := "header." + payload + ".signature"
+
+	var exchanges int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exchanges++
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("Failed to parse exchange request: %v", err)
+		}
+		if r.Form.Get("grant_type") != "access_token" || r.Form.Get("access_token") != "aad-token" {
+			t.Errorf("Expected access_token grant with the AD token, got %v", r.Form)
+		}
+		if service := r.Form.Get("service"); service != "myregistry.azurecr.io" {
+			t.Errorf("Expected service myregistry.azurecr.io, got %q", service)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"refresh_token": refreshToken})
+	}))
+	defer server.Close()
+
+	keychain := &acrKeychain{
+		credentials: func() (string, error) { return "aad-token", nil },
+		exchangeURL: func(registry string) string { return server.URL + "/oauth2/exchange" },
+	}
+
+	repo, err := name.NewRepository("myregistry.azurecr.io/app")
+	if err != nil {
+		t.Fatalf("Failed to parse repository: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		authenticator, err := keychain.Resolve(repo)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		cfg, err := authenticator.Authorization()
+		if err != nil {
+			t.Fatalf("Failed to get authorization: %v", err)
+		}
+		if cfg.Username != acrNullUsername || cfg.Password != refreshToken {
+			t.Errorf("Expected null username and refresh token, got %s/%s", cfg.Username, cfg.Password)
+		}
+	}
+	if exchanges != 1 {
+		t.Errorf("Expected the refresh token to be cached after 1 exchange, got %d", exchanges)
+	}
+	if got := keychain.tokens["myregistry.azurecr.io"].expiresAt.Unix(); got != expiry {
+		t.Errorf("Expected expiry from the token's exp claim (%d), got %d", expiry, got)
+	}
+
+	other, err := name.NewRepository("registry.example.com/app")
+	if err != nil {
+		t.Fatalf("Failed to parse repository: %v", err)
+	}
+	authenticator, err := keychain.Resolve(other)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if authenticator != authn.Anonymous {
+		t.Errorf("Expected anonymous access for non-ACR registry, got %v", authenticator)
+	}
+}
+
+func TestACRServicePrincipal(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tenant-id/oauth2/v2.0/token" {
+			t.Errorf("Expected tenant token endpoint, got %s", r.URL.Path)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("Failed to parse token request: %v", err)
+		}
+		if r.Form.Get("client_secret") != "secret" || r.Form.Get("scope") != acrResource+".default" {
+			t.Errorf("Expected client credentials for ACR, got %v", r.Form)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "aad-token"})
+	}))
+	defer server.Close()
+
+	t.Setenv("AZURE_AUTHORITY_HOST", server.URL)
+	t.Setenv("AZURE_TENANT_ID", "tenant-id")
+	t.Setenv("AZURE_CLIENT_ID", "client-id")
+	t.Setenv("AZURE_CLIENT_SECRET", "secret")
+
+	token, err := (&acrKeychain{}).azureADToken()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if token != "aad-token" {
+		t.Errorf("Expected aad-token, got %s", token)
+	}
+}
@@ -0,0 +1,201 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+)
+
+// A minimal read-only reader of bbolt databases, enough to look up keys in the
+// nested buckets of containerd's metadata store without linking bbolt (and
+// without taking its file lock, so a running containerd is not blocked).
+
+const (
+	boltMagic          = 0xED0CDAED
+	boltPageHeaderSize = 16
+	boltElementSize    = 16
+	boltBranchPage     = 0x01
+	boltLeafPage       = 0x02
+	boltBucketLeaf     = 0x01
+	boltMetaChecksumAt = 56 // the meta fields covered by its checksum
+)
+
+// errBoltNotFound is returned for a missing key or bucket
+var errBoltNotFound = errors.New("not found")
+
+// boltDB is an open bbolt database file
+type boltDB struct {
+	file     *os.File
+	pageSize int
+}
+
+// boltBucket is a bucket of a boltDB: a tree of pages, or a page stored inline
+// in the value of its parent
+type boltBucket struct {
+	db     *boltDB
+	root   uint64
+	inline []byte
+}
+
+// openBolt opens a bbolt file and returns its root bucket, as of the last
+// committed transaction
+func openBolt(path string) (*boltDB, boltBucket, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, boltBucket{}, err
+	}
+	db := &boltDB{file: file}
+
+	// Both meta pages are read; the valid one with the newer transaction wins
+	header := make([]byte, 4096)
+	if _, err := file.ReadAt(header, 0); err != nil && err != io.EOF {
+		file.Close()
+		return nil, boltBucket{}, err
+	}
+	var root, txid uint64
+	found := false
+	for i := 0; i < 2; i++ {
+		var meta []byte
+		if i == 0 {
+			meta = header[boltPageHeaderSize:]
+		} else {
+			if db.pageSize == 0 {
+				break
+			}
+			page := make([]byte, db.pageSize)
+			if _, err := file.ReadAt(page, int64(db.pageSize)); err != nil {
+				break
+			}
+			meta = page[boltPageHeaderSize:]
+		}
+		if binary.LittleEndian.Uint32(meta[0:]) != boltMagic {
+			continue
+		}
+		sum := fnv.New64a()
+		sum.Write(meta[:boltMetaChecksumAt])
+		if sum.Sum64() != binary.LittleEndian.Uint64(meta[boltMetaChecksumAt:]) {
+			continue
+		}
+		if db.pageSize == 0 {
+			db.pageSize = int(binary.LittleEndian.Uint32(meta[8:]))
+		}
+		if tx := binary.LittleEndian.Uint64(meta[48:]); !found || tx > txid {
+			root, txid, found = binary.LittleEndian.Uint64(meta[16:]), tx, true
+		}
+	}
+	if !found || db.pageSize < boltPageHeaderSize {
+		file.Close()
+		return nil, boltBucket{}, fmt.Errorf("%s is not a bbolt database", path)
+	}
+	return db, boltBucket{db: db, root: root}, nil
+}
+
+// Close closes the database file
+func (db *boltDB) Close() error {
+	return db.file.Close()
+}
+
+// page reads a page and its overflow pages
+func (db *boltDB) page(id uint64) ([]byte, error) {
+	page := make([]byte, db.pageSize)
+	if _, err := db.file.ReadAt(page, int64(id)*int64(db.pageSize)); err != nil {
+		return nil, fmt.Errorf("failed to read page %d: %w", id, err)
+	}
+	if overflow := binary.LittleEndian.Uint32(page[12:]); overflow > 0 {
+		page = make([]byte, (int(overflow)+1)*db.pageSize)
+		if _, err := db.file.ReadAt(page, int64(id)*int64(db.pageSize)); err != nil {
+			return nil, fmt.Errorf("failed to read page %d: %w", id, err)
+		}
+	}
+	return page, nil
+}
+
+// get returns the value of key in the bucket and whether it is a nested bucket
+func (b boltBucket) get(key []byte) ([]byte, bool, error) {
+	page := b.inline
+	if page == nil {
+		var err error
+		if page, err = b.db.page(b.root); err != nil {
+			return nil, false, err
+		}
+	}
+	for depth := 0; depth < 64; depth++ {
+		flags := binary.LittleEndian.Uint16(page[8:])
+		count := int(binary.LittleEndian.Uint16(page[10:]))
+		if boltPageHeaderSize+count*boltElementSize > len(page) {
+			return nil, false, fmt.Errorf("corrupt page")
+		}
+		element := func(i int) []byte { return page[boltPageHeaderSize+i*boltElementSize:] }
+
+		switch {
+		case flags&boltLeafPage != 0:
+			for i := 0; i < count; i++ {
+				elem, at := element(i), boltPageHeaderSize+i*boltElementSize
+				pos, ksize, vsize := int(binary.LittleEndian.Uint32(elem[4:])), int(binary.LittleEndian.Uint32(elem[8:])), int(binary.LittleEndian.Uint32(elem[12:]))
+				start := at + pos
+				if start+ksize+vsize > len(page) {
+					return nil, false, fmt.Errorf("corrupt leaf page")
+				}
+				if bytes.Equal(page[start:start+ksize], key) {
+					return page[start+ksize : start+ksize+vsize], binary.LittleEndian.Uint32(elem)&boltBucketLeaf != 0, nil
+				}
+			}
+			return nil, false, errBoltNotFound
+
+		case flags&boltBranchPage != 0 && count > 0:
+			// Descend into the last child whose first key is not after key
+			child := binary.LittleEndian.Uint64(element(0)[8:])
+			for i := 1; i < count; i++ {
+				elem, at := element(i), boltPageHeaderSize+i*boltElementSize
+				pos, ksize := int(binary.LittleEndian.Uint32(elem)), int(binary.LittleEndian.Uint32(elem[4:]))
+				if at+pos+ksize > len(page) {
+					return nil, false, fmt.Errorf("corrupt branch page")
+				}
+				if bytes.Compare(page[at+pos:at+pos+ksize], key) > 0 {
+					break
+				}
+				child = binary.LittleEndian.Uint64(elem[8:])
+			}
+			var err error
+			if page, err = b.db.page(child); err != nil {
+				return nil, false, err
+			}
+
+		default:
+			return nil, false, fmt.Errorf("unexpected page type %#x", flags)
+		}
+	}
+	return nil, false, fmt.Errorf("bucket tree too deep")
+}
+
+// bucket returns the nested bucket name
+func (b boltBucket) bucket(name string) (boltBucket, error) {
+	value, isBucket, err := b.get([]byte(name))
+	if err != nil {
+		return boltBucket{}, fmt.Errorf("bucket %s: %w", name, err)
+	}
+	if !isBucket || len(value) < 16 {
+		return boltBucket{}, fmt.Errorf("%s is not a bucket", name)
+	}
+	nested := boltBucket{db: b.db, root: binary.LittleEndian.Uint64(value)}
+	if nested.root == 0 {
+		nested.inline = value[16:]
+	}
+	return nested, nil
+}
+
+// value returns the value of key, which must not be a bucket
+func (b boltBucket) value(key string) ([]byte, error) {
+	value, isBucket, err := b.get([]byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", key, err)
+	}
+	if isBucket {
+		return nil, fmt.Errorf("%s is a bucket", key)
+	}
+	return value, nil
+}
@@ -0,0 +1,510 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// BundleOptions controls ExportBundle
+type BundleOptions struct {
+	// Platforms limits a multi-arch index to these platforms (e.g. "linux/arm64").
+	// The index itself is kept unchanged, so its digest still matches the tag;
+	// the other platforms are simply not available from the bundle. Empty means
+	// every platform.
+	Platforms []string
+
+	// PlatformPolicy controls validation of the bundled platforms against the
+	// host before anything is written: a bundle passes when any of its images can
+	// run on the host. Warnings go to the WithWarnings handler.
+	PlatformPolicy PlatformPolicy
+}
+
+// Annotations naming the image of a bundle in its index.json: the tag, as in
+// any OCI layout, and the full reference, as containerd expects it
+const (
+	annotationRefName        = "org.opencontainers.image.ref.name"
+	annotationContainerdName = "io.containerd.image.name"
+)
+
+// AnnotationBundlePlatforms lists the platforms of the images in a bundle, from
+// their configurations and comma-separated (e.g. "linux/amd64,linux/arm64/v8"),
+// on the image in its index.json
+const AnnotationBundlePlatforms = "com.github.kenichi.imgex.bundle.platforms"
+
+// maxBundleManifestSize bounds the bundle entries kept in memory until
+// index.json tells manifests from other blobs; registries refuse larger manifests
+const maxBundleManifestSize = 4 << 20
+
+// ExportBundle writes an image as a self-contained tar for moving it to an
+// air-gapped machine: its manifest (or index), config and compressed layers,
+// exactly as the registry serves them. The tar is an OCI image layout
+// (oci-layout, index.json and blobs/sha256/<hex>), so it can also be unpacked
+// and used by other OCI tools. Blobs are read through the cache when WithCache
+// is set. The platforms of the bundled images are recorded in index.json (see
+// AnnotationBundlePlatforms).
+//
+// On the offline machine, ImportBundle loads the bundle into the blob cache,
+// after which the image can be exported with WithOffline.
+//
+// Parameters:
+//   - imageRef: Image reference (e.g., "alpine:3")
+//   - w: Writer the tar stream is written to
+//   - auth: Optional authentication configuration for private registries
+//   - opts: Optional platform selection for multi-arch images
+//
+// Example:
+//
+//	file, _ := os.Create("app-v1.bundle.tar")
+//	defer file.Close()
+//	err := exporter.ExportBundle("registry.com/app:v1", file, nil, &BundleOptions{
+//	    Platforms: []string{"linux/amd64"},
+//	})
+func (e *imageExporter) ExportBundle(imageRef string, w io.Writer, auth *AuthConfig, opts *BundleOptions) error {
+	if opts == nil {
+		opts = &BundleOptions{}
+	}
+	var platforms []v1.Platform
+	for _, p := range opts.Platforms {
+		platform, err := v1.ParsePlatform(p)
+		if err != nil {
+			return fmt.Errorf("invalid platform %q: %w", p, err)
+		}
+		platforms = append(platforms, *platform)
+	}
+
+	ref, err := e.parseReference(imageRef)
+	if err != nil {
+		return fmt.Errorf("failed to parse image reference %s: %w", imageRef, err)
+	}
+	desc, err := e.getManifest(ref, auth)
+	if err != nil {
+		return fmt.Errorf("failed to fetch image %s: %w", imageRef, err)
+	}
+	if err := checkAttestationDescriptor(ref, desc); err != nil {
+		return err
+	}
+	if err := e.verifySignatures(ref, desc.Digest, auth); err != nil {
+		return err
+	}
+
+	// Select the images first, so the platform policy is applied before anything is written
+	var images []v1.Image
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return fmt.Errorf("failed to read index %s: %w", imageRef, err)
+		}
+		manifest, err := index.IndexManifest()
+		if err != nil {
+			return fmt.Errorf("failed to read index %s: %w", imageRef, err)
+		}
+		for _, child := range manifest.Manifests {
+			if !child.MediaType.IsImage() || !matchesAnyPlatform(child.Platform, platforms) {
+				continue
+			}
+			image, err := index.Image(child.Digest)
+			if err != nil {
+				return fmt.Errorf("failed to read image %s of %s: %w", child.Digest, imageRef, err)
+			}
+			images = append(images, e.withCache(image))
+		}
+		if len(images) == 0 {
+			return fmt.Errorf("no image of %s matches platforms %v", imageRef, opts.Platforms)
+		}
+	} else {
+		image, err := desc.Image()
+		if err != nil {
+			return fmt.Errorf("failed to read image %s: %w", imageRef, err)
+		}
+		images = append(images, e.withCache(image))
+	}
+	if err := e.applyPlatformPolicy(opts.PlatformPolicy, nil, images...); err != nil {
+		return err
+	}
+	platformNames, err := bundlePlatforms(images)
+	if err != nil {
+		return fmt.Errorf("failed to read image %s: %w", imageRef, err)
+	}
+
+	bundle := &bundleWriter{tw: tar.NewWriter(w), written: make(map[v1.Hash]bool)}
+	if err := bundle.add("oci-layout", []byte(`{"imageLayoutVersion": "1.0.0"}`)); err != nil {
+		return err
+	}
+	for _, image := range images {
+		if err := e.addBundleImage(bundle, image); err != nil {
+			return fmt.Errorf("failed to bundle %s: %w", imageRef, err)
+		}
+	}
+	if err := bundle.addBlob(desc.Digest, bytes.NewReader(desc.Manifest), int64(len(desc.Manifest))); err != nil {
+		return err
+	}
+
+	root := v1.Descriptor{
+		MediaType: desc.MediaType,
+		Digest:    desc.Digest,
+		Size:      desc.Size,
+		Annotations: map[string]string{
+			annotationContainerdName:  ref.Name(),
+			AnnotationBundlePlatforms: strings.Join(platformNames, ","),
+		},
+	}
+	if tag, ok := ref.(name.Tag); ok {
+		root.Annotations[annotationRefName] = tag.TagStr()
+	}
+	layout, err := json.Marshal(v1.IndexManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIImageIndex,
+		Manifests:     []v1.Descriptor{root},
+	})
+	if err != nil {
+		return err
+	}
+	if err := bundle.add("index.json", layout); err != nil {
+		return err
+	}
+	if err := bundle.tw.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	e.log().Debug("bundle written", "image", ref.String(), "digest", desc.Digest.String(), "blobs", len(bundle.written))
+	return nil
+}
+
+// matchesAnyPlatform reports whether platform satisfies one of wanted, or
+// whether nothing is wanted
+func matchesAnyPlatform(platform *v1.Platform, wanted []v1.Platform) bool {
+	if len(wanted) == 0 {
+		return true
+	}
+	for _, w := range wanted {
+		if platformMatches(platform, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// bundlePlatforms returns the platforms recorded in the configurations of images
+func bundlePlatforms(images []v1.Image) ([]string, error) {
+	var names []string
+	for _, image := range images {
+		configFile, err := image.ConfigFile()
+		if err != nil {
+			return nil, err
+		}
+		if platform := configFile.Platform(); platform != nil {
+			names = append(names, platform.String())
+		}
+	}
+	return names, nil
+}
+
+// addBundleImage writes the layers, config and manifest of an image
+func (e *imageExporter) addBundleImage(bundle *bundleWriter, image v1.Image) error {
+	layers, err := image.Layers()
+	if err != nil {
+		return err
+	}
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return err
+		}
+		size, err := layer.Size()
+		if err != nil {
+			return err
+		}
+		if bundle.written[digest] {
+			continue
+		}
+		blob, err := e.layerBlob(layer)
+		if err != nil {
+			return fmt.Errorf("failed to fetch layer %s: %w", digest, err)
+		}
+		err = bundle.addBlob(digest, blob, size)
+		blob.Close()
+		if err != nil {
+			return fmt.Errorf("failed to fetch layer %s: %w", digest, err)
+		}
+	}
+
+	configName, err := image.ConfigName()
+	if err != nil {
+		return err
+	}
+	config, err := image.RawConfigFile()
+	if err != nil {
+		return err
+	}
+	if err := bundle.addBlob(configName, bytes.NewReader(config), int64(len(config))); err != nil {
+		return err
+	}
+
+	digest, err := image.Digest()
+	if err != nil {
+		return err
+	}
+	manifest, err := image.RawManifest()
+	if err != nil {
+		return err
+	}
+	return bundle.addBlob(digest, bytes.NewReader(manifest), int64(len(manifest)))
+}
+
+// bundleWriter writes the entries of a bundle, each blob once
+type bundleWriter struct {
+	tw      *tar.Writer
+	written map[v1.Hash]bool
+}
+
+// add writes a small file at the root of the layout
+func (b *bundleWriter) add(name string, data []byte) error {
+	return b.write(name, bytes.NewReader(data), int64(len(data)))
+}
+
+// addBlob writes a blob under blobs/<algorithm>/<hex> unless it was written already
+func (b *bundleWriter) addBlob(digest v1.Hash, r io.Reader, size int64) error {
+	if b.written[digest] {
+		return nil
+	}
+	if err := b.write(path.Join("blobs", digest.Algorithm, digest.Hex), r, size); err != nil {
+		return err
+	}
+	b.written[digest] = true
+	return nil
+}
+
+// write adds one regular file to the tar
+func (b *bundleWriter) write(name string, r io.Reader, size int64) error {
+	if err := b.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: size, Mode: 0644}); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	n, err := io.Copy(b.tw, r)
+	if err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if n != size {
+		return fmt.Errorf("failed to write bundle: %s is %d bytes, expected %d", name, n, size)
+	}
+	return nil
+}
+
+// ImportBundle loads a bundle written by ExportBundle into the blob cache, so
+// that its image can be exported with WithOffline on a machine without
+// registry access. Every blob is verified against its digest, and the tag the
+// bundle was made from is recorded to point to its digest.
+//
+// Parameters:
+//   - r: Reader of the bundle tar
+//
+// Returns:
+//   - []string: The references of the imported images, e.g. "registry.com/app:v1"
+//   - error: If the exporter has no cache (see WithCache), or the bundle is
+//     corrupt or incomplete
+//
+// Example:
+//
+//	exporter := NewImageExporter(WithCache("/var/cache/imgex"))
+//	file, _ := os.Open("app-v1.bundle.tar")
+//	defer file.Close()
+//	refs, err := exporter.ImportBundle(file)
+//	...
+//	offline := NewImageExporter(WithCache("/var/cache/imgex"), WithOffline())
+//	err = offline.ExportImageFilesystem(refs[0], "rootfs.tar", nil)
+func (e *imageExporter) ImportBundle(r io.Reader) ([]string, error) {
+	if e.cache == nil {
+		return nil, fmt.Errorf("importing a bundle needs a blob cache (see WithCache)")
+	}
+
+	// Small blobs are held back until index.json tells which ones are manifests
+	small := make(map[v1.Hash][]byte)
+	var layout *v1.IndexManifest
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		entry := path.Clean(header.Name)
+		switch {
+		case entry == "index.json":
+			if layout, err = v1.ParseIndexManifest(tr); err != nil {
+				return nil, fmt.Errorf("failed to read bundle index.json: %w", err)
+			}
+		case strings.HasPrefix(entry, "blobs/"):
+			digest, err := v1.NewHash(strings.Replace(strings.TrimPrefix(entry, "blobs/"), "/", ":", 1))
+			if err != nil {
+				return nil, fmt.Errorf("unexpected bundle entry %s", header.Name)
+			}
+			if header.Size <= maxBundleManifestSize {
+				data, err := readVerified(tr, digest)
+				if err != nil {
+					return nil, err
+				}
+				small[digest] = data
+				continue
+			}
+			if err := e.cache.store(digest, tr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if layout == nil {
+		return nil, fmt.Errorf("not an imgex bundle: index.json is missing")
+	}
+
+	importer := &bundleImporter{cache: e.cache, small: small, manifests: make(map[v1.Hash]bool)}
+	var refs []string
+	for _, root := range layout.Manifests {
+		if err := importer.importManifest(root, true); err != nil {
+			return nil, err
+		}
+		refName := root.Annotations[annotationContainerdName]
+		if refName == "" {
+			refs = append(refs, root.Digest.String())
+			continue
+		}
+		ref, err := name.ParseReference(refName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image name %q in bundle: %w", refName, err)
+		}
+		if tag, ok := ref.(name.Tag); ok {
+			e.cache.storeTag(tag, root.Digest)
+		}
+		refs = append(refs, ref.Name())
+		e.log().Debug("bundle imported", "image", ref.Name(), "digest", root.Digest.String())
+	}
+
+	// Blobs that are not manifests (configs, small layers) go to the cache
+	for digest, data := range small {
+		if !importer.manifests[digest] {
+			if err := e.cache.store(digest, bytes.NewReader(data)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return refs, nil
+}
+
+// bundleImporter records the manifests of a bundle in the cache
+type bundleImporter struct {
+	cache     *blobCache
+	small     map[v1.Hash][]byte
+	manifests map[v1.Hash]bool
+}
+
+// importManifest stores a manifest or index of the bundle and checks that
+// everything it needs is present. The children of an index are optional, as
+// the bundle may hold some platforms only; the root and its images are not.
+func (b *bundleImporter) importManifest(desc v1.Descriptor, required bool) error {
+	raw, ok := b.small[desc.Digest]
+	if !ok {
+		if required {
+			return fmt.Errorf("bundle is incomplete: manifest %s is missing", desc.Digest)
+		}
+		return nil
+	}
+	b.manifests[desc.Digest] = true
+
+	if desc.MediaType.IsIndex() {
+		index, err := v1.ParseIndexManifest(bytes.NewReader(raw))
+		if err != nil {
+			return fmt.Errorf("invalid index %s in bundle: %w", desc.Digest, err)
+		}
+		for _, child := range index.Manifests {
+			if child.MediaType.IsImage() || child.MediaType.IsIndex() {
+				if err := b.importManifest(child, false); err != nil {
+					return err
+				}
+			}
+		}
+	} else {
+		manifest, err := v1.ParseManifest(bytes.NewReader(raw))
+		if err != nil {
+			return fmt.Errorf("invalid manifest %s in bundle: %w", desc.Digest, err)
+		}
+		for _, blob := range append([]v1.Descriptor{manifest.Config}, manifest.Layers...) {
+			if _, ok := b.small[blob.Digest]; ok {
+				continue
+			}
+			if _, err := os.Stat(b.cache.path(blob.Digest)); err != nil {
+				return fmt.Errorf("bundle is incomplete: blob %s of manifest %s is missing", blob.Digest, desc.Digest)
+			}
+		}
+	}
+	b.cache.storeManifest(desc.Digest, raw)
+	return nil
+}
+
+// errDigestMismatch reports a bundle blob whose content does not match its name
+var errDigestMismatch = errors.New("content does not match its digest")
+
+// readVerified reads a whole blob and checks its sha256 digest
+func readVerified(r io.Reader, digest v1.Hash) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	if digest.Algorithm != "sha256" {
+		return nil, fmt.Errorf("unsupported digest algorithm in bundle: %s", digest)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != digest.Hex {
+		return nil, fmt.Errorf("blob %s in bundle: %w", digest, errDigestMismatch)
+	}
+	return data, nil
+}
+
+// store adds a blob to the cache from r, verifying its sha256 digest; a blob
+// already present is kept
+func (c *blobCache) store(digest v1.Hash, r io.Reader) error {
+	if digest.Algorithm != "sha256" {
+		return fmt.Errorf("unsupported digest algorithm: %s", digest)
+	}
+	if _, err := os.Stat(c.path(digest)); err == nil {
+		return nil
+	}
+	dir := filepath.Dir(c.path(digest))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	temp, err := os.CreateTemp(dir, digest.Hex+".partial-*")
+	if err != nil {
+		return fmt.Errorf("failed to create cache file: %w", err)
+	}
+	defer os.Remove(temp.Name())
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(temp, hash), r); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to store blob %s: %w", digest, err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to store blob %s: %w", digest, err)
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != digest.Hex {
+		return fmt.Errorf("blob %s: %w", digest, errDigestMismatch)
+	}
+	if err := os.Rename(temp.Name(), c.path(digest)); err != nil {
+		return fmt.Errorf("failed to store blob %s: %w", digest, err)
+	}
+	return nil
+}
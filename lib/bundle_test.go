@@ -0,0 +1,144 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	host := newTestRegistry(t)
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "etc/motd", content: "hello"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	imageRef := host + "/test/app:v1"
+	pushTestImage(t, imageRef, image)
+	indexRef := host + "/test/multiarch:latest"
+	pushTestIndex(t, indexRef)
+
+	online := NewImageExporter()
+	var expected bytes.Buffer
+	if err := online.ExportImageFilesystemToWriter(imageRef, &expected, nil); err != nil {
+		t.Fatalf("Expected no error online, got %v", err)
+	}
+	var imageBundle, indexBundle bytes.Buffer
+	if err := online.ExportBundle(imageRef, &imageBundle, nil, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := online.ExportBundle(indexRef, &indexBundle, nil, &BundleOptions{Platforms: []string{"linux/arm64"}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// index.json records the bundled platforms
+	if platforms := bundleAnnotation(t, indexBundle.Bytes(), AnnotationBundlePlatforms); platforms != "linux/arm64" {
+		t.Errorf("Expected platforms linux/arm64 in index.json, got %q", platforms)
+	}
+
+	cacheDir := t.TempDir()
+	importer := NewImageExporter(WithCache(cacheDir))
+	for _, bundle := range []struct {
+		data *bytes.Buffer
+		ref  string
+	}{{&imageBundle, imageRef}, {&indexBundle, indexRef}} {
+		refs, err := importer.ImportBundle(bytes.NewReader(bundle.data.Bytes()))
+		if err != nil {
+			t.Fatalf("Expected no error importing %s, got %v", bundle.ref, err)
+		}
+		if !reflect.DeepEqual(refs, []string{bundle.ref}) {
+			t.Errorf("Expected [%s], got %v", bundle.ref, refs)
+		}
+	}
+
+	offline := NewImageExporter(WithCache(cacheDir), WithOffline())
+	var out bytes.Buffer
+	if err := offline.ExportImageFilesystemToWriter(imageRef, &out, nil); err != nil {
+		t.Fatalf("Expected the imported image offline, got %v", err)
+	}
+	if !reflect.DeepEqual(readTestTar(t, out.Bytes()), readTestTar(t, expected.Bytes())) {
+		t.Error("Expected the offline export to match the online one")
+	}
+	out.Reset()
+	if err := offline.ExportImageFilesystemToWriterWithOptions(indexRef, &out, nil, &ExportOptions{Platform: "linux/arm64"}); err != nil {
+		t.Fatalf("Expected the bundled platform offline, got %v", err)
+	}
+	if files := readTestTar(t, out.Bytes()); files["bin/app"] != "arm64" {
+		t.Errorf("Expected the arm64 filesystem, got %v", files)
+	}
+	err = offline.ExportImageFilesystemToWriterWithOptions(indexRef, &bytes.Buffer{}, nil, &ExportOptions{Platform: "linux/amd64"})
+	if !errors.Is(err, ErrOffline) {
+		t.Errorf("Expected ErrOffline for a platform left out of the bundle, got %v", err)
+	}
+
+	if _, err := NewImageExporter().ImportBundle(bytes.NewReader(imageBundle.Bytes())); err == nil {
+		t.Error("Expected an error importing without a cache")
+	}
+	if err := online.ExportBundle(indexRef, io.Discard, nil, &BundleOptions{Platforms: []string{"windows/amd64"}}); err == nil {
+		t.Error("Expected an error when no platform matches")
+	}
+}
+
+func TestImportBundleRejectsCorruptBlobs(t *testing.T) {
+	host := newTestRegistry(t)
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "etc/motd", content: "hello"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	pushTestImage(t, host+"/test/app:v1", image)
+	var bundle bytes.Buffer
+	if err := NewImageExporter().ExportBundle(host+"/test/app:v1", &bundle, nil, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Flip a byte of every blob while copying the bundle
+	var corrupt bytes.Buffer
+	tr, tw := tar.NewReader(&bundle), tar.NewWriter(&corrupt)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read bundle: %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		if len(data) > 0 && header.Name != "index.json" && header.Name != "oci-layout" {
+			data[0] ^= 0xff
+		}
+		tw.WriteHeader(header)
+		tw.Write(data)
+	}
+	tw.Close()
+
+	if _, err := NewImageExporter(WithCache(t.TempDir())).ImportBundle(&corrupt); !errors.Is(err, errDigestMismatch) {
+		t.Errorf("Expected a digest mismatch, got %v", err)
+	}
+}
+
+// bundleAnnotation returns an annotation of the image in the index.json of a bundle
+func bundleAnnotation(t *testing.T, bundle []byte, key string) string {
+	t.Helper()
+
+	tr := tar.NewReader(bytes.NewReader(bundle))
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			t.Fatalf("Expected index.json in the bundle, got %v", err)
+		}
+		if header.Name != "index.json" {
+			continue
+		}
+		layout, err := v1.ParseIndexManifest(tr)
+		if err != nil || len(layout.Manifests) != 1 {
+			t.Fatalf("Expected one image in index.json, got %v", err)
+		}
+		return layout.Manifests[0].Annotations[key]
+	}
+}
@@ -0,0 +1,255 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+// CacheStats counts blob cache activity since the exporter was created.
+// Because blobs are stored by digest, layers and configs shared between the
+// platforms of a multi-arch image (or between images) are downloaded once.
+type CacheStats struct {
+	// Hits is the number of blobs served from the cache
+	Hits int `json:"hits"`
+
+	// Misses is the number of blobs downloaded and added to the cache
+	Misses int `json:"misses"`
+
+	// BytesReused is the compressed size of the blobs served from the cache
+	BytesReused int64 `json:"bytesReused"`
+
+	// BytesFetched is the compressed size of the blobs downloaded
+	BytesFetched int64 `json:"bytesFetched"`
+}
+
+// WithCache stores every layer and config blob the exporter downloads in a
+// content-addressed cache under dir (blobs/sha256/<hex>, as in an OCI layout),
+// and serves later requests for the same digest from disk. Blobs are verified
+// against their digest before they are added. The manifests of exported images
+// and the digests their tags pointed to are recorded as well (under
+// blobs/manifests and blobs/refs), for WithOffline.
+//
+// Example:
+//
+//	exporter := NewImageExporter(WithCache("/var/cache/imgex"))
+//	for _, platform := range []string{"linux/amd64", "linux/arm64"} {
+//	    opts := &ExportOptions{Platform: platform}
+//	    err := exporter.ExportImageFilesystemWithOptions("app:v1", "app-"+platform+".tar", nil, opts)
+//	    ...
+//	}
+//	fmt.Printf("%+v\n", exporter.CacheStats())
+func WithCache(dir string) ExporterOption {
+	return func(e *imageExporter) {
+		e.cache = &blobCache{dir: dir, logger: discardLogger}
+	}
+}
+
+// CacheStats returns the blob cache counters, or zero values without WithCache
+func (e *imageExporter) CacheStats() CacheStats {
+	if e.cache == nil {
+		return CacheStats{}
+	}
+	e.cache.mu.Lock()
+	defer e.cache.mu.Unlock()
+	return e.cache.stats
+}
+
+// blobCache is a content-addressed store of compressed blobs on disk
+type blobCache struct {
+	dir    string
+	logger *slog.Logger // the exporter's logger, set by NewImageExporter
+
+	mu    sync.Mutex
+	stats CacheStats
+}
+
+// path returns where a blob is stored
+func (c *blobCache) path(digest v1.Hash) string {
+	return filepath.Join(c.dir, "blobs", digest.Algorithm, digest.Hex)
+}
+
+// open returns the blob with the given digest, calling fetch and storing the
+// result if it is not cached yet. A fetched blob is only added to the cache once
+// it has been read completely and matches its digest.
+func (c *blobCache) open(digest v1.Hash, fetch func() (io.ReadCloser, error)) (io.ReadCloser, error) {
+	if file, err := os.Open(c.path(digest)); err == nil {
+		if info, err := file.Stat(); err == nil {
+			c.record(true, info.Size())
+			c.logger.Debug("blob cache hit", "digest", digest.String(), "size", info.Size())
+		}
+		return file, nil
+	}
+	c.logger.Debug("blob cache miss", "digest", digest.String())
+
+	blob, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	if digest.Algorithm != "sha256" {
+		// Only sha256 blobs can be verified before caching
+		return blob, nil
+	}
+
+	dir := filepath.Dir(c.path(digest))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		blob.Close()
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	temp, err := os.CreateTemp(dir, digest.Hex+".partial-*")
+	if err != nil {
+		blob.Close()
+		return nil, fmt.Errorf("failed to create cache file: %w", err)
+	}
+
+	return &cachingReader{cache: c, digest: digest, source: blob, temp: temp, hash: sha256.New()}, nil
+}
+
+// record updates the statistics for one blob access
+func (c *blobCache) record(hit bool, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hit {
+		c.stats.Hits++
+		c.stats.BytesReused += size
+	} else {
+		c.stats.Misses++
+		c.stats.BytesFetched += size
+	}
+}
+
+// cachingReader copies a blob into a temp file while it is read and moves the
+// file into the cache when the stream ends with the expected digest
+type cachingReader struct {
+	cache  *blobCache
+	digest v1.Hash
+	source io.ReadCloser
+	temp   *os.File
+	hash   hash.Hash
+	size   int64
+}
+
+// Read implements io.Reader
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.source.Read(p)
+	if n > 0 && r.temp != nil {
+		r.hash.Write(p[:n])
+		r.size += int64(n)
+		if _, werr := r.temp.Write(p[:n]); werr != nil {
+			// Caching is best effort; keep serving the blob
+			r.discard()
+		}
+	}
+	if err == io.EOF && r.temp != nil {
+		r.commit()
+	}
+	return n, err
+}
+
+// commit moves the verified temp file into place
+func (r *cachingReader) commit() {
+	if hex.EncodeToString(r.hash.Sum(nil)) != r.digest.Hex {
+		r.discard()
+		return
+	}
+	name := r.temp.Name()
+	if err := r.temp.Close(); err != nil {
+		os.Remove(name)
+		r.temp = nil
+		return
+	}
+	r.temp = nil
+	if err := os.Rename(name, r.cache.path(r.digest)); err != nil {
+		os.Remove(name)
+		return
+	}
+	r.cache.record(false, r.size)
+}
+
+// discard drops the partial cache file
+func (r *cachingReader) discard() {
+	if r.temp == nil {
+		return
+	}
+	r.temp.Close()
+	os.Remove(r.temp.Name())
+	r.temp = nil
+}
+
+// maxCacheDrain bounds how much unread data Close reads to complete a cache entry
+const maxCacheDrain = 1 << 20
+
+// Close implements io.Closer. Consumers such as tar readers often stop before the
+// end of the blob (e.g. trailing padding), so a short unread tail is drained to
+// complete the cache entry; otherwise the partial file is discarded.
+func (r *cachingReader) Close() error {
+	if r.temp != nil {
+		io.CopyN(io.Discard, r, maxCacheDrain)
+	}
+	r.discard()
+	return r.source.Close()
+}
+
+// cachedImage serves the config blob of an image through the blob cache
+type cachedImage struct {
+	v1.Image
+	cache *blobCache
+}
+
+// RawConfigFile implements v1.Image
+func (i *cachedImage) RawConfigFile() ([]byte, error) {
+	digest, err := i.Image.ConfigName()
+	if err != nil {
+		return nil, err
+	}
+	blob, err := i.cache.open(digest, func() (io.ReadCloser, error) {
+		raw, err := i.Image.RawConfigFile()
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(raw)), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	return io.ReadAll(blob)
+}
+
+// ConfigFile implements v1.Image
+func (i *cachedImage) ConfigFile() (*v1.ConfigFile, error) {
+	raw, err := i.RawConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	return v1.ParseConfigFile(bytes.NewReader(raw))
+}
+
+// withCache wraps an image so its config is served through the cache, if enabled
+func (e *imageExporter) withCache(image v1.Image) v1.Image {
+	if e.cache == nil {
+		return image
+	}
+	return &cachedImage{Image: image, cache: e.cache}
+}
+
+// layerBlob returns the compressed blob of a layer, through the cache if enabled
+func (e *imageExporter) layerBlob(layer v1.Layer) (io.ReadCloser, error) {
+	if e.cache == nil {
+		return layer.Compressed()
+	}
+	digest, err := layer.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get layer digest: %w", err)
+	}
+	return e.cache.open(digest, layer.Compressed)
+}
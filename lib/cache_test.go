@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// pushTestIndex pushes a two-platform index whose images share a base layer
+func pushTestIndex(t *testing.T, imageRef string) {
+	t.Helper()
+
+	shared := newTestLayer(t, testEntry{name: "etc/os-release", content: "shared"})
+	index := v1.ImageIndex(empty.Index)
+	for _, arch := range []string{"amd64", "arm64"} {
+		image, err := mutate.AppendLayers(empty.Image, shared, newTestLayer(t, testEntry{name: "bin/app", content: arch}))
+		if err != nil {
+			t.Fatalf("Failed to build test image: %v", err)
+		}
+		configFile, err := image.ConfigFile()
+		if err != nil {
+			t.Fatalf("Failed to get test config: %v", err)
+		}
+		configFile.OS, configFile.Architecture = "linux", arch
+		image, err = mutate.ConfigFile(image, configFile)
+		if err != nil {
+			t.Fatalf("Failed to set test config: %v", err)
+		}
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{
+			Add:        image,
+			Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: arch}},
+		})
+	}
+
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		t.Fatalf("Failed to parse test reference %s: %v", imageRef, err)
+	}
+	if err := remote.WriteIndex(ref, index); err != nil {
+		t.Fatalf("Failed to push test index %s: %v", imageRef, err)
+	}
+}
+
+func TestCacheDedupAcrossPlatforms(t *testing.T) {
+	host := newTestRegistry(t)
+	imageRef := host + "/test/multiarch:latest"
+	pushTestIndex(t, imageRef)
+
+	cacheDir := t.TempDir()
+	exporter := NewImageExporter(WithCache(cacheDir))
+
+	exports := make(map[string]map[string]string)
+	for _, platform := range []string{"linux/amd64", "linux/arm64"} {
+		var buf bytes.Buffer
+		err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &buf, nil, &ExportOptions{Platform: platform})
+		if err != nil {
+			t.Fatalf("Expected no error exporting %s, got %v", platform, err)
+		}
+		exports[platform] = readTestTar(t, buf.Bytes())
+	}
+
+	if exports["linux/amd64"]["bin/app"] != "amd64" || exports["linux/arm64"]["bin/app"] != "arm64" {
+		t.Errorf("Expected each platform's own bin/app, got %v", exports)
+	}
+	if exports["linux/arm64"]["etc/os-release"] != "shared" {
+		t.Errorf("Expected the shared layer in the arm64 export, got %v", exports["linux/arm64"])
+	}
+
+	// 3 distinct layers are downloaded, the shared layer is reused once
+	stats := exporter.CacheStats()
+	if stats.Misses != 3 {
+		t.Errorf("Expected 3 downloaded blobs, got %+v", stats)
+	}
+	if stats.Hits != 1 {
+		t.Errorf("Expected the shared layer to be reused once, got %+v", stats)
+	}
+
+	blobs, err := filepath.Glob(filepath.Join(cacheDir, "blobs", "sha256", "*"))
+	if err != nil {
+		t.Fatalf("Failed to list cache: %v", err)
+	}
+	if len(blobs) != 3 {
+		t.Errorf("Expected 3 cached blobs, got %v", blobs)
+	}
+}
+
+func TestCacheServesRepeatedExports(t *testing.T) {
+	layer := newTestLayer(t,
+		testEntry{name: "etc/", typeflag: tar.TypeDir},
+		testEntry{name: "etc/hostname", content: "cached"},
+	)
+	image, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/cached:latest"
+	pushTestImage(t, imageRef, image)
+
+	cacheDir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		exporter := NewImageExporter(WithCache(cacheDir))
+		var buf bytes.Buffer
+		if err := exporter.ExportImageFilesystemToWriter(imageRef, &buf, nil); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if files := readTestTar(t, buf.Bytes()); files["etc/hostname"] != "cached" {
+			t.Errorf("Expected etc/hostname, got %v", files)
+		}
+		if _, err := exporter.GetImageConfig(imageRef, nil); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		stats := exporter.CacheStats()
+		if i == 0 && (stats.Misses != 2 || stats.Hits != 0) {
+			t.Errorf("Expected config and layer to be downloaded, got %+v", stats)
+		}
+		if i == 1 && (stats.Misses != 0 || stats.Hits != 2) {
+			t.Errorf("Expected config and layer to come from the cache, got %+v", stats)
+		}
+	}
+
+	// A corrupted entry is never written: blobs are verified before they are cached
+	entries, err := os.ReadDir(filepath.Join(cacheDir, "blobs", "sha256"))
+	if err != nil {
+		t.Fatalf("Failed to read cache: %v", err)
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != "" {
+			t.Errorf("Expected no partial files in the cache, got %s", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// IsCanceled reports whether an export stopped because ExportOptions.Context
+// was canceled or its deadline passed
+func IsCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// exportContext returns the context of an export, never nil
+func exportContext(opts *ExportOptions) context.Context {
+	if opts.Context == nil {
+		return context.Background()
+	}
+	return opts.Context
+}
+
+// contextReader fails reads once its context is done, stopping a layer
+// download at the next chunk
+type contextReader struct {
+	ctx context.Context
+	io.ReadCloser
+}
+
+// Read implements io.Reader
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ReadCloser.Read(p)
+}
+
+// contextWriter fails writes once its context is done
+type contextWriter struct {
+	ctx context.Context
+	io.Writer
+}
+
+// Write implements io.Writer
+func (w *contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.Writer.Write(p)
+}
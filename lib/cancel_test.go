@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestExportContext(t *testing.T) {
+	host := newTestRegistry(t)
+	image, err := mutate.AppendLayers(empty.Image,
+		newTestLayer(t, testEntry{name: "first", content: "first"}),
+		newTestLayer(t, testEntry{name: "second", content: strings.Repeat("x", 64<<10)}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	imageRef := host + "/test/cancel:latest"
+	pushTestImage(t, imageRef, image)
+	exporter := NewImageExporter()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var out bytes.Buffer
+	err = exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &out, nil, &ExportOptions{Context: ctx})
+	if !IsCanceled(err) {
+		t.Errorf("Expected a canceled export, got %v", err)
+	}
+
+	// Canceling while the first layer is read stops before the second is applied
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	layersStarted := 0
+	opts := &ExportOptions{
+		Context: ctx,
+		LayerProgress: func(layer, layers int, read, size int64) {
+			if read == 0 {
+				layersStarted++
+			}
+			cancel()
+		},
+	}
+	out.Reset()
+	err = exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &out, nil, opts)
+	if !IsCanceled(err) {
+		t.Errorf("Expected a canceled export, got %v", err)
+	}
+	if layersStarted != 1 || out.Len() != 0 {
+		t.Errorf("Expected the export to stop in the first layer, got %d layers and %d bytes written", layersStarted, out.Len())
+	}
+
+	out.Reset()
+	err = exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &out, nil, &ExportOptions{Context: context.Background()})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if files := readTestTar(t, out.Bytes()); files["first"] != "first" {
+		t.Errorf("Expected the archive with a live context, got %v", files)
+	}
+}
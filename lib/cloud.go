@@ -0,0 +1,35 @@
+package lib
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ecrRegistryPattern matches private Amazon ECR registry hosts such as
+// 123456789012.dkr.ecr.us-east-1.amazonaws.com, capturing the account,
+// the optional FIPS marker, the region and the optional China partition suffix
+var ecrRegistryPattern = regexp.MustCompile(`^(\d{12})\.dkr\.ecr(-fips)?\.([a-z0-9-]+)\.amazonaws\.com(\.cn)?$`)
+
+// IsECRRegistry reports whether a registry host is a private Amazon ECR registry
+func IsECRRegistry(registry string) bool {
+	return ecrRegistryPattern.MatchString(registry)
+}
+
+// googleRegistryPattern matches Google Container Registry (gcr.io, us.gcr.io, ...)
+// and Artifact Registry (us-central1-docker.pkg.dev, ...) hosts
+var googleRegistryPattern = regexp.MustCompile(`^(([a-z0-9-]+\.)?gcr\.io|[a-z0-9-]+-docker\.pkg\.dev)$`)
+
+// IsGoogleRegistry reports whether a registry host is GCR or Artifact Registry
+func IsGoogleRegistry(registry string) bool {
+	return googleRegistryPattern.MatchString(registry)
+}
+
+// IsACRRegistry reports whether a registry host is an Azure Container Registry
+func IsACRRegistry(registry string) bool {
+	for _, suffix := range []string{".azurecr.io", ".azurecr.cn", ".azurecr.us"} {
+		if strings.HasSuffix(registry, suffix) && len(registry) > len(suffix) {
+			return true
+		}
+	}
+	return false
+}
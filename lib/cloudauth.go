@@ -0,0 +1,7 @@
+//go:build !nocloud
+
+package lib
+
+func init() {
+	RegisterFeature(FeatureCloudAuth)
+}
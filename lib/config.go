@@ -1,22 +1,78 @@
 package lib
 
 import (
+	"crypto"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
 
 	"github.com/google/go-containerregistry/pkg/authn"
-	"github.com/google/go-containerregistry/pkg/name"
-	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1"
 )
 
 // imageExporter is the concrete implementation of ImageExporter interface.
 // It provides methods to extract Docker image configurations from container registries.
-type imageExporter struct{}
+type imageExporter struct {
+	proxy          *url.URL            // explicit proxy for registry traffic, nil to use the environment
+	baseTransport  http.RoundTripper   // caller-supplied transport, nil to use the default
+	wrappers       []TransportWrapper  // applied on top of the final transport, in order
+	keychain       authn.Keychain      // credential source when no AuthConfig is given
+	authFile       *AuthFile           // multi-registry credentials consulted before the keychain, nil if unset
+	authMode       AuthMode            // how the keychain is combined with cloud credentials
+	keyring        KeyringMode         // whether Login stores credentials in the OS keyring
+	ecr            *ecrKeychain        // Amazon ECR token source
+	google         *googleKeychain     // GCR and Artifact Registry token source
+	acr            *acrKeychain        // Azure Container Registry token source
+	cache          *blobCache          // content-addressed blob cache, nil to always download
+	decryptionKeys []crypto.PrivateKey // keys for encrypted OCI layers
+	warnings       WarningCallback     // receives non-fatal problems, nil to discard them
+	logger         *slog.Logger        // receives diagnostic events, nil to discard them
+	httpTransport  http.RoundTripper   // transport shared by all registry requests
+	clients        *registryClients    // registry clients shared between calls, nil to create one per call
+	resolvers      []NameResolver      // short-name resolvers, in order
+	allowRepos     []string            // repository patterns that may be contacted, empty for all
+	denyRepos      []string            // repository patterns that may never be contacted
+	offline        bool                // serve images from the cache only and refuse network access
+	localSources   *bool               // whether local image sources may be read, nil to allow them without a repository policy
+	limits         ExportLimits        // bounds of every flattened filesystem, merged with ExportOptions.Limits
+
+	containerdRoot      string      // root directory of containerd for containerd: references, empty for the default
+	containerdNamespace string      // containerd namespace of image names, empty for the default
+	dockerHost          string      // Docker Engine endpoint, empty for DOCKER_HOST or the default socket
+	source              ImageSource // where references without a prefix are read from
+
+	mirrors       map[string][]registryMirror // mirrors tried before each registry, in order
+	foreignLayers ForeignLayerPolicy          // whether foreign layers are fetched or skipped
+
+	cosign   *CosignPolicy   // cosign signatures images must carry, nil to skip verification
+	notation *NotationPolicy // notation trust policy images must satisfy, nil to skip verification
+	verified sync.Map        // repository@digest of images whose signatures were verified
+}
 
 // NewImageExporter creates a new instance of ImageExporter.
 // This is the primary entry point for creating an image exporter that can
 // interact with Docker registries to extract image configurations and filesystems.
-func NewImageExporter() ImageExporter {
-	return &imageExporter{}
+// Options such as WithProxy, WithTransport and WithDockerConfig customize how the registry is reached.
+func NewImageExporter(opts ...ExporterOption) ImageExporter {
+	e := &imageExporter{keychain: &dockerConfigKeychain{}}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.cache != nil {
+		e.cache.logger = e.log()
+	}
+	e.clients = &registryClients{}
+	e.httpTransport = e.transport()
+	if e.offline {
+		e.httpTransport = offlineTransport{}
+	}
+	e.httpTransport = &layerURLGuard{RoundTripper: e.httpTransport, check: e.checkLayerURL}
+	e.ecr = &ecrKeychain{transport: e.httpTransport}
+	e.google = &googleKeychain{transport: e.httpTransport}
+	e.acr = &acrKeychain{transport: e.httpTransport}
+	return e
 }
 
 // GetImageConfig retrieves the configuration of a Docker image from a registry.
@@ -42,30 +98,11 @@ func NewImageExporter() ImageExporter {
 //	}
 //	fmt.Printf("Entrypoint: %v\n", config.Entrypoint)
 func (e *imageExporter) GetImageConfig(imageRef string, auth *AuthConfig) (*ImageConfig, error) {
-	// Parse the image reference to ensure it's valid and extract registry/repository information
-	ref, err := name.ParseReference(imageRef)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse image reference %s: %w", imageRef, err)
-	}
-
-	// Configure authentication for registry access
-	var authOption remote.Option
-	if auth != nil {
-		// Use provided credentials for private registries
-		authOption = remote.WithAuth(&authn.Basic{
-			Username: auth.Username,
-			Password: auth.Password,
-		})
-	} else {
-		// Fall back to system keychain (Docker credentials, etc.)
-		authOption = remote.WithAuthFromKeychain(authn.DefaultKeychain)
-	}
-
 	// Fetch the image metadata from the registry
 	// This downloads the manifest and config blob but not the layer data
-	image, err := remote.Image(ref, authOption)
+	image, err := e.fetchImage(imageRef, auth)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch image %s: %w", imageRef, err)
+		return nil, err
 	}
 
 	// Extract the configuration file from the image
@@ -73,16 +110,30 @@ func (e *imageExporter) GetImageConfig(imageRef string, auth *AuthConfig) (*Imag
 	if err != nil {
 		return nil, fmt.Errorf("failed to get config file: %w", err)
 	}
+	e.log().Debug("image config fetched", "image", imageRef,
+		"os", configFile.OS, "architecture", configFile.Architecture)
 
 	// Convert the registry config format to our simplified format
 	config := &ImageConfig{
-		User:       configFile.Config.User,
-		Entrypoint: configFile.Config.Entrypoint,
-		Cmd:        configFile.Config.Cmd,
-		WorkingDir: configFile.Config.WorkingDir,
-		Env:        configFile.Config.Env,
-		Labels:     configFile.Config.Labels,
+		SchemaVersion: SchemaVersion,
+		User:          configFile.Config.User,
+		Entrypoint:    configFile.Config.Entrypoint,
+		Cmd:           configFile.Config.Cmd,
+		WorkingDir:    configFile.Config.WorkingDir,
+		Env:           configFile.Config.Env,
+		Labels:        configFile.Config.Labels,
+		OS:            configFile.OS,
+		Architecture:  configFile.Architecture,
+		Variant:       configFile.Variant,
+		OSVersion:     configFile.OSVersion,
 	}
 
 	return config, nil
 }
+
+// fetchImage parses imageRef and fetches its manifest from the registry, or
+// opens a docker-archive: tarball. Layer data is not downloaded until the
+// returned image's layers are read.
+func (e *imageExporter) fetchImage(imageRef string, auth *AuthConfig) (v1.Image, error) {
+	return e.openImage(imageRef, auth, nil)
+}
@@ -0,0 +1,289 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// ContainerdPrefix marks an image reference as an image of the local containerd:
+//
+//	containerd:docker.io/library/alpine:3   the image of that name
+//	containerd:alpine:3                     the same, Docker Hub names are expanded
+//	containerd:sha256:<hex>                 a manifest or index by digest
+//
+// The image is read from containerd's metadata and content store on disk (see
+// WithContainerd), so a node exports the rootfs of an image it already pulled
+// without registry access or a running containerd API. Names are looked up in
+// one namespace; Kubernetes nodes keep their images in k8s.io.
+const ContainerdPrefix = "containerd:"
+
+// DefaultContainerdRoot is the root directory of a default containerd installation
+const DefaultContainerdRoot = "/var/lib/containerd"
+
+// EnvContainerdNamespace names the containerd namespace when none is configured,
+// as for ctr
+const EnvContainerdNamespace = "CONTAINERD_NAMESPACE"
+
+// WithContainerd sets where containerd: references are read from: the root
+// directory of containerd (DefaultContainerdRoot when empty) and the namespace
+// of the image names (CONTAINERD_NAMESPACE, or "default", when empty). Reading
+// the store needs read access to the root, which usually means running as root.
+//
+// Example:
+//
+//	exporter := NewImageExporter(WithContainerd("", "k8s.io"))
+//	err := exporter.ExportImageFilesystem("containerd:registry.k8s.io/pause:3.9", "pause.tar", nil)
+func WithContainerd(root, namespace string) ExporterOption {
+	return func(e *imageExporter) {
+		e.containerdRoot = root
+		e.containerdNamespace = namespace
+	}
+}
+
+// containerdStore is the on-disk store of a containerd installation
+type containerdStore struct {
+	root      string
+	namespace string
+}
+
+// containerdStore returns the store configured by WithContainerd
+func (e *imageExporter) containerdStore() *containerdStore {
+	store := &containerdStore{root: e.containerdRoot, namespace: e.containerdNamespace}
+	if store.root == "" {
+		store.root = DefaultContainerdRoot
+	}
+	if store.namespace == "" {
+		store.namespace = os.Getenv(EnvContainerdNamespace)
+	}
+	if store.namespace == "" {
+		store.namespace = "default"
+	}
+	return store
+}
+
+// blobPath returns where the content store keeps a blob
+func (s *containerdStore) blobPath(digest v1.Hash) string {
+	return filepath.Join(s.root, "io.containerd.content.v1.content", "blobs", digest.Algorithm, digest.Hex)
+}
+
+// blob reads a small blob (a manifest, index or config) from the content store
+func (s *containerdStore) blob(digest v1.Hash) ([]byte, error) {
+	data, err := os.ReadFile(s.blobPath(digest))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("blob %s is not in the content store", digest)
+	}
+	return data, err
+}
+
+// lookup returns the target descriptor of the image named imageName. Docker
+// Hub short names are also tried in the docker.io form containerd stores.
+func (s *containerdStore) lookup(imageName string) (v1.Descriptor, error) {
+	db, root, err := openBolt(filepath.Join(s.root, "io.containerd.metadata.v1.bolt", "meta.db"))
+	if err != nil {
+		return v1.Descriptor{}, fmt.Errorf("failed to open containerd metadata: %w", err)
+	}
+	defer db.Close()
+
+	images, err := root.bucket("v1")
+	if err == nil {
+		if images, err = images.bucket(s.namespace); err == nil {
+			images, err = images.bucket("images")
+		}
+	}
+	if errors.Is(err, errBoltNotFound) {
+		return v1.Descriptor{}, fmt.Errorf("containerd namespace %s has no images", s.namespace)
+	}
+	if err != nil {
+		return v1.Descriptor{}, fmt.Errorf("failed to read containerd metadata: %w", err)
+	}
+
+	candidates := []string{imageName}
+	if ref, err := name.ParseReference(imageName); err == nil {
+		candidates = append(candidates, strings.Replace(ref.Name(), name.DefaultRegistry+"/", "docker.io/", 1))
+	}
+	for _, candidate := range candidates {
+		image, err := images.bucket(candidate)
+		if errors.Is(err, errBoltNotFound) {
+			continue
+		}
+		if err != nil {
+			return v1.Descriptor{}, fmt.Errorf("failed to read image %s: %w", candidate, err)
+		}
+		target, err := image.bucket("target")
+		if err != nil {
+			return v1.Descriptor{}, fmt.Errorf("failed to read image %s: %w", candidate, err)
+		}
+		digestValue, err := target.value("digest")
+		if err != nil {
+			return v1.Descriptor{}, fmt.Errorf("failed to read image %s: %w", candidate, err)
+		}
+		mediaType, err := target.value("mediatype")
+		if err != nil {
+			return v1.Descriptor{}, fmt.Errorf("failed to read image %s: %w", candidate, err)
+		}
+		digest, err := v1.NewHash(string(digestValue))
+		if err != nil {
+			return v1.Descriptor{}, fmt.Errorf("invalid digest of image %s: %w", candidate, err)
+		}
+		return v1.Descriptor{Digest: digest, MediaType: types.MediaType(mediaType)}, nil
+	}
+	return v1.Descriptor{}, fmt.Errorf("no image named %s in containerd namespace %s", imageName, s.namespace)
+}
+
+// image returns the image of a containerd: reference, resolving an index to
+// platform (or linux/amd64) like a registry index
+func (s *containerdStore) image(source string, platform *v1.Platform) (v1.Image, error) {
+	var desc v1.Descriptor
+	if digest, err := v1.NewHash(source); err == nil {
+		desc.Digest = digest
+	} else if desc, err = s.lookup(source); err != nil {
+		return nil, err
+	}
+	raw, err := s.blob(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	if desc.MediaType == "" {
+		// Looked up by digest: tell manifests from indexes by their content
+		var probe struct {
+			MediaType types.MediaType `json:"mediaType"`
+			Manifests json.RawMessage `json:"manifests"`
+		}
+		if err := json.Unmarshal(raw, &probe); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", desc.Digest, err)
+		}
+		switch {
+		case probe.MediaType != "":
+			desc.MediaType = probe.MediaType
+		case probe.Manifests != nil:
+			desc.MediaType = types.OCIImageIndex
+		default:
+			desc.MediaType = types.OCIManifestSchema1
+		}
+	}
+
+	if desc.MediaType.IsIndex() {
+		index, err := v1.ParseIndexManifest(bytes.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse index %s: %w", desc.Digest, err)
+		}
+		want := v1.Platform{OS: "linux", Architecture: "amd64"}
+		if platform != nil {
+			want = *platform
+		}
+		var child *v1.Descriptor
+		for i := range index.Manifests {
+			candidate := &index.Manifests[i]
+			if candidate.MediaType.IsImage() && platformMatches(candidate.Platform, want) {
+				child = candidate
+				break
+			}
+		}
+		if child == nil {
+			return nil, fmt.Errorf("no child with platform %s in index %s", want.String(), desc.Digest)
+		}
+		if raw, err = s.blob(child.Digest); err != nil {
+			return nil, fmt.Errorf("platform %s: %w", want.String(), err)
+		}
+		desc = *child
+	} else if !desc.MediaType.IsImage() {
+		return nil, fmt.Errorf("unsupported media type %s", desc.MediaType)
+	}
+
+	manifest, err := v1.ParseManifest(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", desc.Digest, err)
+	}
+	image, err := partial.CompressedToImage(&contentManifest{store: s, raw: raw, mediaType: desc.MediaType, manifest: manifest})
+	if err != nil {
+		return nil, err
+	}
+	if desc.Platform == nil {
+		if err := checkImagePlatform(image, platform); err != nil {
+			return nil, err
+		}
+	}
+	return image, nil
+}
+
+// contentManifest is an image whose config and layers are read from the
+// containerd content store
+type contentManifest struct {
+	store     *containerdStore
+	raw       []byte
+	mediaType types.MediaType
+	manifest  *v1.Manifest
+}
+
+// RawConfigFile implements partial.CompressedImageCore
+func (m *contentManifest) RawConfigFile() ([]byte, error) {
+	return m.store.blob(m.manifest.Config.Digest)
+}
+
+// MediaType implements partial.CompressedImageCore
+func (m *contentManifest) MediaType() (types.MediaType, error) {
+	return m.mediaType, nil
+}
+
+// RawManifest implements partial.CompressedImageCore
+func (m *contentManifest) RawManifest() ([]byte, error) {
+	return m.raw, nil
+}
+
+// LayerByDigest implements partial.CompressedImageCore
+func (m *contentManifest) LayerByDigest(digest v1.Hash) (partial.CompressedLayer, error) {
+	for _, layer := range m.manifest.Layers {
+		if layer.Digest == digest {
+			return &contentLayer{store: m.store, desc: layer}, nil
+		}
+	}
+	return nil, fmt.Errorf("layer %s is not in the manifest", digest)
+}
+
+// contentLayer is a layer blob read from the containerd content store
+type contentLayer struct {
+	store *containerdStore
+	desc  v1.Descriptor
+}
+
+// Digest implements partial.CompressedLayer
+func (l *contentLayer) Digest() (v1.Hash, error) {
+	return l.desc.Digest, nil
+}
+
+// Compressed implements partial.CompressedLayer
+func (l *contentLayer) Compressed() (io.ReadCloser, error) {
+	file, err := os.Open(l.store.blobPath(l.desc.Digest))
+	if errors.Is(err, os.ErrNotExist) {
+		// The CRI plugin deletes layer blobs once unpacked with discard_unpacked_layers
+		return nil, fmt.Errorf("layer %s is not in the content store (discarded after unpacking?)", l.desc.Digest)
+	}
+	return file, err
+}
+
+// Size implements partial.CompressedLayer
+func (l *contentLayer) Size() (int64, error) {
+	return l.desc.Size, nil
+}
+
+// MediaType implements partial.CompressedLayer
+func (l *contentLayer) MediaType() (types.MediaType, error) {
+	return l.desc.MediaType, nil
+}
+
+// Descriptor implements partial.Describable, keeping the annotations of the manifest
+func (l *contentLayer) Descriptor() (*v1.Descriptor, error) {
+	desc := l.desc
+	return &desc, nil
+}
@@ -0,0 +1,176 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// testBucket is the content of a bbolt bucket: []byte values and nested buckets
+type testBucket map[string]interface{}
+
+// boltTestLeaf encodes a bucket as a leaf page, nesting buckets inline
+func boltTestLeaf(bucket testBucket) []byte {
+	keys := make([]string, 0, len(bucket))
+	for key := range bucket {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	page := make([]byte, boltPageHeaderSize+len(keys)*boltElementSize)
+	binary.LittleEndian.PutUint16(page[8:], boltLeafPage)
+	binary.LittleEndian.PutUint16(page[10:], uint16(len(keys)))
+	for i, key := range keys {
+		var value []byte
+		var flags uint32
+		switch v := bucket[key].(type) {
+		case []byte:
+			value = v
+		case testBucket:
+			// An inline bucket: a header with root 0, then its page
+			value, flags = append(make([]byte, 16), boltTestLeaf(v)...), boltBucketLeaf
+		}
+		at := boltPageHeaderSize + i*boltElementSize
+		binary.LittleEndian.PutUint32(page[at:], flags)
+		binary.LittleEndian.PutUint32(page[at+4:], uint32(len(page)-at))
+		binary.LittleEndian.PutUint32(page[at+8:], uint32(len(key)))
+		binary.LittleEndian.PutUint32(page[at+12:], uint32(len(value)))
+		page = append(append(page, key...), value...)
+	}
+	return page
+}
+
+// writeTestBolt writes a bbolt file whose root page is a branch over one leaf
+// page per top-level key
+func writeTestBolt(t *testing.T, path string, root testBucket) {
+	t.Helper()
+	const pageSize = 4096
+	keys := make([]string, 0, len(root))
+	for key := range root {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pages := make([][]byte, 4, 4+len(keys))
+	branch := make([]byte, boltPageHeaderSize+len(keys)*boltElementSize)
+	binary.LittleEndian.PutUint16(branch[8:], boltBranchPage)
+	binary.LittleEndian.PutUint16(branch[10:], uint16(len(keys)))
+	for i, key := range keys {
+		at := boltPageHeaderSize + i*boltElementSize
+		binary.LittleEndian.PutUint32(branch[at:], uint32(len(branch)-at))
+		binary.LittleEndian.PutUint32(branch[at+4:], uint32(len(key)))
+		binary.LittleEndian.PutUint64(branch[at+8:], uint64(len(pages)))
+		branch = append(branch, key...)
+		pages = append(pages, boltTestLeaf(testBucket{key: root[key]}))
+	}
+	pages[3] = branch
+	pages[2] = make([]byte, boltPageHeaderSize) // an empty freelist
+
+	// Two meta pages; the second is newer
+	for i := 0; i < 2; i++ {
+		meta := make([]byte, boltPageHeaderSize+64)
+		binary.LittleEndian.PutUint32(meta[16:], boltMagic)
+		binary.LittleEndian.PutUint32(meta[20:], 2)
+		binary.LittleEndian.PutUint32(meta[24:], pageSize)
+		binary.LittleEndian.PutUint64(meta[32:], 3)
+		binary.LittleEndian.PutUint64(meta[48:], 2)
+		binary.LittleEndian.PutUint64(meta[56:], uint64(len(pages)))
+		binary.LittleEndian.PutUint64(meta[64:], uint64(i+1))
+		sum := fnv.New64a()
+		sum.Write(meta[16 : 16+boltMetaChecksumAt])
+		binary.LittleEndian.PutUint64(meta[16+boltMetaChecksumAt:], sum.Sum64())
+		pages[i] = meta
+	}
+
+	var file bytes.Buffer
+	for id, page := range pages {
+		if len(page) > pageSize {
+			t.Fatalf("Test page %d too large", id)
+		}
+		binary.LittleEndian.PutUint64(page, uint64(id))
+		file.Write(append(page, make([]byte, pageSize-len(page))...))
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, file.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestContent stores blobs in a containerd content store under root
+func writeTestContent(t *testing.T, root string, blobs ...[]byte) {
+	t.Helper()
+	for _, blob := range blobs {
+		digest, _, _ := v1.SHA256(bytes.NewReader(blob))
+		path := filepath.Join(root, "io.containerd.content.v1.content", "blobs", digest.Algorithm, digest.Hex)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, blob, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestContainerd(t *testing.T) {
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "etc/motd", content: "from containerd"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	root := t.TempDir()
+	raw, _ := image.RawManifest()
+	config, _ := image.RawConfigFile()
+	layers, _ := image.Layers()
+	compressed, _ := layers[0].Compressed()
+	layer, _ := io.ReadAll(compressed)
+	writeTestContent(t, root, raw, config, layer)
+
+	digest, _ := image.Digest()
+	mediaType, _ := image.MediaType()
+	target := testBucket{"target": testBucket{
+		"digest":    []byte(digest.String()),
+		"mediatype": []byte(mediaType),
+		"size":      binary.AppendVarint(nil, int64(len(raw))),
+	}}
+	writeTestBolt(t, filepath.Join(root, "io.containerd.metadata.v1.bolt", "meta.db"), testBucket{
+		"a": []byte("sorts before v1"),
+		"v1": testBucket{"k8s.io": testBucket{"images": testBucket{
+			"docker.io/library/app:v1":                    target,
+			"registry.example.com/app@" + digest.String(): target,
+		}}},
+	})
+
+	exporter := NewImageExporter(WithOffline(), WithContainerd(root, "k8s.io"))
+	for _, ref := range []string{"app:v1", "docker.io/library/app:v1", "registry.example.com/app@" + digest.String(), digest.String()} {
+		var out bytes.Buffer
+		if err := exporter.ExportImageFilesystemToWriter(ContainerdPrefix+ref, &out, nil); err != nil {
+			t.Fatalf("Expected no error for %s, got %v", ref, err)
+		}
+		if files := readTestTar(t, out.Bytes()); files["etc/motd"] != "from containerd" {
+			t.Errorf("Expected the filesystem of %s, got %v", ref, files)
+		}
+	}
+
+	if _, err := exporter.GetImageConfig(ContainerdPrefix+"app:v2", nil); err == nil {
+		t.Error("Expected an error for a missing image")
+	}
+	other := NewImageExporter(WithOffline(), WithContainerd(root, "default"))
+	if _, err := other.GetImageConfig(ContainerdPrefix+"app:v1", nil); err == nil {
+		t.Error("Expected an error for an image of another namespace")
+	}
+
+	// Layers discarded after unpacking
+	layerDigest, _ := layers[0].Digest()
+	os.Remove(filepath.Join(root, "io.containerd.content.v1.content", "blobs", "sha256", layerDigest.Hex))
+	if err := exporter.ExportImageFilesystemToWriter(ContainerdPrefix+"app:v1", io.Discard, nil); err == nil {
+		t.Error("Expected an error for a missing layer blob")
+	}
+}
@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// CopyOptions controls CopyImage
+type CopyOptions struct {
+	// Platform copies only the image of this platform (e.g. "linux/arm64") from
+	// a multi-arch index; the destination then holds a single-platform image
+	// with that image's digest. Empty copies the reference as it is, an index
+	// with every platform (and any attestations) keeping its digest.
+	Platform string
+}
+
+// CopyImage copies an image from one repository to another, on the same or
+// another registry. Blobs are streamed from the source to the destination
+// without being stored locally; blobs the destination already has are
+// skipped, and within one registry they are mounted across repositories
+// instead of uploaded (see pushImage).
+//
+// Parameters:
+//   - srcRef: Image reference to copy (e.g., "alpine:3")
+//   - dstRef: Destination reference, usually a tag (e.g., "registry.example.com/mirror/alpine:3")
+//   - auth: Optional authentication configuration, used for both registries
+//     (scope it with AuthConfig.Registry, or rely on the keychain for the other)
+//   - opts: Optional platform selection
+//
+// Returns:
+//   - string: The digest of the manifest (or index) written to dstRef
+//   - error: If a reference is invalid, or reading or writing fails
+//
+// Example:
+//
+//	digest, err := exporter.CopyImage("alpine:3", "registry.example.com/mirror/alpine:3", nil, nil)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	fmt.Println("copied", digest)
+func (e *imageExporter) CopyImage(srcRef, dstRef string, auth *AuthConfig, opts *CopyOptions) (string, error) {
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
+	src, err := e.parseReference(srcRef)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse image reference %s: %w", srcRef, err)
+	}
+	dst, err := e.parseReference(dstRef)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse image reference %s: %w", dstRef, err)
+	}
+
+	desc, err := e.getManifest(src, auth)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch image %s: %w", srcRef, err)
+	}
+	if err := e.verifySignatures(src, desc.Digest, auth); err != nil {
+		return "", err
+	}
+	if opts.Platform == "" && desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return "", fmt.Errorf("failed to read index %s: %w", srcRef, err)
+		}
+		e.log().Debug("copying index", "source", srcRef, "destination", dstRef, "digest", desc.Digest.String())
+		if err := remote.WriteIndex(dst, index, e.remoteOptions(auth)...); err != nil {
+			return "", fmt.Errorf("failed to push %s: %w", dst, err)
+		}
+		return desc.Digest.String(), nil
+	}
+
+	var platform *v1.Platform
+	if opts.Platform != "" {
+		if platform, err = v1.ParsePlatform(opts.Platform); err != nil {
+			return "", fmt.Errorf("invalid platform %q: %w", opts.Platform, err)
+		}
+	}
+	var image v1.Image
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return "", fmt.Errorf("failed to read index %s: %w", srcRef, err)
+		}
+		manifest, err := index.IndexManifest()
+		if err != nil {
+			return "", fmt.Errorf("failed to read index %s: %w", srcRef, err)
+		}
+		for _, child := range manifest.Manifests {
+			if child.MediaType.IsImage() && platformMatches(child.Platform, *platform) {
+				if image, err = index.Image(child.Digest); err != nil {
+					return "", fmt.Errorf("failed to read image %s of %s: %w", child.Digest, srcRef, err)
+				}
+				break
+			}
+		}
+		if image == nil {
+			return "", fmt.Errorf("no child with platform %s in index %s", platform, src)
+		}
+	} else {
+		if image, err = desc.Image(); err != nil {
+			return "", fmt.Errorf("failed to read image %s: %w", srcRef, err)
+		}
+		if err := checkImagePlatform(image, platform); err != nil {
+			return "", fmt.Errorf("failed to copy %s: %w", srcRef, err)
+		}
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		return "", fmt.Errorf("failed to read image %s: %w", srcRef, err)
+	}
+	e.log().Debug("copying image", "source", srcRef, "destination", dstRef, "digest", digest.String())
+	if err := e.pushImage(dst, image, src, auth); err != nil {
+		return "", err
+	}
+	return digest.String(), nil
+}
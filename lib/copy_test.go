@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestCopyImage(t *testing.T) {
+	src, dst := newTestRegistry(t), newTestRegistry(t)
+	indexRef := src + "/test/multiarch:latest"
+	pushTestIndex(t, indexRef)
+	exporter := NewImageExporter()
+
+	// The whole index keeps its digest
+	digest, err := exporter.CopyImage(indexRef, dst+"/mirror/multiarch:latest", nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected, err := exporter.ResolveDigest(indexRef, nil)
+	if err != nil {
+		t.Fatalf("Failed to resolve source: %v", err)
+	}
+	if digest != expected {
+		t.Errorf("Expected digest %s, got %s", expected, digest)
+	}
+	for _, platform := range []string{"linux/amd64", "linux/arm64"} {
+		var out bytes.Buffer
+		err := exporter.ExportImageFilesystemToWriterWithOptions(dst+"/mirror/multiarch:latest", &out, nil, &ExportOptions{Platform: platform})
+		if err != nil {
+			t.Fatalf("Expected %s in the copy, got %v", platform, err)
+		}
+	}
+
+	// One platform becomes a single image
+	digest, err = exporter.CopyImage(indexRef, dst+"/mirror/arm:v1", nil, &CopyOptions{Platform: "linux/arm64"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	ref, _ := name.ParseReference(dst + "/mirror/arm:v1")
+	desc, err := remote.Get(ref)
+	if err != nil {
+		t.Fatalf("Expected the copy to exist, got %v", err)
+	}
+	if desc.MediaType.IsIndex() || desc.Digest.String() != digest {
+		t.Errorf("Expected image %s, got %s %s", digest, desc.MediaType, desc.Digest)
+	}
+	var out bytes.Buffer
+	if err := exporter.ExportImageFilesystemToWriter(dst+"/mirror/arm:v1", &out, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if files := readTestTar(t, out.Bytes()); files["bin/app"] != "arm64" {
+		t.Errorf("Expected the arm64 filesystem, got %v", files)
+	}
+
+	if _, err := exporter.CopyImage(indexRef, dst+"/mirror/s390x:v1", nil, &CopyOptions{Platform: "linux/s390x"}); err == nil {
+		t.Error("Expected an error for a platform missing from the index")
+	}
+	if _, err := exporter.CopyImage(src+"/test/missing:v1", dst+"/mirror/missing:v1", nil, nil); err == nil {
+		t.Error("Expected an error for a missing source")
+	}
+}
@@ -0,0 +1,346 @@
+package lib
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+// Annotations of the layers of a cosign signature manifest
+const (
+	cosignSignatureAnnotation   = "dev.cosignproject.cosign/signature"
+	cosignCertificateAnnotation = "dev.sigstore.cosign/certificate"
+	cosignChainAnnotation       = "dev.sigstore.cosign/chain"
+	cosignBundleAnnotation      = "dev.sigstore.cosign/bundle"
+)
+
+// maxCosignPayload bounds the signed payloads read from a registry
+const maxCosignPayload = 1 << 20
+
+// Fulcio certificate extensions naming the OIDC issuer of the identity
+var (
+	fulcioIssuerOID       = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+	fulcioLegacyIssuerOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+)
+
+// CosignPolicy lists the cosign signatures WithCosignVerification accepts. An
+// image passes when any one of its signatures is accepted.
+type CosignPolicy struct {
+	// PublicKeys accepts signatures made with any of these keys (ECDSA, RSA or
+	// Ed25519), as created by 'cosign sign --key'. See LoadPublicKeys.
+	PublicKeys []crypto.PublicKey
+
+	// Identities accepts keyless signatures whose Fulcio certificate was issued
+	// to one of these identities
+	Identities []CosignIdentity
+
+	// FulcioRoots are the certificate authorities keyless certificates must
+	// chain to, e.g. the Fulcio root of the public Sigstore instance
+	FulcioRoots *x509.CertPool
+
+	// RekorKeys are the keys of the transparency log. Keyless signatures must
+	// carry an entry of the log signed by one of them, which proves when the
+	// signature was made: the Fulcio certificate is only valid for minutes.
+	RekorKeys []crypto.PublicKey
+
+	// Repository is where signatures are stored, like COSIGN_REPOSITORY;
+	// empty for the repository of the image
+	Repository string
+}
+
+// CosignIdentity is the identity a keyless signing certificate was issued to
+type CosignIdentity struct {
+	// Subject is the email address or URI of the certificate, e.g.
+	// "https://github.com/org/app/.github/workflows/release.yml@refs/heads/main"
+	Subject string
+
+	// Issuer is the OIDC issuer that authenticated the subject, e.g.
+	// "https://token.actions.githubusercontent.com"
+	Issuer string
+}
+
+// WithCosignVerification refuses images without a valid cosign signature. The
+// manifest digest a reference resolves to must be signed, as done by 'cosign
+// sign', before its configuration or filesystem is read; otherwise the
+// operation fails with an error wrapping ErrSignatureVerification. Signing an
+// index covers all of its platforms.
+//
+// Signatures are read from the sha256-<hex>.sig tag cosign stores them under.
+// Only images read from a registry can be verified: archives, local stores and
+// offline exports are refused.
+//
+// Example:
+//
+//	keys, err := LoadPublicKeys("cosign.pub")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	exporter := NewImageExporter(WithCosignVerification(CosignPolicy{PublicKeys: keys}))
+//	err = exporter.ExportImageFilesystem("ghcr.io/org/app:v1", "app.tar", nil)
+//	if errors.Is(err, ErrSignatureVerification) {
+//	    log.Fatalf("refusing unsigned image: %v", err)
+//	}
+func WithCosignVerification(policy CosignPolicy) ExporterOption {
+	return func(e *imageExporter) {
+		e.cosign = &policy
+	}
+}
+
+// cosignPayload is the simple signing payload cosign signs
+type cosignPayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// rekorBundle is the transparency log entry attached to a signature
+type rekorBundle struct {
+	SignedEntryTimestamp []byte     `json:"SignedEntryTimestamp"`
+	Payload              rekorEntry `json:"Payload"`
+}
+
+// rekorEntry is the part of a log entry the log signs. The fields are in the
+// order of their canonical JSON form.
+type rekorEntry struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+// verifyCosign checks the cosign signatures of the manifest digest of an image
+// in repo
+func (e *imageExporter) verifyCosign(repo name.Repository, digest v1.Hash, auth *AuthConfig) error {
+	policy := e.cosign
+	signatures := repo
+	if policy.Repository != "" {
+		var err error
+		if signatures, err = name.NewRepository(policy.Repository); err != nil {
+			return fmt.Errorf("invalid signature repository %q: %w", policy.Repository, err)
+		}
+	}
+	tag := signatures.Tag(digest.Algorithm + "-" + digest.Hex + ".sig")
+	desc, err := e.getRemote(tag, e.remoteOptions(auth)...)
+	if IsNotFound(err) {
+		return fmt.Errorf("%s@%s is not signed: %w", repo, digest, ErrSignatureVerification)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch signatures of %s@%s: %w", repo, digest, err)
+	}
+	image, err := desc.Image()
+	if err != nil {
+		return fmt.Errorf("failed to read signatures of %s@%s: %w", repo, digest, err)
+	}
+	manifest, err := image.Manifest()
+	if err != nil {
+		return fmt.Errorf("failed to read signatures of %s@%s: %w", repo, digest, err)
+	}
+
+	var problems []error
+	for _, layer := range manifest.Layers {
+		if _, ok := layer.Annotations[cosignSignatureAnnotation]; !ok {
+			continue
+		}
+		err := policy.verify(image, layer, digest)
+		if err == nil {
+			e.log().Info("signature verified", "image", repo.String(), "digest", digest.String(), "signature", layer.Digest.String())
+			return nil
+		}
+		problems = append(problems, fmt.Errorf("signature %s: %w", layer.Digest, err))
+	}
+	if len(problems) == 0 {
+		return fmt.Errorf("%s@%s is not signed: %w", repo, digest, ErrSignatureVerification)
+	}
+	return fmt.Errorf("no accepted signature for %s@%s: %w: %w", repo, digest, ErrSignatureVerification, errors.Join(problems...))
+}
+
+// verify checks one signature layer of a signature manifest
+func (p *CosignPolicy) verify(image v1.Image, layer v1.Descriptor, digest v1.Hash) error {
+	signature, err := base64.StdEncoding.DecodeString(layer.Annotations[cosignSignatureAnnotation])
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	blob, err := image.LayerByDigest(layer.Digest)
+	if err != nil {
+		return err
+	}
+	rc, err := blob.Compressed()
+	if err != nil {
+		return err
+	}
+	payload, err := io.ReadAll(io.LimitReader(rc, maxCosignPayload))
+	rc.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read payload: %w", err)
+	}
+
+	var signed cosignPayload
+	if err := json.Unmarshal(payload, &signed); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	if signed.Critical.Type != "cosign container image signature" {
+		return fmt.Errorf("unknown payload type %q", signed.Critical.Type)
+	}
+	if signed.Critical.Image.DockerManifestDigest != digest.String() {
+		return fmt.Errorf("payload signs %s", signed.Critical.Image.DockerManifestDigest)
+	}
+
+	for _, key := range p.PublicKeys {
+		if verifySignature(key, payload, signature) == nil {
+			return nil
+		}
+	}
+	certificate := layer.Annotations[cosignCertificateAnnotation]
+	if certificate == "" || len(p.Identities) == 0 {
+		return errors.New("not signed with a trusted key")
+	}
+	return p.verifyKeyless(payload, signature, layer.Annotations)
+}
+
+// verifyKeyless checks a signature made with a Fulcio certificate: the
+// certificate must chain to a trusted root at the time the transparency log
+// recorded the signature, and be issued to a trusted identity
+func (p *CosignPolicy) verifyKeyless(payload, signature []byte, annotations map[string]string) error {
+	if p.FulcioRoots == nil {
+		return errors.New("keyless signature, but no Fulcio root is trusted")
+	}
+	block, _ := pem.Decode([]byte(annotations[cosignCertificateAnnotation]))
+	if block == nil {
+		return errors.New("invalid certificate encoding")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("invalid certificate: %w", err)
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AppendCertsFromPEM([]byte(annotations[cosignChainAnnotation]))
+
+	signedAt, err := p.verifyBundle(annotations[cosignBundleAnnotation], cert, payload, signature)
+	if err != nil {
+		return err
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         p.FulcioRoots,
+		Intermediates: intermediates,
+		CurrentTime:   signedAt,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return fmt.Errorf("untrusted certificate: %w", err)
+	}
+	if err := verifySignature(cert.PublicKey, payload, signature); err != nil {
+		return err
+	}
+
+	subjects := cert.EmailAddresses
+	for _, uri := range cert.URIs {
+		subjects = append(subjects, uri.String())
+	}
+	issuer := certificateIssuer(cert)
+	for _, identity := range p.Identities {
+		if identity.Issuer != issuer {
+			continue
+		}
+		for _, subject := range subjects {
+			if subject == identity.Subject {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("certificate of %v issued by %q is not a trusted identity", subjects, issuer)
+}
+
+// verifyBundle checks the transparency log entry of a keyless signature and
+// returns when the log recorded it
+func (p *CosignPolicy) verifyBundle(annotation string, cert *x509.Certificate, payload, signature []byte) (time.Time, error) {
+	if annotation == "" {
+		return time.Time{}, errors.New("keyless signature has no transparency log entry")
+	}
+	var bundle rekorBundle
+	if err := json.Unmarshal([]byte(annotation), &bundle); err != nil {
+		return time.Time{}, fmt.Errorf("invalid transparency log entry: %w", err)
+	}
+	canonical, err := json.Marshal(bundle.Payload)
+	if err != nil {
+		return time.Time{}, err
+	}
+	trusted := false
+	for _, key := range p.RekorKeys {
+		if verifySignature(key, canonical, bundle.SignedEntryTimestamp) == nil {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return time.Time{}, errors.New("transparency log entry is not signed by a trusted log")
+	}
+
+	// The entry must record this very signature, payload and certificate
+	body, err := base64.StdEncoding.DecodeString(bundle.Payload.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid transparency log entry body: %w", err)
+	}
+	var entry struct {
+		Kind string `json:"kind"`
+		Spec struct {
+			Data struct {
+				Hash struct {
+					Algorithm string `json:"algorithm"`
+					Value     string `json:"value"`
+				} `json:"hash"`
+			} `json:"data"`
+			Signature struct {
+				Content   []byte `json:"content"`
+				PublicKey struct {
+					Content []byte `json:"content"`
+				} `json:"publicKey"`
+			} `json:"signature"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return time.Time{}, fmt.Errorf("invalid transparency log entry body: %w", err)
+	}
+	sum := sha256.Sum256(payload)
+	logged, _ := pem.Decode(entry.Spec.Signature.PublicKey.Content)
+	if entry.Kind != "hashedrekord" || entry.Spec.Data.Hash.Algorithm != "sha256" ||
+		entry.Spec.Data.Hash.Value != hex.EncodeToString(sum[:]) ||
+		!bytes.Equal(entry.Spec.Signature.Content, signature) ||
+		logged == nil || !bytes.Equal(logged.Bytes, cert.Raw) {
+		return time.Time{}, errors.New("transparency log entry does not match the signature")
+	}
+	return time.Unix(bundle.Payload.IntegratedTime, 0), nil
+}
+
+// certificateIssuer returns the OIDC issuer recorded in a Fulcio certificate
+func certificateIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(fulcioIssuerOID):
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err == nil {
+				return issuer
+			}
+		case ext.Id.Equal(fulcioLegacyIssuerOID):
+			return string(ext.Value)
+		}
+	}
+	return ""
+}
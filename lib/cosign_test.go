@@ -0,0 +1,241 @@
+package lib
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+)
+
+// newTestSigningKey generates an ECDSA P-256 key, the default of cosign
+func newTestSigningKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+	return key
+}
+
+// signTestPayload signs payload like cosign does
+func signTestPayload(t *testing.T, key *ecdsa.PrivateKey, payload []byte) []byte {
+	t.Helper()
+	sum := sha256.Sum256(payload)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	if err != nil {
+		t.Fatalf("Failed to sign test payload: %v", err)
+	}
+	return signature
+}
+
+// cosignTestPayload returns the simple signing payload of digest
+func cosignTestPayload(digest v1.Hash) []byte {
+	return []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"test/app"},"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"},"optional":null}`, digest))
+}
+
+// pushTestSignature stores one signature layer under the cosign tag of digest
+// in repo
+func pushTestSignature(t *testing.T, repo string, digest v1.Hash, payload []byte, annotations map[string]string) {
+	t.Helper()
+	layer := static.NewLayer(payload, "application/vnd.dev.cosign.simplesigning.v1+json")
+	image, err := mutate.Append(empty.Image, mutate.Addendum{Layer: layer, Annotations: annotations})
+	if err != nil {
+		t.Fatalf("Failed to build test signature: %v", err)
+	}
+	pushTestImage(t, repo+":"+digest.Algorithm+"-"+digest.Hex+".sig", image)
+}
+
+func TestCosignVerificationWithKey(t *testing.T) {
+	host := newTestRegistry(t)
+	key := newTestSigningKey(t)
+
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "file", content: "data"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	digest, _ := image.Digest()
+	pushTestImage(t, host+"/test/app:signed", image)
+	payload := cosignTestPayload(digest)
+	pushTestSignature(t, host+"/test/app", digest, payload, map[string]string{
+		cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(signTestPayload(t, key, payload)),
+	})
+
+	unsigned, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "file", content: "other"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	pushTestImage(t, host+"/test/app:unsigned", unsigned)
+
+	// A valid signature of another image, copied to the tag of this one
+	forged, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "file", content: "forged"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	forgedDigest, _ := forged.Digest()
+	pushTestImage(t, host+"/test/app:forged", forged)
+	pushTestSignature(t, host+"/test/app", forgedDigest, payload, map[string]string{
+		cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(signTestPayload(t, key, payload)),
+	})
+
+	// The public key is read as written by 'cosign generate-key-pair'
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal test key: %v", err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "cosign.pub")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatalf("Failed to write test key: %v", err)
+	}
+	keys, err := LoadPublicKeys(keyFile)
+	if err != nil {
+		t.Fatalf("Expected no error loading key, got %v", err)
+	}
+	exporter := NewImageExporter(WithCosignVerification(CosignPolicy{PublicKeys: keys}))
+
+	if _, err := exporter.GetImageConfig(host+"/test/app:signed", nil); err != nil {
+		t.Errorf("Expected signed image to verify, got %v", err)
+	}
+	for _, tag := range []string{"unsigned", "forged"} {
+		_, err := exporter.GetImageConfig(host+"/test/app:"+tag, nil)
+		if !errors.Is(err, ErrSignatureVerification) {
+			t.Errorf("Expected ErrSignatureVerification for %s image, got %v", tag, err)
+		}
+	}
+
+	other := NewImageExporter(WithCosignVerification(CosignPolicy{PublicKeys: []crypto.PublicKey{&newTestSigningKey(t).PublicKey}}))
+	if _, err := other.GetImageConfig(host+"/test/app:signed", nil); !errors.Is(err, ErrSignatureVerification) {
+		t.Errorf("Expected ErrSignatureVerification with another key, got %v", err)
+	}
+	if _, err := exporter.GetImageConfig(OCILayoutPrefix+t.TempDir(), nil); !errors.Is(err, ErrSignatureVerification) {
+		t.Errorf("Expected local images to be refused, got %v", err)
+	}
+}
+
+func TestCosignVerificationKeyless(t *testing.T) {
+	host := newTestRegistry(t)
+	now := time.Now()
+
+	// A Fulcio-like root and a short-lived leaf certificate that has already
+	// expired, but was valid when the transparency log recorded the signature
+	caKey := newTestSigningKey(t)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test fulcio"},
+		NotBefore:             now.Add(-24 * time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create test CA: %v", err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	issuer, _ := asn1.Marshal("https://issuer.example.com")
+	leafKey := newTestSigningKey(t)
+	leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       now.Add(-time.Hour),
+		NotAfter:        now.Add(-50 * time.Minute),
+		EmailAddresses:  []string{"release@example.com"},
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		ExtraExtensions: []pkix.Extension{{Id: fulcioIssuerOID, Value: issuer}},
+	}, ca, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create test certificate: %v", err)
+	}
+	leafPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})
+
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "file", content: "data"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	digest, _ := image.Digest()
+	pushTestImage(t, host+"/test/app:v1", image)
+	payload := cosignTestPayload(digest)
+	signature := signTestPayload(t, leafKey, payload)
+
+	// The transparency log entry of the signature, signed by the log
+	sum := sha256.Sum256(payload)
+	body, _ := json.Marshal(map[string]any{
+		"apiVersion": "0.0.1",
+		"kind":       "hashedrekord",
+		"spec": map[string]any{
+			"data":      map[string]any{"hash": map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(sum[:])}},
+			"signature": map[string]any{"content": signature, "publicKey": map[string]any{"content": leafPEM}},
+		},
+	})
+	rekorKey := newTestSigningKey(t)
+	entry := rekorEntry{
+		Body:           base64.StdEncoding.EncodeToString(body),
+		IntegratedTime: now.Add(-55 * time.Minute).Unix(),
+		LogID:          "c0d23d6ad406973f9559f3ba2d1ca01f84147d8ffc5b8445c224f98b9591801d",
+		LogIndex:       42,
+	}
+	canonical, _ := json.Marshal(entry)
+	bundle, _ := json.Marshal(rekorBundle{SignedEntryTimestamp: signTestPayload(t, rekorKey, canonical), Payload: entry})
+
+	// Signatures are read from the signature repository
+	pushTestSignature(t, host+"/test/signatures", digest, payload, map[string]string{
+		cosignSignatureAnnotation:   base64.StdEncoding.EncodeToString(signature),
+		cosignCertificateAnnotation: string(leafPEM),
+		cosignChainAnnotation:       string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})),
+		cosignBundleAnnotation:      string(bundle),
+	})
+
+	policy := CosignPolicy{
+		Identities:  []CosignIdentity{{Subject: "release@example.com", Issuer: "https://issuer.example.com"}},
+		FulcioRoots: roots,
+		RekorKeys:   []crypto.PublicKey{&rekorKey.PublicKey},
+		Repository:  host + "/test/signatures",
+	}
+	if _, err := NewImageExporter(WithCosignVerification(policy)).GetImageConfig(host+"/test/app:v1", nil); err != nil {
+		t.Fatalf("Expected keyless signature to verify, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(p *CosignPolicy)
+	}{
+		{"other identity", func(p *CosignPolicy) { p.Identities[0].Subject = "attacker@example.com" }},
+		{"other issuer", func(p *CosignPolicy) { p.Identities[0].Issuer = "https://accounts.example.com" }},
+		{"untrusted root", func(p *CosignPolicy) { p.FulcioRoots = x509.NewCertPool() }},
+		{"untrusted log", func(p *CosignPolicy) { p.RekorKeys = []crypto.PublicKey{&newTestSigningKey(t).PublicKey} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modified := policy
+			modified.Identities = append([]CosignIdentity(nil), policy.Identities...)
+			tt.modify(&modified)
+			_, err := NewImageExporter(WithCosignVerification(modified)).GetImageConfig(host+"/test/app:v1", nil)
+			if !errors.Is(err, ErrSignatureVerification) {
+				t.Errorf("Expected ErrSignatureVerification, got %v", err)
+			}
+		})
+	}
+}
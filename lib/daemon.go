@@ -0,0 +1,179 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// ImageSource selects where image references without a prefix are read from
+type ImageSource string
+
+const (
+	// ImageSourceRegistry fetches images from their registry (default)
+	ImageSourceRegistry ImageSource = ""
+
+	// ImageSourceDaemon reads every image from the local Docker Engine, as if
+	// each reference had the DockerDaemonPrefix
+	ImageSourceDaemon ImageSource = "daemon"
+
+	// ImageSourceAuto fetches images from their registry and falls back to the
+	// local Docker Engine when that fails, e.g. for images that were built
+	// locally and never pushed
+	ImageSourceAuto ImageSource = "auto"
+)
+
+// ParseImageSource converts a source name ("registry", "daemon", "auto") into an ImageSource
+func ParseImageSource(source string) (ImageSource, error) {
+	switch source {
+	case "", "registry":
+		return ImageSourceRegistry, nil
+	case "daemon", "docker":
+		return ImageSourceDaemon, nil
+	case "auto":
+		return ImageSourceAuto, nil
+	default:
+		return "", fmt.Errorf("unknown image source %q", source)
+	}
+}
+
+// WithImageSource selects where image references without a prefix are read from.
+func WithImageSource(source ImageSource) ExporterOption {
+	return func(e *imageExporter) {
+		e.source = source
+	}
+}
+
+// DockerDaemonPrefix marks an image reference as an image of the local Docker
+// Engine, e.g. docker-daemon:app:dev. The image is read through the Engine API
+// ('docker save') wherever an image configuration or filesystem is read.
+const DockerDaemonPrefix = "docker-daemon:"
+
+// EnvDockerHost names the Docker Engine endpoint, as for the docker CLI
+const EnvDockerHost = "DOCKER_HOST"
+
+// defaultDockerHost is where the Docker Engine listens by default
+const defaultDockerHost = "unix:///var/run/docker.sock"
+
+// WithDockerHost sets the Docker Engine endpoint for docker-daemon: references
+// and ImageSourceDaemon: unix:///path/to/docker.sock or tcp://host:port (without
+// TLS). The default is DOCKER_HOST, or unix:///var/run/docker.sock.
+func WithDockerHost(host string) ExporterOption {
+	return func(e *imageExporter) {
+		e.dockerHost = host
+	}
+}
+
+// dockerDaemon is a minimal client of the Docker Engine API
+type dockerDaemon struct {
+	host   string
+	base   string
+	client *http.Client
+}
+
+// dockerDaemon returns a client of the configured Docker Engine
+func (e *imageExporter) dockerDaemon() (*dockerDaemon, error) {
+	host := e.dockerHost
+	if host == "" {
+		host = os.Getenv(EnvDockerHost)
+	}
+	if host == "" {
+		host = defaultDockerHost
+	}
+	endpoint, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host %q: %w", host, err)
+	}
+
+	daemon := &dockerDaemon{host: host}
+	switch endpoint.Scheme {
+	case "unix":
+		socket := endpoint.Path
+		daemon.base = "http://docker"
+		daemon.client = &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}}
+	case "tcp", "http":
+		daemon.base = "http://" + endpoint.Host
+		daemon.client = &http.Client{}
+	default:
+		return nil, fmt.Errorf("unsupported docker host %q: use unix:// or tcp://", host)
+	}
+	return daemon, nil
+}
+
+// save streams the 'docker save' archive of imageName into a temporary file.
+// The file is unlinked right away, so its space is freed once the returned
+// handle is closed or collected; on Windows it stays in the temp directory.
+func (d *dockerDaemon) save(imageName string) (*os.File, error) {
+	resp, err := d.client.Get(d.base + "/images/" + imageName + "/get")
+	if err != nil {
+		return nil, fmt.Errorf("docker daemon not reachable at %s: %w", d.host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var apiError struct {
+			Message string `json:"message"`
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if json.Unmarshal(body, &apiError) != nil || apiError.Message == "" {
+			apiError.Message = strings.TrimSpace(string(body))
+		}
+		return nil, fmt.Errorf("docker daemon: %s (%s)", apiError.Message, resp.Status)
+	}
+
+	file, err := os.CreateTemp("", "imgex-daemon-*.tar")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	// The open file keeps its data; elsewhere the file stays behind in the temp directory
+	os.Remove(file.Name())
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read image from docker daemon: %w", err)
+	}
+	return file, nil
+}
+
+// daemonImage reads the image imageName from the Docker Engine. The daemon
+// stores one platform per image, so a platform only checks that it matches.
+func (e *imageExporter) daemonImage(imageName string, platform *v1.Platform) (v1.Image, error) {
+	daemon, err := e.dockerDaemon()
+	if err != nil {
+		return nil, err
+	}
+	file, err := daemon.save(imageName)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	opener := func() (io.ReadCloser, error) {
+		return io.NopCloser(io.NewSectionReader(file, 0, info.Size())), nil
+	}
+	image, err := tarball.Image(opener, nil)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read the image saved by the docker daemon: %w", err)
+	}
+	if err := checkImagePlatform(image, platform); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return image, nil
+}
@@ -0,0 +1,86 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// newTestDaemon serves 'docker save' of one local image, app:dev, on a unix socket
+func newTestDaemon(t *testing.T) string {
+	t.Helper()
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "etc/motd", content: "built locally"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	tag, _ := name.NewTag("app:dev")
+	var saved bytes.Buffer
+	if err := tarball.Write(tag, image, &saved); err != nil {
+		t.Fatalf("Failed to save test image: %v", err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on %s: %v", socket, err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/images/app:dev/get" {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"message":"reference does not exist"}`)
+			return
+		}
+		w.Write(saved.Bytes())
+	}))
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	return "unix://" + socket
+}
+
+func TestDockerDaemon(t *testing.T) {
+	host := newTestDaemon(t)
+
+	for _, exporter := range []ImageExporter{
+		NewImageExporter(WithDockerHost(host)),
+		NewImageExporter(WithDockerHost(host), WithImageSource(ImageSourceDaemon)),
+		NewImageExporter(WithDockerHost(host), WithImageSource(ImageSourceAuto), WithOffline()),
+	} {
+		imageRef := "app:dev"
+		if exporter.(*imageExporter).source == ImageSourceRegistry {
+			imageRef = DockerDaemonPrefix + imageRef
+		}
+		var out bytes.Buffer
+		if err := exporter.ExportImageFilesystemToWriter(imageRef, &out, nil); err != nil {
+			t.Fatalf("Expected no error for %s, got %v", imageRef, err)
+		}
+		if files := readTestTar(t, out.Bytes()); files["etc/motd"] != "built locally" {
+			t.Errorf("Expected the local image, got %v", files)
+		}
+	}
+
+	// Both failures are reported when the fallback fails too
+	exporter := NewImageExporter(WithDockerHost(host), WithImageSource(ImageSourceAuto), WithOffline())
+	_, err := exporter.GetImageConfig("app:missing", nil)
+	if !errors.Is(err, ErrOffline) || !strings.Contains(err.Error(), "reference does not exist") {
+		t.Errorf("Expected the registry and daemon errors, got %v", err)
+	}
+	unreachable := NewImageExporter(WithDockerHost("unix://" + filepath.Join(t.TempDir(), "none.sock")))
+	if _, err := unreachable.GetImageConfig(DockerDaemonPrefix+"app:dev", nil); err == nil || !strings.Contains(err.Error(), "not reachable") {
+		t.Errorf("Expected an unreachable daemon error, got %v", err)
+	}
+	if _, err := ParseImageSource("podman"); err == nil {
+		t.Error("Expected an error for an unknown source")
+	}
+}
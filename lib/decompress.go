@@ -0,0 +1,188 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+// LayerHandler turns the raw blob of a layer into an uncompressed tar stream.
+// It receives the compressed blob exactly as stored in the registry, along with
+// the layer descriptor from the manifest (media type, digest, annotations), and
+// must return a reader over the layer tar. Closing the returned reader must also
+// release the blob reader.
+type LayerHandler func(blob io.ReadCloser, desc v1.Descriptor) (io.ReadCloser, error)
+
+var (
+	layerHandlers     = make(map[string]LayerHandler)
+	layerHandlersLock sync.RWMutex
+)
+
+// RegisterLayerHandler registers a handler for layers with the given media type.
+//
+// Registered handlers take precedence over the built-in gzip and zstd support,
+// which lets embedders add custom compression or encryption schemes (for example
+// encrypted OCI layers) without changing the flatten pipeline. Registering a nil
+// handler removes any handler for the media type. It is safe to call concurrently
+// with exports, and is typically done from an init function.
+//
+// Example:
+//
+//	lib.RegisterLayerHandler("application/vnd.example.layer.v1.tar+xz", func(blob io.ReadCloser, desc v1.Descriptor) (io.ReadCloser, error) {
+//	    return newXZReadCloser(blob)
+//	})
+func RegisterLayerHandler(mediaType string, handler LayerHandler) {
+	layerHandlersLock.Lock()
+	defer layerHandlersLock.Unlock()
+	if handler == nil {
+		delete(layerHandlers, mediaType)
+		return
+	}
+	layerHandlers[mediaType] = handler
+}
+
+// lookupLayerHandler returns the handler registered for a media type, if any
+func lookupLayerHandler(mediaType string) (LayerHandler, bool) {
+	layerHandlersLock.RLock()
+	defer layerHandlersLock.RUnlock()
+	handler, ok := layerHandlers[mediaType]
+	return handler, ok
+}
+
+// openLayer returns the uncompressed tar stream of a layer. Encrypted layers are
+// decrypted with the exporter's keys, layers with a registered LayerHandler are
+// routed through it, and everything else uses the built-in decompression.
+// With a blob cache, the compressed blob is read through the cache first.
+// When onRead is non-nil it receives the number of compressed bytes of each read.
+func (e *imageExporter) openLayer(layer v1.Layer, onRead func(n int64)) (io.ReadCloser, error) {
+	desc, err := partial.Descriptor(layer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get layer descriptor: %w", err)
+	}
+	mediaType := string(desc.MediaType)
+	if isForeignLayer(layer) {
+		layer = e.foreignLayer(layer, *desc)
+	}
+
+	handler, ok := lookupLayerHandler(mediaType)
+	if !ok && isEncryptedMediaType(mediaType) {
+		handler, ok = e.decryptLayer, true
+	}
+	if !ok && e.cache == nil && onRead == nil {
+		return layer.Uncompressed()
+	}
+
+	blob, err := e.layerBlob(layer)
+	if err != nil {
+		return nil, err
+	}
+	if onRead != nil {
+		blob = &progressReader{ReadCloser: blob, onRead: onRead}
+	}
+	if !ok {
+		// Cached blobs are still compressed
+		return decompressStream(blob)
+	}
+	reader, err := handler(blob, *desc)
+	if err != nil {
+		blob.Close()
+		return nil, fmt.Errorf("layer handler for %s failed: %w", mediaType, err)
+	}
+	return reader, nil
+}
+
+// decompressStream detects gzip or zstd compression from the stream header and
+// returns a reader over the decompressed data. Uncompressed streams are returned as-is.
+func decompressStream(r io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read layer header: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		return &readCloser{Reader: gz, close: func() error {
+			gz.Close()
+			return r.Close()
+		}}, nil
+	case isZstdHeader(header):
+		zr, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open zstd stream: %w", err)
+		}
+		return &readCloser{Reader: zr, close: func() error {
+			zr.Close()
+			return r.Close()
+		}}, nil
+	default:
+		return &readCloser{Reader: buffered, close: r.Close}, nil
+	}
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// isZstdHeader reports whether a stream starts with a zstd frame, or with a
+// skippable frame (magic 0x184D2A50 to 0x184D2A5F) like the metadata some
+// zstd:chunked writers put first
+func isZstdHeader(header []byte) bool {
+	if bytes.HasPrefix(header, zstdMagic) {
+		return true
+	}
+	return len(header) >= 4 && header[0]&0xf0 == 0x50 && bytes.Equal(header[1:4], []byte{0x2a, 0x4d, 0x18})
+}
+
+// readCloser pairs a reader with a custom close function
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+// Close implements io.Closer
+func (r *readCloser) Close() error {
+	return r.close()
+}
+
+// isForeignLayer reports whether a layer is marked non-distributable
+func isForeignLayer(layer v1.Layer) bool {
+	mediaType, err := layer.MediaType()
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case types.DockerForeignLayer, types.OCIRestrictedLayer, types.OCIUncompressedRestrictedLayer:
+		return true
+	default:
+		return false
+	}
+}
+
+// progressReader reports the size of every read from a stream
+type progressReader struct {
+	io.ReadCloser
+	onRead func(n int64)
+}
+
+// Read implements io.Reader
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.onRead(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// xorReader obscures a stream by XORing every byte with a fixed key
+type xorReader struct {
+	io.ReadCloser
+	key byte
+}
+
+func (x *xorReader) Read(p []byte) (int, error) {
+	n, err := x.ReadCloser.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] ^= x.key
+	}
+	return n, err
+}
+
+func TestRegisterLayerHandler(t *testing.T) {
+	const mediaType = "application/vnd.imgex.test.layer.v1.tar+xor"
+	const key = 0x5a
+
+	RegisterLayerHandler(mediaType, func(blob io.ReadCloser, desc v1.Descriptor) (io.ReadCloser, error) {
+		return &xorReader{ReadCloser: blob, key: key}, nil
+	})
+	defer RegisterLayerHandler(mediaType, nil)
+
+	// Build a plain tar layer and obscure it with the custom scheme
+	plain := newTestLayer(t, testEntry{name: "secret.txt", content: "decoded"})
+	reader, err := plain.Uncompressed()
+	if err != nil {
+		t.Fatalf("Failed to read test layer: %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Fatalf("Failed to read test layer: %v", err)
+	}
+	for i := range data {
+		data[i] ^= key
+	}
+
+	image, err := mutate.AppendLayers(empty.Image, static.NewLayer(data, types.MediaType(mediaType)))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+
+	host := newTestRegistry(t)
+	imageRef := host + "/test/custom-layer:latest"
+	pushTestImage(t, imageRef, image)
+
+	var buf bytes.Buffer
+	exporter := NewImageExporter()
+	if err := exporter.ExportImageFilesystemToWriter(imageRef, &buf, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	files := readTestTar(t, buf.Bytes())
+	if files["secret.txt"] != "decoded" {
+		t.Errorf("Expected secret.txt to be decoded, got %q", files["secret.txt"])
+	}
+}
@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// DeleteOptions controls Delete
+type DeleteOptions struct {
+	// AllowTag permits tag references, which delete only the tag on registries
+	// that support tag deletion. Without it, only digest references are accepted
+	// so that a moving tag never deletes a manifest it did not point at when reviewed.
+	AllowTag bool
+
+	// DryRun resolves the reference without deleting anything
+	DryRun bool
+}
+
+// Delete removes a manifest or tag from a registry using the registry delete API.
+//
+// A digest reference (repo@sha256:...) deletes the manifest, and with it every
+// tag pointing at it. The manifest is resolved first, so a missing manifest is
+// reported as an error in dry runs too.
+//
+// Parameters:
+//   - imageRef: Image reference, by digest unless opts.AllowTag is set
+//   - auth: Optional authentication configuration for private registries
+//   - opts: Optional settings for tag references and dry runs
+//
+// Returns:
+//   - string: Digest of the deleted (or, in a dry run, resolved) manifest
+//   - error: Any error encountered, including registries that disallow deletion
+//
+// Example:
+//
+//	digest, err := exporter.Delete("registry.com/org/image@sha256:...", nil, nil)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+func (e *imageExporter) Delete(imageRef string, auth *AuthConfig, opts *DeleteOptions) (string, error) {
+	if opts == nil {
+		opts = &DeleteOptions{}
+	}
+
+	ref, err := e.parseReference(imageRef)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse image reference %s: %w", imageRef, err)
+	}
+	if _, isTag := ref.(name.Tag); isTag && !opts.AllowTag {
+		return "", fmt.Errorf("refusing to delete %s by tag; use a digest reference or allow tag deletion", imageRef)
+	}
+
+	// A digest reference names the manifest already, so resolving it is only
+	// needed to confirm it exists in a dry run
+	digest, isDigest := ref.(name.Digest)
+	resolved := digest.DigestStr()
+	if !isDigest || opts.DryRun {
+		desc, err := remote.Head(ref, e.remoteOptions(auth)...)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve %s: %w", imageRef, err)
+		}
+		resolved = desc.Digest.String()
+	}
+	if opts.DryRun {
+		return resolved, nil
+	}
+
+	if err := remote.Delete(ref, e.remoteOptions(auth)...); err != nil {
+		return "", fmt.Errorf("failed to delete %s: %w", imageRef, err)
+	}
+	return resolved, nil
+}
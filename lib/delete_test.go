@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestDelete(t *testing.T) {
+	host := newTestRegistry(t)
+	repo := host + "/team/app"
+	image := newDatedTestImage(t, "delete", 1)
+	pushTestImage(t, repo+":v1", image)
+	pushTestImage(t, repo+":pr-1", newDatedTestImage(t, "pr", 2))
+	digest := testDigest(t, image)
+	exporter := NewImageExporter()
+
+	// Tags are refused unless allowed
+	if _, err := exporter.Delete(repo+":pr-1", nil, nil); err == nil || !strings.Contains(err.Error(), "by tag") {
+		t.Errorf("Expected tag reference to be refused, got %v", err)
+	}
+
+	// A dry run resolves without deleting
+	got, err := exporter.Delete(repo+"@"+digest, nil, &DeleteOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got != digest {
+		t.Errorf("Expected %s, got %s", digest, got)
+	}
+	assertManifestExists(t, repo+"@"+digest, true)
+
+	if _, err := exporter.Delete(repo+"@"+digest, nil, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	assertManifestExists(t, repo+"@"+digest, false)
+
+	if _, err := exporter.Delete(repo+":pr-1", nil, &DeleteOptions{AllowTag: true}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	assertManifestExists(t, repo+":pr-1", false)
+
+	// Missing manifests are reported before anything is attempted
+	if _, err := exporter.Delete(repo+"@"+digest, nil, &DeleteOptions{DryRun: true}); err == nil {
+		t.Error("Expected an error for a deleted manifest")
+	}
+}
+
+// assertManifestExists checks whether a reference still resolves
+func assertManifestExists(t *testing.T, imageRef string, expected bool) {
+	t.Helper()
+
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		t.Fatalf("Failed to parse reference: %v", err)
+	}
+	_, err = remote.Head(ref)
+	if exists := err == nil; exists != expected {
+		t.Errorf("Expected %s to exist: %v, got error %v", imageRef, expected, err)
+	}
+}
@@ -0,0 +1,245 @@
+package lib
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+)
+
+// defaultDiskUsageTop is the number of directories and files DiskUsage lists
+// by default
+const defaultDiskUsageTop = 10
+
+// DiskUsageOptions configures DiskUsage
+type DiskUsageOptions struct {
+	// Platform selects the image of a multi-platform index, e.g. "linux/arm64";
+	// empty for the default platform
+	Platform string
+
+	// Top is the number of largest directories and files to list; 0 for 10
+	Top int
+}
+
+// DiskUsageReport breaks down the size of an image by layer and by path. It is
+// returned by the DiskUsage method.
+type DiskUsageReport struct {
+	// SchemaVersion is the version of this JSON document (see SchemaVersion)
+	SchemaVersion int `json:"schema_version"`
+
+	// Reference is the image reference that was measured
+	Reference string `json:"reference"`
+
+	// Digest is the manifest digest of the image
+	Digest string `json:"digest"`
+
+	// CompressedSize is the total size of the layer blobs, as pulled
+	CompressedSize int64 `json:"compressed_size"`
+
+	// UncompressedSize is the total size of the uncompressed layer tars
+	UncompressedSize int64 `json:"uncompressed_size"`
+
+	// FilesystemSize is the total size of the regular files of the flattened
+	// filesystem
+	FilesystemSize int64 `json:"filesystem_size"`
+
+	// Layers lists the layers in order
+	Layers []LayerUsage `json:"layers"`
+
+	// Directories lists the largest directories of the flattened filesystem,
+	// counting every file below them, largest first
+	Directories []PathUsage `json:"directories"`
+
+	// Files lists the largest files of the flattened filesystem, largest first
+	Files []PathUsage `json:"files"`
+}
+
+// LayerUsage is the size of one layer of a DiskUsageReport
+type LayerUsage struct {
+	// Index is the zero-based index of the layer in the image
+	Index int `json:"index"`
+
+	// Digest is the digest of the layer
+	Digest string `json:"digest"`
+
+	// Size is the size of the compressed layer blob
+	Size int64 `json:"size"`
+
+	// UncompressedSize is the size of the uncompressed layer tar; 0 for
+	// skipped foreign layers
+	UncompressedSize int64 `json:"uncompressed_size"`
+
+	// Entries is the number of files, directories and links the layer holds,
+	// whiteouts excluded
+	Entries int `json:"entries"`
+
+	// WastedSize is the size of the regular files of the layer that a later
+	// layer replaced or deleted: they are pulled but not in the filesystem
+	WastedSize int64 `json:"wasted_size"`
+
+	// CreatedBy is the history line of the layer, e.g. the Dockerfile
+	// instruction that produced it
+	CreatedBy string `json:"created_by,omitempty"`
+}
+
+// PathUsage is the size of a directory or file of a DiskUsageReport
+type PathUsage struct {
+	// Path is the absolute path in the image
+	Path string `json:"path"`
+
+	// Size is the size of the file, or of all regular files below the directory
+	Size int64 `json:"size"`
+
+	// Files is the number of regular files below a directory; 0 for files
+	Files int `json:"files,omitempty"`
+}
+
+// DiskUsage measures where the size of an image comes from: the compressed and
+// uncompressed size of each layer, how much of each layer is replaced or
+// deleted by later ones, and the largest directories and files of the
+// flattened filesystem. File contents are counted but not kept.
+//
+// Parameters:
+//   - imageRef: Image reference (e.g., "node:20")
+//   - auth: Optional authentication configuration for private registries
+//   - opts: Optional platform and number of largest paths
+//
+// Returns:
+//   - *DiskUsageReport: The breakdown of the image size
+//   - error: Any error encountered during the operation
+//
+// Example:
+//
+//	report, err := exporter.DiskUsage("node:20", nil, &lib.DiskUsageOptions{Top: 5})
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	for _, dir := range report.Directories {
+//	    fmt.Println(dir.Size, dir.Path)
+//	}
+func (e *imageExporter) DiskUsage(imageRef string, auth *AuthConfig, opts *DiskUsageOptions) (*DiskUsageReport, error) {
+	if opts == nil {
+		opts = &DiskUsageOptions{}
+	}
+	top := opts.Top
+	if top <= 0 {
+		top = defaultDiskUsageTop
+	}
+
+	image, err := e.openPlatformImage(imageRef, auth, opts.Platform)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image digest: %w", err)
+	}
+	history, err := layerHistory(image)
+	if err != nil {
+		return nil, err
+	}
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image layers: %w", err)
+	}
+
+	report := &DiskUsageReport{
+		SchemaVersion: SchemaVersion,
+		Reference:     imageRef,
+		Digest:        digest.String(),
+		Layers:        make([]LayerUsage, len(layers)),
+	}
+	filesystem := make(map[string]*fileEntry)
+	paths := newPathTrie()
+	windows := newWindowsDetector(image)
+
+	// Remember the layer of each entry to tell which of its bytes survive
+	origin := make(map[*fileEntry]int)
+	written := make([]int64, len(layers))
+	for i, layer := range layers {
+		usage := &report.Layers[i]
+		usage.Index, usage.Digest, usage.Size, usage.CreatedBy = i, history[i].Digest, history[i].Size, history[i].CreatedBy
+		report.CompressedSize += usage.Size
+		if e.foreignLayers == ForeignLayersSkip && isForeignLayer(layer) {
+			continue
+		}
+
+		reader, err := e.openLayer(layer, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get layer %d content: %w", i, err)
+		}
+		counted := &progressReader{ReadCloser: reader, onRead: func(n int64) { usage.UncompressedSize += n }}
+		err = e.applyLayer(filesystem, paths, counted, i, windows, &ExportOptions{}, nil, func(header *tar.Header, _ io.Reader) (*fileEntry, error) {
+			entry := &fileEntry{header: header}
+			origin[entry] = i
+			usage.Entries++
+			if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA {
+				written[i] += header.Size
+			}
+			return entry, nil
+		})
+		if err == nil {
+			// Count the end-of-archive padding too
+			_, err = io.Copy(io.Discard, counted)
+		}
+		closeErr := reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		if closeErr != nil {
+			return nil, fmt.Errorf("failed to read layer %d: %w", i, closeErr)
+		}
+		report.UncompressedSize += usage.UncompressedSize
+	}
+
+	surviving := make([]int64, len(layers))
+	directories := make(map[string]*PathUsage)
+	for p, entry := range filesystem {
+		if entry.header.Typeflag != tar.TypeReg && entry.header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		size := entry.header.Size
+		surviving[origin[entry]] += size
+		report.FilesystemSize += size
+		report.Files = append(report.Files, PathUsage{Path: "/" + p, Size: size})
+		for dir := path.Dir(p); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			usage, ok := directories[dir]
+			if !ok {
+				usage = &PathUsage{Path: "/" + dir}
+				directories[dir] = usage
+			}
+			usage.Size += size
+			usage.Files++
+		}
+	}
+	for i := range report.Layers {
+		report.Layers[i].WastedSize = written[i] - surviving[i]
+	}
+	for _, usage := range directories {
+		report.Directories = append(report.Directories, *usage)
+	}
+	report.Directories = largestPaths(report.Directories, top)
+	report.Files = largestPaths(report.Files, top)
+
+	e.log().Debug("disk usage measured", "image", imageRef, "layers", len(layers), "filesystem_size", report.FilesystemSize)
+	return report, nil
+}
+
+// largestPaths sorts paths by decreasing size, then by path, and keeps the
+// first n
+func largestPaths(paths []PathUsage, n int) []PathUsage {
+	sort.Slice(paths, func(i, j int) bool {
+		if paths[i].Size != paths[j].Size {
+			return paths[i].Size > paths[j].Size
+		}
+		return paths[i].Path < paths[j].Path
+	})
+	if len(paths) > n {
+		paths = paths[:n]
+	}
+	if paths == nil {
+		paths = []PathUsage{}
+	}
+	return paths
+}
@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"archive/tar"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestDiskUsage(t *testing.T) {
+	host := newTestRegistry(t)
+	imageRef := host + "/test/app:v1"
+
+	base := newTestLayer(t,
+		testEntry{name: "usr/", typeflag: tar.TypeDir, mode: 0755},
+		testEntry{name: "usr/lib/libbig.so", content: strings.Repeat("x", 3000)},
+		testEntry{name: "usr/lib/libsmall.so", content: strings.Repeat("x", 100)},
+		testEntry{name: "usr/bin/tool", content: strings.Repeat("x", 500)},
+		testEntry{name: "tmp/cache.tar", content: strings.Repeat("x", 2000)},
+	)
+	cleanup := newTestLayer(t,
+		testEntry{name: "tmp/.wh.cache.tar"},
+		testEntry{name: "usr/bin/tool", content: strings.Repeat("x", 200)},
+	)
+	image, err := mutate.AppendLayers(empty.Image, base, cleanup)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	pushTestImage(t, imageRef, image)
+
+	report, err := NewImageExporter().DiskUsage(imageRef, nil, &DiskUsageOptions{Top: 2})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(report.Layers) != 2 {
+		t.Fatalf("Expected 2 layers, got %+v", report.Layers)
+	}
+	for i, layer := range []v1.Layer{base, cleanup} {
+		size, _ := layer.Size()
+		if report.Layers[i].Size != size {
+			t.Errorf("Expected layer %d size %d, got %d", i, size, report.Layers[i].Size)
+		}
+		if report.Layers[i].UncompressedSize%512 != 0 || report.Layers[i].UncompressedSize == 0 {
+			t.Errorf("Expected layer %d uncompressed size to be whole tar blocks, got %d", i, report.Layers[i].UncompressedSize)
+		}
+	}
+	if report.Layers[0].Entries != 5 || report.Layers[1].Entries != 1 {
+		t.Errorf("Expected 5 and 1 entries, got %d and %d", report.Layers[0].Entries, report.Layers[1].Entries)
+	}
+	if report.Layers[0].WastedSize != 2500 || report.Layers[1].WastedSize != 0 {
+		t.Errorf("Expected 2500 and 0 wasted bytes, got %d and %d", report.Layers[0].WastedSize, report.Layers[1].WastedSize)
+	}
+	if report.FilesystemSize != 3300 {
+		t.Errorf("Expected filesystem size 3300, got %d", report.FilesystemSize)
+	}
+
+	wantDirectories := []PathUsage{{Path: "/usr", Size: 3300, Files: 3}, {Path: "/usr/lib", Size: 3100, Files: 2}}
+	if !reflect.DeepEqual(report.Directories, wantDirectories) {
+		t.Errorf("Expected directories %+v, got %+v", wantDirectories, report.Directories)
+	}
+	wantFiles := []PathUsage{{Path: "/usr/lib/libbig.so", Size: 3000}, {Path: "/usr/bin/tool", Size: 200}}
+	if !reflect.DeepEqual(report.Files, wantFiles) {
+		t.Errorf("Expected files %+v, got %+v", wantFiles, report.Files)
+	}
+}
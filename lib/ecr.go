@@ -0,0 +1,223 @@
+//go:build !nocloud
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+// ecrTokenRefreshWindow renews cached tokens this long before they expire
+const ecrTokenRefreshWindow = 5 * time.Minute
+
+// ecrKeychain obtains registry tokens from the ECR GetAuthorizationToken API
+// using credentials from the default AWS credential chain of the AWS SDK
+// (environment, shared config and credentials profiles including role
+// assumption, SSO and credential_process, web identity, ECS and EC2 roles).
+// Registries that are not ECR resolve anonymously so the keychain can be
+// combined with others.
+type ecrKeychain struct {
+	transport http.RoundTripper
+
+	// endpoint overrides the ECR API URL, for tests
+	endpoint string
+
+	// credentials overrides the AWS credential chain, for tests
+	credentials aws.CredentialsProvider
+
+	mu     sync.Mutex
+	tokens map[string]ecrToken
+}
+
+// ecrToken is a cached registry login
+type ecrToken struct {
+	username  string
+	password  string
+	expiresAt time.Time
+}
+
+// Resolve implements authn.Keychain
+func (k *ecrKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	registry := target.RegistryStr()
+	match := ecrRegistryPattern.FindStringSubmatch(registry)
+	if match == nil {
+		return authn.Anonymous, nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	token, ok := k.tokens[registry]
+	if !ok || time.Until(token.expiresAt) < ecrTokenRefreshWindow {
+		var err error
+		token, err = k.fetchToken(match[1], match[3], match[2] != "", match[4] != "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ECR token for %s: %w", registry, err)
+		}
+		if k.tokens == nil {
+			k.tokens = make(map[string]ecrToken)
+		}
+		k.tokens[registry] = token
+	}
+
+	return authn.FromConfig(authn.AuthConfig{
+		Username: token.username,
+		Password: token.password,
+	}), nil
+}
+
+// fetchToken calls GetAuthorizationToken for one registry account
+func (k *ecrKeychain) fetchToken(account, region string, fips, china bool) (ecrToken, error) {
+	ctx := context.Background()
+	client := &http.Client{Transport: awsTransport{k.transport}, Timeout: 30 * time.Second}
+	provider := k.credentials
+	if provider == nil {
+		// AWS_CA_BUNDLE and ca_bundle are not supported: the SDK only applies
+		// them to a transport it builds, and AWS calls use the exporter's
+		cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region), config.WithHTTPClient(client))
+		if err != nil {
+			return ecrToken{}, fmt.Errorf("failed to load AWS configuration: %w", err)
+		}
+		provider = cfg.Credentials
+	}
+	creds, err := provider.Retrieve(ctx)
+	if err != nil {
+		return ecrToken{}, fmt.Errorf("failed to get AWS credentials: %w", err)
+	}
+
+	endpoint := k.endpoint
+	if endpoint == "" {
+		service := "api.ecr"
+		if fips {
+			service = "ecr-fips"
+		}
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", service, region)
+		if china {
+			endpoint += ".cn"
+		}
+	}
+
+	body, err := json.Marshal(map[string][]string{"registryIds": {account}})
+	if err != nil {
+		return ecrToken{}, err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return ecrToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken")
+	bodyHash := sha256.Sum256(body)
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(bodyHash[:]), "ecr", region, time.Now()); err != nil {
+		return ecrToken{}, fmt.Errorf("failed to sign GetAuthorizationToken request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return ecrToken{}, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ecrToken{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ecrToken{}, fmt.Errorf("GetAuthorizationToken returned %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	var result struct {
+		AuthorizationData []struct {
+			AuthorizationToken string  `json:"authorizationToken"`
+			ExpiresAt          float64 `json:"expiresAt"`
+		} `json:"authorizationData"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return ecrToken{}, fmt.Errorf("failed to parse GetAuthorizationToken response: %w", err)
+	}
+	if len(result.AuthorizationData) == 0 {
+		return ecrToken{}, fmt.Errorf("GetAuthorizationToken returned no authorization data")
+	}
+
+	data := result.AuthorizationData[0]
+	decoded, err := base64.StdEncoding.DecodeString(data.AuthorizationToken)
+	if err != nil {
+		return ecrToken{}, fmt.Errorf("failed to decode authorization token: %w", err)
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return ecrToken{}, fmt.Errorf("malformed authorization token")
+	}
+
+	// Tokens last 12 hours; assume the minimum if the expiry is missing
+	expiresAt := time.Now().Add(ecrTokenRefreshWindow)
+	if data.ExpiresAt > 0 {
+		expiresAt = time.Unix(int64(data.ExpiresAt), 0)
+	}
+	return ecrToken{username: username, password: password, expiresAt: expiresAt}, nil
+}
+
+// awsTransport sends AWS API calls, including those the credential chain
+// makes to STS and SSO, through the exporter's transport, and the calls to the
+// local ECS and EC2 credential endpoints directly
+type awsTransport struct {
+	http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t awsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if ip := net.ParseIP(req.URL.Hostname()); ip != nil && (ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.Equal(net.ParseIP("fd00:ec2::254"))) {
+		return metadataClient.Transport.RoundTrip(req)
+	}
+	if t.RoundTripper == nil {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+// metadataClient talks to link-local credential endpoints, which must never go through a proxy
+var metadataClient = &http.Client{
+	Transport: &http.Transport{Proxy: nil},
+	Timeout:   2 * time.Second,
+}
+
+// fetchMetadata performs a metadata request and returns the body
+func fetchMetadata(req *http.Request) ([]byte, error) {
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", req.URL.Path, resp.Status)
+	}
+	return body, nil
+}
+
+// fetchMetadataJSON performs a metadata request and decodes the JSON body
+func fetchMetadataJSON(req *http.Request, v interface{}) error {
+	body, err := fetchMetadata(req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
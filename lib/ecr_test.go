@@ -0,0 +1,145 @@
+//go:build !nocloud
+
+package lib
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestIsECRRegistry(t *testing.T) {
+	tests := map[string]bool{
+		"123456789012.dkr.ecr.us-east-1.amazonaws.com":          true,
+		"123456789012.dkr.ecr-fips.us-gov-west-1.amazonaws.com": true,
+		"123456789012.dkr.ecr.cn-north-1.amazonaws.com.cn":      true,
+		"public.ecr.aws":                        false,
+		"index.docker.io":                       false,
+		"12345.dkr.ecr.us-east-1.amazonaws.com": false,
+	}
+	for registry, expected := range tests {
+		if got := IsECRRegistry(registry); got != expected {
+			t.Errorf("Expected IsECRRegistry(%q) = %v, got %v", registry, expected, got)
+		}
+	}
+}
+
+// newFakeECR serves GetAuthorizationToken, counting calls
+func newFakeECR(t *testing.T, calls *int) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		if target := r.Header.Get("X-Amz-Target"); !strings.HasSuffix(target, ".GetAuthorizationToken") {
+			t.Errorf("Expected GetAuthorizationToken, got %q", target)
+		}
+		if auth := r.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+			t.Errorf("Expected SigV4 authorization, got %q", auth)
+		}
+		if token := r.Header.Get("X-Amz-Security-Token"); token != "session" {
+			t.Errorf("Expected session token header, got %q", token)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"authorizationData": []map[string]interface{}{{
+				"authorizationToken": base64.StdEncoding.EncodeToString([]byte("AWS:ecr-password")),
+				"expiresAt":          float64(time.Now().Add(12 * time.Hour).Unix()),
+			}},
+		})
+	}))
+}
+
+func TestECRKeychain(t *testing.T) {
+	var calls int
+	server := newFakeECR(t, &calls)
+	defer server.Close()
+
+	keychain := &ecrKeychain{
+		endpoint:    server.URL,
+		credentials: credentials.NewStaticCredentialsProvider("AKIDEXAMPLE", "secret", "session"),
+	}
+
+	repo, err := name.NewRepository("123456789012.dkr.ecr.us-east-1.amazonaws.com/app")
+	if err != nil {
+		t.Fatalf("Failed to parse repository: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		authenticator, err := keychain.Resolve(repo)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		cfg, err := authenticator.Authorization()
+		if err != nil {
+			t.Fatalf("Failed to get authorization: %v", err)
+		}
+		if cfg.Username != "AWS" || cfg.Password != "ecr-password" {
+			t.Errorf("Expected AWS/ecr-password, got %s/%s", cfg.Username, cfg.Password)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected the token to be cached after 1 call, got %d calls", calls)
+	}
+
+	other, err := name.NewRepository("registry.example.com/app")
+	if err != nil {
+		t.Fatalf("Failed to parse repository: %v", err)
+	}
+	authenticator, err := keychain.Resolve(other)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if authenticator != authn.Anonymous {
+		t.Errorf("Expected anonymous access for non-ECR registry, got %v", authenticator)
+	}
+}
+
+func TestECRKeychainProfile(t *testing.T) {
+	var calls int
+	server := newFakeECR(t, &calls)
+	defer server.Close()
+
+	// A profile of the shared config file that only credential_process can
+	// satisfy, which the SDK chain resolves
+	dir := t.TempDir()
+	process := `{"Version": 1, "AccessKeyId": "AKIDEXAMPLE", "SecretAccessKey": "secret", "SessionToken": "session"}`
+	content := "[profile ci]\nregion = us-east-1\ncredential_process = echo '" + process + "'\n"
+	if err := os.WriteFile(filepath.Join(dir, "config"), []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_PROFILE", "ci")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_CA_BUNDLE", "")
+
+	keychain := &ecrKeychain{endpoint: server.URL}
+	token, err := keychain.fetchToken("123456789012", "us-east-1", false, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if token.password != "ecr-password" || calls != 1 {
+		t.Errorf("Expected ecr-password after 1 call, got %q after %d calls", token.password, calls)
+	}
+}
+
+func TestParseAuthMode(t *testing.T) {
+	for input, expected := range map[string]AuthMode{"": AuthModeAuto, "auto": AuthModeAuto, "ecr": AuthModeECR, "google": AuthModeGoogle, "acr": AuthModeACR, "anonymous": AuthModeAnonymous} {
+		mode, err := ParseAuthMode(input)
+		if err != nil || mode != expected {
+			t.Errorf("Expected ParseAuthMode(%q) = %q, got %q (%v)", input, expected, mode, err)
+		}
+	}
+	if _, err := ParseAuthMode("kerberos"); err == nil {
+		t.Error("Expected error for unknown auth mode")
+	}
+}
@@ -0,0 +1,403 @@
+package lib
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Annotations used by encrypted OCI layers (see github.com/containers/ocicrypt)
+const (
+	// AnnotationEncryptionKeysJWE holds the comma-separated, base64-encoded JWE
+	// envelopes that wrap the layer's symmetric key for each recipient
+	AnnotationEncryptionKeysJWE = "org.opencontainers.image.enc.keys.jwe"
+
+	// AnnotationEncryptionPubOpts holds the base64-encoded public cipher options
+	AnnotationEncryptionPubOpts = "org.opencontainers.image.enc.pubopts"
+)
+
+// cipherAES256CTR is the only layer cipher defined by ocicrypt
+const cipherAES256CTR = "AES_256_CTR_HMAC_SHA256"
+
+// ErrNoDecryptionKey is returned when an encrypted layer cannot be decrypted
+// with any of the configured keys.
+var ErrNoDecryptionKey = errors.New("no decryption key matches the encrypted layer")
+
+// ErrLayerIntegrity is returned when the HMAC of an encrypted layer does not
+// match its ciphertext, e.g. because the ciphertext was tampered with.
+var ErrLayerIntegrity = errors.New("encrypted layer failed integrity check")
+
+// WithDecryptionKeys enables decryption of encrypted OCI layers
+// (media types ending in "+encrypted") using the given private keys.
+//
+// Keys are tried in order against each recipient of the layer. RSA keys
+// (RSA-OAEP) and ECDSA keys (ECDH-ES+A256KW) are supported; use
+// LoadDecryptionKey to read them from PEM files.
+func WithDecryptionKeys(keys ...crypto.PrivateKey) ExporterOption {
+	return func(e *imageExporter) {
+		e.decryptionKeys = append(e.decryptionKeys, keys...)
+	}
+}
+
+// LoadDecryptionKey reads an unencrypted PEM private key (PKCS#1, PKCS#8 or SEC 1 EC)
+// for use with WithDecryptionKeys.
+func LoadDecryptionKey(path string) (crypto.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read decryption key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported private key format in %s", path)
+}
+
+// LoadEncryptionRecipient reads the public key of a recipient of encrypted
+// layers from a PEM file holding an X.509 certificate or a PKIX or PKCS#1 public key.
+// RSA and ECDSA keys are supported.
+func LoadEncryptionRecipient(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption recipient: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	var key crypto.PublicKey
+	if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		key = cert.PublicKey
+	} else if key, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		if key, err = x509.ParsePKCS1PublicKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("unsupported public key format in %s", path)
+		}
+	}
+	if _, err := recipientAlgorithm(key); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// recipientAlgorithm returns the JWE key wrapping algorithm ocicrypt uses for a public key
+func recipientAlgorithm(key crypto.PublicKey) (jose.KeyAlgorithm, error) {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return jose.RSA_OAEP, nil
+	case *ecdsa.PublicKey:
+		return jose.ECDH_ES_A256KW, nil
+	}
+	return "", fmt.Errorf("unsupported recipient key type %T", key)
+}
+
+// encryptedMediaType returns the media type of a layer once encrypted
+func encryptedMediaType(mediaType types.MediaType) types.MediaType {
+	return mediaType + "+encrypted"
+}
+
+// isEncryptedMediaType reports whether a layer media type denotes an encrypted layer
+func isEncryptedMediaType(mediaType string) bool {
+	return strings.HasSuffix(mediaType, "+encrypted")
+}
+
+// publicCipherOptions mirrors ocicrypt's PublicLayerBlockCipherOptions
+type publicCipherOptions struct {
+	Cipher        string            `json:"cipher"`
+	Hmac          []byte            `json:"hmac"`
+	CipherOptions map[string][]byte `json:"cipheroptions"`
+}
+
+// privateCipherOptions mirrors ocicrypt's PrivateLayerBlockCipherOptions
+type privateCipherOptions struct {
+	SymmetricKey  []byte            `json:"symkey"`
+	Digest        string            `json:"digest"`
+	CipherOptions map[string][]byte `json:"cipheroptions"`
+}
+
+// decryptLayer is the LayerHandler for encrypted layers. It unwraps the symmetric
+// key with the exporter's private keys, decrypts the blob and then decompresses
+// the inner layer.
+func (e *imageExporter) decryptLayer(blob io.ReadCloser, desc v1.Descriptor) (io.ReadCloser, error) {
+	if len(e.decryptionKeys) == 0 {
+		return nil, fmt.Errorf("layer %s is encrypted and no decryption keys are configured", desc.Digest)
+	}
+
+	public, err := decodePublicCipherOptions(desc.Annotations[AnnotationEncryptionPubOpts])
+	if err != nil {
+		return nil, err
+	}
+	if public.Cipher != cipherAES256CTR {
+		return nil, fmt.Errorf("unsupported layer cipher %q", public.Cipher)
+	}
+
+	private, err := e.unwrapCipherOptions(desc.Annotations[AnnotationEncryptionKeysJWE])
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(private.SymmetricKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid layer key: %w", err)
+	}
+	nonce := private.CipherOptions["nonce"]
+	if len(nonce) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid layer nonce length %d", len(nonce))
+	}
+
+	decrypted := &ctrHMACReader{
+		source:   blob,
+		stream:   cipher.NewCTR(block, nonce),
+		mac:      hmac.New(sha256.New, private.SymmetricKey),
+		expected: public.Hmac,
+	}
+	return decompressStream(decrypted)
+}
+
+// encryptLayer encrypts a compressed layer blob for the given recipients the way
+// ocicrypt does: AES-256-CTR under a fresh key, with an HMAC-SHA256 of the
+// ciphertext, the key wrapped in one JWE for all recipients. The ciphertext is
+// written to dst and the returned annotations describe it.
+func encryptLayer(dst io.Writer, blob io.Reader, recipients []crypto.PublicKey) (map[string]string, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no encryption recipients")
+	}
+	joseRecipients := make([]jose.Recipient, 0, len(recipients))
+	for _, key := range recipients {
+		algorithm, err := recipientAlgorithm(key)
+		if err != nil {
+			return nil, err
+		}
+		joseRecipients = append(joseRecipients, jose.Recipient{Algorithm: algorithm, Key: key})
+	}
+
+	symKey := make([]byte, 32)
+	nonce := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(symKey); err != nil {
+		return nil, fmt.Errorf("failed to generate layer key: %w", err)
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate layer nonce: %w", err)
+	}
+	block, err := aes.NewCipher(symKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid layer key: %w", err)
+	}
+
+	// The HMAC covers the ciphertext and the digest the plaintext
+	mac := hmac.New(sha256.New, symKey)
+	plainDigest := sha256.New()
+	encrypted := cipher.StreamWriter{S: cipher.NewCTR(block, nonce), W: io.MultiWriter(dst, mac)}
+	if _, err := io.Copy(encrypted, io.TeeReader(blob, plainDigest)); err != nil {
+		return nil, fmt.Errorf("failed to encrypt layer: %w", err)
+	}
+
+	private, err := json.Marshal(privateCipherOptions{
+		SymmetricKey:  symKey,
+		Digest:        fmt.Sprintf("sha256:%x", plainDigest.Sum(nil)),
+		CipherOptions: map[string][]byte{"nonce": nonce},
+	})
+	if err != nil {
+		return nil, err
+	}
+	public, err := json.Marshal(publicCipherOptions{
+		Cipher:        cipherAES256CTR,
+		Hmac:          mac.Sum(nil),
+		CipherOptions: map[string][]byte{},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	encrypter, err := jose.NewMultiEncrypter(jose.A256GCM, joseRecipients, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap layer key: %w", err)
+	}
+	jwe, err := encrypter.Encrypt(private)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap layer key: %w", err)
+	}
+	return map[string]string{
+		AnnotationEncryptionKeysJWE: base64.StdEncoding.EncodeToString([]byte(jwe.FullSerialize())),
+		AnnotationEncryptionPubOpts: base64.StdEncoding.EncodeToString(public),
+	}, nil
+}
+
+// encryptedLayer is an encrypted layer blob staged in a file. Its DiffID is the
+// one of the plaintext layer, which the image configuration keeps.
+type encryptedLayer struct {
+	path      string
+	digest    v1.Hash
+	diffID    v1.Hash
+	size      int64
+	mediaType types.MediaType
+}
+
+// Digest implements v1.Layer
+func (l *encryptedLayer) Digest() (v1.Hash, error) {
+	return l.digest, nil
+}
+
+// DiffID implements v1.Layer
+func (l *encryptedLayer) DiffID() (v1.Hash, error) {
+	return l.diffID, nil
+}
+
+// Compressed implements v1.Layer, returning the ciphertext
+func (l *encryptedLayer) Compressed() (io.ReadCloser, error) {
+	return os.Open(l.path)
+}
+
+// Uncompressed implements v1.Layer; an encrypted layer can only be read as ciphertext
+func (l *encryptedLayer) Uncompressed() (io.ReadCloser, error) {
+	return nil, fmt.Errorf("layer %s is encrypted", l.digest)
+}
+
+// Size implements v1.Layer
+func (l *encryptedLayer) Size() (int64, error) {
+	return l.size, nil
+}
+
+// MediaType implements v1.Layer
+func (l *encryptedLayer) MediaType() (types.MediaType, error) {
+	return l.mediaType, nil
+}
+
+// decodePublicCipherOptions parses the pubopts annotation
+func decodePublicCipherOptions(annotation string) (*publicCipherOptions, error) {
+	if annotation == "" {
+		return nil, fmt.Errorf("encrypted layer is missing the %s annotation", AnnotationEncryptionPubOpts)
+	}
+	data, err := base64.StdEncoding.DecodeString(annotation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode layer cipher options: %w", err)
+	}
+	var opts publicCipherOptions
+	if err := json.Unmarshal(data, &opts); err != nil {
+		return nil, fmt.Errorf("failed to parse layer cipher options: %w", err)
+	}
+	return &opts, nil
+}
+
+// unwrapCipherOptions tries every configured key against every JWE recipient
+// envelope until one yields the private cipher options
+func (e *imageExporter) unwrapCipherOptions(annotation string) (*privateCipherOptions, error) {
+	if annotation == "" {
+		return nil, fmt.Errorf("encrypted layer is missing the %s annotation", AnnotationEncryptionKeysJWE)
+	}
+
+	for _, envelope := range strings.Split(annotation, ",") {
+		data, err := base64.StdEncoding.DecodeString(envelope)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode layer key envelope: %w", err)
+		}
+		jwe, err := jose.ParseEncrypted(string(data),
+			[]jose.KeyAlgorithm{jose.RSA_OAEP, jose.RSA_OAEP_256, jose.ECDH_ES_A256KW},
+			[]jose.ContentEncryption{jose.A256GCM})
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse layer key envelope: %w", err)
+		}
+
+		for _, key := range e.decryptionKeys {
+			_, _, plaintext, err := jwe.DecryptMulti(key)
+			if err != nil {
+				continue
+			}
+			var opts privateCipherOptions
+			if err := json.Unmarshal(plaintext, &opts); err != nil {
+				return nil, fmt.Errorf("failed to parse layer key: %w", err)
+			}
+			return &opts, nil
+		}
+	}
+
+	return nil, ErrNoDecryptionKey
+}
+
+// ctrHMACReader decrypts an AES-CTR stream and verifies its HMAC at EOF. Tar
+// readers stop at the end-of-archive marker, before the end of the ciphertext,
+// so Close reads the rest of it and returns ErrLayerIntegrity on a mismatch:
+// callers must check the error of Close before using what they read.
+type ctrHMACReader struct {
+	source   io.ReadCloser
+	stream   cipher.Stream
+	mac      hash.Hash
+	expected []byte
+	checked  error // result of the HMAC check once EOF is reached
+	eof      bool
+}
+
+// Read implements io.Reader
+func (r *ctrHMACReader) Read(p []byte) (int, error) {
+	if r.eof {
+		return 0, r.eofError()
+	}
+	n, err := r.source.Read(p)
+	if n > 0 {
+		// The HMAC covers the ciphertext, so update it before decrypting in place
+		r.mac.Write(p[:n])
+		r.stream.XORKeyStream(p[:n], p[:n])
+	}
+	if err == io.EOF {
+		r.eof = true
+		if !hmac.Equal(r.mac.Sum(nil), r.expected) {
+			r.checked = ErrLayerIntegrity
+		}
+		return n, r.eofError()
+	}
+	return n, err
+}
+
+// eofError returns io.EOF, or the failed integrity check
+func (r *ctrHMACReader) eofError() error {
+	if r.checked != nil {
+		return r.checked
+	}
+	return io.EOF
+}
+
+// Close implements io.Closer, verifying the HMAC over the whole ciphertext
+func (r *ctrHMACReader) Close() error {
+	var err error
+	if !r.eof {
+		_, err = io.Copy(io.Discard, r)
+	}
+	if err == nil {
+		err = r.checked
+	}
+	if closeErr := r.source.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
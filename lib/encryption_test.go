@@ -0,0 +1,203 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// encryptTestLayer encrypts a compressed layer blob the way ocicrypt does and
+// returns the ciphertext with the annotations describing it
+func encryptTestLayer(t *testing.T, blob []byte, recipient *rsa.PublicKey) ([]byte, map[string]string) {
+	t.Helper()
+
+	symKey := make([]byte, 32)
+	nonce := make([]byte, aes.BlockSize)
+	rand.Read(symKey)
+	rand.Read(nonce)
+
+	block, err := aes.NewCipher(symKey)
+	if err != nil {
+		t.Fatalf("Failed to create cipher: %v", err)
+	}
+	ciphertext := make([]byte, len(blob))
+	cipher.NewCTR(block, nonce).XORKeyStream(ciphertext, blob)
+	mac := hmac.New(sha256.New, symKey)
+	mac.Write(ciphertext)
+
+	private, _ := json.Marshal(privateCipherOptions{
+		SymmetricKey:  symKey,
+		CipherOptions: map[string][]byte{"nonce": nonce},
+	})
+	public, _ := json.Marshal(publicCipherOptions{
+		Cipher:        cipherAES256CTR,
+		Hmac:          mac.Sum(nil),
+		CipherOptions: map[string][]byte{},
+	})
+
+	encrypter, err := jose.NewMultiEncrypter(jose.A256GCM, []jose.Recipient{
+		{Algorithm: jose.RSA_OAEP, Key: recipient},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create JWE encrypter: %v", err)
+	}
+	jwe, err := encrypter.Encrypt(private)
+	if err != nil {
+		t.Fatalf("Failed to wrap layer key: %v", err)
+	}
+
+	return ciphertext, map[string]string{
+		AnnotationEncryptionKeysJWE: base64.StdEncoding.EncodeToString([]byte(jwe.FullSerialize())),
+		AnnotationEncryptionPubOpts: base64.StdEncoding.EncodeToString(public),
+	}
+}
+
+func TestExportEncryptedLayer(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	plain := newTestLayer(t, testEntry{name: "app/secret.conf", content: "confidential"})
+	compressed, err := plain.Compressed()
+	if err != nil {
+		t.Fatalf("Failed to read test layer: %v", err)
+	}
+	blob, err := io.ReadAll(compressed)
+	compressed.Close()
+	if err != nil {
+		t.Fatalf("Failed to read test layer: %v", err)
+	}
+
+	mediaType := types.MediaType("application/vnd.oci.image.layer.v1.tar+gzip+encrypted")
+	ciphertext, annotations := encryptTestLayer(t, blob, &key.PublicKey)
+	image, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       static.NewLayer(ciphertext, mediaType),
+		Annotations: annotations,
+		MediaType:   mediaType,
+	})
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+
+	host := newTestRegistry(t)
+	imageRef := host + "/test/encrypted:latest"
+	pushTestImage(t, imageRef, image)
+
+	// Write the key to disk to exercise LoadDecryptionKey
+	keyPath := filepath.Join(t.TempDir(), "key.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	loaded, err := LoadDecryptionKey(keyPath)
+	if err != nil {
+		t.Fatalf("Failed to load key: %v", err)
+	}
+
+	var buf bytes.Buffer
+	exporter := NewImageExporter(WithDecryptionKeys(loaded))
+	if err := exporter.ExportImageFilesystemToWriter(imageRef, &buf, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	files := readTestTar(t, buf.Bytes())
+	if files["app/secret.conf"] != "confidential" {
+		t.Errorf("Expected decrypted content, got %q", files["app/secret.conf"])
+	}
+
+	// Without keys, or with the wrong key, the export must fail
+	if err := NewImageExporter().ExportImageFilesystemToWriter(imageRef, io.Discard, nil); err == nil {
+		t.Error("Expected error exporting encrypted layer without keys")
+	}
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	err = NewImageExporter(WithDecryptionKeys(other)).ExportImageFilesystemToWriter(imageRef, io.Discard, nil)
+	if !errors.Is(err, ErrNoDecryptionKey) {
+		t.Errorf("Expected ErrNoDecryptionKey, got %v", err)
+	}
+}
+
+func TestExportEncryptedLayerIntegrity(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	plain := newTestLayer(t, testEntry{name: "app/secret.conf", content: "confidential"})
+	compressed, err := plain.Compressed()
+	if err != nil {
+		t.Fatalf("Failed to read test layer: %v", err)
+	}
+	blob, err := io.ReadAll(compressed)
+	compressed.Close()
+	if err != nil {
+		t.Fatalf("Failed to read test layer: %v", err)
+	}
+	// Trailing bytes after the gzip stream are never read by the tar reader,
+	// and must still be covered by the HMAC
+	blob = append(blob, make([]byte, 4096)...)
+	ciphertext, annotations := encryptTestLayer(t, blob, &key.PublicKey)
+
+	mediaType := types.MediaType("application/vnd.oci.image.layer.v1.tar+gzip+encrypted")
+	host := newTestRegistry(t)
+	push := func(name string, ciphertext []byte, annotations map[string]string) string {
+		image, err := mutate.Append(empty.Image, mutate.Addendum{
+			Layer:       static.NewLayer(ciphertext, mediaType),
+			Annotations: annotations,
+			MediaType:   mediaType,
+		})
+		if err != nil {
+			t.Fatalf("Failed to build test image: %v", err)
+		}
+		imageRef := host + "/test/" + name + ":latest"
+		pushTestImage(t, imageRef, image)
+		return imageRef
+	}
+
+	// A zeroed HMAC
+	public, err := decodePublicCipherOptions(annotations[AnnotationEncryptionPubOpts])
+	if err != nil {
+		t.Fatalf("Failed to decode cipher options: %v", err)
+	}
+	public.Hmac = make([]byte, len(public.Hmac))
+	data, _ := json.Marshal(public)
+	badHMAC := map[string]string{
+		AnnotationEncryptionKeysJWE: annotations[AnnotationEncryptionKeysJWE],
+		AnnotationEncryptionPubOpts: base64.StdEncoding.EncodeToString(data),
+	}
+
+	// Ciphertext tampered with beyond the end of the tar stream
+	tampered := bytes.Clone(ciphertext)
+	tampered[len(tampered)-1] ^= 0xff
+
+	exporter := NewImageExporter(WithDecryptionKeys(key))
+	for name, imageRef := range map[string]string{
+		"bad-hmac": push("bad-hmac", ciphertext, badHMAC),
+		"tampered": push("tampered", tampered, annotations),
+	} {
+		err := exporter.ExportImageFilesystemToWriter(imageRef, io.Discard, nil)
+		if !errors.Is(err, ErrLayerIntegrity) {
+			t.Errorf("Expected ErrLayerIntegrity for the %s layer, got %v", name, err)
+		}
+	}
+	if err := exporter.ExportImageFilesystemToWriter(push("intact", ciphertext, annotations), io.Discard, nil); err != nil {
+		t.Errorf("Expected the intact layer to export, got %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package lib
+
+import "os"
+
+// Environment variables consulted by AuthConfigFromEnv
+const (
+	// EnvUsername is the registry username
+	EnvUsername = "IMGEX_USERNAME"
+
+	// EnvPassword is the registry password
+	EnvPassword = "IMGEX_PASSWORD"
+
+	// EnvToken is an access token used as the password when EnvPassword is unset
+	EnvToken = "IMGEX_TOKEN"
+
+	// EnvRegistry limits the credentials to a single registry
+	EnvRegistry = "IMGEX_REGISTRY"
+)
+
+// AuthConfigFromEnv builds an AuthConfig from the IMGEX_* environment variables.
+//
+// IMGEX_USERNAME and IMGEX_PASSWORD provide the credentials; IMGEX_TOKEN is used
+// as the password when IMGEX_PASSWORD is not set. IMGEX_REGISTRY restricts the
+// credentials to one registry, other registries then use the docker config.
+// Returns nil if neither a username nor a password or token is set.
+//
+// The imgex CLI gives command-line flags precedence over these variables, and
+// both take precedence over the docker config and credential helpers.
+func AuthConfigFromEnv() *AuthConfig {
+	password := os.Getenv(EnvPassword)
+	if password == "" {
+		password = os.Getenv(EnvToken)
+	}
+
+	username := os.Getenv(EnvUsername)
+	if username == "" && password == "" {
+		return nil
+	}
+
+	return &AuthConfig{
+		Username: username,
+		Password: password,
+		Registry: os.Getenv(EnvRegistry),
+	}
+}
@@ -0,0 +1,45 @@
+package lib
+
+import "testing"
+
+func TestAuthConfigFromEnv(t *testing.T) {
+	t.Setenv(EnvUsername, "")
+	t.Setenv(EnvPassword, "")
+	t.Setenv(EnvToken, "")
+	t.Setenv(EnvRegistry, "")
+	if auth := AuthConfigFromEnv(); auth != nil {
+		t.Fatalf("Expected nil without environment, got %+v", auth)
+	}
+
+	t.Setenv(EnvUsername, "ci")
+	t.Setenv(EnvToken, "token")
+	t.Setenv(EnvRegistry, "registry.example.com")
+	auth := AuthConfigFromEnv()
+	if auth == nil {
+		t.Fatal("Expected auth config from environment")
+	}
+	if auth.Username != "ci" || auth.Password != "token" || auth.Registry != "registry.example.com" {
+		t.Errorf("Unexpected auth config %+v", auth)
+	}
+
+	// An explicit password wins over the token
+	t.Setenv(EnvPassword, "secret")
+	if auth := AuthConfigFromEnv(); auth.Password != "secret" {
+		t.Errorf("Expected IMGEX_PASSWORD to take precedence, got %q", auth.Password)
+	}
+}
+
+func TestNormalizeRegistry(t *testing.T) {
+	testCases := map[string]string{
+		"registry.example.com":         "registry.example.com",
+		"https://registry.example.com": "registry.example.com",
+		"localhost:5000/v2/":           "localhost:5000",
+		"https://index.docker.io/v1/":  "index.docker.io",
+		"docker.io":                    "index.docker.io",
+	}
+	for input, expected := range testCases {
+		if got := normalizeRegistry(input); got != expected {
+			t.Errorf("normalizeRegistry(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// estimatedCompressionRatio is the typical ratio of the uncompressed to the
+// compressed size of gzip and zstd image layers
+const estimatedCompressionRatio = 2.5
+
+// ExportEstimate tells how much an export of an image would download and
+// write. It is returned by the EstimateExport method.
+type ExportEstimate struct {
+	// SchemaVersion is the version of this JSON document (see SchemaVersion)
+	SchemaVersion int `json:"schema_version"`
+
+	// Reference is the image reference that was estimated
+	Reference string `json:"reference"`
+
+	// Digest is the manifest digest of the image
+	Digest string `json:"digest"`
+
+	// CompressedSize is the total size of the layer blobs, from the manifest
+	CompressedSize int64 `json:"compressed_size"`
+
+	// DownloadSize is the part of CompressedSize the export would download:
+	// layers in the blob cache and skipped foreign layers are not
+	DownloadSize int64 `json:"download_size"`
+
+	// CachedSize is the part of CompressedSize already in the blob cache
+	CachedSize int64 `json:"cached_size"`
+
+	// UncompressedSize estimates the total size of the uncompressed layers
+	UncompressedSize int64 `json:"uncompressed_size"`
+
+	// OutputSize estimates the size of the exported archive: at most
+	// UncompressedSize, less what later layers replace or delete, and
+	// compressed again with Compress
+	OutputSize int64 `json:"output_size"`
+
+	// Exact reports whether UncompressedSize is exact rather than estimated,
+	// which is the case when no layer is compressed
+	Exact bool `json:"exact"`
+
+	// Layers lists the layers in order
+	Layers []LayerEstimate `json:"layers"`
+}
+
+// LayerEstimate is one layer of an ExportEstimate
+type LayerEstimate struct {
+	// Index is the zero-based index of the layer in the image
+	Index int `json:"index"`
+
+	// Digest is the digest of the layer
+	Digest string `json:"digest"`
+
+	// MediaType is the media type of the layer
+	MediaType string `json:"media_type"`
+
+	// Size is the size of the compressed layer blob
+	Size int64 `json:"size"`
+
+	// Cached reports whether the blob is in the blob cache
+	Cached bool `json:"cached"`
+
+	// Skipped reports a foreign layer the export would leave out
+	Skipped bool `json:"skipped"`
+
+	// UncompressedSize estimates the size of the uncompressed layer
+	UncompressedSize int64 `json:"uncompressed_size"`
+}
+
+// EstimateExport tells how much exporting an image would download and write,
+// from the sizes of the layers in its manifest, without downloading them. The
+// uncompressed size of compressed layers is estimated with a typical
+// compression ratio, so it is only a budget: the exported archive is smaller
+// when later layers replace or delete files.
+//
+// Parameters:
+//   - imageRef: Image reference (e.g., "nginx:alpine")
+//   - auth: Optional authentication configuration for private registries
+//   - opts: The options of the export; only Platform and Compress matter
+//
+// Returns:
+//   - *ExportEstimate: The download and output sizes
+//   - error: Any error encountered during the operation
+//
+// Example:
+//
+//	estimate, err := exporter.EstimateExport("nginx:alpine", nil, nil)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	fmt.Println("download", estimate.DownloadSize, "write about", estimate.OutputSize)
+func (e *imageExporter) EstimateExport(imageRef string, auth *AuthConfig, opts *ExportOptions) (*ExportEstimate, error) {
+	if opts == nil {
+		opts = &ExportOptions{}
+	}
+
+	image, err := e.openPlatformImage(imageRef, auth, opts.Platform)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image digest: %w", err)
+	}
+	manifest, err := image.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest: %w", err)
+	}
+
+	estimate := &ExportEstimate{
+		SchemaVersion: SchemaVersion,
+		Reference:     imageRef,
+		Digest:        digest.String(),
+		Exact:         true,
+		Layers:        []LayerEstimate{},
+	}
+	for i, desc := range manifest.Layers {
+		layer := LayerEstimate{
+			Index:     i,
+			Digest:    desc.Digest.String(),
+			MediaType: string(desc.MediaType),
+			Size:      desc.Size,
+			Cached:    e.cached(desc.Digest),
+			Skipped:   e.foreignLayers == ForeignLayersSkip && !desc.MediaType.IsDistributable(),
+		}
+		estimate.CompressedSize += layer.Size
+		switch {
+		case layer.Cached:
+			estimate.CachedSize += layer.Size
+		case !layer.Skipped:
+			estimate.DownloadSize += layer.Size
+		}
+		if !layer.Skipped {
+			layer.UncompressedSize = layer.Size
+			if isCompressedMediaType(layer.MediaType) {
+				layer.UncompressedSize = int64(float64(layer.Size) * estimatedCompressionRatio)
+				estimate.Exact = false
+			}
+		}
+		estimate.UncompressedSize += layer.UncompressedSize
+		estimate.Layers = append(estimate.Layers, layer)
+	}
+
+	// The archive repeats the layer entries that survive flattening
+	estimate.OutputSize = estimate.UncompressedSize
+	if opts.Compress {
+		estimate.OutputSize = int64(float64(estimate.UncompressedSize) / estimatedCompressionRatio)
+	}
+	e.log().Debug("export estimated", "image", imageRef, "download_size", estimate.DownloadSize, "output_size", estimate.OutputSize)
+	return estimate, nil
+}
+
+// cached reports whether a blob is in the blob cache
+func (e *imageExporter) cached(digest v1.Hash) bool {
+	if e.cache == nil {
+		return false
+	}
+	_, err := os.Stat(e.cache.path(digest))
+	return err == nil
+}
+
+// isCompressedMediaType reports whether layers of a media type are compressed
+func isCompressedMediaType(mediaType string) bool {
+	return strings.Contains(mediaType, "gzip") || strings.Contains(mediaType, "zstd")
+}
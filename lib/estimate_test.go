@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// pathRecorder records the paths of requests before delegating to the default transport
+type pathRecorder struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+func (r *pathRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	r.paths = append(r.paths, req.URL.Path)
+	r.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestEstimateExport(t *testing.T) {
+	host := newTestRegistry(t)
+	imageRef := host + "/test/app:v1"
+
+	first := newTestLayer(t, testEntry{name: "a.txt", content: strings.Repeat("a", 4096)})
+	second := newTestLayer(t, testEntry{name: "b.txt", content: "b"})
+	image, err := mutate.AppendLayers(empty.Image, first, second)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	pushTestImage(t, imageRef, image)
+	var compressed int64
+	for _, layer := range []v1.Layer{first, second} {
+		size, _ := layer.Size()
+		compressed += size
+	}
+
+	recorder := &pathRecorder{}
+	cacheDir := t.TempDir()
+	exporter := NewImageExporter(WithTransport(recorder), WithCache(cacheDir))
+	estimate, err := exporter.EstimateExport(imageRef, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(estimate.Layers) != 2 || estimate.CompressedSize != compressed || estimate.DownloadSize != compressed {
+		t.Errorf("Expected 2 layers of %d bytes to download, got %+v", compressed, estimate)
+	}
+	if estimate.Exact || estimate.UncompressedSize != estimate.OutputSize || estimate.UncompressedSize <= compressed {
+		t.Errorf("Expected an estimated uncompressed size above %d, got %+v", compressed, estimate)
+	}
+	for _, layer := range estimate.Layers {
+		for _, p := range recorder.paths {
+			if strings.HasSuffix(p, "/blobs/"+layer.Digest) {
+				t.Errorf("Expected layer %s not to be downloaded, got request %s", layer.Digest, p)
+			}
+		}
+	}
+
+	compressedOutput, err := exporter.EstimateExport(imageRef, nil, &ExportOptions{Compress: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if compressedOutput.OutputSize >= estimate.OutputSize {
+		t.Errorf("Expected a compressed output below %d, got %d", estimate.OutputSize, compressedOutput.OutputSize)
+	}
+
+	// Layers in the blob cache are not downloaded again
+	if err := exporter.ExportImageFilesystemToWriter(imageRef, io.Discard, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	estimate, err = exporter.EstimateExport(imageRef, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if estimate.DownloadSize != 0 || estimate.CachedSize != compressed || !estimate.Layers[0].Cached {
+		t.Errorf("Expected every layer cached, got %+v", estimate)
+	}
+}
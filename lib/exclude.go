@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvExcludes names an exclusion file replacing DefaultExcludes, like the
+// --excludes-file flag
+const EnvExcludes = "IMGEX_EXCLUDES"
+
+// DefaultExcludes lists the paths that are useless or harmful in a root
+// filesystem archive: the mount points filled by the runtime, temporary
+// directories and package manager caches. Mount points keep their directory,
+// only their contents are excluded.
+var DefaultExcludes = []string{
+	"proc/*",
+	"sys/*",
+	"dev/*",
+	"tmp/*",
+	"var/tmp/*",
+	"var/cache/apt/*.bin",
+	"var/cache/apt/archives/*.deb",
+	"var/lib/apt/lists/*",
+	"var/cache/apk/*",
+	"var/cache/dnf/*",
+	"var/cache/yum/*",
+	"root/.cache/*",
+}
+
+// excludeFile is the layout of an exclusion file
+type excludeFile struct {
+	Exclude []string `json:"exclude"`
+}
+
+// LoadExcludes reads an exclusion file, written as JSON or YAML with an
+// "exclude" list of patterns in the syntax of ExportOptions.Exclude:
+//
+//	exclude:
+//	  - proc/*
+//	  - var/cache/apt/archives/*.deb
+//
+// The list replaces DefaultExcludes; copy the entries to keep from it.
+func LoadExcludes(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exclusions: %w", err)
+	}
+	var document interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse exclusions %s: %w", path, err)
+	}
+	normalized, err := json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse exclusions %s: %w", path, err)
+	}
+	var contents excludeFile
+	if err := json.Unmarshal(normalized, &contents); err != nil {
+		return nil, fmt.Errorf("failed to parse exclusions %s: %w", path, err)
+	}
+	if contents.Exclude == nil {
+		return nil, fmt.Errorf(`failed to parse exclusions %s: no "exclude" list found`, path)
+	}
+	if err := validateExcludes(contents.Exclude); err != nil {
+		return nil, fmt.Errorf("invalid exclusions %s: %w", path, err)
+	}
+	return contents.Exclude, nil
+}
+
+// DefaultExcludesFile returns the exclusion file used when none is configured:
+// $IMGEX_EXCLUDES if set, otherwise excludes.yaml in the imgex folder of the
+// user configuration directory (e.g. ~/.config/imgex/excludes.yaml). The file
+// may not exist.
+func DefaultExcludesFile() (string, error) {
+	if path := os.Getenv(EnvExcludes); path != "" {
+		return path, nil
+	}
+	configHome, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the configuration directory: %w", err)
+	}
+	return filepath.Join(configHome, "imgex", "excludes.yaml"), nil
+}
+
+// validateExcludes checks the syntax of exclusion patterns
+func validateExcludes(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(excludePattern(pattern), ""); err != nil || excludePattern(pattern) == "" {
+			return fmt.Errorf("invalid exclusion pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// excludePattern normalizes a pattern like the paths of the filesystem, which
+// have no leading slash
+func excludePattern(pattern string) string {
+	return strings.Trim(pattern, "/")
+}
+
+// excluded reports whether a path or one of its parents matches a pattern
+func excluded(p string, patterns []string) bool {
+	for p = strings.TrimSuffix(p, "/"); p != "." && p != ""; p = path.Dir(p) {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(excludePattern(pattern), p); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// excludePaths removes the entries matching the exclusion patterns from the
+// filesystem, before hardlinks are repaired so that links elsewhere to an
+// excluded file keep its content
+func (e *imageExporter) excludePaths(filesystem map[string]*fileEntry, patterns []string) {
+	removed := 0
+	for p := range filesystem {
+		if excluded(p, patterns) {
+			delete(filesystem, p)
+			removed++
+			e.log().Debug("excluded path", "path", p)
+		}
+	}
+	if removed > 0 {
+		e.log().Info("excluded paths", "entries", removed)
+	}
+}
@@ -0,0 +1,102 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestExcluded(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{"proc/", false},
+		{"proc/cpuinfo", true},
+		{"tmp/build/", true},
+		{"tmp/build/out.o", true},
+		{"var/cache/apt/archives/curl.deb", true},
+		{"var/cache/apt/archives/partial/", false},
+		{"var/lib/apt/lists/lock", true},
+		{"etc/passwd", false},
+		{"procfs/x", false},
+	}
+	for _, tt := range tests {
+		if got := excluded(tt.path, DefaultExcludes); got != tt.expected {
+			t.Errorf("Expected excluded(%q) to be %v, got %v", tt.path, tt.expected, got)
+		}
+	}
+}
+
+func TestLoadExcludes(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		return p
+	}
+
+	excludes, err := LoadExcludes(write("excludes.yaml", "exclude:\n  - /var/log/\n  - tmp/*\n"))
+	if err != nil {
+		t.Fatalf("Expected valid exclusions, got %v", err)
+	}
+	if len(excludes) != 2 || !excluded("var/log/syslog", excludes) || excluded("etc/hosts", excludes) {
+		t.Errorf("Expected var/log and the contents of tmp to be excluded, got %v", excludes)
+	}
+
+	for name, contents := range map[string]string{
+		"empty.yaml":   "aliases: {}\n",
+		"pattern.yaml": "exclude: ['var/[log']\n",
+	} {
+		if _, err := LoadExcludes(write(name, contents)); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
+
+func TestExportExclude(t *testing.T) {
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t,
+		testEntry{name: "tmp/", typeflag: tar.TypeDir, mode: 01777},
+		testEntry{name: "tmp/data", content: "data"},
+		testEntry{name: "tmp/cache/", typeflag: tar.TypeDir, mode: 0755},
+		testEntry{name: "tmp/cache/blob", content: "blob"},
+		testEntry{name: "usr/data", typeflag: tar.TypeLink, linkname: "tmp/data"},
+		testEntry{name: "etc/hosts", content: "hosts"},
+	))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/exclude:latest"
+	pushTestImage(t, imageRef, image)
+
+	var output bytes.Buffer
+	exporter := NewImageExporter()
+	if err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &output, nil, &ExportOptions{Exclude: DefaultExcludes}); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	files := readTestTar(t, output.Bytes())
+	for _, name := range []string{"tmp/data", "tmp/cache/", "tmp/cache/blob"} {
+		if _, ok := files[name]; ok {
+			t.Errorf("Expected %s to be excluded", name)
+		}
+	}
+	if _, ok := files["tmp/"]; !ok {
+		t.Error("Expected the tmp directory to be kept")
+	}
+	// The hardlink keeps the content of the excluded file it shared
+	if files["usr/data"] != "data" || files["etc/hosts"] != "hosts" {
+		t.Errorf("Expected usr/data and etc/hosts to be kept, got %q and %q", files["usr/data"], files["etc/hosts"])
+	}
+
+	if err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &output, nil, &ExportOptions{Exclude: []string{"["}}); err == nil {
+		t.Error("Expected an invalid pattern to fail the export")
+	}
+}
@@ -0,0 +1,194 @@
+package lib
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/ulikunitz/xz"
+)
+
+// FileOptions configures OpenFile
+type FileOptions struct {
+	// Platform selects the image from a multi-arch index, e.g. "linux/arm64";
+	// empty means linux/amd64
+	Platform string
+
+	// PlatformPolicy controls validation of the image platform against the host,
+	// before any layer is read; warnings go to the WithWarnings handler
+	PlatformPolicy PlatformPolicy
+
+	// Decompress transparently decompresses gzip, bzip2, xz and zstd files, detected
+	// from their content. Other files are returned unchanged.
+	Decompress bool
+}
+
+// OpenFile returns the content of a single file of the image's flattened
+// filesystem, as a container would see it: later layers and whiteouts are
+// applied, and symlinks along the path and at the file itself are followed.
+//
+// When every layer is eStargz, only the table of contents of each layer and
+// the compressed chunks of the file are fetched from the registry, with range
+// requests. Other images, and registries that do not serve ranges, have their
+// layers downloaded.
+//
+// Parameters:
+//   - imageRef: Docker image reference (e.g., "ubuntu:24.04")
+//   - filePath: Path of the file in the image (e.g., "/etc/os-release")
+//   - auth: Optional authentication configuration for private registries
+//   - opts: Optional platform and decompression settings
+//
+// Returns:
+//   - io.ReadCloser: The file content; the caller must close it
+//   - *tar.Header: The header of the file the path resolved to (name, mode, stored size)
+//   - error: fs.ErrNotExist if there is no such file, an error for directories
+//     and special files, a *PlatformMismatchError under PlatformPolicyFail, or
+//     any error reaching the registry
+//
+// Example:
+//
+//	content, header, err := exporter.OpenFile("ubuntu:24.04", "usr/share/man/man1/ls.1.gz", nil,
+//	    &FileOptions{Decompress: true})
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	defer content.Close()
+//	fmt.Println(header.Name)
+//	io.Copy(os.Stdout, content)
+func (e *imageExporter) OpenFile(imageRef string, filePath string, auth *AuthConfig, opts *FileOptions) (io.ReadCloser, *tar.Header, error) {
+	if opts == nil {
+		opts = &FileOptions{}
+	}
+
+	image, err := e.openPlatformImage(imageRef, auth, opts.Platform)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := e.applyPlatformPolicy(opts.PlatformPolicy, nil, image); err != nil {
+		return nil, nil, err
+	}
+	filesystem, err := e.lazyFilesystem(image, auth)
+	if err != nil {
+		if !errors.Is(err, errNotLazy) {
+			return nil, nil, err
+		}
+		e.log().Debug("downloading layers", "image", imageRef, "reason", err)
+		if filesystem, err = e.flattenLayers(image); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	entry, ok := resolveFile(filesystem, e.cleanPath(filePath))
+	if !ok {
+		return nil, nil, fmt.Errorf("%s in %s: %w", filePath, imageRef, fs.ErrNotExist)
+	}
+	if entry == nil || entry.header.Typeflag == tar.TypeDir {
+		return nil, nil, fmt.Errorf("%s in %s is a directory", filePath, imageRef)
+	}
+	if entry.header.Typeflag != tar.TypeReg && entry.header.Typeflag != tar.TypeRegA {
+		return nil, nil, fmt.Errorf("%s in %s is not a regular file (%s)", filePath, imageRef, tarTypeName(entry.header.Typeflag))
+	}
+
+	content := io.NopCloser(entry.content())
+	if entry.lazy != nil {
+		if content, err = entry.lazy(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if !opts.Decompress {
+		return content, entry.header, nil
+	}
+	decompressed, err := decompressFile(content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decompress %s: %w", filePath, err)
+	}
+	return decompressed, entry.header, nil
+}
+
+// flattenImage fetches the image of imageRef for platform (empty for the
+// default) and applies its layers, returning the image and its filesystem
+func (e *imageExporter) flattenImage(imageRef string, auth *AuthConfig, platformName string) (v1.Image, map[string]*fileEntry, error) {
+	image, err := e.openPlatformImage(imageRef, auth, platformName)
+	if err != nil {
+		return nil, nil, err
+	}
+	filesystem, err := e.flattenLayers(image)
+	if err != nil {
+		return nil, nil, err
+	}
+	return image, filesystem, nil
+}
+
+// openPlatformImage fetches the image of imageRef for platform (empty for the default)
+func (e *imageExporter) openPlatformImage(imageRef string, auth *AuthConfig, platformName string) (v1.Image, error) {
+	var platform *v1.Platform
+	if platformName != "" {
+		var err error
+		platform, err = v1.ParsePlatform(platformName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid platform %q: %w", platformName, err)
+		}
+	}
+	return e.openImage(imageRef, auth, platform)
+}
+
+// flattenLayers downloads and applies the layers of image
+func (e *imageExporter) flattenLayers(image v1.Image) (map[string]*fileEntry, error) {
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image layers: %w", err)
+	}
+	filesystem, err := e.applyLayers(layers, newWindowsDetector(image))
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply layers: %w", err)
+	}
+	e.finalizeFilesystem(filesystem, nil)
+	return filesystem, nil
+}
+
+// resolveFile looks up a path like resolveEntry, also following a hardlink to
+// the entry holding its content
+func resolveFile(filesystem map[string]*fileEntry, p string) (*fileEntry, bool) {
+	entry, ok := resolveEntry(filesystem, p, 0)
+	if ok && entry != nil && entry.header.Typeflag == tar.TypeLink {
+		target, _ := linkTarget(entry.header.Name, entry.header)
+		entry, ok = lookupEntry(filesystem, target)
+	}
+	return entry, ok
+}
+
+var (
+	bzip2Magic = []byte("BZh")
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// decompressFile detects gzip, bzip2, xz or zstd compression from the content of
+// a file and returns a reader over the decompressed data. Files in no recognized
+// format are returned as-is.
+func decompressFile(r io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read file header: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(header, xzMagic):
+		decoder, err := xz.NewReader(buffered)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("failed to read xz header: %w", err)
+		}
+		return &readCloser{Reader: decoder, close: r.Close}, nil
+	case bytes.HasPrefix(header, bzip2Magic) && len(header) >= 4 && header[3] >= '1' && header[3] <= '9':
+		return &readCloser{Reader: bzip2.NewReader(buffered), close: r.Close}, nil
+	default:
+		return decompressStream(&readCloser{Reader: buffered, close: r.Close})
+	}
+}
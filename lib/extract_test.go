@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// compressTestData compresses data with gzip or zstd
+func compressTestData(t *testing.T, format string, data string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch format {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zstd":
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("Failed to create zstd writer: %v", err)
+		}
+		w = zw
+	case "xz":
+		xw, err := xz.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("Failed to create xz writer: %v", err)
+		}
+		w = xw
+	}
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatalf("Failed to compress test data: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to compress test data: %v", err)
+	}
+	return buf.String()
+}
+
+func TestOpenFile(t *testing.T) {
+	base := newTestLayer(t,
+		testEntry{name: "etc/", typeflag: tar.TypeDir},
+		testEntry{name: "etc/os-release", content: "ID=old\n"},
+		testEntry{name: "etc/removed", content: "gone"},
+		testEntry{name: "usr/share/man/ls.1.gz", content: compressTestData(t, "gzip", ".TH LS 1\n")},
+		testEntry{name: "boot/config.zst", content: compressTestData(t, "zstd", "CONFIG_X=y\n")},
+		testEntry{name: "boot/config.xz", content: compressTestData(t, "xz", "CONFIG_Y=m\n")},
+	)
+	top := newTestLayer(t,
+		testEntry{name: "etc/os-release", content: "ID=new\n"},
+		testEntry{name: "etc/.wh.removed"},
+		testEntry{name: "lib", typeflag: tar.TypeSymlink, linkname: "usr/share"},
+		testEntry{name: "etc/release", typeflag: tar.TypeLink, linkname: "etc/os-release"},
+	)
+	image, err := mutate.AppendLayers(empty.Image, base, top)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/files:latest"
+	pushTestImage(t, imageRef, image)
+	exporter := NewImageExporter()
+
+	for _, test := range []struct {
+		path       string
+		decompress bool
+		expected   string
+	}{
+		{"/etc/os-release", false, "ID=new\n"},
+		{"etc/release", false, "ID=new\n"},
+		{"lib/man/ls.1.gz", true, ".TH LS 1\n"},
+		{"usr/share/man/ls.1.gz", false, compressTestData(t, "gzip", ".TH LS 1\n")},
+		{"boot/config.zst", true, "CONFIG_X=y\n"},
+		{"boot/config.xz", true, "CONFIG_Y=m\n"},
+		{"etc/os-release", true, "ID=new\n"},
+	} {
+		content, _, err := exporter.OpenFile(imageRef, test.path, nil, &FileOptions{Decompress: test.decompress})
+		if err != nil {
+			t.Fatalf("Expected no error for %s, got %v", test.path, err)
+		}
+		data, err := io.ReadAll(content)
+		content.Close()
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", test.path, err)
+		}
+		// gzip output is not byte-for-byte reproducible, so compare decompressed content
+		if !test.decompress && test.path == "usr/share/man/ls.1.gz" {
+			reader, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Expected %s to stay compressed, got %v", test.path, err)
+			}
+			data, _ = io.ReadAll(reader)
+			test.expected = ".TH LS 1\n"
+		}
+		if string(data) != test.expected {
+			t.Errorf("Expected %q for %s, got %q", test.expected, test.path, data)
+		}
+	}
+
+	if _, _, err := exporter.OpenFile(imageRef, "etc/removed", nil, nil); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a whited-out file to be missing, got %v", err)
+	}
+	if _, _, err := exporter.OpenFile(imageRef, "etc", nil, nil); err == nil {
+		t.Error("Expected an error for a directory")
+	}
+}
@@ -0,0 +1,86 @@
+package lib
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Optional features that can be left out of a build with a Go build tag, for a
+// smaller binary with fewer dependencies, e.g. a static imgex for an initramfs:
+//
+//	CGO_ENABLED=0 go build -tags nocloud,noserver ./cmd/imgex
+//
+// Features reports the ones compiled in.
+const (
+	// FeatureCloudAuth is Amazon ECR, Google and Azure credentials (AuthModeECR,
+	// AuthModeGoogle, AuthModeACR and the cloud fallback of AuthModeAuto). Tag: nocloud.
+	FeatureCloudAuth = "cloud-auth"
+
+	// FeatureServer is the 'imgex serve' HTTP API. Tag: noserver.
+	FeatureServer = "server"
+)
+
+var (
+	featuresMu sync.Mutex
+	features   = map[string]bool{}
+)
+
+// RegisterFeature records that an optional feature is compiled in. It is called
+// from init functions of files guarded by the feature's build tag, including
+// those of the imgex command.
+func RegisterFeature(name string) {
+	featuresMu.Lock()
+	defer featuresMu.Unlock()
+	features[name] = true
+}
+
+// HasFeature reports whether an optional feature is compiled in
+func HasFeature(name string) bool {
+	featuresMu.Lock()
+	defer featuresMu.Unlock()
+	return features[name]
+}
+
+// Features returns the optional features compiled in, sorted
+func Features() []string {
+	featuresMu.Lock()
+	defer featuresMu.Unlock()
+	names := make([]string, 0, len(features))
+	for name := range features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BuildInfo describes an imgex build, as printed by 'imgex version --json'
+type BuildInfo struct {
+	// SchemaVersion is the version of this JSON document (see SchemaVersion)
+	SchemaVersion int `json:"schema_version"`
+
+	// Version is the imgex version
+	Version string `json:"version"`
+
+	// GoVersion is the Go toolchain the binary was built with
+	GoVersion string `json:"go_version"`
+
+	// OS and Arch are the platform the binary was built for
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+
+	// Features lists the optional features compiled in (see FeatureCloudAuth)
+	Features []string `json:"features"`
+}
+
+// GetBuildInfo returns the version, platform and compiled-in features of this build
+func GetBuildInfo() *BuildInfo {
+	return &BuildInfo{
+		SchemaVersion: SchemaVersion,
+		Version:       Version,
+		GoVersion:     runtime.Version(),
+		OS:            runtime.GOOS,
+		Arch:          runtime.GOARCH,
+		Features:      Features(),
+	}
+}
@@ -0,0 +1,23 @@
+package lib
+
+import (
+	"runtime"
+	"sort"
+	"testing"
+)
+
+func TestGetBuildInfo(t *testing.T) {
+	info := GetBuildInfo()
+	if info.Version != Version || info.GoVersion != runtime.Version() || info.OS != runtime.GOOS || info.Arch != runtime.GOARCH {
+		t.Errorf("Expected the running build, got %+v", info)
+	}
+	if info.Features == nil || !sort.StringsAreSorted(info.Features) {
+		t.Errorf("Expected a sorted feature list, got %v", info.Features)
+	}
+
+	// Cloud credentials are compiled in unless built with the nocloud tag
+	_, err := ParseAuthMode("ecr")
+	if HasFeature(FeatureCloudAuth) != (err == nil) {
+		t.Errorf("Expected ParseAuthMode(\"ecr\") to follow %s, got %v", FeatureCloudAuth, err)
+	}
+}
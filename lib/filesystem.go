@@ -2,8 +2,12 @@ package lib
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path"
@@ -11,10 +15,7 @@ import (
 	"strings"
 	"time"
 
-	"github.com/google/go-containerregistry/pkg/authn"
-	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1"
-	"github.com/google/go-containerregistry/pkg/v1/remote"
 )
 
 // ExportImageFilesystem exports the complete filesystem of a Docker image to a tar file.
@@ -39,20 +40,8 @@ import (
 //	    log.Fatal(err)
 //	}
 func (e *imageExporter) ExportImageFilesystem(imageRef string, outputPath string, auth *AuthConfig) error {
-	// Create the output file with proper permissions
-	file, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("failed to create output file %s: %w", outputPath, err)
-	}
-	defer func() {
-		// Ensure file is closed even if export fails
-		if closeErr := file.Close(); closeErr != nil && err == nil {
-			err = fmt.Errorf("failed to close output file: %w", closeErr)
-		}
-	}()
-
-	// Delegate to the writer-based implementation for consistency
-	return e.ExportImageFilesystemToWriter(imageRef, file, auth)
+	// Exports without options still honor the exporter's limits
+	return e.ExportImageFilesystemWithOptions(imageRef, outputPath, auth, nil)
 }
 
 // ExportImageFilesystemToWriter exports the complete filesystem of a Docker image to an io.Writer.
@@ -86,52 +75,7 @@ func (e *imageExporter) ExportImageFilesystem(imageRef string, outputPath string
 //	}
 //	// buf now contains the complete flattened filesystem as tar data
 func (e *imageExporter) ExportImageFilesystemToWriter(imageRef string, writer io.Writer, auth *AuthConfig) error {
-	// Parse and validate the image reference
-	ref, err := name.ParseReference(imageRef)
-	if err != nil {
-		return fmt.Errorf("failed to parse image reference %s: %w", imageRef, err)
-	}
-
-	// Configure authentication for registry access
-	var authOption remote.Option
-	if auth != nil {
-		// Use provided credentials for private registries
-		authOption = remote.WithAuth(&authn.Basic{
-			Username: auth.Username,
-			Password: auth.Password,
-		})
-	} else {
-		// Fall back to system keychain (Docker credentials, etc.)
-		authOption = remote.WithAuthFromKeychain(authn.DefaultKeychain)
-	}
-
-	// Fetch the complete image from the registry
-	// This downloads all layers and metadata needed for filesystem reconstruction
-	image, err := remote.Image(ref, authOption)
-	if err != nil {
-		return fmt.Errorf("failed to fetch image %s: %w", imageRef, err)
-	}
-
-	// Get the ordered list of layers from the image
-	layers, err := image.Layers()
-	if err != nil {
-		return fmt.Errorf("failed to get image layers: %w", err)
-	}
-
-	// Apply all layers to build the final filesystem state
-	// This creates a map representing the flattened filesystem
-	filesystem, err := e.applyLayers(layers)
-	if err != nil {
-		return fmt.Errorf("failed to apply layers: %w", err)
-	}
-
-	// Write the flattened filesystem as a tar archive
-	err = e.writeFilesystemTar(filesystem, writer)
-	if err != nil {
-		return fmt.Errorf("failed to write filesystem tar: %w", err)
-	}
-
-	return nil
+	return e.ExportImageFilesystemToWriterWithOptions(imageRef, writer, auth, nil)
 }
 
 // ExportImageFilesystemWithOptions exports the complete filesystem with additional options.
@@ -159,18 +103,52 @@ func (e *imageExporter) ExportImageFilesystemToWriterWithOptions(imageRef string
 	if opts == nil {
 		opts = &ExportOptions{}
 	}
+	start := time.Now()
+	var stats *ExportStats
+	if opts.Stats != nil {
+		stats = &ExportStats{SchemaVersion: SchemaVersion, Layers: []LayerStats{}}
+	}
+
+	// Stop writing once the export is canceled
+	ctx := exportContext(opts)
+	if opts.Context != nil {
+		writer = &contextWriter{ctx: ctx, Writer: writer}
+	}
+
+	// Watch for a destination that stops accepting data
+	var stall *stallWriter
+	if opts.WriteTimeout > 0 || opts.StallWarning > 0 {
+		stall = newStallWriter(writer, opts.WriteTimeout, opts.StallWarning, func(w Warning) { e.warn(opts, w) })
+		defer stall.stop()
+		writer = stall
+	}
+
+	// Count the bytes reaching the destination if requested
+	progress := newByteProgressTracker(opts.ByteProgress)
+	if progress != nil {
+		writer = &progressWriter{Writer: writer, onWrite: progress.wrote}
+	}
+
+	// Hash the bytes reaching the destination if requested
+	var digester hash.Hash
+	if opts.Digest != nil {
+		digester = sha256.New()
+		writer = io.MultiWriter(writer, digester)
+	}
+
+	// Stop before the destination receives more than the output limit
+	if limit := e.exportLimits(opts).MaxOutputSize; limit > 0 {
+		writer = &limitWriter{Writer: writer, limit: limit}
+	}
 
 	// Wrap writer with gzip compression if requested
 	var finalWriter io.Writer = writer
 	var gzipWriter *gzip.Writer
 	if opts.Compress {
+		// Closed only on success, so a failed export never ends in a valid gzip trailer
+		// and nothing is written to the destination before the archive starts
 		gzipWriter = gzip.NewWriter(writer)
 		finalWriter = gzipWriter
-		defer func() {
-			if closeErr := gzipWriter.Close(); closeErr != nil {
-				// Log compression close error but don't override main error
-			}
-		}()
 	}
 
 	// Call progress callback if provided
@@ -178,33 +156,59 @@ func (e *imageExporter) ExportImageFilesystemToWriterWithOptions(imageRef string
 		opts.Progress(0, 4, "Parsing image reference")
 	}
 
-	// Parse and validate the image reference
-	ref, err := name.ParseReference(imageRef)
-	if err != nil {
-		return fmt.Errorf("failed to parse image reference %s: %w", imageRef, err)
+	if _, err := tarFormat(opts); err != nil {
+		return err
+	}
+	if _, err := xattrFilter(opts); err != nil {
+		return err
+	}
+	if _, err := devicePolicy(opts); err != nil {
+		return err
+	}
+	if err := validateOwnership(opts); err != nil {
+		return err
+	}
+	if err := validateExcludes(opts.Exclude); err != nil {
+		return err
+	}
+	if opts.SkipFileSize < 0 {
+		return fmt.Errorf("invalid skip file size %d", opts.SkipFileSize)
 	}
 
-	// Configure authentication for registry access
-	var authOption remote.Option
-	if auth != nil {
-		// Use provided credentials for private registries
-		authOption = remote.WithAuth(&authn.Basic{
-			Username: auth.Username,
-			Password: auth.Password,
-		})
-	} else {
-		// Fall back to system keychain (Docker credentials, etc.)
-		authOption = remote.WithAuthFromKeychain(authn.DefaultKeychain)
+	// Validate the requested platform
+	var platform *v1.Platform
+	if opts.Platform != "" {
+		var err error
+		platform, err = v1.ParsePlatform(opts.Platform)
+		if err != nil {
+			return fmt.Errorf("invalid platform %q: %w", opts.Platform, err)
+		}
 	}
 
 	if opts.Progress != nil {
 		opts.Progress(1, 4, "Fetching image manifest")
 	}
 
-	// Fetch the complete image from the registry
-	image, err := remote.Image(ref, authOption)
+	// Only an image pinned by digest gives the same archive on every run
+	if opts.Reproducible {
+		if err := e.checkPinned(imageRef); err != nil {
+			return err
+		}
+	}
+
+	// Fetch the complete image from the registry (or a docker-archive: tarball),
+	// selecting the requested platform from multi-arch indexes
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("export of %s canceled: %w", imageRef, err)
+	}
+	image, err := e.openImage(imageRef, auth, platform)
 	if err != nil {
-		return fmt.Errorf("failed to fetch image %s: %w", imageRef, err)
+		return err
+	}
+
+	// Validate the image platform against the host before downloading layers
+	if err := e.applyPlatformPolicy(opts.PlatformPolicy, opts, image); err != nil {
+		return err
 	}
 
 	if opts.Progress != nil {
@@ -217,21 +221,57 @@ func (e *imageExporter) ExportImageFilesystemToWriterWithOptions(imageRef string
 		return fmt.Errorf("failed to get image layers: %w", err)
 	}
 
+	// Stage file contents on disk if requested
+	var staging *stagingArea
+	if opts.StagingDir != "" {
+		staging, err = newStagingArea(opts.StagingDir, opts.MaxStagingBytes)
+		if err != nil {
+			return err
+		}
+		defer staging.Close()
+	}
+
 	// Apply all layers to build the final filesystem state
-	filesystem, err := e.applyLayersWithProgress(layers, opts.Progress)
+	filesystem, err := e.applyLayersWithProgress(layers, newWindowsDetector(image), opts, staging, progress, stats)
 	if err != nil {
 		return fmt.Errorf("failed to apply layers: %w", err)
 	}
+	if len(opts.Exclude) > 0 {
+		e.excludePaths(filesystem, opts.Exclude)
+	}
+	if opts.SkipFileSize > 0 {
+		e.dropSkippedFiles(filesystem, opts)
+	}
+	e.finalizeFilesystem(filesystem, opts)
 
 	if opts.Progress != nil {
 		opts.Progress(3, 4, "Writing filesystem archive")
 	}
 
 	// Write the flattened filesystem as a tar archive
-	err = e.writeFilesystemTar(filesystem, finalWriter)
+	err = e.writeFilesystemTar(filesystem, finalWriter, opts)
 	if err != nil {
 		return fmt.Errorf("failed to write filesystem tar: %w", err)
 	}
+	if gzipWriter != nil {
+		if err := gzipWriter.Close(); err != nil {
+			return fmt.Errorf("failed to finish gzip stream: %w", err)
+		}
+	}
+	if stall != nil {
+		if err := stall.flush(); err != nil {
+			return fmt.Errorf("failed to write filesystem tar: %w", err)
+		}
+	}
+
+	if digester != nil {
+		opts.Digest("sha256:" + hex.EncodeToString(digester.Sum(nil)))
+	}
+	if stats != nil {
+		devices, _ := devicePolicy(opts)
+		countEntries(stats, filesystem, devices, start)
+		opts.Stats(stats)
+	}
 
 	if opts.Progress != nil {
 		opts.Progress(4, 4, "Export complete")
@@ -242,91 +282,391 @@ func (e *imageExporter) ExportImageFilesystemToWriterWithOptions(imageRef string
 
 // fileEntry represents a single file or directory in the flattened filesystem
 type fileEntry struct {
-	header *tar.Header // tar header with metadata (name, mode, size, etc.)
-	data   []byte      // file content data (empty for directories)
+	header *tar.Header  // tar header with metadata (name, mode, size, etc.)
+	data   []byte       // file content data (empty for directories or staged files)
+	staged *stagingArea // staging area holding the content, nil if kept in memory
+	offset int64        // offset of the content within the staging area
+
+	lazy func() (io.ReadCloser, error) // fetches the content on demand, nil if stored
+
+	inode   *fileEntry   // regular file a hardlink shares its content with, see linkInode
+	layer   int          // index of the layer that added the entry, -1 if synthesized
+	regions []dataRegion // data regions of a sparse file, the only content stored; nil if dense
+	skipped bool         // content left unread for being larger than ExportOptions.SkipFileSize
+}
+
+// content returns a reader over the file content, wherever it is stored
+func (f *fileEntry) content() io.Reader {
+	if f.regions != nil {
+		return &holeReader{data: f.stored(), regions: f.regions, size: f.header.Size}
+	}
+	return f.stored()
+}
+
+// stored returns a reader over the content as stored: the data regions only
+// for sparse files
+func (f *fileEntry) stored() io.Reader {
+	if f.lazy != nil {
+		return &lazyReader{open: f.lazy}
+	}
+	if f.staged != nil {
+		size := f.header.Size
+		if f.regions != nil {
+			size = 0
+			for _, region := range f.regions {
+				size += region.length
+			}
+		}
+		return f.staged.reader(f.offset, size)
+	}
+	return bytes.NewReader(f.data)
 }
 
 // applyLayersWithProgress processes all image layers in order and builds the final filesystem state.
 // It handles Docker layer application rules including whiteout files for deletions.
-// Provides progress callbacks during layer processing.
-func (e *imageExporter) applyLayersWithProgress(layers []v1.Layer, progress ProgressCallback) (map[string]*fileEntry, error) {
+// Provides progress callbacks during layer processing. When staging is non-nil, file
+// contents are spooled to disk until the staging limit is reached, after which they
+// are kept in memory and a warning is reported once. Downloaded bytes are reported
+// to progress when it is non-nil. Layers of Windows images are applied with
+// Windows path semantics (see applyLayer).
+func (e *imageExporter) applyLayersWithProgress(layers []v1.Layer, windows *windowsDetector, opts *ExportOptions, staging *stagingArea, progress *byteProgressTracker, stats *ExportStats) (map[string]*fileEntry, error) {
 	filesystem := make(map[string]*fileEntry)
+	paths := newPathTrie()
+	stagingFull := false
+	progress.setLayers(layers)
+	ctx := exportContext(opts)
 
 	for i, layer := range layers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
 		// Report progress for each layer
-		if progress != nil {
-			progress(i, len(layers), fmt.Sprintf("Processing layer %d/%d", i+1, len(layers)))
+		if opts.Progress != nil {
+			opts.Progress(i, len(layers), fmt.Sprintf("Processing layer %d/%d", i+1, len(layers)))
+		}
+
+		// Report downloaded bytes if requested
+		var onRead func(n int64)
+		var size int64
+		if opts.LayerProgress != nil || progress != nil {
+			size, _ = layer.Size()
+			var read int64
+			index := i
+			onRead = func(n int64) {
+				read += n
+				if opts.LayerProgress != nil {
+					opts.LayerProgress(index, len(layers), read, size)
+				}
+				progress.read(n)
+			}
+			if opts.LayerProgress != nil {
+				opts.LayerProgress(i, len(layers), 0, size)
+			}
+			progress.startLayer(i, size)
 		}
 
 		// Get the layer content as a tar stream
-		layerReader, err := layer.Uncompressed()
+		start := time.Now()
+		digest, _ := layer.Digest()
+		var layerStats *LayerStats
+		if stats != nil {
+			stats.Layers = append(stats.Layers, LayerStats{Index: i, Digest: digest.String()})
+			layerStats = &stats.Layers[i]
+		}
+		if e.foreignLayers == ForeignLayersSkip && isForeignLayer(layer) {
+			e.warn(opts, Warning{
+				Code:    WarningForeignLayerSkipped,
+				Message: fmt.Sprintf("skipped foreign layer %d (%s), the filesystem is incomplete", i, digest),
+				Layer:   digest.String(),
+			})
+			progress.finishLayer()
+			continue
+		}
+		e.log().Info("pulling layer", "layer", i+1, "layers", len(layers), "digest", digest.String())
+		layerReader, err := e.openLayer(layer, onRead)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get layer %d content: %w", i, err)
 		}
-		defer layerReader.Close()
+		if opts.Context != nil {
+			layerReader = &contextReader{ctx: ctx, ReadCloser: layerReader}
+		}
+		if limit := e.exportLimits(opts).MaxLayerSize; limit > 0 {
+			layerReader = &limitReader{ReadCloser: layerReader, index: i, limit: limit}
+		}
 
-		// Process the layer tar stream
-		tarReader := tar.NewReader(layerReader)
-		for {
-			header, err := tarReader.Next()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return nil, fmt.Errorf("failed to read layer %d tar: %w", i, err)
+		err = e.applyLayer(filesystem, paths, layerReader, i, windows, opts, layerStats, func(header *tar.Header, r io.Reader) (*fileEntry, error) {
+			entry := &fileEntry{header: header}
+			if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeGNUSparse {
+				return entry, nil
 			}
 
-			// Handle whiteout files (Docker layer deletion mechanism)
-			if e.isWhiteoutFile(header.Name) {
-				e.handleWhiteout(filesystem, header.Name)
-				continue
+			// Keep only the data of sparse files, which can be mostly holes. Their
+			// size bounds the data, which is only known once read.
+			sparse := isSparseHeader(header)
+			size := header.Size
+			if staging != nil && staging.fits(size) {
+				offset := staging.size
+				var err error
+				if sparse {
+					entry.regions, err = readDataRegions(r, size, staging)
+				} else {
+					_, err = staging.stage(r, size)
+				}
+				if err != nil {
+					return nil, err
+				}
+				entry.staged = staging
+				entry.offset = offset
+				return entry, nil
+			}
+			if staging != nil && !stagingFull {
+				// Degrade gracefully to memory once the temp budget is exhausted
+				stagingFull = true
+				e.warn(opts, Warning{
+					Code:    WarningStagingLimit,
+					Message: fmt.Sprintf("staging limit of %d bytes reached, keeping remaining files in memory", staging.limit),
+				})
 			}
 
-			// Read file data for regular files
-			var data []byte
-			if header.Typeflag == tar.TypeReg {
-				data = make([]byte, header.Size)
-				_, err = io.ReadFull(tarReader, data)
+			if sparse {
+				var data bytes.Buffer
+				regions, err := readDataRegions(r, size, &data)
 				if err != nil {
-					return nil, fmt.Errorf("failed to read file data: %w", err)
+					return nil, err
 				}
+				entry.regions, entry.data = regions, data.Bytes()
+				return entry, nil
 			}
-
-			// Clean the path and add to filesystem
-			cleanPath := e.cleanPath(header.Name)
-			filesystem[cleanPath] = &fileEntry{
-				header: header,
-				data:   data,
+			entry.data = make([]byte, size)
+			if _, err := io.ReadFull(r, entry.data); err != nil {
+				return nil, fmt.Errorf("failed to read file data: %w", err)
 			}
+			return entry, nil
+		})
+		// Encrypted layers are only authenticated once fully read, on Close
+		closeErr := layerReader.Close()
+		if err != nil {
+			return nil, err
+		}
+		if closeErr != nil {
+			return nil, fmt.Errorf("failed to read layer %d: %w", i, closeErr)
+		}
+		e.log().Info("layer applied", "layer", i+1, "layers", len(layers), "digest", digest.String(),
+			"filesystem_entries", len(filesystem), "duration", time.Since(start).Round(time.Millisecond))
+		if opts.LayerProgress != nil {
+			opts.LayerProgress(i, len(layers), size, size)
 		}
+		progress.finishLayer()
 	}
 
 	return filesystem, nil
 }
 
+// applyLayer reads a single uncompressed layer and applies its entries to the filesystem,
+// keeping the path index in sync. The caller closes the layer stream once it returns,
+// so only one layer is open at a time. In Windows images, entries follow the rules of
+// Windows layers: backslashes separate path components, and names are matched
+// ignoring case, so an entry replaces (or a whiteout deletes) a path written
+// with different case by an earlier layer.
+func (e *imageExporter) applyLayer(filesystem map[string]*fileEntry, paths *pathTrie, layerReader io.Reader, index int, windows *windowsDetector, opts *ExportOptions, stats *LayerStats, newEntry func(*tar.Header, io.Reader) (*fileEntry, error)) error {
+	// Process the layer tar stream
+	limits := e.exportLimits(opts)
+	tarReader := tar.NewReader(layerReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read layer %d tar: %w", index, err)
+		}
+
+		// Windows layers (Files/, Hives/ and UtilityVM/) may separate paths with
+		// backslashes, and their paths differing only in case are the same file
+		isWindows := windows.check(header.Name)
+		if isWindows {
+			header.Name = strings.ReplaceAll(header.Name, `\`, "/")
+			header.Linkname = strings.ReplaceAll(header.Linkname, `\`, "/")
+		}
+
+		// Entries never leave the root of the filesystem
+		if err := e.checkEntryPath(header, index, opts); err != nil {
+			return err
+		}
+
+		// Clean the path, following symlinked parents like a running container would
+		cleanPath := e.cleanPath(header.Name)
+		if !opts.LiteralPaths {
+			cleanPath = resolveParentPath(filesystem, cleanPath)
+			if header.Typeflag == tar.TypeLink {
+				header.Linkname = resolveParentPath(filesystem, e.cleanPath(header.Linkname))
+			}
+		}
+		if isWindows {
+			cleanPath = paths.canonical(cleanPath)
+			if header.Typeflag == tar.TypeLink {
+				header.Linkname = paths.canonical(e.cleanPath(header.Linkname))
+			}
+		}
+
+		// Handle whiteout files (Docker layer deletion mechanism)
+		if e.isWhiteoutFile(cleanPath) {
+			e.handleWhiteout(filesystem, paths, cleanPath, index, opts.CaseInsensitiveWhiteouts || isWindows)
+			if stats != nil {
+				stats.Whiteouts++
+			}
+			continue
+		}
+		// Paths below a whiteout name, such as the hardlink directory of aufs
+		// (.wh..wh.plnk), are metadata of the layer and never part of the filesystem
+		if hasWhiteoutComponent(cleanPath) {
+			e.log().Debug("skipping whiteout metadata", "layer", index, "path", cleanPath)
+			continue
+		}
+
+		// Fail before reading a file larger than the limit
+		if limit := limits.MaxFileSize; limit > 0 && header.Size > limit {
+			return fmt.Errorf("%s in layer %d is larger than %d bytes: %w", cleanPath, index, limit, ErrLimitExceeded)
+		}
+
+		// Read or stage file data for regular files. Files above the skip size
+		// still replace their path, and are dropped once every layer is applied.
+		var entry *fileEntry
+		if skip := opts.SkipFileSize; skip > 0 && header.Size > skip &&
+			(header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeGNUSparse) {
+			entry = &fileEntry{header: header, skipped: true}
+		} else if entry, err = newEntry(header, tarReader); err != nil {
+			return err
+		}
+		entry.layer = index
+		if isSparseHeader(header) {
+			dropSparseRecords(header)
+		}
+
+		if header.Typeflag == tar.TypeLink {
+			entry.inode = linkInode(filesystem, cleanPath, header)
+		}
+
+		if !opts.LiteralPaths {
+			// An entry replaces whatever was at its path, e.g. a directory replacing a symlink
+			header.Name = cleanPath
+			alternate := cleanPath + "/"
+			if strings.HasSuffix(cleanPath, "/") {
+				alternate = strings.TrimSuffix(cleanPath, "/")
+			}
+			// Like a runtime extracting the layer, anything but a directory
+			// removes a directory it replaces together with its contents
+			if _, ok := filesystem[alternate]; ok && header.Typeflag != tar.TypeDir {
+				paths.deleteChildren(filesystem, cleanPath, index+1, isWindows)
+			}
+			delete(filesystem, alternate)
+			paths.remove(alternate)
+		}
+		filesystem[cleanPath] = entry
+		paths.insert(cleanPath)
+		if limit := limits.MaxFiles; limit > 0 && len(filesystem) > limit {
+			return fmt.Errorf("layer %d takes the filesystem beyond %d files: %w", index, limit, ErrLimitExceeded)
+		}
+	}
+
+	return nil
+}
+
 // applyLayers processes all image layers in order and builds the final filesystem state.
 // It handles Docker layer application rules including whiteout files for deletions.
-func (e *imageExporter) applyLayers(layers []v1.Layer) (map[string]*fileEntry, error) {
-	return e.applyLayersWithProgress(layers, nil)
+func (e *imageExporter) applyLayers(layers []v1.Layer, windows *windowsDetector) (map[string]*fileEntry, error) {
+	return e.applyLayersWithProgress(layers, windows, &ExportOptions{}, nil, nil, nil)
 }
 
 // writeFilesystemTar writes the flattened filesystem map as a tar archive.
 // Entries are sorted to ensure proper extraction order: directories first, then files, then links.
-func (e *imageExporter) writeFilesystemTar(filesystem map[string]*fileEntry, writer io.Writer) error {
+// Entries are written in opts.TarFormat with opts.SourceDateEpoch as their modification
+// time; nil opts write PAX archives dated at the Unix epoch.
+func (e *imageExporter) writeFilesystemTar(filesystem map[string]*fileEntry, writer io.Writer, opts *ExportOptions) error {
+	format, err := tarFormat(opts)
+	if err != nil {
+		return err
+	}
+	modTime := exportModTime(opts)
+	keepXattr, err := xattrFilter(opts)
+	if err != nil {
+		return err
+	}
+	devices, err := devicePolicy(opts)
+	if err != nil {
+		return err
+	}
 	tarWriter := tar.NewWriter(writer)
 	defer tarWriter.Close()
 
 	// Create sorted list of entries for proper extraction order
 	sortedEntries := e.sortTarEntries(filesystem)
+	start := time.Now()
+	var written int64
+	var skipped, converted, unmapped int
+	e.log().Info("writing filesystem tar", "entries", len(sortedEntries))
 
 	// Write each file/directory in the correct order
 	for _, entry := range sortedEntries {
+		if isSpecialFile(entry.header.Typeflag) {
+			switch devices {
+			case DevicesSkip:
+				skipped++
+				continue
+			case DevicesConvert:
+				entry.header.Typeflag = tar.TypeReg
+				entry.header.Mode &= 07777
+				entry.header.Size = 0
+				entry.header.Devmajor, entry.header.Devminor = 0, 0
+				converted++
+			}
+		}
+
 		// Update header timestamps for consistency and format compatibility
-		entry.header.ModTime = time.Unix(0, 0)
-		// Clear unsupported fields for USTAR format
+		entry.header.ModTime = modTime
+		// Access and change times would only add PAX records
 		entry.header.AccessTime = time.Time{}
 		entry.header.ChangeTime = time.Time{}
 
+		filterXattrs(entry.header, keepXattr)
+		if opts != nil && opts.Reproducible {
+			dropHostRecords(entry.header)
+		}
+		if opts != nil && remapOwner(entry.header, opts) {
+			unmapped++
+		}
+
+		// Write every entry in the requested format rather than the one of its layer
+		entry.header.Format = format
+		if format != tar.FormatPAX {
+			if hasExtendedRecords(entry.header) {
+				message := fmt.Sprintf("extended header records of %s cannot be written in the %s format", entry.header.Name, format)
+				if _, ok := entry.header.PAXRecords[xattrCapability]; ok {
+					message += "; the file loses its capabilities"
+				}
+				e.warn(opts, Warning{
+					Code:    WarningRecordsDropped,
+					Message: message,
+					Path:    entry.header.Name,
+				})
+			}
+			entry.header.PAXRecords = nil
+			entry.header.Xattrs = nil
+		}
+
+		// Sparse files keep their holes in PAX archives only
+		if entry.regions != nil && format == tar.FormatPAX {
+			if err := tarWriter.Flush(); err != nil {
+				return fmt.Errorf("failed to write data before %s: %w", entry.header.Name, err)
+			}
+			if err := writeSparseEntry(writer, entry.header, entry.regions, entry.stored()); err != nil {
+				return fmt.Errorf("failed to write sparse file %s: %w", entry.header.Name, err)
+			}
+			written += entry.header.Size
+			continue
+		}
+
 		// Write the header
 		err := tarWriter.WriteHeader(entry.header)
 		if err != nil {
@@ -334,14 +674,26 @@ func (e *imageExporter) writeFilesystemTar(filesystem map[string]*fileEntry, wri
 		}
 
 		// Write file data for regular files
-		if entry.header.Typeflag == tar.TypeReg && len(entry.data) > 0 {
-			_, err = tarWriter.Write(entry.data)
+		if entry.header.Typeflag == tar.TypeReg && entry.header.Size > 0 {
+			_, err = io.Copy(tarWriter, entry.content())
 			if err != nil {
 				return fmt.Errorf("failed to write data for %s: %w", entry.header.Name, err)
 			}
+			written += entry.header.Size
 		}
 	}
 
+	if unmapped > 0 {
+		e.warn(opts, Warning{
+			Code:    WarningUnmappedID,
+			Message: fmt.Sprintf("%d entries are owned by ids outside the uid or gid mappings and were given to %d", unmapped, OverflowID),
+		})
+	}
+	if skipped > 0 || converted > 0 {
+		e.log().Info("special files handled", "policy", string(devices), "skipped", skipped, "converted", converted)
+	}
+	e.log().Info("filesystem tar written", "entries", len(sortedEntries)-skipped, "content_bytes", written,
+		"duration", time.Since(start).Round(time.Millisecond))
 	return nil
 }
 
@@ -394,6 +746,8 @@ func (e *imageExporter) getTypePriority(typeflag byte) int {
 		return 1 // Directories first
 	case tar.TypeReg:
 		return 2 // Regular files second
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		return 2 // Device nodes and FIFOs with regular files, see ExportOptions.Devices
 	case tar.TypeSymlink, tar.TypeLink:
 		return 3 // Links last (after their targets exist)
 	default:
@@ -401,49 +755,206 @@ func (e *imageExporter) getTypePriority(typeflag byte) int {
 	}
 }
 
+// tarFormat returns the tar format selected by opts
+func tarFormat(opts *ExportOptions) (tar.Format, error) {
+	if opts == nil {
+		return tar.FormatPAX, nil
+	}
+	switch opts.TarFormat {
+	case TarFormatPAX:
+		return tar.FormatPAX, nil
+	case TarFormatGNU:
+		return tar.FormatGNU, nil
+	case TarFormatUSTAR:
+		return tar.FormatUSTAR, nil
+	default:
+		return tar.FormatUnknown, fmt.Errorf("invalid tar format %q (must be pax, gnu or ustar)", opts.TarFormat)
+	}
+}
+
+// devicePolicy returns the device policy selected by opts
+func devicePolicy(opts *ExportOptions) (DevicePolicy, error) {
+	if opts == nil {
+		return DevicesPreserve, nil
+	}
+	switch opts.Devices {
+	case DevicesPreserve, DevicesSkip, DevicesConvert:
+		return opts.Devices, nil
+	default:
+		return "", fmt.Errorf("invalid device policy %q (must be preserve, skip or convert)", opts.Devices)
+	}
+}
+
+// isSpecialFile reports whether a tar entry type is a device node or a FIFO
+func isSpecialFile(typeflag byte) bool {
+	return typeflag == tar.TypeChar || typeflag == tar.TypeBlock || typeflag == tar.TypeFifo
+}
+
+// xattrPAXPrefixes are the prefixes of the PAX records holding extended
+// attributes, written by GNU tar and by libarchive
+var xattrPAXPrefixes = []string{"SCHILY.xattr.", "LIBARCHIVE.xattr."}
+
+// xattrFilter returns whether an extended attribute, by name, is copied under
+// the policy selected by opts
+func xattrFilter(opts *ExportOptions) (func(name string) bool, error) {
+	if opts == nil {
+		return func(string) bool { return true }, nil
+	}
+	switch opts.Xattrs {
+	case XattrsAll:
+		return func(string) bool { return true }, nil
+	case XattrsSecurity:
+		return func(name string) bool { return strings.HasPrefix(name, "security.") }, nil
+	case XattrsNone:
+		return func(string) bool { return false }, nil
+	default:
+		return nil, fmt.Errorf("invalid xattr policy %q (must be all, security or none)", opts.Xattrs)
+	}
+}
+
+// filterXattrs removes the extended attributes of header that keep rejects
+func filterXattrs(header *tar.Header, keep func(name string) bool) {
+	for key := range header.PAXRecords {
+		for _, prefix := range xattrPAXPrefixes {
+			if strings.HasPrefix(key, prefix) && !keep(strings.TrimPrefix(key, prefix)) {
+				delete(header.PAXRecords, key)
+			}
+		}
+	}
+	for name := range header.Xattrs {
+		if !keep(name) {
+			delete(header.Xattrs, name)
+		}
+	}
+}
+
+// paxHeaderKeys are the PAX records standing for fields of tar.Header, which
+// the tar writer derives from the header rather than copying them
+var paxHeaderKeys = map[string]bool{
+	"path": true, "linkpath": true, "size": true, "uid": true, "gid": true,
+	"uname": true, "gname": true, "mtime": true, "atime": true, "ctime": true,
+}
+
+// hasExtendedRecords reports whether a header carries PAX records beyond its
+// own fields, such as extended attributes, which only PAX archives can hold
+func hasExtendedRecords(header *tar.Header) bool {
+	if len(header.Xattrs) > 0 {
+		return true
+	}
+	for key := range header.PAXRecords {
+		if !paxHeaderKeys[key] {
+			return true
+		}
+	}
+	return false
+}
+
 // isWhiteoutFile checks if a file is a Docker whiteout file used for deletions
 func (e *imageExporter) isWhiteoutFile(filename string) bool {
 	base := path.Base(filename)
 	return strings.HasPrefix(base, ".wh.")
 }
 
-// handleWhiteout processes a whiteout file by removing the target from the filesystem
-func (e *imageExporter) handleWhiteout(filesystem map[string]*fileEntry, whiteoutPath string) {
+// hasWhiteoutComponent reports whether a directory of a path is named like a whiteout
+func hasWhiteoutComponent(p string) bool {
+	for _, part := range splitPath(path.Dir(strings.TrimSuffix(p, "/"))) {
+		if strings.HasPrefix(part, ".wh.") {
+			return true
+		}
+	}
+	return false
+}
+
+// handleWhiteout processes a whiteout file of the given layer by removing the target
+// from the filesystem. As in the OCI image spec, whiteouts only hide entries of
+// lower layers, never ones the same layer adds, and whiteouts of missing paths are
+// ignored. The path index makes each removal proportional to the size of the
+// removed subtree. With foldCase, targets are matched case-insensitively, as on
+// the filesystem the image was built on.
+func (e *imageExporter) handleWhiteout(filesystem map[string]*fileEntry, paths *pathTrie, whiteoutPath string, layer int, foldCase bool) {
 	dir := path.Dir(whiteoutPath)
 	base := path.Base(whiteoutPath)
 
 	if base == ".wh..wh..opq" {
 		// Opaque whiteout - remove all files in this directory
-		prefix := dir + "/"
-		if dir == "." {
-			prefix = ""
-		}
-
-		for filePath := range filesystem {
-			if strings.HasPrefix(filePath, prefix) {
-				delete(filesystem, filePath)
-			}
-		}
+		paths.deleteChildren(filesystem, dir, layer, foldCase)
 	} else if strings.HasPrefix(base, ".wh.") {
-		// Regular whiteout - remove the specific file/directory
+		// Regular whiteout - remove the specific file/directory and any files under it
 		target := path.Join(dir, strings.TrimPrefix(base, ".wh."))
-		target = e.cleanPath(target)
-
-		// Remove the target file and any files under it (if it's a directory)
-		delete(filesystem, target)
-		prefix := target + "/"
-		for filePath := range filesystem {
-			if strings.HasPrefix(filePath, prefix) {
-				delete(filesystem, filePath)
+		paths.deleteSubtree(filesystem, e.cleanPath(target), layer, foldCase)
+	}
+}
+
+// resolveParentPath rewrites a layer path so that symlinked parent directories
+// already in the filesystem are followed, e.g. "usr/bin/foo" becomes
+// "usr/local/bin/foo" when "usr/bin" links to "local/bin". The final path
+// component is never followed, and a trailing slash is preserved.
+func resolveParentPath(filesystem map[string]*fileEntry, p string) string {
+	trimmed := strings.TrimSuffix(p, "/")
+	dir, base := path.Split(trimmed)
+	if dir == "" {
+		return p
+	}
+
+	resolved, ok := resolveDirPath(filesystem, dir, 0)
+	if !ok {
+		return p
+	}
+	result := path.Join(resolved, base)
+	if strings.HasSuffix(p, "/") {
+		result += "/"
+	}
+	return result
+}
+
+// resolveDirPath follows every symlink along a directory path. Like the
+// kernel inside a container, ".." never climbs above the root. It fails on
+// symlink loops deeper than maxSymlinkDepth.
+func resolveDirPath(filesystem map[string]*fileEntry, p string, depth int) (string, bool) {
+	if depth > maxSymlinkDepth {
+		return "", false
+	}
+
+	current := ""
+	for _, part := range strings.Split(p, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if current = path.Dir(current); current == "." {
+				current = ""
 			}
+			continue
 		}
+
+		next := path.Join(current, part)
+		entry, ok := lookupEntry(filesystem, next)
+		if !ok || entry.header.Typeflag != tar.TypeSymlink {
+			current = next
+			continue
+		}
+
+		target := entry.header.Linkname
+		if !strings.HasPrefix(target, "/") {
+			target = current + "/" + target
+		}
+		resolved, ok := resolveDirPath(filesystem, target, depth+1)
+		if !ok {
+			return "", false
+		}
+		current = resolved
 	}
+
+	return current, true
 }
 
 // cleanPath normalizes a file path for consistent handling
 func (e *imageExporter) cleanPath(filePath string) string {
-	// Remove leading slash to make paths relative
-	cleaned := strings.TrimPrefix(filePath, "/")
+	// Remove leading slashes and "./" to make paths relative
+	cleaned := filePath
+	for strings.HasPrefix(cleaned, "/") || strings.HasPrefix(cleaned, "./") {
+		cleaned = strings.TrimPrefix(strings.TrimPrefix(cleaned, "/"), "./")
+	}
 
 	// Handle root directory case
 	if cleaned == "" {
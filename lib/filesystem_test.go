@@ -1,12 +1,17 @@
 package lib
 
 import (
+	"archive/tar"
 	"bytes"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
 )
 
 func TestExportImageFilesystemToWriter(t *testing.T) {
@@ -99,3 +104,445 @@ func TestExportImageFilesystemToWriter_InvalidImage(t *testing.T) {
 		t.Errorf("Expected parse or fetch error, got %v", err)
 	}
 }
+
+func TestExportResolvesSymlinkedParents(t *testing.T) {
+	base := newTestLayer(t,
+		testEntry{name: "usr/", typeflag: tar.TypeDir},
+		testEntry{name: "usr/local/", typeflag: tar.TypeDir},
+		testEntry{name: "usr/local/bin/", typeflag: tar.TypeDir},
+		testEntry{name: "usr/bin", typeflag: tar.TypeSymlink, linkname: "local/bin"},
+		testEntry{name: "lib", typeflag: tar.TypeSymlink, linkname: "/usr/../../usr/lib"},
+		testEntry{name: "loop", typeflag: tar.TypeSymlink, linkname: "loop"},
+	)
+	top := newTestLayer(t,
+		testEntry{name: "usr/bin/foo", content: "foo"},
+		testEntry{name: "./lib/libc.so", content: "libc"},
+		testEntry{name: "loop/x", content: "loop"},
+	)
+	image, err := mutate.AppendLayers(empty.Image, base, top)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+
+	host := newTestRegistry(t)
+	imageRef := host + "/test/symlinks:latest"
+	pushTestImage(t, imageRef, image)
+
+	exporter := NewImageExporter()
+	var buf bytes.Buffer
+	if err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &buf, nil, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	files := readTestTar(t, buf.Bytes())
+	if files["usr/local/bin/foo"] != "foo" {
+		t.Errorf("Expected usr/bin/foo to land in usr/local/bin, got %v", files)
+	}
+	if _, ok := files["usr/bin/foo"]; ok {
+		t.Errorf("Expected no usr/bin/foo entry, got %v", files)
+	}
+	if files["usr/lib/libc.so"] != "libc" {
+		t.Errorf("Expected lib/libc.so to land in usr/lib (.. stops at the root), got %v", files)
+	}
+	if files["loop/x"] != "loop" {
+		t.Errorf("Expected a symlink loop to keep the literal path, got %v", files)
+	}
+
+	// The opt-out keeps the paths written in each layer
+	buf.Reset()
+	if err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &buf, nil, &ExportOptions{LiteralPaths: true}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	files = readTestTar(t, buf.Bytes())
+	if files["usr/bin/foo"] != "foo" {
+		t.Errorf("Expected literal usr/bin/foo, got %v", files)
+	}
+	if _, ok := files["usr/local/bin/foo"]; ok {
+		t.Errorf("Expected no usr/local/bin/foo with LiteralPaths, got %v", files)
+	}
+}
+
+func TestExportReplacedDirectories(t *testing.T) {
+	base := newTestLayer(t,
+		testEntry{name: "opt/", typeflag: tar.TypeDir},
+		testEntry{name: "opt/app/", typeflag: tar.TypeDir},
+		testEntry{name: "opt/app/bin", content: "bin"},
+		testEntry{name: "lib/", typeflag: tar.TypeDir},
+		testEntry{name: "lib/a.so", content: "a"},
+		testEntry{name: "usr/lib/", typeflag: tar.TypeDir},
+	)
+	top := newTestLayer(t,
+		testEntry{name: "opt/app", content: "script"},
+		testEntry{name: "lib", typeflag: tar.TypeSymlink, linkname: "usr/lib"},
+		testEntry{name: "lib/b.so", content: "b"},
+	)
+	image, err := mutate.AppendLayers(empty.Image, base, top)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/replaced:latest"
+	pushTestImage(t, imageRef, image)
+
+	var buf bytes.Buffer
+	if err := NewImageExporter().ExportImageFilesystemToWriterWithOptions(imageRef, &buf, nil, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	files := readTestTar(t, buf.Bytes())
+	if files["opt/app"] != "script" {
+		t.Errorf("Expected opt/app to become a file, got %v", files)
+	}
+	for _, removed := range []string{"opt/app/bin", "lib/a.so", "lib/"} {
+		if _, ok := files[removed]; ok {
+			t.Errorf("Expected %s to be removed with the directory it was in, got %v", removed, files)
+		}
+	}
+	if files["usr/lib/b.so"] != "b" {
+		t.Errorf("Expected lib/b.so to land in usr/lib through the new symlink, got %v", files)
+	}
+}
+
+func TestExportCaseInsensitiveWhiteouts(t *testing.T) {
+	base := newTestLayer(t,
+		testEntry{name: "README", content: "readme"},
+		testEntry{name: "Docs/", typeflag: tar.TypeDir},
+		testEntry{name: "Docs/guide.txt", content: "guide"},
+		testEntry{name: "keep.txt", content: "keep"},
+	)
+	top := newTestLayer(t,
+		testEntry{name: ".wh.readme"},
+		testEntry{name: ".wh.docs"},
+	)
+	image, err := mutate.AppendLayers(empty.Image, base, top)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+
+	host := newTestRegistry(t)
+	imageRef := host + "/test/whiteouts:latest"
+	pushTestImage(t, imageRef, image)
+
+	exporter := NewImageExporter()
+	var buf bytes.Buffer
+	if err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &buf, nil, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	files := readTestTar(t, buf.Bytes())
+	if _, ok := files["README"]; !ok {
+		t.Errorf("Expected case-sensitive whiteouts to keep README, got %v", files)
+	}
+
+	buf.Reset()
+	opts := &ExportOptions{CaseInsensitiveWhiteouts: true}
+	if err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &buf, nil, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	files = readTestTar(t, buf.Bytes())
+	for _, removed := range []string{"README", "Docs/", "Docs/guide.txt"} {
+		if _, ok := files[removed]; ok {
+			t.Errorf("Expected %s to be whited out, got %v", removed, files)
+		}
+	}
+	if files["keep.txt"] != "keep" {
+		t.Errorf("Expected keep.txt to survive, got %v", files)
+	}
+}
+
+func TestExportLayerProgress(t *testing.T) {
+	layers := []v1.Layer{
+		newTestLayer(t, testEntry{name: "small", content: "x"}),
+		newTestLayer(t, testEntry{name: "large", content: strings.Repeat("imgex ", 50000)}),
+	}
+	image, err := mutate.AppendLayers(empty.Image, layers...)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/progress:latest"
+	pushTestImage(t, imageRef, image)
+
+	type call struct {
+		layer, layers int
+		read, size    int64
+	}
+	var calls []call
+	opts := &ExportOptions{LayerProgress: func(layer, layers int, read, size int64) {
+		calls = append(calls, call{layer, layers, read, size})
+	}}
+	if err := NewImageExporter().ExportImageFilesystemToWriterWithOptions(imageRef, io.Discard, nil, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for i, layer := range layers {
+		size, err := layer.Size()
+		if err != nil {
+			t.Fatalf("Failed to get layer size: %v", err)
+		}
+		var seen []call
+		for _, c := range calls {
+			if c.layer == i {
+				seen = append(seen, c)
+			}
+		}
+		if len(seen) < 2 || seen[0].read != 0 || seen[len(seen)-1].read != size {
+			t.Fatalf("Expected layer %d progress from 0 to %d, got %v", i, size, seen)
+		}
+		for j, c := range seen {
+			if c.layers != 2 || c.size != size || (j > 0 && c.read < seen[j-1].read) || c.read > size {
+				t.Errorf("Expected increasing progress within %d bytes for layer %d, got %v", size, i, seen)
+				break
+			}
+		}
+	}
+}
+
+func TestExportByteProgress(t *testing.T) {
+	layers := []v1.Layer{
+		newTestLayer(t, testEntry{name: "small", content: "x"}),
+		newTestLayer(t, testEntry{name: "large", content: strings.Repeat("imgex ", 50000)}),
+	}
+	image, err := mutate.AppendLayers(empty.Image, layers...)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/bytes:latest"
+	pushTestImage(t, imageRef, image)
+
+	var total int64
+	for _, layer := range layers {
+		size, err := layer.Size()
+		if err != nil {
+			t.Fatalf("Failed to get layer size: %v", err)
+		}
+		total += size
+	}
+
+	for _, compress := range []bool{false, true} {
+		var updates []ByteProgress
+		var output bytes.Buffer
+		opts := &ExportOptions{Compress: compress, ByteProgress: func(progress ByteProgress) {
+			updates = append(updates, progress)
+		}}
+		if err := NewImageExporter().ExportImageFilesystemToWriterWithOptions(imageRef, &output, nil, opts); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(updates) == 0 {
+			t.Fatal("Expected byte progress updates")
+		}
+
+		for i, u := range updates {
+			if u.Layers != 2 || u.DownloadTotal != total {
+				t.Fatalf("Expected 2 layers totalling %d bytes, got %+v", total, u)
+			}
+			if i > 0 && (u.Downloaded < updates[i-1].Downloaded || u.Written < updates[i-1].Written) {
+				t.Fatalf("Expected non-decreasing byte counts, got %+v after %+v", u, updates[i-1])
+			}
+		}
+		last := updates[len(updates)-1]
+		if last.Downloaded != total {
+			t.Errorf("Expected %d bytes downloaded, got %d", total, last.Downloaded)
+		}
+		if last.Written != int64(output.Len()) {
+			t.Errorf("Expected %d bytes written (compress=%v), got %d", output.Len(), compress, last.Written)
+		}
+	}
+}
+
+func TestExportDigest(t *testing.T) {
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "file", content: "data"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/digest:latest"
+	pushTestImage(t, imageRef, image)
+
+	for _, compress := range []bool{false, true} {
+		var output bytes.Buffer
+		var digest string
+		opts := &ExportOptions{Compress: compress, Digest: func(d string) { digest = d }}
+		if err := NewImageExporter().ExportImageFilesystemToWriterWithOptions(imageRef, &output, nil, opts); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		want, _, err := v1.SHA256(&output)
+		if err != nil {
+			t.Fatalf("Failed to hash output: %v", err)
+		}
+		if digest != want.String() {
+			t.Errorf("Expected digest %s (compress=%v), got %q", want, compress, digest)
+		}
+	}
+}
+
+func TestExportTarFormat(t *testing.T) {
+	longPath := "opt/" + strings.Repeat("deep/", 60) + "file"
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t,
+		testEntry{name: longPath, content: "data", uid: 3000000},
+		testEntry{name: "bin/ping", content: "elf", mode: 0755, pax: map[string]string{"SCHILY.xattr.user.test": "x"}},
+	))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/format:latest"
+	pushTestImage(t, imageRef, image)
+
+	export := func(format TarFormat) (map[string]*tar.Header, []Warning, error) {
+		var output bytes.Buffer
+		var warnings []Warning
+		opts := &ExportOptions{TarFormat: format, Warning: func(w Warning) { warnings = append(warnings, w) }}
+		if err := NewImageExporter().ExportImageFilesystemToWriterWithOptions(imageRef, &output, nil, opts); err != nil {
+			return nil, warnings, err
+		}
+		headers := make(map[string]*tar.Header)
+		reader := tar.NewReader(&output)
+		for {
+			header, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Failed to read %q archive: %v", format, err)
+			}
+			headers[header.Name] = header
+		}
+		return headers, warnings, nil
+	}
+
+	for _, format := range []TarFormat{TarFormatPAX, TarFormatGNU} {
+		headers, warnings, err := export(format)
+		if err != nil {
+			t.Fatalf("Expected no error for %q, got %v", format, err)
+		}
+		if header, ok := headers[longPath]; !ok || header.Uid != 3000000 {
+			t.Errorf("Expected %q to keep the long path and uid 3000000, got %+v", format, header)
+		}
+		xattr := headers["bin/ping"].PAXRecords["SCHILY.xattr.user.test"]
+		dropped := warningCodes(warnings)[WarningRecordsDropped]
+		if format == TarFormatPAX && (xattr != "x" || dropped != 0) {
+			t.Errorf("Expected PAX to keep the extended attribute, got %q and %d warnings", xattr, dropped)
+		}
+		if format == TarFormatGNU && (xattr != "" || dropped != 1) {
+			t.Errorf("Expected GNU to drop the extended attribute with a warning, got %q and %d warnings", xattr, dropped)
+		}
+	}
+
+	if _, _, err := export(TarFormatUSTAR); err == nil || !strings.Contains(err.Error(), longPath[:20]) {
+		t.Errorf("Expected USTAR to fail on the long path, got %v", err)
+	}
+	if _, _, err := export("cpio"); err == nil {
+		t.Error("Expected an error for an unknown format, got nil")
+	}
+}
+
+func TestExportXattrs(t *testing.T) {
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t,
+		testEntry{name: "bin/ping", content: "elf", mode: 0755, pax: map[string]string{
+			"SCHILY.xattr.security.capability": "\x01\x00\x00\x02",
+			"SCHILY.xattr.user.origin":         "build",
+		}},
+	))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/xattrs:latest"
+	pushTestImage(t, imageRef, image)
+
+	tests := []struct {
+		policy     XattrPolicy
+		capability bool
+		user       bool
+	}{
+		{XattrsAll, true, true},
+		{XattrsSecurity, true, false},
+		{XattrsNone, false, false},
+	}
+	for _, tt := range tests {
+		var output bytes.Buffer
+		opts := &ExportOptions{Xattrs: tt.policy}
+		if err := NewImageExporter().ExportImageFilesystemToWriterWithOptions(imageRef, &output, nil, opts); err != nil {
+			t.Fatalf("Expected no error for %q, got %v", tt.policy, err)
+		}
+		reader := tar.NewReader(&output)
+		header, err := reader.Next()
+		for err == nil && header.Name != "bin/ping" {
+			header, err = reader.Next()
+		}
+		if err != nil {
+			t.Fatalf("Expected bin/ping in the %q archive, got %v", tt.policy, err)
+		}
+		_, capability := header.PAXRecords["SCHILY.xattr.security.capability"]
+		_, user := header.PAXRecords["SCHILY.xattr.user.origin"]
+		if capability != tt.capability || user != tt.user {
+			t.Errorf("Expected %q to keep capability %v and user.origin %v, got %v and %v", tt.policy, tt.capability, tt.user, capability, user)
+		}
+	}
+
+	if err := NewImageExporter().ExportImageFilesystemToWriterWithOptions(imageRef, io.Discard, nil, &ExportOptions{Xattrs: "some"}); err == nil {
+		t.Error("Expected an error for an unknown policy, got nil")
+	}
+}
+
+func TestExportDevices(t *testing.T) {
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t,
+		testEntry{name: "dev/", typeflag: tar.TypeDir},
+		testEntry{name: "dev/null", typeflag: tar.TypeChar, mode: 0666},
+		testEntry{name: "dev/loop0", typeflag: tar.TypeBlock, mode: 0660, gid: 6},
+		testEntry{name: "run/initctl", typeflag: tar.TypeFifo, mode: 0600},
+		testEntry{name: "etc/hostname", content: "app"},
+	))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/devices:latest"
+	pushTestImage(t, imageRef, image)
+
+	export := func(policy DevicePolicy) map[string]*tar.Header {
+		var output bytes.Buffer
+		if err := NewImageExporter().ExportImageFilesystemToWriterWithOptions(imageRef, &output, nil, &ExportOptions{Devices: policy}); err != nil {
+			t.Fatalf("Expected no error for %q, got %v", policy, err)
+		}
+		headers := make(map[string]*tar.Header)
+		reader := tar.NewReader(&output)
+		for {
+			header, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Failed to read %q archive: %v", policy, err)
+			}
+			headers[header.Name] = header
+		}
+		return headers
+	}
+
+	headers := export(DevicesPreserve)
+	if headers["dev/null"].Typeflag != tar.TypeChar || headers["dev/loop0"].Typeflag != tar.TypeBlock || headers["run/initctl"].Typeflag != tar.TypeFifo {
+		t.Errorf("Expected devices and FIFOs to be preserved, got %+v", headers)
+	}
+
+	headers = export(DevicesSkip)
+	for _, name := range []string{"dev/null", "dev/loop0", "run/initctl"} {
+		if _, ok := headers[name]; ok {
+			t.Errorf("Expected %s to be skipped", name)
+		}
+	}
+	if _, ok := headers["etc/hostname"]; !ok {
+		t.Error("Expected regular files to be kept")
+	}
+
+	headers = export(DevicesConvert)
+	if header := headers["dev/loop0"]; header == nil || header.Typeflag != tar.TypeReg || header.Size != 0 || header.Mode != 0660 || header.Gid != 6 {
+		t.Errorf("Expected dev/loop0 as an empty file keeping its mode and group, got %+v", header)
+	}
+	if header := headers["run/initctl"]; header == nil || header.Typeflag != tar.TypeReg {
+		t.Errorf("Expected run/initctl as a regular file, got %+v", header)
+	}
+
+	if err := NewImageExporter().ExportImageFilesystemToWriterWithOptions(imageRef, io.Discard, nil, &ExportOptions{Devices: "mknod"}); err == nil {
+		t.Error("Expected an error for an unknown policy, got nil")
+	}
+}
@@ -0,0 +1,256 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// ForeignLayerPolicy decides what happens to foreign (non-distributable)
+// layers, such as the Windows base layers that registries only reference by
+// URL on mcr.microsoft.com
+type ForeignLayerPolicy string
+
+const (
+	// ForeignLayersFetch downloads foreign layers like any other layer, from the
+	// image source or else from the URLs of their descriptor, and fails the
+	// export when none of them serves the layer (default)
+	ForeignLayersFetch ForeignLayerPolicy = ""
+
+	// ForeignLayersSkip leaves foreign layers out without downloading them, and
+	// reports each with a WarningForeignLayerSkipped
+	ForeignLayersSkip ForeignLayerPolicy = "skip"
+)
+
+// ParseForeignLayerPolicy converts a policy name ("fetch", "skip") into a ForeignLayerPolicy
+func ParseForeignLayerPolicy(policy string) (ForeignLayerPolicy, error) {
+	switch policy {
+	case "", "fetch":
+		return ForeignLayersFetch, nil
+	case "skip":
+		return ForeignLayersSkip, nil
+	default:
+		return "", fmt.Errorf("unknown foreign layer policy %q", policy)
+	}
+}
+
+// WithForeignLayers selects how foreign (non-distributable) layers are handled
+// when an image filesystem is flattened. By default they are fetched, falling
+// back to the URLs of their descriptor, and an export fails rather than
+// silently producing an incomplete filesystem when they cannot be fetched.
+//
+// Example:
+//
+//	exporter := NewImageExporter(WithForeignLayers(ForeignLayersSkip))
+func WithForeignLayers(policy ForeignLayerPolicy) ExporterOption {
+	return func(e *imageExporter) {
+		e.foreignLayers = policy
+	}
+}
+
+// foreignLayer serves a foreign layer from the URLs of its descriptor when its
+// image source does not have the blob. Registry images already try the URLs
+// themselves, archives and local stores do not.
+type foreignLayer struct {
+	v1.Layer
+	desc   v1.Descriptor
+	client *http.Client
+	check  func(*url.URL) error
+}
+
+// foreignLayer wraps layer to fall back to the URLs of desc
+func (e *imageExporter) foreignLayer(layer v1.Layer, desc v1.Descriptor) v1.Layer {
+	if len(desc.URLs) == 0 {
+		return layer
+	}
+	client := &http.Client{
+		Transport: e.httpTransport,
+		Timeout:   30 * time.Minute,
+		// Every hop must pass the check of the URL itself
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return e.checkLayerURL(req.URL)
+		},
+	}
+	return &foreignLayer{Layer: layer, desc: desc, client: client, check: e.checkLayerURL}
+}
+
+// checkLayerURL refuses layer URLs that are not https, or whose host the
+// repository policy does not allow. Like registry-wide operations, a URL is
+// checked against its host alone, so an allow pattern must cover the host.
+func (e *imageExporter) checkLayerURL(u *url.URL) error {
+	if u.Scheme != "https" {
+		return fmt.Errorf("layer URL %s is not https", u.Redacted())
+	}
+	if err := e.checkRepository(u.Host); err != nil {
+		return fmt.Errorf("layer URL %s: %w", u.Redacted(), err)
+	}
+	return nil
+}
+
+// maxGuardedManifestSize bounds the manifests layerURLGuard reads, as the
+// registry client does
+const maxGuardedManifestSize = 100 << 20
+
+// layerURLGuard is the transport of an exporter. It learns the layer URLs of
+// every manifest fetched through it and runs check on each request to one of
+// them, and on each redirect from one, so the URLs the registry client tries
+// by itself when a registry lacks a blob are checked whichever code path reads
+// the layer.
+type layerURLGuard struct {
+	http.RoundTripper
+	check     func(*url.URL) error
+	layerURLs sync.Map // URL strings of the layers of fetched manifests
+}
+
+// RoundTrip implements http.RoundTripper
+func (g *layerURLGuard) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := g.refuse(req); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	resp, err := g.RoundTripper.RoundTrip(req)
+	if err != nil || req.Method != http.MethodGet || resp.StatusCode != http.StatusOK || !strings.Contains(req.URL.Path, "/manifests/") {
+		return resp, err
+	}
+	return g.learn(resp)
+}
+
+// refuse returns the error of check for requests to a layer URL, or
+// redirected from one
+func (g *layerURLGuard) refuse(req *http.Request) error {
+	if _, ok := g.layerURLs.Load(req.URL.String()); ok {
+		return g.check(req.URL)
+	}
+	for redirect := req.Response; redirect != nil && redirect.Request != nil; redirect = redirect.Request.Response {
+		if _, ok := g.layerURLs.Load(redirect.Request.URL.String()); ok {
+			return g.check(req.URL)
+		}
+	}
+	return nil
+}
+
+// learn records the layer URLs of a manifest response, returning the response
+// with its body restored
+func (g *layerURLGuard) learn(resp *http.Response) (*http.Response, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxGuardedManifestSize+1))
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxGuardedManifestSize {
+		return nil, fmt.Errorf("manifest %s exceeds %d bytes", resp.Request.URL.Redacted(), maxGuardedManifestSize)
+	}
+	var manifest v1.Manifest
+	if json.Unmarshal(body, &manifest) == nil {
+		for _, desc := range manifest.Layers {
+			for _, location := range desc.URLs {
+				if u, err := url.Parse(location); err == nil {
+					g.layerURLs.Store(u.String(), struct{}{})
+				}
+			}
+		}
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// Compressed implements v1.Layer
+func (l *foreignLayer) Compressed() (io.ReadCloser, error) {
+	blob, err := l.Layer.Compressed()
+	if err == nil {
+		return blob, nil
+	}
+	errs := []error{err}
+	for _, location := range l.desc.URLs {
+		blob, err := l.fetch(location)
+		if err == nil {
+			return blob, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("foreign layer %s is unavailable from the image source and its URLs: %w",
+		l.desc.Digest, errors.Join(errs...))
+}
+
+// Uncompressed implements v1.Layer
+func (l *foreignLayer) Uncompressed() (io.ReadCloser, error) {
+	blob, err := l.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	return decompressStream(blob)
+}
+
+// fetch downloads the layer from one of its URLs, verifying size and digest
+func (l *foreignLayer) fetch(location string) (io.ReadCloser, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid layer URL %q", location)
+	}
+	if err := l.check(u); err != nil {
+		return nil, err
+	}
+	if l.desc.Size <= 0 {
+		return nil, fmt.Errorf("foreign layer %s has no size to bound its download", l.desc.Digest)
+	}
+	resp, err := l.client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := transport.CheckError(resp, http.StatusOK); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	// A byte past the size is enough to fail the size check
+	body := &readCloser{Reader: io.LimitReader(resp.Body, l.desc.Size+1), close: resp.Body.Close}
+	return verifiedReader(body, l.desc.Size, l.desc.Digest), nil
+}
+
+// digestReader fails the read that reaches the end of a blob whose size or
+// digest does not match its descriptor
+type digestReader struct {
+	io.ReadCloser
+	hash   hash.Hash
+	size   int64
+	read   int64
+	digest v1.Hash
+}
+
+// verifiedReader verifies blob against size and digest while it is read
+func verifiedReader(blob io.ReadCloser, size int64, digest v1.Hash) io.ReadCloser {
+	return &digestReader{ReadCloser: blob, hash: sha256.New(), size: size, digest: digest}
+}
+
+// Read implements io.Reader
+func (r *digestReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	r.read += int64(n)
+	if err == io.EOF {
+		if r.size > 0 && r.read != r.size {
+			return n, fmt.Errorf("blob %s: expected %d bytes, got %d: %w", r.digest, r.size, r.read, errDigestMismatch)
+		}
+		if r.digest.Algorithm != "sha256" || hex.EncodeToString(r.hash.Sum(nil)) != r.digest.Hex {
+			return n, fmt.Errorf("blob %s: %w", r.digest, errDigestMismatch)
+		}
+	}
+	return n, err
+}
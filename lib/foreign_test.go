@@ -0,0 +1,205 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestForeignLayers(t *testing.T) {
+	base := newTestLayer(t, testEntry{name: "Files/base.txt", content: "windows base"})
+	blob, err := base.Compressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(blob)
+	serve := true
+	requests := 0
+	var redirect string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, redirect, http.StatusFound)
+			return
+		case "/endless":
+			for i := 0; i < 1024 && r.Context().Err() == nil; i++ {
+				w.Write(data)
+			}
+			return
+		}
+		if !serve {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+
+	foreign, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}, tarball.WithMediaType(types.DockerForeignLayer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer: foreign,
+		URLs:  []string{server.URL + "/layer.tar.gz"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	image, err = mutate.AppendLayers(image, newTestLayer(t, testEntry{name: "app/run.exe", content: "app"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	// The registry never receives the foreign layer
+	registryHost := newTestRegistry(t)
+	imageRef := registryHost + "/test/windows:latest"
+	pushTestImage(t, imageRef, image)
+
+	// Trust the certificate of the layer server
+	newExporter := func(opts ...ExporterOption) ImageExporter {
+		return NewImageExporter(append([]ExporterOption{WithTransport(server.Client().Transport)}, opts...)...)
+	}
+
+	var out bytes.Buffer
+	if err := newExporter().ExportImageFilesystemToWriter(imageRef, &out, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if files := readTestTar(t, out.Bytes()); files["Files/base.txt"] != "windows base" || files["app/run.exe"] != "app" {
+		t.Errorf("Expected the foreign layer fetched from its URL, got %v", files)
+	}
+
+	// A layout without the blob only has the URLs to fall back to
+	dir := filepath.Join(t.TempDir(), "layout")
+	path, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := path.AppendImage(image, layout.WithAnnotations(map[string]string{"org.opencontainers.image.ref.name": "v1"})); err != nil {
+		t.Fatal(err)
+	}
+	digest, _ := foreign.Digest()
+	if err := os.Remove(filepath.Join(dir, "blobs", "sha256", digest.Hex)); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := newExporter().ExportImageFilesystemToWriter(OCILayoutPrefix+dir+":v1", &out, nil); err != nil {
+		t.Fatalf("Expected no error for the layout, got %v", err)
+	}
+	if files := readTestTar(t, out.Bytes()); files["Files/base.txt"] != "windows base" {
+		t.Errorf("Expected the foreign layer fetched from its URL, got %v", files)
+	}
+
+	// The URLs of an allowed image are refused unless the policy allows their host
+	requests = 0
+	policy := WithRepositoryPolicy([]string{registryHost + "/test"}, nil)
+	for _, ref := range []string{imageRef, OCILayoutPrefix + dir + ":v1"} {
+		err = newExporter(policy).ExportImageFilesystemToWriter(ref, io.Discard, nil)
+		if !errors.Is(err, ErrRepositoryDenied) {
+			t.Errorf("Expected the layer URL of %s to be denied, got %v", ref, err)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("Expected no request to the layer URL, got %d", requests)
+	}
+	policy = WithRepositoryPolicy([]string{registryHost + "/test", strings.TrimPrefix(server.URL, "https://")}, nil)
+	if err := newExporter(policy).ExportImageFilesystemToWriter(imageRef, io.Discard, nil); err != nil {
+		t.Errorf("Expected the allowed layer URL to be fetched, got %v", err)
+	}
+
+	serve = false
+	err = newExporter().ExportImageFilesystemToWriter(imageRef, io.Discard, nil)
+	if err == nil || !strings.Contains(err.Error(), "layer 0") {
+		t.Errorf("Expected an error for an unavailable foreign layer, got %v", err)
+	}
+
+	var warnings WarningCollector
+	exporter := newExporter(WithForeignLayers(ForeignLayersSkip), WithWarnings(warnings.Collect))
+	out.Reset()
+	if err := exporter.ExportImageFilesystemToWriter(imageRef, &out, nil); err != nil {
+		t.Fatalf("Expected no error when skipping, got %v", err)
+	}
+	if files := readTestTar(t, out.Bytes()); files["app/run.exe"] != "app" || files["Files/base.txt"] != "" {
+		t.Errorf("Expected only the distributable layer, got %v", files)
+	}
+	if counts := warningCodes(warnings.Warnings()); counts[WarningForeignLayerSkipped] != 1 {
+		t.Errorf("Expected a foreign layer warning, got %v", warnings.Warnings())
+	}
+
+	if _, err := ParseForeignLayerPolicy("ignore"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+
+	// Plain http layer URLs are never contacted
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected no request to the plain http layer URL, got %s", r.URL)
+		w.Write(data)
+	}))
+	t.Cleanup(plain.Close)
+	image, err = mutate.Append(empty.Image, mutate.Addendum{
+		Layer: foreign,
+		URLs:  []string{plain.URL + "/layer.tar.gz"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	plainRef := registryHost + "/test/plain:latest"
+	pushTestImage(t, plainRef, image)
+	if err := newExporter().ExportImageFilesystemToWriter(plainRef, io.Discard, nil); err == nil {
+		t.Error("Expected an error for a plain http layer URL")
+	}
+	// The registry client tries the URLs for every caller reading the blob
+	if _, _, err := newExporter().OpenLayer(plainRef, digest.String(), nil); err == nil {
+		t.Error("Expected an error opening a layer with a plain http URL")
+	}
+
+	// An https layer URL cannot redirect to plain http
+	redirect = plain.URL + "/layer.tar.gz"
+	image, err = mutate.Append(empty.Image, mutate.Addendum{
+		Layer: foreign,
+		URLs:  []string{server.URL + "/redirect"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	redirectRef := registryHost + "/test/redirect:latest"
+	pushTestImage(t, redirectRef, image)
+	if err := newExporter().ExportImageFilesystemToWriter(redirectRef, io.Discard, nil); err == nil {
+		t.Error("Expected an error for a layer URL redirecting to plain http")
+	}
+	if _, _, err := newExporter().OpenLayer(redirectRef, digest.String(), nil); err == nil {
+		t.Error("Expected an error opening a layer redirected to plain http")
+	}
+
+	// Layer URLs fetched by imgex itself pass the same checks on every
+	// redirect, and no more than the layer size is read
+	desc := v1.Descriptor{MediaType: types.DockerForeignLayer, Size: int64(len(data)), Digest: digest, URLs: []string{server.URL + "/endless"}}
+	fetcher := newExporter().(*imageExporter).foreignLayer(foreign, desc).(*foreignLayer)
+	if _, err := fetcher.fetch(server.URL + "/redirect"); err == nil {
+		t.Error("Expected an error for a layer URL redirecting to plain http")
+	}
+	blob, err = fetcher.fetch(server.URL + "/endless")
+	if err != nil {
+		t.Fatalf("Expected the layer URL to be fetched, got %v", err)
+	}
+	read, err := io.ReadAll(blob)
+	blob.Close()
+	if !errors.Is(err, errDigestMismatch) || int64(len(read)) > desc.Size+1 {
+		t.Errorf("Expected the download to stop after %d bytes with a size mismatch, got %d bytes and %v", desc.Size+1, len(read), err)
+	}
+}
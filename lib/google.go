@@ -0,0 +1,270 @@
+//go:build !nocloud
+
+package lib
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+// googleCloudPlatformScope is the OAuth scope requested for registry access
+const googleCloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// googleTokenRefreshWindow renews cached tokens this long before they expire
+const googleTokenRefreshWindow = time.Minute
+
+// googleKeychain obtains OAuth access tokens from Google Application Default
+// Credentials: the GOOGLE_APPLICATION_CREDENTIALS file, the gcloud ADC file, or
+// the GCE/GKE metadata server (which also serves workload identity). Registries
+// that are not GCR or Artifact Registry resolve anonymously.
+type googleKeychain struct {
+	transport http.RoundTripper
+
+	// credentials overrides the ADC lookup, for tests
+	credentials func() (*googleToken, error)
+
+	mu    sync.Mutex
+	token *googleToken
+}
+
+// googleToken is a cached OAuth access token
+type googleToken struct {
+	accessToken string
+	expiresAt   time.Time
+}
+
+// Resolve implements authn.Keychain
+func (k *googleKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	if !IsGoogleRegistry(target.RegistryStr()) {
+		return authn.Anonymous, nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// One token is valid for every Google registry
+	if k.token == nil || time.Until(k.token.expiresAt) < googleTokenRefreshWindow {
+		fetch := k.credentials
+		if fetch == nil {
+			fetch = k.fetchToken
+		}
+		token, err := fetch()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get Google access token for %s: %w", target.RegistryStr(), err)
+		}
+		k.token = token
+	}
+
+	return authn.FromConfig(authn.AuthConfig{
+		Username: "oauth2accesstoken",
+		Password: k.token.accessToken,
+	}), nil
+}
+
+// googleCredentialsFile is the subset of an ADC JSON file imgex understands
+type googleCredentialsFile struct {
+	Type string `json:"type"`
+
+	// authorized_user (gcloud auth application-default login)
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+
+	// service_account
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// fetchToken walks the Application Default Credentials chain
+func (k *googleKeychain) fetchToken() (*googleToken, error) {
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		path = googleWellKnownCredentialsFile()
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return k.tokenFromFile(path, data)
+		}
+		if os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") != "" || !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read Google credentials: %w", err)
+		}
+	}
+
+	token, err := googleMetadataToken()
+	if err != nil {
+		return nil, fmt.Errorf("no Google credentials found (set GOOGLE_APPLICATION_CREDENTIALS, run 'gcloud auth application-default login' or run on Google Cloud): %w", err)
+	}
+	return token, nil
+}
+
+// googleWellKnownCredentialsFile returns where gcloud writes application default credentials
+func googleWellKnownCredentialsFile() string {
+	if dir := os.Getenv("CLOUDSDK_CONFIG"); dir != "" {
+		return filepath.Join(dir, "application_default_credentials.json")
+	}
+	if appData := os.Getenv("APPDATA"); appData != "" {
+		return filepath.Join(appData, "gcloud", "application_default_credentials.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+}
+
+// tokenFromFile exchanges the credentials in an ADC file for an access token
+func (k *googleKeychain) tokenFromFile(path string, data []byte) (*googleToken, error) {
+	var creds googleCredentialsFile
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse Google credentials %s: %w", path, err)
+	}
+
+	switch creds.Type {
+	case "authorized_user":
+		return k.exchangeToken("https://oauth2.googleapis.com/token", url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {creds.ClientID},
+			"client_secret": {creds.ClientSecret},
+			"refresh_token": {creds.RefreshToken},
+		})
+	case "service_account":
+		tokenURI := creds.TokenURI
+		if tokenURI == "" {
+			tokenURI = "https://oauth2.googleapis.com/token"
+		}
+		assertion, err := signServiceAccountJWT(&creds, tokenURI, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign service account assertion: %w", err)
+		}
+		return k.exchangeToken(tokenURI, url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		})
+	default:
+		return nil, fmt.Errorf("unsupported Google credentials type %q in %s", creds.Type, path)
+	}
+}
+
+// googleTokenResponse is the OAuth token endpoint and metadata server response
+type googleTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// token converts the response into a cached token
+func (r *googleTokenResponse) token() (*googleToken, error) {
+	if r.AccessToken == "" {
+		return nil, fmt.Errorf("token response contained no access token")
+	}
+	return &googleToken{
+		accessToken: r.AccessToken,
+		expiresAt:   time.Now().Add(time.Duration(r.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// exchangeToken posts an OAuth grant to a token endpoint
+func (k *googleKeychain) exchangeToken(tokenURI string, form url.Values) (*googleToken, error) {
+	client := &http.Client{Transport: k.transport, Timeout: 30 * time.Second}
+	resp, err := client.PostForm(tokenURI, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var result googleTokenResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse token response: %w", err)
+	}
+	return result.token()
+}
+
+// googleMetadataToken fetches the attached service account's token from the
+// GCE/GKE metadata server, honoring GCE_METADATA_HOST
+func googleMetadataToken() (*googleToken, error) {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+	req, err := http.NewRequest(http.MethodGet,
+		"http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	var result googleTokenResponse
+	if err := fetchMetadataJSON(req, &result); err != nil {
+		return nil, err
+	}
+	return result.token()
+}
+
+// signServiceAccountJWT builds the RS256-signed assertion for the JWT bearer grant
+func signServiceAccountJWT(creds *googleCredentialsFile, audience string, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("private key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to parse private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("service account key is not an RSA key")
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": creds.PrivateKeyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   creds.ClientEmail,
+		"scope": googleCloudPlatformScope,
+		"aud":   audience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,173 @@
+//go:build !nocloud
+
+package lib
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestIsGoogleRegistry(t *testing.T) {
+	tests := map[string]bool{
+		"gcr.io":                      true,
+		"eu.gcr.io":                   true,
+		"us-central1-docker.pkg.dev":  true,
+		"europe-docker.pkg.dev":       true,
+		"pkg.dev":                     false,
+		"index.docker.io":             false,
+		"us-central1-python.pkg.dev":  false,
+		"gcr.io.attacker.example.com": false,
+	}
+	for registry, expected := range tests {
+		if got := IsGoogleRegistry(registry); got != expected {
+			t.Errorf("Expected IsGoogleRegistry(%q) = %v, got %v", registry, expected, got)
+		}
+	}
+}
+
+func TestGoogleKeychain(t *testing.T) {
+	var calls int
+	keychain := &googleKeychain{
+		credentials: func() (*googleToken, error) {
+			calls++
+			return &googleToken{accessToken: "ya29.token", expiresAt: time.Now().Add(time.Hour)}, nil
+		},
+	}
+
+	for _, ref := range []string{"gcr.io/project/app", "us-central1-docker.pkg.dev/project/repo/app"} {
+		repo, err := name.NewRepository(ref)
+		if err != nil {
+			t.Fatalf("Failed to parse repository: %v", err)
+		}
+		authenticator, err := keychain.Resolve(repo)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		cfg, err := authenticator.Authorization()
+		if err != nil {
+			t.Fatalf("Failed to get authorization: %v", err)
+		}
+		if cfg.Username != "oauth2accesstoken" || cfg.Password != "ya29.token" {
+			t.Errorf("Expected oauth2accesstoken/ya29.token, got %s/%s", cfg.Username, cfg.Password)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected the token to be shared across registries, got %d fetches", calls)
+	}
+
+	other, err := name.NewRepository("registry.example.com/app")
+	if err != nil {
+		t.Fatalf("Failed to parse repository: %v", err)
+	}
+	authenticator, err := keychain.Resolve(other)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if authenticator != authn.Anonymous {
+		t.Errorf("Expected anonymous access for non-Google registry, got %v", authenticator)
+	}
+}
+
+func TestGoogleServiceAccountCredentials(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("Failed to parse token request: %v", err)
+		}
+		if grant := r.Form.Get("grant_type"); grant != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			t.Errorf("Expected JWT bearer grant, got %q", grant)
+		}
+
+		// The assertion must verify with the service account's public key
+		parts := strings.Split(r.Form.Get("assertion"), ".")
+		if len(parts) != 3 {
+			t.Fatalf("Expected a three-part JWT, got %d parts", len(parts))
+		}
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+			t.Errorf("Expected a valid RS256 signature, got %v", err)
+		}
+		claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		if !strings.Contains(string(claims), `"iss":"imgex@project.iam.gserviceaccount.com"`) {
+			t.Errorf("Expected service account issuer, got %s", claims)
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "sa-token", "expires_in": 3600})
+	}))
+	defer server.Close()
+
+	creds, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "imgex@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal credentials: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "sa.json")
+	if err := os.WriteFile(path, creds, 0600); err != nil {
+		t.Fatalf("Failed to write credentials: %v", err)
+	}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", path)
+
+	token, err := (&googleKeychain{}).fetchToken()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if token.accessToken != "sa-token" {
+		t.Errorf("Expected sa-token, got %s", token.accessToken)
+	}
+}
+
+func TestGoogleMetadataCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			t.Errorf("Expected Metadata-Flavor header")
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "gke-token", "expires_in": 3600})
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse server URL: %v", err)
+	}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	t.Setenv("CLOUDSDK_CONFIG", t.TempDir())
+	t.Setenv("GCE_METADATA_HOST", u.Host)
+
+	token, err := (&googleKeychain{}).fetchToken()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if token.accessToken != "gke-token" {
+		t.Errorf("Expected gke-token, got %s", token.accessToken)
+	}
+}
@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"archive/tar"
+	"maps"
+	"sort"
+)
+
+// linkInode returns the regular file a hardlink entry applied at p shares its
+// content with: the entry its Linkname names in the filesystem as it stands when
+// the link is applied, or the file that entry links to in turn. It returns nil
+// for a link to a missing path or to anything but a regular file.
+func linkInode(filesystem map[string]*fileEntry, p string, header *tar.Header) *fileEntry {
+	target, _ := linkTarget(p, header)
+	entry, ok := lookupEntry(filesystem, target)
+	if !ok {
+		return nil
+	}
+	if entry.inode != nil {
+		return entry.inode
+	}
+	if entry.header.Typeflag == tar.TypeReg || entry.header.Typeflag == tar.TypeRegA {
+		return entry
+	}
+	return nil
+}
+
+// repairHardlinks keeps hardlinks pointing at the content they shared when
+// their layer was applied. A later layer may replace or delete the file a link
+// names, while in a container the link still holds the original content. Links
+// whose target changed are pointed to another path still holding the original
+// file, or, when none is left, the first of them becomes a regular file with the
+// original content and the others link to it.
+func (e *imageExporter) repairHardlinks(filesystem map[string]*fileEntry) {
+	// Group the links by the file they share, and find where each file survives
+	links := make(map[*fileEntry][]string)
+	for p, entry := range filesystem {
+		if entry.header.Typeflag == tar.TypeLink && entry.inode != nil {
+			links[entry.inode] = append(links[entry.inode], p)
+		}
+	}
+	if len(links) == 0 {
+		return
+	}
+	survivors := make(map[*fileEntry]string)
+	for p, entry := range filesystem {
+		if _, ok := links[entry]; ok {
+			survivors[entry] = p
+		}
+	}
+
+	for inode, paths := range links {
+		sort.Strings(paths)
+		var broken []string
+		for _, p := range paths {
+			target, _ := linkTarget(p, filesystem[p].header)
+			current, ok := lookupEntry(filesystem, target)
+			if !ok || (current != inode && current.inode != inode) {
+				broken = append(broken, p)
+			}
+		}
+		if len(broken) == 0 {
+			continue
+		}
+
+		survivor, ok := survivors[inode]
+		if !ok {
+			// Materialize the original content at the first broken link
+			survivor = broken[0]
+			broken = broken[1:]
+			header := *inode.header
+			header.Name = filesystem[survivor].header.Name
+			header.Typeflag = tar.TypeReg
+			header.PAXRecords = maps.Clone(inode.header.PAXRecords)
+			header.Xattrs = maps.Clone(inode.header.Xattrs)
+			filesystem[survivor] = &fileEntry{
+				header:  &header,
+				data:    inode.data,
+				staged:  inode.staged,
+				offset:  inode.offset,
+				lazy:    inode.lazy,
+				regions: inode.regions,
+				layer:   filesystem[survivor].layer,
+			}
+			e.log().Debug("materialized hardlink whose target changed", "path", survivor)
+		}
+		for _, p := range broken {
+			filesystem[p].header.Linkname = filesystem[survivor].header.Name
+			e.log().Debug("rewrote hardlink whose target changed", "path", p, "target", survivor)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestExportHardlinksAcrossLayers(t *testing.T) {
+	base := newTestLayer(t,
+		testEntry{name: "deleted", content: "one"},
+		testEntry{name: "first", typeflag: tar.TypeLink, linkname: "deleted"},
+		testEntry{name: "second", typeflag: tar.TypeLink, linkname: "deleted"},
+		testEntry{name: "replaced", content: "old"},
+		testEntry{name: "stale", typeflag: tar.TypeLink, linkname: "replaced"},
+		testEntry{name: "kept", content: "kept"},
+		testEntry{name: "middle", typeflag: tar.TypeLink, linkname: "kept"},
+		testEntry{name: "same", content: "same"},
+		testEntry{name: "intact", typeflag: tar.TypeLink, linkname: "same"},
+	)
+	top := newTestLayer(t,
+		testEntry{name: ".wh.deleted"},
+		testEntry{name: "replaced", content: "new"},
+		testEntry{name: "last", typeflag: tar.TypeLink, linkname: "middle"},
+		testEntry{name: ".wh.middle"},
+	)
+	image, err := mutate.AppendLayers(empty.Image, base, top)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/hardlinks:latest"
+	pushTestImage(t, imageRef, image)
+
+	var output bytes.Buffer
+	var warnings []Warning
+	opts := &ExportOptions{Warning: func(w Warning) { warnings = append(warnings, w) }}
+	if err := NewImageExporter().ExportImageFilesystemToWriterWithOptions(imageRef, &output, nil, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if dangling := warningCodes(warnings)[WarningDanglingLink]; dangling != 0 {
+		t.Errorf("Expected no dangling links, got %d warnings", dangling)
+	}
+
+	headers := make(map[string]*tar.Header)
+	contents := make(map[string]string)
+	reader := tar.NewReader(&output)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		content, _ := io.ReadAll(reader)
+		headers[header.Name], contents[header.Name] = header, string(content)
+	}
+
+	for _, test := range []struct {
+		path     string
+		content  string // expected content of a regular file
+		linkname string // expected target of a hardlink
+	}{
+		{path: "first", content: "one"},     // target deleted: materialized
+		{path: "second", linkname: "first"}, // links to the materialized copy
+		{path: "replaced", content: "new"},  // replacement kept
+		{path: "stale", content: "old"},     // keeps the content of the replaced file
+		{path: "last", linkname: "kept"},    // target deleted, the file survives elsewhere
+		{path: "intact", linkname: "same"},  // unchanged target
+	} {
+		header, ok := headers[test.path]
+		if !ok {
+			t.Errorf("Expected %s in the archive", test.path)
+			continue
+		}
+		if test.linkname != "" && (header.Typeflag != tar.TypeLink || header.Linkname != test.linkname) {
+			t.Errorf("Expected %s to link to %s, got type %q to %q", test.path, test.linkname, header.Typeflag, header.Linkname)
+		}
+		if test.linkname == "" && (header.Typeflag != tar.TypeReg || contents[test.path] != test.content) {
+			t.Errorf("Expected %s to be a file holding %q, got type %q holding %q", test.path, test.content, header.Typeflag, contents[test.path])
+		}
+	}
+}
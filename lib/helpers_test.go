@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"log"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// testEntry describes a single entry of a crafted layer tar
+type testEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+	mode     int64
+	uid, gid int
+	pax      map[string]string
+}
+
+// newTestLayer builds a gzip-compressed layer from the given entries
+func newTestLayer(t *testing.T, entries ...testEntry) v1.Layer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		typeflag := entry.typeflag
+		if typeflag == 0 {
+			typeflag = tar.TypeReg
+		}
+		mode := entry.mode
+		if mode == 0 {
+			mode = 0644
+			if typeflag == tar.TypeDir {
+				mode = 0755
+			}
+		}
+		header := &tar.Header{
+			Name:       entry.name,
+			Typeflag:   typeflag,
+			Linkname:   entry.linkname,
+			Mode:       mode,
+			Uid:        entry.uid,
+			Gid:        entry.gid,
+			Size:       int64(len(entry.content)),
+			PAXRecords: entry.pax,
+		}
+		if typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("Failed to write test header %s: %v", entry.name, err)
+		}
+		if header.Size > 0 {
+			if _, err := tw.Write([]byte(entry.content)); err != nil {
+				t.Fatalf("Failed to write test content %s: %v", entry.name, err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close test tar: %v", err)
+	}
+
+	data := buf.Bytes()
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test layer: %v", err)
+	}
+	return layer
+}
+
+// newTestRegistry starts an in-memory registry and returns its host:port
+func newTestRegistry(t *testing.T) string {
+	t.Helper()
+
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse registry URL: %v", err)
+	}
+	return u.Host
+}
+
+// pushTestImage pushes image to the given reference in a test registry
+func pushTestImage(t *testing.T, imageRef string, image v1.Image) {
+	t.Helper()
+
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		t.Fatalf("Failed to parse test reference %s: %v", imageRef, err)
+	}
+	if err := remote.Write(ref, image); err != nil {
+		t.Fatalf("Failed to push test image %s: %v", imageRef, err)
+	}
+}
+
+// readTestTar returns the regular file contents of a tar archive keyed by name
+func readTestTar(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read output tar: %v", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("Failed to read %s from output tar: %v", header.Name, err)
+		}
+		files[header.Name] = string(content)
+	}
+	return files
+}
@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+// LayerHistory returns each layer of an image paired with the history entry that created it.
+//
+// The image configuration records one history entry per Dockerfile instruction, but only
+// entries without the empty_layer flag produced a layer. This method walks the history,
+// skips the empty entries and matches the remaining ones to the manifest layers in order.
+// Layers without a matching history entry (e.g. images built without history) are still
+// returned with an empty CreatedBy.
+//
+// Parameters:
+//   - imageRef: Docker image reference (e.g., "nginx:latest", "registry.com/org/image:v1.0")
+//   - auth: Optional authentication configuration for private registries
+//
+// Returns:
+//   - []LayerHistoryEntry: One entry per layer, in layer order
+//   - error: Any error encountered during the operation
+//
+// Example:
+//
+//	exporter := NewImageExporter()
+//	history, err := exporter.LayerHistory("nginx:alpine", nil)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	for _, entry := range history {
+//	    fmt.Printf("%s %d %s\n", entry.Digest, entry.Size, entry.CreatedBy)
+//	}
+func (e *imageExporter) LayerHistory(imageRef string, auth *AuthConfig) ([]LayerHistoryEntry, error) {
+	image, err := e.fetchImage(imageRef, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return layerHistory(image)
+}
+
+// layerHistory pairs the non-empty history entries of an image with its layers
+func layerHistory(image v1.Image) ([]LayerHistoryEntry, error) {
+	configFile, err := image.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config file: %w", err)
+	}
+
+	manifest, err := image.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest: %w", err)
+	}
+
+	// Collect the history entries that actually produced a layer
+	var history []v1.History
+	for _, h := range configFile.History {
+		if !h.EmptyLayer {
+			history = append(history, h)
+		}
+	}
+
+	entries := make([]LayerHistoryEntry, 0, len(manifest.Layers))
+	for i, layer := range manifest.Layers {
+		entry := LayerHistoryEntry{
+			Index:  i,
+			Digest: layer.Digest.String(),
+			Size:   layer.Size,
+		}
+		if i < len(configFile.RootFS.DiffIDs) {
+			entry.DiffID = configFile.RootFS.DiffIDs[i].String()
+		}
+		if i < len(history) {
+			entry.Created = history[i].Created.Time
+			entry.CreatedBy = history[i].CreatedBy
+			entry.Comment = history[i].Comment
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"archive/tar"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestLayerHistory(t *testing.T) {
+	first := newTestLayer(t, testEntry{name: "etc/", typeflag: tar.TypeDir})
+	second := newTestLayer(t, testEntry{name: "etc/motd", content: "hello"})
+
+	image, err := mutate.Append(empty.Image,
+		mutate.Addendum{Layer: first, History: v1.History{CreatedBy: "ADD rootfs.tar /"}},
+		mutate.Addendum{History: v1.History{CreatedBy: "ENV FOO=bar", EmptyLayer: true}},
+		mutate.Addendum{Layer: second, History: v1.History{CreatedBy: "RUN echo hello > /etc/motd"}},
+	)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+
+	host := newTestRegistry(t)
+	imageRef := host + "/test/history:latest"
+	pushTestImage(t, imageRef, image)
+
+	exporter := NewImageExporter()
+	history, err := exporter.LayerHistory(imageRef, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 entries, got %d: %+v", len(history), history)
+	}
+
+	expected := []string{"ADD rootfs.tar /", "RUN echo hello > /etc/motd"}
+	layers := []v1.Layer{first, second}
+	for i, entry := range history {
+		if entry.Index != i {
+			t.Errorf("Expected index %d, got %d", i, entry.Index)
+		}
+		if entry.CreatedBy != expected[i] {
+			t.Errorf("Expected CreatedBy %q, got %q", expected[i], entry.CreatedBy)
+		}
+
+		digest, _ := layers[i].Digest()
+		if entry.Digest != digest.String() {
+			t.Errorf("Expected digest %s, got %s", digest, entry.Digest)
+		}
+		size, _ := layers[i].Size()
+		if entry.Size != size {
+			t.Errorf("Expected size %d, got %d", size, entry.Size)
+		}
+	}
+}
+
+func TestLayerHistory_MissingHistory(t *testing.T) {
+	layer := newTestLayer(t, testEntry{name: "file", content: "data"})
+	image, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+
+	// Drop the history synthesized by AppendLayers
+	configFile, err := image.ConfigFile()
+	if err != nil {
+		t.Fatalf("Failed to get config file: %v", err)
+	}
+	configFile.History = nil
+	image, err = mutate.ConfigFile(image, configFile)
+	if err != nil {
+		t.Fatalf("Failed to update config file: %v", err)
+	}
+
+	history, err := layerHistory(image)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(history) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(history))
+	}
+	if history[0].CreatedBy != "" {
+		t.Errorf("Expected empty CreatedBy, got %q", history[0].CreatedBy)
+	}
+}
@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"archive/tar"
+	"fmt"
+)
+
+// OverflowID is the owner given to ids outside every range of a remapping,
+// like the kernel does for ids unmapped in a user namespace (nobody/nogroup)
+const OverflowID = 65534
+
+// IDMapping maps a range of user or group ids of the image to ids of the
+// exported archive, like a line of /proc/<pid>/uid_map: ids ContainerID to
+// ContainerID+Size-1 become HostID to HostID+Size-1
+type IDMapping struct {
+	ContainerID int
+	HostID      int
+	Size        int
+}
+
+// Owner is the single owner given to every entry by ExportOptions.Owner
+type Owner struct {
+	UID int
+	GID int
+}
+
+// validateOwnership checks the owner override and the remappings of opts
+func validateOwnership(opts *ExportOptions) error {
+	if opts.Owner != nil {
+		if opts.Owner.UID < 0 || opts.Owner.GID < 0 {
+			return fmt.Errorf("invalid owner %d:%d", opts.Owner.UID, opts.Owner.GID)
+		}
+		if len(opts.UIDMap) > 0 || len(opts.GIDMap) > 0 {
+			return fmt.Errorf("an owner override cannot be combined with uid or gid mappings")
+		}
+	}
+	if err := validateIDMap("uid", opts.UIDMap); err != nil {
+		return err
+	}
+	return validateIDMap("gid", opts.GIDMap)
+}
+
+// validateIDMap checks that the ranges of a remapping are valid and that no
+// two ranges map the same id of the image
+func validateIDMap(kind string, mappings []IDMapping) error {
+	for i, m := range mappings {
+		if m.ContainerID < 0 || m.HostID < 0 || m.Size <= 0 {
+			return fmt.Errorf("invalid %s mapping %d:%d:%d", kind, m.ContainerID, m.HostID, m.Size)
+		}
+		for _, other := range mappings[:i] {
+			if m.ContainerID < other.ContainerID+other.Size && other.ContainerID < m.ContainerID+m.Size {
+				return fmt.Errorf("%s mappings %d:%d:%d and %d:%d:%d overlap", kind,
+					other.ContainerID, other.HostID, other.Size, m.ContainerID, m.HostID, m.Size)
+			}
+		}
+	}
+	return nil
+}
+
+// mapID returns the id an id of the image maps to, and false with OverflowID
+// when no range covers it
+func mapID(mappings []IDMapping, id int) (int, bool) {
+	for _, m := range mappings {
+		if id >= m.ContainerID && id < m.ContainerID+m.Size {
+			return m.HostID + id - m.ContainerID, true
+		}
+	}
+	return OverflowID, false
+}
+
+// remapOwner applies the owner override or the uid and gid remappings of opts
+// to a header. User and group names are cleared with them, so that extraction
+// cannot map the entry back to the original owner by name. It reports whether
+// an id was unmapped.
+func remapOwner(header *tar.Header, opts *ExportOptions) bool {
+	if opts.Owner != nil {
+		header.Uid, header.Gid = opts.Owner.UID, opts.Owner.GID
+		header.Uname, header.Gname = "", ""
+		return false
+	}
+	unmapped := false
+	if len(opts.UIDMap) > 0 {
+		var ok bool
+		header.Uid, ok = mapID(opts.UIDMap, header.Uid)
+		header.Uname = ""
+		unmapped = !ok
+	}
+	if len(opts.GIDMap) > 0 {
+		var ok bool
+		header.Gid, ok = mapID(opts.GIDMap, header.Gid)
+		header.Gname = ""
+		unmapped = unmapped || !ok
+	}
+	return unmapped
+}
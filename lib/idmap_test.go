@@ -0,0 +1,138 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestValidateIDMap(t *testing.T) {
+	tests := []struct {
+		name     string
+		mappings []IDMapping
+		valid    bool
+	}{
+		{"empty", nil, true},
+		{"single", []IDMapping{{0, 100000, 65536}}, true},
+		{"adjacent", []IDMapping{{0, 1000, 1}, {1, 100000, 65535}}, true},
+		{"overlapping", []IDMapping{{0, 100000, 65536}, {1000, 1000, 1}}, false},
+		{"zero size", []IDMapping{{0, 100000, 0}}, false},
+		{"negative", []IDMapping{{-1, 100000, 1}}, false},
+	}
+	for _, tt := range tests {
+		err := validateIDMap("uid", tt.mappings)
+		if tt.valid && err != nil {
+			t.Errorf("Expected %s mappings to be valid, got %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("Expected %s mappings to be rejected", tt.name)
+		}
+	}
+}
+
+func TestExportIDMap(t *testing.T) {
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t,
+		testEntry{name: "root", content: "root"},
+		testEntry{name: "app", content: "app", uid: 1000, gid: 1000},
+		testEntry{name: "outside", content: "outside", uid: 70000, gid: 10},
+	))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/idmap:latest"
+	pushTestImage(t, imageRef, image)
+
+	var output bytes.Buffer
+	var warnings []Warning
+	opts := &ExportOptions{
+		UIDMap:  []IDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+		GIDMap:  []IDMapping{{ContainerID: 0, HostID: 200000, Size: 65536}},
+		Warning: func(w Warning) { warnings = append(warnings, w) },
+	}
+	exporter := NewImageExporter()
+	if err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &output, nil, opts); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if count := warningCodes(warnings)[WarningUnmappedID]; count != 1 {
+		t.Errorf("Expected 1 unmapped id warning, got %d", count)
+	}
+
+	expected := map[string][2]int{
+		"root":    {100000, 200000},
+		"app":     {101000, 201000},
+		"outside": {OverflowID, 200010},
+	}
+	reader := tar.NewReader(&output)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		if header.Uname != "" || header.Gname != "" {
+			t.Errorf("Expected no owner names for %s, got %q and %q", header.Name, header.Uname, header.Gname)
+		}
+		ids, ok := expected[header.Name]
+		if !ok {
+			continue
+		}
+		if header.Uid != ids[0] || header.Gid != ids[1] {
+			t.Errorf("Expected %s to be owned by %d:%d, got %d:%d", header.Name, ids[0], ids[1], header.Uid, header.Gid)
+		}
+		delete(expected, header.Name)
+	}
+	if len(expected) > 0 {
+		t.Errorf("Expected entries missing from the archive: %v", expected)
+	}
+
+	invalid := []*ExportOptions{
+		{UIDMap: []IDMapping{{ContainerID: 0, HostID: 1, Size: 10}, {ContainerID: 5, HostID: 100, Size: 10}}},
+		{Owner: &Owner{UID: -1, GID: 0}},
+		{Owner: &Owner{}, GIDMap: []IDMapping{{ContainerID: 0, HostID: 1, Size: 1}}},
+	}
+	for _, opts := range invalid {
+		if err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, io.Discard, nil, opts); err == nil {
+			t.Errorf("Expected ownership options %+v to fail the export", *opts)
+		}
+	}
+}
+
+func TestExportOwner(t *testing.T) {
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t,
+		testEntry{name: "etc/", typeflag: tar.TypeDir, mode: 0755},
+		testEntry{name: "etc/app", content: "app", uid: 1000, gid: 1000},
+	))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/owner:latest"
+	pushTestImage(t, imageRef, image)
+
+	var output bytes.Buffer
+	exporter := NewImageExporter()
+	opts := &ExportOptions{Owner: &Owner{UID: 1234, GID: 5678}}
+	if err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &output, nil, opts); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	reader := tar.NewReader(&output)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		if header.Uid != 1234 || header.Gid != 5678 || header.Uname != "" || header.Gname != "" {
+			t.Errorf("Expected %s to be owned by 1234:5678, got %d:%d (%q:%q)", header.Name, header.Uid, header.Gid, header.Uname, header.Gname)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/docker-credential-helpers/client"
+	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// WithDockerConfig loads registry credentials from a specific docker config.json
+// instead of the default location ($DOCKER_CONFIG or ~/.docker).
+//
+// The path may point either at the config.json file itself or at the directory
+// containing it. Credential stores and helpers configured in that file are honored.
+// Explicit AuthConfig credentials passed to individual calls still take precedence.
+func WithDockerConfig(path string) ExporterOption {
+	return func(e *imageExporter) {
+		e.keychain = &dockerConfigKeychain{path: path}
+	}
+}
+
+// WithCredentialHelper resolves credentials for every registry through the named
+// docker credential helper (e.g. "ecr-login" runs docker-credential-ecr-login),
+// ignoring any docker config.json. Explicit AuthConfig credentials still take precedence.
+func WithCredentialHelper(helper string) ExporterOption {
+	return func(e *imageExporter) {
+		e.keychain = &credentialHelperKeychain{helper: helper}
+	}
+}
+
+// dockerConfigKeychain resolves credentials from a docker config.json, including
+// any credHelpers or credsStore it configures. An empty path means the default
+// location ($DOCKER_CONFIG or ~/.docker); if no file exists there, resolution is
+// delegated to the go-containerregistry default keychain (podman auth files, etc.).
+type dockerConfigKeychain struct {
+	path string
+}
+
+// Resolve implements authn.Keychain
+func (k *dockerConfigKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	cf, err := k.load()
+	if err != nil {
+		return nil, err
+	}
+	if cf == nil {
+		return authn.DefaultKeychain.Resolve(target)
+	}
+
+	// Match the lookup order of the default keychain: repository, then registry
+	for _, key := range []string{target.String(), target.RegistryStr()} {
+		if key == name.DefaultRegistry {
+			key = authn.DefaultAuthKey
+		}
+
+		// Fail loudly when a configured helper is missing instead of going anonymous
+		if err := checkCredentialHelper(cf, key); err != nil {
+			return nil, err
+		}
+
+		cfg, err := cf.GetAuthConfig(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read credentials for %s from %s: %w", key, cf.Filename, err)
+		}
+		if cfg.Username == "" && cfg.Password == "" && cfg.Auth == "" &&
+			cfg.IdentityToken == "" && cfg.RegistryToken == "" {
+			continue
+		}
+
+		return authn.FromConfig(authn.AuthConfig{
+			Username:      cfg.Username,
+			Password:      cfg.Password,
+			Auth:          cfg.Auth,
+			IdentityToken: cfg.IdentityToken,
+			RegistryToken: cfg.RegistryToken,
+		}), nil
+	}
+
+	return authn.Anonymous, nil
+}
+
+// load reads the config file, accepting either a file path or its directory.
+// It returns nil without error when no path is set and the default file is absent.
+func (k *dockerConfigKeychain) load() (*configfile.ConfigFile, error) {
+	path := k.path
+	if path == "" {
+		path = filepath.Join(config.Dir(), config.ConfigFileName)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, nil
+		}
+	} else if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, config.ConfigFileName)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open docker config: %w", err)
+	}
+	defer file.Close()
+
+	cf, err := config.LoadFromReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse docker config %s: %w", path, err)
+	}
+	cf.Filename = path
+	return cf, nil
+}
+
+// checkCredentialHelper verifies that the credential helper configured for a
+// registry key, if any, can be found on the PATH
+func checkCredentialHelper(cf *configfile.ConfigFile, key string) error {
+	helper := cf.CredentialHelpers[key]
+	if helper == "" {
+		helper = cf.CredentialsStore
+	}
+	if helper == "" {
+		return nil
+	}
+	if _, err := exec.LookPath(credentialHelperPrefix + helper); err != nil {
+		return fmt.Errorf("credential helper %s%s configured in %s is not installed: %w",
+			credentialHelperPrefix, helper, cf.Filename, err)
+	}
+	return nil
+}
+
+// credentialHelperPrefix is prepended to helper names to form the executable name
+const credentialHelperPrefix = "docker-credential-"
+
+// credentialHelperKeychain resolves credentials by running a docker credential helper
+type credentialHelperKeychain struct {
+	helper string
+}
+
+// Resolve implements authn.Keychain
+func (k *credentialHelperKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	program := credentialHelperPrefix + k.helper
+	if _, err := exec.LookPath(program); err != nil {
+		return nil, fmt.Errorf("credential helper %s is not installed: %w", program, err)
+	}
+
+	serverURL := target.RegistryStr()
+	if serverURL == name.DefaultRegistry {
+		serverURL = authn.DefaultAuthKey
+	}
+
+	creds, err := client.Get(client.NewShellProgramFunc(program), serverURL)
+	if err != nil {
+		if credentials.IsErrCredentialsNotFound(err) {
+			return authn.Anonymous, nil
+		}
+		return nil, fmt.Errorf("credential helper %s failed for %s: %w", program, serverURL, err)
+	}
+
+	// Helpers return identity tokens with a fixed "<token>" username
+	if creds.Username == "<token>" {
+		return authn.FromConfig(authn.AuthConfig{IdentityToken: creds.Secret}), nil
+	}
+	return authn.FromConfig(authn.AuthConfig{
+		Username: creds.Username,
+		Password: creds.Secret,
+	}), nil
+}
@@ -0,0 +1,189 @@
+package lib
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestDockerConfigKeychain(t *testing.T) {
+	dir := t.TempDir()
+	auth := base64.StdEncoding.EncodeToString([]byte("ci-user:ci-pass"))
+	config := `{"auths": {"registry.example.com": {"auth": "` + auth + `"}}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatalf("Failed to write docker config: %v", err)
+	}
+
+	// Both the directory and the file itself are accepted
+	for _, path := range []string{dir, filepath.Join(dir, "config.json")} {
+		keychain := &dockerConfigKeychain{path: path}
+
+		repo, err := name.NewRepository("registry.example.com/team/app")
+		if err != nil {
+			t.Fatalf("Failed to parse repository: %v", err)
+		}
+		authenticator, err := keychain.Resolve(repo)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		cfg, err := authenticator.Authorization()
+		if err != nil {
+			t.Fatalf("Failed to get authorization: %v", err)
+		}
+		if cfg.Username != "ci-user" || cfg.Password != "ci-pass" {
+			t.Errorf("Expected ci-user/ci-pass, got %s/%s", cfg.Username, cfg.Password)
+		}
+
+		other, err := name.NewRepository("other.example.com/app")
+		if err != nil {
+			t.Fatalf("Failed to parse repository: %v", err)
+		}
+		authenticator, err = keychain.Resolve(other)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if authenticator != authn.Anonymous {
+			t.Errorf("Expected anonymous access for unknown registry, got %v", authenticator)
+		}
+	}
+}
+
+func TestDockerConfigKeychain_MissingFile(t *testing.T) {
+	keychain := &dockerConfigKeychain{path: filepath.Join(t.TempDir(), "missing.json")}
+
+	repo, err := name.NewRepository("registry.example.com/team/app")
+	if err != nil {
+		t.Fatalf("Failed to parse repository: %v", err)
+	}
+	if _, err := keychain.Resolve(repo); err == nil {
+		t.Fatal("Expected error for missing docker config")
+	}
+}
+
+// installTestCredentialHelper puts a docker-credential-<name> script on the PATH
+// that returns the given credentials for registry.example.com
+func installTestCredentialHelper(t *testing.T, helper, username, secret string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	script := `#!/bin/sh
+read server
+if [ "$server" = "registry.example.com" ]; then
+  echo '{"ServerURL":"registry.example.com","Username":"` + username + `","Secret":"` + secret + `"}'
+else
+  echo "credentials not found in native keychain"
+  exit 1
+fi
+`
+	if err := os.WriteFile(filepath.Join(dir, "docker-credential-"+helper), []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write credential helper: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestCredentialHelperKeychain(t *testing.T) {
+	installTestCredentialHelper(t, "imgextest", "helper-user", "helper-pass")
+	keychain := &credentialHelperKeychain{helper: "imgextest"}
+
+	repo, err := name.NewRepository("registry.example.com/team/app")
+	if err != nil {
+		t.Fatalf("Failed to parse repository: %v", err)
+	}
+	authenticator, err := keychain.Resolve(repo)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	cfg, err := authenticator.Authorization()
+	if err != nil {
+		t.Fatalf("Failed to get authorization: %v", err)
+	}
+	if cfg.Username != "helper-user" || cfg.Password != "helper-pass" {
+		t.Errorf("Expected helper-user/helper-pass, got %s/%s", cfg.Username, cfg.Password)
+	}
+
+	other, err := name.NewRepository("other.example.com/app")
+	if err != nil {
+		t.Fatalf("Failed to parse repository: %v", err)
+	}
+	authenticator, err = keychain.Resolve(other)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if authenticator != authn.Anonymous {
+		t.Errorf("Expected anonymous access when helper has no credentials, got %v", authenticator)
+	}
+}
+
+func TestDockerConfigKeychain_MissingHelper(t *testing.T) {
+	dir := t.TempDir()
+	config := `{"credHelpers": {"registry.example.com": "imgex-missing-helper"}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatalf("Failed to write docker config: %v", err)
+	}
+
+	repo, err := name.NewRepository("registry.example.com/team/app")
+	if err != nil {
+		t.Fatalf("Failed to parse repository: %v", err)
+	}
+	keychain := &dockerConfigKeychain{path: dir}
+	_, err = keychain.Resolve(repo)
+	if err == nil || !strings.Contains(err.Error(), "docker-credential-imgex-missing-helper") {
+		t.Fatalf("Expected missing helper error, got %v", err)
+	}
+}
+
+func TestAnonymousAuthSkipsBrokenHelper(t *testing.T) {
+	host := newTestRegistry(t)
+	imageRef := host + "/test/public:latest"
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "hello", content: "world"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	pushTestImage(t, imageRef, image)
+
+	dir := t.TempDir()
+	config := `{"credsStore": "imgex-missing-helper"}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatalf("Failed to write docker config: %v", err)
+	}
+
+	if _, err := NewImageExporter(WithDockerConfig(dir)).GetImageConfig(imageRef, nil); err == nil {
+		t.Fatal("Expected the broken credential helper to fail the default lookup")
+	}
+	if _, err := NewImageExporter(WithDockerConfig(dir), WithAnonymousAuth()).GetImageConfig(imageRef, nil); err != nil {
+		t.Errorf("Expected anonymous access to skip the helper, got %v", err)
+	}
+}
+
+func TestDockerConfigKeychain_CredHelpers(t *testing.T) {
+	installTestCredentialHelper(t, "imgextest", "cfg-user", "cfg-pass")
+
+	dir := t.TempDir()
+	config := `{"credHelpers": {"registry.example.com": "imgextest"}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatalf("Failed to write docker config: %v", err)
+	}
+
+	repo, err := name.NewRepository("registry.example.com/team/app")
+	if err != nil {
+		t.Fatalf("Failed to parse repository: %v", err)
+	}
+	authenticator, err := (&dockerConfigKeychain{path: dir}).Resolve(repo)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	cfg, err := authenticator.Authorization()
+	if err != nil {
+		t.Fatalf("Failed to get authorization: %v", err)
+	}
+	if cfg.Username != "cfg-user" || cfg.Password != "cfg-pass" {
+		t.Errorf("Expected cfg-user/cfg-pass, got %s/%s", cfg.Username, cfg.Password)
+	}
+}
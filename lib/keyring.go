@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// KeyringMode selects whether Login stores credentials in the OS keyring
+type KeyringMode string
+
+const (
+	// KeyringAuto stores credentials in the OS keyring when its credential helper
+	// is installed and falls back to the docker config file otherwise (default)
+	KeyringAuto KeyringMode = ""
+
+	// KeyringAlways stores credentials in the OS keyring and fails when no
+	// keyring credential helper is installed
+	KeyringAlways KeyringMode = "always"
+
+	// KeyringNever stores credentials in the docker config file
+	KeyringNever KeyringMode = "never"
+)
+
+// ParseKeyringMode converts a mode name ("auto", "always", "never") into a KeyringMode
+func ParseKeyringMode(mode string) (KeyringMode, error) {
+	switch mode {
+	case "", "auto":
+		return KeyringAuto, nil
+	case "always":
+		return KeyringAlways, nil
+	case "never":
+		return KeyringNever, nil
+	default:
+		return "", fmt.Errorf("unknown keyring mode %q", mode)
+	}
+}
+
+// WithKeyring selects whether Login stores credentials in the OS keyring
+// (macOS Keychain, Windows Credential Manager or the Secret Service on Linux).
+//
+// The keyring is reached through the docker credential helper for the platform
+// (docker-credential-osxkeychain, -wincred, -secretservice or -pass), which is
+// recorded in credHelpers for the registry so that later lookups find it. It
+// only applies when the docker config does not already name a credential store.
+func WithKeyring(mode KeyringMode) ExporterOption {
+	return func(e *imageExporter) {
+		e.keyring = mode
+	}
+}
+
+// keyringHelpers returns the credential helpers backed by an OS keyring on a
+// platform, in order of preference
+func keyringHelpers(goos string) []string {
+	switch goos {
+	case "darwin":
+		return []string{"osxkeychain"}
+	case "windows":
+		return []string{"wincred"}
+	case "linux", "freebsd", "openbsd", "netbsd":
+		// pass keeps GPG-encrypted files and is used where no Secret Service runs
+		return []string{"secretservice", "pass"}
+	default:
+		return nil
+	}
+}
+
+// keyringHelper returns the installed keyring credential helper for this host,
+// or an empty string if there is none
+func keyringHelper() string {
+	for _, helper := range keyringHelpers(runtime.GOOS) {
+		if _, err := exec.LookPath(credentialHelperPrefix + helper); err == nil {
+			return helper
+		}
+	}
+	return ""
+}
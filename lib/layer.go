@@ -0,0 +1,110 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+// OpenLayer returns the raw layer blob of an image, exactly as stored in the registry
+// (usually gzip or zstd compressed), together with its descriptor from the manifest.
+//
+// This is meant for custom pipelines that store or analyze layers themselves while
+// reusing the exporter's authentication, proxy, retry and blob cache settings. For
+// a multi-arch index, the manifests of every platform are searched for the layer.
+// The stream is verified against the digest as it is read: a mismatch is returned
+// as an error from the final Read.
+//
+// Parameters:
+//   - imageRef: Docker image reference (e.g., "nginx:latest", "registry.com/org/image@sha256:...")
+//   - digest: Layer digest (e.g., "sha256:4c5f..."), as listed by LayerHistory
+//   - auth: Optional authentication configuration for private registries
+//
+// Returns:
+//   - io.ReadCloser: The compressed layer blob; the caller must close it
+//   - v1.Descriptor: The layer descriptor (media type, size, digest, annotations, URLs)
+//   - error: The digest is not a layer of the image, or any error reaching the registry
+//
+// Example:
+//
+//	exporter := NewImageExporter()
+//	history, err := exporter.LayerHistory("nginx:alpine", nil)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	blob, desc, err := exporter.OpenLayer("nginx:alpine", history[0].Digest, nil)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	defer blob.Close()
+//	fmt.Println(desc.MediaType, desc.Size)
+func (e *imageExporter) OpenLayer(imageRef string, digest string, auth *AuthConfig) (io.ReadCloser, v1.Descriptor, error) {
+	hash, err := v1.NewHash(digest)
+	if err != nil {
+		return nil, v1.Descriptor{}, fmt.Errorf("invalid layer digest %s: %w", digest, err)
+	}
+	ref, err := e.parseReference(imageRef)
+	if err != nil {
+		return nil, v1.Descriptor{}, fmt.Errorf("failed to parse image reference %s: %w", imageRef, err)
+	}
+
+	root, err := e.getManifest(ref, auth)
+	if err != nil {
+		return nil, v1.Descriptor{}, fmt.Errorf("failed to fetch image %s: %w", imageRef, err)
+	}
+	if err := e.verifySignatures(ref, root.Digest, auth); err != nil {
+		return nil, v1.Descriptor{}, err
+	}
+
+	var images []v1.Image
+	if root.MediaType.IsIndex() {
+		index, err := root.ImageIndex()
+		if err != nil {
+			return nil, v1.Descriptor{}, fmt.Errorf("failed to read index %s: %w", imageRef, err)
+		}
+		manifest, err := index.IndexManifest()
+		if err != nil {
+			return nil, v1.Descriptor{}, fmt.Errorf("failed to read index %s: %w", imageRef, err)
+		}
+		for _, child := range manifest.Manifests {
+			if !child.MediaType.IsImage() {
+				continue
+			}
+			image, err := index.Image(child.Digest)
+			if err != nil {
+				return nil, v1.Descriptor{}, fmt.Errorf("failed to fetch manifest %s: %w", child.Digest, err)
+			}
+			images = append(images, image)
+		}
+	} else {
+		image, err := root.Image()
+		if err != nil {
+			return nil, v1.Descriptor{}, fmt.Errorf("failed to read image %s: %w", imageRef, err)
+		}
+		images = append(images, image)
+	}
+
+	for _, image := range images {
+		manifest, err := image.Manifest()
+		if err != nil {
+			return nil, v1.Descriptor{}, fmt.Errorf("failed to get manifest: %w", err)
+		}
+		for _, desc := range manifest.Layers {
+			if desc.Digest != hash {
+				continue
+			}
+			layer, err := image.LayerByDigest(hash)
+			if err != nil {
+				return nil, v1.Descriptor{}, fmt.Errorf("failed to get layer %s: %w", hash, err)
+			}
+			blob, err := e.layerBlob(layer)
+			if err != nil {
+				return nil, v1.Descriptor{}, fmt.Errorf("failed to open layer %s: %w", hash, err)
+			}
+			return blob, desc, nil
+		}
+	}
+
+	return nil, v1.Descriptor{}, fmt.Errorf("layer %s not found in image %s", hash, imageRef)
+}
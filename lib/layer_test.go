@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// readLayerBlob reads the compressed blob of a test layer
+func readLayerBlob(t *testing.T, layer v1.Layer) []byte {
+	t.Helper()
+
+	blob, err := layer.Compressed()
+	if err != nil {
+		t.Fatalf("Failed to open layer: %v", err)
+	}
+	defer blob.Close()
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatalf("Failed to read layer: %v", err)
+	}
+	return data
+}
+
+func TestOpenLayer(t *testing.T) {
+	base := newTestLayer(t, testEntry{name: "base.txt", content: "base"})
+	top := newTestLayer(t, testEntry{name: "top.txt", content: "top"})
+	image, err := mutate.AppendLayers(empty.Image, base, top)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/layers:latest"
+	pushTestImage(t, imageRef, image)
+
+	for _, exporter := range []ImageExporter{NewImageExporter(), NewImageExporter(WithCache(t.TempDir()))} {
+		digest, err := top.Digest()
+		if err != nil {
+			t.Fatalf("Failed to get layer digest: %v", err)
+		}
+		blob, desc, err := exporter.OpenLayer(imageRef, digest.String(), nil)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		data, err := io.ReadAll(blob)
+		blob.Close()
+		if err != nil {
+			t.Fatalf("Failed to read layer: %v", err)
+		}
+
+		if !bytes.Equal(data, readLayerBlob(t, top)) {
+			t.Error("Expected the compressed layer blob")
+		}
+		mediaType, _ := top.MediaType()
+		if desc.Digest != digest || desc.Size != int64(len(data)) || desc.MediaType != mediaType {
+			t.Errorf("Expected descriptor %s (%d bytes, %s), got %+v", digest, len(data), mediaType, desc)
+		}
+	}
+
+	exporter := NewImageExporter()
+	missing := "sha256:" + strings.Repeat("0", 64)
+	if _, _, err := exporter.OpenLayer(imageRef, missing, nil); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+	if _, _, err := exporter.OpenLayer(imageRef, "not-a-digest", nil); err == nil || !strings.Contains(err.Error(), "invalid layer digest") {
+		t.Errorf("Expected an invalid digest error, got %v", err)
+	}
+}
+
+func TestOpenLayerSearchesIndex(t *testing.T) {
+	amd64Layer := newTestLayer(t, testEntry{name: "arch", content: "amd64"})
+	arm64Layer := newTestLayer(t, testEntry{name: "arch", content: "arm64"})
+	var addenda []mutate.IndexAddendum
+	for arch, layer := range map[string]v1.Layer{"amd64": amd64Layer, "arm64": arm64Layer} {
+		image, err := mutate.AppendLayers(empty.Image, layer)
+		if err != nil {
+			t.Fatalf("Failed to build test image: %v", err)
+		}
+		addenda = append(addenda, mutate.IndexAddendum{
+			Add:        image,
+			Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: arch}},
+		})
+	}
+	index := mutate.AppendManifests(empty.Index, addenda...)
+
+	host := newTestRegistry(t)
+	ref, err := name.ParseReference(host + "/test/multiarch:latest")
+	if err != nil {
+		t.Fatalf("Failed to parse reference: %v", err)
+	}
+	if err := remote.WriteIndex(ref, index); err != nil {
+		t.Fatalf("Failed to push test index: %v", err)
+	}
+
+	digest, err := arm64Layer.Digest()
+	if err != nil {
+		t.Fatalf("Failed to get layer digest: %v", err)
+	}
+	blob, _, err := NewImageExporter().OpenLayer(ref.String(), digest.String(), nil)
+	if err != nil {
+		t.Fatalf("Expected the arm64 layer to be found through the index, got %v", err)
+	}
+	defer blob.Close()
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatalf("Failed to read layer: %v", err)
+	}
+	if !bytes.Equal(data, readLayerBlob(t, arm64Layer)) {
+		t.Error("Expected the arm64 layer blob")
+	}
+}
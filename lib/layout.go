@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+)
+
+// OCILayoutPrefix marks an image reference as an OCI image layout directory,
+// as written by buildkit (--output type=oci, extracted), skopeo (oci:) or by
+// extracting an 'imgex bundle-export' bundle:
+//
+//	oci:/path/to/layout      the only image of the layout
+//	oci:/path/to/layout:v1   the image whose org.opencontainers.image.ref.name is v1
+//
+// Like DockerArchivePrefix, such references are read locally wherever an image
+// configuration or filesystem is read. A multi-arch index in the layout is
+// resolved to the requested platform like one in a registry.
+const OCILayoutPrefix = "oci:"
+
+// ociLayoutImage loads an image from an OCI layout given as path[:tag]
+func ociLayoutImage(source string, platform *v1.Platform) (v1.Image, error) {
+	path, tag, hasTag := strings.Cut(source, ":")
+	if path == "" {
+		return nil, fmt.Errorf("missing layout path")
+	}
+	root, err := layout.ImageIndexFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := root.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []v1.Descriptor
+	for _, desc := range manifest.Manifests {
+		if !hasTag || desc.Annotations[annotationRefName] == tag || desc.Annotations[annotationContainerdName] == tag {
+			matches = append(matches, desc)
+		}
+	}
+	switch {
+	case len(matches) == 0 && hasTag:
+		return nil, fmt.Errorf("no image tagged %s in the layout", tag)
+	case len(matches) == 0:
+		return nil, fmt.Errorf("the layout holds no image")
+	case len(matches) > 1 && hasTag:
+		return nil, fmt.Errorf("%d images are tagged %s in the layout", len(matches), tag)
+	case len(matches) > 1:
+		return nil, fmt.Errorf("the layout holds %d images, add :<tag> to choose one", len(matches))
+	}
+
+	desc := matches[0]
+	if desc.MediaType.IsImage() {
+		image, err := root.Image(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkImagePlatform(image, platform); err != nil {
+			return nil, err
+		}
+		return image, nil
+	}
+	if !desc.MediaType.IsIndex() {
+		return nil, fmt.Errorf("unsupported media type %s", desc.MediaType)
+	}
+
+	index, err := root.ImageIndex(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	children, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	want := v1.Platform{OS: "linux", Architecture: "amd64"}
+	if platform != nil {
+		want = *platform
+	}
+	for _, child := range children.Manifests {
+		if child.MediaType.IsImage() && platformMatches(child.Platform, want) {
+			return index.Image(child.Digest)
+		}
+	}
+	return nil, fmt.Errorf("no child with platform %s in index %s", want.String(), desc.Digest)
+}
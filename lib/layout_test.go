@@ -0,0 +1,97 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// untarTestBundle extracts a bundle into a new directory, making it an OCI layout
+func untarTestBundle(t *testing.T, bundle []byte) string {
+	t.Helper()
+	dir := t.TempDir()
+	tr := tar.NewReader(bytes.NewReader(bundle))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return dir
+		}
+		if err != nil {
+			t.Fatalf("Failed to read bundle: %v", err)
+		}
+		target := filepath.Join(dir, header.Name)
+		if header.Typeflag == tar.TypeDir {
+			os.MkdirAll(target, 0o755)
+			continue
+		}
+		os.MkdirAll(filepath.Dir(target), 0o755)
+		data, _ := io.ReadAll(tr)
+		if err := os.WriteFile(target, data, 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", target, err)
+		}
+	}
+}
+
+func TestOCILayout(t *testing.T) {
+	host := newTestRegistry(t)
+	indexRef := host + "/test/multiarch:latest"
+	pushTestIndex(t, indexRef)
+	var bundle bytes.Buffer
+	if err := NewImageExporter().ExportBundle(indexRef, &bundle, nil, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	dir := untarTestBundle(t, bundle.Bytes())
+
+	// No registry is ever contacted
+	exporter := NewImageExporter(WithOffline())
+	var out bytes.Buffer
+	if err := exporter.ExportImageFilesystemToWriter(OCILayoutPrefix+dir, &out, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if files := readTestTar(t, out.Bytes()); files["bin/app"] != "amd64" {
+		t.Errorf("Expected the linux/amd64 filesystem by default, got %v", files)
+	}
+	out.Reset()
+	err := exporter.ExportImageFilesystemToWriterWithOptions(OCILayoutPrefix+dir+":latest", &out, nil, &ExportOptions{Platform: "linux/arm64"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if files := readTestTar(t, out.Bytes()); files["bin/app"] != "arm64" {
+		t.Errorf("Expected the linux/arm64 filesystem, got %v", files)
+	}
+
+	// A second image needs the tag to be chosen
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "etc/motd", content: "hello"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	path, err := layout.FromPath(dir)
+	if err != nil {
+		t.Fatalf("Failed to open layout: %v", err)
+	}
+	if err := path.AppendImage(image, layout.WithAnnotations(map[string]string{annotationRefName: "v2"})); err != nil {
+		t.Fatalf("Failed to append image: %v", err)
+	}
+	if _, err := exporter.GetImageConfig(OCILayoutPrefix+dir, nil); err == nil {
+		t.Error("Expected an error without a tag for a layout of several images")
+	}
+	content, _, err := exporter.OpenFile(OCILayoutPrefix+dir+":v2", "etc/motd", nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	data, _ := io.ReadAll(content)
+	content.Close()
+	if string(data) != "hello" {
+		t.Errorf("Expected hello, got %q", data)
+	}
+	if _, err := exporter.GetImageConfig(OCILayoutPrefix+dir+":v3", nil); err == nil {
+		t.Error("Expected an error for a missing tag")
+	}
+}
@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ErrLimitExceeded is returned by exports stopped by one of ExportOptions.Limits
+// or the limits of WithLimits
+var ErrLimitExceeded = errors.New("resource limit exceeded")
+
+// ExportLimits bounds the resources an export may use, so that a malicious
+// image (a decompression bomb, millions of files) fails the export instead of
+// exhausting the memory or disk of the host. Zero fields are unlimited.
+type ExportLimits struct {
+	// MaxLayerSize caps the uncompressed size of each layer
+	MaxLayerSize int64
+
+	// MaxOutputSize caps the bytes written to the destination, after compression
+	MaxOutputSize int64
+
+	// MaxFiles caps the number of entries in the flattened filesystem
+	MaxFiles int
+
+	// MaxFileSize caps the size of any single file of a layer
+	MaxFileSize int64
+}
+
+// WithLimits bounds every export and every other operation that flattens an
+// image filesystem (OpenFile, DiskUsage, secret scans, SBOM generation...),
+// for services exporting images they do not trust. ExportOptions.Limits still
+// apply on top: for each limit the stricter of the two wins, so a caller can
+// tighten but never lift the exporter's limits.
+//
+// Example:
+//
+//	exporter := NewImageExporter(WithLimits(ExportLimits{MaxLayerSize: 10 << 30, MaxFiles: 1_000_000}))
+func WithLimits(limits ExportLimits) ExporterOption {
+	return func(e *imageExporter) {
+		e.limits = limits
+	}
+}
+
+// exportLimits returns the limits of an export, the stricter of the
+// exporter's and those of opts
+func (e *imageExporter) exportLimits(opts *ExportOptions) ExportLimits {
+	limits := opts.Limits
+	limits.MaxLayerSize = stricterLimit(limits.MaxLayerSize, e.limits.MaxLayerSize)
+	limits.MaxOutputSize = stricterLimit(limits.MaxOutputSize, e.limits.MaxOutputSize)
+	limits.MaxFiles = stricterLimit(limits.MaxFiles, e.limits.MaxFiles)
+	limits.MaxFileSize = stricterLimit(limits.MaxFileSize, e.limits.MaxFileSize)
+	return limits
+}
+
+// stricterLimit returns the smaller of two limits, where zero is unlimited
+func stricterLimit[T int | int64](a, b T) T {
+	if a == 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// limitReader fails reads of a layer stream beyond its MaxLayerSize
+type limitReader struct {
+	io.ReadCloser
+	index int
+	limit int64
+	read  int64
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+	if r.read > r.limit {
+		return n, fmt.Errorf("layer %d is larger than %d bytes uncompressed: %w", r.index, r.limit, ErrLimitExceeded)
+	}
+	return n, err
+}
+
+// limitWriter fails writes that would take the output beyond MaxOutputSize,
+// writing nothing of them
+type limitWriter struct {
+	io.Writer
+	limit   int64
+	written int64
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if w.written+int64(len(p)) > w.limit {
+		return 0, fmt.Errorf("output is larger than %d bytes: %w", w.limit, ErrLimitExceeded)
+	}
+	n, err := w.Writer.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// dropSkippedFiles removes the files larger than ExportOptions.SkipFileSize
+// from the filesystem, with the hardlinks sharing their content, reporting
+// each path in a warning
+func (e *imageExporter) dropSkippedFiles(filesystem map[string]*fileEntry, opts *ExportOptions) {
+	var skipped []string
+	for p, entry := range filesystem {
+		if entry.skipped || (entry.header.Typeflag == tar.TypeLink && entry.inode != nil && entry.inode.skipped) {
+			skipped = append(skipped, p)
+		}
+	}
+	sort.Strings(skipped)
+	for _, p := range skipped {
+		e.warn(opts, Warning{
+			Code:    WarningFileSkipped,
+			Message: fmt.Sprintf("skipped %s, larger than %d bytes", p, opts.SkipFileSize),
+			Path:    p,
+		})
+		delete(filesystem, p)
+	}
+}
@@ -0,0 +1,154 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestExportLimits(t *testing.T) {
+	image, err := mutate.AppendLayers(empty.Image,
+		newTestLayer(t,
+			testEntry{name: "a", content: strings.Repeat("a", 64<<10)},
+			testEntry{name: "b", content: "b"},
+		),
+		newTestLayer(t,
+			testEntry{name: "c", content: "c"},
+			testEntry{name: "d", content: "d"},
+		),
+	)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/limits:latest"
+	pushTestImage(t, imageRef, image)
+
+	tests := []struct {
+		name   string
+		limits ExportLimits
+		fails  bool
+	}{
+		{"unlimited", ExportLimits{}, false},
+		{"large enough", ExportLimits{MaxLayerSize: 1 << 20, MaxOutputSize: 1 << 20, MaxFiles: 4, MaxFileSize: 64 << 10}, false},
+		{"layer size", ExportLimits{MaxLayerSize: 32 << 10}, true},
+		{"output size", ExportLimits{MaxOutputSize: 32 << 10}, true},
+		{"file count", ExportLimits{MaxFiles: 3}, true},
+		{"file size", ExportLimits{MaxFileSize: 1024}, true},
+	}
+	exporter := NewImageExporter()
+	for _, tt := range tests {
+		err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, io.Discard, nil, &ExportOptions{Limits: tt.limits})
+		if tt.fails && !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("Expected ErrLimitExceeded for the %s limit, got %v", tt.name, err)
+		}
+		if !tt.fails && err != nil {
+			t.Errorf("Expected no error with %s limits, got %v", tt.name, err)
+		}
+	}
+
+	// The output limit applies to the compressed archive
+	err = exporter.ExportImageFilesystemToWriterWithOptions(imageRef, io.Discard, nil, &ExportOptions{Compress: true, Limits: ExportLimits{MaxOutputSize: 32 << 10}})
+	if err != nil {
+		t.Errorf("Expected the compressed archive to fit, got %v", err)
+	}
+}
+
+func TestExportSkipFileSize(t *testing.T) {
+	image, err := mutate.AppendLayers(empty.Image,
+		newTestLayer(t,
+			testEntry{name: "etc/app.conf", content: "small"},
+			testEntry{name: "data/model", content: "small"},
+		),
+		newTestLayer(t,
+			testEntry{name: "data/model", content: strings.Repeat("m", 4096)},
+			testEntry{name: "data/model.link", typeflag: tar.TypeLink, linkname: "data/model"},
+		),
+	)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/skip:latest"
+	pushTestImage(t, imageRef, image)
+
+	var output bytes.Buffer
+	var warnings []Warning
+	opts := &ExportOptions{SkipFileSize: 1024, Warning: func(w Warning) { warnings = append(warnings, w) }}
+	exporter := NewImageExporter()
+	if err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &output, nil, opts); err != nil {
+		t.Fatalf("Expected large files to be skipped, got %v", err)
+	}
+	files := readTestTar(t, output.Bytes())
+	// The large file replaced the small one of the first layer, which must not reappear
+	for _, name := range []string{"data/model", "data/model.link"} {
+		if _, ok := files[name]; ok {
+			t.Errorf("Expected %s to be skipped", name)
+		}
+	}
+	if files["etc/app.conf"] != "small" {
+		t.Errorf("Expected etc/app.conf to be kept, got %q", files["etc/app.conf"])
+	}
+	var skipped []string
+	for _, w := range warnings {
+		if w.Code == WarningFileSkipped {
+			skipped = append(skipped, w.Path)
+		}
+	}
+	if strings.Join(skipped, ",") != "data/model,data/model.link" {
+		t.Errorf("Expected warnings for data/model and data/model.link, got %v", skipped)
+	}
+}
+
+func TestWithLimits(t *testing.T) {
+	image, err := mutate.AppendLayers(empty.Image,
+		newTestLayer(t, testEntry{name: "a", content: "a"}, testEntry{name: "b", content: "b"}),
+		newTestLayer(t, testEntry{name: "c", content: "c"}, testEntry{name: "d", content: "d"}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/limits:latest"
+	pushTestImage(t, imageRef, image)
+
+	// Operations flattening the filesystem outside of exports are bounded too
+	exporter := NewImageExporter(WithLimits(ExportLimits{MaxFiles: 3}))
+	if _, _, err := exporter.OpenFile(imageRef, "a", nil, nil); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Expected OpenFile to exceed the file limit, got %v", err)
+	}
+	if _, err := exporter.ScanSecrets(imageRef, nil, nil); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Expected ScanSecrets to exceed the file limit, got %v", err)
+	}
+
+	// Export options can tighten the exporter's limits but never lift them
+	err = exporter.ExportImageFilesystemToWriterWithOptions(imageRef, io.Discard, nil, &ExportOptions{Limits: ExportLimits{MaxFiles: 100}})
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Expected the exporter's file limit to apply, got %v", err)
+	}
+	exporter = NewImageExporter(WithLimits(ExportLimits{MaxFiles: 100}))
+	err = exporter.ExportImageFilesystemToWriterWithOptions(imageRef, io.Discard, nil, &ExportOptions{Limits: ExportLimits{MaxFiles: 3}})
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Expected the stricter file limit of the options to apply, got %v", err)
+	}
+	if err := exporter.ExportImageFilesystemToWriter(imageRef, io.Discard, nil); err != nil {
+		t.Errorf("Expected no error within the limits, got %v", err)
+	}
+
+	// Exports without options are bounded like the others
+	exporter = NewImageExporter(WithLimits(ExportLimits{MaxOutputSize: 1024}))
+	if err := exporter.ExportImageFilesystemToWriter(imageRef, io.Discard, nil); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Expected the output limit to fail ExportImageFilesystemToWriter, got %v", err)
+	}
+	output := filepath.Join(t.TempDir(), "out.tar")
+	if err := exporter.ExportImageFilesystem(imageRef, output, nil); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Expected the output limit to fail ExportImageFilesystem, got %v", err)
+	}
+}
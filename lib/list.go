@@ -0,0 +1,136 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// ListOptions controls paginated registry listings
+type ListOptions struct {
+	// PageSize is the number of entries requested per page (0 for the registry default).
+	// Registries may return fewer entries than requested.
+	PageSize int
+}
+
+// PageIterator walks a paginated registry listing one page at a time, following
+// the registry's continuation links so that huge listings never have to be held
+// in memory at once.
+type PageIterator struct {
+	hasNext func() bool
+	next    func(ctx context.Context) ([]string, error)
+}
+
+// HasNext reports whether another page is available
+func (it *PageIterator) HasNext() bool {
+	return it.hasNext()
+}
+
+// Next returns the next page of entries. It should only be called when HasNext
+// returns true.
+func (it *PageIterator) Next() ([]string, error) {
+	return it.next(context.Background())
+}
+
+// ListTags returns an iterator over the tags of a repository.
+//
+// The first page is fetched before returning, so errors such as a missing
+// repository or failed authentication are reported immediately.
+//
+// Parameters:
+//   - repository: Repository name (e.g., "nginx", "registry.com/org/image")
+//   - auth: Optional authentication configuration for private registries
+//   - opts: Optional pagination settings
+//
+// Example:
+//
+//	tags, err := exporter.ListTags("registry.com/org/image", nil, &ListOptions{PageSize: 500})
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	for tags.HasNext() {
+//	    page, err := tags.Next()
+//	    if err != nil {
+//	        log.Fatal(err)
+//	    }
+//	    for _, tag := range page {
+//	        fmt.Println(tag)
+//	    }
+//	}
+func (e *imageExporter) ListTags(repository string, auth *AuthConfig, opts *ListOptions) (*PageIterator, error) {
+	repo, err := e.parseRepository(repository)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse repository %s: %w", repository, err)
+	}
+
+	puller, err := remote.NewPuller(e.listOptions(auth, opts)...)
+	if err != nil {
+		return nil, err
+	}
+	lister, err := puller.Lister(context.Background(), repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags for %s: %w", repository, err)
+	}
+
+	return &PageIterator{
+		hasNext: lister.HasNext,
+		next: func(ctx context.Context) ([]string, error) {
+			page, err := lister.Next(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list tags for %s: %w", repository, err)
+			}
+			return page.Tags, nil
+		},
+	}, nil
+}
+
+// Catalog returns an iterator over the repositories hosted by a registry.
+//
+// Many public registries (including Docker Hub) do not expose the catalog
+// endpoint; in that case an error is returned.
+//
+// Parameters:
+//   - registry: Registry host (e.g., "registry.com", "localhost:5000")
+//   - auth: Optional authentication configuration for private registries
+//   - opts: Optional pagination settings
+func (e *imageExporter) Catalog(registry string, auth *AuthConfig, opts *ListOptions) (*PageIterator, error) {
+	reg, err := name.NewRegistry(registry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse registry %s: %w", registry, err)
+	}
+	if err := e.checkRepository(reg.RegistryStr()); err != nil {
+		return nil, err
+	}
+
+	puller, err := remote.NewPuller(e.listOptions(auth, opts)...)
+	if err != nil {
+		return nil, err
+	}
+	catalogger, err := puller.Catalogger(context.Background(), reg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list repositories in %s: %w", registry, err)
+	}
+
+	return &PageIterator{
+		hasNext: catalogger.HasNext,
+		next: func(ctx context.Context) ([]string, error) {
+			page, err := catalogger.Next(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list repositories in %s: %w", registry, err)
+			}
+			return page.Repos, nil
+		},
+	}, nil
+}
+
+// listOptions builds the registry client options for a paginated listing. The
+// page size is fixed when a client is created, so listings get their own client.
+func (e *imageExporter) listOptions(auth *AuthConfig, opts *ListOptions) []remote.Option {
+	options := e.baseRemoteOptions(auth)
+	if opts != nil && opts.PageSize > 0 {
+		options = append(options, remote.WithPageSize(opts.PageSize))
+	}
+	return options
+}
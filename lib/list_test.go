@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestListTags_Pagination(t *testing.T) {
+	layer := newTestLayer(t, testEntry{name: "file", content: "data"})
+	image, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+
+	host := newPaginatingTestRegistry(t)
+	var expected []string
+	for i := 0; i < 5; i++ {
+		tag := fmt.Sprintf("v%d", i)
+		pushTestImage(t, host+"/test/paged:"+tag, image)
+		expected = append(expected, tag)
+	}
+	pushTestImage(t, host+"/test/other:latest", image)
+
+	exporter := NewImageExporter()
+	tags, err := exporter.ListTags(host+"/test/paged", nil, &ListOptions{PageSize: 2})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var all []string
+	pages := 0
+	for tags.HasNext() {
+		page, err := tags.Next()
+		if err != nil {
+			t.Fatalf("Failed to fetch page %d: %v", pages, err)
+		}
+		if len(page) > 2 {
+			t.Errorf("Expected at most 2 tags per page, got %d", len(page))
+		}
+		all = append(all, page...)
+		pages++
+	}
+
+	sort.Strings(all)
+	if fmt.Sprint(all) != fmt.Sprint(expected) {
+		t.Errorf("Expected tags %v, got %v", expected, all)
+	}
+	if pages != 3 {
+		t.Errorf("Expected 3 pages, got %d", pages)
+	}
+
+	repos, err := exporter.Catalog(host, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var names []string
+	for repos.HasNext() {
+		page, err := repos.Next()
+		if err != nil {
+			t.Fatalf("Failed to fetch catalog page: %v", err)
+		}
+		names = append(names, page...)
+	}
+	sort.Strings(names)
+	if fmt.Sprint(names) != "[test/other test/paged]" {
+		t.Errorf("Expected both repositories, got %v", names)
+	}
+}
+
+// newPaginatingTestRegistry starts an in-memory registry that advertises the next
+// tags page with a Link header, as distribution-based registries do
+func newPaginatingTestRegistry(t *testing.T) string {
+	t.Helper()
+
+	inner := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		if !strings.HasSuffix(r.URL.Path, "/tags/list") || n == 0 {
+			inner.ServeHTTP(w, r)
+			return
+		}
+
+		recorder := httptest.NewRecorder()
+		inner.ServeHTTP(recorder, r)
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &list); err == nil && len(list.Tags) == n {
+			next := url.Values{"n": {strconv.Itoa(n)}, "last": {list.Tags[n-1]}}
+			w.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", r.URL.Path, next.Encode()))
+		}
+		for key, values := range recorder.Header() {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(recorder.Code)
+		w.Write(recorder.Body.Bytes())
+	}))
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse registry URL: %v", err)
+	}
+	return u.Host
+}
@@ -0,0 +1,417 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"gopkg.in/yaml.v3"
+)
+
+// Lockfile pins a list of image references to the digests they resolved to,
+// so that builds can use exactly the same images later. It is written by Lock
+// and read back with LoadLockfile.
+type Lockfile struct {
+	// SchemaVersion is the version of this JSON document (see SchemaVersion)
+	SchemaVersion int `json:"schema_version"`
+
+	// Images lists the locked images in the order they were given
+	Images []LockedImage `json:"images"`
+}
+
+// LockedImage is one image reference of a lockfile
+type LockedImage struct {
+	// Reference is the image reference as given, e.g. "alpine:3"
+	Reference string `json:"reference"`
+
+	// Repository is the fully-qualified repository of the reference
+	Repository string `json:"repository"`
+
+	// Digest is the digest of the manifest or index the reference pointed to
+	Digest string `json:"digest"`
+
+	// MediaType is the media type of that manifest or index
+	MediaType string `json:"media_type"`
+
+	// Size is the compressed size of the config and layers of an image; indexes
+	// report it for each platform instead
+	Size int64 `json:"size,omitempty"`
+
+	// Platform is the platform of an image (e.g. "linux/amd64"), if recorded
+	Platform string `json:"platform,omitempty"`
+
+	// Platforms lists the images of an index
+	Platforms []LockedPlatform `json:"platforms,omitempty"`
+}
+
+// LockedPlatform is one platform image of a locked index
+type LockedPlatform struct {
+	// Platform is the platform of the image, e.g. "linux/arm64/v8"
+	Platform string `json:"platform"`
+
+	// Digest is the digest of the platform's image manifest
+	Digest string `json:"digest"`
+
+	// Size is the compressed size of the config and layers of the image
+	Size int64 `json:"size"`
+}
+
+// Pinned returns the repository@digest reference of the locked image
+func (i *LockedImage) Pinned() string {
+	return i.Repository + "@" + i.Digest
+}
+
+// Pin returns the pinned repository@digest reference locked for imageRef, as
+// given to Lock, reporting whether the lockfile has it.
+func (l *Lockfile) Pin(imageRef string) (string, bool) {
+	for _, image := range l.Images {
+		if image.Reference == imageRef {
+			return image.Pinned(), true
+		}
+	}
+	return "", false
+}
+
+// EncodeYAML returns the lockfile as YAML, with the fields in the same order
+// and under the same names as the JSON document
+func (l *Lockfile) EncodeYAML() ([]byte, error) {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is YAML: decoding it into a node keeps the field order, and
+	// clearing the JSON styles prints it as a regular block document
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	clearYAMLStyle(&document)
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// clearYAMLStyle resets the style of a node tree to the block defaults; the
+// encoder still quotes strings that would read as other types
+func clearYAMLStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		clearYAMLStyle(child)
+	}
+}
+
+// LoadLockfile reads a lockfile written by 'imgex lock', as JSON or YAML.
+//
+// Parameters:
+//   - path: Path of the lockfile
+//
+// Returns:
+//   - *Lockfile: The locked images
+//   - error: The file could not be read or parsed, or has an unsupported schema version
+//
+// Example:
+//
+//	lock, err := LoadLockfile("images.lock.yaml")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	if pinned, ok := lock.Pin("alpine:3"); ok {
+//	    err = exporter.ExportImageFilesystem(pinned, "rootfs.tar", nil)
+//	}
+func LoadLockfile(path string) (*Lockfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lockfile: %w", err)
+	}
+	lock, err := parseLockfile(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse lockfile %s: %w", path, err)
+	}
+	return lock, nil
+}
+
+// parseLockfile decodes JSON or YAML lockfile contents
+func parseLockfile(data []byte) (*Lockfile, error) {
+	var document interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	normalized, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	var lock Lockfile
+	if err := json.Unmarshal(normalized, &lock); err != nil {
+		return nil, err
+	}
+	if lock.SchemaVersion != SchemaVersion {
+		return nil, fmt.Errorf("unsupported schema_version %d, expected %d", lock.SchemaVersion, SchemaVersion)
+	}
+	for _, image := range lock.Images {
+		if image.Reference == "" || image.Repository == "" || image.Digest == "" {
+			return nil, fmt.Errorf("image %q needs a reference, repository and digest", image.Reference)
+		}
+	}
+	return &lock, nil
+}
+
+// Lock resolves image references to the digests they currently point to and
+// returns them as a lockfile. Only manifests and image configs are fetched.
+//
+// For an index, every platform image is listed with its digest and size;
+// attestation manifests (platform unknown/unknown) are left out. Repeated
+// references are locked once.
+//
+// Parameters:
+//   - imageRefs: Image references to lock (e.g., "alpine:3")
+//   - auth: Optional authentication configuration for private registries
+//
+// Returns:
+//   - *Lockfile: The locked images, in the order given
+//   - error: The first reference that could not be resolved
+//
+// Example:
+//
+//	lock, err := exporter.Lock([]string{"alpine:3", "ghcr.io/org/app:v1"}, nil)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	data, _ := json.MarshalIndent(lock, "", "  ")
+//	os.WriteFile("images.lock.json", data, 0644)
+func (e *imageExporter) Lock(imageRefs []string, auth *AuthConfig) (*Lockfile, error) {
+	lock := &Lockfile{SchemaVersion: SchemaVersion, Images: []LockedImage{}}
+	seen := make(map[string]bool)
+	for _, imageRef := range imageRefs {
+		if seen[imageRef] {
+			continue
+		}
+		seen[imageRef] = true
+
+		image, err := e.lockImage(imageRef, auth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock %s: %w", imageRef, err)
+		}
+		e.log().Debug("image locked", "image", imageRef, "digest", image.Digest)
+		lock.Images = append(lock.Images, *image)
+	}
+	return lock, nil
+}
+
+// lockImage resolves one reference of a lockfile
+func (e *imageExporter) lockImage(imageRef string, auth *AuthConfig) (*LockedImage, error) {
+	ref, err := e.parseReference(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image reference: %w", err)
+	}
+	desc, err := e.getManifest(ref, auth)
+	if err != nil {
+		return nil, err
+	}
+	locked := &LockedImage{
+		Reference:  imageRef,
+		Repository: ref.Context().Name(),
+		Digest:     desc.Digest.String(),
+		MediaType:  string(desc.MediaType),
+	}
+
+	if !desc.MediaType.IsIndex() {
+		image, err := desc.Image()
+		if err != nil {
+			return nil, err
+		}
+		if locked.Size, err = imageSize(image); err != nil {
+			return nil, err
+		}
+		config, err := image.ConfigFile()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		if platform := config.Platform(); platform != nil && platform.OS != "" {
+			locked.Platform = platform.String()
+		}
+		return locked, nil
+	}
+
+	index, err := desc.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	for _, child := range manifest.Manifests {
+		if !child.MediaType.IsImage() || child.Platform == nil || child.Platform.OS == "unknown" {
+			continue
+		}
+		image, err := index.Image(child.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch manifest %s: %w", child.Digest, err)
+		}
+		size, err := imageSize(image)
+		if err != nil {
+			return nil, err
+		}
+		locked.Platforms = append(locked.Platforms, LockedPlatform{
+			Platform: child.Platform.String(),
+			Digest:   child.Digest.String(),
+			Size:     size,
+		})
+	}
+	return locked, nil
+}
+
+// imageSize returns the compressed size of the config and layers of an image
+func imageSize(image v1.Image) (int64, error) {
+	manifest, err := image.Manifest()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	size := manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+	return size, nil
+}
+
+// LockStatus classifies an image of a lockfile checked by VerifyLock
+type LockStatus string
+
+const (
+	// LockOK means the reference still resolves to the locked digest
+	LockOK LockStatus = "ok"
+
+	// LockMoved means the reference now resolves to another digest
+	LockMoved LockStatus = "moved"
+
+	// LockMissing means the locked digest no longer exists in its repository
+	LockMissing LockStatus = "missing"
+
+	// LockError means the image could not be checked, e.g. the tag is gone or
+	// the registry refused access
+	LockError LockStatus = "error"
+)
+
+// LockResult is the state of one locked image
+type LockResult struct {
+	// Reference is the image reference as locked
+	Reference string `json:"reference"`
+
+	// Locked is the locked digest
+	Locked string `json:"locked"`
+
+	// Current is the digest the reference resolves to now, if it resolves
+	Current string `json:"current,omitempty"`
+
+	// Status classifies the result
+	Status LockStatus `json:"status"`
+
+	// Error explains a missing or error status
+	Error string `json:"error,omitempty"`
+}
+
+// LockVerification is the result of VerifyLock
+type LockVerification struct {
+	// SchemaVersion is the version of this JSON document (see SchemaVersion)
+	SchemaVersion int `json:"schema_version"`
+
+	// Results lists every locked image, in lockfile order
+	Results []LockResult `json:"results"`
+}
+
+// OK reports whether every locked image is unchanged
+func (v *LockVerification) OK() bool {
+	for _, result := range v.Results {
+		if result.Status != LockOK {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyLock checks that every image of a lockfile is still pinned: its locked
+// digest still exists in the repository, and its reference still resolves to
+// that digest. Only HEAD requests are made where the registry supports them.
+//
+// Problems with individual images are reported in the results rather than
+// failing the verification.
+//
+// Parameters:
+//   - lock: The lockfile, e.g. from LoadLockfile
+//   - auth: Optional authentication configuration for private registries
+//
+// Returns:
+//   - *LockVerification: One result per locked image
+//   - error: If no lockfile is given
+//
+// Example:
+//
+//	lock, _ := LoadLockfile("images.lock.json")
+//	verification, err := exporter.VerifyLock(lock, nil)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	for _, result := range verification.Results {
+//	    fmt.Printf("%s %s\n", result.Status, result.Reference)
+//	}
+func (e *imageExporter) VerifyLock(lock *Lockfile, auth *AuthConfig) (*LockVerification, error) {
+	if lock == nil {
+		return nil, fmt.Errorf("no lockfile given")
+	}
+	verification := &LockVerification{SchemaVersion: SchemaVersion, Results: []LockResult{}}
+	for _, image := range lock.Images {
+		result := e.verifyLockedImage(image, auth)
+		e.log().Debug("locked image checked", "image", image.Reference, "status", result.Status)
+		verification.Results = append(verification.Results, result)
+	}
+	return verification, nil
+}
+
+// verifyLockedImage checks one image of a lockfile
+func (e *imageExporter) verifyLockedImage(image LockedImage, auth *AuthConfig) LockResult {
+	result := LockResult{Reference: image.Reference, Locked: image.Digest}
+	fail := func(status LockStatus, err error) LockResult {
+		result.Status, result.Error = status, err.Error()
+		return result
+	}
+
+	pinned, err := e.parseReference(image.Pinned())
+	if err != nil {
+		return fail(LockError, fmt.Errorf("invalid pinned reference %s: %w", image.Pinned(), err))
+	}
+	if _, err := remote.Head(pinned, e.remoteOptions(auth)...); err != nil {
+		if IsNotFound(err) {
+			return fail(LockMissing, fmt.Errorf("%s no longer exists", image.Pinned()))
+		}
+		// Some registries do not answer HEAD; fall back to fetching the manifest
+		if _, err := e.getManifest(pinned, auth); err != nil {
+			if IsNotFound(err) {
+				return fail(LockMissing, fmt.Errorf("%s no longer exists", image.Pinned()))
+			}
+			return fail(LockError, err)
+		}
+	}
+
+	current, err := e.ResolveDigest(image.Reference, auth)
+	if err != nil {
+		return fail(LockError, err)
+	}
+	result.Current = current
+	if current != image.Digest {
+		result.Status = LockMoved
+		return result
+	}
+	result.Status = LockOK
+	return result
+}
@@ -0,0 +1,153 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestLock(t *testing.T) {
+	host := newTestRegistry(t)
+	indexRef := host + "/test/multiarch:latest"
+	pushTestIndex(t, indexRef)
+
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "app", content: "v1"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	imageRef := host + "/test/app:v1"
+	pushTestImage(t, imageRef, image)
+	digest, err := image.Digest()
+	if err != nil {
+		t.Fatalf("Failed to get test digest: %v", err)
+	}
+
+	exporter := NewImageExporter()
+	lock, err := exporter.Lock([]string{imageRef, indexRef, imageRef}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(lock.Images) != 2 {
+		t.Fatalf("Expected 2 locked images, got %d", len(lock.Images))
+	}
+
+	locked := lock.Images[0]
+	if locked.Digest != digest.String() || locked.Repository != host+"/test/app" {
+		t.Errorf("Expected %s@%s, got %s", host+"/test/app", digest, locked.Pinned())
+	}
+	if locked.Size <= 0 || locked.Platforms != nil {
+		t.Errorf("Expected a size and no platforms for an image, got %+v", locked)
+	}
+
+	index := lock.Images[1]
+	if !reflect.DeepEqual([]string{index.Platforms[0].Platform, index.Platforms[1].Platform}, []string{"linux/amd64", "linux/arm64"}) {
+		t.Errorf("Expected both platforms, got %+v", index.Platforms)
+	}
+	for _, platform := range index.Platforms {
+		if platform.Digest == "" || platform.Size <= 0 {
+			t.Errorf("Expected a digest and size for %s, got %+v", platform.Platform, platform)
+		}
+	}
+
+	if pinned, ok := lock.Pin(imageRef); !ok || pinned != host+"/test/app@"+digest.String() {
+		t.Errorf("Expected %s to be pinned, got %q, %v", imageRef, pinned, ok)
+	}
+	if _, ok := lock.Pin("alpine:3"); ok {
+		t.Error("Expected alpine:3 not to be pinned")
+	}
+
+	if _, err := exporter.Lock([]string{host + "/test/missing:v1"}, nil); err == nil {
+		t.Error("Expected an error for a missing image")
+	}
+}
+
+func TestLockfileYAMLRoundTrip(t *testing.T) {
+	lock := &Lockfile{SchemaVersion: SchemaVersion, Images: []LockedImage{{
+		Reference:  "alpine:3",
+		Repository: "index.docker.io/library/alpine",
+		Digest:     "sha256:1234",
+		MediaType:  "application/vnd.oci.image.index.v1+json",
+		Platforms:  []LockedPlatform{{Platform: "linux/amd64", Digest: "sha256:5678", Size: 42}},
+	}}}
+	data, err := lock.EncodeYAML()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "images.lock.yaml")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write lockfile: %v", err)
+	}
+	loaded, err := LoadLockfile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(loaded, lock) {
+		t.Errorf("Expected %+v, got %+v", lock, loaded)
+	}
+
+	for _, invalid := range []string{
+		`{"schema_version": 2, "images": []}`,
+		`{"schema_version": 1, "images": [{"reference": "alpine:3"}]}`,
+	} {
+		if _, err := parseLockfile([]byte(invalid)); err == nil {
+			t.Errorf("Expected an error for %s", invalid)
+		}
+	}
+}
+
+func TestVerifyLock(t *testing.T) {
+	host := newTestRegistry(t)
+	first, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "app", content: "v1"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	second, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "app", content: "v2"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	pushTestImage(t, host+"/test/stable:v1", first)
+	pushTestImage(t, host+"/test/moving:latest", first)
+	pushTestImage(t, host+"/test/gone:v1", first)
+
+	exporter := NewImageExporter()
+	lock, err := exporter.Lock([]string{host + "/test/stable:v1", host + "/test/moving:latest", host + "/test/gone:v1"}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	pushTestImage(t, host+"/test/moving:latest", second)
+	lock.Images[2].Digest = "sha256:" + strings.Repeat("0", 64)
+	lock.Images = append(lock.Images, LockedImage{
+		Reference:  host + "/test/missing:v1",
+		Repository: host + "/test/stable",
+		Digest:     lock.Images[0].Digest,
+	})
+
+	verification, err := exporter.VerifyLock(lock, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var statuses []LockStatus
+	for _, result := range verification.Results {
+		statuses = append(statuses, result.Status)
+	}
+	if expected := []LockStatus{LockOK, LockMoved, LockMissing, LockError}; !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("Expected statuses %v, got %v", expected, statuses)
+	}
+	secondDigest, _ := second.Digest()
+	if moved := verification.Results[1]; moved.Current != secondDigest.String() {
+		t.Errorf("Expected the moved image to resolve to %s, got %s", secondDigest, moved.Current)
+	}
+	if verification.OK() {
+		t.Error("Expected the verification to report drift")
+	}
+
+	verification, err = exporter.VerifyLock(&Lockfile{Images: lock.Images[:1]}, nil)
+	if err != nil || !verification.OK() {
+		t.Errorf("Expected an unchanged lockfile to verify, got %+v, %v", verification, err)
+	}
+}
@@ -0,0 +1,48 @@
+package lib
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+// WithLogger sends diagnostic events to logger: manifest and config fetches,
+// blob cache hits and misses at debug level, and layer pulls and tar writing at
+// info level. Problems an export survives are still reported as warnings (see
+// WithWarnings), not logged. Without this option the exporter logs nothing.
+//
+// Example:
+//
+//	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+//	exporter := NewImageExporter(WithLogger(logger))
+func WithLogger(logger *slog.Logger) ExporterOption {
+	return func(e *imageExporter) {
+		e.logger = logger
+	}
+}
+
+// discardLogger is used when no logger is configured
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// log returns the configured logger, or one that discards everything
+func (e *imageExporter) log() *slog.Logger {
+	if e.logger == nil {
+		return discardLogger
+	}
+	return e.logger
+}
+
+// logManifest logs the digest and layer count of a fetched image
+func (e *imageExporter) logManifest(imageRef string, image v1.Image) {
+	if !e.log().Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	digest, _ := image.Digest()
+	manifest, err := image.Manifest()
+	if err != nil {
+		return
+	}
+	e.log().Debug("manifest fetched", "image", imageRef, "digest", digest.String(),
+		"layers", len(manifest.Layers))
+}
@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// logMessages returns the messages of JSON log lines, in order
+func logMessages(t *testing.T, data []byte) []string {
+	t.Helper()
+
+	var messages []string
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var record struct {
+			Msg string `json:"msg"`
+		}
+		if err := decoder.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Failed to decode log line: %v", err)
+		}
+		messages = append(messages, record.Msg)
+	}
+	return messages
+}
+
+func TestWithLogger(t *testing.T) {
+	layer := newTestLayer(t, testEntry{name: "etc/hostname", content: "test"})
+	image, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/logged:latest"
+	pushTestImage(t, imageRef, image)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	exporter := NewImageExporter(WithLogger(logger), WithCache(t.TempDir()))
+
+	for range 2 {
+		if _, err := exporter.GetImageConfig(imageRef, nil); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, io.Discard, nil, &ExportOptions{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	counts := map[string]int{}
+	for _, msg := range logMessages(t, buf.Bytes()) {
+		counts[msg]++
+	}
+	for msg, expected := range map[string]int{
+		"fetching manifest":      3,
+		"manifest fetched":       3,
+		"image config fetched":   2,
+		"blob cache miss":        2, // the config, then the layer
+		"blob cache hit":         1, // the config again
+		"pulling layer":          1,
+		"layer applied":          1,
+		"writing filesystem tar": 1,
+		"filesystem tar written": 1,
+	} {
+		if counts[msg] != expected {
+			t.Errorf("Expected %d %q events, got %d", expected, msg, counts[msg])
+		}
+	}
+
+	// Info level leaves out fetches and cache activity
+	buf.Reset()
+	logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	exporter = NewImageExporter(WithLogger(logger))
+	if err := exporter.ExportImageFilesystemToWriter(imageRef, io.Discard, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	messages := logMessages(t, buf.Bytes())
+	expected := []string{"pulling layer", "layer applied", "writing filesystem tar", "filesystem tar written"}
+	if len(messages) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, messages)
+	}
+	for i := range expected {
+		if messages[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, messages)
+			break
+		}
+	}
+}
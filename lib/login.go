@@ -0,0 +1,231 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/docker/docker-credential-helpers/client"
+	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// Login validates credentials against a registry and stores them for later use.
+//
+// Credentials are checked by authenticating to the registry's /v2/ endpoint,
+// then saved where the exporter reads credentials from: the docker config.json
+// (WithDockerConfig, $DOCKER_CONFIG or ~/.docker), honoring its credsStore and
+// credHelpers like 'docker login' does, or the helper set with WithCredentialHelper.
+// When the docker config names no store, credentials go to the OS keyring if its
+// credential helper is installed (see WithKeyring) rather than into the file.
+//
+// Parameters:
+//   - registry: Registry host (e.g. "ghcr.io"); empty means Docker Hub
+//   - auth: Credentials to validate and store; Registry is ignored
+//
+// Returns:
+//   - error: Any error encountered, including rejected credentials
+//
+// Example:
+//
+//	exporter := NewImageExporter()
+//	err := exporter.Login("registry.example.com", &AuthConfig{Username: "ci", Password: token})
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+func (e *imageExporter) Login(registry string, auth *AuthConfig) error {
+	if auth == nil {
+		return fmt.Errorf("no credentials given")
+	}
+	reg, err := loginRegistry(registry)
+	if err != nil {
+		return err
+	}
+	if err := e.checkRepository(reg.RegistryStr()); err != nil {
+		return err
+	}
+
+	if err := e.checkCredentials(reg, auth.authenticator()); err != nil {
+		return err
+	}
+
+	creds := types.AuthConfig{
+		Username:      auth.Username,
+		Password:      auth.Password,
+		IdentityToken: auth.IdentityToken,
+		RegistryToken: auth.RegistryToken,
+		ServerAddress: credentialKey(reg),
+	}
+
+	if helper, ok := e.keychain.(*credentialHelperKeychain); ok {
+		username, secret := creds.Username, creds.Password
+		if creds.IdentityToken != "" {
+			username, secret = "<token>", creds.IdentityToken
+		}
+		program := credentialHelperPrefix + helper.helper
+		err := client.Store(client.NewShellProgramFunc(program), &credentials.Credentials{
+			ServerURL: creds.ServerAddress,
+			Username:  username,
+			Secret:    secret,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to store credentials with %s: %w", program, err)
+		}
+		return nil
+	}
+
+	cf, err := e.loginConfigFile()
+	if err != nil {
+		return err
+	}
+	if cf.CredentialHelpers[creds.ServerAddress] == "" && cf.CredentialsStore == "" && e.keyring != KeyringNever {
+		helper := keyringHelper()
+		if helper == "" && e.keyring == KeyringAlways {
+			return fmt.Errorf("no OS keyring credential helper is installed (looked for %s%v)", credentialHelperPrefix, keyringHelpers(runtime.GOOS))
+		}
+		if helper != "" {
+			if cf.CredentialHelpers == nil {
+				cf.CredentialHelpers = make(map[string]string)
+			}
+			cf.CredentialHelpers[creds.ServerAddress] = helper
+			// Drop any plaintext copy left by an earlier login
+			delete(cf.GetAuthConfigs(), creds.ServerAddress)
+		}
+	}
+	if cf.CredentialHelpers[creds.ServerAddress] == "" && cf.CredentialsStore == "" {
+		// No credential store is configured, so the secret ends up in the file itself
+		cf.GetAuthConfigs()[creds.ServerAddress] = creds
+		if creds.Password != "" {
+			e.warn(nil, Warning{
+				Code:    WarningPlaintextCredentials,
+				Message: fmt.Sprintf("credentials are stored unencrypted in %s; install an OS keyring credential helper to avoid this", cf.Filename),
+			})
+		}
+	} else if err := cf.GetCredentialsStore(creds.ServerAddress).Store(creds); err != nil {
+		return fmt.Errorf("failed to store credentials for %s: %w", creds.ServerAddress, err)
+	}
+	if err := cf.Save(); err != nil {
+		return fmt.Errorf("failed to save %s: %w", cf.Filename, err)
+	}
+	return nil
+}
+
+// Logout removes stored credentials for a registry.
+//
+// Parameters:
+//   - registry: Registry host (e.g. "ghcr.io"); empty means Docker Hub
+//
+// Returns:
+//   - error: Any error encountered, including when no credentials were stored
+func (e *imageExporter) Logout(registry string) error {
+	reg, err := loginRegistry(registry)
+	if err != nil {
+		return err
+	}
+	key := credentialKey(reg)
+
+	if helper, ok := e.keychain.(*credentialHelperKeychain); ok {
+		program := credentialHelperPrefix + helper.helper
+		if err := client.Erase(client.NewShellProgramFunc(program), key); err != nil {
+			if credentials.IsErrCredentialsNotFound(err) {
+				return fmt.Errorf("not logged in to %s", key)
+			}
+			return fmt.Errorf("failed to erase credentials with %s: %w", program, err)
+		}
+		return nil
+	}
+
+	cf, err := e.loginConfigFile()
+	if err != nil {
+		return err
+	}
+	store := cf.GetCredentialsStore(key)
+	existing, err := store.Get(key)
+	if err != nil {
+		return fmt.Errorf("failed to read credentials for %s: %w", key, err)
+	}
+	if existing == (types.AuthConfig{ServerAddress: existing.ServerAddress}) {
+		return fmt.Errorf("not logged in to %s", key)
+	}
+	if err := store.Erase(key); err != nil {
+		return fmt.Errorf("failed to erase credentials for %s: %w", key, err)
+	}
+	if err := cf.Save(); err != nil {
+		return fmt.Errorf("failed to save %s: %w", cf.Filename, err)
+	}
+	return nil
+}
+
+// loginRegistry parses a registry argument, defaulting to Docker Hub
+func loginRegistry(registry string) (name.Registry, error) {
+	if registry == "" {
+		registry = name.DefaultRegistry
+	}
+	reg, err := name.NewRegistry(normalizeRegistry(registry))
+	if err != nil {
+		return name.Registry{}, fmt.Errorf("invalid registry %q: %w", registry, err)
+	}
+	return reg, nil
+}
+
+// credentialKey returns the config.json key for a registry, using the legacy
+// URL for Docker Hub like the docker CLI does
+func credentialKey(reg name.Registry) string {
+	if reg.RegistryStr() == name.DefaultRegistry {
+		return authn.DefaultAuthKey
+	}
+	return reg.RegistryStr()
+}
+
+// checkCredentials authenticates to the registry's /v2/ endpoint, exchanging
+// the credentials for a token first on registries that use token auth
+func (e *imageExporter) checkCredentials(reg name.Registry, auth authn.Authenticator) error {
+	ctx := context.Background()
+	rt, err := transport.NewWithContext(ctx, reg, auth, e.httpTransport, []string{reg.Scope(transport.PullScope)})
+	if err != nil {
+		return fmt.Errorf("login to %s failed: %w", reg.RegistryStr(), err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s/v2/", reg.Scheme(), reg.RegistryStr()), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Transport: rt}).Do(req)
+	if err != nil {
+		return fmt.Errorf("login to %s failed: %w", reg.RegistryStr(), err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("login to %s failed: registry returned %s", reg.RegistryStr(), resp.Status)
+	}
+	return nil
+}
+
+// loginConfigFile loads the docker config the exporter reads credentials from,
+// creating an empty one if it does not exist yet
+func (e *imageExporter) loginConfigFile() (*configfile.ConfigFile, error) {
+	path := filepath.Join(config.Dir(), config.ConfigFileName)
+	if k, ok := e.keychain.(*dockerConfigKeychain); ok && k.path != "" {
+		path = k.path
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			path = filepath.Join(path, config.ConfigFileName)
+		}
+	}
+
+	cf, err := (&dockerConfigKeychain{path: path}).load()
+	if err == nil {
+		return cf, nil
+	}
+	if _, statErr := os.Stat(path); !os.IsNotExist(statErr) {
+		return nil, err
+	}
+	return configfile.New(path), nil
+}
@@ -0,0 +1,233 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// newBasicAuthTestRegistry starts an in-memory registry that requires basic auth
+func newBasicAuthTestRegistry(t *testing.T, user, pass string) string {
+	t.Helper()
+
+	inner := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != user || p != pass {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		inner.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse registry URL: %v", err)
+	}
+	return u.Host
+}
+
+func TestLoginLogout(t *testing.T) {
+	host := newBasicAuthTestRegistry(t, "ci", "secret")
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	var warnings WarningCollector
+	exporter := NewImageExporter(WithDockerConfig(configPath), WithKeyring(KeyringNever), WithWarnings(warnings.Collect))
+
+	err := exporter.Login(host, &AuthConfig{Username: "ci", Password: "wrong"})
+	if err == nil {
+		t.Fatal("Expected rejected credentials to fail login")
+	}
+	if !strings.Contains(err.Error(), "login to") {
+		t.Errorf("Expected login error, got %v", err)
+	}
+
+	if err := exporter.Login(host, &AuthConfig{Username: "ci", Password: "secret"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The stored credentials are picked up by later exporters
+	repo, err := name.NewRepository(host + "/team/app")
+	if err != nil {
+		t.Fatalf("Failed to parse repository: %v", err)
+	}
+	authenticator, err := (&dockerConfigKeychain{path: configPath}).Resolve(repo)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	cfg, err := authenticator.Authorization()
+	if err != nil {
+		t.Fatalf("Failed to get authorization: %v", err)
+	}
+	if cfg.Username != "ci" || cfg.Password != "secret" {
+		t.Errorf("Expected ci/secret, got %s/%s", cfg.Username, cfg.Password)
+	}
+	if counts := warningCodes(warnings.Warnings()); counts[WarningPlaintextCredentials] != 1 {
+		t.Errorf("Expected a plaintext credentials warning, got %v", warnings.Warnings())
+	}
+
+	if err := exporter.Logout(host); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := exporter.Logout(host); err == nil || !strings.Contains(err.Error(), "not logged in") {
+		t.Errorf("Expected not logged in error, got %v", err)
+	}
+}
+
+// installTestKeyringHelper puts a docker-credential-<name> script on the PATH
+// that keeps a single credential in a file, standing in for an OS keyring
+func installTestKeyringHelper(t *testing.T, helper string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	store := filepath.Join(dir, "stored.json")
+	script := `#!/bin/sh
+case "$1" in
+store) cat > '` + store + `' ;;
+get) [ -f '` + store + `' ] && cat '` + store + `' || { echo "credentials not found in native keychain"; exit 1; } ;;
+erase) [ -f '` + store + `' ] && rm '` + store + `' || { echo "credentials not found in native keychain"; exit 1; } ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "docker-credential-"+helper), []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write credential helper: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return store
+}
+
+func TestLoginKeyring(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("keyring helper script requires a Linux shell")
+	}
+	host := newBasicAuthTestRegistry(t, "ci", "secret")
+	configPath := filepath.Join(t.TempDir(), "config.json")
+
+	// Without a keyring helper installed, KeyringAlways refuses to fall back to the file
+	t.Setenv("PATH", t.TempDir())
+	exporter := NewImageExporter(WithDockerConfig(configPath), WithKeyring(KeyringAlways))
+	err := exporter.Login(host, &AuthConfig{Username: "ci", Password: "secret"})
+	if err == nil || !strings.Contains(err.Error(), "keyring") {
+		t.Fatalf("Expected missing keyring error, got %v", err)
+	}
+
+	t.Setenv("PATH", "/bin:/usr/bin")
+	store := installTestKeyringHelper(t, "secretservice")
+	var warnings WarningCollector
+	exporter = NewImageExporter(WithDockerConfig(configPath), WithWarnings(warnings.Collect))
+	if err := exporter.Login(host, &AuthConfig{Username: "ci", Password: "secret"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(warnings.Warnings()) != 0 {
+		t.Errorf("Expected no warnings, got %v", warnings.Warnings())
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to read docker config: %v", err)
+	}
+	if strings.Contains(string(data), "auth\"") || !strings.Contains(string(data), `"secretservice"`) {
+		t.Errorf("Expected the registry to use the keyring helper and no stored secret, got %s", data)
+	}
+	if stored, err := os.ReadFile(store); err != nil || !strings.Contains(string(stored), "secret") {
+		t.Errorf("Expected the secret in the keyring, got %q (%v)", stored, err)
+	}
+
+	repo, err := name.NewRepository(host + "/team/app")
+	if err != nil {
+		t.Fatalf("Failed to parse repository: %v", err)
+	}
+	authenticator, err := (&dockerConfigKeychain{path: configPath}).Resolve(repo)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	cfg, err := authenticator.Authorization()
+	if err != nil {
+		t.Fatalf("Failed to get authorization: %v", err)
+	}
+	if cfg.Username != "ci" || cfg.Password != "secret" {
+		t.Errorf("Expected ci/secret from the keyring, got %s/%s", cfg.Username, cfg.Password)
+	}
+
+	if err := exporter.Logout(host); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := os.Stat(store); !os.IsNotExist(err) {
+		t.Errorf("Expected logout to erase the keyring entry, got %v", err)
+	}
+}
+
+func TestKeyringHelpers(t *testing.T) {
+	for goos, expected := range map[string]string{
+		"darwin":  "[osxkeychain]",
+		"windows": "[wincred]",
+		"linux":   "[secretservice pass]",
+		"plan9":   "[]",
+	} {
+		if got := fmt.Sprint(keyringHelpers(goos)); got != expected {
+			t.Errorf("Expected %s on %s, got %s", expected, goos, got)
+		}
+	}
+}
+
+func TestIsUnauthorized(t *testing.T) {
+	host := newBasicAuthTestRegistry(t, "ci", "secret")
+	pushed, err := name.ParseReference(host + "/team/app:v1")
+	if err != nil {
+		t.Fatalf("Failed to parse reference: %v", err)
+	}
+	image := newDatedTestImage(t, "private", 1)
+	if err := remote.Write(pushed, image, remote.WithAuth(&authn.Basic{Username: "ci", Password: "secret"})); err != nil {
+		t.Fatalf("Failed to push test image: %v", err)
+	}
+
+	exporter := NewImageExporter(WithAnonymousAuth())
+	_, err = exporter.GetImageConfig(host+"/team/app:v1", nil)
+	if !IsUnauthorized(err) {
+		t.Errorf("Expected an unauthorized error, got %v", err)
+	}
+	if _, err := exporter.GetImageConfig(host+"/team/app:v1", &AuthConfig{Username: "ci", Password: "secret"}); err != nil {
+		t.Errorf("Expected no error with credentials, got %v", err)
+	}
+
+	open := newTestRegistry(t)
+	_, err = exporter.GetImageConfig(open+"/team/missing:v1", nil)
+	if err == nil || IsUnauthorized(err) {
+		t.Errorf("Expected a missing image not to be unauthorized, got %v", err)
+	}
+	if !IsNotFound(err) {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+	if _, err := exporter.GetImageConfig(host+"/team/app:v1", nil); IsNotFound(err) {
+		t.Errorf("Expected an unauthorized error not to be not found, got %v", err)
+	}
+}
+
+func TestCredentialKey(t *testing.T) {
+	for input, expected := range map[string]string{
+		"":                            "https://index.docker.io/v1/",
+		"docker.io":                   "https://index.docker.io/v1/",
+		"https://index.docker.io/v1/": "https://index.docker.io/v1/",
+		"ghcr.io":                     "ghcr.io",
+	} {
+		reg, err := loginRegistry(input)
+		if err != nil {
+			t.Fatalf("Expected no error for %q, got %v", input, err)
+		}
+		if got := credentialKey(reg); got != expected {
+			t.Errorf("Expected credentialKey(%q) = %s, got %s", input, expected, got)
+		}
+	}
+}
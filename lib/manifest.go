@@ -0,0 +1,225 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// maxRegistryClients bounds the clients kept by an exporter; callers passing a
+// fresh AuthConfig on every call would otherwise grow the map without limit
+const maxRegistryClients = 64
+
+// maxDigestManifests bounds the manifests each client keeps
+const maxDigestManifests = 256
+
+// registryClients shares registry clients between the calls of an exporter.
+// A client pings each repository and exchanges tokens once, however many
+// manifests and blobs are fetched from it afterwards.
+type registryClients struct {
+	mu      sync.Mutex
+	clients map[*AuthConfig]*registryClient
+}
+
+// registryClient is the shared client for one set of credentials
+type registryClient struct {
+	puller *remote.Puller
+
+	mu        sync.Mutex
+	manifests map[string]*remote.Descriptor // manifests of digest references, which never change
+}
+
+// client returns the shared client for auth, or nil if clients are not shared
+func (e *imageExporter) client(auth *AuthConfig) *registryClient {
+	if e.clients == nil {
+		return nil
+	}
+	e.clients.mu.Lock()
+	defer e.clients.mu.Unlock()
+
+	if client, ok := e.clients.clients[auth]; ok {
+		return client
+	}
+	puller, err := remote.NewPuller(e.baseRemoteOptions(auth)...)
+	if err != nil {
+		return nil
+	}
+	if e.clients.clients == nil || len(e.clients.clients) >= maxRegistryClients {
+		e.clients.clients = make(map[*AuthConfig]*registryClient)
+	}
+	client := &registryClient{puller: puller, manifests: make(map[string]*remote.Descriptor)}
+	e.clients.clients[auth] = client
+	return client
+}
+
+// getManifest fetches the manifest (or index) of ref. A manifest fetched by
+// digest is immutable, so it is requested from the registry only once per
+// exporter: repeated calls, and exports of several platforms of one index,
+// reuse it without another round trip.
+func (e *imageExporter) getManifest(ref name.Reference, auth *AuthConfig) (*remote.Descriptor, error) {
+	client := e.client(auth)
+	if _, isDigest := ref.(name.Digest); !isDigest || client == nil {
+		return e.getRemote(ref, e.remoteOptions(auth)...)
+	}
+
+	key := ref.Name()
+	client.mu.Lock()
+	desc, ok := client.manifests[key]
+	client.mu.Unlock()
+	if ok {
+		e.log().Debug("manifest reused", "image", key)
+		return desc, nil
+	}
+
+	desc, err := e.getRemote(ref, e.remoteOptions(auth)...)
+	if err != nil {
+		return nil, err
+	}
+	client.mu.Lock()
+	if len(client.manifests) >= maxDigestManifests {
+		client.manifests = make(map[string]*remote.Descriptor)
+	}
+	client.manifests[key] = desc
+	client.mu.Unlock()
+	return desc, nil
+}
+
+// remoteImage fetches the image of ref, resolving an index to platform, or to
+// linux/amd64 when platform is nil. References to attestations rather than an
+// image fail with an *AttestationError.
+func (e *imageExporter) remoteImage(ref name.Reference, auth *AuthConfig, platform *v1.Platform) (v1.Image, error) {
+	var image v1.Image
+	var err error
+	if e.offline && e.verifiesSignatures() {
+		return nil, fmt.Errorf("signatures of %s cannot be verified: %w", ref, ErrOffline)
+	}
+	if e.offline {
+		image, err = e.offlineImage(ref, platform)
+	} else {
+		image, err = e.resolveImage(ref, auth, platform)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if e.cache != nil && !e.offline {
+		// The image of an index is recorded by digest, next to the index
+		digest, err := image.Digest()
+		if err != nil {
+			return nil, err
+		}
+		raw, err := image.RawManifest()
+		if err != nil {
+			return nil, err
+		}
+		e.recordManifest(ref.Context().Digest(digest.String()), digest, raw)
+	}
+	if err := checkAttestationImage(ref, image); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// resolveImage fetches the manifest of ref and selects the image of platform
+// from an index
+func (e *imageExporter) resolveImage(ref name.Reference, auth *AuthConfig, platform *v1.Platform) (v1.Image, error) {
+	var desc *remote.Descriptor
+	var err error
+	if _, isDigest := ref.(name.Digest); isDigest {
+		desc, err = e.getManifest(ref, auth)
+	} else {
+		desc, err = e.getRemote(ref, e.remoteOptions(auth)...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.recordManifest(ref, desc.Digest, desc.Manifest)
+	if err := checkAttestationDescriptor(ref, desc); err != nil {
+		return nil, err
+	}
+	if err := e.verifySignatures(ref, desc.Digest, auth); err != nil {
+		return nil, err
+	}
+	if isSchema1(desc.MediaType) {
+		return remoteSchema1Image(desc)
+	}
+	if platform == nil || !desc.MediaType.IsIndex() {
+		return desc.Image()
+	}
+
+	index, err := desc.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range manifest.Manifests {
+		if child.MediaType.IsImage() && platformMatches(child.Platform, *platform) {
+			return index.Image(child.Digest)
+		}
+	}
+	return nil, fmt.Errorf("no child with platform %s in index %s", platform, ref)
+}
+
+// checkAttestationDescriptor fails with an *AttestationError when desc is an
+// index holding only attestation manifests
+func checkAttestationDescriptor(ref name.Reference, desc *remote.Descriptor) error {
+	if !desc.MediaType.IsIndex() {
+		return nil
+	}
+	manifest, err := v1.ParseIndexManifest(bytes.NewReader(desc.Manifest))
+	if err != nil {
+		return fmt.Errorf("failed to parse index %s: %w", ref, err)
+	}
+	return checkAttestationIndex(ref, manifest)
+}
+
+// ResolveDigest returns the digest of the manifest (or index) imageRef points to.
+// A digest reference is returned as is, without contacting the registry; a tag
+// is resolved with a HEAD request, falling back to fetching the manifest for
+// registries that do not report a digest on HEAD.
+//
+// Parameters:
+//   - imageRef: Image reference (e.g., "alpine:latest")
+//   - auth: Authentication configuration (nil for the keychain)
+//
+// Returns:
+//   - string: The manifest digest, e.g. "sha256:..."
+//   - error: If the reference is invalid or cannot be resolved
+func (e *imageExporter) ResolveDigest(imageRef string, auth *AuthConfig) (string, error) {
+	ref, err := e.parseReference(imageRef)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse image reference: %w", err)
+	}
+	if digest, isDigest := ref.(name.Digest); isDigest {
+		return digest.DigestStr(), nil
+	}
+	if e.offline {
+		if e.cache == nil {
+			return "", fmt.Errorf("offline mode needs a blob cache: %w", ErrOffline)
+		}
+		digest, err := e.cache.resolve(ref)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve %s: %w", imageRef, err)
+		}
+		return digest.String(), nil
+	}
+
+	if desc, err := remote.Head(ref, e.remoteOptions(auth)...); err == nil {
+		e.recordManifest(ref, desc.Digest, nil)
+		return desc.Digest.String(), nil
+	} else if IsUnauthorized(err) || IsNotFound(err) {
+		return "", fmt.Errorf("failed to resolve %s: %w", imageRef, err)
+	}
+	desc, err := e.getManifest(ref, auth)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", imageRef, err)
+	}
+	e.recordManifest(ref, desc.Digest, desc.Manifest)
+	return desc.Digest.String(), nil
+}
@@ -0,0 +1,153 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// requestCounter counts registry requests by method and path
+type requestCounter struct {
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+// wrap implements TransportWrapper
+func (c *requestCounter) wrap(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		c.mu.Lock()
+		c.requests[req.Method+" "+req.URL.Path]++
+		c.mu.Unlock()
+		return next.RoundTrip(req)
+	})
+}
+
+// count returns how many requests were made with method to path
+func (c *requestCounter) count(method, path string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.requests[method+" "+path]
+}
+
+func TestDigestReferenceFetchesManifestOnce(t *testing.T) {
+	var addenda []mutate.IndexAddendum
+	for _, arch := range []string{"amd64", "arm64"} {
+		base, err := mutate.ConfigFile(empty.Image, &v1.ConfigFile{OS: "linux", Architecture: arch})
+		if err != nil {
+			t.Fatalf("Failed to set test image config: %v", err)
+		}
+		image, err := mutate.AppendLayers(base, newTestLayer(t, testEntry{name: "arch", content: arch}))
+		if err != nil {
+			t.Fatalf("Failed to build test image: %v", err)
+		}
+		addenda = append(addenda, mutate.IndexAddendum{
+			Add:        image,
+			Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: arch}},
+		})
+	}
+	index := mutate.AppendManifests(empty.Index, addenda...)
+
+	host := newTestRegistry(t)
+	tag, err := name.ParseReference(host + "/test/pinned:latest")
+	if err != nil {
+		t.Fatalf("Failed to parse reference: %v", err)
+	}
+	if err := remote.WriteIndex(tag, index); err != nil {
+		t.Fatalf("Failed to push test index: %v", err)
+	}
+	digest, err := index.Digest()
+	if err != nil {
+		t.Fatalf("Failed to get index digest: %v", err)
+	}
+	imageRef := host + "/test/pinned@" + digest.String()
+	manifestPath := "/v2/test/pinned/manifests/" + digest.String()
+
+	counter := &requestCounter{requests: map[string]int{}}
+	exporter := NewImageExporter(WithTransportWrapper(counter.wrap))
+	pings := -1
+	for _, platform := range []string{"linux/amd64", "linux/arm64"} {
+		var buf bytes.Buffer
+		if err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &buf, nil, &ExportOptions{Platform: platform}); err != nil {
+			t.Fatalf("Expected no error exporting %s, got %v", platform, err)
+		}
+		if files := readTestTar(t, buf.Bytes()); files["arch"] != strings.TrimPrefix(platform, "linux/") {
+			t.Errorf("Expected the %s filesystem, got %v", platform, files)
+		}
+		if pings < 0 {
+			// Pinging may take more than one request while the scheme is probed
+			pings = counter.count(http.MethodGet, "/v2/")
+		}
+	}
+	for range 2 {
+		config, err := exporter.GetImageConfig(imageRef, nil)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if config.Architecture != "amd64" {
+			t.Errorf("Expected the default linux/amd64 image, got %s", config.Architecture)
+		}
+	}
+
+	if got := counter.count(http.MethodGet, manifestPath); got != 1 {
+		t.Errorf("Expected the index to be fetched once, got %d requests", got)
+	}
+	if got := counter.count(http.MethodGet, "/v2/"); got != pings {
+		t.Errorf("Expected the registry to be pinged only for the first export, got %d requests after %d", got, pings)
+	}
+	if _, err := exporter.GetImageConfig(host+"/test/pinned@"+digest.String(), &AuthConfig{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := counter.count(http.MethodGet, manifestPath); got != 2 {
+		t.Errorf("Expected other credentials to fetch the index again, got %d requests", got)
+	}
+
+	// Deleting by digest needs no HEAD to resolve the digest
+	if _, err := exporter.Delete(imageRef, nil, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := counter.count(http.MethodHead, manifestPath); got != 0 {
+		t.Errorf("Expected no HEAD request for a digest delete, got %d", got)
+	}
+	if _, err := NewImageExporter().GetImageConfig(imageRef, nil); err == nil {
+		t.Error("Expected the deleted index to be gone")
+	}
+}
+
+func TestRemoteImagePlatformNotFound(t *testing.T) {
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "file", content: "data"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	index := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{
+		Add:        image,
+		Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}},
+	})
+	host := newTestRegistry(t)
+	tag, err := name.ParseReference(host + "/test/single:latest")
+	if err != nil {
+		t.Fatalf("Failed to parse reference: %v", err)
+	}
+	if err := remote.WriteIndex(tag, index); err != nil {
+		t.Fatalf("Failed to push test index: %v", err)
+	}
+	digest, err := index.Digest()
+	if err != nil {
+		t.Fatalf("Failed to get index digest: %v", err)
+	}
+
+	exporter := NewImageExporter()
+	err = exporter.ExportImageFilesystemToWriterWithOptions(host+"/test/single@"+digest.String(), io.Discard, nil,
+		&ExportOptions{Platform: "linux/s390x"})
+	if err == nil {
+		t.Fatal("Expected an error for a platform missing from the index")
+	}
+}
@@ -0,0 +1,189 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"gopkg.in/yaml.v3"
+)
+
+// RegistryMirrors maps a registry (e.g. "docker.io" or "ghcr.io") to the
+// mirrors manifests are fetched from before it, in order. A mirror is a host,
+// optionally with a scheme and a path prefix the repositories live under:
+// "mirror.gcr.io", "https://hub.example.com" or "http://10.0.0.5:5000/ghcr".
+type RegistryMirrors map[string][]string
+
+// registryMirror is a parsed mirror endpoint
+type registryMirror struct {
+	host     string
+	prefix   string
+	insecure bool
+}
+
+// parseRegistryMirror parses a mirror endpoint; plain http is allowed when the
+// scheme says so
+func parseRegistryMirror(endpoint string) (registryMirror, error) {
+	var mirror registryMirror
+	rest := endpoint
+	if scheme, after, found := strings.Cut(endpoint, "://"); found {
+		switch scheme {
+		case "https":
+		case "http":
+			mirror.insecure = true
+		default:
+			return registryMirror{}, fmt.Errorf("mirror %q: unsupported scheme %s", endpoint, scheme)
+		}
+		rest = after
+	}
+	mirror.host, mirror.prefix, _ = strings.Cut(strings.TrimSuffix(rest, "/"), "/")
+	if _, err := name.NewRegistry(mirror.host); err != nil || mirror.host == "" {
+		return registryMirror{}, fmt.Errorf("mirror %q: invalid registry host", endpoint)
+	}
+	if u, err := url.Parse("https://" + rest); err != nil || u.RawQuery != "" || u.Fragment != "" {
+		return registryMirror{}, fmt.Errorf("mirror %q: invalid endpoint", endpoint)
+	}
+	return mirror, nil
+}
+
+// reference returns ref as served by the mirror
+func (m registryMirror) reference(ref name.Reference) (name.Reference, error) {
+	repository := m.host + "/" + path.Join(m.prefix, ref.Context().RepositoryStr())
+	var opts []name.Option
+	if m.insecure {
+		opts = append(opts, name.Insecure)
+	}
+	if digest, ok := ref.(name.Digest); ok {
+		return name.NewDigest(repository+"@"+digest.DigestStr(), opts...)
+	}
+	return name.NewTag(repository+":"+ref.Identifier(), opts...)
+}
+
+// Validate checks every registry and mirror endpoint
+func (m RegistryMirrors) Validate() error {
+	for registry, mirrors := range m {
+		if _, err := name.NewRegistry(normalizeRegistry(registry)); err != nil || registry == "" {
+			return fmt.Errorf("invalid registry %q", registry)
+		}
+		for _, endpoint := range mirrors {
+			if _, err := parseRegistryMirror(endpoint); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mirrorsFile is the layout of a mirror configuration file
+type mirrorsFile struct {
+	Mirrors        RegistryMirrors `json:"mirrors"`
+	RegistryMirror []string        `json:"registry-mirrors"`
+}
+
+// LoadRegistryMirrors reads a mirror configuration file, written as JSON or
+// YAML with a "mirrors" map of registry to mirror list:
+//
+//	mirrors:
+//	  docker.io:
+//	    - mirror.gcr.io
+//	    - https://hub-cache.example.com
+//	  ghcr.io:
+//	    - http://10.0.0.5:5000/ghcr
+//
+// A "registry-mirrors" list is read as mirrors of docker.io, so dockerd's
+// daemon.json can be used as it is.
+//
+// Parameters:
+//   - path: Path of the configuration file
+//
+// Returns:
+//   - RegistryMirrors: The mirrors, to pass to WithRegistryMirrors
+//   - error: The file could not be read, parsed, or holds an invalid endpoint
+func LoadRegistryMirrors(path string) (RegistryMirrors, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry mirrors: %w", err)
+	}
+	var document interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse registry mirrors %s: %w", path, err)
+	}
+	normalized, err := json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse registry mirrors %s: %w", path, err)
+	}
+	var contents mirrorsFile
+	if err := json.Unmarshal(normalized, &contents); err != nil {
+		return nil, fmt.Errorf("failed to parse registry mirrors %s: %w", path, err)
+	}
+
+	mirrors := contents.Mirrors
+	if mirrors == nil {
+		mirrors = make(RegistryMirrors)
+	}
+	if len(contents.RegistryMirror) > 0 {
+		mirrors["docker.io"] = append(mirrors["docker.io"], contents.RegistryMirror...)
+	}
+	if len(mirrors) == 0 {
+		return nil, fmt.Errorf(`no mirrors found in %s, expected a "mirrors" map or a "registry-mirrors" list`, path)
+	}
+	if err := mirrors.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid registry mirrors %s: %w", path, err)
+	}
+	return mirrors, nil
+}
+
+// WithRegistryMirrors fetches the manifests of a registry's images from its
+// mirrors first, like dockerd's registry-mirrors: each mirror is tried in
+// order, and the registry itself when all of them fail (unreachable, rate
+// limited, or without the image). Layers are then read from wherever the
+// manifest came from. Invalid endpoints are skipped; check them with
+// RegistryMirrors.Validate. Calling it again adds mirrors after the existing
+// ones.
+//
+// Mirrors are only used to read images. A mirror may serve a tag that moved
+// upstream since it was cached; digest references are always exact.
+//
+// Example:
+//
+//	exporter := NewImageExporter(WithRegistryMirrors(RegistryMirrors{
+//	    "docker.io": {"mirror.gcr.io"},
+//	}))
+func WithRegistryMirrors(mirrors RegistryMirrors) ExporterOption {
+	return func(e *imageExporter) {
+		if e.mirrors == nil {
+			e.mirrors = make(map[string][]registryMirror)
+		}
+		for registry, endpoints := range mirrors {
+			registry = normalizeRegistry(registry)
+			for _, endpoint := range endpoints {
+				if mirror, err := parseRegistryMirror(endpoint); err == nil {
+					e.mirrors[registry] = append(e.mirrors[registry], mirror)
+				}
+			}
+		}
+	}
+}
+
+// getRemote fetches the manifest of ref like remote.Get, from the mirrors of
+// its registry first
+func (e *imageExporter) getRemote(ref name.Reference, opts ...remote.Option) (*remote.Descriptor, error) {
+	for _, mirror := range e.mirrors[ref.Context().RegistryStr()] {
+		mirrored, err := mirror.reference(ref)
+		if err != nil {
+			continue
+		}
+		desc, err := remote.Get(mirrored, opts...)
+		if err == nil {
+			e.log().Debug("manifest served by mirror", "image", ref.String(), "mirror", mirrored.String())
+			return desc, nil
+		}
+		e.log().Info("mirror failed, falling back", "image", ref.String(), "mirror", mirrored.String(), "error", err)
+	}
+	return remote.Get(ref, opts...)
+}
@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestRegistryMirrors(t *testing.T) {
+	upstream, mirror, bare := newTestRegistry(t), newTestRegistry(t), newTestRegistry(t)
+	pushFile := func(ref, content string) {
+		image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "etc/motd", content: content}))
+		if err != nil {
+			t.Fatalf("Failed to build test image: %v", err)
+		}
+		pushTestImage(t, ref, image)
+	}
+	pushFile(upstream+"/library/app:v1", "upstream")
+	pushFile(upstream+"/library/app:v2", "upstream")
+	pushFile(mirror+"/hub/library/app:v1", "mirror")
+
+	// A port nothing listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := listener.Addr().String()
+	listener.Close()
+
+	exporter := NewImageExporter(WithRegistryMirrors(RegistryMirrors{
+		upstream: {"http://" + down, bare, "http://" + mirror + "/hub/"},
+	}))
+	for tag, expected := range map[string]string{"v1": "mirror", "v2": "upstream"} {
+		var out bytes.Buffer
+		if err := exporter.ExportImageFilesystemToWriter(upstream+"/library/app:"+tag, &out, nil); err != nil {
+			t.Fatalf("Expected no error for %s, got %v", tag, err)
+		}
+		if files := readTestTar(t, out.Bytes()); files["etc/motd"] != expected {
+			t.Errorf("Expected %s to come from the %s, got %v", tag, expected, files)
+		}
+	}
+	if _, err := exporter.GetImageConfig(upstream+"/library/app:v3", nil); err == nil {
+		t.Error("Expected an error for an image missing everywhere")
+	}
+
+	for _, invalid := range []string{"ftp://mirror.example.com", "", "https://", "mirror.example.com?x=1"} {
+		if err := (RegistryMirrors{"docker.io": {invalid}}).Validate(); err == nil {
+			t.Errorf("Expected an error for mirror %q", invalid)
+		}
+	}
+}
+
+func TestLoadRegistryMirrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "daemon.json")
+	os.WriteFile(path, []byte(`{"registry-mirrors": ["https://mirror.gcr.io"], "mirrors": {"ghcr.io": ["http://10.0.0.5:5000/ghcr"]}}`), 0o644)
+	mirrors, err := LoadRegistryMirrors(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(mirrors["docker.io"]) != 1 || len(mirrors["ghcr.io"]) != 1 {
+		t.Errorf("Expected mirrors of docker.io and ghcr.io, got %v", mirrors)
+	}
+
+	path = filepath.Join(dir, "mirrors.yaml")
+	os.WriteFile(path, []byte("mirrors:\n  docker.io:\n    - mirror.gcr.io\n    - hub.example.com\n"), 0o644)
+	if mirrors, err = LoadRegistryMirrors(path); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := mirrors["docker.io"]; len(got) != 2 || got[0] != "mirror.gcr.io" {
+		t.Errorf("Expected mirrors in order, got %v", got)
+	}
+
+	for _, content := range []string{"debug: true\n", "mirrors:\n  docker.io: [\"ftp://x\"]\n", "mirrors: ["} {
+		os.WriteFile(path, []byte(content), 0o644)
+		if _, err := LoadRegistryMirrors(path); err == nil {
+			t.Errorf("Expected an error for %q", content)
+		}
+	}
+}
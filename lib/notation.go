@@ -0,0 +1,632 @@
+package lib
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha512" // SHA-384 and SHA-512 of the PS384, ES384, PS512 and ES512 algorithms
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Media types of notation signatures
+const (
+	notationArtifactType = "application/vnd.cncf.notary.signature"
+	notationJWSMediaType = "application/jose+json"
+	notationCOSEType     = "application/cose"
+)
+
+// maxNotationEnvelope bounds the signature envelopes read from a registry
+const maxNotationEnvelope = 1 << 20
+
+// NotationLevel is the signatureVerification level of a notation trust
+// policy, which decides which checks fail verification and which are only
+// reported as a WarningSignatureCheck
+type NotationLevel string
+
+const (
+	// NotationLevelStrict enforces every check
+	NotationLevelStrict NotationLevel = "strict"
+
+	// NotationLevelPermissive enforces integrity and authenticity, and only
+	// reports expired signatures and certificates
+	NotationLevelPermissive NotationLevel = "permissive"
+
+	// NotationLevelAudit only enforces integrity: images need a signature of
+	// their digest, by anyone
+	NotationLevelAudit NotationLevel = "audit"
+
+	// NotationLevelSkip accepts images without looking for signatures
+	NotationLevelSkip NotationLevel = "skip"
+)
+
+// ParseNotationLevel parses a signatureVerification level
+//
+// Parameters:
+//   - s: "strict", "permissive", "audit" or "skip"
+//
+// Returns:
+//   - NotationLevel: The parsed level
+//   - error: If s is not a known level
+func ParseNotationLevel(s string) (NotationLevel, error) {
+	switch level := NotationLevel(s); level {
+	case NotationLevelStrict, NotationLevelPermissive, NotationLevelAudit, NotationLevelSkip:
+		return level, nil
+	}
+	return "", fmt.Errorf("invalid signature verification level %q (must be strict, permissive, audit or skip)", s)
+}
+
+// The checks of notation verification, enforced depending on the level
+type notationCheck int
+
+const (
+	notationIntegrity notationCheck = iota
+	notationAuthenticity
+	notationAuthenticTimestamp
+	notationExpiry
+)
+
+// enforces reports whether a failed check fails verification at level
+func (l NotationLevel) enforces(check notationCheck) bool {
+	switch l {
+	case NotationLevelStrict:
+		return true
+	case NotationLevelPermissive:
+		return check <= notationAuthenticity
+	default:
+		return check == notationIntegrity
+	}
+}
+
+// NotationPolicy is a notation trust policy document with the certificates of
+// the trust stores it names, see LoadNotationPolicy
+type NotationPolicy struct {
+	// TrustPolicies select the policy of an image by its repository
+	TrustPolicies []NotationTrustPolicy
+
+	// TrustStores are the certificates of each trust store, keyed by
+	// "<type>:<name>" as in NotationTrustPolicy.TrustStores
+	TrustStores map[string][]*x509.Certificate
+}
+
+// NotationTrustPolicy is one entry of a notation trust policy document
+type NotationTrustPolicy struct {
+	// Name identifies the policy in errors
+	Name string
+
+	// RegistryScopes are the repositories the policy applies to, like
+	// "registry.example.com/app", or "*" for every repository without a policy
+	// of its own
+	RegistryScopes []string
+
+	// Level decides which checks are enforced
+	Level NotationLevel
+
+	// TrustStores name the stores of the certificate authorities signing
+	// certificates must chain to, like "ca:acme-rockets": "ca" stores for the
+	// notary.x509 signing scheme, "signingAuthority" stores for
+	// notary.x509.signingAuthority
+	TrustStores []string
+
+	// TrustedIdentities are the accepted signing certificate subjects, like
+	// "x509.subject: C=US, ST=WA, O=acme-rockets.io", or "*" for any subject
+	TrustedIdentities []string
+}
+
+// trustPolicyDocument is the file format of notation trust policies
+type trustPolicyDocument struct {
+	Version       string `json:"version"`
+	TrustPolicies []struct {
+		Name                  string   `json:"name"`
+		RegistryScopes        []string `json:"registryScopes"`
+		SignatureVerification struct {
+			Level string `json:"level"`
+		} `json:"signatureVerification"`
+		TrustStores       []string `json:"trustStores"`
+		TrustedIdentities []string `json:"trustedIdentities"`
+	} `json:"trustPolicies"`
+}
+
+// DefaultNotationDir returns the notation configuration directory, where
+// 'notation policy import' and 'notation cert add' store trust policies and
+// trust stores (e.g. ~/.config/notation)
+func DefaultNotationDir() (string, error) {
+	configHome, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the configuration directory: %w", err)
+	}
+	return filepath.Join(configHome, "notation"), nil
+}
+
+// LoadNotationPolicy reads a notation trust policy document and the trust
+// stores it names, as managed by the notation CLI.
+//
+// Parameters:
+//   - policyFile: Trust policy document, e.g. ~/.config/notation/trustpolicy.oci.json
+//   - trustStoreDir: Trust store directory holding x509/<type>/<name>/ folders of
+//     PEM or DER certificates, e.g. ~/.config/notation/truststore
+//
+// Returns:
+//   - *NotationPolicy: The policy, for WithNotationVerification
+//   - error: If the document is invalid or a trust store it names is missing
+func LoadNotationPolicy(policyFile, trustStoreDir string) (*NotationPolicy, error) {
+	data, err := os.ReadFile(policyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trust policy: %w", err)
+	}
+	var document trustPolicyDocument
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("invalid trust policy %s: %w", policyFile, err)
+	}
+	if document.Version != "1.0" {
+		return nil, fmt.Errorf("unsupported trust policy version %q in %s", document.Version, policyFile)
+	}
+
+	policy := &NotationPolicy{TrustStores: make(map[string][]*x509.Certificate)}
+	for _, entry := range document.TrustPolicies {
+		level, err := ParseNotationLevel(entry.SignatureVerification.Level)
+		if err != nil {
+			return nil, fmt.Errorf("trust policy %q: %w", entry.Name, err)
+		}
+		policy.TrustPolicies = append(policy.TrustPolicies, NotationTrustPolicy{
+			Name:              entry.Name,
+			RegistryScopes:    entry.RegistryScopes,
+			Level:             level,
+			TrustStores:       entry.TrustStores,
+			TrustedIdentities: entry.TrustedIdentities,
+		})
+		for _, store := range entry.TrustStores {
+			if _, ok := policy.TrustStores[store]; ok {
+				continue
+			}
+			kind, storeName, found := strings.Cut(store, ":")
+			if !found {
+				return nil, fmt.Errorf("trust policy %q: invalid trust store %q", entry.Name, store)
+			}
+			certs, err := loadTrustStore(filepath.Join(trustStoreDir, "x509", kind, storeName))
+			if err != nil {
+				return nil, fmt.Errorf("trust policy %q: %w", entry.Name, err)
+			}
+			policy.TrustStores[store] = certs
+		}
+	}
+	return policy, nil
+}
+
+// loadTrustStore reads the certificates of the files of a trust store folder
+func loadTrustStore(dir string) ([]*x509.Certificate, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trust store: %w", err)
+	}
+	var certs []*x509.Certificate
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trust store: %w", err)
+		}
+		if block, _ := pem.Decode(data); block == nil {
+			cert, err := x509.ParseCertificate(data)
+			if err != nil {
+				return nil, fmt.Errorf("invalid certificate %s: %w", file.Name(), err)
+			}
+			certs = append(certs, cert)
+			continue
+		}
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid certificate %s: %w", file.Name(), err)
+			}
+			certs = append(certs, cert)
+		}
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate in trust store %s", dir)
+	}
+	return certs, nil
+}
+
+// WithNotationVerification refuses images without a notation signature
+// accepted by the trust policy of their repository. Signatures are looked up
+// with the OCI referrers API (see Referrers) on the manifest digest a
+// reference resolves to, as pushed by 'notation sign'; otherwise the operation
+// fails with an error wrapping ErrSignatureVerification. Images of
+// repositories no trust policy applies to are refused.
+//
+// JWS envelopes of the notary.x509 and notary.x509.signingAuthority signing
+// schemes are supported. COSE envelopes, timestamp countersignatures,
+// verification plugins and revocation checks are not. It can be combined
+// with WithCosignVerification, in which case both must accept an image.
+//
+// Example:
+//
+//	dir, _ := DefaultNotationDir()
+//	policy, err := LoadNotationPolicy(filepath.Join(dir, "trustpolicy.json"), filepath.Join(dir, "truststore"))
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	exporter := NewImageExporter(WithNotationVerification(policy))
+func WithNotationVerification(policy *NotationPolicy) ExporterOption {
+	return func(e *imageExporter) {
+		e.notation = policy
+	}
+}
+
+// policyFor returns the trust policy of repo: the one naming it, or else the
+// wildcard policy
+func (p *NotationPolicy) policyFor(repo name.Repository) *NotationTrustPolicy {
+	names := []string{repo.Name()}
+	if repo.RegistryStr() == name.DefaultRegistry {
+		names = append(names, "docker.io/"+repo.RepositoryStr())
+	}
+	var wildcard *NotationTrustPolicy
+	for i := range p.TrustPolicies {
+		policy := &p.TrustPolicies[i]
+		for _, scope := range policy.RegistryScopes {
+			if scope == "*" {
+				wildcard = policy
+			}
+			for _, name := range names {
+				if scope == name {
+					return policy
+				}
+			}
+		}
+	}
+	return wildcard
+}
+
+// jwsEnvelope is a notation signature in the JWS JSON serialization
+type jwsEnvelope struct {
+	Payload   string `json:"payload"`
+	Protected string `json:"protected"`
+	Header    struct {
+		CertificateChain [][]byte `json:"x5c"`
+	} `json:"header"`
+	Signature string `json:"signature"`
+}
+
+// jwsHeader is the protected header of a notation JWS envelope
+type jwsHeader struct {
+	Algorithm            string     `json:"alg"`
+	ContentType          string     `json:"cty"`
+	Critical             []string   `json:"crit"`
+	SigningScheme        string     `json:"io.cncf.notary.signingScheme"`
+	SigningTime          *time.Time `json:"io.cncf.notary.signingTime"`
+	AuthenticSigningTime *time.Time `json:"io.cncf.notary.authenticSigningTime"`
+	Expiry               *time.Time `json:"io.cncf.notary.expiry"`
+	VerificationPlugin   string     `json:"io.cncf.notary.verificationPlugin"`
+}
+
+// verifyNotation checks the notation signatures of the manifest digest of an
+// image in repo against its trust policy
+func (e *imageExporter) verifyNotation(repo name.Repository, digest v1.Hash, auth *AuthConfig) error {
+	policy := e.notation.policyFor(repo)
+	if policy == nil {
+		return fmt.Errorf("no trust policy applies to %s: %w", repo, ErrSignatureVerification)
+	}
+	if policy.Level == NotationLevelSkip {
+		e.log().Debug("signature verification skipped", "image", repo.String(), "policy", policy.Name)
+		return nil
+	}
+
+	options := append(e.remoteOptions(auth), remote.WithFilter("artifactType", notationArtifactType))
+	index, err := remote.Referrers(repo.Digest(digest.String()), options...)
+	if err != nil {
+		return fmt.Errorf("failed to list signatures of %s@%s: %w", repo, digest, err)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return fmt.Errorf("failed to list signatures of %s@%s: %w", repo, digest, err)
+	}
+
+	var problems []error
+	for _, desc := range manifest.Manifests {
+		if desc.ArtifactType != notationArtifactType {
+			continue
+		}
+		warnings, err := e.verifyNotationSignature(repo.Digest(desc.Digest.String()), digest, policy, auth)
+		if err == nil {
+			for _, warning := range warnings {
+				e.warn(nil, Warning{Code: WarningSignatureCheck, Message: fmt.Sprintf("signature %s of %s@%s: %s (trust policy %q is %s)", desc.Digest, repo, digest, warning, policy.Name, policy.Level)})
+			}
+			e.log().Info("signature verified", "image", repo.String(), "digest", digest.String(), "signature", desc.Digest.String(), "policy", policy.Name)
+			return nil
+		}
+		problems = append(problems, fmt.Errorf("signature %s: %w", desc.Digest, err))
+	}
+	if len(problems) == 0 {
+		return fmt.Errorf("%s@%s is not signed: %w", repo, digest, ErrSignatureVerification)
+	}
+	return fmt.Errorf("no signature of %s@%s accepted by trust policy %q: %w: %w", repo, digest, policy.Name, ErrSignatureVerification, errors.Join(problems...))
+}
+
+// verifyNotationSignature checks one signature manifest. Checks the level of
+// the policy does not enforce are returned as warnings.
+func (e *imageExporter) verifyNotationSignature(ref name.Digest, digest v1.Hash, policy *NotationTrustPolicy, auth *AuthConfig) ([]error, error) {
+	desc, err := e.getRemote(ref, e.remoteOptions(auth)...)
+	if err != nil {
+		return nil, err
+	}
+	image, err := desc.Image()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.Layers) != 1 {
+		return nil, fmt.Errorf("expected one signature envelope, got %d", len(manifest.Layers))
+	}
+	if manifest.Layers[0].MediaType != notationJWSMediaType {
+		if manifest.Layers[0].MediaType == notationCOSEType {
+			return nil, errors.New("COSE signature envelopes are not supported")
+		}
+		return nil, fmt.Errorf("unknown signature envelope %s", manifest.Layers[0].MediaType)
+	}
+	blob, err := image.LayerByDigest(manifest.Layers[0].Digest)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := blob.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	envelope, err := io.ReadAll(io.LimitReader(rc, maxNotationEnvelope))
+	rc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signature envelope: %w", err)
+	}
+
+	var warnings []error
+	failed := func(check notationCheck, err error) error {
+		if policy.Level.enforces(check) {
+			return err
+		}
+		warnings = append(warnings, err)
+		return nil
+	}
+	if err := e.notation.verifyJWS(envelope, digest, policy, time.Now(), failed); err != nil {
+		return nil, err
+	}
+	return warnings, nil
+}
+
+// verifyJWS checks a JWS signature envelope of digest as of now, passing each
+// failed check to failed, which returns the error if it is enforced
+func (p *NotationPolicy) verifyJWS(data []byte, digest v1.Hash, policy *NotationTrustPolicy, now time.Time, failed func(notationCheck, error) error) error {
+	var envelope jwsEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("invalid signature envelope: %w", err)
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(envelope.Protected)
+	if err != nil {
+		return fmt.Errorf("invalid protected header: %w", err)
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return fmt.Errorf("invalid protected header: %w", err)
+	}
+	if err := header.checkCritical(); err != nil {
+		return err
+	}
+	if len(envelope.Header.CertificateChain) == 0 {
+		return errors.New("signature envelope has no certificate chain")
+	}
+	chain := make([]*x509.Certificate, 0, len(envelope.Header.CertificateChain))
+	for _, der := range envelope.Header.CertificateChain {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("invalid certificate chain: %w", err)
+		}
+		chain = append(chain, cert)
+	}
+
+	// Integrity: the leaf certificate signed the payload, which names digest
+	signature, err := base64.RawURLEncoding.DecodeString(envelope.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if err := verifyJWSSignature(header.Algorithm, chain[0].PublicKey, []byte(envelope.Protected+"."+envelope.Payload), signature); err != nil {
+		return failed(notationIntegrity, err)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	var signed struct {
+		TargetArtifact v1.Descriptor `json:"targetArtifact"`
+	}
+	if err := json.Unmarshal(payload, &signed); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	if signed.TargetArtifact.Digest != digest {
+		return failed(notationIntegrity, fmt.Errorf("payload signs %s", signed.TargetArtifact.Digest))
+	}
+
+	// Authenticity: the chain ends in a trust store of the policy, and the
+	// leaf was issued to a trusted identity
+	signedAt := now
+	storeType := "ca"
+	switch header.SigningScheme {
+	case "notary.x509":
+		if header.SigningTime != nil {
+			signedAt = *header.SigningTime
+		}
+	case "notary.x509.signingAuthority":
+		storeType = "signingAuthority"
+		if header.AuthenticSigningTime == nil {
+			return failed(notationIntegrity, errors.New("signing authority signature has no authentic signing time"))
+		}
+		signedAt = *header.AuthenticSigningTime
+	}
+	if err := p.verifyChain(chain, storeType, policy, signedAt); err != nil {
+		if err := failed(notationAuthenticity, err); err != nil {
+			return err
+		}
+	} else if !trustedIdentity(chain[0], policy.TrustedIdentities) {
+		if err := failed(notationAuthenticity, fmt.Errorf("signer %q is not a trusted identity", chain[0].Subject)); err != nil {
+			return err
+		}
+	}
+
+	// Authentic timestamp: the signing time of notary.x509 signatures is only
+	// the signer's claim, so the chain must still be valid
+	if header.SigningScheme == "notary.x509" {
+		for _, cert := range chain {
+			if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+				if err := failed(notationAuthenticTimestamp, fmt.Errorf("certificate %q is not valid at %s", cert.Subject, now.Format(time.RFC3339))); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	if header.Expiry != nil && now.After(*header.Expiry) {
+		return failed(notationExpiry, fmt.Errorf("signature expired at %s", header.Expiry.Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// checkCritical fails for signing schemes and critical headers notation
+// verification does not understand
+func (h *jwsHeader) checkCritical() error {
+	if h.ContentType != "application/vnd.cncf.notary.payload.v1+json" {
+		return fmt.Errorf("unknown payload type %q", h.ContentType)
+	}
+	if h.SigningScheme != "notary.x509" && h.SigningScheme != "notary.x509.signingAuthority" {
+		return fmt.Errorf("unknown signing scheme %q", h.SigningScheme)
+	}
+	if h.VerificationPlugin != "" {
+		return fmt.Errorf("verification plugin %s is not supported", h.VerificationPlugin)
+	}
+	for _, critical := range h.Critical {
+		switch critical {
+		case "io.cncf.notary.signingScheme", "io.cncf.notary.expiry", "io.cncf.notary.authenticSigningTime":
+		default:
+			return fmt.Errorf("unknown critical header %q", critical)
+		}
+	}
+	return nil
+}
+
+// verifyChain checks that chain ends in a trust store of storeType named by
+// policy, at the time the signature was made
+func (p *NotationPolicy) verifyChain(chain []*x509.Certificate, storeType string, policy *NotationTrustPolicy, signedAt time.Time) error {
+	roots := x509.NewCertPool()
+	for _, store := range policy.TrustStores {
+		if strings.HasPrefix(store, storeType+":") {
+			for _, cert := range p.TrustStores[store] {
+				roots.AddCert(cert)
+			}
+		}
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   signedAt,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return fmt.Errorf("signer %q is not trusted: %w", chain[0].Subject, err)
+	}
+	return nil
+}
+
+// trustedIdentity reports whether cert matches a trusted identity: "*", or an
+// "x509.subject:" whose attributes all appear in the subject of cert
+func trustedIdentity(cert *x509.Certificate, identities []string) bool {
+	subject := map[string][]string{
+		"C":  cert.Subject.Country,
+		"ST": cert.Subject.Province,
+		"L":  cert.Subject.Locality,
+		"O":  cert.Subject.Organization,
+		"OU": cert.Subject.OrganizationalUnit,
+		"CN": {cert.Subject.CommonName},
+	}
+	for _, identity := range identities {
+		if identity == "*" {
+			return true
+		}
+		dn, ok := strings.CutPrefix(identity, "x509.subject:")
+		if !ok {
+			continue
+		}
+		matches := true
+		for _, attribute := range strings.Split(dn, ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(attribute), "=")
+			found := false
+			for _, have := range subject[key] {
+				found = found || have == value
+			}
+			matches = matches && found
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyJWSSignature checks a JWS signature of the PS and ES algorithms
+// notation signs with
+func verifyJWSSignature(algorithm string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch algorithm {
+	case "PS256", "ES256":
+		hash = crypto.SHA256
+	case "PS384", "ES384":
+		hash = crypto.SHA384
+	case "PS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", algorithm)
+	}
+	h := hash.New()
+	h.Write(signed)
+	sum := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if algorithm[0] != 'P' {
+			return fmt.Errorf("%s signature with an RSA key", algorithm)
+		}
+		return rsa.VerifyPSS(key, hash, sum, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if algorithm[0] != 'E' || len(signature) != 2*size {
+			return fmt.Errorf("invalid %s signature", algorithm)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, sum, r, s) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+}
@@ -0,0 +1,230 @@
+package lib
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// newTestNotationSigner returns a CA and a signing certificate it issued to
+// the subject C=US, ST=WA, O=acme-rockets.io
+func newTestNotationSigner(t *testing.T) (ca *x509.Certificate, leaf []byte, key *ecdsa.PrivateKey) {
+	t.Helper()
+	now := time.Now()
+	caKey := newTestSigningKey(t)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "acme-rockets CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create test CA: %v", err)
+	}
+	ca, _ = x509.ParseCertificate(caDER)
+
+	key = newTestSigningKey(t)
+	leaf, err = x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{Country: []string{"US"}, Province: []string{"WA"}, Organization: []string{"acme-rockets.io"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create test certificate: %v", err)
+	}
+	return ca, leaf, key
+}
+
+// newTestJWS returns a notation JWS envelope signing digest, as created by
+// 'notation sign'
+func newTestJWS(t *testing.T, key *ecdsa.PrivateKey, chain [][]byte, digest v1.Hash, expiry time.Time) []byte {
+	t.Helper()
+	header := map[string]any{
+		"alg":                          "ES256",
+		"cty":                          "application/vnd.cncf.notary.payload.v1+json",
+		"crit":                         []string{"io.cncf.notary.signingScheme", "io.cncf.notary.expiry"},
+		"io.cncf.notary.signingScheme": "notary.x509",
+		"io.cncf.notary.signingTime":   time.Now().Add(-time.Minute).Format(time.RFC3339),
+		"io.cncf.notary.expiry":        expiry.Format(time.RFC3339),
+	}
+	protectedJSON, _ := json.Marshal(header)
+	payloadJSON, _ := json.Marshal(map[string]any{"targetArtifact": v1.Descriptor{
+		MediaType: "application/vnd.oci.image.manifest.v1+json",
+		Digest:    digest,
+		Size:      1234,
+	}})
+	protected := base64.RawURLEncoding.EncodeToString(protectedJSON)
+	payload := base64.RawURLEncoding.EncodeToString(payloadJSON)
+
+	sum := sha256.Sum256([]byte(protected + "." + payload))
+	r, s, err := ecdsa.Sign(rand.Reader, key, sum[:])
+	if err != nil {
+		t.Fatalf("Failed to sign test envelope: %v", err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	envelope, _ := json.Marshal(map[string]any{
+		"payload":   payload,
+		"protected": protected,
+		"header":    map[string]any{"x5c": chain, "io.cncf.notary.signingAgent": "notation-go/1.3.0"},
+		"signature": base64.RawURLEncoding.EncodeToString(signature),
+	})
+	return envelope
+}
+
+// writeTestNotationConfig writes a trust policy document and a trust store
+// holding ca, laid out like the notation configuration directory
+func writeTestNotationConfig(t *testing.T, ca *x509.Certificate, scope string) (policyFile, trustStoreDir string) {
+	t.Helper()
+	dir := t.TempDir()
+	trustStoreDir = filepath.Join(dir, "truststore")
+	store := filepath.Join(trustStoreDir, "x509", "ca", "acme-rockets")
+	if err := os.MkdirAll(store, 0755); err != nil {
+		t.Fatalf("Failed to create trust store: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(store, "ca.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0644); err != nil {
+		t.Fatalf("Failed to write trust store: %v", err)
+	}
+	policyFile = filepath.Join(dir, "trustpolicy.json")
+	document := `{
+  "version": "1.0",
+  "trustPolicies": [
+    {
+      "name": "acme-rockets",
+      "registryScopes": ["` + scope + `"],
+      "signatureVerification": {"level": "strict"},
+      "trustStores": ["ca:acme-rockets"],
+      "trustedIdentities": ["x509.subject: C=US, ST=WA, O=acme-rockets.io"]
+    }
+  ]
+}`
+	if err := os.WriteFile(policyFile, []byte(document), 0644); err != nil {
+		t.Fatalf("Failed to write trust policy: %v", err)
+	}
+	return policyFile, trustStoreDir
+}
+
+func TestNotationVerification(t *testing.T) {
+	host := newTestRegistry(t)
+	ca, leaf, key := newTestNotationSigner(t)
+
+	pushImage := func(tag, content string) v1.Hash {
+		image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "file", content: content}))
+		if err != nil {
+			t.Fatalf("Failed to build test image: %v", err)
+		}
+		pushTestImage(t, host+"/test/app:"+tag, image)
+		digest, _ := image.Digest()
+		return digest
+	}
+	signed := pushImage("signed", "signed")
+	pushTestReferrer(t, host+"/test/app:signed", notationArtifactType, notationJWSMediaType,
+		newTestJWS(t, key, [][]byte{leaf}, signed, time.Now().Add(time.Hour)), nil)
+	pushImage("unsigned", "unsigned")
+	expired := pushImage("expired", "expired")
+	pushTestReferrer(t, host+"/test/app:expired", notationArtifactType, notationJWSMediaType,
+		newTestJWS(t, key, [][]byte{leaf}, expired, time.Now().Add(-time.Minute)), nil)
+
+	policyFile, trustStoreDir := writeTestNotationConfig(t, ca, host+"/test/app")
+	policy, err := LoadNotationPolicy(policyFile, trustStoreDir)
+	if err != nil {
+		t.Fatalf("Expected no error loading policy, got %v", err)
+	}
+
+	// An identical CA of another organization, which did not issue the signer
+	other, _, _ := newTestNotationSigner(t)
+
+	tests := []struct {
+		name    string
+		tag     string
+		modify  func(p *NotationTrustPolicy)
+		stores  []*x509.Certificate
+		wantErr bool
+		warns   bool
+	}{
+		{name: "signed", tag: "signed"},
+		{name: "unsigned", tag: "unsigned", wantErr: true},
+		{name: "untrusted identity", tag: "signed", wantErr: true, modify: func(p *NotationTrustPolicy) {
+			p.TrustedIdentities = []string{"x509.subject: C=US, ST=WA, O=wabbit-networks.io"}
+		}},
+		{name: "any identity", tag: "signed", modify: func(p *NotationTrustPolicy) { p.TrustedIdentities = []string{"*"} }},
+		{name: "untrusted CA", tag: "signed", stores: []*x509.Certificate{other}, wantErr: true},
+		{name: "untrusted CA audited", tag: "signed", stores: []*x509.Certificate{other}, warns: true,
+			modify: func(p *NotationTrustPolicy) { p.Level = NotationLevelAudit }},
+		{name: "expired", tag: "expired", wantErr: true},
+		{name: "expired permissive", tag: "expired", warns: true,
+			modify: func(p *NotationTrustPolicy) { p.Level = NotationLevelPermissive }},
+		{name: "skipped", tag: "unsigned", modify: func(p *NotationTrustPolicy) { p.Level = NotationLevelSkip }},
+		{name: "no policy", tag: "signed", wantErr: true,
+			modify: func(p *NotationTrustPolicy) { p.RegistryScopes = []string{host + "/test/other"} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modified := &NotationPolicy{
+				TrustPolicies: []NotationTrustPolicy{policy.TrustPolicies[0]},
+				TrustStores:   policy.TrustStores,
+			}
+			if tt.modify != nil {
+				tt.modify(&modified.TrustPolicies[0])
+			}
+			if tt.stores != nil {
+				modified.TrustStores = map[string][]*x509.Certificate{"ca:acme-rockets": tt.stores}
+			}
+			var warnings []Warning
+			exporter := NewImageExporter(WithNotationVerification(modified), WithWarnings(func(w Warning) { warnings = append(warnings, w) }))
+
+			_, err := exporter.GetImageConfig(host+"/test/app:"+tt.tag, nil)
+			if tt.wantErr {
+				if !errors.Is(err, ErrSignatureVerification) {
+					t.Errorf("Expected ErrSignatureVerification, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if tt.warns != (len(warnings) == 1 && warnings[0].Code == WarningSignatureCheck) {
+				t.Errorf("Expected signature check warning %v, got %+v", tt.warns, warnings)
+			}
+		})
+	}
+}
+
+func TestLoadNotationPolicyErrors(t *testing.T) {
+	ca, _, _ := newTestNotationSigner(t)
+	policyFile, trustStoreDir := writeTestNotationConfig(t, ca, "*")
+
+	if _, err := LoadNotationPolicy(policyFile, t.TempDir()); err == nil {
+		t.Error("Expected error for a missing trust store, got nil")
+	}
+	if err := os.WriteFile(policyFile, []byte(`{"version": "1.0", "trustPolicies": [{"name": "p", "signatureVerification": {"level": "lenient"}}]}`), 0644); err != nil {
+		t.Fatalf("Failed to write trust policy: %v", err)
+	}
+	if _, err := LoadNotationPolicy(policyFile, trustStoreDir); err == nil {
+		t.Error("Expected error for an unknown level, got nil")
+	}
+}
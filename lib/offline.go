@@ -0,0 +1,304 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// ErrOffline is returned in offline mode (see WithOffline) for anything that
+// is not in the blob cache and would need the network
+var ErrOffline = errors.New("not available offline")
+
+// WithOffline serves image configurations and filesystems entirely from the
+// blob cache of WithCache, without any network access. Every export made with
+// a cache records the manifests it resolved and the digest each tag pointed to,
+// so an image exported once online can be exported again offline, e.g. in an
+// air-gapped build stage that receives a copy of the cache.
+//
+// Anything missing from the cache fails with an error wrapping ErrOffline that
+// names the manifest or blob. Operations that only make sense online (listing
+// tags, deleting, logging in) fail the same way: the exporter's transport
+// refuses every request.
+//
+// Example:
+//
+//	exporter := NewImageExporter(WithCache("/mnt/imgex-cache"), WithOffline())
+//	err := exporter.ExportImageFilesystem("alpine:3", "rootfs.tar", nil)
+//	if errors.Is(err, ErrOffline) {
+//	    log.Fatalf("alpine:3 was not cached: %v", err)
+//	}
+func WithOffline() ExporterOption {
+	return func(e *imageExporter) {
+		e.offline = true
+	}
+}
+
+// offlineTransport refuses every request in offline mode
+type offlineTransport struct{}
+
+// RoundTrip implements http.RoundTripper
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("request to %s refused: %w", req.URL.Host, ErrOffline)
+}
+
+// manifestPath returns where a manifest is stored, apart from the other blobs
+func (c *blobCache) manifestPath(digest v1.Hash) string {
+	return filepath.Join(c.dir, "blobs", "manifests", digest.Algorithm, digest.Hex)
+}
+
+// refPath returns where the digest a tag points to is recorded
+func (c *blobCache) refPath(ref name.Reference) string {
+	sum := sha256.Sum256([]byte(ref.Name()))
+	return filepath.Join(c.dir, "blobs", "refs", hex.EncodeToString(sum[:]))
+}
+
+// storeManifest records a manifest by digest. Recording is best effort: a
+// failure only costs offline use.
+func (c *blobCache) storeManifest(digest v1.Hash, raw []byte) {
+	if digest.Algorithm != "sha256" {
+		return
+	}
+	if _, err := os.Stat(c.manifestPath(digest)); err == nil {
+		return
+	}
+	if err := writeFileAtomic(c.manifestPath(digest), raw); err != nil {
+		c.logger.Debug("failed to cache manifest", "digest", digest.String(), "error", err)
+	}
+}
+
+// storeTag records the digest a tag points to, best effort
+func (c *blobCache) storeTag(tag name.Tag, digest v1.Hash) {
+	// The name is kept for people browsing the cache
+	record := digest.String() + " " + tag.Name() + "\n"
+	if err := writeFileAtomic(c.refPath(tag), []byte(record)); err != nil {
+		c.logger.Debug("failed to record tag", "image", tag.Name(), "error", err)
+	}
+}
+
+// resolve returns the digest ref points to according to the cache
+func (c *blobCache) resolve(ref name.Reference) (v1.Hash, error) {
+	if digest, ok := ref.(name.Digest); ok {
+		return v1.NewHash(digest.DigestStr())
+	}
+	data, err := os.ReadFile(c.refPath(ref))
+	if err != nil {
+		return v1.Hash{}, fmt.Errorf("%s was never exported with this cache: %w", ref, ErrOffline)
+	}
+	digest, _, _ := strings.Cut(strings.TrimSpace(string(data)), " ")
+	return v1.NewHash(digest)
+}
+
+// manifest returns a cached manifest and its media type
+func (c *blobCache) manifest(digest v1.Hash) ([]byte, types.MediaType, error) {
+	raw, err := os.ReadFile(c.manifestPath(digest))
+	if err != nil {
+		return nil, "", fmt.Errorf("manifest %s is not in the cache: %w", digest, ErrOffline)
+	}
+	var fields struct {
+		SchemaVersion int               `json:"schemaVersion"`
+		MediaType     types.MediaType   `json:"mediaType"`
+		Manifests     []json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, "", fmt.Errorf("cached manifest %s is corrupt: %w", digest, err)
+	}
+	switch {
+	case fields.SchemaVersion == 1:
+		return raw, types.DockerManifestSchema1, nil
+	case fields.MediaType != "":
+		return raw, fields.MediaType, nil
+	case fields.Manifests != nil:
+		return raw, types.OCIImageIndex, nil
+	default:
+		return raw, types.OCIManifestSchema1, nil
+	}
+}
+
+// writeFileAtomic writes a file through a temp file in the same directory
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".partial-*")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return nil
+}
+
+// recordManifest stores a manifest resolved online, and the digest ref points
+// to when it is a tag, so that it can be resolved again offline
+func (e *imageExporter) recordManifest(ref name.Reference, digest v1.Hash, raw []byte) {
+	if e.cache == nil || e.offline {
+		return
+	}
+	if raw != nil {
+		e.cache.storeManifest(digest, raw)
+	}
+	if tag, ok := ref.(name.Tag); ok {
+		e.cache.storeTag(tag, digest)
+	}
+}
+
+// offlineImage resolves ref from the cache, selecting platform (or linux/amd64)
+// from an index like the online path does
+func (e *imageExporter) offlineImage(ref name.Reference, platform *v1.Platform) (v1.Image, error) {
+	if e.cache == nil {
+		return nil, fmt.Errorf("offline mode needs a blob cache: %w", ErrOffline)
+	}
+	digest, err := e.cache.resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	raw, mediaType, err := e.cache.manifest(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	if mediaType.IsIndex() {
+		index, err := v1.ParseIndexManifest(bytes.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse cached index %s: %w", digest, err)
+		}
+		if err := checkAttestationIndex(ref, index); err != nil {
+			return nil, err
+		}
+		want := v1.Platform{OS: "linux", Architecture: "amd64"}
+		if platform != nil {
+			want = *platform
+		}
+		var child *v1.Descriptor
+		for i := range index.Manifests {
+			candidate := &index.Manifests[i]
+			if candidate.MediaType.IsImage() && platformMatches(candidate.Platform, want) {
+				child = candidate
+				break
+			}
+		}
+		if child == nil {
+			return nil, fmt.Errorf("no child with platform %s in index %s", want.String(), ref)
+		}
+		if raw, mediaType, err = e.cache.manifest(child.Digest); err != nil {
+			return nil, fmt.Errorf("platform %s of %s: %w", want.String(), ref, err)
+		}
+	}
+
+	if isSchema1(mediaType) {
+		e.log().Debug("schema1 manifest served offline", "image", ref.String(), "digest", digest.String())
+		return newSchema1Image(raw, func(layer v1.Hash) (partial.CompressedLayer, error) {
+			info, err := os.Stat(e.cache.path(layer))
+			if err != nil {
+				return nil, fmt.Errorf("layer %s is not in the cache: %w", layer, ErrOffline)
+			}
+			return &cachedLayer{cache: e.cache, desc: v1.Descriptor{MediaType: types.DockerLayer, Size: info.Size(), Digest: layer}}, nil
+		})
+	}
+
+	manifest, err := v1.ParseManifest(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cached manifest: %w", err)
+	}
+	e.log().Debug("manifest served offline", "image", ref.String(), "digest", digest.String())
+	return partial.CompressedToImage(&cachedManifest{cache: e.cache, raw: raw, mediaType: mediaType, manifest: manifest})
+}
+
+// cachedManifest is an image whose manifest, config and layers are read from
+// the blob cache
+type cachedManifest struct {
+	cache     *blobCache
+	raw       []byte
+	mediaType types.MediaType
+	manifest  *v1.Manifest
+}
+
+// RawConfigFile implements partial.CompressedImageCore
+func (m *cachedManifest) RawConfigFile() ([]byte, error) {
+	raw, err := os.ReadFile(m.cache.path(m.manifest.Config.Digest))
+	if err != nil {
+		return nil, fmt.Errorf("config %s is not in the cache: %w", m.manifest.Config.Digest, ErrOffline)
+	}
+	return raw, nil
+}
+
+// MediaType implements partial.CompressedImageCore
+func (m *cachedManifest) MediaType() (types.MediaType, error) {
+	return m.mediaType, nil
+}
+
+// RawManifest implements partial.CompressedImageCore
+func (m *cachedManifest) RawManifest() ([]byte, error) {
+	return m.raw, nil
+}
+
+// LayerByDigest implements partial.CompressedImageCore
+func (m *cachedManifest) LayerByDigest(digest v1.Hash) (partial.CompressedLayer, error) {
+	for _, layer := range m.manifest.Layers {
+		if layer.Digest == digest {
+			return &cachedLayer{cache: m.cache, desc: layer}, nil
+		}
+	}
+	return nil, fmt.Errorf("layer %s is not in the manifest", digest)
+}
+
+// cachedLayer is a layer blob read from the cache
+type cachedLayer struct {
+	cache *blobCache
+	desc  v1.Descriptor
+}
+
+// Digest implements partial.CompressedLayer
+func (l *cachedLayer) Digest() (v1.Hash, error) {
+	return l.desc.Digest, nil
+}
+
+// Compressed implements partial.CompressedLayer
+func (l *cachedLayer) Compressed() (io.ReadCloser, error) {
+	file, err := os.Open(l.cache.path(l.desc.Digest))
+	if err != nil {
+		return nil, fmt.Errorf("layer %s (%d bytes) is not in the cache: %w", l.desc.Digest, l.desc.Size, ErrOffline)
+	}
+	return file, nil
+}
+
+// Size implements partial.CompressedLayer
+func (l *cachedLayer) Size() (int64, error) {
+	return l.desc.Size, nil
+}
+
+// MediaType implements partial.CompressedLayer
+func (l *cachedLayer) MediaType() (types.MediaType, error) {
+	return l.desc.MediaType, nil
+}
+
+// Descriptor implements partial.Describable, keeping the annotations of the manifest
+func (l *cachedLayer) Descriptor() (*v1.Descriptor, error) {
+	desc := l.desc
+	return &desc, nil
+}
@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestOfflineExport(t *testing.T) {
+	host := newTestRegistry(t)
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "etc/motd", content: "hello"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	imageRef := host + "/test/app:v1"
+	pushTestImage(t, imageRef, image)
+	indexRef := host + "/test/multiarch:latest"
+	pushTestIndex(t, indexRef)
+
+	cacheDir := t.TempDir()
+	online := NewImageExporter(WithCache(cacheDir))
+	var expected bytes.Buffer
+	if err := online.ExportImageFilesystemToWriter(imageRef, &expected, nil); err != nil {
+		t.Fatalf("Expected no error online, got %v", err)
+	}
+	if err := online.ExportImageFilesystemToWriterWithOptions(indexRef, &bytes.Buffer{}, nil, &ExportOptions{Platform: "linux/arm64"}); err != nil {
+		t.Fatalf("Expected no error online, got %v", err)
+	}
+	if _, err := online.GetImageConfig(imageRef, nil); err != nil {
+		t.Fatalf("Expected no error online, got %v", err)
+	}
+
+	offline := NewImageExporter(WithCache(cacheDir), WithOffline())
+	var out bytes.Buffer
+	if err := offline.ExportImageFilesystemToWriter(imageRef, &out, nil); err != nil {
+		t.Fatalf("Expected no error offline, got %v", err)
+	}
+	if !reflect.DeepEqual(readTestTar(t, out.Bytes()), readTestTar(t, expected.Bytes())) {
+		t.Error("Expected the offline export to match the online one")
+	}
+	config, err := offline.GetImageConfig(imageRef, nil)
+	if err != nil || config == nil {
+		t.Errorf("Expected the config offline, got %v", err)
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		t.Fatalf("Failed to get test digest: %v", err)
+	}
+	if resolved, err := offline.ResolveDigest(imageRef, nil); err != nil || resolved != digest.String() {
+		t.Errorf("Expected %s offline, got %q, %v", digest, resolved, err)
+	}
+
+	out.Reset()
+	if err := offline.ExportImageFilesystemToWriterWithOptions(indexRef, &out, nil, &ExportOptions{Platform: "linux/arm64"}); err != nil {
+		t.Fatalf("Expected the cached platform offline, got %v", err)
+	}
+	if files := readTestTar(t, out.Bytes()); files["bin/app"] != "arm64" {
+		t.Errorf("Expected the arm64 filesystem, got %v", files)
+	}
+
+	// Anything that was never cached fails clearly, without the network
+	for _, attempt := range []func() error{
+		func() error {
+			return offline.ExportImageFilesystemToWriterWithOptions(indexRef, &bytes.Buffer{}, nil, &ExportOptions{Platform: "linux/amd64"})
+		},
+		func() error { return offline.ExportImageFilesystemToWriter(host+"/test/app:v2", &bytes.Buffer{}, nil) },
+		func() error { _, err := offline.ListTags(host+"/test/app", nil, nil); return err },
+		func() error { _, err := NewImageExporter(WithOffline()).GetImageConfig(imageRef, nil); return err },
+	} {
+		if err := attempt(); !errors.Is(err, ErrOffline) {
+			t.Errorf("Expected ErrOffline, got %v", err)
+		}
+	}
+
+	// A layer removed from the cache is named in the error
+	layers, err := image.Layers()
+	if err != nil {
+		t.Fatalf("Failed to get test layers: %v", err)
+	}
+	layerDigest, err := layers[0].Digest()
+	if err != nil {
+		t.Fatalf("Failed to get layer digest: %v", err)
+	}
+	if err := os.Remove(filepath.Join(cacheDir, "blobs", "sha256", layerDigest.Hex)); err != nil {
+		t.Fatalf("Failed to remove cached layer: %v", err)
+	}
+	err = offline.ExportImageFilesystemToWriter(imageRef, &bytes.Buffer{}, nil)
+	if !errors.Is(err, ErrOffline) || !strings.Contains(err.Error(), layerDigest.String()) {
+		t.Errorf("Expected ErrOffline naming %s, got %v", layerDigest, err)
+	}
+}
@@ -0,0 +1,161 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestWithProxy_RoutesRegistryTraffic(t *testing.T) {
+	var mu sync.Mutex
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.Host)
+		mu.Unlock()
+		http.Error(w, "blocked by test proxy", http.StatusForbidden)
+	}))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse proxy URL: %v", err)
+	}
+
+	exporter := NewImageExporter(WithProxy(proxyURL))
+	_, err = exporter.GetImageConfig("registry.example.com/private/image:latest", &AuthConfig{})
+	if err == nil {
+		t.Fatal("Expected error when proxy rejects the request")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(proxied) == 0 {
+		t.Fatal("Expected registry request to go through the proxy")
+	}
+	if proxied[0] != "registry.example.com:443" {
+		t.Errorf("Expected CONNECT to registry.example.com:443, got %s", proxied[0])
+	}
+}
+
+// countingTransport records requests before delegating to the default transport
+type countingTransport struct {
+	mu       sync.Mutex
+	requests int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.requests++
+	c.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestWithTransport_UsesCustomRoundTripper(t *testing.T) {
+	layer := newTestLayer(t, testEntry{name: "file", content: "data"})
+	image, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+
+	host := newTestRegistry(t)
+	imageRef := host + "/test/transport:latest"
+	pushTestImage(t, imageRef, image)
+
+	transport := &countingTransport{}
+	exporter := NewImageExporter(WithTransport(transport))
+	if _, err := exporter.GetImageConfig(imageRef, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	if transport.requests == 0 {
+		t.Fatal("Expected registry requests to use the custom transport")
+	}
+}
+
+// roundTripperFunc adapts a function to http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestWithTransportWrapper_KeepsProxy(t *testing.T) {
+	var mu sync.Mutex
+	var proxied, wrapped []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.Host)
+		mu.Unlock()
+		http.Error(w, "blocked by test proxy", http.StatusForbidden)
+	}))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse proxy URL: %v", err)
+	}
+
+	wrapper := func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			wrapped = append(wrapped, req.URL.Host)
+			mu.Unlock()
+			return next.RoundTrip(req)
+		})
+	}
+	exporter := NewImageExporter(WithProxy(proxyURL), WithTransportWrapper(wrapper))
+	if _, err := exporter.GetImageConfig("registry.example.com/private/image:latest", &AuthConfig{}); err == nil {
+		t.Fatal("Expected error when proxy rejects the request")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(wrapped) == 0 || wrapped[0] != "registry.example.com" {
+		t.Errorf("Expected the wrapper to see the registry request, got %v", wrapped)
+	}
+	if len(proxied) == 0 {
+		t.Error("Expected the wrapped transport to still use the proxy")
+	}
+}
+
+func TestRemoteOptions_RegistryToken(t *testing.T) {
+	var mu sync.Mutex
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		authorization = r.Header.Get("Authorization")
+		mu.Unlock()
+		if r.URL.Path == "/v2/" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse server URL: %v", err)
+	}
+
+	exporter := NewImageExporter()
+	_, err = exporter.GetImageConfig(u.Host+"/private/image:latest", &AuthConfig{RegistryToken: "pre-minted"})
+	if err == nil {
+		t.Fatal("Expected error from stub registry")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if authorization != "Bearer pre-minted" {
+		t.Errorf("Expected bearer token to be sent, got %q", authorization)
+	}
+}
@@ -0,0 +1,604 @@
+package lib
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Package types of a PackageInventory, which are also the purl types of the
+// packages
+const (
+	PackageTypeAPK   = "apk"
+	PackageTypeDeb   = "deb"
+	PackageTypeRPM   = "rpm"
+	PackageTypeNPM   = "npm"
+	PackageTypePyPI  = "pypi"
+	PackageTypeCargo = "cargo"
+)
+
+// maxPackageDatabase bounds the size of a package database or lockfile read
+// into memory
+const maxPackageDatabase = 512 << 20
+
+// GenerateSBOMOptions configures GenerateSBOM
+type GenerateSBOMOptions struct {
+	// Platform selects the image of a multi-platform index, e.g. "linux/arm64";
+	// empty for the default platform
+	Platform string
+
+	// Lockfiles also inventories the dependencies pinned by language lockfiles
+	// (package-lock.json, poetry.lock, requirements.txt and Cargo.lock)
+	Lockfiles bool
+}
+
+// PackageInventory lists the packages installed in an image. It is returned by
+// the GenerateSBOM method and converted to an SBOM by Encode.
+type PackageInventory struct {
+	// SchemaVersion is the version of this JSON document (see SchemaVersion)
+	SchemaVersion int `json:"schema_version"`
+
+	// Reference is the image reference that was inventoried
+	Reference string `json:"reference"`
+
+	// Digest is the manifest digest of the image
+	Digest string `json:"digest"`
+
+	// Distro identifies the distribution from /etc/os-release, if present
+	Distro *Distro `json:"distro,omitempty"`
+
+	// Packages lists the packages in the order their databases list them:
+	// those of the system package manager first, then those of lockfiles
+	Packages []Package `json:"packages"`
+}
+
+// Distro identifies the distribution of an image
+type Distro struct {
+	// ID is the os-release ID, e.g. "alpine", "debian" or "rhel"
+	ID string `json:"id"`
+
+	// VersionID is the os-release VERSION_ID, e.g. "3.20" or "12"
+	VersionID string `json:"version_id,omitempty"`
+
+	// Name is the os-release PRETTY_NAME, e.g. "Debian GNU/Linux 12 (bookworm)"
+	Name string `json:"name,omitempty"`
+}
+
+// Package is one package found in an image
+type Package struct {
+	// Name is the package name
+	Name string `json:"name"`
+
+	// Version is the package version; RPM versions are "[epoch:]version-release"
+	Version string `json:"version"`
+
+	// Type is the package ecosystem: apk, deb, rpm, npm, pypi or cargo
+	Type string `json:"type"`
+
+	// Arch is the architecture of an OS package, e.g. "x86_64" or "all"
+	Arch string `json:"arch,omitempty"`
+
+	// License is the license the package database declares, as written there
+	License string `json:"license,omitempty"`
+
+	// Source is the source package an OS package was built from
+	Source string `json:"source,omitempty"`
+
+	// PURL is the package URL of the package
+	PURL string `json:"purl"`
+
+	// Location is the path of the database or lockfile listing the package
+	Location string `json:"location"`
+}
+
+// GenerateSBOM inventories the packages installed in an image by reading the
+// package databases of its flattened filesystem: apk (lib/apk/db/installed),
+// dpkg (var/lib/dpkg/status and the status.d directory of distroless images)
+// and rpm (the SQLite, Berkeley DB and NDB databases in var/lib/rpm or
+// usr/lib/sysimage/rpm). With opts.Lockfiles, the dependencies pinned by
+// language lockfiles anywhere in the image are added.
+//
+// Unlike SBOMs, which downloads the SBOMs attached to an image, GenerateSBOM
+// describes the image contents themselves; packages installed without a
+// package manager are not found. Encode converts the inventory to SPDX or
+// CycloneDX.
+//
+// Parameters:
+//   - imageRef: Image reference (e.g., "debian:bookworm")
+//   - auth: Optional authentication configuration for private registries
+//   - opts: Optional platform and lockfile options
+//
+// Returns:
+//   - *PackageInventory: The packages found, possibly none
+//   - error: Any error encountered, including unreadable package databases
+//
+// Example:
+//
+//	inventory, err := exporter.GenerateSBOM("debian:bookworm", nil, nil)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	for _, pkg := range inventory.Packages {
+//	    fmt.Println(pkg.Name, pkg.Version)
+//	}
+func (e *imageExporter) GenerateSBOM(imageRef string, auth *AuthConfig, opts *GenerateSBOMOptions) (*PackageInventory, error) {
+	if opts == nil {
+		opts = &GenerateSBOMOptions{}
+	}
+
+	image, filesystem, err := e.flattenImage(imageRef, auth, opts.Platform)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image digest: %w", err)
+	}
+
+	inventory := &PackageInventory{
+		SchemaVersion: SchemaVersion,
+		Reference:     imageRef,
+		Digest:        digest.String(),
+		Distro:        readDistro(filesystem),
+		Packages:      []Package{},
+	}
+	for _, read := range []func(map[string]*fileEntry, *Distro) ([]Package, error){
+		readAPKPackages, readDpkgPackages, readRPMPackages,
+	} {
+		packages, err := read(filesystem, inventory.Distro)
+		if err != nil {
+			return nil, err
+		}
+		inventory.Packages = append(inventory.Packages, packages...)
+	}
+	if opts.Lockfiles {
+		packages, err := readLockfilePackages(filesystem)
+		if err != nil {
+			return nil, err
+		}
+		inventory.Packages = append(inventory.Packages, packages...)
+	}
+	e.log().Debug("packages inventoried", "image", imageRef, "packages", len(inventory.Packages))
+	return inventory, nil
+}
+
+// readPackageFile returns the content of a regular file of the filesystem,
+// following symlinks, or nil if there is none
+func readPackageFile(filesystem map[string]*fileEntry, p string) ([]byte, error) {
+	entry, ok := resolveFile(filesystem, p)
+	if !ok || entry == nil || (entry.header.Typeflag != tar.TypeReg && entry.header.Typeflag != tar.TypeRegA) {
+		return nil, nil
+	}
+	if entry.header.Size > maxPackageDatabase {
+		return nil, fmt.Errorf("failed to read /%s: %d bytes exceed the %d byte limit", p, entry.header.Size, maxPackageDatabase)
+	}
+	data, err := io.ReadAll(entry.content())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read /%s: %w", p, err)
+	}
+	return data, nil
+}
+
+// readDistro identifies the distribution from os-release
+func readDistro(filesystem map[string]*fileEntry) *Distro {
+	for _, p := range []string{"etc/os-release", "usr/lib/os-release"} {
+		data, err := readPackageFile(filesystem, p)
+		if err != nil || data == nil {
+			continue
+		}
+		distro := &Distro{}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+			if !ok {
+				continue
+			}
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			} else {
+				value = strings.Trim(value, `'"`)
+			}
+			switch key {
+			case "ID":
+				distro.ID = value
+			case "VERSION_ID":
+				distro.VersionID = value
+			case "PRETTY_NAME":
+				distro.Name = value
+			}
+		}
+		if distro.ID != "" {
+			return distro
+		}
+	}
+	return nil
+}
+
+// namespace returns the purl namespace of the OS packages of the distribution,
+// or fallback if it is unknown
+func (d *Distro) namespace(fallback string) string {
+	if d == nil {
+		return fallback
+	}
+	return d.ID
+}
+
+// qualifier returns the purl distro qualifier, e.g. "alpine-3.20"
+func (d *Distro) qualifier() string {
+	if d == nil || d.VersionID == "" {
+		return ""
+	}
+	return d.ID + "-" + d.VersionID
+}
+
+// packageURL builds a purl; empty qualifiers are left out
+func packageURL(purlType, namespace, name, version string, qualifiers ...[2]string) string {
+	// "@" separates the version, so it is escaped in the other components
+	escape := func(s string) string { return strings.ReplaceAll(url.PathEscape(s), "@", "%40") }
+	var purl strings.Builder
+	purl.WriteString("pkg:" + purlType + "/")
+	if namespace != "" {
+		purl.WriteString(escape(namespace) + "/")
+	}
+	purl.WriteString(escape(name))
+	if version != "" {
+		purl.WriteString("@" + escape(version))
+	}
+	sort.Slice(qualifiers, func(i, j int) bool { return qualifiers[i][0] < qualifiers[j][0] })
+	separator := "?"
+	for _, qualifier := range qualifiers {
+		if qualifier[1] != "" {
+			purl.WriteString(separator + qualifier[0] + "=" + url.QueryEscape(qualifier[1]))
+			separator = "&"
+		}
+	}
+	return purl.String()
+}
+
+// parseStanzas splits a package database made of blank-line separated
+// stanzas of "Key<separator>value" lines. Lines starting with a space continue
+// the previous value, as in dpkg status files.
+func parseStanzas(data []byte, separator string) []map[string]string {
+	var stanzas []map[string]string
+	stanza := map[string]string{}
+	var last string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxPackageDatabase)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.TrimSpace(line) == "":
+			if len(stanza) > 0 {
+				stanzas = append(stanzas, stanza)
+				stanza = map[string]string{}
+			}
+		case (line[0] == ' ' || line[0] == '\t') && last != "":
+			stanza[last] += "\n" + strings.TrimSpace(line)
+		default:
+			key, value, ok := strings.Cut(line, separator)
+			if !ok {
+				continue
+			}
+			last = key
+			stanza[key] = strings.TrimSpace(value)
+		}
+	}
+	if len(stanza) > 0 {
+		stanzas = append(stanzas, stanza)
+	}
+	return stanzas
+}
+
+// readAPKPackages reads the apk database of Alpine and Wolfi images
+func readAPKPackages(filesystem map[string]*fileEntry, distro *Distro) ([]Package, error) {
+	const database = "lib/apk/db/installed"
+	data, err := readPackageFile(filesystem, database)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var packages []Package
+	for _, stanza := range parseStanzas(data, ":") {
+		if stanza["P"] == "" {
+			continue
+		}
+		packages = append(packages, Package{
+			Name:     stanza["P"],
+			Version:  stanza["V"],
+			Type:     PackageTypeAPK,
+			Arch:     stanza["A"],
+			License:  stanza["L"],
+			Source:   stanza["o"],
+			PURL:     packageURL(PackageTypeAPK, distro.namespace("alpine"), stanza["P"], stanza["V"], [2]string{"arch", stanza["A"]}, [2]string{"distro", distro.qualifier()}),
+			Location: "/" + database,
+		})
+	}
+	return packages, nil
+}
+
+// readDpkgPackages reads the dpkg status database of Debian and Ubuntu images,
+// and the per-package status files distroless images ship instead
+func readDpkgPackages(filesystem map[string]*fileEntry, distro *Distro) ([]Package, error) {
+	databases := []string{"var/lib/dpkg/status"}
+	var statusFiles []string
+	for p, entry := range filesystem {
+		if path.Dir(p) == "var/lib/dpkg/status.d" && entry.header.Typeflag != tar.TypeDir && !strings.HasSuffix(p, ".md5sums") {
+			statusFiles = append(statusFiles, p)
+		}
+	}
+	sort.Strings(statusFiles)
+	databases = append(databases, statusFiles...)
+
+	var packages []Package
+	for _, database := range databases {
+		data, err := readPackageFile(filesystem, database)
+		if err != nil {
+			return nil, err
+		}
+		for _, stanza := range parseStanzas(data, ":") {
+			// status.d files have no Status field: they list installed packages
+			if stanza["Package"] == "" || (stanza["Status"] != "" && !strings.HasSuffix(stanza["Status"], " installed")) {
+				continue
+			}
+			source, _, _ := strings.Cut(stanza["Source"], " (")
+			packages = append(packages, Package{
+				Name:     stanza["Package"],
+				Version:  stanza["Version"],
+				Type:     PackageTypeDeb,
+				Arch:     stanza["Architecture"],
+				Source:   source,
+				PURL:     packageURL(PackageTypeDeb, distro.namespace("debian"), stanza["Package"], stanza["Version"], [2]string{"arch", stanza["Architecture"]}, [2]string{"distro", distro.qualifier()}),
+				Location: "/" + database,
+			})
+		}
+	}
+	return packages, nil
+}
+
+// rpmDatabases are the rpm databases, newest format first in each directory:
+// SQLite (RPM 4.16+), NDB (SUSE) and Berkeley DB
+var rpmDatabases = []struct {
+	name string
+	read func([]byte) ([][]byte, error)
+}{
+	{"rpmdb.sqlite", readRPMSQLite},
+	{"Packages.db", readRPMNDB},
+	{"Packages", readRPMBerkeleyDB},
+}
+
+// readRPMPackages reads the rpm database of Red Hat, Fedora and SUSE images
+func readRPMPackages(filesystem map[string]*fileEntry, distro *Distro) ([]Package, error) {
+	for _, dir := range []string{"usr/lib/sysimage/rpm", "var/lib/rpm"} {
+		for _, database := range rpmDatabases {
+			p := dir + "/" + database.name
+			data, err := readPackageFile(filesystem, p)
+			if err != nil {
+				return nil, err
+			}
+			if data == nil {
+				continue
+			}
+			headers, err := database.read(data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read rpm database /%s: %w", p, err)
+			}
+			var packages []Package
+			for _, blob := range headers {
+				header, err := parseRPMHeader(blob)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read rpm database /%s: %w", p, err)
+				}
+				// Imported signing keys are recorded as packages
+				if header.name == "gpg-pubkey" {
+					continue
+				}
+				packages = append(packages, header.pkg(distro, "/"+p))
+			}
+			return packages, nil
+		}
+	}
+	return nil, nil
+}
+
+// lockfileParsers parse the language lockfiles, by file name
+var lockfileParsers = map[string]func([]byte) ([]Package, error){
+	"package-lock.json": parsePackageLock,
+	"poetry.lock":       parseTOMLLock(PackageTypePyPI),
+	"Cargo.lock":        parseTOMLLock(PackageTypeCargo),
+	"requirements.txt":  parseRequirements,
+}
+
+// readLockfilePackages reads the language lockfiles of the filesystem, except
+// those of installed npm packages
+func readLockfilePackages(filesystem map[string]*fileEntry) ([]Package, error) {
+	var lockfiles []string
+	for p, entry := range filesystem {
+		if lockfileParsers[path.Base(p)] != nil && entry.header.Typeflag != tar.TypeDir &&
+			!strings.Contains("/"+p, "/node_modules/") {
+			lockfiles = append(lockfiles, p)
+		}
+	}
+	sort.Strings(lockfiles)
+
+	var packages []Package
+	for _, lockfile := range lockfiles {
+		data, err := readPackageFile(filesystem, lockfile)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+		found, err := lockfileParsers[path.Base(lockfile)](data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read /%s: %w", lockfile, err)
+		}
+		for _, pkg := range found {
+			pkg.Location = "/" + lockfile
+			packages = append(packages, pkg)
+		}
+	}
+	return packages, nil
+}
+
+// parsePackageLock reads an npm package-lock.json: the packages map of
+// lockfile versions 2 and 3, or the nested dependencies of version 1
+func parsePackageLock(data []byte) ([]Package, error) {
+	type dependency struct {
+		Version      string                     `json:"version"`
+		Link         bool                       `json:"link"`
+		Dependencies map[string]json.RawMessage `json:"dependencies"`
+	}
+	var lock struct {
+		Packages     map[string]dependency      `json:"packages"`
+		Dependencies map[string]json.RawMessage `json:"dependencies"`
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, err
+	}
+
+	versions := map[[2]string]bool{}
+	if lock.Packages != nil {
+		for key, dep := range lock.Packages {
+			i := strings.LastIndex(key, "node_modules/")
+			if i < 0 || dep.Link || dep.Version == "" {
+				continue
+			}
+			versions[[2]string{key[i+len("node_modules/"):], dep.Version}] = true
+		}
+	} else {
+		var walk func(map[string]json.RawMessage) error
+		walk = func(dependencies map[string]json.RawMessage) error {
+			for name, raw := range dependencies {
+				var dep dependency
+				if err := json.Unmarshal(raw, &dep); err != nil {
+					return err
+				}
+				if dep.Version != "" && !strings.HasPrefix(dep.Version, "file:") {
+					versions[[2]string{name, dep.Version}] = true
+				}
+				if err := walk(dep.Dependencies); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err := walk(lock.Dependencies); err != nil {
+			return nil, err
+		}
+	}
+
+	packages := make([]Package, 0, len(versions))
+	for version := range versions {
+		namespace, name := "", version[0]
+		if strings.HasPrefix(name, "@") {
+			namespace, name, _ = strings.Cut(name, "/")
+		}
+		packages = append(packages, Package{
+			Name:    version[0],
+			Version: version[1],
+			Type:    PackageTypeNPM,
+			PURL:    packageURL(PackageTypeNPM, namespace, name, version[1]),
+		})
+	}
+	sortPackages(packages)
+	return packages, nil
+}
+
+// parseTOMLLock returns a parser of the [[package]] tables of poetry.lock and
+// Cargo.lock files
+func parseTOMLLock(purlType string) func([]byte) ([]Package, error) {
+	return func(data []byte) ([]Package, error) {
+		var packages []Package
+		var current *Package
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), maxPackageDatabase)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if strings.HasPrefix(line, "[") {
+				current = nil
+				if line == "[[package]]" {
+					packages = append(packages, Package{Type: purlType})
+					current = &packages[len(packages)-1]
+				}
+				continue
+			}
+			key, value, ok := strings.Cut(line, "=")
+			if current == nil || !ok {
+				continue
+			}
+			value, err := strconv.Unquote(strings.TrimSpace(value))
+			if err != nil {
+				continue
+			}
+			switch strings.TrimSpace(key) {
+			case "name":
+				current.Name = value
+			case "version":
+				current.Version = value
+			}
+		}
+		found := packages[:0]
+		for _, pkg := range packages {
+			if pkg.Name == "" || pkg.Version == "" {
+				continue
+			}
+			name := pkg.Name
+			if purlType == PackageTypePyPI {
+				name = normalizePyPIName(name)
+			}
+			pkg.PURL = packageURL(purlType, "", name, pkg.Version)
+			found = append(found, pkg)
+		}
+		return found, nil
+	}
+}
+
+// parseRequirements reads the requirements pinned with == in a pip
+// requirements.txt; other requirements have no single version
+func parseRequirements(data []byte) ([]Package, error) {
+	var packages []Package
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line, _, _ = strings.Cut(line, ";")
+		name, version, ok := strings.Cut(strings.TrimSpace(line), "==")
+		if !ok || strings.HasPrefix(name, "-") {
+			continue
+		}
+		name, _, _ = strings.Cut(strings.TrimSpace(name), "[")
+		version, _, _ = strings.Cut(strings.TrimSpace(version), " ")
+		if name == "" || version == "" {
+			continue
+		}
+		packages = append(packages, Package{
+			Name:    name,
+			Version: version,
+			Type:    PackageTypePyPI,
+			PURL:    packageURL(PackageTypePyPI, "", normalizePyPIName(name), version),
+		})
+	}
+	return packages, nil
+}
+
+// normalizePyPIName normalizes a Python package name as purls require
+func normalizePyPIName(name string) string {
+	return strings.NewReplacer("_", "-", ".", "-").Replace(strings.ToLower(name))
+}
+
+// sortPackages orders packages by name, then version
+func sortPackages(packages []Package) {
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].Name != packages[j].Name {
+			return packages[i].Name < packages[j].Name
+		}
+		return packages[i].Version < packages[j].Version
+	})
+}
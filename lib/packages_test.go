@@ -0,0 +1,375 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// newTestRPMHeader builds an rpm header blob as stored in the rpm database
+func newTestRPMHeader(name, version, release, arch, license string, epoch int) []byte {
+	var index, store bytes.Buffer
+	add := func(tag, kind uint32, value []byte) {
+		for kind == rpmTypeInt32 && store.Len()%4 != 0 {
+			store.WriteByte(0)
+		}
+		binary.Write(&index, binary.BigEndian, [4]uint32{tag, kind, uint32(store.Len()), 1})
+		store.Write(value)
+	}
+	for _, field := range []struct {
+		tag   uint32
+		value string
+	}{{rpmTagName, name}, {rpmTagVersion, version}, {rpmTagRelease, release}, {rpmTagArch, arch}, {rpmTagLicense, license}} {
+		add(field.tag, rpmTypeString, append([]byte(field.value), 0))
+	}
+	if epoch != 0 {
+		add(rpmTagEpoch, rpmTypeInt32, binary.BigEndian.AppendUint32(nil, uint32(epoch)))
+	}
+	blob := binary.BigEndian.AppendUint32(nil, uint32(index.Len()/16))
+	blob = binary.BigEndian.AppendUint32(blob, uint32(store.Len()))
+	return append(append(blob, index.Bytes()...), store.Bytes()...)
+}
+
+// testSQLite builds an SQLite database of 512-byte pages
+type testSQLite struct {
+	pages [][]byte
+}
+
+// putVarint encodes an SQLite variable-length integer below 2^56
+func (db *testSQLite) putVarint(v uint64) []byte {
+	out := []byte{byte(v & 0x7f)}
+	for v >>= 7; v > 0; v >>= 7 {
+		out = append([]byte{byte(v&0x7f) | 0x80}, out...)
+	}
+	return out
+}
+
+// record encodes values (nil, int64, string or []byte) as a record
+func (db *testSQLite) record(values ...any) []byte {
+	var header, body []byte
+	for _, value := range values {
+		switch v := value.(type) {
+		case nil:
+			header = append(header, 0)
+		case int64:
+			header = append(header, 6)
+			body = binary.BigEndian.AppendUint64(body, uint64(v))
+		case string:
+			header = append(header, db.putVarint(uint64(13+2*len(v)))...)
+			body = append(body, v...)
+		case []byte:
+			header = append(header, db.putVarint(uint64(12+2*len(v)))...)
+			body = append(body, v...)
+		}
+	}
+	return append(append(db.putVarint(uint64(len(header)+1)), header...), body...)
+}
+
+// cell builds a leaf cell, spilling the payload to overflow pages as SQLite does
+func (db *testSQLite) cell(rowid int, payload []byte) []byte {
+	const usable = 512
+	local := len(payload)
+	if local > usable-35 {
+		minLocal := (usable-12)*32/255 - 23
+		if local = minLocal + (len(payload)-minLocal)%(usable-4); local > usable-35 {
+			local = minLocal
+		}
+	}
+	cell := append(db.putVarint(uint64(len(payload))), db.putVarint(uint64(rowid))...)
+	cell = append(cell, payload[:local]...)
+	if local == len(payload) {
+		return cell
+	}
+	cell = binary.BigEndian.AppendUint32(cell, uint32(len(db.pages)+1))
+	for rest := payload[local:]; len(rest) > 0; {
+		chunk := rest[:min(len(rest), usable-4)]
+		rest = rest[len(chunk):]
+		page := make([]byte, 512)
+		if len(rest) > 0 {
+			binary.BigEndian.PutUint32(page, uint32(len(db.pages)+2))
+		}
+		copy(page[4:], chunk)
+		db.pages = append(db.pages, page)
+	}
+	return cell
+}
+
+// page lays out a b-tree page of the given type; the cells of leaf pages
+// and the child pointers of interior pages are stored at the end
+func (db *testSQLite) page(n int, kind byte, right uint32, cells ...[]byte) []byte {
+	page := make([]byte, 512)
+	header := page
+	if n == 1 {
+		copy(page, "SQLite format 3\x00")
+		binary.BigEndian.PutUint16(page[16:], 512)
+		header = page[100:]
+	}
+	header[0] = kind
+	binary.BigEndian.PutUint16(header[3:], uint16(len(cells)))
+	pointers := header[8:]
+	if kind == 0x05 {
+		binary.BigEndian.PutUint32(header[8:], right)
+		pointers = header[12:]
+	}
+	end := len(page)
+	for i, cell := range cells {
+		end -= len(cell)
+		copy(page[end:], cell)
+		binary.BigEndian.PutUint16(pointers[2*i:], uint16(end))
+	}
+	binary.BigEndian.PutUint16(header[5:], uint16(end))
+	return page
+}
+
+// newTestRPMSQLite builds an rpmdb.sqlite holding one header per leaf page,
+// under an interior root page
+func newTestRPMSQLite(headers ...[]byte) []byte {
+	db := &testSQLite{pages: make([][]byte, 2)}
+	schema := db.record("table", "Packages", "Packages", int64(2),
+		"CREATE TABLE Packages (hnum INTEGER PRIMARY KEY AUTOINCREMENT, blob BLOB NOT NULL)")
+	db.pages[0] = db.page(1, 0x0d, 0, db.cell(1, schema))
+
+	var leaves []uint32
+	for i, header := range headers {
+		cell := db.cell(i+1, db.record(nil, header))
+		db.pages = append(db.pages, db.page(len(db.pages)+1, 0x0d, 0, cell))
+		leaves = append(leaves, uint32(len(db.pages)))
+	}
+	var children [][]byte
+	for i, leaf := range leaves[:len(leaves)-1] {
+		children = append(children, append(binary.BigEndian.AppendUint32(nil, leaf), db.putVarint(uint64(i+1))...))
+	}
+	db.pages[1] = db.page(2, 0x05, leaves[len(leaves)-1], children...)
+	return bytes.Join(db.pages, nil)
+}
+
+// newTestRPMNDB builds a Packages.db holding the headers
+func newTestRPMNDB(headers ...[]byte) []byte {
+	le := binary.LittleEndian
+	data := make([]byte, 4096)
+	le.PutUint32(data[0:], ndbHeaderMagic)
+	le.PutUint32(data[12:], 1)
+	for i, header := range headers {
+		slot := data[32+i*16:]
+		le.PutUint32(slot[0:], ndbSlotMagic)
+		le.PutUint32(slot[4:], uint32(i+1))
+		le.PutUint32(slot[8:], uint32(len(data)/ndbBlockSize))
+		blob := le.AppendUint32(nil, ndbBlobMagic)
+		blob = le.AppendUint32(blob, uint32(i+1))
+		blob = le.AppendUint32(blob, 0)
+		blob = le.AppendUint32(blob, uint32(len(header)))
+		blob = append(blob, header...)
+		data = append(data, append(blob, make([]byte, (16-len(blob)%16)%16)...)...)
+	}
+	return data
+}
+
+// newTestRPMBerkeleyDB builds a big-endian Packages hash database holding the
+// headers on overflow pages
+func newTestRPMBerkeleyDB(headers ...[]byte) []byte {
+	be := binary.BigEndian
+	pages := [][]byte{make([]byte, 512), make([]byte, 512)}
+	be.PutUint32(pages[0][12:], bdbHashMagic)
+	be.PutUint32(pages[0][20:], 512)
+	hash := pages[1]
+	hash[25] = bdbPageHash
+	be.PutUint16(hash[20:], uint16(2*len(headers)))
+	for i, header := range headers {
+		key := 512 - 16*(i+1)
+		hash[key] = 1
+		be.PutUint32(hash[key+1:], uint32(i+1))
+		be.PutUint16(hash[bdbPageHeader+4*i:], uint16(key))
+		value := 512 - 16*len(headers) - 16*(i+1)
+		be.PutUint16(hash[bdbPageHeader+4*i+2:], uint16(value))
+		hash[value] = bdbItemOffPage
+		be.PutUint32(hash[value+4:], uint32(len(pages)))
+		be.PutUint32(hash[value+8:], uint32(len(header)))
+		for rest := header; len(rest) > 0; {
+			chunk := rest[:min(len(rest), 512-bdbPageHeader)]
+			rest = rest[len(chunk):]
+			page := make([]byte, 512)
+			page[25] = bdbPageOverflow
+			be.PutUint16(page[22:], uint16(len(chunk)))
+			if len(rest) > 0 {
+				be.PutUint32(page[16:], uint32(len(pages)+1))
+			}
+			copy(page[bdbPageHeader:], chunk)
+			pages = append(pages, page)
+		}
+	}
+	return bytes.Join(pages, nil)
+}
+
+func TestRPMDatabases(t *testing.T) {
+	headers := [][]byte{
+		newTestRPMHeader("bash", "5.1.8", "9.el9", "x86_64", "GPLv3+", 0),
+		// Long enough to spill to overflow pages
+		newTestRPMHeader("openssl-libs", "3.0.7", "27.el9", "x86_64", strings.Repeat("ASL 2.0 and ", 100)+"MIT", 1),
+		newTestRPMHeader("gpg-pubkey", "fd431d51", "4ae0493b", "", "pubkey", 0),
+	}
+	for name, data := range map[string][]byte{
+		"rpmdb.sqlite": newTestRPMSQLite(headers...),
+		"Packages.db":  newTestRPMNDB(headers...),
+		"Packages":     newTestRPMBerkeleyDB(headers...),
+	} {
+		for _, database := range rpmDatabases {
+			if database.name != name {
+				continue
+			}
+			blobs, err := database.read(data)
+			if err != nil {
+				t.Fatalf("Expected %s to be read, got %v", name, err)
+			}
+			if len(blobs) != len(headers) {
+				t.Fatalf("Expected %d headers in %s, got %d", len(headers), name, len(blobs))
+			}
+			for i, blob := range blobs {
+				if !bytes.Equal(blob, headers[i]) {
+					t.Errorf("Expected header %d of %s to be intact", i, name)
+				}
+			}
+			if _, err := database.read(data[:len(data)/2]); err == nil {
+				t.Errorf("Expected error for a truncated %s, got nil", name)
+			}
+		}
+	}
+
+	header, err := parseRPMHeader(headers[1])
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	pkg := header.pkg(&Distro{ID: "rhel", VersionID: "9.4"}, "/var/lib/rpm/rpmdb.sqlite")
+	if pkg.Version != "1:3.0.7-27.el9" {
+		t.Errorf("Expected version 1:3.0.7-27.el9, got %s", pkg.Version)
+	}
+	if pkg.PURL != "pkg:rpm/rhel/openssl-libs@3.0.7-27.el9?arch=x86_64&distro=rhel-9.4&epoch=1" {
+		t.Errorf("Expected the openssl-libs purl, got %s", pkg.PURL)
+	}
+}
+
+func TestGenerateSBOM(t *testing.T) {
+	host := newTestRegistry(t)
+	imageRef := host + "/test/app:v1"
+
+	apk := `P:musl
+V:1.2.5-r0
+A:x86_64
+L:MIT
+o:musl
+
+P:busybox
+V:1.36.1-r29
+A:x86_64
+L:GPL-2.0-only
+o:busybox
+`
+	dpkg := `Package: libc6
+Status: install ok installed
+Architecture: amd64
+Source: glibc (2.36-9+deb12u7)
+Version: 2.36-9+deb12u7
+Description: GNU C Library
+ Shared libraries.
+
+Package: vim
+Status: deinstall ok config-files
+Architecture: amd64
+Version: 2:9.0.1378-2
+`
+	image, err := mutate.AppendLayers(empty.Image,
+		newTestLayer(t,
+			testEntry{name: "etc/os-release", content: "ID=alpine\nVERSION_ID=3.20.0\nPRETTY_NAME=\"Alpine Linux v3.20\"\n"},
+			testEntry{name: "lib/apk/db/installed", content: apk},
+			testEntry{name: "var/lib/dpkg/status", content: dpkg},
+			testEntry{name: "var/lib/dpkg/status.d/tzdata", content: "Package: tzdata\nVersion: 2024a-0+deb12u1\nArchitecture: all\n"},
+			testEntry{name: "var/lib/dpkg/status.d/tzdata.md5sums", content: "d41d8cd98f00b204e9800998ecf8427e  usr/share/zoneinfo/UTC\n"},
+			testEntry{name: "usr/lib/sysimage/rpm/rpmdb.sqlite", content: string(newTestRPMSQLite(newTestRPMHeader("bash", "5.2.26", "3.fc40", "x86_64", "GPL-3.0-or-later", 0)))},
+			testEntry{name: "app/package-lock.json", content: `{"lockfileVersion": 3, "packages": {"": {"name": "app"}, "node_modules/@types/node": {"version": "20.12.7"}, "node_modules/express": {"version": "4.19.2"}}}`},
+			testEntry{name: "app/node_modules/express/package-lock.json", content: `{"lockfileVersion": 3, "packages": {"node_modules/debug": {"version": "2.6.9"}}}`},
+			testEntry{name: "srv/requirements.txt", content: "# pinned\nDjango==5.0.4\nrequests>=2.31\nzope.interface[test]==6.3 ; python_version >= '3.8'\n"},
+			testEntry{name: "srv/Cargo.lock", content: "version = 3\n\n[[package]]\nname = \"serde\"\nversion = \"1.0.200\"\nsource = \"registry+https://github.com/rust-lang/crates.io-index\"\n\n[metadata]\nname = \"ignored\"\n"},
+		),
+	)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	pushTestImage(t, imageRef, image)
+
+	exporter := NewImageExporter()
+	inventory, err := exporter.GenerateSBOM(imageRef, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if inventory.Distro == nil || inventory.Distro.ID != "alpine" || inventory.Distro.Name != "Alpine Linux v3.20" {
+		t.Errorf("Expected the Alpine distro, got %+v", inventory.Distro)
+	}
+	want := []string{
+		"pkg:apk/alpine/musl@1.2.5-r0?arch=x86_64&distro=alpine-3.20.0",
+		"pkg:apk/alpine/busybox@1.36.1-r29?arch=x86_64&distro=alpine-3.20.0",
+		"pkg:deb/alpine/libc6@2.36-9+deb12u7?arch=amd64&distro=alpine-3.20.0",
+		"pkg:deb/alpine/tzdata@2024a-0+deb12u1?arch=all&distro=alpine-3.20.0",
+		"pkg:rpm/alpine/bash@5.2.26-3.fc40?arch=x86_64&distro=alpine-3.20.0",
+	}
+	if len(inventory.Packages) != len(want) {
+		t.Fatalf("Expected %d packages, got %+v", len(want), inventory.Packages)
+	}
+	for i, purl := range want {
+		if inventory.Packages[i].PURL != purl {
+			t.Errorf("Expected package %d to be %s, got %s", i, purl, inventory.Packages[i].PURL)
+		}
+	}
+	if libc := inventory.Packages[2]; libc.Source != "glibc" || libc.Location != "/var/lib/dpkg/status" {
+		t.Errorf("Expected libc6 built from glibc, got %+v", libc)
+	}
+
+	inventory, err = exporter.GenerateSBOM(imageRef, nil, &GenerateSBOMOptions{Lockfiles: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var lockfiles []string
+	for _, pkg := range inventory.Packages[len(want):] {
+		lockfiles = append(lockfiles, pkg.PURL+" "+pkg.Location)
+	}
+	wantLockfiles := []string{
+		"pkg:npm/%40types/node@20.12.7 /app/package-lock.json",
+		"pkg:npm/express@4.19.2 /app/package-lock.json",
+		"pkg:cargo/serde@1.0.200 /srv/Cargo.lock",
+		"pkg:pypi/django@5.0.4 /srv/requirements.txt",
+		"pkg:pypi/zope-interface@6.3 /srv/requirements.txt",
+	}
+	if strings.Join(lockfiles, "\n") != strings.Join(wantLockfiles, "\n") {
+		t.Errorf("Expected lockfile packages %v, got %v", wantLockfiles, lockfiles)
+	}
+
+	for _, format := range []string{SBOMFormatSPDX, SBOMFormatCycloneDX} {
+		content, err := inventory.Encode(format)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		var document struct {
+			SPDXVersion string `json:"spdxVersion"`
+			Packages    []any  `json:"packages"`
+			BOMFormat   string `json:"bomFormat"`
+			Components  []any  `json:"components"`
+		}
+		if err := json.Unmarshal(content, &document); err != nil {
+			t.Fatalf("Expected a JSON %s document, got %s", format, content)
+		}
+		// SPDX also lists the image as a package
+		packages := len(inventory.Packages)
+		if format == SBOMFormatSPDX {
+			packages++
+		}
+		if len(document.Packages)+len(document.Components) != packages {
+			t.Errorf("Expected every package in the %s document, got %s", format, content)
+		}
+	}
+	if _, err := inventory.Encode(SBOMFormatSyft); err == nil {
+		t.Error("Expected error for the syft format, got nil")
+	}
+}
@@ -0,0 +1,193 @@
+package lib
+
+import (
+	"sort"
+	"strings"
+)
+
+// pathTrie indexes the keys of a flattened filesystem map by path component,
+// so that whiteouts can delete a directory subtree in time proportional to the
+// subtree instead of scanning every path. Images with hundreds of layers and
+// long chains of opaque whiteouts would otherwise be quadratic.
+//
+// The map stays the source of truth for entries; the trie only records which
+// keys exist below each directory. A node can own two keys ("usr" and "usr/")
+// since directories are stored with a trailing slash.
+type pathTrie struct {
+	root *trieNode
+}
+
+// trieNode is one path component
+type trieNode struct {
+	children map[string]*trieNode
+	keys     map[string]struct{}
+}
+
+// newPathTrie creates an empty trie
+func newPathTrie() *pathTrie {
+	return &pathTrie{root: &trieNode{}}
+}
+
+// splitPath returns the components of a filesystem key, ignoring empty and "." components
+func splitPath(p string) []string {
+	var parts []string
+	for _, part := range strings.Split(p, "/") {
+		if part != "" && part != "." {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// insert records a filesystem key
+func (t *pathTrie) insert(key string) {
+	node := t.root
+	for _, part := range splitPath(key) {
+		if node.children == nil {
+			node.children = make(map[string]*trieNode)
+		}
+		child, ok := node.children[part]
+		if !ok {
+			child = &trieNode{}
+			node.children[part] = child
+		}
+		node = child
+	}
+	if node.keys == nil {
+		node.keys = make(map[string]struct{})
+	}
+	node.keys[key] = struct{}{}
+}
+
+// remove forgets a single filesystem key, leaving anything below it in place
+func (t *pathTrie) remove(key string) {
+	if node := t.find(splitPath(key)); node != nil {
+		delete(node.keys, key)
+	}
+}
+
+// find returns the node for a path, or nil if nothing was recorded there
+func (t *pathTrie) find(parts []string) *trieNode {
+	node := t.root
+	for _, part := range parts {
+		node = node.children[part]
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// matchChildren returns the names of a node's children equal to name,
+// ignoring case when foldCase is set
+func (n *trieNode) matchChildren(name string, foldCase bool) []string {
+	if !foldCase {
+		if _, ok := n.children[name]; ok {
+			return []string{name}
+		}
+		return nil
+	}
+	var matches []string
+	for child := range n.children {
+		if strings.EqualFold(child, name) {
+			matches = append(matches, child)
+		}
+	}
+	return matches
+}
+
+// canonical returns key spelled like the recorded path it equals ignoring case,
+// component by component, or key itself for components not recorded yet. An
+// exact match wins over other spellings; otherwise the first in sort order.
+func (t *pathTrie) canonical(key string) string {
+	parts := splitPath(key)
+	node := t.root
+	for i, part := range parts {
+		if node == nil {
+			break
+		}
+		if _, ok := node.children[part]; !ok {
+			matches := node.matchChildren(part, true)
+			if len(matches) == 0 {
+				break
+			}
+			sort.Strings(matches)
+			parts[i] = matches[0]
+		}
+		node = node.children[parts[i]]
+	}
+	canonical := strings.Join(parts, "/")
+	if canonical == "" {
+		return key
+	}
+	if strings.HasSuffix(key, "/") {
+		canonical += "/"
+	}
+	return canonical
+}
+
+// findAll returns every node matching a path, ignoring case when foldCase is set
+func (t *pathTrie) findAll(parts []string, foldCase bool) []*trieNode {
+	nodes := []*trieNode{t.root}
+	for _, part := range parts {
+		var next []*trieNode
+		for _, node := range nodes {
+			for _, name := range node.matchChildren(part, foldCase) {
+				next = append(next, node.children[name])
+			}
+		}
+		if len(next) == 0 {
+			return nil
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// deleteSubtree removes a path and everything below it from the filesystem and
+// the trie, for a whiteout of the given layer: only entries of lower layers are
+// removed, so entries the layer itself adds survive wherever they appear in it
+func (t *pathTrie) deleteSubtree(filesystem map[string]*fileEntry, p string, layer int, foldCase bool) {
+	parts := splitPath(p)
+	if len(parts) == 0 {
+		t.deleteChildren(filesystem, p, layer, foldCase)
+		return
+	}
+
+	for _, parent := range t.findAll(parts[:len(parts)-1], foldCase) {
+		for _, name := range parent.matchChildren(parts[len(parts)-1], foldCase) {
+			if parent.children[name].purge(filesystem, layer) {
+				delete(parent.children, name)
+			}
+		}
+	}
+}
+
+// deleteChildren removes everything below a directory, keeping the directory
+// itself, for an opaque whiteout of the given layer (see deleteSubtree)
+func (t *pathTrie) deleteChildren(filesystem map[string]*fileEntry, dir string, layer int, foldCase bool) {
+	for _, node := range t.findAll(splitPath(dir), foldCase) {
+		for name, child := range node.children {
+			if child.purge(filesystem, layer) {
+				delete(node.children, name)
+			}
+		}
+	}
+}
+
+// purge deletes the keys of a node and all of its descendants added by layers
+// below layer from the filesystem. It reports whether the node is left empty.
+func (n *trieNode) purge(filesystem map[string]*fileEntry, layer int) bool {
+	for key := range n.keys {
+		if entry, ok := filesystem[key]; !ok || entry.layer < layer {
+			delete(filesystem, key)
+			delete(n.keys, key)
+		}
+	}
+	for name, child := range n.children {
+		if child.purge(filesystem, layer) {
+			delete(n.children, name)
+		}
+	}
+	return len(n.keys) == 0 && len(n.children) == 0
+}
@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// newTestTrie builds a filesystem map and its index from keys
+func newTestTrie(keys ...string) (map[string]*fileEntry, *pathTrie) {
+	filesystem := make(map[string]*fileEntry)
+	paths := newPathTrie()
+	for _, key := range keys {
+		filesystem[key] = &fileEntry{header: &tar.Header{Name: key}}
+		paths.insert(key)
+	}
+	return filesystem, paths
+}
+
+// sortedKeys returns the keys of a filesystem map in order
+func sortedKeys(filesystem map[string]*fileEntry) []string {
+	keys := make([]string, 0, len(filesystem))
+	for key := range filesystem {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestPathTrieDeleteSubtree(t *testing.T) {
+	filesystem, paths := newTestTrie("usr/", "usr/bin/", "usr/bin/sh", "usr/lib/", "usrlocal", "etc/", "etc/passwd")
+
+	paths.deleteSubtree(filesystem, "usr/bin", 1, false)
+	expected := "[etc/ etc/passwd usr/ usr/lib/ usrlocal]"
+	if got := fmt.Sprint(sortedKeys(filesystem)); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+
+	// Sibling names sharing a prefix are not affected
+	paths.deleteSubtree(filesystem, "usr", 1, false)
+	expected = "[etc/ etc/passwd usrlocal]"
+	if got := fmt.Sprint(sortedKeys(filesystem)); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestPathTrieDeleteChildren(t *testing.T) {
+	filesystem, paths := newTestTrie("etc/", "etc/passwd", "etc/ssl/", "etc/ssl/cert.pem", "var/")
+
+	paths.deleteChildren(filesystem, "etc", 1, false)
+	expected := "[etc/ var/]"
+	if got := fmt.Sprint(sortedKeys(filesystem)); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+
+	// Re-added entries are indexed again after a deletion
+	filesystem["etc/hosts"] = &fileEntry{header: &tar.Header{Name: "etc/hosts"}}
+	paths.insert("etc/hosts")
+	paths.deleteChildren(filesystem, ".", 1, false)
+	if len(filesystem) != 0 {
+		t.Errorf("Expected a root opaque whiteout to remove everything, got %v", sortedKeys(filesystem))
+	}
+}
+
+func TestPathTrieFoldCase(t *testing.T) {
+	filesystem, paths := newTestTrie("Docs/", "Docs/README", "docs/", "docs/index.md", "other")
+
+	paths.deleteSubtree(filesystem, "DOCS", 1, true)
+	expected := "[other]"
+	if got := fmt.Sprint(sortedKeys(filesystem)); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestExportManyLayers(t *testing.T) {
+	// 150 layers, each replacing the contents of /data with an opaque whiteout
+	const layerCount = 150
+	layers := make([]v1.Layer, 0, layerCount)
+	for i := 0; i < layerCount; i++ {
+		entries := []testEntry{
+			{name: "data/", typeflag: tar.TypeDir},
+			{name: "data/.wh..wh..opq"},
+			{name: fmt.Sprintf("data/layer-%03d", i), content: fmt.Sprint(i)},
+			{name: fmt.Sprintf("keep/layer-%03d", i), content: fmt.Sprint(i)},
+		}
+		layers = append(layers, newTestLayer(t, entries...))
+	}
+	image, err := mutate.AppendLayers(empty.Image, layers...)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+
+	host := newTestRegistry(t)
+	imageRef := host + "/test/many-layers:latest"
+	pushTestImage(t, imageRef, image)
+
+	var buf bytes.Buffer
+	if err := NewImageExporter().ExportImageFilesystemToWriter(imageRef, &buf, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	files := readTestTar(t, buf.Bytes())
+
+	last := fmt.Sprintf("data/layer-%03d", layerCount-1)
+	if files[last] != fmt.Sprint(layerCount-1) {
+		t.Errorf("Expected %s from the last layer, got %v", last, files[last])
+	}
+	if _, ok := files["data/layer-000"]; ok {
+		t.Error("Expected earlier data/ contents to be removed by opaque whiteouts")
+	}
+	if _, ok := files["data/"]; !ok {
+		t.Error("Expected the data/ directory itself to survive opaque whiteouts")
+	}
+	for i := 0; i < layerCount; i++ {
+		if name := fmt.Sprintf("keep/layer-%03d", i); files[name] != fmt.Sprint(i) {
+			t.Fatalf("Expected %s to be kept, got %q", name, files[name])
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package lib
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+// PlatformMismatchError is returned when an image's platform cannot run on the host.
+type PlatformMismatchError struct {
+	// Image is the platform recorded in the image configuration (e.g. "linux/arm64")
+	Image string
+
+	// Host is the platform of the machine performing the export (e.g. "linux/amd64")
+	Host string
+}
+
+// Error implements the error interface
+func (e *PlatformMismatchError) Error() string {
+	return fmt.Sprintf("image platform %s cannot run on host platform %s", e.Image, e.Host)
+}
+
+// CheckHostPlatform reports whether an image can run on the current host.
+//
+// The os and architecture from the image configuration are compared against the
+// host the program is running on. Images that do not record a platform are
+// accepted, since there is nothing to compare against.
+//
+// Returns:
+//   - error: A *PlatformMismatchError if the image targets a different os or architecture
+//
+// Example:
+//
+//	config, _ := exporter.GetImageConfig("alpine:latest", nil)
+//	if err := CheckHostPlatform(config); err != nil {
+//	    log.Printf("warning: %v", err)
+//	}
+func CheckHostPlatform(config *ImageConfig) error {
+	return checkPlatform(config.OS, config.Architecture, config.Variant, runtime.GOOS, runtime.GOARCH)
+}
+
+// checkPlatform compares an image os/architecture against a host os/architecture
+func checkPlatform(imageOS, imageArch, imageVariant, hostOS, hostArch string) error {
+	if imageOS == "" && imageArch == "" {
+		return nil
+	}
+	if (imageOS == "" || imageOS == hostOS) && (imageArch == "" || imageArch == hostArch) {
+		return nil
+	}
+
+	image := imageOS + "/" + imageArch
+	if imageVariant != "" {
+		image += "/" + imageVariant
+	}
+	return &PlatformMismatchError{
+		Image: image,
+		Host:  hostOS + "/" + hostArch,
+	}
+}
+
+// platformMatches reports whether candidate satisfies want, like
+// v1.Platform.Satisfies, except that a Windows os.version matches by prefix:
+// "windows/amd64:10.0.20348" selects 10.0.20348.2227 and any later patch of
+// that build
+func platformMatches(candidate *v1.Platform, want v1.Platform) bool {
+	if candidate == nil {
+		return false
+	}
+	if want.OSVersion != "" && (candidate.OSVersion == want.OSVersion || strings.HasPrefix(candidate.OSVersion, want.OSVersion+".")) {
+		want.OSVersion = ""
+	}
+	return candidate.Satisfies(want)
+}
+
+// windowsDetector tells whether the layers being applied belong to a Windows
+// image, whose Files/ and Hives/ trees follow Windows path semantics. The image
+// configuration is only read once an entry looks like part of a Windows layer,
+// so Linux images are flattened without fetching it.
+type windowsDetector struct {
+	image   v1.Image
+	checked bool
+	windows bool
+}
+
+// newWindowsDetector creates a detector for the layers of image
+func newWindowsDetector(image v1.Image) *windowsDetector {
+	return &windowsDetector{image: image}
+}
+
+// check reports whether the image is a Windows image, given the name of the
+// next layer entry
+func (d *windowsDetector) check(entryName string) bool {
+	if d == nil || d.checked {
+		return d != nil && d.windows
+	}
+	top, _, _ := strings.Cut(strings.ReplaceAll(strings.TrimPrefix(strings.TrimPrefix(entryName, "./"), "/"), `\`, "/"), "/")
+	if !strings.ContainsRune(entryName, '\\') && !strings.EqualFold(top, "Files") &&
+		!strings.EqualFold(top, "Hives") && !strings.EqualFold(top, "UtilityVM") {
+		return false
+	}
+	d.checked = true
+	configFile, err := d.image.ConfigFile()
+	d.windows = err == nil && configFile.OS == "windows"
+	return d.windows
+}
+
+// applyPlatformPolicy validates the platform of images against the host according
+// to policy; several images (the platforms of a bundle) pass when any of them can
+// run on the host. A mismatch is returned as an error under PlatformPolicyFail and
+// reported as a warning, through opts if given, under PlatformPolicyWarn.
+func (e *imageExporter) applyPlatformPolicy(policy PlatformPolicy, opts *ExportOptions, images ...v1.Image) error {
+	if policy == PlatformPolicyIgnore || len(images) == 0 {
+		return nil
+	}
+
+	var mismatch error
+	for _, image := range images {
+		configFile, err := image.ConfigFile()
+		if err != nil {
+			return fmt.Errorf("failed to get config file: %w", err)
+		}
+		err = checkPlatform(configFile.OS, configFile.Architecture, configFile.Variant, runtime.GOOS, runtime.GOARCH)
+		if err == nil {
+			return nil
+		}
+		if mismatch == nil {
+			mismatch = err
+		}
+	}
+
+	switch policy {
+	case PlatformPolicyFail:
+		return mismatch
+	case PlatformPolicyWarn:
+		e.warn(opts, Warning{
+			Code:    WarningPlatformMismatch,
+			Message: mismatch.Error(),
+		})
+		return nil
+	default:
+		return fmt.Errorf("unknown platform policy %q", policy)
+	}
+}
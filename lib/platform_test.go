@@ -0,0 +1,151 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestCheckPlatform(t *testing.T) {
+	testCases := []struct {
+		name      string
+		os, arch  string
+		wantError bool
+	}{
+		{"match", "linux", "amd64", false},
+		{"unrecorded", "", "", false},
+		{"os only", "linux", "", false},
+		{"wrong arch", "linux", "arm64", true},
+		{"wrong os", "windows", "amd64", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkPlatform(tc.os, tc.arch, "", "linux", "amd64")
+			if tc.wantError {
+				var mismatch *PlatformMismatchError
+				if !errors.As(err, &mismatch) {
+					t.Fatalf("Expected PlatformMismatchError, got %v", err)
+				}
+				if mismatch.Host != "linux/amd64" {
+					t.Errorf("Expected host linux/amd64, got %s", mismatch.Host)
+				}
+			} else if err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+// pushForeignTestImage pushes an image for an architecture that can never match
+// the host, returning the architecture
+func pushForeignTestImage(t *testing.T, imageRef string) string {
+	t.Helper()
+
+	foreignArch := "arm64"
+	if runtime.GOARCH == "arm64" {
+		foreignArch = "amd64"
+	}
+
+	layer := newTestLayer(t, testEntry{name: "bin/app", content: "binary"})
+	image, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	configFile, err := image.ConfigFile()
+	if err != nil {
+		t.Fatalf("Failed to get config file: %v", err)
+	}
+	configFile.OS = runtime.GOOS
+	configFile.Architecture = foreignArch
+	image, err = mutate.ConfigFile(image, configFile)
+	if err != nil {
+		t.Fatalf("Failed to update config file: %v", err)
+	}
+
+	pushTestImage(t, imageRef, image)
+	return foreignArch
+}
+
+func TestExportImageFilesystem_PlatformPolicy(t *testing.T) {
+	host := newTestRegistry(t)
+	imageRef := host + "/test/foreign:latest"
+	foreignArch := pushForeignTestImage(t, imageRef)
+
+	exporter := NewImageExporter()
+	var buf bytes.Buffer
+
+	var warnings WarningCollector
+	err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &buf, nil, &ExportOptions{
+		PlatformPolicy: PlatformPolicyWarn,
+		Warning:        warnings.Collect,
+	})
+	if err != nil {
+		t.Fatalf("Expected warn policy to succeed, got %v", err)
+	}
+	if got := warningCodes(warnings.Warnings()); got[WarningPlatformMismatch] != 1 {
+		t.Errorf("Expected 1 platform warning, got %v", warnings.Warnings())
+	}
+
+	err = exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &buf, nil, &ExportOptions{
+		PlatformPolicy: PlatformPolicyFail,
+	})
+	var mismatch *PlatformMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Expected PlatformMismatchError, got %v", err)
+	}
+
+	config, err := exporter.GetImageConfig(imageRef, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if config.Architecture != foreignArch {
+		t.Errorf("Expected architecture %s, got %s", foreignArch, config.Architecture)
+	}
+}
+
+func TestOpenFileAndBundle_PlatformPolicy(t *testing.T) {
+	host := newTestRegistry(t)
+	imageRef := host + "/test/foreign:latest"
+	pushForeignTestImage(t, imageRef)
+
+	var warnings WarningCollector
+	exporter := NewImageExporter(WithWarnings(warnings.Collect))
+	content, _, err := exporter.OpenFile(imageRef, "bin/app", nil, &FileOptions{PlatformPolicy: PlatformPolicyWarn})
+	if err != nil {
+		t.Fatalf("Expected warn policy to succeed, got %v", err)
+	}
+	content.Close()
+	if got := warningCodes(warnings.Warnings()); got[WarningPlatformMismatch] != 1 {
+		t.Errorf("Expected 1 platform warning, got %v", warnings.Warnings())
+	}
+
+	var mismatch *PlatformMismatchError
+	_, _, err = exporter.OpenFile(imageRef, "bin/app", nil, &FileOptions{PlatformPolicy: PlatformPolicyFail})
+	if !errors.As(err, &mismatch) {
+		t.Errorf("Expected PlatformMismatchError from OpenFile, got %v", err)
+	}
+
+	var bundle bytes.Buffer
+	err = exporter.ExportBundle(imageRef, &bundle, nil, &BundleOptions{PlatformPolicy: PlatformPolicyFail})
+	if !errors.As(err, &mismatch) {
+		t.Errorf("Expected PlatformMismatchError from ExportBundle, got %v", err)
+	}
+	if bundle.Len() != 0 {
+		t.Errorf("Expected nothing written before the platform check, got %d bytes", bundle.Len())
+	}
+
+	// A bundle of every platform passes when one of them runs on the host
+	if runtime.GOOS == "linux" && (runtime.GOARCH == "amd64" || runtime.GOARCH == "arm64") {
+		indexRef := host + "/test/multiarch:latest"
+		pushTestIndex(t, indexRef)
+		if err := exporter.ExportBundle(indexRef, io.Discard, nil, &BundleOptions{PlatformPolicy: PlatformPolicyFail}); err != nil {
+			t.Errorf("Expected the multi-arch bundle to pass, got %v", err)
+		}
+	}
+}
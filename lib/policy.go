@@ -0,0 +1,146 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// ErrRepositoryDenied is returned for references the repository policy of the
+// exporter does not allow (see WithRepositoryPolicy)
+var ErrRepositoryDenied = errors.New("repository not allowed by policy")
+
+// WithRepositoryPolicy restricts the repositories an exporter may contact,
+// whatever references its callers pass. Every reference is checked after
+// short-name resolution and before any network call; a refused reference
+// fails with an error wrapping ErrRepositoryDenied.
+//
+// Patterns are fully-qualified repository names ("docker.io" stands for Docker
+// Hub) and match a repository and everything below it: "ghcr.io/org" matches
+// ghcr.io/org/app and ghcr.io/org/team/app, and "registry.example.com" the
+// whole registry. Each path component may use path.Match wildcards, e.g.
+// "*.example.com" or "ghcr.io/org/app-*".
+//
+// A repository is refused when it matches any deny pattern, or when allow
+// patterns are given and it matches none of them. Registry-wide operations
+// (Catalog, Login) are checked against the registry host alone, so they need
+// an allow pattern covering the whole registry. Repeated options add to the
+// lists; a malformed pattern refuses everything. Local image sources are
+// refused as well unless WithLocalSources allows them.
+//
+// Parameters:
+//   - allow: Repositories that may be contacted; empty allows all but the denied ones
+//   - deny: Repositories that may never be contacted
+//
+// Example:
+//
+//	exporter := NewImageExporter(WithRepositoryPolicy(
+//	    []string{"registry.example.com", "docker.io/library"},
+//	    []string{"registry.example.com/internal"},
+//	))
+//	_, err := exporter.GetImageConfig("evil.example.org/x:latest", nil)
+//	fmt.Println(errors.Is(err, ErrRepositoryDenied)) // true
+func WithRepositoryPolicy(allow, deny []string) ExporterOption {
+	return func(e *imageExporter) {
+		e.allowRepos = append(e.allowRepos, normalizeRepositoryPatterns(allow)...)
+		e.denyRepos = append(e.denyRepos, normalizeRepositoryPatterns(deny)...)
+	}
+}
+
+// WithLocalSources allows or refuses images read from the host rather than a
+// registry: docker-archive:, oci:, containerd: and docker-daemon: references,
+// and the Docker Engine selected by WithImageSource. Local sources are allowed
+// by default but refused once WithRepositoryPolicy is given, as the policy is
+// meant to bound what callers can make the exporter read; WithLocalSources(true)
+// allows them again. A refused reference fails with an error wrapping
+// ErrRepositoryDenied.
+func WithLocalSources(allowed bool) ExporterOption {
+	return func(e *imageExporter) {
+		e.localSources = &allowed
+	}
+}
+
+// localSourcesAllowed reports whether images may be read from the host
+func (e *imageExporter) localSourcesAllowed() bool {
+	if e.localSources != nil {
+		return *e.localSources
+	}
+	return len(e.allowRepos) == 0 && len(e.denyRepos) == 0
+}
+
+// checkLocalSource refuses a local image reference when local sources are
+// not allowed
+func (e *imageExporter) checkLocalSource(imageRef string) error {
+	if e.localSourcesAllowed() {
+		return nil
+	}
+	e.log().Warn("local image denied", "image", imageRef)
+	return fmt.Errorf("%s is a local image: %w", imageRef, ErrRepositoryDenied)
+}
+
+// normalizeRepositoryPatterns spells Docker Hub patterns the way references
+// name it and drops trailing slashes
+func normalizeRepositoryPatterns(patterns []string) []string {
+	normalized := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(pattern, "/")
+		host, rest, _ := strings.Cut(pattern, "/")
+		if host == "docker.io" || host == "registry-1.docker.io" {
+			host = name.DefaultRegistry
+		}
+		if rest != "" {
+			host += "/" + rest
+		}
+		normalized = append(normalized, host)
+	}
+	return normalized
+}
+
+// checkRepository enforces the repository policy on a fully-qualified
+// repository name, or on a registry host for registry-wide operations
+func (e *imageExporter) checkRepository(repository string) error {
+	if len(e.allowRepos) == 0 && len(e.denyRepos) == 0 {
+		return nil
+	}
+	denied, err := matchRepository(e.denyRepos, repository)
+	if err != nil {
+		return err
+	}
+	if denied {
+		e.log().Warn("repository denied", "repository", repository)
+		return fmt.Errorf("%s: %w", repository, ErrRepositoryDenied)
+	}
+	if len(e.allowRepos) == 0 {
+		return nil
+	}
+	allowed, err := matchRepository(e.allowRepos, repository)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		e.log().Warn("repository not allowed", "repository", repository)
+		return fmt.Errorf("%s: %w", repository, ErrRepositoryDenied)
+	}
+	return nil
+}
+
+// matchRepository reports whether any pattern matches the repository or one
+// of its parents
+func matchRepository(patterns []string, repository string) (bool, error) {
+	components := strings.Split(repository, "/")
+	for _, pattern := range patterns {
+		for i := 1; i <= len(components); i++ {
+			matched, err := path.Match(pattern, strings.Join(components[:i], "/"))
+			if err != nil {
+				return false, fmt.Errorf("invalid repository pattern %q: %w", pattern, ErrRepositoryDenied)
+			}
+			if matched {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
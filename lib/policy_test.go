@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestMatchRepository(t *testing.T) {
+	patterns := normalizeRepositoryPatterns([]string{"ghcr.io/org/", "*.example.com", "docker.io/library", "quay.io/app-*"})
+	for repository, expected := range map[string]bool{
+		"ghcr.io/org/app":                true,
+		"ghcr.io/org/team/app":           true,
+		"ghcr.io/organization/app":       false,
+		"ghcr.io":                        false,
+		"registry.example.com/team/app":  true,
+		"index.docker.io/library/alpine": true,
+		"index.docker.io/someone/alpine": false,
+		"quay.io/app-frontend":           true,
+		"quay.io/app":                    false,
+	} {
+		matched, err := matchRepository(patterns, repository)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if matched != expected {
+			t.Errorf("Expected %s to match %v, got %v", repository, expected, matched)
+		}
+	}
+
+	if _, err := matchRepository([]string{"ghcr.io/["}, "ghcr.io/org"); !errors.Is(err, ErrRepositoryDenied) {
+		t.Errorf("Expected a malformed pattern to deny, got %v", err)
+	}
+}
+
+func TestRepositoryPolicy(t *testing.T) {
+	host := newTestRegistry(t)
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "app", content: "v1"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	pushTestImage(t, host+"/team/app:v1", image)
+	pushTestImage(t, host+"/team/secret:v1", image)
+
+	exporter := NewImageExporter(WithRepositoryPolicy([]string{host + "/team"}, []string{host + "/team/secret"}))
+	if _, err := exporter.GetImageConfig(host+"/team/app:v1", nil); err != nil {
+		t.Errorf("Expected an allowed image, got %v", err)
+	}
+	for _, imageRef := range []string{host + "/team/secret:v1", host + "/other/app:v1", "alpine:latest"} {
+		if _, err := exporter.ResolveDigest(imageRef, nil); !errors.Is(err, ErrRepositoryDenied) {
+			t.Errorf("Expected %s to be denied, got %v", imageRef, err)
+		}
+	}
+	if _, err := exporter.ListTags(host+"/team/secret", nil, nil); !errors.Is(err, ErrRepositoryDenied) {
+		t.Errorf("Expected listing a denied repository to fail, got %v", err)
+	}
+	if _, err := exporter.Catalog(host, nil, nil); !errors.Is(err, ErrRepositoryDenied) {
+		t.Errorf("Expected the catalog of a partly allowed registry to be denied, got %v", err)
+	}
+
+	// Short names are checked once resolved
+	exporter = NewImageExporter(
+		WithNameResolver(func(shortName string) (string, bool) { return host + "/team/" + shortName, true }),
+		WithRepositoryPolicy(nil, []string{host + "/team/secret"}),
+	)
+	if _, err := exporter.GetImageConfig("app:v1", nil); err != nil {
+		t.Errorf("Expected an allowed short name, got %v", err)
+	}
+	if _, err := exporter.GetImageConfig("secret:v1", nil); !errors.Is(err, ErrRepositoryDenied) {
+		t.Errorf("Expected a denied short name, got %v", err)
+	}
+}
+
+func TestLocalSources(t *testing.T) {
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "app", content: "v1"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	archive := DockerArchivePrefix + writeTestArchive(t, map[string]v1.Image{"app:v1": image})
+
+	if _, err := NewImageExporter(WithOffline()).GetImageConfig(archive, nil); err != nil {
+		t.Errorf("Expected local sources to be allowed by default, got %v", err)
+	}
+
+	// A repository policy refuses every local source unless they are allowed again
+	for _, imageRef := range []string{archive, OCILayoutPrefix + t.TempDir(), ContainerdPrefix + "app:v1", DockerDaemonPrefix + "app:v1"} {
+		exporter := NewImageExporter(WithOffline(), WithRepositoryPolicy([]string{"registry.example.com"}, nil))
+		if _, err := exporter.GetImageConfig(imageRef, nil); !errors.Is(err, ErrRepositoryDenied) {
+			t.Errorf("Expected %s to be denied, got %v", imageRef, err)
+		}
+	}
+	exporter := NewImageExporter(WithOffline(), WithRepositoryPolicy([]string{"registry.example.com"}, nil), WithLocalSources(true))
+	if _, err := exporter.GetImageConfig(archive, nil); err != nil {
+		t.Errorf("Expected local sources to be allowed explicitly, got %v", err)
+	}
+
+	exporter = NewImageExporter(WithOffline(), WithLocalSources(false), WithImageSource(ImageSourceDaemon))
+	if _, err := exporter.GetImageConfig("app:v1", nil); !errors.Is(err, ErrRepositoryDenied) {
+		t.Errorf("Expected the daemon source to be denied, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"io"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+// byteProgressTracker accumulates the bytes downloaded and written by an export
+// and reports a snapshot on every change. All methods are no-ops on a nil
+// tracker, so callers need not check whether byte progress was requested.
+type byteProgressTracker struct {
+	callback ByteProgressCallback
+	state    ByteProgress
+}
+
+// newByteProgressTracker returns a tracker reporting to callback, or nil when callback is nil
+func newByteProgressTracker(callback ByteProgressCallback) *byteProgressTracker {
+	if callback == nil {
+		return nil
+	}
+	return &byteProgressTracker{callback: callback}
+}
+
+// setLayers records the layer count and the compressed size of all layers
+func (t *byteProgressTracker) setLayers(layers []v1.Layer) {
+	if t == nil {
+		return
+	}
+	t.state.Layers = len(layers)
+	t.state.DownloadTotal = 0
+	for _, layer := range layers {
+		if size, err := layer.Size(); err == nil {
+			t.state.DownloadTotal += size
+		}
+	}
+}
+
+// startLayer begins reporting a new layer
+func (t *byteProgressTracker) startLayer(layer int, size int64) {
+	if t == nil {
+		return
+	}
+	t.state.Layer = layer
+	t.state.LayerRead = 0
+	t.state.LayerSize = size
+	t.callback(t.state)
+}
+
+// read adds n downloaded bytes of the current layer
+func (t *byteProgressTracker) read(n int64) {
+	if t == nil {
+		return
+	}
+	t.state.LayerRead += n
+	t.state.Downloaded += n
+	t.callback(t.state)
+}
+
+// finishLayer marks the current layer as fully downloaded. Bytes the tar reader
+// never consumed, such as trailing padding or a skipped foreign layer, are
+// counted so that Downloaded ends at DownloadTotal.
+func (t *byteProgressTracker) finishLayer() {
+	if t == nil {
+		return
+	}
+	if remaining := t.state.LayerSize - t.state.LayerRead; remaining > 0 {
+		t.state.Downloaded += remaining
+	}
+	t.state.LayerRead = t.state.LayerSize
+	t.callback(t.state)
+}
+
+// wrote adds n bytes written to the output
+func (t *byteProgressTracker) wrote(n int64) {
+	if t == nil {
+		return
+	}
+	t.state.Written += n
+	t.callback(t.state)
+}
+
+// progressWriter reports the size of every write to a stream
+type progressWriter struct {
+	io.Writer
+	onWrite func(n int64)
+}
+
+// Write implements io.Writer
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if n > 0 {
+		w.onWrite(int64(n))
+	}
+	return n, err
+}
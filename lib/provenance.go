@@ -0,0 +1,278 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrProvenanceSubject is returned by Provenance when an attestation attached
+// to an image describes another artifact: its subject does not name the digest
+// it is attached to
+var ErrProvenanceSubject = errors.New("provenance subject does not match the image")
+
+// ProvenanceOptions configures Provenance
+type ProvenanceOptions struct {
+	// Platform selects the image of a multi-platform index whose provenance is
+	// listed with that of the index, e.g. "linux/arm64"; empty for linux/amd64
+	Platform string
+}
+
+// ProvenanceList lists the SLSA provenance attestations of an image. It is
+// returned by the Provenance method.
+type ProvenanceList struct {
+	// SchemaVersion is the version of this JSON document (see SchemaVersion)
+	SchemaVersion int `json:"schema_version"`
+
+	// Reference is the fully-qualified reference that was queried
+	Reference string `json:"reference"`
+
+	// Digest is the manifest digest the reference resolved to
+	Digest string `json:"digest"`
+
+	// Provenance lists the attestations: those of the index first, then those
+	// of the selected platform
+	Provenance []Provenance `json:"provenance"`
+}
+
+// Provenance is one SLSA provenance attestation, with the fields of its
+// predicate shared by SLSA v0.2 and v1
+type Provenance struct {
+	// Source is how the attestation is attached, as in SBOM.Source
+	Source string `json:"source"`
+
+	// Subject is the manifest digest the attestation is attached to, which
+	// its in-toto subject was verified to name
+	Subject string `json:"subject"`
+
+	// Digest is the digest of the blob holding the attestation
+	Digest string `json:"digest"`
+
+	// PredicateType is "https://slsa.dev/provenance/v0.2" or
+	// "https://slsa.dev/provenance/v1"
+	PredicateType string `json:"predicate_type"`
+
+	// BuilderID identifies the build platform, e.g. the GitHub Actions workflow
+	// or BuildKit version that built the image
+	BuilderID string `json:"builder_id,omitempty"`
+
+	// BuildType identifies the schema of the build parameters
+	BuildType string `json:"build_type,omitempty"`
+
+	// InvocationID identifies the build run, e.g. a CI job URL
+	InvocationID string `json:"invocation_id,omitempty"`
+
+	// StartedOn and FinishedOn are the RFC 3339 times of the build, if recorded
+	StartedOn  string `json:"started_on,omitempty"`
+	FinishedOn string `json:"finished_on,omitempty"`
+
+	// Materials are the inputs of the build: sources, base images and other
+	// dependencies (resolvedDependencies in SLSA v1)
+	Materials []ProvenanceMaterial `json:"materials"`
+
+	// Predicate is the complete provenance predicate
+	Predicate json.RawMessage `json:"predicate"`
+}
+
+// ProvenanceMaterial is one input of a build
+type ProvenanceMaterial struct {
+	// URI locates the input, e.g. "git+https://github.com/org/app@refs/heads/main"
+	// or "pkg:docker/alpine@3.20?platform=linux%2Famd64"
+	URI string `json:"uri"`
+
+	// Digest is the digest of the input as "algorithm:hex", if recorded
+	Digest string `json:"digest,omitempty"`
+}
+
+// isProvenance reports whether an in-toto predicate type is SLSA provenance
+func isProvenance(predicateType string) bool {
+	return strings.HasPrefix(predicateType, "https://slsa.dev/provenance/")
+}
+
+// slsaPredicate holds the fields of SLSA v0.2 and v1 provenance predicates
+// Provenance reports
+type slsaPredicate struct {
+	// SLSA v0.2
+	Builder struct {
+		ID string `json:"id"`
+	} `json:"builder"`
+	BuildType string `json:"buildType"`
+	Metadata  struct {
+		BuildInvocationID string `json:"buildInvocationId"`
+		BuildStartedOn    string `json:"buildStartedOn"`
+		BuildFinishedOn   string `json:"buildFinishedOn"`
+	} `json:"metadata"`
+	Materials []slsaDescriptor `json:"materials"`
+
+	// SLSA v1
+	BuildDefinition struct {
+		BuildType            string           `json:"buildType"`
+		ResolvedDependencies []slsaDescriptor `json:"resolvedDependencies"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		Metadata struct {
+			InvocationID string `json:"invocationId"`
+			StartedOn    string `json:"startedOn"`
+			FinishedOn   string `json:"finishedOn"`
+		} `json:"metadata"`
+	} `json:"runDetails"`
+}
+
+// slsaDescriptor is a material (v0.2) or resource descriptor (v1)
+type slsaDescriptor struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+// material converts d, preferring a sha256 digest
+func (d slsaDescriptor) material() ProvenanceMaterial {
+	material := ProvenanceMaterial{URI: d.URI}
+	algorithms := make([]string, 0, len(d.Digest))
+	for algorithm := range d.Digest {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Strings(algorithms)
+	for _, algorithm := range algorithms {
+		if material.Digest == "" || algorithm == "sha256" {
+			material.Digest = algorithm + ":" + d.Digest[algorithm]
+		}
+	}
+	return material
+}
+
+// summarize fills the predicate fields of p from the predicate of an
+// attestation
+func (p *Provenance) summarize(predicate json.RawMessage) error {
+	var slsa slsaPredicate
+	if err := json.Unmarshal(predicate, &slsa); err != nil {
+		return fmt.Errorf("invalid provenance predicate: %w", err)
+	}
+	p.Predicate = predicate
+	p.Materials = []ProvenanceMaterial{}
+	dependencies := slsa.Materials
+	if strings.HasPrefix(p.PredicateType, "https://slsa.dev/provenance/v0.") {
+		p.BuilderID = slsa.Builder.ID
+		p.BuildType = slsa.BuildType
+		p.InvocationID = slsa.Metadata.BuildInvocationID
+		p.StartedOn = slsa.Metadata.BuildStartedOn
+		p.FinishedOn = slsa.Metadata.BuildFinishedOn
+	} else {
+		p.BuilderID = slsa.RunDetails.Builder.ID
+		p.BuildType = slsa.BuildDefinition.BuildType
+		p.InvocationID = slsa.RunDetails.Metadata.InvocationID
+		p.StartedOn = slsa.RunDetails.Metadata.StartedOn
+		p.FinishedOn = slsa.RunDetails.Metadata.FinishedOn
+		dependencies = slsa.BuildDefinition.ResolvedDependencies
+	}
+	for _, dependency := range dependencies {
+		p.Materials = append(p.Materials, dependency.material())
+	}
+	return nil
+}
+
+// Provenance returns the SLSA provenance attestations attached to an image
+// (see SBOMs for where attachments are looked up), such as the provenance
+// 'docker buildx build --provenance' and the SLSA GitHub generator attach.
+// Attestations without a predicate type annotation are read to find out
+// whether they are provenance.
+//
+// The in-toto subject of every provenance attestation must name the manifest
+// digest the attestation is attached to; otherwise Provenance fails with an
+// error wrapping ErrProvenanceSubject, as the attestation describes another
+// image. Signatures of the attestations are not verified.
+//
+// Parameters:
+//   - imageRef: Image reference (e.g., "ghcr.io/org/app:v1")
+//   - auth: Optional authentication configuration for private registries
+//   - opts: Optional platform of a multi-platform image
+//
+// Returns:
+//   - *ProvenanceList: The provenance attestations, possibly none
+//   - error: Any error encountered during the operation
+//
+// Example:
+//
+//	list, err := exporter.Provenance("ghcr.io/org/app:v1", nil, nil)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	for _, provenance := range list.Provenance {
+//	    fmt.Println(provenance.BuilderID, provenance.InvocationID)
+//	}
+func (e *imageExporter) Provenance(imageRef string, auth *AuthConfig, opts *ProvenanceOptions) (*ProvenanceList, error) {
+	if opts == nil {
+		opts = &ProvenanceOptions{}
+	}
+	ref, err := e.parseReference(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image reference: %w", err)
+	}
+	subjects, index, err := e.attachmentSubjects(ref, opts.Platform, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &ProvenanceList{
+		SchemaVersion: SchemaVersion,
+		Reference:     ref.Name(),
+		Digest:        subjects[0].String(),
+		Provenance:    []Provenance{},
+	}
+	for _, subject := range subjects {
+		layers, err := e.attachedLayers(ref.Context(), subject, index, auth)
+		if err != nil {
+			return nil, err
+		}
+		for _, layer := range layers {
+			mediaType := string(layer.layer.MediaType)
+			if !isAttestationLayer(mediaType) {
+				continue
+			}
+			if predicateType := layer.predicateType(); predicateType != "" && !isProvenance(predicateType) {
+				continue
+			}
+			data, err := e.readAttachedLayer(layer, auth)
+			if err != nil {
+				return nil, err
+			}
+			statement, err := parseStatement(mediaType, data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read attestation %s: %w", layer.layer.Digest, err)
+			}
+			if !isProvenance(statement.PredicateType) {
+				continue
+			}
+			if !statement.names(subject.Algorithm, subject.Hex) {
+				return nil, fmt.Errorf("attestation %s attached to %s: %w", layer.layer.Digest, subject, ErrProvenanceSubject)
+			}
+
+			provenance := Provenance{
+				Source:        layer.source,
+				Subject:       subject.String(),
+				Digest:        layer.layer.Digest.String(),
+				PredicateType: statement.PredicateType,
+			}
+			if err := provenance.summarize(statement.Predicate); err != nil {
+				return nil, fmt.Errorf("failed to read attestation %s: %w", layer.layer.Digest, err)
+			}
+			list.Provenance = append(list.Provenance, provenance)
+		}
+	}
+	return list, nil
+}
+
+// names reports whether a subject of the statement has the digest
+// algorithm:hex
+func (s *inTotoStatement) names(algorithm, hex string) bool {
+	for _, subject := range s.Subject {
+		if subject.Digest[algorithm] == hex {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// pushTestCosignAttestation stores a DSSE envelope of statement under the
+// cosign .att tag of digest, without a predicate type annotation
+func pushTestCosignAttestation(t *testing.T, repo string, digest v1.Hash, statement []byte) {
+	t.Helper()
+	attestation, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer: static.NewLayer(newTestDSSE(statement), types.MediaType(dsseMediaType)),
+	})
+	if err != nil {
+		t.Fatalf("Failed to build test attestation: %v", err)
+	}
+	pushTestImage(t, repo+":"+digest.Algorithm+"-"+digest.Hex+".att", attestation)
+}
+
+func TestProvenance(t *testing.T) {
+	host := newTestRegistry(t)
+	imageRef := host + "/test/app:v1"
+
+	// BuildKit attaches SLSA v0.2 provenance to the image of each platform
+	buildkit := map[string]any{
+		"builder":   map[string]any{"id": "https://github.com/docker/buildx@v0.14.0"},
+		"buildType": "https://mobyproject.org/buildkit@v1",
+		"materials": []any{
+			map[string]any{"uri": "pkg:docker/alpine@3.20?platform=linux%2Famd64", "digest": map[string]string{"sha256": "0a4eaa0eecf5f8c050e5bba433f58c052be7587ee8af3e8b3910ef9ab5fbe9f5"}},
+		},
+		"metadata": map[string]any{"buildInvocationId": "x7y2", "buildStartedOn": "2024-05-01T10:00:00Z", "buildFinishedOn": "2024-05-01T10:02:00Z"},
+	}
+	indexDigest, imageDigest := pushTestAttestations(t, imageRef, "https://slsa.dev/provenance/v0.2", func(image v1.Hash) []byte {
+		return newTestStatement(t, image, "https://slsa.dev/provenance/v0.2", buildkit)
+	})
+
+	// The SLSA GitHub generator attests the index with SLSA v1, and an SBOM
+	// attestation is not provenance
+	github := map[string]any{
+		"buildDefinition": map[string]any{
+			"buildType": "https://slsa-framework.github.io/github-actions-buildtypes/workflow/v1",
+			"resolvedDependencies": []any{
+				map[string]any{"uri": "git+https://github.com/org/app@refs/tags/v1", "digest": map[string]string{"gitCommit": "1b2c3d"}},
+			},
+		},
+		"runDetails": map[string]any{
+			"builder":  map[string]any{"id": "https://github.com/slsa-framework/slsa-github-generator/.github/workflows/generator_container_slsa3.yml@refs/tags/v2.0.0"},
+			"metadata": map[string]any{"invocationId": "https://github.com/org/app/actions/runs/1/attempts/1"},
+		},
+	}
+	attestation, err := mutate.Append(empty.Image,
+		mutate.Addendum{Layer: static.NewLayer(newTestDSSE(newTestStatement(t, indexDigest, "https://slsa.dev/provenance/v1", github)), types.MediaType(dsseMediaType))},
+		mutate.Addendum{Layer: static.NewLayer(newTestDSSE(newTestStatement(t, indexDigest, "https://spdx.dev/Document", map[string]any{})), types.MediaType(dsseMediaType))},
+	)
+	if err != nil {
+		t.Fatalf("Failed to build test attestation: %v", err)
+	}
+	pushTestImage(t, host+"/test/app:sha256-"+indexDigest.Hex+".att", attestation)
+
+	exporter := NewImageExporter()
+	list, err := exporter.Provenance(imageRef, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(list.Provenance) != 2 {
+		t.Fatalf("Expected 2 provenance attestations, got %+v", list.Provenance)
+	}
+	slsa1, slsa02 := list.Provenance[0], list.Provenance[1]
+	if slsa1.Subject != indexDigest.String() || slsa1.Source != SourceCosignAttestation {
+		t.Errorf("Expected SLSA v1 provenance of the index, got %+v", slsa1)
+	}
+	if slsa1.BuilderID != "https://github.com/slsa-framework/slsa-github-generator/.github/workflows/generator_container_slsa3.yml@refs/tags/v2.0.0" ||
+		slsa1.InvocationID != "https://github.com/org/app/actions/runs/1/attempts/1" {
+		t.Errorf("Expected SLSA v1 run details, got %+v", slsa1)
+	}
+	if len(slsa1.Materials) != 1 || slsa1.Materials[0].Digest != "gitCommit:1b2c3d" {
+		t.Errorf("Expected the git commit material, got %+v", slsa1.Materials)
+	}
+	if slsa02.Subject != imageDigest.String() || slsa02.Source != SourceBuildKitAttestation {
+		t.Errorf("Expected SLSA v0.2 provenance of the image, got %+v", slsa02)
+	}
+	if slsa02.BuilderID != "https://github.com/docker/buildx@v0.14.0" || slsa02.InvocationID != "x7y2" ||
+		slsa02.StartedOn != "2024-05-01T10:00:00Z" || slsa02.FinishedOn != "2024-05-01T10:02:00Z" {
+		t.Errorf("Expected SLSA v0.2 metadata, got %+v", slsa02)
+	}
+	if len(slsa02.Materials) != 1 || slsa02.Materials[0].URI != "pkg:docker/alpine@3.20?platform=linux%2Famd64" {
+		t.Errorf("Expected the base image material, got %+v", slsa02.Materials)
+	}
+
+	// Provenance of another image, attached to this one
+	forged := host + "/test/forged:v1"
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "file", content: "forged"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	pushTestImage(t, forged, image)
+	forgedDigest, _ := image.Digest()
+	pushTestCosignAttestation(t, host+"/test/forged", forgedDigest, newTestStatement(t, indexDigest, "https://slsa.dev/provenance/v1", github))
+	if _, err := exporter.Provenance(forged, nil, nil); !errors.Is(err, ErrProvenanceSubject) {
+		t.Errorf("Expected ErrProvenanceSubject, got %v", err)
+	}
+}
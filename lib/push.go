@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// pushImage writes an image to a registry, mounting blobs from the repository
+// the image was read from instead of uploading them again.
+//
+// Registries perform a cross-repository mount without transferring any data
+// when the source repository is on the same registry and readable with the same
+// credentials; copies and squash pushes within a registry then only upload the
+// blobs that are actually new. Where a mount is refused (another registry, no
+// access to the source, or a blob that does not exist there, such as a
+// regenerated config) the blob is uploaded as usual.
+//
+// Parameters:
+//   - dst: Destination reference (tag or digest)
+//   - image: Image to write; its layers may come from src or be new
+//   - src: Repository the image's existing blobs can be mounted from
+//   - auth: Optional authentication configuration for both repositories
+func (e *imageExporter) pushImage(dst name.Reference, image v1.Image, src name.Reference, auth *AuthConfig) error {
+	if err := remote.Write(dst, &mountableImage{Image: image, source: src}, e.remoteOptions(auth)...); err != nil {
+		return fmt.Errorf("failed to push %s: %w", dst, err)
+	}
+	return nil
+}
+
+// mountableImage marks every blob of an image as mountable from a source
+// repository. Wrappers such as the blob cache or mutate hide the mount
+// information attached to layers read by remote.Image, so it is restored here.
+type mountableImage struct {
+	v1.Image
+	source name.Reference
+}
+
+// mountable wraps a layer so that remote.Write offers a mount from the source
+func (i *mountableImage) mountable(layer v1.Layer) v1.Layer {
+	if _, ok := layer.(*remote.MountableLayer); ok {
+		return layer
+	}
+	return &remote.MountableLayer{Layer: layer, Reference: i.source}
+}
+
+// Layers implements v1.Image
+func (i *mountableImage) Layers() ([]v1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	mounted := make([]v1.Layer, len(layers))
+	for n, layer := range layers {
+		mounted[n] = i.mountable(layer)
+	}
+	return mounted, nil
+}
+
+// LayerByDigest implements v1.Image
+func (i *mountableImage) LayerByDigest(digest v1.Hash) (v1.Layer, error) {
+	layer, err := i.Image.LayerByDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+	return i.mountable(layer), nil
+}
+
+// ConfigLayer lets remote.Write mount the config blob as well
+func (i *mountableImage) ConfigLayer() (v1.Layer, error) {
+	layer, err := partial.ConfigLayer(i.Image)
+	if err != nil {
+		return nil, err
+	}
+	return i.mountable(layer), nil
+}
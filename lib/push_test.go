@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+)
+
+func TestPushImageMountsBlobs(t *testing.T) {
+	// The in-memory registry shares blobs between repositories, so pretend the
+	// destination has none to make the client start uploads
+	var mu sync.Mutex
+	var mounts []string
+	inner := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v2/team/dst/blobs/") {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if r.Method == http.MethodPost {
+				mu.Lock()
+				mounts = append(mounts, r.URL.Query().Get("mount")+" from "+r.URL.Query().Get("from"))
+				mu.Unlock()
+			}
+		}
+		inner.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse registry URL: %v", err)
+	}
+
+	image := newDatedTestImage(t, "mount", 1)
+	pushTestImage(t, u.Host+"/team/src:v1", image)
+
+	src, err := name.ParseReference(u.Host + "/team/src:v1")
+	if err != nil {
+		t.Fatalf("Failed to parse reference: %v", err)
+	}
+	dst, err := name.ParseReference(u.Host + "/team/dst:v1")
+	if err != nil {
+		t.Fatalf("Failed to parse reference: %v", err)
+	}
+	// The image's layers are local values here, as after mutate, but exist in the source
+	exporter := NewImageExporter().(*imageExporter)
+	if err := exporter.pushImage(dst, image, src, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	layers, err := image.Layers()
+	if err != nil {
+		t.Fatalf("Failed to get layers: %v", err)
+	}
+	digest, err := layers[0].Digest()
+	if err != nil {
+		t.Fatalf("Failed to get layer digest: %v", err)
+	}
+	layer := digest.String()
+	found := false
+	for _, mount := range mounts {
+		if mount == layer+" from team/src" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected a mount of %s from team/src, got %v", layer, mounts)
+	}
+	assertManifestExists(t, u.Host+"/team/dst:v1", true)
+}
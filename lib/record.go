@@ -0,0 +1,107 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// workDirExports holds export records, inside the jobs area
+const workDirExports = "exports"
+
+// ExportRecord remembers the source of a successful export, so a later run can
+// skip the export when the image has not changed since (see ResolveDigest)
+type ExportRecord struct {
+	// SchemaVersion is the version of this JSON document (see SchemaVersion)
+	SchemaVersion int `json:"schema_version"`
+
+	// Image is the reference as given, e.g. a tag
+	Image string `json:"image"`
+
+	// Digest is the manifest (or index) digest the reference resolved to
+	Digest string `json:"digest"`
+
+	// Platform is the platform exported from an index, empty for the default
+	Platform string `json:"platform,omitempty"`
+
+	// Output is the file the filesystem was written to
+	Output string `json:"output"`
+
+	// Options fingerprints the export options; a record only matches an export
+	// with the same options
+	Options string `json:"options"`
+
+	// Time is when the export finished
+	Time time.Time `json:"time"`
+}
+
+// ReadExportRecord reads the export record at path.
+//
+// Returns:
+//   - *ExportRecord: The record, or nil if there is none
+//   - error: If the file cannot be read or is not a valid record
+func ReadExportRecord(path string) (*ExportRecord, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export record: %w", err)
+	}
+	record := &ExportRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("failed to parse export record %s: %w", path, err)
+	}
+	return record, nil
+}
+
+// Matches reports whether other describes the same export of the same digest
+func (r *ExportRecord) Matches(other *ExportRecord) bool {
+	return r != nil && other != nil && r.Digest == other.Digest && r.Platform == other.Platform &&
+		r.Output == other.Output && r.Options == other.Options
+}
+
+// Write saves the record to path, replacing any previous one atomically so an
+// interrupted run never leaves a truncated record behind
+func (r *ExportRecord) Write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode export record: %w", err)
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	temp, err := os.CreateTemp(dir, filepath.Base(path)+".partial-*")
+	if err != nil {
+		return fmt.Errorf("failed to write export record: %w", err)
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(append(data, '\n')); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to write export record: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to write export record: %w", err)
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write export record: %w", err)
+	}
+	return nil
+}
+
+// ExportRecordPath returns where the record of exports to output is kept in
+// the jobs area, one file per absolute output path
+func (w *WorkDir) ExportRecordPath(output string) (string, error) {
+	abs, err := filepath.Abs(output)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", output, err)
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(w.Path(WorkDirJobs), workDirExports, hex.EncodeToString(sum[:])+".json"), nil
+}
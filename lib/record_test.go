@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestResolveDigest(t *testing.T) {
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "file", content: "v1"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/resolve:latest"
+	pushTestImage(t, imageRef, image)
+	expected, err := image.Digest()
+	if err != nil {
+		t.Fatalf("Failed to get image digest: %v", err)
+	}
+
+	counter := &requestCounter{requests: map[string]int{}}
+	exporter := NewImageExporter(WithTransportWrapper(counter.wrap))
+	digest, err := exporter.ResolveDigest(imageRef, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if digest != expected.String() {
+		t.Errorf("Expected %s, got %s", expected, digest)
+	}
+	if got := counter.count(http.MethodGet, "/v2/test/resolve/manifests/latest"); got != 0 {
+		t.Errorf("Expected the tag to be resolved without fetching the manifest, got %d requests", got)
+	}
+
+	// A digest reference resolves without the registry
+	pinned := host + "/test/resolve@" + expected.String()
+	before := counter.count(http.MethodGet, "/v2/")
+	if digest, err := exporter.ResolveDigest(pinned, nil); err != nil || digest != expected.String() {
+		t.Errorf("Expected %s, got %s, %v", expected, digest, err)
+	}
+	if got := counter.count(http.MethodGet, "/v2/"); got != before {
+		t.Errorf("Expected no requests for a digest reference, got %d", got-before)
+	}
+
+	if _, err := exporter.ResolveDigest(host+"/test/missing:latest", nil); !IsNotFound(err) {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+}
+
+func TestExportRecord(t *testing.T) {
+	w := NewWorkDir(t.TempDir())
+	path, err := w.ExportRecordPath("out/app.tar")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if filepath.Dir(filepath.Dir(path)) != w.Path(WorkDirJobs) {
+		t.Errorf("Expected the record in the jobs area, got %s", path)
+	}
+	if other, _ := w.ExportRecordPath("out/other.tar"); other == path {
+		t.Errorf("Expected distinct records for distinct outputs, got %s twice", path)
+	}
+
+	record, err := ReadExportRecord(path)
+	if err != nil || record != nil {
+		t.Fatalf("Expected no record yet, got %v, %v", record, err)
+	}
+
+	written := &ExportRecord{
+		SchemaVersion: SchemaVersion,
+		Image:         "registry.example.com/app:v1",
+		Digest:        "sha256:0123",
+		Output:        "out/app.tar",
+		Options:       "compress=true",
+		Time:          time.Now().UTC().Truncate(time.Second),
+	}
+	if err := written.Write(path); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	record, err = ReadExportRecord(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !record.Matches(written) || !record.Time.Equal(written.Time) {
+		t.Errorf("Expected %+v, got %+v", written, record)
+	}
+
+	changed := *written
+	changed.Digest = "sha256:4567"
+	if record.Matches(&changed) {
+		t.Error("Expected a different digest not to match")
+	}
+	changed = *written
+	changed.Options = ""
+	if record.Matches(&changed) {
+		t.Error("Expected different options not to match")
+	}
+}
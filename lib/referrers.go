@@ -0,0 +1,217 @@
+package lib
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// ReferrersOptions configures Referrers
+type ReferrersOptions struct {
+	// ArtifactType only lists referrers of this artifact type, e.g.
+	// "application/vnd.dev.sigstore.bundle.v0.3+json"; empty lists all of them
+	ArtifactType string
+}
+
+// ReferrerList lists the artifacts attached to an image. It is returned by the
+// Referrers method.
+type ReferrerList struct {
+	// SchemaVersion is the version of this JSON document (see SchemaVersion)
+	SchemaVersion int `json:"schema_version"`
+
+	// Reference is the fully-qualified reference that was queried
+	Reference string `json:"reference"`
+
+	// Digest is the manifest digest the referrers are attached to
+	Digest string `json:"digest"`
+
+	// Referrers lists the attached artifacts, in the order of the registry
+	Referrers []Referrer `json:"referrers"`
+}
+
+// Referrer is one artifact attached to an image, such as a signature, an SBOM
+// or an attestation
+type Referrer struct {
+	// Digest is the manifest digest of the artifact
+	Digest string `json:"digest"`
+
+	// MediaType is the media type of the artifact manifest
+	MediaType string `json:"media_type"`
+
+	// ArtifactType is the type of the artifact, empty if its manifest has none
+	ArtifactType string `json:"artifact_type,omitempty"`
+
+	// Size is the size of the artifact manifest in bytes
+	Size int64 `json:"size"`
+
+	// Annotations are the annotations of the artifact manifest, such as
+	// org.opencontainers.image.created
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Referrers lists the artifacts attached to an image through the OCI referrers
+// API: signatures, SBOMs, attestations and anything else pushed with a subject
+// pointing at the image.
+//
+// Registries that split the list in pages, announced with a Link header, are
+// followed to the last page. Registries without the referrers API are queried through the fallback tag
+// the OCI distribution spec defines for them (sha256-<hex>), which tools
+// attaching artifacts keep up to date. Artifacts are attached to one manifest
+// digest: for a multi-platform image, the reference lists those of the index,
+// and a platform manifest digest lists those of that platform.
+//
+// Parameters:
+//   - imageRef: Image reference (e.g., "ghcr.io/org/app:v1" or "ghcr.io/org/app@sha256:...")
+//   - auth: Optional authentication configuration for private registries
+//   - opts: Optional artifact type filter
+//
+// Returns:
+//   - *ReferrerList: The attached artifacts, with the digest they are attached to
+//   - error: Any error encountered during the operation
+//
+// Example:
+//
+//	referrers, err := exporter.Referrers("ghcr.io/org/app:v1", nil, nil)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	for _, referrer := range referrers.Referrers {
+//	    fmt.Println(referrer.ArtifactType, referrer.Digest)
+//	}
+func (e *imageExporter) Referrers(imageRef string, auth *AuthConfig, opts *ReferrersOptions) (*ReferrerList, error) {
+	if opts == nil {
+		opts = &ReferrersOptions{}
+	}
+	ref, err := e.parseReference(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image reference: %w", err)
+	}
+	digest, err := e.ResolveDigest(imageRef, auth)
+	if err != nil {
+		return nil, err
+	}
+	subject := ref.Context().Digest(digest)
+
+	descriptors, ok, err := e.listReferrers(subject, auth, opts.ArtifactType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list referrers of %s: %w", subject, err)
+	}
+	if !ok {
+		// The fallback tag holds a single index, with no pages
+		options := e.remoteOptions(auth)
+		if opts.ArtifactType != "" {
+			options = append(options, remote.WithFilter("artifactType", opts.ArtifactType))
+		}
+		index, err := remote.Referrers(subject, options...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list referrers of %s: %w", subject, err)
+		}
+		manifest, err := index.IndexManifest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse referrers of %s: %w", subject, err)
+		}
+		descriptors = manifest.Manifests
+	}
+
+	list := &ReferrerList{
+		SchemaVersion: SchemaVersion,
+		Reference:     ref.Name(),
+		Digest:        digest,
+		Referrers:     make([]Referrer, 0, len(descriptors)),
+	}
+	for _, desc := range descriptors {
+		list.Referrers = append(list.Referrers, Referrer{
+			Digest:       desc.Digest.String(),
+			MediaType:    string(desc.MediaType),
+			ArtifactType: desc.ArtifactType,
+			Size:         desc.Size,
+			Annotations:  desc.Annotations,
+		})
+	}
+	return list, nil
+}
+
+// listReferrers reads every page of the referrers API for subject, following
+// the Link headers that go-containerregistry ignores. It returns false when the
+// registry does not implement the API, so that the fallback tag is used.
+func (e *imageExporter) listReferrers(subject name.Digest, auth *AuthConfig, artifactType string) ([]v1.Descriptor, bool, error) {
+	client, err := e.repositoryClient(subject.Context(), auth)
+	if err != nil {
+		return nil, false, err
+	}
+	next := &url.URL{
+		Scheme: subject.Registry.Scheme(),
+		Host:   subject.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/referrers/%s", subject.RepositoryStr(), subject.DigestStr()),
+	}
+	if artifactType != "" {
+		next.RawQuery = url.Values{"artifactType": {artifactType}}.Encode()
+	}
+
+	var descriptors []v1.Descriptor
+	seen := make(map[string]bool)
+	for next != nil && !seen[next.String()] {
+		seen[next.String()] = true
+		req, err := http.NewRequest(http.MethodGet, next.String(), nil)
+		if err != nil {
+			return nil, false, err
+		}
+		req.Header.Set("Accept", string(types.OCIImageIndex))
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(seen) == 1 {
+			// The answers of registries without the API, as in remote.Referrers
+			mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+			switch {
+			case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusBadRequest, resp.StatusCode == http.StatusNotAcceptable,
+				resp.StatusCode == http.StatusOK && mediaType != string(types.OCIImageIndex):
+				resp.Body.Close()
+				return nil, false, nil
+			}
+		}
+		if err := transport.CheckError(resp, http.StatusOK); err != nil {
+			resp.Body.Close()
+			return nil, false, err
+		}
+		manifest, err := v1.ParseIndexManifest(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to parse referrers page: %w", err)
+		}
+		for _, desc := range manifest.Manifests {
+			// Registries may ignore the artifactType query
+			if artifactType == "" || desc.ArtifactType == artifactType {
+				descriptors = append(descriptors, desc)
+			}
+		}
+		next = nextPage(next, resp.Header.Get("Link"))
+	}
+	return descriptors, true, nil
+}
+
+// nextPage returns the target of the rel="next" link of a Link header,
+// resolved against the page it was returned with, or nil on the last page
+func nextPage(page *url.URL, header string) *url.URL {
+	for _, link := range strings.Split(header, ",") {
+		target, params, _ := strings.Cut(strings.TrimSpace(link), ";")
+		if !strings.Contains(strings.ReplaceAll(params, " ", ""), `rel="next"`) {
+			continue
+		}
+		u, err := url.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+		if err != nil {
+			return nil
+		}
+		return page.ResolveReference(u)
+	}
+	return nil
+}
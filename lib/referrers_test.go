@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// pushTestReferrer attaches an artifact of artifactType with one blob holding
+// content to subject, and returns the digest of the artifact manifest
+func pushTestReferrer(t *testing.T, subject string, artifactType types.MediaType, layerType types.MediaType, content []byte, annotations map[string]string) string {
+	t.Helper()
+
+	ref, err := name.ParseReference(subject)
+	if err != nil {
+		t.Fatalf("Failed to parse test reference %s: %v", subject, err)
+	}
+	desc, err := remote.Get(ref)
+	if err != nil {
+		t.Fatalf("Failed to get test subject %s: %v", subject, err)
+	}
+
+	artifact := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	artifact = mutate.ConfigMediaType(artifact, artifactType)
+	artifact, err = mutate.Append(artifact, mutate.Addendum{Layer: static.NewLayer(content, layerType)})
+	if err != nil {
+		t.Fatalf("Failed to build test artifact: %v", err)
+	}
+	artifact = mutate.Annotations(artifact, annotations).(v1.Image)
+	artifact = mutate.Subject(artifact, desc.Descriptor).(v1.Image)
+
+	digest, err := artifact.Digest()
+	if err != nil {
+		t.Fatalf("Failed to get test artifact digest: %v", err)
+	}
+	if err := remote.Write(ref.Context().Digest(digest.String()), artifact); err != nil {
+		t.Fatalf("Failed to push test artifact: %v", err)
+	}
+	return digest.String()
+}
+
+func TestReferrers(t *testing.T) {
+	host := newTestRegistry(t)
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "file", content: "data"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	imageRef := host + "/test/app:v1"
+	pushTestImage(t, imageRef, image)
+
+	// The test registry lists artifact types, but not the annotations
+	sbom := pushTestReferrer(t, imageRef, "application/spdx+json", "application/spdx+json", []byte(`{}`),
+		map[string]string{"org.opencontainers.image.created": "2024-05-01T10:00:00Z"})
+	signature := pushTestReferrer(t, imageRef, "application/vnd.dev.sigstore.bundle.v0.3+json", "application/vnd.dev.sigstore.bundle.v0.3+json", []byte(`{}`), nil)
+
+	exporter := NewImageExporter()
+	list, err := exporter.Referrers(imageRef, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	imageDigest, _ := image.Digest()
+	if list.Digest != imageDigest.String() {
+		t.Errorf("Expected subject digest %s, got %s", imageDigest, list.Digest)
+	}
+	found := make(map[string]Referrer)
+	for _, referrer := range list.Referrers {
+		found[referrer.Digest] = referrer
+	}
+	if len(found) != 2 {
+		t.Fatalf("Expected 2 referrers, got %+v", list.Referrers)
+	}
+	if found[sbom].ArtifactType != "application/spdx+json" {
+		t.Errorf("Expected SBOM artifact type, got %q", found[sbom].ArtifactType)
+	}
+	if found[signature].MediaType != string(types.OCIManifestSchema1) || found[signature].Size == 0 {
+		t.Errorf("Expected signature manifest descriptor, got %+v", found[signature])
+	}
+
+	filtered, err := exporter.Referrers(imageRef, nil, &ReferrersOptions{ArtifactType: "application/spdx+json"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(filtered.Referrers) != 1 || filtered.Referrers[0].Digest != sbom {
+		t.Errorf("Expected only the SBOM, got %+v", filtered.Referrers)
+	}
+
+	pushTestImage(t, host+"/test/app:bare", empty.Image)
+	bare, err := exporter.Referrers(host+"/test/app:bare", nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(bare.Referrers) != 0 {
+		t.Errorf("Expected no referrers, got %+v", bare.Referrers)
+	}
+}
+
+func TestReferrersPagination(t *testing.T) {
+	// Serves the referrers list one artifact per page, linking to the next
+	inner := registry.New(registry.Logger(log.New(io.Discard, "", 0)), registry.WithReferrersSupport(true))
+	var pages int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/referrers/") {
+			inner.ServeHTTP(w, r)
+			return
+		}
+		recorder := httptest.NewRecorder()
+		inner.ServeHTTP(recorder, r)
+		var index v1.IndexManifest
+		if err := json.Unmarshal(recorder.Body.Bytes(), &index); err != nil {
+			t.Errorf("Failed to parse referrers: %v", err)
+		}
+		pages++
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page+1 < len(index.Manifests) {
+			w.Header().Set("Link", fmt.Sprintf("<%s?page=%d>; rel=\"next\"", r.URL.Path, page+1))
+		}
+		if page < len(index.Manifests) {
+			index.Manifests = index.Manifests[page : page+1]
+		}
+		w.Header().Set("Content-Type", string(types.OCIImageIndex))
+		json.NewEncoder(w).Encode(index)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse registry URL: %v", err)
+	}
+
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "file", content: "data"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	imageRef := u.Host + "/test/app:v1"
+	pushTestImage(t, imageRef, image)
+	expected := map[string]bool{}
+	for _, artifactType := range []types.MediaType{"application/spdx+json", "application/vnd.in-toto+json", "application/vnd.dev.sigstore.bundle.v0.3+json"} {
+		expected[pushTestReferrer(t, imageRef, artifactType, artifactType, []byte(`{}`), nil)] = true
+	}
+
+	pages = 0
+	list, err := NewImageExporter().Referrers(imageRef, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if pages != 3 {
+		t.Errorf("Expected 3 pages to be read, got %d", pages)
+	}
+	if len(list.Referrers) != 3 {
+		t.Fatalf("Expected 3 referrers, got %+v", list.Referrers)
+	}
+	for _, referrer := range list.Referrers {
+		if !expected[referrer.Digest] {
+			t.Errorf("Expected one of the pushed referrers, got %s", referrer.Digest)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// EnvSourceDateEpoch is the reproducible-builds.org variable holding the
+// timestamp, in seconds since the Unix epoch, to write instead of the current
+// time. See SourceDateEpochFromEnv.
+const EnvSourceDateEpoch = "SOURCE_DATE_EPOCH"
+
+// ErrNotReproducible is returned by exports with Reproducible set when the
+// archive could differ from one run to the next
+var ErrNotReproducible = errors.New("export is not reproducible")
+
+// hostPAXKeys are PAX records that tar implementations copy from the build
+// host: inode and device numbers, link counts and creation times. They are
+// written to the archive as found in the layers, unless the export is
+// reproducible.
+var hostPAXKeys = []string{"SCHILY.dev", "SCHILY.ino", "SCHILY.nlink", "LIBARCHIVE.creationtime"}
+
+// SourceDateEpochFromEnv returns the time set by the SOURCE_DATE_EPOCH
+// environment variable, for ExportOptions.SourceDateEpoch.
+//
+// Returns:
+//   - time.Time: The time, zero if SOURCE_DATE_EPOCH is unset or empty
+//   - error: If SOURCE_DATE_EPOCH is not a non-negative number of seconds
+func SourceDateEpochFromEnv() (time.Time, error) {
+	value := os.Getenv(EnvSourceDateEpoch)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return time.Time{}, fmt.Errorf("invalid %s %q: expected seconds since the Unix epoch", EnvSourceDateEpoch, value)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// exportModTime is the modification time written for every entry of an export
+func exportModTime(opts *ExportOptions) time.Time {
+	if opts == nil || opts.SourceDateEpoch.IsZero() {
+		return time.Unix(0, 0)
+	}
+	return opts.SourceDateEpoch
+}
+
+// checkPinned fails with ErrNotReproducible unless imageRef names a registry
+// image by digest: a tag, or an image read from a local source, may change
+// between runs.
+func (e *imageExporter) checkPinned(imageRef string) error {
+	for _, prefix := range []string{DockerArchivePrefix, OCILayoutPrefix, ContainerdPrefix, DockerDaemonPrefix} {
+		if strings.HasPrefix(imageRef, prefix) {
+			return fmt.Errorf("%s is a local image that may change between runs: %w", imageRef, ErrNotReproducible)
+		}
+	}
+	if e.source == ImageSourceDaemon {
+		return fmt.Errorf("images of the docker daemon may change between runs: %w", ErrNotReproducible)
+	}
+	ref, err := e.parseReference(imageRef)
+	if err != nil {
+		return fmt.Errorf("failed to parse image reference %s: %w", imageRef, err)
+	}
+	if _, ok := ref.(name.Digest); !ok {
+		return fmt.Errorf("%s is not pinned by digest and may point to another image on the next run: %w", imageRef, ErrNotReproducible)
+	}
+	return nil
+}
+
+// dropHostRecords removes the PAX records copied from the build host from an
+// entry, so that rebuilding the same image elsewhere gives the same archive
+func dropHostRecords(header *tar.Header) {
+	for _, key := range hostPAXKeys {
+		delete(header.PAXRecords, key)
+	}
+}
@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestReproducibleExport(t *testing.T) {
+	host := newTestRegistry(t)
+	imageRef := host + "/test/app:v1"
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t,
+		testEntry{name: "etc/", typeflag: tar.TypeDir, mode: 0755},
+		testEntry{name: "etc/app.conf", content: "a", uid: 1000, gid: 1000},
+	))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	pushTestImage(t, imageRef, image)
+	digest, _ := image.Digest()
+	pinned := host + "/test/app@" + digest.String()
+
+	exporter := NewImageExporter()
+	if err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, io.Discard, nil, &ExportOptions{Reproducible: true}); !errors.Is(err, ErrNotReproducible) {
+		t.Errorf("Expected ErrNotReproducible for a tag, got %v", err)
+	}
+	if err := exporter.ExportImageFilesystemToWriterWithOptions("oci:./layout:v1", io.Discard, nil, &ExportOptions{Reproducible: true}); !errors.Is(err, ErrNotReproducible) {
+		t.Errorf("Expected ErrNotReproducible for a local image, got %v", err)
+	}
+
+	epoch := time.Unix(1700000000, 0)
+	var outputs [2]bytes.Buffer
+	for i := range outputs {
+		opts := &ExportOptions{Reproducible: true, Compress: true, SourceDateEpoch: epoch}
+		if err := exporter.ExportImageFilesystemToWriterWithOptions(pinned, &outputs[i], nil, opts); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if !bytes.Equal(outputs[0].Bytes(), outputs[1].Bytes()) {
+		t.Error("Expected byte-identical archives")
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(outputs[0].Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		if !header.ModTime.Equal(epoch) {
+			t.Errorf("Expected %s modified at %v, got %v", header.Name, epoch, header.ModTime)
+		}
+	}
+
+	// Records copied from the build host are stripped, other records are kept
+	hostImage, err := mutate.AppendLayers(empty.Image, newTestLayer(t,
+		testEntry{name: "bin/sh", content: "elf", pax: map[string]string{
+			"SCHILY.dev": "64769", "SCHILY.ino": "1234", "SCHILY.nlink": "1",
+			"LIBARCHIVE.creationtime": "1700000000", "SCHILY.xattr.user.app": "yes",
+		}},
+	))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	pushTestImage(t, host+"/test/host:v1", hostImage)
+	hostDigest, _ := hostImage.Digest()
+	var output bytes.Buffer
+	if err := exporter.ExportImageFilesystemToWriterWithOptions(host+"/test/host@"+hostDigest.String(), &output, nil, &ExportOptions{Reproducible: true}); err != nil {
+		t.Fatalf("Expected no error for build-host records, got %v", err)
+	}
+	reader = tar.NewReader(&output)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		if header.Name != "bin/sh" {
+			continue
+		}
+		for _, key := range hostPAXKeys {
+			if value, ok := header.PAXRecords[key]; ok {
+				t.Errorf("Expected %s to be stripped, got %q", key, value)
+			}
+		}
+		if header.PAXRecords["SCHILY.xattr.user.app"] != "yes" {
+			t.Errorf("Expected the xattr record to be kept, got %v", header.PAXRecords)
+		}
+	}
+}
+
+func TestSourceDateEpochFromEnv(t *testing.T) {
+	t.Setenv(EnvSourceDateEpoch, "")
+	if epoch, err := SourceDateEpochFromEnv(); err != nil || !epoch.IsZero() {
+		t.Errorf("Expected zero time when unset, got %v, %v", epoch, err)
+	}
+	t.Setenv(EnvSourceDateEpoch, "1700000000")
+	if epoch, err := SourceDateEpochFromEnv(); err != nil || epoch.Unix() != 1700000000 {
+		t.Errorf("Expected 1700000000, got %v, %v", epoch, err)
+	}
+	t.Setenv(EnvSourceDateEpoch, "yesterday")
+	if _, err := SourceDateEpochFromEnv(); err == nil {
+		t.Error("Expected an error for an invalid value, got nil")
+	}
+}
@@ -0,0 +1,25 @@
+package lib
+
+// CommandResult is the document the imgex CLI prints with --json for commands
+// that have no JSON document of their own (login, delete, bundle-import, ...)
+// and for every command that fails, so that wrappers always receive exactly
+// one JSON document on stdout.
+type CommandResult struct {
+	// SchemaVersion is the version of this JSON document (see SchemaVersion)
+	SchemaVersion int `json:"schema_version"`
+
+	// Command is the command that ran, e.g. "state clean"
+	Command string `json:"command"`
+
+	// OK reports whether the command succeeded
+	OK bool `json:"ok"`
+
+	// Output holds the lines the command prints for people, without styling
+	Output []string `json:"output,omitempty"`
+
+	// Warnings lists the non-fatal problems reported while the command ran
+	Warnings []Warning `json:"warnings,omitempty"`
+
+	// Error describes why the command failed
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,507 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// The rpm database holds one header blob per installed package, in one of the
+// formats RPM used over time. They are read here without the rpm library:
+// only the tables and pages holding the headers are decoded.
+
+// rpmHeader holds the tags of an rpm header that are inventoried
+type rpmHeader struct {
+	name, version, release, arch, license, sourceRPM string
+	epoch                                            int
+}
+
+// rpm header tags and types
+const (
+	rpmTagName      = 1000
+	rpmTagVersion   = 1001
+	rpmTagRelease   = 1002
+	rpmTagEpoch     = 1003
+	rpmTagLicense   = 1014
+	rpmTagArch      = 1022
+	rpmTagSourceRPM = 1044
+
+	rpmTypeInt32       = 4
+	rpmTypeString      = 6
+	rpmTypeStringArray = 8
+	rpmTypeI18NString  = 9
+)
+
+// parseRPMHeader decodes a header blob as stored in the database: an index of
+// tag entries followed by the data store, without the lead of package files
+func parseRPMHeader(blob []byte) (*rpmHeader, error) {
+	if len(blob) < 8 {
+		return nil, errors.New("truncated rpm header")
+	}
+	count := binary.BigEndian.Uint32(blob[0:4])
+	size := binary.BigEndian.Uint32(blob[4:8])
+	if count > 0xffff || size > maxPackageDatabase || 8+uint64(count)*16+uint64(size) > uint64(len(blob)) {
+		return nil, errors.New("invalid rpm header size")
+	}
+	store := blob[8+count*16 : 8+count*16+size]
+
+	header := &rpmHeader{}
+	for i := uint32(0); i < count; i++ {
+		entry := blob[8+i*16 : 8+i*16+16]
+		tag := binary.BigEndian.Uint32(entry[0:4])
+		kind := binary.BigEndian.Uint32(entry[4:8])
+		offset := binary.BigEndian.Uint32(entry[8:12])
+		if offset >= size {
+			continue
+		}
+		if tag == rpmTagEpoch && kind == rpmTypeInt32 && offset+4 <= size {
+			header.epoch = int(binary.BigEndian.Uint32(store[offset:]))
+			continue
+		}
+		if kind != rpmTypeString && kind != rpmTypeStringArray && kind != rpmTypeI18NString {
+			continue
+		}
+		value := store[offset:]
+		if end := bytes.IndexByte(value, 0); end >= 0 {
+			value = value[:end]
+		}
+		switch tag {
+		case rpmTagName:
+			header.name = string(value)
+		case rpmTagVersion:
+			header.version = string(value)
+		case rpmTagRelease:
+			header.release = string(value)
+		case rpmTagLicense:
+			header.license = string(value)
+		case rpmTagArch:
+			header.arch = string(value)
+		case rpmTagSourceRPM:
+			header.sourceRPM = string(value)
+		}
+	}
+	if header.name == "" {
+		return nil, errors.New("rpm header without a name")
+	}
+	return header, nil
+}
+
+// pkg converts the header to a Package listed in database
+func (h *rpmHeader) pkg(distro *Distro, database string) Package {
+	version := h.version
+	if h.release != "" {
+		version += "-" + h.release
+	}
+	var epoch string
+	if h.epoch != 0 {
+		epoch = strconv.Itoa(h.epoch)
+	}
+	pkg := Package{
+		Name:     h.name,
+		Version:  version,
+		Type:     PackageTypeRPM,
+		Arch:     h.arch,
+		License:  h.license,
+		Source:   h.sourceRPM,
+		PURL:     packageURL(PackageTypeRPM, distro.namespace(""), h.name, version, [2]string{"arch", h.arch}, [2]string{"epoch", epoch}, [2]string{"distro", distro.qualifier()}),
+		Location: database,
+	}
+	if epoch != "" {
+		pkg.Version = epoch + ":" + version
+	}
+	return pkg
+}
+
+// readRPMSQLite returns the header blobs of the Packages table of an SQLite
+// rpm database (rpmdb.sqlite, RPM 4.16 and later)
+func readRPMSQLite(data []byte) ([][]byte, error) {
+	db, err := openSQLite(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// The schema table lists the root page of each table
+	root := 0
+	err = db.walk(1, func(record []any) error {
+		if len(record) >= 4 && record[0] == "table" && record[1] == "Packages" {
+			if page, ok := record[3].(int64); ok {
+				root = int(page)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if root == 0 {
+		return nil, errors.New("no Packages table")
+	}
+
+	// CREATE TABLE Packages (hnum INTEGER PRIMARY KEY AUTOINCREMENT, blob BLOB NOT NULL)
+	var headers [][]byte
+	err = db.walk(root, func(record []any) error {
+		if len(record) < 2 {
+			return errors.New("invalid Packages row")
+		}
+		blob, ok := record[1].([]byte)
+		if !ok {
+			return errors.New("invalid Packages row")
+		}
+		headers = append(headers, blob)
+		return nil
+	})
+	return headers, err
+}
+
+// sqliteDB reads the table b-trees of an SQLite database file
+type sqliteDB struct {
+	data     []byte
+	pageSize int
+	usable   int
+}
+
+// openSQLite checks the header of an SQLite database file
+func openSQLite(data []byte) (*sqliteDB, error) {
+	if len(data) < 100 || string(data[:16]) != "SQLite format 3\x00" {
+		return nil, errors.New("not an SQLite database")
+	}
+	pageSize := int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("invalid SQLite page size %d", pageSize)
+	}
+	return &sqliteDB{data: data, pageSize: pageSize, usable: pageSize - int(data[20])}, nil
+}
+
+// page returns page n (1-based)
+func (db *sqliteDB) page(n int) ([]byte, error) {
+	start := (n - 1) * db.pageSize
+	if n < 1 || start+db.pageSize > len(db.data) {
+		return nil, fmt.Errorf("SQLite page %d out of range", n)
+	}
+	return db.data[start : start+db.pageSize], nil
+}
+
+// walk calls fn with the records of the table b-tree rooted at page root, in
+// rowid order
+func (db *sqliteDB) walk(root int, fn func([]any) error) error {
+	visited := map[int]bool{}
+	var walk func(n int) error
+	walk = func(n int) error {
+		if visited[n] {
+			return fmt.Errorf("SQLite page %d referenced twice", n)
+		}
+		visited[n] = true
+		page, err := db.page(n)
+		if err != nil {
+			return err
+		}
+		header := page
+		if n == 1 {
+			header = page[100:]
+		}
+		cells := int(binary.BigEndian.Uint16(header[3:5]))
+		if 12+2*cells > len(header) {
+			return fmt.Errorf("invalid SQLite page %d", n)
+		}
+
+		switch header[0] {
+		case 0x05: // interior table page: child pointers, then the right-most child
+			for i := 0; i < cells; i++ {
+				offset := int(binary.BigEndian.Uint16(header[12+2*i:]))
+				if offset+4 > len(page) {
+					return fmt.Errorf("invalid cell in SQLite page %d", n)
+				}
+				if err := walk(int(binary.BigEndian.Uint32(page[offset:]))); err != nil {
+					return err
+				}
+			}
+			return walk(int(binary.BigEndian.Uint32(header[8:12])))
+		case 0x0d: // leaf table page
+			for i := 0; i < cells; i++ {
+				offset := int(binary.BigEndian.Uint16(header[8+2*i:]))
+				payload, err := db.payload(page, offset)
+				if err != nil {
+					return fmt.Errorf("invalid cell in SQLite page %d: %w", n, err)
+				}
+				record, err := parseSQLiteRecord(payload)
+				if err != nil {
+					return fmt.Errorf("invalid record in SQLite page %d: %w", n, err)
+				}
+				if err := fn(record); err != nil {
+					return err
+				}
+			}
+			return nil
+		default:
+			return fmt.Errorf("SQLite page %d is not a table page", n)
+		}
+	}
+	return walk(root)
+}
+
+// payload returns the payload of the leaf cell at offset, reassembled from
+// its overflow pages
+func (db *sqliteDB) payload(page []byte, offset int) ([]byte, error) {
+	size, n := sqliteVarint(page, offset)
+	if n == 0 || size > maxPackageDatabase {
+		return nil, errors.New("invalid payload size")
+	}
+	offset += n
+	if _, n = sqliteVarint(page, offset); n == 0 { // rowid
+		return nil, errors.New("invalid rowid")
+	}
+	offset += n
+
+	// How much of the payload is stored in the cell, as computed by SQLite
+	total := int(size)
+	local := total
+	if maxLocal := db.usable - 35; total > maxLocal {
+		minLocal := (db.usable-12)*32/255 - 23
+		local = minLocal + (total-minLocal)%(db.usable-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+	if offset+local > len(page) {
+		return nil, errors.New("truncated payload")
+	}
+	payload := append([]byte(nil), page[offset:offset+local]...)
+	if local == total {
+		return payload, nil
+	}
+
+	if offset+local+4 > len(page) {
+		return nil, errors.New("truncated payload")
+	}
+	next := int(binary.BigEndian.Uint32(page[offset+local:]))
+	for pages := 0; len(payload) < total; pages++ {
+		if next == 0 || pages > len(db.data)/db.pageSize {
+			return nil, errors.New("truncated overflow chain")
+		}
+		overflow, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		chunk := overflow[4:db.usable]
+		if remaining := total - len(payload); len(chunk) > remaining {
+			chunk = chunk[:remaining]
+		}
+		payload = append(payload, chunk...)
+		next = int(binary.BigEndian.Uint32(overflow[0:4]))
+	}
+	return payload, nil
+}
+
+// sqliteVarint decodes the big-endian variable-length integer at offset,
+// returning its length, or 0 if it is truncated
+func sqliteVarint(data []byte, offset int) (uint64, int) {
+	var value uint64
+	for i := 0; i < 9; i++ {
+		if offset+i >= len(data) {
+			return 0, 0
+		}
+		b := data[offset+i]
+		if i == 8 {
+			return value<<8 | uint64(b), 9
+		}
+		value = value<<7 | uint64(b&0x7f)
+		if b < 0x80 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// parseSQLiteRecord decodes a record into nil, int64, string (text) and
+// []byte (blob) values; floats are left out as nil
+func parseSQLiteRecord(payload []byte) ([]any, error) {
+	headerSize, n := sqliteVarint(payload, 0)
+	if n == 0 || headerSize > uint64(len(payload)) {
+		return nil, errors.New("invalid record header")
+	}
+	var types []uint64
+	for offset := n; offset < int(headerSize); {
+		serialType, n := sqliteVarint(payload, offset)
+		if n == 0 {
+			return nil, errors.New("invalid record header")
+		}
+		types = append(types, serialType)
+		offset += n
+	}
+
+	body := payload[headerSize:]
+	record := make([]any, 0, len(types))
+	for _, serialType := range types {
+		var size int
+		switch {
+		case serialType >= 12:
+			size = int((serialType - 12) / 2)
+		case serialType >= 1 && serialType <= 4:
+			size = int(serialType)
+		case serialType == 5:
+			size = 6
+		case serialType == 6 || serialType == 7:
+			size = 8
+		}
+		if size > len(body) {
+			return nil, errors.New("truncated record")
+		}
+		value := body[:size]
+		body = body[size:]
+
+		switch {
+		case serialType >= 12 && serialType%2 == 0:
+			record = append(record, value)
+		case serialType >= 13:
+			record = append(record, string(value))
+		case serialType >= 1 && serialType <= 6:
+			// Big-endian two's complement of 1 to 8 bytes
+			v := int64(int8(value[0]))
+			for _, b := range value[1:] {
+				v = v<<8 | int64(b)
+			}
+			record = append(record, v)
+		case serialType == 8:
+			record = append(record, int64(0))
+		case serialType == 9:
+			record = append(record, int64(1))
+		default:
+			record = append(record, nil)
+		}
+	}
+	return record, nil
+}
+
+// NDB rpm database (Packages.db, SUSE): a header and slot pages indexing
+// the header blobs, which are stored in 16-byte blocks
+const (
+	ndbHeaderMagic  = 'R' | 'p'<<8 | 'm'<<16 | 'P'<<24
+	ndbSlotMagic    = 'S' | 'l'<<8 | 'o'<<16 | 't'<<24
+	ndbBlobMagic    = 'B' | 'l'<<8 | 'b'<<16 | 'S'<<24
+	ndbSlotsPerPage = 4096 / 16
+	ndbBlockSize    = 16
+)
+
+// readRPMNDB returns the header blobs of an NDB rpm database
+func readRPMNDB(data []byte) ([][]byte, error) {
+	le := binary.LittleEndian
+	if len(data) < 32 || le.Uint32(data[0:4]) != ndbHeaderMagic || le.Uint32(data[4:8]) != 0 {
+		return nil, errors.New("not an NDB database")
+	}
+	// The 32-byte header takes the place of the first two slots
+	slots := int(le.Uint32(data[12:16]))*ndbSlotsPerPage - 2
+	if slots < 0 || 32+slots*16 > len(data) {
+		return nil, errors.New("truncated NDB slot pages")
+	}
+
+	var headers [][]byte
+	for i := 0; i < slots; i++ {
+		slot := data[32+i*16 : 32+i*16+16]
+		index := le.Uint32(slot[4:8])
+		if le.Uint32(slot[0:4]) != ndbSlotMagic || index == 0 {
+			continue
+		}
+		offset := int(le.Uint32(slot[8:12])) * ndbBlockSize
+		if offset < 0 || offset+16 > len(data) {
+			return nil, fmt.Errorf("NDB package %d out of range", index)
+		}
+		blob := data[offset:]
+		size := int(le.Uint32(blob[12:16]))
+		if le.Uint32(blob[0:4]) != ndbBlobMagic || le.Uint32(blob[4:8]) != index || size > len(blob)-16 {
+			return nil, fmt.Errorf("invalid NDB blob of package %d", index)
+		}
+		headers = append(headers, blob[16:16+size])
+	}
+	return headers, nil
+}
+
+// Berkeley DB hash database (Packages, RPM before 4.16): the header blobs are
+// the values of hash pages, stored on overflow pages
+const (
+	bdbHashMagic    = 0x061561
+	bdbPageHeader   = 26
+	bdbPageHash     = 13
+	bdbPageHashOld  = 2
+	bdbPageOverflow = 7
+	bdbItemOffPage  = 3
+)
+
+// readRPMBerkeleyDB returns the header blobs of a Berkeley DB hash database
+// in either byte order
+func readRPMBerkeleyDB(data []byte) ([][]byte, error) {
+	if len(data) < 512 {
+		return nil, errors.New("not a Berkeley DB database")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if order.Uint32(data[12:16]) != bdbHashMagic {
+		order = binary.BigEndian
+		if order.Uint32(data[12:16]) != bdbHashMagic {
+			return nil, errors.New("not a Berkeley DB hash database")
+		}
+	}
+	pageSize := int(order.Uint32(data[20:24]))
+	if pageSize < 512 || pageSize > 65536 {
+		return nil, fmt.Errorf("invalid Berkeley DB page size %d", pageSize)
+	}
+	pages := len(data) / pageSize
+
+	var headers [][]byte
+	for n := 1; n < pages; n++ {
+		page := data[n*pageSize : (n+1)*pageSize]
+		if page[25] != bdbPageHash && page[25] != bdbPageHashOld {
+			continue
+		}
+		// Items alternate between keys and values
+		entries := int(order.Uint16(page[20:22]))
+		for i := 1; i < entries; i += 2 {
+			if bdbPageHeader+2*i+2 > pageSize {
+				return nil, fmt.Errorf("invalid Berkeley DB page %d", n)
+			}
+			offset := int(order.Uint16(page[bdbPageHeader+2*i:]))
+			if offset+12 > pageSize || page[offset] != bdbItemOffPage {
+				continue
+			}
+			blob, err := readBerkeleyDBOverflow(data, pageSize, order,
+				int(order.Uint32(page[offset+4:])), int(order.Uint32(page[offset+8:])))
+			if err != nil {
+				return nil, err
+			}
+			headers = append(headers, blob)
+		}
+	}
+	return headers, nil
+}
+
+// readBerkeleyDBOverflow reassembles a value of size bytes from the chain of
+// overflow pages starting at page n
+func readBerkeleyDBOverflow(data []byte, pageSize int, order binary.ByteOrder, n, size int) ([]byte, error) {
+	if size > maxPackageDatabase {
+		return nil, errors.New("invalid Berkeley DB value size")
+	}
+	value := make([]byte, 0, size)
+	for pages := 0; n != 0 && len(value) < size; pages++ {
+		if (n+1)*pageSize > len(data) || pages > len(data)/pageSize {
+			return nil, fmt.Errorf("invalid Berkeley DB overflow page %d", n)
+		}
+		page := data[n*pageSize : (n+1)*pageSize]
+		if page[25] != bdbPageOverflow {
+			return nil, fmt.Errorf("Berkeley DB page %d is not an overflow page", n)
+		}
+		// The free area offset of an overflow page is the length of its data
+		length := int(order.Uint16(page[22:24]))
+		if bdbPageHeader+length > pageSize {
+			return nil, fmt.Errorf("invalid Berkeley DB overflow page %d", n)
+		}
+		value = append(value, page[bdbPageHeader:bdbPageHeader+length]...)
+		n = int(order.Uint32(page[16:20]))
+	}
+	if len(value) < size {
+		return nil, errors.New("truncated Berkeley DB value")
+	}
+	return value[:size], nil
+}
@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MergeEnv merges environment overrides into an image's environment using the
+// same rules as 'docker run -e'.
+//
+// Entries in overrides replace image entries with the same key in place, and
+// entries with new keys are appended in the order given. An override without
+// an '=' (e.g. "DEBUG") removes that key from the result, matching how the
+// Docker daemon treats variables that were not resolved on the client side.
+// Neither input slice is modified.
+//
+// Parameters:
+//   - imageEnv: Environment from the image configuration, in "KEY=VALUE" form
+//   - overrides: Environment supplied at run time, in "KEY=VALUE" or "KEY" form
+//
+// Returns:
+//   - []string: The merged environment in "KEY=VALUE" form
+//
+// Example:
+//
+//	env := MergeEnv(config.Env, []string{"PATH=/opt/bin", "DEBUG=1"})
+func MergeEnv(imageEnv []string, overrides []string) []string {
+	merged := make([]string, 0, len(imageEnv)+len(overrides))
+	index := make(map[string]int, len(imageEnv))
+	for _, entry := range imageEnv {
+		key := envKey(entry)
+		if i, exists := index[key]; exists {
+			// Later duplicates in the image win, as they do at container start
+			merged[i] = entry
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, entry)
+	}
+
+	for _, entry := range overrides {
+		key := envKey(entry)
+		if !strings.Contains(entry, "=") {
+			// A bare key means the variable should be unset
+			if i, exists := index[key]; exists {
+				merged[i] = ""
+			}
+			continue
+		}
+		if i, exists := index[key]; exists && merged[i] != "" {
+			merged[i] = entry
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, entry)
+	}
+
+	// Drop entries that were unset by a bare-key override
+	result := merged[:0]
+	for _, entry := range merged {
+		if entry != "" {
+			result = append(result, entry)
+		}
+	}
+
+	return result
+}
+
+// ResolveCommand computes the argument vector a container would start with
+// when run as 'docker run <image> [args...]'.
+//
+// The image entrypoint is always kept. When args is non-empty it replaces the
+// image's default Cmd, otherwise the default Cmd is used. The result is the
+// entrypoint followed by the effective command.
+//
+// Parameters:
+//   - config: The image configuration as returned by GetImageConfig
+//   - args: Arguments supplied after the image name, may be empty
+//
+// Returns:
+//   - []string: The full argument vector, with the executable first
+//   - error: An error if neither the image nor args specify a command
+//
+// Example:
+//
+//	argv, err := ResolveCommand(config, []string{"-c", "echo hi"})
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	fmt.Println(strings.Join(argv, " "))
+func ResolveCommand(config *ImageConfig, args []string) ([]string, error) {
+	var entrypoint, cmd []string
+	if config != nil {
+		entrypoint = config.Entrypoint
+		cmd = config.Cmd
+	}
+	if len(args) > 0 {
+		cmd = args
+	}
+
+	argv := make([]string, 0, len(entrypoint)+len(cmd))
+	argv = append(argv, entrypoint...)
+	argv = append(argv, cmd...)
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("no command specified")
+	}
+
+	return argv, nil
+}
+
+// envKey returns the variable name portion of a "KEY=VALUE" entry
+func envKey(entry string) string {
+	if i := strings.Index(entry, "="); i >= 0 {
+		return entry[:i]
+	}
+	return entry
+}
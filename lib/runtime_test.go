@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeEnv(t *testing.T) {
+	testCases := []struct {
+		name      string
+		imageEnv  []string
+		overrides []string
+		expected  []string
+	}{
+		{
+			name:     "no overrides",
+			imageEnv: []string{"PATH=/usr/bin", "HOME=/root"},
+			expected: []string{"PATH=/usr/bin", "HOME=/root"},
+		},
+		{
+			name:      "replace in place",
+			imageEnv:  []string{"PATH=/usr/bin", "HOME=/root"},
+			overrides: []string{"PATH=/opt/bin"},
+			expected:  []string{"PATH=/opt/bin", "HOME=/root"},
+		},
+		{
+			name:      "append new keys",
+			imageEnv:  []string{"PATH=/usr/bin"},
+			overrides: []string{"DEBUG=1", "LANG=C.UTF-8"},
+			expected:  []string{"PATH=/usr/bin", "DEBUG=1", "LANG=C.UTF-8"},
+		},
+		{
+			name:      "bare key unsets",
+			imageEnv:  []string{"PATH=/usr/bin", "SECRET=x"},
+			overrides: []string{"SECRET", "MISSING"},
+			expected:  []string{"PATH=/usr/bin"},
+		},
+		{
+			name:      "empty value is kept",
+			imageEnv:  []string{"PATH=/usr/bin"},
+			overrides: []string{"EMPTY="},
+			expected:  []string{"PATH=/usr/bin", "EMPTY="},
+		},
+		{
+			name:      "value containing equals",
+			imageEnv:  []string{"OPTS=a=b"},
+			overrides: []string{"OPTS=c=d"},
+			expected:  []string{"OPTS=c=d"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			original := append([]string(nil), tc.imageEnv...)
+
+			merged := MergeEnv(tc.imageEnv, tc.overrides)
+			if !reflect.DeepEqual(merged, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, merged)
+			}
+
+			if !reflect.DeepEqual(tc.imageEnv, original) {
+				t.Errorf("Image env was modified: %v", tc.imageEnv)
+			}
+		})
+	}
+}
+
+func TestResolveCommand(t *testing.T) {
+	testCases := []struct {
+		name     string
+		config   *ImageConfig
+		args     []string
+		expected []string
+	}{
+		{
+			name:     "cmd only",
+			config:   &ImageConfig{Cmd: []string{"/bin/sh"}},
+			expected: []string{"/bin/sh"},
+		},
+		{
+			name:     "args replace cmd",
+			config:   &ImageConfig{Cmd: []string{"/bin/sh"}},
+			args:     []string{"echo", "hi"},
+			expected: []string{"echo", "hi"},
+		},
+		{
+			name:     "entrypoint and cmd",
+			config:   &ImageConfig{Entrypoint: []string{"/docker-entrypoint.sh"}, Cmd: []string{"nginx", "-g", "daemon off;"}},
+			expected: []string{"/docker-entrypoint.sh", "nginx", "-g", "daemon off;"},
+		},
+		{
+			name:     "args appended to entrypoint",
+			config:   &ImageConfig{Entrypoint: []string{"/docker-entrypoint.sh"}, Cmd: []string{"nginx"}},
+			args:     []string{"sh"},
+			expected: []string{"/docker-entrypoint.sh", "sh"},
+		},
+		{
+			name:     "nil config with args",
+			args:     []string{"true"},
+			expected: []string{"true"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			argv, err := ResolveCommand(tc.config, tc.args)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if !reflect.DeepEqual(argv, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, argv)
+			}
+		})
+	}
+}
+
+func TestResolveCommand_NoCommand(t *testing.T) {
+	_, err := ResolveCommand(&ImageConfig{}, nil)
+	if err == nil {
+		t.Fatal("Expected error when no command is specified")
+	}
+}
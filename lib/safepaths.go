@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ErrUnsafePath is returned by exports with StrictPaths set when a layer entry
+// would be written outside the root of the filesystem: a name or hardlink
+// target that is absolute or climbs above the root with "..", or a symlink
+// whose relative target climbs above the root
+var ErrUnsafePath = errors.New("unsafe path")
+
+// rootedPath resolves the "." and ".." components of a path lexically, below
+// the root of the filesystem, keeping the trailing slash of directories. It
+// reports whether the path was absolute or climbed above the root.
+func rootedPath(p string) (string, bool) {
+	unsafe := strings.HasPrefix(p, "/")
+	var parts []string
+	for _, part := range strings.Split(p, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if len(parts) == 0 {
+				unsafe = true
+			} else {
+				parts = parts[:len(parts)-1]
+			}
+		default:
+			parts = append(parts, part)
+		}
+	}
+	rooted := strings.Join(parts, "/")
+	if rooted == "" {
+		rooted = "."
+	}
+	if strings.HasSuffix(p, "/") {
+		rooted += "/"
+	}
+	return rooted, unsafe
+}
+
+// checkEntryPath keeps a layer entry below the root of the filesystem. Names
+// and hardlink targets leaving it are rewritten with a WarningUnsafePath
+// warning, or fail with ErrUnsafePath when opts.StrictPaths is set. Symlinks
+// are not rewritten, since a runtime resolves them within the root; with
+// StrictPaths, relative targets climbing above the root fail the export.
+func (e *imageExporter) checkEntryPath(header *tar.Header, index int, opts *ExportOptions) error {
+	unsafe := func(what, value string) error {
+		if opts.StrictPaths {
+			return fmt.Errorf("%s %q in layer %d leaves the root of the filesystem: %w", what, value, index, ErrUnsafePath)
+		}
+		e.warn(opts, Warning{
+			Code:    WarningUnsafePath,
+			Message: fmt.Sprintf("%s %q in layer %d leaves the root of the filesystem and was rewritten", what, value, index),
+			Path:    header.Name,
+		})
+		return nil
+	}
+
+	// Leading slashes are common and harmless, only ".." escapes the root
+	if name, escapes := rootedPath(strings.TrimLeft(header.Name, "/")); escapes || (opts.StrictPaths && strings.HasPrefix(header.Name, "/")) {
+		if err := unsafe("entry", header.Name); err != nil {
+			return err
+		}
+		header.Name = name
+	}
+
+	switch header.Typeflag {
+	case tar.TypeLink:
+		if target, unsafeTarget := rootedPath(header.Linkname); unsafeTarget {
+			if err := unsafe("hardlink target", header.Linkname); err != nil {
+				return err
+			}
+			header.Linkname = target
+		}
+	case tar.TypeSymlink:
+		if opts.StrictPaths && !strings.HasPrefix(header.Linkname, "/") {
+			if _, escapes := rootedPath(path.Dir(strings.TrimSuffix(header.Name, "/")) + "/" + header.Linkname); escapes {
+				return unsafe("symlink target", header.Linkname)
+			}
+		}
+	}
+	return nil
+}
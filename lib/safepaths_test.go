@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestRootedPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+		unsafe   bool
+	}{
+		{"etc/passwd", "etc/passwd", false},
+		{"./usr/bin/", "usr/bin/", false},
+		{"a/../b", "b", false},
+		{"../etc/passwd", "etc/passwd", true},
+		{"a/../../etc/", "etc/", true},
+		{"/etc/shadow", "etc/shadow", true},
+		{"..", ".", true},
+	}
+	for _, tt := range tests {
+		rooted, unsafe := rootedPath(tt.path)
+		if rooted != tt.expected || unsafe != tt.unsafe {
+			t.Errorf("Expected %q to give %q (unsafe %v), got %q (unsafe %v)", tt.path, tt.expected, tt.unsafe, rooted, unsafe)
+		}
+	}
+}
+
+func TestExportUnsafePaths(t *testing.T) {
+	host := newTestRegistry(t)
+	push := func(name string, entries ...testEntry) string {
+		image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, entries...))
+		if err != nil {
+			t.Fatalf("Failed to build test image: %v", err)
+		}
+		imageRef := host + "/test/" + name + ":latest"
+		pushTestImage(t, imageRef, image)
+		return imageRef
+	}
+	escaping := push("escaping",
+		testEntry{name: "data", content: "data"},
+		testEntry{name: "../../etc/evil", content: "evil"},
+		testEntry{name: "ok/../../x", content: "x"},
+		testEntry{name: "h", typeflag: tar.TypeLink, linkname: "/data"},
+	)
+	symlink := push("symlink", testEntry{name: "s", typeflag: tar.TypeSymlink, linkname: "../../../etc"})
+	absolute := push("absolute", testEntry{name: "usr/bin/awk", typeflag: tar.TypeSymlink, linkname: "/etc/alternatives/awk"})
+
+	exporter := NewImageExporter()
+	for _, literal := range []bool{false, true} {
+		var output bytes.Buffer
+		var warnings []Warning
+		opts := &ExportOptions{LiteralPaths: literal, Warning: func(w Warning) { warnings = append(warnings, w) }}
+		if err := exporter.ExportImageFilesystemToWriterWithOptions(escaping, &output, nil, opts); err != nil {
+			t.Fatalf("Expected unsafe paths to be rewritten, got %v", err)
+		}
+		if count := warningCodes(warnings)[WarningUnsafePath]; count != 3 {
+			t.Errorf("Expected 3 unsafe path warnings, got %d", count)
+		}
+		names := make(map[string]*tar.Header)
+		reader := tar.NewReader(&output)
+		for {
+			header, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Failed to read archive: %v", err)
+			}
+			if strings.HasPrefix(header.Name, "/") || strings.Contains("/"+header.Name, "/../") {
+				t.Errorf("Expected names below the root, got %s", header.Name)
+			}
+			names[header.Name] = header
+		}
+		if names["etc/evil"] == nil || names["x"] == nil || names["h"] == nil || names["h"].Linkname != "data" {
+			t.Errorf("Expected rewritten etc/evil, x and h linking to data (literal paths %v), got %v", literal, names)
+		}
+	}
+
+	strict := &ExportOptions{StrictPaths: true}
+	for _, imageRef := range []string{escaping, symlink} {
+		if err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, io.Discard, nil, strict); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("Expected ErrUnsafePath for %s, got %v", imageRef, err)
+		}
+	}
+	if err := exporter.ExportImageFilesystemToWriterWithOptions(absolute, io.Discard, nil, strict); err != nil {
+		t.Errorf("Expected absolute symlinks to resolve within the root, got %v", err)
+	}
+	if err := exporter.ExportImageFilesystemToWriterWithOptions(symlink, io.Discard, nil, nil); err != nil {
+		t.Errorf("Expected escaping symlinks to be kept without StrictPaths, got %v", err)
+	}
+}
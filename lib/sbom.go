@@ -0,0 +1,256 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+// SBOM formats, see SBOM.Format
+const (
+	SBOMFormatSPDX      = "spdx"
+	SBOMFormatCycloneDX = "cyclonedx"
+	SBOMFormatSyft      = "syft"
+)
+
+// SBOMOptions configures SBOMs
+type SBOMOptions struct {
+	// Platform selects the image of a multi-platform index whose SBOMs are
+	// listed with those of the index, e.g. "linux/arm64"; empty for linux/amd64
+	Platform string
+
+	// Format only lists SBOMs of this format (SBOMFormatSPDX,
+	// SBOMFormatCycloneDX or SBOMFormatSyft); empty lists all of them
+	Format string
+}
+
+// SBOMList lists the SBOMs attached to an image. It is returned by the SBOMs
+// method.
+type SBOMList struct {
+	// SchemaVersion is the version of this JSON document (see SchemaVersion)
+	SchemaVersion int `json:"schema_version"`
+
+	// Reference is the fully-qualified reference that was queried
+	Reference string `json:"reference"`
+
+	// Digest is the manifest digest the reference resolved to
+	Digest string `json:"digest"`
+
+	// SBOMs lists the attached SBOMs: those of the index first, then those of
+	// the selected platform
+	SBOMs []SBOM `json:"sboms"`
+}
+
+// SBOM is one software bill of materials attached to an image, read with
+// ReadSBOM
+type SBOM struct {
+	// Format is SBOMFormatSPDX, SBOMFormatCycloneDX or SBOMFormatSyft
+	Format string `json:"format"`
+
+	// Source is how the SBOM is attached: SourceReferrer (OCI referrers),
+	// SourceCosignAttachment ('cosign attach sbom'), SourceCosignAttestation
+	// ('cosign attest') or SourceBuildKitAttestation ('docker buildx build --sbom')
+	Source string `json:"source"`
+
+	// Subject is the manifest digest the SBOM is attached to: the index, or
+	// the image of the selected platform
+	Subject string `json:"subject"`
+
+	// Manifest is the digest of the artifact manifest holding the SBOM
+	Manifest string `json:"manifest"`
+
+	// Digest is the digest of the blob holding the SBOM
+	Digest string `json:"digest"`
+
+	// MediaType is the media type of the blob: an SBOM document, or an in-toto
+	// statement, possibly in a DSSE envelope, whose predicate is the SBOM
+	MediaType string `json:"media_type"`
+
+	// PredicateType is the in-toto predicate type of attestations, such as
+	// "https://spdx.dev/Document"
+	PredicateType string `json:"predicate_type,omitempty"`
+
+	// Size is the size of the blob in bytes
+	Size int64 `json:"size"`
+}
+
+// sbomFormat returns the SBOM format of a document media type, or of the
+// predicate type of an attestation, empty if it is not an SBOM
+func sbomFormat(mediaType, predicateType string) string {
+	if isAttestationLayer(mediaType) {
+		switch {
+		case strings.HasPrefix(predicateType, "https://spdx.dev/Document"):
+			return SBOMFormatSPDX
+		case strings.HasPrefix(predicateType, "https://cyclonedx.org/bom"):
+			return SBOMFormatCycloneDX
+		}
+		return ""
+	}
+	mediaType, _, _ = strings.Cut(mediaType, ";")
+	switch {
+	case strings.HasPrefix(mediaType, "application/spdx"), strings.HasPrefix(mediaType, "text/spdx"):
+		return SBOMFormatSPDX
+	case strings.HasPrefix(mediaType, "application/vnd.cyclonedx"):
+		return SBOMFormatCycloneDX
+	case strings.HasPrefix(mediaType, "application/vnd.syft"):
+		return SBOMFormatSyft
+	}
+	return ""
+}
+
+// SBOMs lists the SBOMs attached to an image, wherever the tool that produced
+// them stored them: as OCI referrers, with 'cosign attach sbom' or 'cosign
+// attest' under the sha256-<hex>.sbom and .att tags, or as BuildKit
+// attestation manifests in the index. SBOMs in attestations are recognized by
+// the predicate type annotation of their layer.
+//
+// SBOMs are attached to one manifest digest: both those of a multi-platform
+// index and those of the image of opts.Platform are listed.
+//
+// Parameters:
+//   - imageRef: Image reference (e.g., "ghcr.io/org/app:v1")
+//   - auth: Optional authentication configuration for private registries
+//   - opts: Optional platform and format filter
+//
+// Returns:
+//   - *SBOMList: The attached SBOMs
+//   - error: Any error encountered during the operation
+//
+// Example:
+//
+//	list, err := exporter.SBOMs("ghcr.io/org/app:v1", nil, &SBOMOptions{Format: SBOMFormatSPDX})
+//	if err != nil || len(list.SBOMs) == 0 {
+//	    log.Fatal("no SPDX SBOM")
+//	}
+//	document, err := exporter.ReadSBOM("ghcr.io/org/app:v1", list.SBOMs[0], nil, true)
+func (e *imageExporter) SBOMs(imageRef string, auth *AuthConfig, opts *SBOMOptions) (*SBOMList, error) {
+	if opts == nil {
+		opts = &SBOMOptions{}
+	}
+	switch opts.Format {
+	case "", SBOMFormatSPDX, SBOMFormatCycloneDX, SBOMFormatSyft:
+	default:
+		return nil, fmt.Errorf("invalid SBOM format %q (must be spdx, cyclonedx or syft)", opts.Format)
+	}
+	ref, err := e.parseReference(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image reference: %w", err)
+	}
+	subjects, index, err := e.attachmentSubjects(ref, opts.Platform, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &SBOMList{
+		SchemaVersion: SchemaVersion,
+		Reference:     ref.Name(),
+		Digest:        subjects[0].String(),
+		SBOMs:         []SBOM{},
+	}
+	for _, subject := range subjects {
+		layers, err := e.attachedLayers(ref.Context(), subject, index, auth)
+		if err != nil {
+			return nil, err
+		}
+		for _, layer := range layers {
+			format := sbomFormat(string(layer.layer.MediaType), layer.predicateType())
+			if format == "" || (opts.Format != "" && format != opts.Format) {
+				continue
+			}
+			sbom := SBOM{
+				Format:    format,
+				Source:    layer.source,
+				Subject:   subject.String(),
+				Manifest:  layer.manifest.DigestStr(),
+				Digest:    layer.layer.Digest.String(),
+				MediaType: string(layer.layer.MediaType),
+				Size:      layer.layer.Size,
+			}
+			if isAttestationLayer(sbom.MediaType) {
+				sbom.PredicateType = layer.predicateType()
+			}
+			list.SBOMs = append(list.SBOMs, sbom)
+		}
+	}
+	return list, nil
+}
+
+// attachmentSubjects returns the manifest digest ref resolves to and, for an
+// index, the digest of the image of platform (linux/amd64 when empty) along
+// with the index
+func (e *imageExporter) attachmentSubjects(ref name.Reference, platformName string, auth *AuthConfig) ([]v1.Hash, *v1.IndexManifest, error) {
+	platform := &v1.Platform{OS: "linux", Architecture: "amd64"}
+	if platformName != "" {
+		var err error
+		if platform, err = v1.ParsePlatform(platformName); err != nil {
+			return nil, nil, fmt.Errorf("invalid platform %q: %w", platformName, err)
+		}
+	}
+	desc, err := e.getManifest(ref, auth)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch image %s: %w", ref, err)
+	}
+	subjects := []v1.Hash{desc.Digest}
+	if !desc.MediaType.IsIndex() {
+		return subjects, nil, nil
+	}
+	index, err := desc.ImageIndex()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read index %s: %w", ref, err)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read index %s: %w", ref, err)
+	}
+	for _, child := range manifest.Manifests {
+		if child.MediaType.IsImage() && platformMatches(child.Platform, *platform) {
+			return append(subjects, child.Digest), manifest, nil
+		}
+	}
+	if platformName != "" {
+		return nil, nil, fmt.Errorf("no child with platform %s in index %s", platformName, ref)
+	}
+	return subjects, manifest, nil
+}
+
+// ReadSBOM returns the content of an SBOM listed by SBOMs: the document, or
+// the attestation it is attached as.
+//
+// Parameters:
+//   - imageRef: The image reference passed to SBOMs
+//   - sbom: The SBOM to read
+//   - auth: Optional authentication configuration for private registries
+//   - predicate: Return the SBOM document of an attestation, the predicate of
+//     its in-toto statement, instead of the statement or DSSE envelope
+//
+// Returns:
+//   - []byte: The content
+//   - error: Any error encountered during the operation
+func (e *imageExporter) ReadSBOM(imageRef string, sbom SBOM, auth *AuthConfig, predicate bool) ([]byte, error) {
+	ref, err := e.parseReference(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image reference: %w", err)
+	}
+	digest, err := v1.NewHash(sbom.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SBOM digest %s: %w", sbom.Digest, err)
+	}
+	layer := attachedLayer{
+		manifest: ref.Context().Digest(sbom.Manifest),
+		layer:    v1.Descriptor{Digest: digest, Size: sbom.Size},
+	}
+	data, err := e.readAttachedLayer(layer, auth)
+	if err != nil {
+		return nil, err
+	}
+	if !predicate || !isAttestationLayer(sbom.MediaType) {
+		return data, nil
+	}
+	statement, err := parseStatement(sbom.MediaType, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SBOM %s: %w", sbom.Digest, err)
+	}
+	return statement.Predicate, nil
+}
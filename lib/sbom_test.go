@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// newTestStatement returns an in-toto statement about subject
+func newTestStatement(t *testing.T, subject v1.Hash, predicateType string, predicate any) []byte {
+	t.Helper()
+	statement, err := json.Marshal(map[string]any{
+		"_type":         "https://in-toto.io/Statement/v0.1",
+		"subject":       []any{map[string]any{"name": "test/app", "digest": map[string]string{subject.Algorithm: subject.Hex}}},
+		"predicateType": predicateType,
+		"predicate":     predicate,
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal test statement: %v", err)
+	}
+	return statement
+}
+
+// newTestDSSE wraps a statement in a DSSE envelope, as 'cosign attest' does
+func newTestDSSE(statement []byte) []byte {
+	envelope, _ := json.Marshal(map[string]any{
+		"payloadType": inTotoMediaType,
+		"payload":     base64.StdEncoding.EncodeToString(statement),
+		"signatures":  []any{map[string]string{"keyid": "", "sig": "MEUCIQ=="}},
+	})
+	return envelope
+}
+
+// pushTestAttestations builds an index with a linux/amd64 image and a
+// BuildKit attestation manifest holding statement, pushes it as ref and
+// returns the digests of the index and the image
+func pushTestAttestations(t *testing.T, ref string, predicateType string, statement func(image v1.Hash) []byte) (v1.Hash, v1.Hash) {
+	t.Helper()
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "file", content: "data"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	imageDigest, _ := image.Digest()
+	attestation, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       static.NewLayer(statement(imageDigest), inTotoMediaType),
+		Annotations: map[string]string{"in-toto.io/predicate-type": predicateType},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build test attestation: %v", err)
+	}
+	index := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: image, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+		mutate.IndexAddendum{Add: attestation, Descriptor: v1.Descriptor{
+			Platform: &v1.Platform{OS: "unknown", Architecture: "unknown"},
+			Annotations: map[string]string{
+				annotationReferenceType:   "attestation-manifest",
+				annotationReferenceDigest: imageDigest.String(),
+			},
+		}},
+	)
+	tag, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatalf("Failed to parse test reference %s: %v", ref, err)
+	}
+	if err := remote.WriteIndex(tag, index); err != nil {
+		t.Fatalf("Failed to push test index: %v", err)
+	}
+	indexDigest, _ := index.Digest()
+	return indexDigest, imageDigest
+}
+
+func TestSBOMs(t *testing.T) {
+	host := newTestRegistry(t)
+	imageRef := host + "/test/app:v1"
+	spdx := map[string]any{"spdxVersion": "SPDX-2.3", "name": "app"}
+	indexDigest, imageDigest := pushTestAttestations(t, imageRef, "https://spdx.dev/Document", func(image v1.Hash) []byte {
+		return newTestStatement(t, image, "https://spdx.dev/Document", spdx)
+	})
+
+	// An SPDX document attached to the image, and a signature that is no SBOM
+	document := []byte(`{"spdxVersion": "SPDX-2.3", "name": "referrer"}`)
+	pushTestReferrer(t, host+"/test/app@"+imageDigest.String(), "application/spdx+json", "application/spdx+json", document, nil)
+	pushTestReferrer(t, host+"/test/app@"+imageDigest.String(), "application/vnd.dev.sigstore.bundle.v0.3+json", "application/vnd.dev.sigstore.bundle.v0.3+json", []byte(`{}`), nil)
+
+	// A CycloneDX attestation of the index by 'cosign attest'
+	cyclonedx := map[string]any{"bomFormat": "CycloneDX", "specVersion": "1.5"}
+	envelope := newTestDSSE(newTestStatement(t, indexDigest, "https://cyclonedx.org/bom", cyclonedx))
+	attestation, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       static.NewLayer(envelope, types.MediaType(dsseMediaType)),
+		Annotations: map[string]string{"predicateType": "https://cyclonedx.org/bom"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build test attestation: %v", err)
+	}
+	pushTestImage(t, host+"/test/app:sha256-"+indexDigest.Hex+".att", attestation)
+
+	exporter := NewImageExporter()
+	list, err := exporter.SBOMs(imageRef, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if list.Digest != indexDigest.String() {
+		t.Errorf("Expected digest %s, got %s", indexDigest, list.Digest)
+	}
+	want := []struct{ format, source, subject string }{
+		{SBOMFormatCycloneDX, SourceCosignAttestation, indexDigest.String()},
+		{SBOMFormatSPDX, SourceReferrer, imageDigest.String()},
+		{SBOMFormatSPDX, SourceBuildKitAttestation, imageDigest.String()},
+	}
+	if len(list.SBOMs) != len(want) {
+		t.Fatalf("Expected %d SBOMs, got %+v", len(want), list.SBOMs)
+	}
+	for i, w := range want {
+		got := list.SBOMs[i]
+		if got.Format != w.format || got.Source != w.source || got.Subject != w.subject {
+			t.Errorf("Expected SBOM %d to be %+v, got %+v", i, w, got)
+		}
+	}
+
+	content, err := exporter.ReadSBOM(imageRef, list.SBOMs[1], nil, true)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(content) != string(document) {
+		t.Errorf("Expected the referrer document, got %s", content)
+	}
+	content, err = exporter.ReadSBOM(imageRef, list.SBOMs[0], nil, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(content) != string(envelope) {
+		t.Errorf("Expected the DSSE envelope, got %s", content)
+	}
+	for i, predicate := range map[int]map[string]any{0: cyclonedx, 2: spdx} {
+		content, err := exporter.ReadSBOM(imageRef, list.SBOMs[i], nil, true)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		var got map[string]any
+		if err := json.Unmarshal(content, &got); err != nil {
+			t.Fatalf("Expected a JSON predicate, got %s", content)
+		}
+		for key, value := range predicate {
+			if got[key] != value {
+				t.Errorf("Expected predicate %v, got %s", predicate, content)
+			}
+		}
+	}
+
+	filtered, err := exporter.SBOMs(imageRef, nil, &SBOMOptions{Format: SBOMFormatCycloneDX})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(filtered.SBOMs) != 1 || filtered.SBOMs[0].Format != SBOMFormatCycloneDX {
+		t.Errorf("Expected only the CycloneDX SBOM, got %+v", filtered.SBOMs)
+	}
+	if _, err := exporter.SBOMs(imageRef, nil, &SBOMOptions{Platform: "linux/arm64"}); err == nil {
+		t.Error("Expected error for a missing platform, got nil")
+	}
+}
@@ -0,0 +1,157 @@
+package lib
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Encode converts the inventory to an SBOM document: SPDX 2.3 JSON
+// (SBOMFormatSPDX) or CycloneDX 1.5 JSON (SBOMFormatCycloneDX). The image is
+// the root of the document, containing every package.
+//
+// Parameters:
+//   - format: SBOMFormatSPDX or SBOMFormatCycloneDX
+//
+// Returns:
+//   - []byte: The indented JSON document
+//   - error: An error if the format is not supported
+//
+// Example:
+//
+//	inventory, err := exporter.GenerateSBOM("debian:bookworm", nil, nil)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	document, err := inventory.Encode(SBOMFormatCycloneDX)
+func (i *PackageInventory) Encode(format string) ([]byte, error) {
+	created := time.Now().UTC().Format(time.RFC3339)
+	serial, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	var document any
+	switch format {
+	case SBOMFormatSPDX:
+		document = i.spdx(created, serial)
+	case SBOMFormatCycloneDX:
+		document = i.cycloneDX(created, serial)
+	default:
+		return nil, fmt.Errorf("unsupported SBOM format %q: must be %s or %s", format, SBOMFormatSPDX, SBOMFormatCycloneDX)
+	}
+	data, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode SBOM: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// spdx builds an SPDX 2.3 document. Licenses are recorded as comments, as
+// package databases do not always use SPDX license expressions.
+func (i *PackageInventory) spdx(created, serial string) map[string]any {
+	packages := []any{map[string]any{
+		"name":                  i.Reference,
+		"SPDXID":                "SPDXRef-Image",
+		"versionInfo":           i.Digest,
+		"downloadLocation":      "NOASSERTION",
+		"filesAnalyzed":         false,
+		"primaryPackagePurpose": "CONTAINER",
+	}}
+	relationships := []any{map[string]any{
+		"spdxElementId":      "SPDXRef-DOCUMENT",
+		"relationshipType":   "DESCRIBES",
+		"relatedSpdxElement": "SPDXRef-Image",
+	}}
+	for n, pkg := range i.Packages {
+		id := "SPDXRef-Package-" + strconv.Itoa(n+1)
+		spdxPackage := map[string]any{
+			"name":             pkg.Name,
+			"SPDXID":           id,
+			"versionInfo":      pkg.Version,
+			"downloadLocation": "NOASSERTION",
+			"filesAnalyzed":    false,
+			"licenseConcluded": "NOASSERTION",
+			"licenseDeclared":  "NOASSERTION",
+			"sourceInfo":       "found in " + pkg.Location,
+			"externalRefs": []any{map[string]any{
+				"referenceCategory": "PACKAGE-MANAGER",
+				"referenceType":     "purl",
+				"referenceLocator":  pkg.PURL,
+			}},
+		}
+		if pkg.License != "" {
+			spdxPackage["comment"] = "License: " + pkg.License
+		}
+		packages = append(packages, spdxPackage)
+		relationships = append(relationships, map[string]any{
+			"spdxElementId":      "SPDXRef-Image",
+			"relationshipType":   "CONTAINS",
+			"relatedSpdxElement": id,
+		})
+	}
+	return map[string]any{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              i.Reference,
+		"documentNamespace": "https://github.com/kenichi/imgex/spdx/" + serial,
+		"creationInfo": map[string]any{
+			"created":  created,
+			"creators": []string{"Tool: imgex-" + Version},
+		},
+		"packages":      packages,
+		"relationships": relationships,
+	}
+}
+
+// cycloneDX builds a CycloneDX 1.5 document
+func (i *PackageInventory) cycloneDX(created, serial string) map[string]any {
+	components := []any{}
+	for n, pkg := range i.Packages {
+		component := map[string]any{
+			"type":       "library",
+			"bom-ref":    "package-" + strconv.Itoa(n+1),
+			"name":       pkg.Name,
+			"version":    pkg.Version,
+			"purl":       pkg.PURL,
+			"properties": []any{map[string]string{"name": "imgex:location", "value": pkg.Location}},
+		}
+		if pkg.License != "" {
+			component["licenses"] = []any{map[string]any{"license": map[string]string{"name": pkg.License}}}
+		}
+		components = append(components, component)
+	}
+	return map[string]any{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.5",
+		"serialNumber": "urn:uuid:" + serial,
+		"version":      1,
+		"metadata": map[string]any{
+			"timestamp": created,
+			"tools": map[string]any{
+				"components": []any{map[string]string{"type": "application", "name": "imgex", "version": Version}},
+			},
+			"component": map[string]any{
+				"type":    "container",
+				"bom-ref": i.Digest,
+				"name":    i.Reference,
+				"version": i.Digest,
+			},
+		},
+		"components": components,
+	}
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate UUID: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"embed"
+	"fmt"
+	"sort"
+)
+
+// SchemaVersion is the version of every JSON document imgex produces, reported
+// in their schema_version field.
+//
+// Compatibility policy: within a schema version, fields are only ever added.
+// Removing or renaming a field, changing its type, or changing the meaning of a
+// value increments SchemaVersion for all documents at once. Consumers should
+// ignore fields they do not know and check schema_version before relying on
+// the ones they do.
+const SchemaVersion = 1
+
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// schemaNames maps each JSON document to its embedded schema file
+var schemaNames = map[string]string{
+	"config":          "schemas/config.json",
+	"verify-report":   "schemas/verify-report.json",
+	"retention-plan":  "schemas/retention-plan.json",
+	"build-info":      "schemas/build-info.json",
+	"start-report":    "schemas/start-report.json",
+	"lockfile":        "schemas/lockfile.json",
+	"lock-report":     "schemas/lock-report.json",
+	"tag-history":     "schemas/tag-history.json",
+	"referrers":       "schemas/referrers.json",
+	"sbom":            "schemas/sbom.json",
+	"provenance":      "schemas/provenance.json",
+	"packages":        "schemas/packages.json",
+	"secret-report":   "schemas/secret-report.json",
+	"audit-report":    "schemas/audit-report.json",
+	"path-blame":      "schemas/path-blame.json",
+	"disk-usage":      "schemas/disk-usage.json",
+	"export-estimate": "schemas/export-estimate.json",
+	"export-stats":    "schemas/export-stats.json",
+	"result":          "schemas/result.json",
+}
+
+// SchemaNames returns the names of the available JSON Schemas, sorted
+func SchemaNames() []string {
+	names := make([]string, 0, len(schemaNames))
+	for name := range schemaNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// JSONSchema returns the JSON Schema (draft 2020-12) describing a JSON document.
+//
+// Parameters:
+//   - name: Document name: "config", "verify-report", "retention-plan", "build-info",
+//     "start-report", "lockfile", "lock-report", "tag-history", "referrers", "sbom",
+//     "provenance", "packages", "secret-report", "audit-report", "path-blame",
+//     "disk-usage", "export-estimate", "export-stats" or "result"
+//
+// Returns:
+//   - []byte: The schema document
+//   - error: If no schema has that name
+func JSONSchema(name string) ([]byte, error) {
+	file, ok := schemaNames[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema %q (available: %v)", name, SchemaNames())
+	}
+	return schemaFiles.ReadFile(file)
+}
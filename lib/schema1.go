@@ -0,0 +1,195 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// schema1Manifest is a Docker image manifest of schema version 1, still served
+// by some legacy registries. Layers and history are listed newest first, and
+// the image configuration is embedded in the newest history entry.
+type schema1Manifest struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Architecture  string `json:"architecture"`
+	FSLayers      []struct {
+		BlobSum v1.Hash `json:"blobSum"`
+	} `json:"fsLayers"`
+	History []struct {
+		V1Compatibility string `json:"v1Compatibility"`
+	} `json:"history"`
+}
+
+// schema1History is the v1Compatibility document of a schema1 history entry
+type schema1History struct {
+	Created         time.Time  `json:"created"`
+	Author          string     `json:"author,omitempty"`
+	Comment         string     `json:"comment,omitempty"`
+	DockerVersion   string     `json:"docker_version,omitempty"`
+	OS              string     `json:"os,omitempty"`
+	Architecture    string     `json:"architecture,omitempty"`
+	Variant         string     `json:"variant,omitempty"`
+	Config          *v1.Config `json:"config,omitempty"`
+	ContainerConfig struct {
+		Cmd []string `json:"Cmd"`
+	} `json:"container_config"`
+	ThrowAway bool `json:"throwaway,omitempty"`
+}
+
+// isSchema1 reports whether a manifest media type is Docker schema1
+func isSchema1(mediaType types.MediaType) bool {
+	return mediaType == types.DockerManifestSchema1 || mediaType == types.DockerManifestSchema1Signed
+}
+
+// convertSchema1 converts a schema1 manifest into an equivalent schema2
+// manifest and image configuration, the way the Docker Engine does when it
+// pulls one. Empty layers marked as throwaway become history entries only.
+// The diff IDs are left out of the configuration, since computing them needs
+// every layer to be downloaded; layers are still addressed by blob digest.
+// layerSize returns the size of a layer blob, which schema1 does not record.
+func convertSchema1(raw []byte, layerSize func(v1.Hash) (int64, error)) (manifest, config []byte, err error) {
+	var source schema1Manifest
+	if err := json.Unmarshal(raw, &source); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse schema1 manifest: %w", err)
+	}
+	if source.SchemaVersion != 1 || len(source.FSLayers) == 0 || len(source.FSLayers) != len(source.History) {
+		return nil, nil, fmt.Errorf("invalid schema1 manifest: %d layers, %d history entries", len(source.FSLayers), len(source.History))
+	}
+
+	configFile := v1.ConfigFile{
+		Architecture: source.Architecture,
+		OS:           "linux",
+		RootFS:       v1.RootFS{Type: "layers"},
+	}
+	out := v1.Manifest{
+		SchemaVersion: 2,
+		MediaType:     types.DockerManifestSchema2,
+	}
+	sizes := make(map[v1.Hash]int64)
+	for i := len(source.FSLayers) - 1; i >= 0; i-- {
+		var entry schema1History
+		if err := json.Unmarshal([]byte(source.History[i].V1Compatibility), &entry); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse schema1 history %d: %w", i, err)
+		}
+		if i == 0 {
+			// The newest entry holds the configuration of the image itself
+			configFile.Created = v1.Time{Time: entry.Created}
+			configFile.Author = entry.Author
+			configFile.DockerVersion = entry.DockerVersion
+			if entry.OS != "" {
+				configFile.OS = entry.OS
+			}
+			if entry.Architecture != "" {
+				configFile.Architecture = entry.Architecture
+			}
+			configFile.Variant = entry.Variant
+			if entry.Config != nil {
+				configFile.Config = *entry.Config
+			}
+		}
+		configFile.History = append(configFile.History, v1.History{
+			Created:    v1.Time{Time: entry.Created},
+			CreatedBy:  strings.Join(entry.ContainerConfig.Cmd, " "),
+			Author:     entry.Author,
+			Comment:    entry.Comment,
+			EmptyLayer: entry.ThrowAway,
+		})
+		if entry.ThrowAway {
+			continue
+		}
+
+		digest := source.FSLayers[i].BlobSum
+		size, ok := sizes[digest]
+		if !ok {
+			if size, err = layerSize(digest); err != nil {
+				return nil, nil, fmt.Errorf("failed to get size of layer %s: %w", digest, err)
+			}
+			sizes[digest] = size
+		}
+		out.Layers = append(out.Layers, v1.Descriptor{
+			MediaType: types.DockerLayer,
+			Size:      size,
+			Digest:    digest,
+		})
+	}
+
+	if config, err = json.Marshal(configFile); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode converted config: %w", err)
+	}
+	configDigest, configSize, err := v1.SHA256(bytes.NewReader(config))
+	if err != nil {
+		return nil, nil, err
+	}
+	out.Config = v1.Descriptor{
+		MediaType: types.DockerConfigJSON,
+		Size:      configSize,
+		Digest:    configDigest,
+	}
+	if manifest, err = json.Marshal(out); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode converted manifest: %w", err)
+	}
+	return manifest, config, nil
+}
+
+// schema1Image is a schema1 image converted to schema2, whose layers are
+// read from the original source
+type schema1Image struct {
+	manifest []byte
+	config   []byte
+	layer    func(v1.Hash) (partial.CompressedLayer, error)
+}
+
+// newSchema1Image converts a schema1 manifest into an image. layer returns the
+// blob of a layer by digest, and its size is read from it.
+func newSchema1Image(raw []byte, layer func(v1.Hash) (partial.CompressedLayer, error)) (v1.Image, error) {
+	manifest, config, err := convertSchema1(raw, func(digest v1.Hash) (int64, error) {
+		blob, err := layer(digest)
+		if err != nil {
+			return 0, err
+		}
+		return blob.Size()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return partial.CompressedToImage(&schema1Image{manifest: manifest, config: config, layer: layer})
+}
+
+// remoteSchema1Image converts the schema1 manifest of desc, reading layers
+// from its repository
+func remoteSchema1Image(desc *remote.Descriptor) (v1.Image, error) {
+	source, err := desc.Schema1()
+	if err != nil {
+		return nil, err
+	}
+	return newSchema1Image(desc.Manifest, func(digest v1.Hash) (partial.CompressedLayer, error) {
+		return source.LayerByDigest(digest)
+	})
+}
+
+// RawConfigFile implements partial.CompressedImageCore
+func (i *schema1Image) RawConfigFile() ([]byte, error) {
+	return i.config, nil
+}
+
+// MediaType implements partial.CompressedImageCore
+func (i *schema1Image) MediaType() (types.MediaType, error) {
+	return types.DockerManifestSchema2, nil
+}
+
+// RawManifest implements partial.CompressedImageCore
+func (i *schema1Image) RawManifest() ([]byte, error) {
+	return i.manifest, nil
+}
+
+// LayerByDigest implements partial.CompressedImageCore
+func (i *schema1Image) LayerByDigest(digest v1.Hash) (partial.CompressedLayer, error) {
+	return i.layer(digest)
+}
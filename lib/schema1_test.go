@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// rawManifest is a manifest pushed as it is
+type rawManifest struct {
+	raw       []byte
+	mediaType types.MediaType
+}
+
+// RawManifest implements remote.Taggable
+func (m rawManifest) RawManifest() ([]byte, error) {
+	return m.raw, nil
+}
+
+// MediaType sets the Content-Type of the upload
+func (m rawManifest) MediaType() (types.MediaType, error) {
+	return m.mediaType, nil
+}
+
+func TestSchema1Manifest(t *testing.T) {
+	imageRef := newTestRegistry(t) + "/legacy/app:v1"
+	ref, _ := name.ParseReference(imageRef)
+	base := newTestLayer(t, testEntry{name: "etc/os-release", content: "base"}, testEntry{name: "etc/old", content: "old"})
+	app := newTestLayer(t, testEntry{name: "app/run", content: "app"}, testEntry{name: "etc/.wh.old"})
+	if err := remote.WriteLayer(ref.Context(), base); err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.WriteLayer(ref.Context(), app); err != nil {
+		t.Fatal(err)
+	}
+	baseDigest, _ := base.Digest()
+	appDigest, _ := app.Digest()
+
+	// Newest first: the app layer, an ENV step without a layer, then the base
+	history := []string{
+		`{"id":"3","parent":"2","created":"2016-05-01T10:00:00Z","os":"linux","architecture":"amd64",` +
+			`"config":{"Env":["PATH=/usr/bin","APP=1"],"Cmd":["/app/run"],"WorkingDir":"/app","User":"app"},` +
+			`"container_config":{"Cmd":["/bin/sh","-c","#(nop) COPY dir:abc in /app"]}}`,
+		`{"id":"2","parent":"1","created":"2016-05-01T09:00:00Z","container_config":{"Cmd":["/bin/sh","-c","#(nop) ENV APP=1"]},"throwaway":true}`,
+		`{"id":"1","created":"2016-04-01T09:00:00Z","container_config":{"Cmd":["/bin/sh","-c","#(nop) ADD file:base in /"]}}`,
+	}
+	manifest := map[string]interface{}{
+		"schemaVersion": 1,
+		"name":          "legacy/app",
+		"tag":           "v1",
+		"architecture":  "amd64",
+		"fsLayers": []map[string]string{
+			{"blobSum": appDigest.String()}, {"blobSum": baseDigest.String()}, {"blobSum": baseDigest.String()},
+		},
+		"history": []map[string]string{
+			{"v1Compatibility": history[0]}, {"v1Compatibility": history[1]}, {"v1Compatibility": history[2]},
+		},
+	}
+	raw, _ := json.Marshal(manifest)
+	if err := remote.Put(ref, rawManifest{raw: raw, mediaType: types.DockerManifestSchema1}); err != nil {
+		t.Fatalf("Failed to push schema1 manifest: %v", err)
+	}
+
+	cacheDir := t.TempDir()
+	exporter := NewImageExporter(WithCache(cacheDir))
+	config, err := exporter.GetImageConfig(imageRef, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if config.User != "app" || config.WorkingDir != "/app" || len(config.Env) != 2 || config.Cmd[0] != "/app/run" || config.Architecture != "amd64" {
+		t.Errorf("Expected the configuration of the newest history entry, got %+v", config)
+	}
+
+	var out bytes.Buffer
+	if err := exporter.ExportImageFilesystemToWriter(imageRef, &out, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	files := readTestTar(t, out.Bytes())
+	if files["etc/os-release"] != "base" || files["app/run"] != "app" {
+		t.Errorf("Expected both layers, got %v", files)
+	}
+	if _, ok := files["etc/old"]; ok {
+		t.Errorf("Expected layers applied oldest first, got %v", files)
+	}
+
+	// The converted image is served from the cache too
+	out.Reset()
+	offline := NewImageExporter(WithCache(cacheDir), WithOffline())
+	if err := offline.ExportImageFilesystemToWriter(imageRef, &out, nil); err != nil {
+		t.Fatalf("Expected no error offline, got %v", err)
+	}
+	if files := readTestTar(t, out.Bytes()); files["app/run"] != "app" {
+		t.Errorf("Expected the filesystem offline, got %v", files)
+	}
+
+	if _, _, err := convertSchema1([]byte(`{"schemaVersion":1,"fsLayers":[{"blobSum":"`+baseDigest.String()+`"}]}`), nil); err == nil {
+		t.Error("Expected an error for a manifest without history")
+	}
+}
@@ -0,0 +1,152 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkSchemaFields fails unless the properties of a schema object match the
+// JSON fields of a struct type, recursing into nested structs
+func checkSchemaFields(t *testing.T, root, schema map[string]interface{}, typ reflect.Type, path string) {
+	t.Helper()
+
+	if ref, ok := schema["$ref"].(string); ok {
+		defs, _ := root["$defs"].(map[string]interface{})
+		schema, _ = defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]interface{})
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		checkSchemaFields(t, root, items, typ, path)
+		return
+	}
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	var fields, documented []string
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		jsonName := strings.Split(field.Tag.Get("json"), ",")[0]
+		fields = append(fields, jsonName)
+
+		nested := field.Type
+		for nested.Kind() == reflect.Slice || nested.Kind() == reflect.Ptr {
+			nested = nested.Elem()
+		}
+		if sub, ok := properties[jsonName].(map[string]interface{}); ok && nested.Kind() == reflect.Struct && nested != reflect.TypeOf(time.Time{}) {
+			checkSchemaFields(t, root, sub, nested, path+"."+jsonName)
+		}
+	}
+	for name := range properties {
+		documented = append(documented, name)
+	}
+	sort.Strings(fields)
+	sort.Strings(documented)
+	if !reflect.DeepEqual(fields, documented) {
+		t.Errorf("Expected schema properties %v at %s, got %v", fields, path, documented)
+	}
+}
+
+// checkSchemaDocument fails unless a JSON value has the required properties,
+// types and constants of its schema, recursing into objects and arrays
+func checkSchemaDocument(t *testing.T, root, schema map[string]interface{}, value interface{}, path string) {
+	t.Helper()
+
+	if ref, ok := schema["$ref"].(string); ok {
+		defs, _ := root["$defs"].(map[string]interface{})
+		schema, _ = defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]interface{})
+	}
+	if constant, ok := schema["const"]; ok && !reflect.DeepEqual(value, constant) {
+		t.Errorf("Expected %v at %s, got %v", constant, path, value)
+	}
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			t.Errorf("Expected an object at %s, got %v", path, value)
+			return
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				t.Errorf("Expected %s at %s", name, path)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, field := range object {
+			sub, ok := properties[name].(map[string]interface{})
+			if !ok {
+				t.Errorf("Expected no undocumented %s at %s", name, path)
+				continue
+			}
+			checkSchemaDocument(t, root, sub, field, path+"."+name)
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			t.Errorf("Expected an array at %s, got %v", path, value)
+			return
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, item := range array {
+			checkSchemaDocument(t, root, items, item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case "integer":
+		if number, ok := value.(float64); !ok || number != float64(int64(number)) {
+			t.Errorf("Expected an integer at %s, got %v", path, value)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			t.Errorf("Expected a string at %s, got %v", path, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			t.Errorf("Expected a boolean at %s, got %v", path, value)
+		}
+	}
+}
+
+func TestJSONSchemasMatchTypes(t *testing.T) {
+	for name, value := range map[string]interface{}{
+		"config":          ImageConfig{},
+		"verify-report":   VerificationReport{},
+		"retention-plan":  RetentionPlan{},
+		"build-info":      BuildInfo{},
+		"start-report":    StartReport{},
+		"lockfile":        Lockfile{},
+		"lock-report":     LockVerification{},
+		"tag-history":     TagHistory{},
+		"referrers":       ReferrerList{},
+		"sbom":            SBOMList{},
+		"provenance":      ProvenanceList{},
+		"packages":        PackageInventory{},
+		"secret-report":   SecretReport{},
+		"audit-report":    AuditReport{},
+		"path-blame":      PathBlame{},
+		"disk-usage":      DiskUsageReport{},
+		"export-estimate": ExportEstimate{},
+		"export-stats":    ExportStats{},
+		"result":          CommandResult{},
+	} {
+		data, err := JSONSchema(name)
+		if err != nil {
+			t.Fatalf("Expected schema %s, got %v", name, err)
+		}
+		var schema map[string]interface{}
+		if err := json.Unmarshal(data, &schema); err != nil {
+			t.Fatalf("Expected schema %s to be valid JSON, got %v", name, err)
+		}
+		checkSchemaFields(t, schema, schema, reflect.TypeOf(value), name)
+
+		version, _ := schema["properties"].(map[string]interface{})["schema_version"].(map[string]interface{})
+		if version["const"] != float64(SchemaVersion) {
+			t.Errorf("Expected %s schema_version to be %d, got %v", name, SchemaVersion, version["const"])
+		}
+	}
+
+	if _, err := JSONSchema("nope"); err == nil {
+		t.Error("Expected an error for an unknown schema")
+	}
+}
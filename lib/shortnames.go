@@ -0,0 +1,244 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvShortNames names a short-name alias file, like the --short-names flag
+const EnvShortNames = "IMGEX_SHORT_NAMES"
+
+// NameResolver maps a short repository name, one without a registry host such
+// as "ubi9" or "team/app", to a fully-qualified repository such as
+// "registry.access.redhat.com/ubi9". It returns false to leave the name to the
+// next resolver, and finally to the Docker Hub default.
+type NameResolver func(shortName string) (string, bool)
+
+// WithNameResolver resolves short image names through resolver before they
+// reach a registry. Tags and digests of the reference are kept. Resolvers apply
+// in order and the first match wins; references naming a registry host are
+// never resolved.
+func WithNameResolver(resolver NameResolver) ExporterOption {
+	return func(e *imageExporter) {
+		e.resolvers = append(e.resolvers, resolver)
+	}
+}
+
+// ShortNameAliases is a table of short names and the repositories they stand
+// for, loaded by LoadShortNameAliases, in the spirit of the short-name aliases
+// of the containers registries.conf.
+type ShortNameAliases struct {
+	aliases map[string]string
+}
+
+// shortNameFile is the layout of a short-name alias file
+type shortNameFile struct {
+	Aliases map[string]string `json:"aliases"`
+}
+
+// LoadShortNameAliases reads a short-name alias file, written as JSON or YAML
+// with an "aliases" map of short name to fully-qualified repository:
+//
+//	aliases:
+//	  ubi9: registry.access.redhat.com/ubi9
+//	  team/app: registry.example.com/team/app
+//
+// Short names must not name a registry host, and neither side may carry a tag
+// or digest; the tag or digest of the resolved reference is kept.
+//
+// Parameters:
+//   - path: Path of the alias file
+//
+// Returns:
+//   - *ShortNameAliases: The aliases, to pass to WithShortNameAliases or query with Resolve
+//   - error: The file could not be read, parsed, or holds an invalid alias
+//
+// Example:
+//
+//	aliases, err := LoadShortNameAliases("/etc/imgex/short-names.yaml")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	exporter := NewImageExporter(WithShortNameAliases(aliases))
+//	config, err := exporter.GetImageConfig("ubi9", nil)
+func LoadShortNameAliases(path string) (*ShortNameAliases, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read short-name aliases: %w", err)
+	}
+	aliases, err := parseShortNameAliases(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse short-name aliases %s: %w", path, err)
+	}
+	return aliases, nil
+}
+
+// parseShortNameAliases decodes and validates JSON or YAML alias file contents
+func parseShortNameAliases(data []byte) (*ShortNameAliases, error) {
+	var document interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	normalized, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	var contents shortNameFile
+	if err := json.Unmarshal(normalized, &contents); err != nil {
+		return nil, err
+	}
+	if contents.Aliases == nil {
+		return nil, fmt.Errorf(`no aliases found, expected an "aliases" map`)
+	}
+
+	aliases := &ShortNameAliases{aliases: make(map[string]string, len(contents.Aliases))}
+	for short, target := range contents.Aliases {
+		if !isShortName(short) || strings.ContainsAny(short, ":@") {
+			return nil, fmt.Errorf("alias %q must be a repository name without a registry, tag or digest", short)
+		}
+		repo, err := name.NewRepository(target, name.StrictValidation)
+		if err != nil || isShortName(target) {
+			return nil, fmt.Errorf("alias %s: %q is not a fully-qualified repository", short, target)
+		}
+		aliases.aliases[short] = repo.String()
+	}
+	return aliases, nil
+}
+
+// Resolve returns the repository a short name stands for. It implements
+// NameResolver.
+func (a *ShortNameAliases) Resolve(shortName string) (string, bool) {
+	target, ok := a.aliases[shortName]
+	return target, ok
+}
+
+// Names returns the short names of the table, sorted
+func (a *ShortNameAliases) Names() []string {
+	names := make([]string, 0, len(a.aliases))
+	for short := range a.aliases {
+		names = append(names, short)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WithShortNameAliases resolves the short names of an alias table, see
+// WithNameResolver
+func WithShortNameAliases(aliases *ShortNameAliases) ExporterOption {
+	return WithNameResolver(aliases.Resolve)
+}
+
+// DefaultShortNamesFile returns the alias file used when none is configured:
+// $IMGEX_SHORT_NAMES if set, otherwise short-names.yaml in the imgex folder of
+// the user configuration directory (e.g. ~/.config/imgex/short-names.yaml).
+// The file may not exist.
+func DefaultShortNamesFile() (string, error) {
+	if path := os.Getenv(EnvShortNames); path != "" {
+		return path, nil
+	}
+	configHome, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the configuration directory: %w", err)
+	}
+	return filepath.Join(configHome, "imgex", "short-names.yaml"), nil
+}
+
+// isShortName reports whether a reference or repository lacks a registry host.
+// As in the registry client, a first path component is a host when it contains
+// a dot or a port.
+func isShortName(ref string) bool {
+	first, _, found := strings.Cut(ref, "/")
+	if !found {
+		return true
+	}
+	return !strings.ContainsAny(first, ".:")
+}
+
+// resolveName expands a short name through the configured resolvers, returning
+// the reference unchanged when it names a registry or nothing matches
+func (e *imageExporter) resolveName(ref string) (string, error) {
+	if len(e.resolvers) == 0 || !isShortName(ref) {
+		return ref, nil
+	}
+
+	// Split the tag or digest off the repository
+	repository, suffix := ref, ""
+	if i := strings.IndexByte(ref, '@'); i >= 0 {
+		repository, suffix = ref[:i], ref[i:]
+	}
+	if i := strings.LastIndex(repository, ":"); i >= 0 {
+		repository, suffix = repository[:i], repository[i:]+suffix
+	}
+
+	for _, resolve := range e.resolvers {
+		target, ok := resolve(repository)
+		if !ok {
+			continue
+		}
+		if isShortName(target) {
+			return "", fmt.Errorf("short name %s resolves to %q, which names no registry", repository, target)
+		}
+		e.log().Debug("short name resolved", "name", repository, "repository", target)
+		return target + suffix, nil
+	}
+	return ref, nil
+}
+
+// parseReference parses an image reference after short-name resolution,
+// enforcing the repository policy
+func (e *imageExporter) parseReference(imageRef string) (name.Reference, error) {
+	resolved, err := e.resolveName(imageRef)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := name.ParseReference(resolved)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.checkRepository(ref.Context().Name()); err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// parseRepository parses a repository after short-name resolution, enforcing
+// the repository policy
+func (e *imageExporter) parseRepository(repository string) (name.Repository, error) {
+	resolved, err := e.resolveName(repository)
+	if err != nil {
+		return name.Repository{}, err
+	}
+	repo, err := name.NewRepository(resolved)
+	if err != nil {
+		return name.Repository{}, err
+	}
+	if err := e.checkRepository(repo.Name()); err != nil {
+		return name.Repository{}, err
+	}
+	return repo, nil
+}
+
+// ResolveName returns the fully-qualified reference an image reference stands
+// for, after short-name resolution and Docker Hub defaults, e.g. "alpine"
+// becomes "index.docker.io/library/alpine:latest".
+//
+// Parameters:
+//   - imageRef: Image reference, possibly a short name
+//
+// Returns:
+//   - string: The fully-qualified reference
+//   - error: If the reference is invalid or a resolver returned an invalid name
+func (e *imageExporter) ResolveName(imageRef string) (string, error) {
+	ref, err := e.parseReference(imageRef)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse image reference %s: %w", imageRef, err)
+	}
+	return ref.Name(), nil
+}
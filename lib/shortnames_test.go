@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestLoadShortNameAliases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short-names.yaml")
+	contents := `
+aliases:
+  ubi9: registry.access.redhat.com/ubi9
+  team/app: registry.example.com/team/app
+`
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("Failed to write alias file: %v", err)
+	}
+	aliases, err := LoadShortNameAliases(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if expected := []string{"team/app", "ubi9"}; !reflect.DeepEqual(aliases.Names(), expected) {
+		t.Errorf("Expected names %v, got %v", expected, aliases.Names())
+	}
+	if target, ok := aliases.Resolve("ubi9"); !ok || target != "registry.access.redhat.com/ubi9" {
+		t.Errorf("Expected ubi9 to resolve, got %q, %v", target, ok)
+	}
+	if _, ok := aliases.Resolve("ubi8"); ok {
+		t.Error("Expected ubi8 not to resolve")
+	}
+
+	for _, invalid := range []string{
+		`{"aliases": {"quay.io/ubi9": "registry.access.redhat.com/ubi9"}}`,
+		`{"aliases": {"ubi9:latest": "registry.access.redhat.com/ubi9"}}`,
+		`{"aliases": {"ubi9": "ubi9"}}`,
+		`{"aliases": {"ubi9": "registry.access.redhat.com/ubi9:latest"}}`,
+		`{"registries": {}}`,
+	} {
+		if _, err := parseShortNameAliases([]byte(invalid)); err == nil {
+			t.Errorf("Expected an error for %s", invalid)
+		}
+	}
+}
+
+func TestNameResolver(t *testing.T) {
+	aliases, err := parseShortNameAliases([]byte(`{"aliases": {"ubi9": "registry.access.redhat.com/ubi9"}}`))
+	if err != nil {
+		t.Fatalf("Failed to parse aliases: %v", err)
+	}
+	exporter := NewImageExporter(
+		WithShortNameAliases(aliases),
+		WithNameResolver(func(shortName string) (string, bool) {
+			return "quay.io/mirror/" + shortName, strings.HasPrefix(shortName, "mirrored/")
+		}),
+	)
+
+	digest := "sha256:" + strings.Repeat("a", 64)
+	for ref, expected := range map[string]string{
+		"ubi9":                 "registry.access.redhat.com/ubi9:latest",
+		"ubi9:9.4":             "registry.access.redhat.com/ubi9:9.4",
+		"ubi9@" + digest:       "registry.access.redhat.com/ubi9@" + digest,
+		"mirrored/app:v1":      "quay.io/mirror/mirrored/app:v1",
+		"alpine":               "index.docker.io/library/alpine:latest",
+		"localhost:5000/ubi9":  "localhost:5000/ubi9:latest",
+		"docker.io/library/ub": "index.docker.io/library/ub:latest",
+	} {
+		resolved, err := exporter.ResolveName(ref)
+		if err != nil {
+			t.Errorf("Expected %s to resolve, got %v", ref, err)
+			continue
+		}
+		if resolved != expected {
+			t.Errorf("Expected %s to resolve to %s, got %s", ref, expected, resolved)
+		}
+	}
+
+	broken := NewImageExporter(WithNameResolver(func(string) (string, bool) { return "elsewhere", true }))
+	if _, err := broken.ResolveName("app"); err == nil {
+		t.Error("Expected an error for a resolver returning a short name")
+	}
+}
+
+func TestShortNameRegistryAccess(t *testing.T) {
+	host := newTestRegistry(t)
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "app", content: "app"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	pushTestImage(t, host+"/team/app:v1", image)
+
+	exporter := NewImageExporter(WithNameResolver(func(shortName string) (string, bool) {
+		return host + "/team/" + shortName, shortName == "app"
+	}))
+	if _, err := exporter.GetImageConfig("app:v1", nil); err != nil {
+		t.Errorf("Expected the short name to reach the test registry, got %v", err)
+	}
+	tags, err := exporter.ListTags("app", nil, nil)
+	if err != nil {
+		t.Fatalf("Expected tags of the short name, got %v", err)
+	}
+	page, err := tags.Next()
+	if err != nil || !reflect.DeepEqual(page, []string{"v1"}) {
+		t.Errorf("Expected tags [v1], got %v (%v)", page, err)
+	}
+}
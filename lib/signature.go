@@ -0,0 +1,116 @@
+package lib
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+// ErrSignatureVerification is returned when signature verification is enabled
+// (see WithCosignVerification and WithNotationVerification) and an image has
+// no signature the policy accepts, or cannot be verified at all
+var ErrSignatureVerification = errors.New("signature verification failed")
+
+// verifiesSignatures reports whether images must be verified before use
+func (e *imageExporter) verifiesSignatures() bool {
+	return e.cosign != nil || e.notation != nil
+}
+
+// verifySignatures checks the signatures of the manifest digest ref resolved
+// to against every configured policy. Digests verified once are not checked
+// again by the same exporter.
+func (e *imageExporter) verifySignatures(ref name.Reference, digest v1.Hash, auth *AuthConfig) error {
+	if !e.verifiesSignatures() {
+		return nil
+	}
+	key := ref.Context().Name() + "@" + digest.String()
+	if _, ok := e.verified.Load(key); ok {
+		return nil
+	}
+	if e.cosign != nil {
+		if err := e.verifyCosign(ref.Context(), digest, auth); err != nil {
+			return err
+		}
+	}
+	if e.notation != nil {
+		if err := e.verifyNotation(ref.Context(), digest, auth); err != nil {
+			return err
+		}
+	}
+	e.verified.Store(key, true)
+	return nil
+}
+
+// LoadPublicKeys reads the public keys of a PEM file: PKIX public keys (the
+// format of cosign.pub) and the keys of certificates, in file order.
+//
+// Parameters:
+//   - path: PEM file holding one or more "PUBLIC KEY" or "CERTIFICATE" blocks
+//
+// Returns:
+//   - []crypto.PublicKey: The keys found, at least one
+//   - error: If the file cannot be read or holds no usable key
+func LoadPublicKeys(path string) ([]crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid public key in %s: %w", path, err)
+			}
+			keys = append(keys, key)
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid certificate in %s: %w", path, err)
+			}
+			keys = append(keys, cert.PublicKey)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no PEM public key in %s", path)
+	}
+	return keys, nil
+}
+
+// verifySignature checks a signature of payload made with the private key of
+// key: ECDSA (ASN.1) and RSA (PKCS #1 v1.5) over SHA-256, or Ed25519
+func verifySignature(key crypto.PublicKey, payload, signature []byte) error {
+	sum := sha256.Sum256(payload)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, sum[:], signature) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, payload, signature) {
+			return errors.New("invalid Ed25519 signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+}
@@ -0,0 +1,452 @@
+package lib
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// defaultPath is the PATH container runtimes set when the image defines none
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// SimulateOptions overrides the image configuration like the flags of 'docker run'
+type SimulateOptions struct {
+	// Platform selects the image from a multi-arch index, e.g. "linux/arm64";
+	// empty means linux/amd64
+	Platform string
+
+	// Env adds or replaces environment variables, as KEY=VALUE
+	Env []string
+
+	// User replaces the image USER: a name or UID, optionally with ":group" or ":GID"
+	User string
+
+	// Entrypoint replaces the image ENTRYPOINT when non-nil, which also clears CMD
+	Entrypoint []string
+
+	// Cmd replaces CMD when non-empty
+	Cmd []string
+
+	// WorkingDir replaces the image WORKDIR
+	WorkingDir string
+}
+
+// StartReport describes what a container of the image would do at start, as
+// computed by SimulateStart
+type StartReport struct {
+	// SchemaVersion is the version of this JSON document (see SchemaVersion)
+	SchemaVersion int `json:"schema_version"`
+
+	// Image is the simulated image reference
+	Image string `json:"image"`
+
+	// Command is the resolved command line: ENTRYPOINT followed by CMD
+	Command []string `json:"command"`
+
+	// Env is the final environment of the process, in order
+	Env []string `json:"env"`
+
+	// User is the identity the process runs as
+	User StartUser `json:"user"`
+
+	// WorkingDir is the directory the process starts in
+	WorkingDir StartWorkingDir `json:"working_dir"`
+
+	// Executable describes the program of the command line
+	Executable StartExecutable `json:"executable"`
+
+	// Problems lists everything that would make the container fail to start
+	Problems []string `json:"problems"`
+}
+
+// StartUser is the resolved identity of a container process
+type StartUser struct {
+	// Spec is the user as configured, e.g. "nginx" or "1001:0"; empty means root
+	Spec string `json:"spec"`
+
+	// UID and GID are the numeric ids the process runs with
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+
+	// Name and Group are the names from /etc/passwd and /etc/group, if any
+	Name  string `json:"name,omitempty"`
+	Group string `json:"group,omitempty"`
+
+	// AdditionalGIDs are the supplementary groups listing the user in /etc/group
+	AdditionalGIDs []int `json:"additional_gids"`
+
+	// Home is the home directory from /etc/passwd, "/" without an entry
+	Home string `json:"home"`
+}
+
+// StartWorkingDir is the working directory of a container process
+type StartWorkingDir struct {
+	// Path is the absolute path of the directory
+	Path string `json:"path"`
+
+	// Exists reports whether the image has the directory; runtimes create a missing one
+	Exists bool `json:"exists"`
+}
+
+// StartExecutable is the program a container process would execute
+type StartExecutable struct {
+	// Name is the first word of the command line
+	Name string `json:"name"`
+
+	// Path is where Name resolved to in the image, after the PATH search; empty if not found
+	Path string `json:"path,omitempty"`
+
+	// Executable reports whether the user may execute the file
+	Executable bool `json:"executable"`
+
+	// Interpreter is the program that runs the file: the #! line of a script or
+	// the dynamic loader of an ELF binary; empty for static binaries
+	Interpreter string `json:"interpreter,omitempty"`
+}
+
+// OK reports whether the container would start without a known problem
+func (r *StartReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// SimulateStart reports what would happen when a container of the image starts,
+// without running anything: the command line, environment and user resolved
+// like Docker and runc do, whether the working directory exists, and whether
+// the program exists, is executable by the user and has its interpreter.
+//
+// Parameters:
+//   - imageRef: Docker image reference (e.g., "nginx:alpine")
+//   - auth: Optional authentication configuration for private registries
+//   - opts: Optional overrides, like the flags of 'docker run'
+//
+// Returns:
+//   - *StartReport: What would happen at start; Problems lists what would fail
+//   - error: Any error that prevented the simulation
+//
+// Example:
+//
+//	report, err := exporter.SimulateStart("nginx:alpine", nil, &SimulateOptions{User: "1001"})
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	for _, problem := range report.Problems {
+//	    fmt.Println(problem)
+//	}
+func (e *imageExporter) SimulateStart(imageRef string, auth *AuthConfig, opts *SimulateOptions) (*StartReport, error) {
+	if opts == nil {
+		opts = &SimulateOptions{}
+	}
+
+	image, filesystem, err := e.flattenImage(imageRef, auth, opts.Platform)
+	if err != nil {
+		return nil, err
+	}
+	configFile, err := image.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config file: %w", err)
+	}
+	config := configFile.Config
+
+	report := &StartReport{SchemaVersion: SchemaVersion, Image: imageRef, Problems: []string{}}
+
+	// Identity, from the image files like runc does
+	report.User.Spec = config.User
+	if opts.User != "" {
+		report.User.Spec = opts.User
+	}
+	if problem := resolveStartUser(filesystem, &report.User); problem != "" {
+		report.Problems = append(report.Problems, problem)
+	}
+
+	// Environment: image variables, then overrides, then the runtime defaults
+	report.Env = mergeEnv(config.Env, opts.Env)
+	if envValue(report.Env, "PATH") == nil {
+		report.Env = append(report.Env, "PATH="+defaultPath)
+	}
+	if envValue(report.Env, "HOME") == nil {
+		report.Env = append(report.Env, "HOME="+report.User.Home)
+	}
+
+	// Working directory
+	report.WorkingDir.Path = config.WorkingDir
+	if opts.WorkingDir != "" {
+		report.WorkingDir.Path = opts.WorkingDir
+	}
+	if report.WorkingDir.Path == "" {
+		report.WorkingDir.Path = "/"
+	}
+	if !path.IsAbs(report.WorkingDir.Path) {
+		report.Problems = append(report.Problems, fmt.Sprintf("working directory %s is not an absolute path", report.WorkingDir.Path))
+	} else if entry, ok := resolveEntry(filesystem, report.WorkingDir.Path, 0); ok {
+		report.WorkingDir.Exists = true
+		if entry != nil && entry.header.Typeflag != tar.TypeDir {
+			report.Problems = append(report.Problems, fmt.Sprintf("working directory %s is a %s", report.WorkingDir.Path, tarTypeName(entry.header.Typeflag)))
+		}
+	}
+
+	// Command line: an entrypoint override clears CMD, like 'docker run --entrypoint'
+	entrypoint, cmd := config.Entrypoint, config.Cmd
+	if opts.Entrypoint != nil {
+		entrypoint, cmd = opts.Entrypoint, nil
+	}
+	if len(opts.Cmd) > 0 {
+		cmd = opts.Cmd
+	}
+	report.Command = append(append([]string{}, entrypoint...), cmd...)
+	if len(report.Command) == 0 {
+		report.Problems = append(report.Problems, "no command: the image has no ENTRYPOINT or CMD")
+		return report, nil
+	}
+
+	report.Problems = append(report.Problems, checkStartExecutable(filesystem, report)...)
+	return report, nil
+}
+
+// resolveStartUser fills in the ids, names and home of user.Spec from the
+// image's /etc/passwd and /etc/group, returning a problem if they do not resolve
+func resolveStartUser(filesystem map[string]*fileEntry, user *StartUser) string {
+	passwd := readColonFile(filesystem, "etc/passwd")
+	groups := readColonFile(filesystem, "etc/group")
+
+	userPart, groupPart, hasGroup := strings.Cut(user.Spec, ":")
+	if userPart == "" {
+		userPart = "0"
+	}
+	user.Home = "/"
+
+	// A name must be in /etc/passwd; a number only needs to be valid
+	found := false
+	for _, fields := range passwd {
+		if len(fields) < 6 || (fields[0] != userPart && fields[2] != userPart) {
+			continue
+		}
+		uid, uidErr := strconv.Atoi(fields[2])
+		gid, gidErr := strconv.Atoi(fields[3])
+		if uidErr != nil || gidErr != nil {
+			continue
+		}
+		user.UID, user.GID, user.Name, user.Home = uid, gid, fields[0], fields[5]
+		found = true
+		break
+	}
+	if !found {
+		uid, err := strconv.Atoi(userPart)
+		if err != nil || uid < 0 {
+			return fmt.Sprintf("unable to find user %s: no matching entries in passwd file", userPart)
+		}
+		user.UID = uid
+	}
+
+	if hasGroup {
+		found = false
+		for _, fields := range groups {
+			if len(fields) < 3 || (fields[0] != groupPart && fields[2] != groupPart) {
+				continue
+			}
+			if gid, err := strconv.Atoi(fields[2]); err == nil {
+				user.GID, user.Group, found = gid, fields[0], true
+				break
+			}
+		}
+		if !found {
+			gid, err := strconv.Atoi(groupPart)
+			if err != nil || gid < 0 {
+				return fmt.Sprintf("unable to find group %s: no matching entries in group file", groupPart)
+			}
+			user.GID = gid
+		}
+	}
+
+	user.AdditionalGIDs = []int{}
+	for _, fields := range groups {
+		if len(fields) < 4 {
+			continue
+		}
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		if gid == user.GID && user.Group == "" {
+			user.Group = fields[0]
+		}
+		if user.Name == "" || gid == user.GID {
+			continue
+		}
+		for _, member := range strings.Split(fields[3], ",") {
+			if strings.TrimSpace(member) == user.Name {
+				user.AdditionalGIDs = append(user.AdditionalGIDs, gid)
+				break
+			}
+		}
+	}
+	return ""
+}
+
+// readColonFile reads the lines of a file like /etc/passwd as colon-separated
+// fields, skipping comments; a missing file has no lines
+func readColonFile(filesystem map[string]*fileEntry, p string) [][]string {
+	entry, ok := resolveFile(filesystem, p)
+	if !ok || entry == nil || entry.header.Typeflag == tar.TypeDir {
+		return nil
+	}
+	var lines [][]string
+	scanner := bufio.NewScanner(entry.content())
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, strings.Split(line, ":"))
+	}
+	return lines
+}
+
+// mergeEnv applies KEY=VALUE overrides to an environment, replacing variables
+// in place and appending new ones
+func mergeEnv(env, overrides []string) []string {
+	merged := append([]string{}, env...)
+	for _, override := range overrides {
+		key, _, _ := strings.Cut(override, "=")
+		replaced := false
+		for i, variable := range merged {
+			if k, _, _ := strings.Cut(variable, "="); k == key {
+				merged[i], replaced = override, true
+			}
+		}
+		if !replaced {
+			merged = append(merged, override)
+		}
+	}
+	return merged
+}
+
+// envValue returns the value of a variable, or nil if it is not set
+func envValue(env []string, key string) *string {
+	for i := len(env) - 1; i >= 0; i-- {
+		if k, value, _ := strings.Cut(env[i], "="); k == key {
+			return &value
+		}
+	}
+	return nil
+}
+
+// checkStartExecutable resolves the program of the command line like execvp,
+// searching PATH for a bare name, and checks it can be executed by the user
+func checkStartExecutable(filesystem map[string]*fileEntry, report *StartReport) []string {
+	executable := &report.Executable
+	executable.Name = report.Command[0]
+
+	var candidates []string
+	switch {
+	case strings.HasPrefix(executable.Name, "/"):
+		candidates = []string{executable.Name}
+	case strings.Contains(executable.Name, "/"):
+		candidates = []string{path.Join(report.WorkingDir.Path, executable.Name)}
+	default:
+		for _, dir := range strings.Split(*envValue(report.Env, "PATH"), ":") {
+			if dir == "" {
+				dir = report.WorkingDir.Path
+			}
+			candidates = append(candidates, path.Join(dir, executable.Name))
+		}
+	}
+
+	var entry *fileEntry
+	for _, candidate := range candidates {
+		found, ok := resolveFile(filesystem, candidate)
+		if ok && found != nil && found.header.Typeflag != tar.TypeDir {
+			executable.Path, entry = candidate, found
+			break
+		}
+	}
+	if entry == nil {
+		if len(candidates) > 1 {
+			return []string{fmt.Sprintf("executable %s not found in $PATH", executable.Name)}
+		}
+		return []string{fmt.Sprintf("executable %s not found", executable.Name)}
+	}
+
+	var problems []string
+	if entry.header.Typeflag != tar.TypeReg && entry.header.Typeflag != tar.TypeRegA {
+		return []string{fmt.Sprintf("executable %s is a %s", executable.Path, tarTypeName(entry.header.Typeflag))}
+	}
+	executable.Executable = canExecute(entry.header, &report.User)
+	if !executable.Executable {
+		problems = append(problems, fmt.Sprintf("%s is not executable by uid %d (mode %04o)", executable.Path, report.User.UID, entry.header.Mode&07777))
+	}
+
+	interpreter, err := fileInterpreter(entry)
+	if err != nil {
+		return append(problems, fmt.Sprintf("failed to read %s: %v", executable.Path, err))
+	}
+	executable.Interpreter = interpreter
+	if interpreter != "" {
+		if found, ok := resolveFile(filesystem, interpreter); !ok || found == nil || found.header.Typeflag == tar.TypeDir {
+			problems = append(problems, fmt.Sprintf("interpreter %s of %s not found", interpreter, executable.Path))
+		}
+	}
+	return problems
+}
+
+// canExecute checks the execute permission of a file for a user like the kernel
+func canExecute(header *tar.Header, user *StartUser) bool {
+	mode := header.Mode
+	if user.UID == 0 {
+		return mode&0111 != 0
+	}
+	if header.Uid == user.UID {
+		return mode&0100 != 0
+	}
+	if header.Gid == user.GID {
+		return mode&0010 != 0
+	}
+	for _, gid := range user.AdditionalGIDs {
+		if header.Gid == gid {
+			return mode&0010 != 0
+		}
+	}
+	return mode&0001 != 0
+}
+
+// fileInterpreter returns the interpreter of a script's #! line or the dynamic
+// loader of an ELF binary, or "" for other files
+func fileInterpreter(entry *fileEntry) (string, error) {
+	data, err := io.ReadAll(entry.content())
+	if err != nil {
+		return "", err
+	}
+
+	if line, ok := bytes.CutPrefix(data, []byte("#!")); ok {
+		if end := bytes.IndexByte(line, '\n'); end >= 0 {
+			line = line[:end]
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 {
+			return "", nil
+		}
+		return fields[0], nil
+	}
+
+	binary, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return "", nil
+	}
+	defer binary.Close()
+	for _, prog := range binary.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		interp, err := io.ReadAll(prog.Open())
+		if err != nil {
+			return "", err
+		}
+		return string(bytes.TrimRight(interp, "\x00")), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"archive/tar"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestSimulateStart(t *testing.T) {
+	layer := newTestLayer(t,
+		testEntry{name: "etc/passwd", content: "root:x:0:0:root:/root:/bin/sh\napp:x:1001:1001::/home/app:/bin/sh\n"},
+		testEntry{name: "etc/group", content: "root:x:0:\napp:x:1001:\nstaff:x:50:app,other\n"},
+		testEntry{name: "bin/sh", content: "\x7fELF not really", mode: 0755},
+		testEntry{name: "usr/local/bin/start.sh", content: "#!/bin/sh -e\nexec app\n", mode: 0755},
+		testEntry{name: "usr/local/bin/tool", content: "#!/usr/bin/python3\n", mode: 0755},
+		testEntry{name: "usr/local/bin/private", content: "#!/bin/sh\n", mode: 0700},
+		testEntry{name: "srv/", typeflag: tar.TypeDir},
+	)
+	base, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	image, err := mutate.Config(base, v1.Config{
+		User:       "app",
+		Entrypoint: []string{"start.sh"},
+		Cmd:        []string{"--serve"},
+		WorkingDir: "/srv",
+		Env:        []string{"MODE=prod", "LANG=C"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to set test image config: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/start:latest"
+	pushTestImage(t, imageRef, image)
+	exporter := NewImageExporter()
+
+	report, err := exporter.SimulateStart(imageRef, nil, &SimulateOptions{Env: []string{"MODE=dev", "DEBUG=1"}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !report.OK() {
+		t.Errorf("Expected no problems, got %v", report.Problems)
+	}
+	if expected := []string{"start.sh", "--serve"}; !reflect.DeepEqual(report.Command, expected) {
+		t.Errorf("Expected command %v, got %v", expected, report.Command)
+	}
+	if expected := []string{"MODE=dev", "LANG=C", "DEBUG=1", "PATH=" + defaultPath, "HOME=/home/app"}; !reflect.DeepEqual(report.Env, expected) {
+		t.Errorf("Expected env %v, got %v", expected, report.Env)
+	}
+	if user := report.User; user.UID != 1001 || user.GID != 1001 || user.Name != "app" || user.Group != "app" || !reflect.DeepEqual(user.AdditionalGIDs, []int{50}) {
+		t.Errorf("Expected app (1001:1001, groups 50), got %+v", user)
+	}
+	if !report.WorkingDir.Exists {
+		t.Errorf("Expected /srv to exist, got %+v", report.WorkingDir)
+	}
+	if exe := report.Executable; exe.Path != "/usr/local/bin/start.sh" || !exe.Executable || exe.Interpreter != "/bin/sh" {
+		t.Errorf("Expected start.sh found on PATH and run by /bin/sh, got %+v", exe)
+	}
+
+	for _, test := range []struct {
+		opts    SimulateOptions
+		problem string
+	}{
+		{SimulateOptions{User: "nobody"}, "unable to find user nobody"},
+		{SimulateOptions{User: "1001:wheel"}, "unable to find group wheel"},
+		{SimulateOptions{User: "2000"}, ""},
+		{SimulateOptions{Entrypoint: []string{}}, "no command"},
+		{SimulateOptions{Entrypoint: []string{"missing"}}, "executable missing not found in $PATH"},
+		{SimulateOptions{Entrypoint: []string{"tool"}}, "interpreter /usr/bin/python3 of /usr/local/bin/tool not found"},
+		{SimulateOptions{Entrypoint: []string{"/usr/local/bin/private"}}, "not executable by uid 1001"},
+		{SimulateOptions{Entrypoint: []string{"./sh"}, WorkingDir: "/bin"}, ""},
+		{SimulateOptions{Entrypoint: []string{"sh"}, WorkingDir: "/etc/passwd"}, "working directory /etc/passwd is a file"},
+	} {
+		report, err := exporter.SimulateStart(imageRef, nil, &test.opts)
+		if err != nil {
+			t.Fatalf("Expected no error for %+v, got %v", test.opts, err)
+		}
+		if test.problem == "" {
+			if !report.OK() {
+				t.Errorf("Expected no problems for %+v, got %v", test.opts, report.Problems)
+			}
+			continue
+		}
+		if len(report.Problems) != 1 || !strings.Contains(report.Problems[0], test.problem) {
+			t.Errorf("Expected the problem %q for %+v, got %v", test.problem, test.opts, report.Problems)
+		}
+	}
+
+	// Root can run anything with an execute bit, and a missing working directory is created
+	report, err = exporter.SimulateStart(imageRef, nil, &SimulateOptions{User: "0", WorkingDir: "/data", Entrypoint: []string{"/usr/local/bin/private"}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !report.OK() || report.WorkingDir.Exists || report.User.Name != "root" || report.User.Home != "/root" {
+		t.Errorf("Expected root to start in a missing /data, got %+v", report)
+	}
+}
@@ -0,0 +1,274 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sparseBlockSize is the granularity at which holes are detected in sparse files
+const sparseBlockSize = 512
+
+// gnuSparsePrefix starts the PAX records describing GNU sparse files
+const gnuSparsePrefix = "GNU.sparse."
+
+// dataRegion is a range of a sparse file holding data; the rest are holes
+type dataRegion struct {
+	offset, length int64
+}
+
+// isSparseHeader reports whether a layer stored a file as a GNU or PAX sparse
+// file. The tar reader expands such files, returning zeros for their holes.
+func isSparseHeader(header *tar.Header) bool {
+	if header.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, gnuSparsePrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropSparseRecords turns the header of a sparse file read from a layer into
+// the header of a regular file; the sparse map of the layer no longer applies
+func dropSparseRecords(header *tar.Header) {
+	header.Typeflag = tar.TypeReg
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, gnuSparsePrefix) {
+			delete(header.PAXRecords, key)
+		}
+	}
+}
+
+// readDataRegions reads size bytes of an expanded sparse file, writes the
+// blocks that are not all zeros to w and returns them as regions, so that holes
+// of any size cost nothing to keep
+func readDataRegions(r io.Reader, size int64, w io.Writer) ([]dataRegion, error) {
+	regions := []dataRegion{}
+	var zero [sparseBlockSize]byte
+	buf := make([]byte, 128*sparseBlockSize)
+	for offset := int64(0); offset < size; {
+		n := min(int64(len(buf)), size-offset)
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			return nil, fmt.Errorf("failed to read file data: %w", err)
+		}
+		for start := int64(0); start < n; start += sparseBlockSize {
+			end := min(start+sparseBlockSize, n)
+			block := buf[start:end]
+			if bytes.Equal(block, zero[:len(block)]) {
+				continue
+			}
+			at := offset + start
+			if last := len(regions) - 1; last >= 0 && regions[last].offset+regions[last].length == at {
+				regions[last].length += end - start
+			} else {
+				regions = append(regions, dataRegion{offset: at, length: end - start})
+			}
+			if _, err := w.Write(block); err != nil {
+				return nil, fmt.Errorf("failed to store file data: %w", err)
+			}
+		}
+		offset += n
+	}
+	return regions, nil
+}
+
+// holeReader expands the data regions of a sparse file back to its full size
+type holeReader struct {
+	data    io.Reader // concatenated data of the regions
+	regions []dataRegion
+	pos     int64
+	size    int64
+}
+
+func (r *holeReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	for len(r.regions) > 0 && r.regions[0].offset+r.regions[0].length <= r.pos {
+		r.regions = r.regions[1:]
+	}
+
+	// A hole lasts until the next region, or the end of the file
+	end := r.size
+	inHole := len(r.regions) == 0 || r.pos < r.regions[0].offset
+	if len(r.regions) > 0 {
+		end = r.regions[0].offset
+		if !inHole {
+			end += r.regions[0].length
+		}
+	}
+	if int64(len(p)) > end-r.pos {
+		p = p[:end-r.pos]
+	}
+	if inHole {
+		clear(p)
+		r.pos += int64(len(p))
+		return len(p), nil
+	}
+	n, err := io.ReadFull(r.data, p)
+	r.pos += int64(n)
+	if err != nil {
+		return n, fmt.Errorf("sparse file data ends early: %w", err)
+	}
+	return n, nil
+}
+
+// writeSparseEntry writes a regular file as a PAX 1.0 sparse file (the format
+// of GNU tar's --sparse-version=1.0), storing only its data regions, to the
+// writer of an archive between two entries. archive/tar reads these files but
+// cannot write them, so the PAX and USTAR header blocks are encoded here.
+// Regions must start and end on block boundaries, except at the end of the
+// file, as GNU tar reads the data of each region from a new block.
+func writeSparseEntry(w io.Writer, header *tar.Header, regions []dataRegion, data io.Reader) error {
+	// The sparse map lists the regions, ending with an empty one at the end of
+	// the file so that trailing holes are kept, and is padded to a block
+	if n := len(regions); n == 0 || regions[n-1].offset+regions[n-1].length < header.Size {
+		regions = append(slices.Clip(regions), dataRegion{offset: header.Size})
+	}
+	sparseMap := strconv.AppendInt(nil, int64(len(regions)), 10)
+	sparseMap = append(sparseMap, '\n')
+	var dataSize int64
+	for _, region := range regions {
+		sparseMap = append(strconv.AppendInt(sparseMap, region.offset, 10), '\n')
+		sparseMap = append(strconv.AppendInt(sparseMap, region.length, 10), '\n')
+		dataSize += region.length
+	}
+	sparseMap = append(sparseMap, make([]byte, blockPadding(int64(len(sparseMap))))...)
+	size := int64(len(sparseMap)) + dataSize
+
+	records := map[string]string{
+		gnuSparsePrefix + "major":    "1",
+		gnuSparsePrefix + "minor":    "0",
+		gnuSparsePrefix + "name":     header.Name,
+		gnuSparsePrefix + "realsize": strconv.FormatInt(header.Size, 10),
+	}
+	for key, value := range header.PAXRecords {
+		if !paxHeaderKeys[key] && !strings.HasPrefix(key, gnuSparsePrefix) {
+			records[key] = value
+		}
+	}
+	for name, value := range header.Xattrs {
+		if _, ok := records["SCHILY.xattr."+name]; !ok {
+			records["SCHILY.xattr."+name] = value
+		}
+	}
+	// Fields the USTAR header cannot hold
+	if !fitsOctal(header.Uid, 8) {
+		records["uid"] = strconv.Itoa(header.Uid)
+	}
+	if !fitsOctal(header.Gid, 8) {
+		records["gid"] = strconv.Itoa(header.Gid)
+	}
+	if len(header.Uname) > 32 {
+		records["uname"] = header.Uname
+	}
+	if len(header.Gname) > 32 {
+		records["gname"] = header.Gname
+	}
+	if !fitsOctal(size, 12) {
+		records["size"] = strconv.FormatInt(size, 10)
+	}
+	if !fitsOctal(header.ModTime.Unix(), 12) {
+		records["mtime"] = strconv.FormatInt(header.ModTime.Unix(), 10)
+	}
+	var pax []byte
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		pax = append(pax, paxRecord(key, records[key])...)
+	}
+
+	dir, base := path.Split(header.Name)
+	blocks := [][]byte{
+		ustarBlock(path.Join(dir, "PaxHeaders.0", base), 0644, 0, 0, int64(len(pax)), header.ModTime.Unix(), tar.TypeXHeader, "", ""),
+		pax,
+		make([]byte, blockPadding(int64(len(pax)))),
+		ustarBlock(path.Join(dir, "GNUSparseFile.0", base), header.Mode&07777, int64(header.Uid), int64(header.Gid),
+			size, header.ModTime.Unix(), tar.TypeReg, header.Uname, header.Gname),
+		sparseMap,
+	}
+	for _, block := range blocks {
+		if _, err := w.Write(block); err != nil {
+			return err
+		}
+	}
+	if _, err := io.CopyN(w, data, dataSize); err != nil {
+		return err
+	}
+	_, err := w.Write(make([]byte, blockPadding(size)))
+	return err
+}
+
+// blockPadding returns the number of bytes padding n to a whole tar block
+func blockPadding(n int64) int64 {
+	return -n & (sparseBlockSize - 1)
+}
+
+// fitsOctal reports whether n fits in a NUL-terminated octal field of width bytes
+func fitsOctal[T int | int64](n T, width int) bool {
+	return n >= 0 && int64(n) < int64(1)<<(3*(width-1))
+}
+
+// paxRecord formats a PAX extended header record, prefixed with its own length
+func paxRecord(key, value string) string {
+	const padding = 3 // ' ', '=' and '\n'
+	size := len(key) + len(value) + padding
+	size += len(strconv.Itoa(size))
+	record := strconv.Itoa(size) + " " + key + "=" + value + "\n"
+	if len(record) != size {
+		// The length grew a digit by counting itself
+		record = strconv.Itoa(len(record)) + " " + key + "=" + value + "\n"
+	}
+	return record
+}
+
+// ustarBlock encodes a USTAR header block. Names longer than the field are
+// truncated and numbers that do not fit are left zero, for a PAX record to
+// override.
+func ustarBlock(name string, mode, uid, gid, size, modTime int64, typeflag byte, uname, gname string) []byte {
+	block := make([]byte, sparseBlockSize)
+	copy(block[0:100], name)
+	putOctal(block[100:108], mode)
+	putOctal(block[108:116], uid)
+	putOctal(block[116:124], gid)
+	putOctal(block[124:136], size)
+	putOctal(block[136:148], modTime)
+	block[156] = typeflag
+	copy(block[257:265], "ustar\x0000")
+	if len(uname) <= 32 {
+		copy(block[265:297], uname)
+	}
+	if len(gname) <= 32 {
+		copy(block[297:329], gname)
+	}
+
+	// The checksum is computed with its own field set to spaces
+	copy(block[148:156], "        ")
+	var sum int64
+	for _, b := range block {
+		sum += int64(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	return block
+}
+
+// putOctal writes n to a NUL-terminated octal field, or zero if it does not fit
+func putOctal(field []byte, n int64) {
+	if !fitsOctal(n, len(field)) {
+		n = 0
+	}
+	copy(field, fmt.Sprintf("%0*o", len(field)-1, n))
+	field[len(field)-1] = 0
+}
@@ -0,0 +1,126 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+func TestExportSparseFiles(t *testing.T) {
+	const size = 8 << 20
+	expected := make([]byte, size)
+	copy(expected, "head")
+	copy(expected[4<<20:], "tail")
+	var regionData bytes.Buffer
+	regions, err := readDataRegions(bytes.NewReader(expected), size, &regionData)
+	if err != nil {
+		t.Fatalf("Failed to find data regions: %v", err)
+	}
+
+	// archive/tar cannot write sparse files, so the layer is crafted with the exporter's encoder
+	var layerTar bytes.Buffer
+	tw := tar.NewWriter(&layerTar)
+	if err := tw.WriteHeader(&tar.Header{Name: "var/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		t.Fatalf("Failed to write test header: %v", err)
+	}
+	if err := tw.Flush(); err != nil {
+		t.Fatalf("Failed to flush test tar: %v", err)
+	}
+	header := &tar.Header{Name: "var/db.img", Mode: 0600, Size: size, Uid: 999, ModTime: time.Unix(0, 0)}
+	if err := writeSparseEntry(&layerTar, header, regions, &regionData); err != nil {
+		t.Fatalf("Failed to write sparse test file: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close test tar: %v", err)
+	}
+	data := layerTar.Bytes()
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test layer: %v", err)
+	}
+	image, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/sparse:latest"
+	pushTestImage(t, imageRef, image)
+
+	// Staged data regions are streamed to the staging file, unless the size of
+	// the file exceeds the staging limit
+	stagingDir := t.TempDir()
+	for _, test := range []struct {
+		format TarFormat
+		sparse bool
+		opts   ExportOptions
+	}{
+		{TarFormatPAX, true, ExportOptions{}},
+		{TarFormatGNU, false, ExportOptions{}},
+		{TarFormatPAX, true, ExportOptions{StagingDir: stagingDir}},
+		{TarFormatGNU, false, ExportOptions{StagingDir: stagingDir}},
+		{TarFormatPAX, true, ExportOptions{StagingDir: stagingDir, MaxStagingBytes: size / 2}},
+	} {
+		var output bytes.Buffer
+		opts := test.opts
+		opts.TarFormat = test.format
+		if err := NewImageExporter().ExportImageFilesystemToWriterWithOptions(imageRef, &output, nil, &opts); err != nil {
+			t.Fatalf("Expected no error for %q, got %v", test.format, err)
+		}
+		if sparse := output.Len() < size; sparse != test.sparse {
+			t.Errorf("Expected a sparse %q archive to be %v, got %d bytes", test.format, test.sparse, output.Len())
+		}
+
+		reader := tar.NewReader(bytes.NewReader(output.Bytes()))
+		var found bool
+		for {
+			header, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Failed to read %q archive: %v", test.format, err)
+			}
+			if header.Name != "var/db.img" {
+				continue
+			}
+			found = true
+			content, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("Failed to read var/db.img: %v", err)
+			}
+			if header.Size != size || header.Uid != 999 || !bytes.Equal(content, expected) {
+				t.Errorf("Expected %q to restore %d bytes owned by 999, got %d bytes owned by %d", test.format, size, len(content), header.Uid)
+			}
+		}
+		if !found {
+			t.Errorf("Expected var/db.img in the %q archive", test.format)
+		}
+	}
+}
+
+func TestHoleReader(t *testing.T) {
+	var data bytes.Buffer
+	regions, err := readDataRegions(strings.NewReader(strings.Repeat("\x00", 1024)+"x"+strings.Repeat("\x00", 2000)), 3025, &data)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(regions) != 1 || regions[0] != (dataRegion{offset: 1024, length: 512}) || data.Len() != 512 {
+		t.Fatalf("Expected one block of data at 1024, got %v", regions)
+	}
+	content, err := io.ReadAll(&holeReader{data: &data, regions: regions, size: 3025})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(content) != 3025 || content[1024] != 'x' || bytes.Count(content, []byte{0}) != 3024 {
+		t.Errorf("Expected the original content back, got %d bytes", len(content))
+	}
+}
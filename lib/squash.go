@@ -0,0 +1,205 @@
+package lib
+
+import (
+	"compress/gzip"
+	"crypto"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// SquashOptions controls SquashImage
+type SquashOptions struct {
+	// Platform selects the image of a multi-arch index to squash (default linux/amd64)
+	Platform string
+
+	// EncryptionRecipients encrypts the squashed layer for these public keys,
+	// as ocicrypt does (see LoadEncryptionRecipient). The image is then pushed
+	// with OCI media types.
+	EncryptionRecipients []crypto.PublicKey
+}
+
+// SquashImage flattens every layer of an image into a single layer, the same
+// filesystem ExportImageFilesystem writes, and pushes the result as a new
+// image. Files deleted or overwritten by later layers no longer take up space,
+// which slims images built with many RUN steps.
+//
+// The configuration is kept (entrypoint, command, environment, user, working
+// directory, labels, exposed ports and platform); the layer history is
+// replaced by one entry naming the source. The source may be any reference
+// accepted by ExportImageFilesystem, including docker-archive: and
+// docker-daemon:. The flattened layer is compressed to a temporary file
+// before it is uploaded. With EncryptionRecipients, the layer is encrypted and
+// pushed with the "+encrypted" media type, which WithDecryptionKeys reads back.
+//
+// Parameters:
+//   - srcRef: Image reference to squash (e.g., "registry.com/app:v1")
+//   - dstRef: Destination reference (e.g., "registry.com/app:v1-squashed")
+//   - auth: Optional authentication configuration, used for both registries
+//   - opts: Optional platform selection and encryption recipients
+//
+// Returns:
+//   - string: The digest of the squashed image
+//   - error: If reading, flattening or pushing fails
+//
+// Example:
+//
+//	digest, err := exporter.SquashImage("registry.com/app:v1", "registry.com/app:v1-slim", nil, nil)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+func (e *imageExporter) SquashImage(srcRef, dstRef string, auth *AuthConfig, opts *SquashOptions) (string, error) {
+	if opts == nil {
+		opts = &SquashOptions{}
+	}
+	dst, err := e.parseReference(dstRef)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse image reference %s: %w", dstRef, err)
+	}
+	var platform *v1.Platform
+	if opts.Platform != "" {
+		if platform, err = v1.ParsePlatform(opts.Platform); err != nil {
+			return "", fmt.Errorf("invalid platform %q: %w", opts.Platform, err)
+		}
+	}
+
+	image, err := e.openImage(srcRef, auth, platform)
+	if err != nil {
+		return "", err
+	}
+	configFile, err := image.ConfigFile()
+	if err != nil {
+		return "", fmt.Errorf("failed to get config file: %w", err)
+	}
+	mediaType, err := image.MediaType()
+	if err != nil {
+		return "", fmt.Errorf("failed to read image %s: %w", srcRef, err)
+	}
+	layers, err := image.Layers()
+	if err != nil {
+		return "", fmt.Errorf("failed to get image layers: %w", err)
+	}
+	filesystem, err := e.applyLayers(layers, newWindowsDetector(image))
+	if err != nil {
+		return "", fmt.Errorf("failed to apply layers: %w", err)
+	}
+	e.finalizeFilesystem(filesystem, nil)
+
+	// Compress the flattened filesystem once, for both the digest and the upload
+	file, err := os.CreateTemp("", "imgex-squash-*.tar.gz")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	gz := gzip.NewWriter(file)
+	diffID := sha256.New()
+	err = e.writeFilesystemTar(filesystem, io.MultiWriter(gz, diffID), nil)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write squashed layer: %w", err)
+	}
+
+	encrypt := len(opts.EncryptionRecipients) > 0
+	if encrypt {
+		// Encrypted layer media types are only defined for OCI images
+		mediaType = types.OCIManifestSchema1
+	}
+	layerType, configType := types.DockerLayer, types.DockerConfigJSON
+	if mediaType == types.OCIManifestSchema1 {
+		layerType, configType = types.OCILayer, types.OCIConfigJSON
+	}
+	addendum := mutate.Addendum{}
+	if encrypt {
+		encrypted, annotations, err := encryptSquashedLayer(file.Name(), layerType,
+			v1.Hash{Algorithm: "sha256", Hex: fmt.Sprintf("%x", diffID.Sum(nil))}, opts.EncryptionRecipients)
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(encrypted.path)
+		addendum.Layer, addendum.MediaType, addendum.Annotations = encrypted, encrypted.mediaType, annotations
+	} else {
+		addendum.Layer, err = tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+			return os.Open(file.Name())
+		}, tarball.WithMediaType(layerType))
+		if err != nil {
+			return "", fmt.Errorf("failed to read squashed layer: %w", err)
+		}
+	}
+
+	config := configFile.DeepCopy()
+	config.RootFS = v1.RootFS{Type: "layers"}
+	config.History = nil
+	squashed, err := mutate.ConfigFile(mutate.MediaType(empty.Image, mediaType), config)
+	if err != nil {
+		return "", fmt.Errorf("failed to build squashed image: %w", err)
+	}
+	squashed = mutate.ConfigMediaType(squashed, configType)
+	addendum.History = v1.History{
+		Created:   config.Created,
+		CreatedBy: "imgex squash " + srcRef,
+		Comment:   fmt.Sprintf("%d layers squashed into one", len(layers)),
+	}
+	squashed, err = mutate.Append(squashed, addendum)
+	if err != nil {
+		return "", fmt.Errorf("failed to build squashed image: %w", err)
+	}
+	digest, err := squashed.Digest()
+	if err != nil {
+		return "", fmt.Errorf("failed to build squashed image: %w", err)
+	}
+
+	e.log().Debug("pushing squashed image", "source", srcRef, "destination", dstRef,
+		"layers", len(layers), "digest", digest.String(), "encrypted", encrypt)
+	if err := remote.Write(dst, squashed, e.remoteOptions(auth)...); err != nil {
+		return "", fmt.Errorf("failed to push %s: %w", dst, err)
+	}
+	return digest.String(), nil
+}
+
+// encryptSquashedLayer encrypts the compressed layer at path into a new
+// temporary file, which the caller removes
+func encryptSquashedLayer(path string, layerType types.MediaType, diffID v1.Hash, recipients []crypto.PublicKey) (*encryptedLayer, map[string]string, error) {
+	plain, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read squashed layer: %w", err)
+	}
+	defer plain.Close()
+	file, err := os.CreateTemp("", "imgex-squash-*.tar.gz.enc")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+
+	digest := sha256.New()
+	annotations, err := encryptLayer(io.MultiWriter(file, digest), plain, recipients)
+	var info os.FileInfo
+	if err == nil {
+		info, err = file.Stat()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, nil, fmt.Errorf("failed to encrypt squashed layer: %w", err)
+	}
+	return &encryptedLayer{
+		path:      file.Name(),
+		digest:    v1.Hash{Algorithm: "sha256", Hex: fmt.Sprintf("%x", digest.Sum(nil))},
+		diffID:    diffID,
+		size:      info.Size(),
+		mediaType: encryptedMediaType(layerType),
+	}, annotations, nil
+}
@@ -0,0 +1,187 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestSquashImage(t *testing.T) {
+	host := newTestRegistry(t)
+	image, err := mutate.AppendLayers(empty.Image,
+		newTestLayer(t, testEntry{name: "etc/motd", content: "hello"}, testEntry{name: "tmp/big", content: "build cache"}),
+		newTestLayer(t, testEntry{name: "tmp/.wh.big", typeflag: tar.TypeReg}, testEntry{name: "etc/motd", content: "squashed"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	image, err = mutate.Config(image, v1.Config{Entrypoint: []string{"/app"}, Env: []string{"A=1"}, User: "1000"})
+	if err != nil {
+		t.Fatalf("Failed to set config: %v", err)
+	}
+	pushTestImage(t, host+"/test/app:v1", image)
+
+	exporter := NewImageExporter()
+	var expected bytes.Buffer
+	if err := exporter.ExportImageFilesystemToWriter(host+"/test/app:v1", &expected, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	digest, err := exporter.SquashImage(host+"/test/app:v1", host+"/test/app:v1-squashed", nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	ref, _ := name.ParseReference(host + "/test/app:v1-squashed")
+	squashed, err := remote.Image(ref)
+	if err != nil {
+		t.Fatalf("Expected the squashed image, got %v", err)
+	}
+	if actual, _ := squashed.Digest(); actual.String() != digest {
+		t.Errorf("Expected digest %s, got %s", digest, actual)
+	}
+	layers, _ := squashed.Layers()
+	if len(layers) != 1 {
+		t.Errorf("Expected 1 layer, got %d", len(layers))
+	}
+	config, err := exporter.GetImageConfig(host+"/test/app:v1-squashed", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(config.Entrypoint, []string{"/app"}) || !reflect.DeepEqual(config.Env, []string{"A=1"}) || config.User != "1000" {
+		t.Errorf("Expected the configuration to be kept, got %+v", config)
+	}
+
+	var out bytes.Buffer
+	if err := exporter.ExportImageFilesystemToWriter(host+"/test/app:v1-squashed", &out, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	files := readTestTar(t, out.Bytes())
+	if !reflect.DeepEqual(files, readTestTar(t, expected.Bytes())) {
+		t.Errorf("Expected the flattened filesystem, got %v", files)
+	}
+	if _, ok := files["tmp/big"]; ok {
+		t.Error("Expected the deleted file to be gone")
+	}
+}
+
+func TestSquashImageEncrypted(t *testing.T) {
+	host := newTestRegistry(t)
+	image, err := mutate.AppendLayers(empty.Image,
+		newTestLayer(t, testEntry{name: "etc/motd", content: "hello"}, testEntry{name: "app/secret.conf", content: "old"}),
+		newTestLayer(t, testEntry{name: "app/secret.conf", content: "confidential"}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	pushTestImage(t, host+"/test/app:v1", image)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	// The RSA recipient comes from a certificate, the ECDSA one from a public key
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "imgex test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &rsaKey.PublicKey, rsaKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	dir := t.TempDir()
+	var recipients []crypto.PublicKey
+	for name, block := range map[string]*pem.Block{
+		"cert.pem": {Type: "CERTIFICATE", Bytes: certDER},
+		"ec.pem":   {Type: "PUBLIC KEY", Bytes: pubDER},
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		key, err := LoadEncryptionRecipient(path)
+		if err != nil {
+			t.Fatalf("Failed to load %s: %v", name, err)
+		}
+		recipients = append(recipients, key)
+	}
+
+	exporter := NewImageExporter()
+	var expected bytes.Buffer
+	if err := exporter.ExportImageFilesystemToWriter(host+"/test/app:v1", &expected, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, err = exporter.SquashImage(host+"/test/app:v1", host+"/test/app:v1-enc", nil,
+		&SquashOptions{EncryptionRecipients: recipients})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	ref, _ := name.ParseReference(host + "/test/app:v1-enc")
+	squashed, err := remote.Image(ref)
+	if err != nil {
+		t.Fatalf("Expected the squashed image, got %v", err)
+	}
+	manifest, err := squashed.Manifest()
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if len(manifest.Layers) != 1 {
+		t.Fatalf("Expected 1 layer, got %d", len(manifest.Layers))
+	}
+	desc := manifest.Layers[0]
+	if !strings.HasSuffix(string(desc.MediaType), "+encrypted") {
+		t.Errorf("Expected an encrypted layer media type, got %s", desc.MediaType)
+	}
+	if desc.Annotations[AnnotationEncryptionKeysJWE] == "" || desc.Annotations[AnnotationEncryptionPubOpts] == "" {
+		t.Errorf("Expected the encryption annotations, got %v", desc.Annotations)
+	}
+
+	// Each recipient decrypts the same filesystem through the decryption path
+	for _, key := range []crypto.PrivateKey{rsaKey, ecKey} {
+		var out bytes.Buffer
+		err := NewImageExporter(WithDecryptionKeys(key)).ExportImageFilesystemToWriter(host+"/test/app:v1-enc", &out, nil)
+		if err != nil {
+			t.Fatalf("Expected no error decrypting with %T, got %v", key, err)
+		}
+		if files := readTestTar(t, out.Bytes()); !reflect.DeepEqual(files, readTestTar(t, expected.Bytes())) {
+			t.Errorf("Expected the flattened filesystem with %T, got %v", key, files)
+		}
+	}
+
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	err = NewImageExporter(WithDecryptionKeys(other)).ExportImageFilesystemToWriter(host+"/test/app:v1-enc", io.Discard, nil)
+	if !errors.Is(err, ErrNoDecryptionKey) {
+		t.Errorf("Expected ErrNoDecryptionKey, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// stagingArea spools file contents to a single temporary file on disk so that
+// large images can be flattened without holding every file in memory.
+// Using one append-only file keeps the export to a single extra file handle.
+type stagingArea struct {
+	file  *os.File
+	size  int64 // bytes written so far
+	limit int64 // maximum bytes to write, 0 for unlimited
+}
+
+// newStagingArea creates a staging file in dir (or the system temp dir if empty)
+func newStagingArea(dir string, limit int64) (*stagingArea, error) {
+	file, err := os.CreateTemp(dir, "imgex-staging-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging file: %w", err)
+	}
+	return &stagingArea{file: file, limit: limit}, nil
+}
+
+// fits reports whether size more bytes can be staged without exceeding the limit
+func (s *stagingArea) fits(size int64) bool {
+	return s.limit <= 0 || s.size+size <= s.limit
+}
+
+// stage copies exactly size bytes from r into the staging file and returns
+// the offset at which they were written
+func (s *stagingArea) stage(r io.Reader, size int64) (int64, error) {
+	offset := s.size
+	written, err := io.CopyN(s.file, r, size)
+	s.size += written
+	if err != nil {
+		return 0, fmt.Errorf("failed to stage file data: %w", err)
+	}
+	return offset, nil
+}
+
+// Write appends p to the staging file, for content whose size is only known
+// once staged
+func (s *stagingArea) Write(p []byte) (int, error) {
+	n, err := s.file.Write(p)
+	s.size += int64(n)
+	return n, err
+}
+
+// reader returns a reader over previously staged bytes
+func (s *stagingArea) reader(offset, size int64) io.Reader {
+	return io.NewSectionReader(s.file, offset, size)
+}
+
+// Close closes and removes the staging file
+func (s *stagingArea) Close() error {
+	closeErr := s.file.Close()
+	if err := os.Remove(s.file.Name()); err != nil {
+		return fmt.Errorf("failed to remove staging file: %w", err)
+	}
+	return closeErr
+}
@@ -0,0 +1,68 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestExportWithStaging(t *testing.T) {
+	base := newTestLayer(t,
+		testEntry{name: "etc/", typeflag: tar.TypeDir},
+		testEntry{name: "etc/hostname", content: "base"},
+		testEntry{name: "etc/motd", content: "welcome"},
+	)
+	top := newTestLayer(t,
+		testEntry{name: "etc/hostname", content: "overridden"},
+		testEntry{name: "etc/large", content: "this file does not fit in the staging budget"},
+	)
+	image, err := mutate.AppendLayers(empty.Image, base, top)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+
+	host := newTestRegistry(t)
+	imageRef := host + "/test/staging:latest"
+	pushTestImage(t, imageRef, image)
+
+	stagingDir := t.TempDir()
+	var warnings WarningCollector
+	var buf bytes.Buffer
+	exporter := NewImageExporter()
+	err = exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &buf, nil, &ExportOptions{
+		StagingDir:      stagingDir,
+		MaxStagingBytes: 32,
+		Warning:         warnings.Collect,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	files := readTestTar(t, buf.Bytes())
+	expected := map[string]string{
+		"etc/hostname": "overridden",
+		"etc/motd":     "welcome",
+		"etc/large":    "this file does not fit in the staging budget",
+	}
+	for name, content := range expected {
+		if files[name] != content {
+			t.Errorf("Expected %s to contain %q, got %q", name, content, files[name])
+		}
+	}
+
+	if got := warningCodes(warnings.Warnings()); got[WarningStagingLimit] != 1 {
+		t.Errorf("Expected 1 staging limit warning, got %v", warnings.Warnings())
+	}
+
+	remaining, err := os.ReadDir(stagingDir)
+	if err != nil {
+		t.Fatalf("Failed to read staging dir: %v", err)
+	}
+	if len(remaining) != 0 {
+		t.Errorf("Expected staging file to be removed, found %d entries", len(remaining))
+	}
+}
@@ -0,0 +1,190 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrWriteTimeout is returned by exports whose destination accepted no data
+// for ExportOptions.WriteTimeout
+var ErrWriteTimeout = errors.New("write timeout")
+
+// stallBufferSize is how much output is buffered in memory while the
+// destination is slow, so that short pauses of the reader do not stall the export
+const stallBufferSize = 4 << 20
+
+// stallWriter hands writes to a goroutine through a bounded buffer, so that a
+// destination that stops reading (a stalled pipe, a hung NFS mount) is noticed
+// and reported instead of blocking the export forever. A write to the
+// destination cannot be interrupted: after a timeout the goroutine stays
+// blocked until the destination returns.
+type stallWriter struct {
+	dest      io.Writer
+	timeout   time.Duration
+	warnAfter time.Duration
+	warn      func(Warning)
+	limit     int
+
+	mu       sync.Mutex
+	queue    [][]byte
+	buffered int       // bytes in queue and in the write in flight
+	since    time.Time // start of the write in flight, zero when idle
+	warnings int       // stall warnings reported for the write in flight
+	err      error     // first failure, returned by every later call
+	closing  bool
+	changed  chan struct{} // closed and replaced on every state change
+	stopped  chan struct{} // closed when the monitor should exit
+}
+
+// newStallWriter starts watching writes to dest, failing them once dest has
+// been blocked for timeout and warning every warnAfter (either may be 0)
+func newStallWriter(dest io.Writer, timeout, warnAfter time.Duration, warn func(Warning)) *stallWriter {
+	w := &stallWriter{
+		dest:      dest,
+		timeout:   timeout,
+		warnAfter: warnAfter,
+		warn:      warn,
+		limit:     stallBufferSize,
+		changed:   make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go w.run()
+	go w.monitor()
+	return w
+}
+
+// notify wakes everything waiting for a state change; the lock must be held
+func (w *stallWriter) notify() {
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// wait releases the lock until the next state change; the lock must be held
+func (w *stallWriter) wait() {
+	changed := w.changed
+	w.mu.Unlock()
+	<-changed
+	w.mu.Lock()
+}
+
+// Write queues a copy of p, waiting while the buffer is full
+func (w *stallWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.err == nil && w.buffered > 0 && w.buffered+len(p) > w.limit {
+		w.wait()
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.queue = append(w.queue, append([]byte(nil), p...))
+	w.buffered += len(p)
+	w.notify()
+	return len(p), nil
+}
+
+// flush waits until the destination has accepted everything, then stops
+func (w *stallWriter) flush() error {
+	w.mu.Lock()
+	for w.err == nil && w.buffered > 0 {
+		w.wait()
+	}
+	w.mu.Unlock()
+	w.stop()
+	return w.err
+}
+
+// stop ends the goroutines without waiting for the buffer to drain
+func (w *stallWriter) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closing {
+		w.closing = true
+		w.queue = nil
+		close(w.stopped)
+		w.notify()
+	}
+}
+
+// run copies queued data to the destination
+func (w *stallWriter) run() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for {
+		for len(w.queue) == 0 && !w.closing && w.err == nil {
+			w.wait()
+		}
+		if len(w.queue) == 0 || w.err != nil {
+			return
+		}
+		chunk := w.queue[0]
+		w.queue = w.queue[1:]
+		w.since, w.warnings = time.Now(), 0
+		w.mu.Unlock()
+
+		_, err := w.dest.Write(chunk)
+
+		w.mu.Lock()
+		w.since = time.Time{}
+		w.buffered -= len(chunk)
+		if err != nil && w.err == nil {
+			w.err = err
+		}
+		w.notify()
+	}
+}
+
+// monitor warns about and times out a write in flight that makes no progress
+func (w *stallWriter) monitor() {
+	interval := time.Second
+	for _, limit := range []time.Duration{w.timeout / 4, w.warnAfter / 4} {
+		if limit > 0 && limit < interval {
+			interval = limit
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stopped:
+			return
+		case <-ticker.C:
+		}
+
+		w.mu.Lock()
+		var warning *Warning
+		if !w.since.IsZero() && w.err == nil {
+			stalled := time.Since(w.since)
+			switch {
+			case w.timeout > 0 && stalled >= w.timeout:
+				w.err = fmt.Errorf("output accepted no data for %s with %s buffered: %w",
+					stalled.Round(time.Second), formatBufferSize(w.buffered), ErrWriteTimeout)
+				w.notify()
+			case w.warnAfter > 0 && stalled >= time.Duration(w.warnings+1)*w.warnAfter:
+				w.warnings++
+				warning = &Warning{
+					Code: WarningOutputStalled,
+					Message: fmt.Sprintf("output accepted no data for %s, %s of %s buffered; is the reader stalled?",
+						stalled.Round(time.Second), formatBufferSize(w.buffered), formatBufferSize(w.limit)),
+				}
+			}
+		}
+		w.mu.Unlock()
+
+		if warning != nil && w.warn != nil {
+			w.warn(*warning)
+		}
+	}
+}
+
+// formatBufferSize formats a byte count for stall messages
+func formatBufferSize(n int) string {
+	if n < 1<<20 {
+		return fmt.Sprintf("%d KiB", n>>10)
+	}
+	return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+}
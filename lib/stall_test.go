@@ -0,0 +1,102 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// blockingWriter blocks every write until release is closed
+type blockingWriter struct {
+	release chan struct{}
+	buf     bytes.Buffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return w.buf.Write(p)
+}
+
+func TestStallWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := newStallWriter(&out, time.Second, time.Second, nil)
+	for _, chunk := range []string{"hello ", "stalled ", "world"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if err := w.flush(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if out.String() != "hello stalled world" {
+		t.Errorf("Expected all data in order, got %q", out.String())
+	}
+
+	// A reader that pauses gets warned about, then released
+	var warnings WarningCollector
+	dest := &blockingWriter{release: make(chan struct{})}
+	w = newStallWriter(dest, 0, 20*time.Millisecond, warnings.Collect)
+	if _, err := w.Write([]byte("slow")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(dest.release)
+	if err := w.flush(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	collected := warnings.Warnings()
+	if len(collected) == 0 || collected[0].Code != WarningOutputStalled {
+		t.Errorf("Expected output_stalled warnings, got %v", collected)
+	}
+	if dest.buf.String() != "slow" {
+		t.Errorf("Expected the data once released, got %q", dest.buf.String())
+	}
+
+	// A reader that never returns times out, with writes failing once the buffer is full
+	dest = &blockingWriter{release: make(chan struct{})}
+	defer close(dest.release)
+	w = newStallWriter(dest, 50*time.Millisecond, 0, nil)
+	w.limit = 8
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		_, err = w.Write([]byte("12345678"))
+	}
+	if !errors.Is(err, ErrWriteTimeout) {
+		t.Errorf("Expected ErrWriteTimeout from Write, got %v", err)
+	}
+	if err := w.flush(); !errors.Is(err, ErrWriteTimeout) {
+		t.Errorf("Expected ErrWriteTimeout from flush, got %v", err)
+	}
+}
+
+func TestExportWriteTimeout(t *testing.T) {
+	host := newTestRegistry(t)
+	image, err := mutate.AppendLayers(empty.Image, newTestLayer(t, testEntry{name: "data", content: strings.Repeat("x", 1024)}))
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	imageRef := host + "/test/stall:latest"
+	pushTestImage(t, imageRef, image)
+
+	dest := &blockingWriter{release: make(chan struct{})}
+	defer close(dest.release)
+	exporter := NewImageExporter()
+	err = exporter.ExportImageFilesystemToWriterWithOptions(imageRef, dest, nil, &ExportOptions{WriteTimeout: 50 * time.Millisecond})
+	if !errors.Is(err, ErrWriteTimeout) {
+		t.Errorf("Expected ErrWriteTimeout, got %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &out, nil, &ExportOptions{WriteTimeout: time.Minute}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	files := readTestTar(t, out.Bytes())
+	if files["data"] != strings.Repeat("x", 1024) {
+		t.Errorf("Expected the data file in the archive, got %v", files)
+	}
+}
@@ -0,0 +1,343 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// errNotLazy is returned when an image cannot be read lazily, and its layers
+// have to be downloaded instead
+var errNotLazy = errors.New("image cannot be read lazily")
+
+// lazyFilesystem builds the filesystem of an image whose layers are all eStargz
+// (layers carrying a table of contents, announced by the toc.digest annotation)
+// without downloading the layers. Only the footer and table of contents of
+// each layer are fetched, with HTTP range requests, and file contents are
+// fetched one file at a time when they are read.
+//
+// Images that are not all eStargz, offline exports and exporters with a blob
+// cache return errNotLazy: a cache stores whole layers for later exports, so
+// it keeps downloading them. Registries that ignore range requests, and tables
+// of contents without the digest of a file, also return errNotLazy, before any
+// layer has been downloaded.
+func (e *imageExporter) lazyFilesystem(image v1.Image, auth *AuthConfig) (map[string]*fileEntry, error) {
+	if e.offline || e.cache != nil {
+		return nil, errNotLazy
+	}
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image layers: %w", err)
+	}
+	if len(layers) == 0 {
+		return nil, errNotLazy
+	}
+
+	clients := make(map[string]*http.Client)
+	stargz := make([]*stargzLayer, len(layers))
+	for i, layer := range layers {
+		if stargz[i], err = e.openStargzLayer(layer, auth, clients); err != nil {
+			return nil, err
+		}
+	}
+
+	filesystem := make(map[string]*fileEntry)
+	paths := newPathTrie()
+	windows := newWindowsDetector(image)
+	for i, layer := range stargz {
+		headers, err := layer.headers()
+		if err != nil {
+			return nil, err
+		}
+		err = e.applyLayer(filesystem, paths, headers, i, windows, &ExportOptions{}, nil, func(header *tar.Header, _ io.Reader) (*fileEntry, error) {
+			entry := &fileEntry{header: header}
+			if header.Typeflag != tar.TypeReg {
+				return entry, nil
+			}
+			file, ok := layer.files[strings.ReplaceAll(header.Name, `\`, "/")]
+			if !ok {
+				return nil, fmt.Errorf("layer %d has no table of contents entry for %s", i, header.Name)
+			}
+			header.Size = file.Size
+			if file.Size > 0 {
+				entry.lazy = layer.opener(file)
+			}
+			return entry, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	e.finalizeFilesystem(filesystem, nil)
+	return filesystem, nil
+}
+
+// stargzLayer is the table of contents of a remote eStargz layer
+type stargzLayer struct {
+	blob   *rangeBlob
+	reader *estargz.Reader
+	files  map[string]*estargz.TOCEntry // regular files by synthesized tar name
+}
+
+// openStargzLayer reads the table of contents of a remote eStargz layer,
+// verifying it against the digest in the layer descriptor. clients holds the
+// registry clients already authenticated, by repository.
+func (e *imageExporter) openStargzLayer(layer v1.Layer, auth *AuthConfig, clients map[string]*http.Client) (*stargzLayer, error) {
+	mountable, ok := layer.(*remote.MountableLayer)
+	if !ok {
+		return nil, errNotLazy
+	}
+	desc, err := partial.Descriptor(layer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get layer descriptor: %w", err)
+	}
+	tocDigest := desc.Annotations[estargz.TOCJSONDigestAnnotation]
+	if tocDigest == "" || (desc.MediaType != types.DockerLayer && desc.MediaType != types.OCILayer) {
+		return nil, errNotLazy
+	}
+	if _, ok := lookupLayerHandler(string(desc.MediaType)); ok {
+		return nil, errNotLazy
+	}
+
+	repo := mountable.Reference.Context()
+	client, ok := clients[repo.String()]
+	if !ok {
+		if client, err = e.repositoryClient(repo, auth); err != nil {
+			return nil, err
+		}
+		clients[repo.String()] = client
+	}
+
+	blob := &rangeBlob{
+		client: client,
+		url:    fmt.Sprintf("%s://%s/v2/%s/blobs/%s", repo.Scheme(), repo.RegistryStr(), repo.RepositoryStr(), desc.Digest),
+		size:   desc.Size,
+	}
+	reader, err := estargz.Open(io.NewSectionReader(blob, 0, blob.size))
+	if err != nil && blob.noRanges {
+		// estargz does not wrap the errors of the blob
+		return nil, fmt.Errorf("%s does not support range requests: %w", repo.RegistryStr(), errNotLazy)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read table of contents of layer %s: %w", desc.Digest, err)
+	}
+	if reader.TOCDigest().String() != tocDigest {
+		return nil, fmt.Errorf("table of contents of layer %s: %w", desc.Digest, errDigestMismatch)
+	}
+	e.log().Debug("reading layer lazily", "digest", desc.Digest.String(), "toc", tocDigest)
+	return &stargzLayer{blob: blob, reader: reader}, nil
+}
+
+// headers returns a tar stream of the entries of the table of contents, with
+// empty regular files. Each directory lists its whiteouts first, so that an
+// opaque directory hides the files of lower layers only.
+func (l *stargzLayer) headers() (io.Reader, error) {
+	root, ok := l.reader.Lookup("")
+	if !ok {
+		return nil, fmt.Errorf("table of contents has no root directory")
+	}
+	l.files = make(map[string]*estargz.TOCEntry)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := l.writeChildren(tw, root, ""); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// writeChildren writes the headers of the entries below dir
+func (l *stargzLayer) writeChildren(tw *tar.Writer, dir *estargz.TOCEntry, dirName string) error {
+	var names []string
+	dir.ForeachChild(func(name string, _ *estargz.TOCEntry) bool {
+		names = append(names, name)
+		return true
+	})
+	sort.Slice(names, func(i, j int) bool {
+		iWhiteout, jWhiteout := strings.HasPrefix(names[i], ".wh."), strings.HasPrefix(names[j], ".wh.")
+		if iWhiteout != jWhiteout {
+			return iWhiteout
+		}
+		return names[i] < names[j]
+	})
+
+	for _, base := range names {
+		ent, _ := dir.LookupChild(base)
+		name := path.Join(dirName, base)
+		header := &tar.Header{
+			Name:     name,
+			Mode:     ent.Mode,
+			Uid:      ent.UID,
+			Gid:      ent.GID,
+			Uname:    ent.Uname,
+			Gname:    ent.Gname,
+			ModTime:  ent.ModTime(),
+			Devmajor: int64(ent.DevMajor),
+			Devminor: int64(ent.DevMinor),
+			Format:   tar.FormatPAX,
+		}
+		for key, value := range ent.Xattrs {
+			if header.PAXRecords == nil {
+				header.PAXRecords = make(map[string]string)
+			}
+			header.PAXRecords["SCHILY.xattr."+key] = string(value)
+		}
+		switch ent.Type {
+		case "dir":
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+		case "reg":
+			// Content fetched by range bypasses the digest of the blob, so a
+			// file is only read lazily when its own digest can verify it
+			if ent.Size > 0 && ent.Digest == "" {
+				return fmt.Errorf("%s has no digest in the table of contents: %w", name, errNotLazy)
+			}
+			// Hardlinks are listed as their target, and become copies of it
+			header.Typeflag = tar.TypeReg
+			l.files[strings.ReplaceAll(name, `\`, "/")] = ent
+		case "symlink":
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = ent.LinkName
+		case "char":
+			header.Typeflag = tar.TypeChar
+		case "block":
+			header.Typeflag = tar.TypeBlock
+		case "fifo":
+			header.Typeflag = tar.TypeFifo
+		default:
+			return fmt.Errorf("unknown table of contents entry type %q for %s", ent.Type, name)
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to list %s: %w", name, err)
+		}
+		if ent.Type == "dir" {
+			if err := l.writeChildren(tw, ent, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// opener returns a function fetching the content of a regular file: the
+// compressed chunks of the file are fetched with a single range request and
+// verified against the digest of the file
+func (l *stargzLayer) opener(file *estargz.TOCEntry) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		last, ok := l.reader.ChunkEntryForOffset(file.Name, file.Size-1)
+		if !ok {
+			return nil, fmt.Errorf("table of contents has no chunk at the end of %s", file.Name)
+		}
+		compressed, err := l.blob.open(file.Offset, last.NextOffset()-file.Offset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %w", file.Name, err)
+		}
+		gz, err := gzip.NewReader(compressed)
+		if err != nil {
+			compressed.Close()
+			return nil, fmt.Errorf("failed to open %s: %w", file.Name, err)
+		}
+		if _, err := io.CopyN(io.Discard, gz, file.InnerOffset); err != nil {
+			compressed.Close()
+			return nil, fmt.Errorf("failed to read %s: %w", file.Name, err)
+		}
+		digest, err := v1.NewHash(file.Digest)
+		if err != nil {
+			compressed.Close()
+			return nil, fmt.Errorf("invalid digest of %s: %w", file.Name, err)
+		}
+		content := &readCloser{Reader: io.LimitReader(gz, file.Size), close: compressed.Close}
+		return verifiedReader(content, file.Size, digest), nil
+	}
+}
+
+// rangeBlob is a registry blob read with HTTP range requests
+type rangeBlob struct {
+	client   *http.Client
+	url      string
+	size     int64
+	noRanges bool // set once the registry answered with the whole blob
+}
+
+// open fetches length bytes of the blob from offset. Registries answering with
+// the whole blob instead of the range fail with errNotLazy.
+func (b *rangeBlob) open(offset, length int64) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, b.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		resp.Body.Close()
+		b.noRanges = true
+		return nil, fmt.Errorf("%s does not support range requests: %w", req.URL.Host, errNotLazy)
+	}
+	if err := transport.CheckError(resp, http.StatusPartialContent); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return &readCloser{Reader: io.LimitReader(resp.Body, length), close: resp.Body.Close}, nil
+}
+
+// ReadAt implements io.ReaderAt
+func (b *rangeBlob) ReadAt(p []byte, offset int64) (int, error) {
+	if offset >= b.size {
+		return 0, io.EOF
+	}
+	length := min(int64(len(p)), b.size-offset)
+	body, err := b.open(offset, length)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	n, err := io.ReadFull(body, p[:length])
+	if err == nil && length < int64(len(p)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// lazyReader opens content on the first read and closes it at the end
+type lazyReader struct {
+	open func() (io.ReadCloser, error)
+	body io.ReadCloser
+	err  error
+}
+
+// Read implements io.Reader
+func (r *lazyReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.body == nil {
+		if r.body, r.err = r.open(); r.err != nil {
+			return 0, r.err
+		}
+	}
+	n, err := r.body.Read(p)
+	if err != nil {
+		r.body.Close()
+		r.err = err
+	}
+	return n, err
+}
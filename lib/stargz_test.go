@@ -0,0 +1,295 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/opencontainers/go-digest"
+)
+
+// newTestStargzAddendum builds an eStargz layer from the given entries, with
+// the toc.digest annotation registries use to announce it
+func newTestStargzAddendum(t *testing.T, entries ...testEntry) mutate.Addendum {
+	t.Helper()
+	return newTestStargzAddendumWith(t, testStargzCompressor{GzipCompressor: estargz.NewGzipCompressor()}, entries...)
+}
+
+// newTestStargzAddendumWith builds an eStargz layer with the given compressor
+func newTestStargzAddendumWith(t *testing.T, compressor testStargzCompressor, entries ...testEntry) mutate.Addendum {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		typeflag := entry.typeflag
+		if typeflag == 0 {
+			typeflag = tar.TypeReg
+		}
+		header := &tar.Header{Name: entry.name, Typeflag: typeflag, Linkname: entry.linkname, Mode: 0644}
+		if typeflag == tar.TypeReg {
+			header.Size = int64(len(entry.content))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("Failed to write test header %s: %v", entry.name, err)
+		}
+		tw.Write([]byte(entry.content))
+	}
+	tw.Close()
+
+	var blob bytes.Buffer
+	w := estargz.NewWriterWithCompressor(&blob, compressor)
+	w.ChunkSize = 64 << 10
+	if err := w.AppendTar(&buf); err != nil {
+		t.Fatalf("Failed to build eStargz layer: %v", err)
+	}
+	tocDigest, err := w.Close()
+	if err != nil {
+		t.Fatalf("Failed to build eStargz layer: %v", err)
+	}
+	data := blob.Bytes()
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create eStargz layer: %v", err)
+	}
+	return mutate.Addendum{
+		Layer:       layer,
+		Annotations: map[string]string{estargz.TOCJSONDigestAnnotation: tocDigest.String()},
+	}
+}
+
+// testStargzCompressor writes the eStargz footer by hand: the estargz writer
+// expects the 51 bytes the compress/flate of older Go releases produced for an
+// empty stored block, and panics with later ones
+type testStargzCompressor struct {
+	*estargz.GzipCompressor
+
+	// noDigests leaves the digests of files out of the table of contents
+	noDigests bool
+}
+
+// WriteTOCAndFooter implements estargz.Compressor
+func (c testStargzCompressor) WriteTOCAndFooter(w io.Writer, off int64, toc *estargz.JTOC, diffHash hash.Hash) (digest.Digest, error) {
+	if c.noDigests {
+		for _, entry := range toc.Entries {
+			entry.Digest = ""
+		}
+	}
+	tocJSON, err := json.Marshal(toc)
+	if err != nil {
+		return "", err
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(io.MultiWriter(gz, diffHash))
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: estargz.TOCTarName, Size: int64(len(tocJSON))})
+	tw.Write(tocJSON)
+	tw.Close()
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+
+	// gzip header with an extra field holding the TOC offset, followed by an
+	// empty stored block, CRC-32 and size
+	subfield := fmt.Sprintf("%016xSTARGZ", off)
+	footer := []byte{0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 0xff, 4 + byte(len(subfield)), 0, 'S', 'G', byte(len(subfield)), 0}
+	footer = append(footer, subfield...)
+	footer = append(footer, 1, 0, 0, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 0)
+	if _, err := w.Write(footer); err != nil {
+		return "", err
+	}
+	return digest.FromBytes(tocJSON), nil
+}
+
+// newCountingRegistry starts a test registry counting the blob bytes it
+// serves. Without ranges, it ignores Range headers like some registries do.
+func newCountingRegistry(t *testing.T, ranges bool) (string, *atomic.Int64) {
+	t.Helper()
+	var served atomic.Int64
+	handler := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ranges {
+			r.Header.Del("Range")
+		}
+		if r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/blobs/") {
+			w = &countingResponseWriter{ResponseWriter: w, served: &served}
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse registry URL: %v", err)
+	}
+	return u.Host, &served
+}
+
+// countingResponseWriter counts the bytes of a response body
+type countingResponseWriter struct {
+	http.ResponseWriter
+	served *atomic.Int64
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	w.served.Add(int64(len(p)))
+	return w.ResponseWriter.Write(p)
+}
+
+// TestOpenFileStargz tests that files of eStargz images are read without
+// downloading whole layers, and that whiteouts still apply
+func TestOpenFileStargz(t *testing.T) {
+	large := make([]byte, 1<<20)
+	rand.Read(large)
+	image, err := mutate.Append(empty.Image,
+		newTestStargzAddendum(t,
+			testEntry{name: "etc/", typeflag: tar.TypeDir},
+			testEntry{name: "etc/hostname", content: "base\n"},
+			testEntry{name: "etc/removed", content: "gone\n"},
+			testEntry{name: "data/", typeflag: tar.TypeDir},
+			testEntry{name: "data/large.bin", content: string(large)},
+		),
+		newTestStargzAddendum(t,
+			testEntry{name: "etc/", typeflag: tar.TypeDir},
+			testEntry{name: "etc/.wh.removed"},
+			testEntry{name: "etc/hostname", content: "top\n"},
+			testEntry{name: "etc/link", typeflag: tar.TypeSymlink, linkname: "hostname"},
+		),
+	)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+
+	readFile := func(t *testing.T, exporter ImageExporter, imageRef, path string) string {
+		t.Helper()
+		content, _, err := exporter.OpenFile(imageRef, path, nil, nil)
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", path, err)
+		}
+		defer content.Close()
+		data, err := io.ReadAll(content)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		return string(data)
+	}
+
+	t.Run("ranges", func(t *testing.T) {
+		host, served := newCountingRegistry(t, true)
+		imageRef := host + "/test/stargz:latest"
+		pushTestImage(t, imageRef, image)
+		exporter := NewImageExporter()
+
+		served.Store(0)
+		if got := readFile(t, exporter, imageRef, "/etc/link"); got != "top\n" {
+			t.Errorf("Expected top layer content, got %q", got)
+		}
+		if n := served.Load(); n > 256<<10 {
+			t.Errorf("Expected only the tables of contents and one file to be fetched, got %d bytes", n)
+		}
+
+		if got := readFile(t, exporter, imageRef, "data/large.bin"); got != string(large) {
+			t.Errorf("Expected chunked file content to match, got %d bytes", len(got))
+		}
+		if _, _, err := exporter.OpenFile(imageRef, "etc/removed", nil, nil); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Expected whiteout to remove etc/removed, got %v", err)
+		}
+	})
+
+	t.Run("no digests", func(t *testing.T) {
+		image, err := mutate.Append(empty.Image, newTestStargzAddendumWith(t,
+			testStargzCompressor{GzipCompressor: estargz.NewGzipCompressor(), noDigests: true},
+			testEntry{name: "etc/hostname", content: "unverified\n"},
+		))
+		if err != nil {
+			t.Fatalf("Failed to build test image: %v", err)
+		}
+		host, _ := newCountingRegistry(t, true)
+		imageRef := host + "/test/nodigests:latest"
+		pushTestImage(t, imageRef, image)
+		exporter := NewImageExporter()
+
+		// Files without a digest are read from the whole, verified layer
+		remote, err := exporter.(*imageExporter).openImage(imageRef, nil, nil)
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", imageRef, err)
+		}
+		if _, err := exporter.(*imageExporter).lazyFilesystem(remote, nil); !errors.Is(err, errNotLazy) {
+			t.Errorf("Expected files without a digest to be read eagerly, got %v", err)
+		}
+		if got := readFile(t, exporter, imageRef, "etc/hostname"); got != "unverified\n" {
+			t.Errorf("Expected fallback to downloading layers, got %q", got)
+		}
+	})
+
+	t.Run("no ranges", func(t *testing.T) {
+		host, _ := newCountingRegistry(t, false)
+		imageRef := host + "/test/stargz:latest"
+		pushTestImage(t, imageRef, image)
+
+		if got := readFile(t, NewImageExporter(), imageRef, "/etc/hostname"); got != "top\n" {
+			t.Errorf("Expected fallback to downloading layers, got %q", got)
+		}
+	})
+}
+
+// TestStargzLayerHeaders tests that directories list their whiteouts first
+func TestStargzLayerHeaders(t *testing.T) {
+	addendum := newTestStargzAddendum(t,
+		testEntry{name: "a/", typeflag: tar.TypeDir},
+		testEntry{name: "a/b", content: "b"},
+		testEntry{name: "a/.wh..wh..opq"},
+	)
+	blob, err := addendum.Layer.Compressed()
+	if err != nil {
+		t.Fatalf("Failed to open layer: %v", err)
+	}
+	data, _ := io.ReadAll(blob)
+	reader, err := estargz.Open(io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))))
+	if err != nil {
+		t.Fatalf("Failed to open table of contents: %v", err)
+	}
+
+	layer := &stargzLayer{reader: reader}
+	headers, err := layer.headers()
+	if err != nil {
+		t.Fatalf("Failed to list headers: %v", err)
+	}
+	var names []string
+	tr := tar.NewReader(headers)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read headers: %v", err)
+		}
+		names = append(names, header.Name)
+	}
+	if got := strings.Join(names, " "); got != "a/ a/.wh..wh..opq a/b" {
+		t.Errorf("Expected a/ a/.wh..wh..opq a/b, got %s", got)
+	}
+	if _, ok := layer.files["a/b"]; !ok {
+		t.Errorf("Expected a/b to be indexed, got %v", layer.files)
+	}
+}
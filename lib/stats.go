@@ -0,0 +1,86 @@
+package lib
+
+import (
+	"archive/tar"
+	"time"
+)
+
+// ExportStats summarizes an export, see ExportOptions.Stats
+type ExportStats struct {
+	// SchemaVersion is the version of this JSON document (see SchemaVersion)
+	SchemaVersion int `json:"schema_version"`
+
+	// Entries is the number of entries written to the archive
+	Entries int `json:"entries"`
+
+	// Files is the number of regular files of the archive
+	Files int `json:"files"`
+
+	// Directories is the number of directories of the archive
+	Directories int `json:"directories"`
+
+	// Bytes is the total size of the regular files of the archive,
+	// uncompressed
+	Bytes int64 `json:"bytes"`
+
+	// Whiteouts is the number of whiteouts the layers applied
+	Whiteouts int `json:"whiteouts"`
+
+	// Layers lists what each layer contributed to the archive, in order
+	Layers []LayerStats `json:"layers"`
+
+	// DurationMS is the time the export took, in milliseconds
+	DurationMS int64 `json:"duration_ms"`
+}
+
+// LayerStats is the contribution of one layer to an export
+type LayerStats struct {
+	// Index is the zero-based index of the layer in the image
+	Index int `json:"index"`
+
+	// Digest is the digest of the layer
+	Digest string `json:"digest"`
+
+	// Entries is the number of entries of the archive the layer provided, not
+	// counting those a later layer replaced or deleted
+	Entries int `json:"entries"`
+
+	// Bytes is the total size of the regular files of the archive the layer
+	// provided
+	Bytes int64 `json:"bytes"`
+
+	// Whiteouts is the number of whiteouts of the layer
+	Whiteouts int `json:"whiteouts"`
+}
+
+// StatsCallback is called once an export succeeds, with its summary
+type StatsCallback func(stats *ExportStats)
+
+// countEntries fills the entry counts of stats from the filesystem as written,
+// leaving out the special files of the DevicesSkip policy. Entries no layer
+// provided, such as synthesized parent directories, count for no layer.
+func countEntries(stats *ExportStats, filesystem map[string]*fileEntry, devices DevicePolicy, start time.Time) {
+	for _, entry := range filesystem {
+		if devices == DevicesSkip && isSpecialFile(entry.header.Typeflag) {
+			continue
+		}
+		var size int64
+		switch entry.header.Typeflag {
+		case tar.TypeReg:
+			size = entry.header.Size
+			stats.Files++
+		case tar.TypeDir:
+			stats.Directories++
+		}
+		stats.Entries++
+		stats.Bytes += size
+		if entry.layer >= 0 && entry.layer < len(stats.Layers) {
+			stats.Layers[entry.layer].Entries++
+			stats.Layers[entry.layer].Bytes += size
+		}
+	}
+	for _, layer := range stats.Layers {
+		stats.Whiteouts += layer.Whiteouts
+	}
+	stats.DurationMS = time.Since(start).Milliseconds()
+}
@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestExportStats(t *testing.T) {
+	image, err := mutate.AppendLayers(empty.Image,
+		newTestLayer(t,
+			testEntry{name: "etc/", typeflag: tar.TypeDir, mode: 0755},
+			testEntry{name: "etc/hosts", content: "hosts"},
+			testEntry{name: "etc/old", content: "old"},
+		),
+		newTestLayer(t,
+			testEntry{name: "etc/.wh.old"},
+			testEntry{name: "usr/bin/app", content: "application"},
+			testEntry{name: "usr/bin/run", typeflag: tar.TypeSymlink, linkname: "app"},
+		),
+	)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/stats:latest"
+	pushTestImage(t, imageRef, image)
+
+	var stats *ExportStats
+	exporter := NewImageExporter()
+	opts := &ExportOptions{Stats: func(s *ExportStats) { stats = s }}
+	if err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, io.Discard, nil, opts); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if stats == nil {
+		t.Fatal("Expected the stats callback to be called")
+	}
+
+	// usr/ and usr/bin/ are synthesized and count for no layer
+	if stats.Entries != 6 || stats.Files != 2 || stats.Directories != 3 {
+		t.Errorf("Expected 6 entries, 2 files and 3 directories, got %d, %d and %d", stats.Entries, stats.Files, stats.Directories)
+	}
+	if stats.Bytes != int64(len("hosts")+len("application")) {
+		t.Errorf("Expected %d bytes, got %d", len("hosts")+len("application"), stats.Bytes)
+	}
+	if stats.Whiteouts != 1 {
+		t.Errorf("Expected 1 whiteout, got %d", stats.Whiteouts)
+	}
+	if len(stats.Layers) != 2 {
+		t.Fatalf("Expected 2 layers, got %d", len(stats.Layers))
+	}
+	expected := []LayerStats{
+		{Index: 0, Entries: 2, Bytes: int64(len("hosts"))},
+		{Index: 1, Entries: 2, Bytes: int64(len("application")), Whiteouts: 1},
+	}
+	for i, layer := range stats.Layers {
+		layer.Digest = ""
+		if layer != expected[i] {
+			t.Errorf("Expected layer %d stats %+v, got %+v", i, expected[i], layer)
+		}
+	}
+}
+
+func TestExportStatsSchema(t *testing.T) {
+	image, err := mutate.AppendLayers(empty.Image,
+		newTestLayer(t, testEntry{name: "etc/hosts", content: "hosts"}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	host := newTestRegistry(t)
+	imageRef := host + "/test/stats:latest"
+	pushTestImage(t, imageRef, image)
+
+	var stats *ExportStats
+	opts := &ExportOptions{Stats: func(s *ExportStats) { stats = s }}
+	if err := NewImageExporter().ExportImageFilesystemToWriterWithOptions(imageRef, io.Discard, nil, opts); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if stats.SchemaVersion != SchemaVersion {
+		t.Errorf("Expected schema_version %d, got %d", SchemaVersion, stats.SchemaVersion)
+	}
+
+	// The document --stats-format json prints
+	data, err := json.Marshal(stats)
+	if err != nil {
+		t.Fatalf("Failed to encode stats: %v", err)
+	}
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+	schemaData, err := JSONSchema("export-stats")
+	if err != nil {
+		t.Fatalf("Expected the export-stats schema, got %v", err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(schemaData, &schema); err != nil {
+		t.Fatalf("Expected the export-stats schema to be valid JSON, got %v", err)
+	}
+	checkSchemaDocument(t, schema, schema, document, "export-stats")
+}
@@ -0,0 +1,390 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// ErrTagHistoryUnsupported is returned by TagHistory for registries that do not
+// expose the history of their tags
+var ErrTagHistoryUnsupported = errors.New("registry does not expose tag history")
+
+// Tag history providers
+const (
+	TagHistoryQuay   = "quay"
+	TagHistoryHarbor = "harbor"
+)
+
+// TagHistory lists the digests a tag pointed to over time, as recorded by the
+// registry. It is returned by the TagHistory method.
+type TagHistory struct {
+	// SchemaVersion is the version of this JSON document (see SchemaVersion)
+	SchemaVersion int `json:"schema_version"`
+
+	// Reference is the fully-qualified tag reference
+	Reference string `json:"reference"`
+
+	// Provider is the registry API the history came from: "quay" or "harbor"
+	Provider string `json:"provider"`
+
+	// Entries lists the digests the tag pointed to, newest first
+	Entries []TagHistoryEntry `json:"entries"`
+}
+
+// TagHistoryEntry is one period during which a tag pointed to a digest
+type TagHistoryEntry struct {
+	// Digest is the manifest digest, empty if the registry did not record it
+	Digest string `json:"digest,omitempty"`
+
+	// Start is when the tag was pushed or moved to the digest
+	Start time.Time `json:"start"`
+
+	// End is when the tag moved away or was deleted; nil while it still points there
+	End *time.Time `json:"end,omitempty"`
+}
+
+// TagHistory returns the digests a tag pointed to over time, for incident
+// forensics: which image did "app:latest" run last Tuesday?
+//
+// Plain registries only know where a tag points now, so the history comes from
+// registry-specific APIs, detected automatically:
+//   - Quay (quay.io and self-hosted Red Hat Quay) keeps every digest of a tag,
+//     with the time it was set and replaced.
+//   - Harbor records tag pushes and deletions in its audit log. The log does not
+//     name digests, so they are matched to artifacts by push time; the digest of
+//     an entry is empty when its artifact was deleted or pushed earlier.
+//
+// Credentials are sent to the API as for the registry: bearer tokens as-is,
+// usernames and passwords as basic auth. Quay's API needs an OAuth application
+// token for private repositories.
+//
+// Parameters:
+//   - imageRef: Tag reference (e.g., "quay.io/org/app:latest")
+//   - auth: Optional authentication configuration for private registries
+//
+// Returns:
+//   - *TagHistory: The recorded digests, newest first
+//   - error: ErrTagHistoryUnsupported if the registry offers neither API
+//
+// Example:
+//
+//	history, err := exporter.TagHistory("quay.io/org/app:latest", nil)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	for _, entry := range history.Entries {
+//	    fmt.Println(entry.Start.Format(time.RFC3339), entry.Digest)
+//	}
+func (e *imageExporter) TagHistory(imageRef string, auth *AuthConfig) (*TagHistory, error) {
+	ref, err := e.parseReference(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image reference %s: %w", imageRef, err)
+	}
+	tag, ok := ref.(name.Tag)
+	if !ok {
+		return nil, fmt.Errorf("tag history needs a tag reference, got %s", imageRef)
+	}
+
+	api, err := e.newRegistryAPI(tag.Context().Registry, auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tag history for %s: %w", imageRef, err)
+	}
+	provider, err := api.detect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tag history for %s: %w", imageRef, err)
+	}
+	e.log().Debug("fetching tag history", "image", tag.String(), "provider", provider)
+
+	var entries []TagHistoryEntry
+	switch provider {
+	case TagHistoryQuay:
+		entries, err = api.quayTagHistory(tag)
+	case TagHistoryHarbor:
+		entries, err = api.harborTagHistory(tag)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tag history for %s: %w", imageRef, err)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Start.After(entries[j].Start)
+	})
+
+	return &TagHistory{
+		SchemaVersion: SchemaVersion,
+		Reference:     tag.String(),
+		Provider:      provider,
+		Entries:       entries,
+	}, nil
+}
+
+// registryAPI calls the vendor HTTP API of a registry, next to its /v2/ API
+type registryAPI struct {
+	base          string
+	authorization string
+	client        *http.Client
+}
+
+// newRegistryAPI prepares calls to a registry's vendor API with the credentials
+// the exporter would use for the registry itself
+func (e *imageExporter) newRegistryAPI(reg name.Registry, auth *AuthConfig) (*registryAPI, error) {
+	var authenticator authn.Authenticator
+	if auth != nil && (auth.Registry == "" || normalizeRegistry(auth.Registry) == reg.RegistryStr()) {
+		authenticator = auth.authenticator()
+	} else {
+		resolved, err := e.authKeychain().Resolve(reg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve credentials: %w", err)
+		}
+		authenticator = resolved
+	}
+	config, err := authenticator.Authorization()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve credentials: %w", err)
+	}
+
+	api := &registryAPI{
+		base:   fmt.Sprintf("%s://%s", reg.Scheme(), reg.RegistryStr()),
+		client: &http.Client{Transport: e.httpTransport, Timeout: 30 * time.Second},
+	}
+	switch {
+	case config.RegistryToken != "":
+		api.authorization = "Bearer " + config.RegistryToken
+	case config.Username != "" || config.Password != "":
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(config.Username, config.Password)
+		api.authorization = req.Header.Get("Authorization")
+	}
+	return api, nil
+}
+
+// get fetches path from the API, decoding a JSON response into v. The status
+// code is returned for responses other than 200 OK, with v left untouched.
+func (a *registryAPI) get(path string, v interface{}) (int, error) {
+	req, err := http.NewRequest(http.MethodGet, a.base+path, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if a.authorization != "" {
+		req.Header.Set("Authorization", a.authorization)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return resp.StatusCode, fmt.Errorf("invalid response from %s: %w", req.URL.Path, err)
+	}
+	return resp.StatusCode, nil
+}
+
+// detect finds out which tag history API the registry offers, through the
+// unauthenticated discovery endpoints of Quay and Harbor
+func (a *registryAPI) detect() (string, error) {
+	for _, probe := range []struct {
+		provider string
+		path     string
+	}{
+		{TagHistoryQuay, "/api/v1/discovery"},
+		{TagHistoryHarbor, "/api/v2.0/systeminfo"},
+	} {
+		var body map[string]interface{}
+		status, err := a.get(probe.path, &body)
+		if err != nil {
+			return "", err
+		}
+		if status == http.StatusOK {
+			return probe.provider, nil
+		}
+	}
+	return "", ErrTagHistoryUnsupported
+}
+
+// apiStatusError describes a failed API call
+func apiStatusError(path string, status int) error {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%s: access denied (status %d); the API may need a token", path, status)
+	case http.StatusNotFound:
+		return fmt.Errorf("%s: repository not found", path)
+	default:
+		return fmt.Errorf("%s: unexpected status %d", path, status)
+	}
+}
+
+// quayTagHistory reads the history of a tag from the Quay API, which keeps a
+// record per digest the tag pointed to
+func (a *registryAPI) quayTagHistory(tag name.Tag) ([]TagHistoryEntry, error) {
+	var entries []TagHistoryEntry
+	for page := 1; ; page++ {
+		path := fmt.Sprintf("/api/v1/repository/%s/tag/?specificTag=%s&onlyActiveTags=false&limit=100&page=%d",
+			tag.RepositoryStr(), url.QueryEscape(tag.TagStr()), page)
+		var response struct {
+			Tags []struct {
+				Name           string `json:"name"`
+				ManifestDigest string `json:"manifest_digest"`
+				StartTS        int64  `json:"start_ts"`
+				EndTS          *int64 `json:"end_ts"`
+			} `json:"tags"`
+			HasAdditional bool `json:"has_additional"`
+		}
+		status, err := a.get(path, &response)
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusOK {
+			return nil, apiStatusError(path, status)
+		}
+
+		for _, record := range response.Tags {
+			if record.Name != tag.TagStr() {
+				continue
+			}
+			entry := TagHistoryEntry{Digest: record.ManifestDigest, Start: time.Unix(record.StartTS, 0).UTC()}
+			if record.EndTS != nil {
+				end := time.Unix(*record.EndTS, 0).UTC()
+				entry.End = &end
+			}
+			entries = append(entries, entry)
+		}
+		if !response.HasAdditional || len(response.Tags) == 0 {
+			return entries, nil
+		}
+	}
+}
+
+// harborPageSize is the page size of Harbor listings
+const harborPageSize = 100
+
+// harborPushTolerance is how far apart an audit log entry and an artifact push
+// time may be and still be matched
+const harborPushTolerance = 5 * time.Second
+
+// harborTagHistory rebuilds the history of a tag from the Harbor audit log,
+// matching each push to the artifact pushed at the same time
+func (a *registryAPI) harborTagHistory(tag name.Tag) ([]TagHistoryEntry, error) {
+	project, repository, found := strings.Cut(tag.RepositoryStr(), "/")
+	if !found {
+		return nil, fmt.Errorf("harbor repositories are named <project>/<repository>, got %s", tag.RepositoryStr())
+	}
+	resource := tag.RepositoryStr() + ":" + tag.TagStr()
+
+	type event struct {
+		create bool
+		time   time.Time
+	}
+	var events []event
+	for page := 1; ; page++ {
+		path := fmt.Sprintf("/api/v2.0/projects/%s/logs?q=%s&page=%d&page_size=%d",
+			url.PathEscape(project), url.QueryEscape("resource=~"+resource), page, harborPageSize)
+		var logs []struct {
+			Resource     string `json:"resource"`
+			ResourceType string `json:"resource_type"`
+			Operation    string `json:"operation"`
+			OpTime       string `json:"op_time"`
+		}
+		status, err := a.get(path, &logs)
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusOK {
+			return nil, apiStatusError(path, status)
+		}
+		for _, log := range logs {
+			if log.Resource != resource || log.ResourceType != "artifact" || (log.Operation != "create" && log.Operation != "delete") {
+				continue
+			}
+			when, err := time.Parse(time.RFC3339, log.OpTime)
+			if err != nil {
+				return nil, fmt.Errorf("invalid audit log time %q: %w", log.OpTime, err)
+			}
+			events = append(events, event{create: log.Operation == "create", time: when.UTC()})
+		}
+		if len(logs) < harborPageSize {
+			break
+		}
+	}
+
+	// Harbor wants slashes in repository names encoded twice
+	artifactsPath := fmt.Sprintf("/api/v2.0/projects/%s/repositories/%s/artifacts",
+		url.PathEscape(project), url.PathEscape(url.PathEscape(repository)))
+	type artifact struct {
+		digest string
+		pushed time.Time
+		tagged bool
+	}
+	var artifacts []artifact
+	for page := 1; ; page++ {
+		path := fmt.Sprintf("%s?with_tag=true&page=%d&page_size=%d", artifactsPath, page, harborPageSize)
+		var listed []struct {
+			Digest   string `json:"digest"`
+			PushTime string `json:"push_time"`
+			Tags     []struct {
+				Name string `json:"name"`
+			} `json:"tags"`
+		}
+		status, err := a.get(path, &listed)
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusOK {
+			return nil, apiStatusError(path, status)
+		}
+		for _, item := range listed {
+			pushed, _ := time.Parse(time.RFC3339, item.PushTime)
+			candidate := artifact{digest: item.Digest, pushed: pushed.UTC()}
+			for _, t := range item.Tags {
+				candidate.tagged = candidate.tagged || t.Name == tag.TagStr()
+			}
+			artifacts = append(artifacts, candidate)
+		}
+		if len(listed) < harborPageSize {
+			break
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].time.Before(events[j].time) })
+	var entries []TagHistoryEntry
+	var open *TagHistoryEntry
+	for _, ev := range events {
+		if open != nil {
+			end := ev.time
+			open.End = &end
+			entries = append(entries, *open)
+			open = nil
+		}
+		if !ev.create {
+			continue
+		}
+		open = &TagHistoryEntry{Start: ev.time}
+		for _, candidate := range artifacts {
+			if diff := candidate.pushed.Sub(ev.time); diff > -harborPushTolerance && diff < harborPushTolerance {
+				open.Digest = candidate.digest
+				break
+			}
+		}
+	}
+	if open != nil {
+		// The artifact holding the tag now is authoritative for the open entry
+		for _, candidate := range artifacts {
+			if candidate.tagged {
+				open.Digest = candidate.digest
+			}
+		}
+		entries = append(entries, *open)
+	}
+	return entries, nil
+}
@@ -0,0 +1,110 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestAPI serves JSON responses by request path (without the query) and
+// records the Authorization header of the last request
+func newTestAPI(t *testing.T, responses map[string]interface{}, authorization *string) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*authorization = r.Header.Get("Authorization")
+		response, ok := responses[r.URL.EscapedPath()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestTagHistoryQuay(t *testing.T) {
+	var authorization string
+	host := newTestAPI(t, map[string]interface{}{
+		"/api/v1/discovery": map[string]interface{}{},
+		"/api/v1/repository/org/app/tag/": map[string]interface{}{
+			"tags": []map[string]interface{}{
+				{"name": "latest", "manifest_digest": "sha256:bbbb", "start_ts": 2000},
+				{"name": "latest", "manifest_digest": "sha256:aaaa", "start_ts": 1000, "end_ts": 2000},
+			},
+		},
+	}, &authorization)
+
+	exporter := NewImageExporter()
+	history, err := exporter.TagHistory(host+"/org/app:latest", &AuthConfig{RegistryToken: "secret"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if history.Provider != TagHistoryQuay {
+		t.Errorf("Expected provider quay, got %s", history.Provider)
+	}
+	end := time.Unix(2000, 0).UTC()
+	expected := []TagHistoryEntry{
+		{Digest: "sha256:bbbb", Start: time.Unix(2000, 0).UTC()},
+		{Digest: "sha256:aaaa", Start: time.Unix(1000, 0).UTC(), End: &end},
+	}
+	if !reflect.DeepEqual(history.Entries, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, history.Entries)
+	}
+	if authorization != "Bearer secret" {
+		t.Errorf("Expected the token to be sent, got %q", authorization)
+	}
+}
+
+func TestTagHistoryHarbor(t *testing.T) {
+	var authorization string
+	host := newTestAPI(t, map[string]interface{}{
+		"/api/v2.0/systeminfo": map[string]interface{}{},
+		"/api/v2.0/projects/proj/logs": []map[string]interface{}{
+			{"resource": "proj/team/app:v1", "resource_type": "artifact", "operation": "create", "op_time": "2024-01-01T10:00:00Z"},
+			{"resource": "proj/team/app:v1", "resource_type": "artifact", "operation": "pull", "op_time": "2024-01-01T11:00:00Z"},
+			{"resource": "proj/team/app:v1", "resource_type": "artifact", "operation": "create", "op_time": "2024-01-02T10:00:00Z"},
+			{"resource": "proj/team/app:v10", "resource_type": "artifact", "operation": "create", "op_time": "2024-01-03T10:00:00Z"},
+		},
+		"/api/v2.0/projects/proj/repositories/team%252Fapp/artifacts": []map[string]interface{}{
+			{"digest": "sha256:bbbb", "push_time": "2024-01-02T10:00:01Z", "tags": []map[string]string{{"name": "v1"}}},
+			{"digest": "sha256:aaaa", "push_time": "2024-01-01T10:00:00Z"},
+		},
+	}, &authorization)
+
+	exporter := NewImageExporter()
+	history, err := exporter.TagHistory(host+"/proj/team/app:v1", &AuthConfig{Username: "admin", Password: "Harbor12345"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if history.Provider != TagHistoryHarbor {
+		t.Errorf("Expected provider harbor, got %s", history.Provider)
+	}
+	moved := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	expected := []TagHistoryEntry{
+		{Digest: "sha256:bbbb", Start: moved},
+		{Digest: "sha256:aaaa", Start: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), End: &moved},
+	}
+	if !reflect.DeepEqual(history.Entries, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, history.Entries)
+	}
+	if !strings.HasPrefix(authorization, "Basic ") {
+		t.Errorf("Expected basic auth, got %q", authorization)
+	}
+}
+
+func TestTagHistoryUnsupported(t *testing.T) {
+	host := newTestRegistry(t)
+	exporter := NewImageExporter()
+	if _, err := exporter.TagHistory(host+"/test/app:v1", nil); !errors.Is(err, ErrTagHistoryUnsupported) {
+		t.Errorf("Expected ErrTagHistoryUnsupported, got %v", err)
+	}
+	if _, err := exporter.TagHistory(host+"/test/app@sha256:"+strings.Repeat("a", 64), nil); err == nil {
+		t.Error("Expected an error for a digest reference")
+	}
+}
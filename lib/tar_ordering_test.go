@@ -62,7 +62,7 @@ func TestTarOrderingForExtraction(t *testing.T) {
 	var buf bytes.Buffer
 
 	// Export the filesystem to tar
-	err := exporter.writeFilesystemTar(filesystem, &buf)
+	err := exporter.writeFilesystemTar(filesystem, &buf, nil)
 	if err != nil {
 		t.Fatalf("Failed to write filesystem tar: %v", err)
 	}
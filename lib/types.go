@@ -5,7 +5,14 @@
 // both public and private registries with authentication.
 package lib
 
-import "io"
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+)
 
 // Version information for imgex
 const (
@@ -20,6 +27,9 @@ const (
 // This structure contains the essential configuration fields that define
 // how a container should be run, extracted from the image manifest.
 type ImageConfig struct {
+	// SchemaVersion is the version of this JSON document (see SchemaVersion).
+	SchemaVersion int `json:"schema_version"`
+
 	// User specifies the username or UID which the process in the container should run as.
 	// Empty string means root user.
 	User string `json:"user"`
@@ -43,6 +53,19 @@ type ImageConfig struct {
 	// Labels contains metadata for the image as key-value pairs.
 	// These are typically used for organization, licensing, and other descriptive information.
 	Labels map[string]string `json:"labels"`
+
+	// OS is the operating system the image was built to run on (e.g. "linux").
+	OS string `json:"os"`
+
+	// Architecture is the CPU architecture the image was built for (e.g. "amd64", "arm64").
+	Architecture string `json:"architecture"`
+
+	// Variant is the optional CPU variant (e.g. "v7" for arm).
+	Variant string `json:"variant,omitempty"`
+
+	// OSVersion is the Windows build the image requires (e.g. "10.0.20348.2227"),
+	// empty for other operating systems.
+	OSVersion string `json:"os_version,omitempty"`
 }
 
 // AuthConfig contains authentication credentials for accessing private registries.
@@ -55,14 +78,122 @@ type AuthConfig struct {
 	// Password for registry authentication.
 	Password string `json:"password"`
 
-	// Registry URL. If empty, authentication applies to Docker Hub.
+	// Registry URL. If set, the credentials are only sent to this registry and
+	// other registries use the default keychain. If empty, they apply to every registry.
 	Registry string `json:"registry"`
+
+	// IdentityToken is an OAuth refresh token exchanged for an access token
+	// (as stored by 'docker login' for registries that issue them).
+	IdentityToken string `json:"identity_token,omitempty"`
+
+	// RegistryToken is a pre-minted bearer token sent as-is on every request.
+	// When set, Username and Password are ignored.
+	RegistryToken string `json:"registry_token,omitempty"`
 }
 
 // ProgressCallback is called during export operations to report progress.
 // Parameters: current step, total steps, description of current operation
 type ProgressCallback func(current, total int, description string)
 
+// LayerProgressCallback is called as layer data is downloaded during an export.
+// Parameters: zero-based layer index, layer count, compressed bytes read so far,
+// compressed size of the layer. It is called once with read == 0 when a layer
+// starts and once with read == size when it is done.
+type LayerProgressCallback func(layer, layers int, read, size int64)
+
+// ByteProgress is a snapshot of the bytes transferred by an export, for
+// rendering overall progress rather than step counts.
+type ByteProgress struct {
+	Layer         int   // zero-based index of the layer being downloaded
+	Layers        int   // number of layers in the image
+	LayerRead     int64 // compressed bytes of the current layer read so far
+	LayerSize     int64 // compressed size of the current layer
+	Downloaded    int64 // compressed bytes read across all layers
+	DownloadTotal int64 // compressed size of all layers
+	Written       int64 // bytes written to the output, after any compression
+}
+
+// ByteProgressCallback is called as layers are downloaded and as the archive is
+// written. Downloaded reaches DownloadTotal once every layer has been applied;
+// the size of the output is not known in advance.
+type ByteProgressCallback func(progress ByteProgress)
+
+// DigestCallback is called once an export succeeds, with the digest of the
+// bytes written to the destination, e.g. "sha256:9f86d0...".
+type DigestCallback func(digest string)
+
+// WarningCallback is called when an operation encounters a problem
+// that does not prevent it from completing.
+type WarningCallback func(warning Warning)
+
+// PlatformPolicy controls what happens when an image's os/architecture
+// cannot run on the host performing the export.
+type PlatformPolicy string
+
+const (
+	// PlatformPolicyIgnore skips the platform check (default)
+	PlatformPolicyIgnore PlatformPolicy = ""
+
+	// PlatformPolicyWarn reports a mismatch through the Warning callback and continues
+	PlatformPolicyWarn PlatformPolicy = "warn"
+
+	// PlatformPolicyFail aborts the export with a *PlatformMismatchError
+	PlatformPolicyFail PlatformPolicy = "fail"
+)
+
+// TarFormat selects the tar format of an exported archive
+type TarFormat string
+
+const (
+	// TarFormatPAX writes POSIX.1-2001 archives (default): USTAR headers,
+	// extended with PAX records only for entries that need them, such as long
+	// paths, large uids, files over 8 GiB or extended attributes. Sparse files
+	// of the layers are written as PAX 1.0 sparse files; other formats expand them.
+	TarFormatPAX TarFormat = ""
+
+	// TarFormatGNU writes GNU archives, with GNU long names and base-256
+	// numbers. Extended attributes cannot be written and are dropped with a
+	// WarningRecordsDropped warning.
+	TarFormatGNU TarFormat = "gnu"
+
+	// TarFormatUSTAR writes plain POSIX.1-1988 archives for legacy consumers.
+	// Extended attributes are dropped with a warning, and the export fails on
+	// entries USTAR cannot encode, e.g. paths over 256 bytes or files over 8 GiB.
+	TarFormatUSTAR TarFormat = "ustar"
+)
+
+// XattrPolicy selects the extended attributes copied to an exported archive
+type XattrPolicy string
+
+const (
+	// XattrsAll copies every extended attribute recorded in the layers
+	// (default), including file capabilities (security.capability)
+	XattrsAll XattrPolicy = ""
+
+	// XattrsSecurity copies only the attributes of the security namespace,
+	// such as file capabilities and SELinux labels
+	XattrsSecurity XattrPolicy = "security"
+
+	// XattrsNone drops every extended attribute
+	XattrsNone XattrPolicy = "none"
+)
+
+// DevicePolicy selects how character and block devices and FIFOs are exported
+type DevicePolicy string
+
+const (
+	// DevicesPreserve writes device nodes and FIFOs as found in the layers
+	// (default). Extracting them requires mknod, which fails without privileges.
+	DevicesPreserve DevicePolicy = ""
+
+	// DevicesSkip leaves device nodes and FIFOs out of the archive
+	DevicesSkip DevicePolicy = "skip"
+
+	// DevicesConvert writes device nodes and FIFOs as empty regular files with
+	// the same path, permissions and owner, so they extract without privileges
+	DevicesConvert DevicePolicy = "convert"
+)
+
 // ExportOptions contains options for filesystem export operations
 type ExportOptions struct {
 	// Compress enables gzip compression of the output tar (creates .tar.gz)
@@ -70,6 +201,122 @@ type ExportOptions struct {
 
 	// Progress callback for reporting export progress
 	Progress ProgressCallback
+
+	// LayerProgress callback for reporting bytes downloaded per layer
+	LayerProgress LayerProgressCallback
+
+	// ByteProgress callback for reporting bytes downloaded and written overall
+	ByteProgress ByteProgressCallback
+
+	// Warning callback for reporting non-fatal problems, overriding WithWarnings for this export
+	Warning WarningCallback
+
+	// PlatformPolicy controls validation of the image platform against the host
+	PlatformPolicy PlatformPolicy
+
+	// StagingDir spools file contents to a temporary file in this directory
+	// instead of holding them in memory. Empty keeps everything in memory.
+	StagingDir string
+
+	// MaxStagingBytes caps the temp disk used by StagingDir (0 for unlimited).
+	// Once reached, remaining files are kept in memory and a warning is reported.
+	MaxStagingBytes int64
+
+	// LiteralPaths applies layer entries at the paths written in the layer, without
+	// following symlinked parent directories created by earlier layers. By default
+	// parents are resolved the way the kernel would inside a running container,
+	// and an entry replacing a directory with a file or symlink removes its contents.
+	LiteralPaths bool
+
+	// StrictPaths fails the export with ErrUnsafePath on entries leaving the
+	// root of the filesystem (absolute names, ".." climbing above the root,
+	// symlinks whose relative target climbs above it) instead of rewriting
+	// them with a WarningUnsafePath warning, for untrusted images
+	StrictPaths bool
+
+	// CaseInsensitiveWhiteouts matches whiteout targets ignoring case, for images
+	// built on case-insensitive filesystems where ".wh.readme" deletes "README"
+	CaseInsensitiveWhiteouts bool
+
+	// Platform selects the image from a multi-arch index, as "os/arch[/variant]"
+	// (e.g. "linux/arm64"), with an optional ":os.version" prefix for Windows
+	// images (e.g. "windows/amd64:10.0.20348"). Empty selects linux/amd64.
+	Platform string
+
+	// WriteTimeout fails the export with ErrWriteTimeout once the destination
+	// has accepted no data for this long, e.g. a stalled reader of a pipe.
+	// 0 waits forever.
+	WriteTimeout time.Duration
+
+	// Context cancels the export when it is done: layer downloads and writes to
+	// the destination stop at their next chunk and the export fails with the
+	// context's error (see IsCanceled). Nil never cancels.
+	Context context.Context
+
+	// StallWarning reports a WarningOutputStalled warning each time the
+	// destination has accepted no data for this long. 0 disables the warning.
+	// With WriteTimeout or StallWarning, output is buffered (up to 4 MiB) and
+	// written by a separate goroutine.
+	StallWarning time.Duration
+
+	// Digest receives the SHA-256 digest of the archive, after any compression,
+	// computed while it is written so large archives need not be read again
+	Digest DigestCallback
+
+	// Stats receives a summary of the export once it succeeds: entry counts,
+	// bytes, whiteouts, the contribution of each layer and the duration
+	Stats StatsCallback
+
+	// SourceDateEpoch is the modification time written for every entry; zero
+	// writes the Unix epoch. SourceDateEpochFromEnv reads it from the
+	// SOURCE_DATE_EPOCH environment variable.
+	SourceDateEpoch time.Time
+
+	// TarFormat is the tar format of the archive, TarFormatPAX by default
+	TarFormat TarFormat
+
+	// Xattrs selects the extended attributes (SCHILY.xattr PAX records) copied
+	// from the layers, XattrsAll by default. Only PAX archives can hold them.
+	Xattrs XattrPolicy
+
+	// Devices selects how device nodes and FIFOs are written, DevicesPreserve
+	// by default
+	Devices DevicePolicy
+
+	// UIDMap and GIDMap remap the owners of the entries, like a user namespace,
+	// for rootless runtimes and chroots. Ids outside every range are exported as
+	// OverflowID with a WarningUnmappedID warning. User and group names are
+	// cleared when a remapping is set. Empty maps keep the owners of the image.
+	UIDMap []IDMapping
+	GIDMap []IDMapping
+
+	// Owner gives every entry the same owner, like tar --owner and --group,
+	// clearing user and group names. It cannot be combined with UIDMap or GIDMap.
+	Owner *Owner
+
+	// Exclude leaves out of the archive the paths matching these patterns, in
+	// the syntax of path.Match without a leading slash, along with everything
+	// below them: "tmp/*" keeps /tmp but none of its contents. DefaultExcludes
+	// lists the runtime paths and caches that rarely belong in an archive.
+	Exclude []string
+
+	// SkipFileSize leaves out regular files larger than this many bytes, and
+	// hardlinks to them, with a WarningFileSkipped warning naming each one, for
+	// exports that only need configuration and small assets of a large image.
+	// Their content is never stored. Unlike Limits.MaxFileSize the export goes
+	// on. Zero keeps every file.
+	SkipFileSize int64
+
+	// Limits bounds the layer sizes, file count, file sizes and output size of
+	// the export, failing it with ErrLimitExceeded
+	Limits ExportLimits
+
+	// Reproducible fails the export with ErrNotReproducible, before writing
+	// anything, when the image is not a registry image pinned by digest and
+	// the archive could differ between runs. Records of the build host (inode
+	// and device numbers, link counts, creation times) are left out of the
+	// archive.
+	Reproducible bool
 }
 
 // ImageExporter defines the interface for extracting Docker image data.
@@ -96,4 +343,128 @@ type ImageExporter interface {
 
 	// ExportImageFilesystemToWriterWithOptions exports to writer with additional options
 	ExportImageFilesystemToWriterWithOptions(imageRef string, writer io.Writer, auth *AuthConfig, opts *ExportOptions) error
+
+	// LayerHistory returns each layer of an image paired with the history entry that created it.
+	// Only the manifest and config are fetched; layer data is not downloaded.
+	LayerHistory(imageRef string, auth *AuthConfig) ([]LayerHistoryEntry, error)
+
+	// OpenLayer returns the raw (compressed) blob of one layer of an image with its descriptor.
+	OpenLayer(imageRef string, digest string, auth *AuthConfig) (io.ReadCloser, v1.Descriptor, error)
+
+	// OpenFile returns the content of one file of the flattened filesystem, optionally decompressed.
+	OpenFile(imageRef string, filePath string, auth *AuthConfig, opts *FileOptions) (io.ReadCloser, *tar.Header, error)
+
+	// ResolveDigest returns the manifest digest a reference currently points to,
+	// without fetching the manifest when the registry answers a HEAD request.
+	ResolveDigest(imageRef string, auth *AuthConfig) (string, error)
+
+	// ResolveName returns the fully-qualified reference an image reference stands
+	// for, after short-name resolution (see WithNameResolver).
+	ResolveName(imageRef string) (string, error)
+
+	// ListTags returns an iterator over the tags of a repository, one page at a time.
+	ListTags(repository string, auth *AuthConfig, opts *ListOptions) (*PageIterator, error)
+
+	// ExportBundle writes an image's manifest, config and compressed layers as an OCI layout tar for air-gapped transfer.
+	ExportBundle(imageRef string, w io.Writer, auth *AuthConfig, opts *BundleOptions) error
+
+	// ImportBundle loads a bundle written by ExportBundle into the blob cache, for use with WithOffline.
+	ImportBundle(r io.Reader) ([]string, error)
+
+	// CopyImage copies an image, with every platform or one, from one repository to another
+	// and returns the digest written.
+	CopyImage(srcRef, dstRef string, auth *AuthConfig, opts *CopyOptions) (string, error)
+
+	// SquashImage flattens an image into a single layer, keeping its configuration,
+	// and pushes the result; it returns the digest pushed.
+	SquashImage(srcRef, dstRef string, auth *AuthConfig, opts *SquashOptions) (string, error)
+
+	// TagHistory returns the digests a tag pointed to over time, from the Quay or Harbor API.
+	TagHistory(imageRef string, auth *AuthConfig) (*TagHistory, error)
+
+	// Catalog returns an iterator over the repositories of a registry, one page at a time.
+	Catalog(registry string, auth *AuthConfig, opts *ListOptions) (*PageIterator, error)
+
+	// VerifyExtraction compares an extracted directory tree against the image and reports drift.
+	VerifyExtraction(imageRef string, dir string, auth *AuthConfig, opts *VerifyOptions) (*VerificationReport, error)
+
+	// SimulateStart reports the command, environment, user and executable a container of the image would start with.
+	SimulateStart(imageRef string, auth *AuthConfig, opts *SimulateOptions) (*StartReport, error)
+
+	// CacheStats returns blob cache hits and misses since the exporter was created (see WithCache).
+	CacheStats() CacheStats
+
+	// Login validates credentials against a registry and stores them in the docker config or credential helper.
+	Login(registry string, auth *AuthConfig) error
+
+	// Logout removes the stored credentials for a registry.
+	Logout(registry string) error
+
+	// AdviseRetention lists the digests of a repository that a retention policy allows deleting.
+	AdviseRetention(repository string, auth *AuthConfig, policy *RetentionPolicy) (*RetentionPlan, error)
+
+	// Delete removes a manifest (by digest) or a tag from a registry and returns the manifest digest.
+	Delete(imageRef string, auth *AuthConfig, opts *DeleteOptions) (string, error)
+
+	// Lock resolves image references to their digests (and platform digests) as a lockfile.
+	Lock(imageRefs []string, auth *AuthConfig) (*Lockfile, error)
+
+	// VerifyLock checks that the images of a lockfile still exist and that their references still resolve to them.
+	VerifyLock(lock *Lockfile, auth *AuthConfig) (*LockVerification, error)
+
+	// Referrers lists the artifacts (signatures, SBOMs, attestations) attached to an image through the OCI referrers API.
+	Referrers(imageRef string, auth *AuthConfig, opts *ReferrersOptions) (*ReferrerList, error)
+
+	// SBOMs lists the SBOMs attached to an image as referrers, cosign attachments or attestations.
+	SBOMs(imageRef string, auth *AuthConfig, opts *SBOMOptions) (*SBOMList, error)
+
+	// ReadSBOM returns the content of an SBOM listed by SBOMs, or the SBOM predicate of an attestation.
+	ReadSBOM(imageRef string, sbom SBOM, auth *AuthConfig, predicate bool) ([]byte, error)
+
+	// Provenance returns the SLSA provenance attestations attached to an image, checking their subjects.
+	Provenance(imageRef string, auth *AuthConfig, opts *ProvenanceOptions) (*ProvenanceList, error)
+
+	// GenerateSBOM inventories the packages of an image from the package databases of its flattened filesystem.
+	GenerateSBOM(imageRef string, auth *AuthConfig, opts *GenerateSBOMOptions) (*PackageInventory, error)
+
+	// ScanSecrets looks for likely credentials in the filesystem of an image, and optionally in every layer.
+	ScanSecrets(imageRef string, auth *AuthConfig, opts *SecretScanOptions) (*SecretReport, error)
+
+	// Audit reports setuid, setgid and world-writable files and file capabilities in the filesystem of an image.
+	Audit(imageRef string, auth *AuthConfig, opts *AuditOptions) (*AuditReport, error)
+
+	// Which tells which layers added, modified or deleted a path of an image.
+	Which(imageRef string, filePath string, auth *AuthConfig, opts *WhichOptions) (*PathBlame, error)
+
+	// DiskUsage breaks down the size of an image by layer and lists its largest directories and files.
+	DiskUsage(imageRef string, auth *AuthConfig, opts *DiskUsageOptions) (*DiskUsageReport, error)
+
+	// EstimateExport tells how much exporting an image would download and write, without downloading its layers.
+	EstimateExport(imageRef string, auth *AuthConfig, opts *ExportOptions) (*ExportEstimate, error)
+}
+
+// LayerHistoryEntry pairs a history entry from the image configuration with
+// the layer it produced. Entries for instructions that did not create a layer
+// (ENV, CMD, LABEL, etc.) are not included.
+type LayerHistoryEntry struct {
+	// Index is the zero-based position of the layer in the image.
+	Index int `json:"index"`
+
+	// Digest is the digest of the compressed layer blob.
+	Digest string `json:"digest"`
+
+	// DiffID is the digest of the uncompressed layer tar.
+	DiffID string `json:"diff_id"`
+
+	// Size is the compressed size of the layer in bytes.
+	Size int64 `json:"size"`
+
+	// Created is when the layer was created, if recorded.
+	Created time.Time `json:"created"`
+
+	// CreatedBy is the command that created the layer (e.g. a Dockerfile RUN line).
+	CreatedBy string `json:"created_by"`
+
+	// Comment is an optional comment recorded with the history entry.
+	Comment string `json:"comment,omitempty"`
 }
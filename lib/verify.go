@@ -0,0 +1,305 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DriftKind classifies a difference between an extracted tree and its image
+type DriftKind string
+
+const (
+	// DriftMissing means an expected path does not exist on disk
+	DriftMissing DriftKind = "missing"
+
+	// DriftType means the path exists with a different file type
+	DriftType DriftKind = "type"
+
+	// DriftMode means the permission bits differ
+	DriftMode DriftKind = "mode"
+
+	// DriftSize means a regular file has a different size
+	DriftSize DriftKind = "size"
+
+	// DriftContent means a regular file has the same size but different content
+	DriftContent DriftKind = "content"
+
+	// DriftLink means a symlink points somewhere else
+	DriftLink DriftKind = "link"
+
+	// DriftExtra means a path exists on disk but not in the image
+	DriftExtra DriftKind = "extra"
+)
+
+// Drift describes one difference found by VerifyExtraction
+type Drift struct {
+	// Path is relative to the extraction directory
+	Path string `json:"path"`
+
+	// Kind classifies the difference
+	Kind DriftKind `json:"kind"`
+
+	// Expected is the value recorded in the image, if applicable
+	Expected string `json:"expected,omitempty"`
+
+	// Actual is the value found on disk, if applicable
+	Actual string `json:"actual,omitempty"`
+}
+
+// VerifyOptions controls VerifyExtraction
+type VerifyOptions struct {
+	// IgnoreModes skips permission comparisons, e.g. for trees extracted without privileges
+	IgnoreModes bool
+
+	// ReportExtra reports paths present on disk but absent from the image
+	ReportExtra bool
+}
+
+// VerificationReport is the result of VerifyExtraction
+type VerificationReport struct {
+	// SchemaVersion is the version of this JSON document (see SchemaVersion)
+	SchemaVersion int `json:"schema_version"`
+
+	// Image is the verified image reference
+	Image string `json:"image"`
+
+	// Directory is the verified extraction directory
+	Directory string `json:"directory"`
+
+	// Checked is the number of image paths compared
+	Checked int `json:"checked"`
+
+	// Drift lists every difference found, sorted by path
+	Drift []Drift `json:"drift"`
+}
+
+// OK reports whether the tree matched the image exactly
+func (r *VerificationReport) OK() bool {
+	return len(r.Drift) == 0
+}
+
+// VerifyExtraction compares a directory holding an extracted image filesystem
+// against the image's flattened metadata.
+//
+// Every path in the image is checked for existence, file type, permission bits,
+// size, SHA-256 content hash (regular files) and link target (symlinks).
+// Ownership is not compared since extraction is commonly done without privileges.
+//
+// Parameters:
+//   - imageRef: Docker image reference (e.g., "nginx:latest", "registry.com/org/image:v1.0")
+//   - dir: Directory the image filesystem was extracted into
+//   - auth: Optional authentication configuration for private registries
+//   - opts: Optional verification settings
+//
+// Returns:
+//   - *VerificationReport: The differences found, empty if the tree matches
+//   - error: Any error that prevented verification
+//
+// Example:
+//
+//	report, err := exporter.VerifyExtraction("alpine:latest", "/srv/rootfs", nil, nil)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	for _, drift := range report.Drift {
+//	    fmt.Printf("%s %s\n", drift.Kind, drift.Path)
+//	}
+func (e *imageExporter) VerifyExtraction(imageRef string, dir string, auth *AuthConfig, opts *VerifyOptions) (*VerificationReport, error) {
+	if opts == nil {
+		opts = &VerifyOptions{}
+	}
+
+	if info, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("failed to access extraction directory: %w", err)
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	image, err := e.fetchImage(imageRef, auth)
+	if err != nil {
+		return nil, err
+	}
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image layers: %w", err)
+	}
+	filesystem, err := e.applyLayers(layers, newWindowsDetector(image))
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply layers: %w", err)
+	}
+	e.finalizeFilesystem(filesystem, nil)
+
+	report := &VerificationReport{SchemaVersion: SchemaVersion, Image: imageRef, Directory: dir}
+	expected := make(map[string]bool, len(filesystem))
+	for key, entry := range filesystem {
+		rel := strings.TrimSuffix(key, "/")
+		if rel == "." || rel == "" {
+			continue
+		}
+		expected[rel] = true
+		report.Checked++
+
+		drift, err := verifyEntry(filesystem, dir, rel, entry, opts)
+		if err != nil {
+			return nil, err
+		}
+		report.Drift = append(report.Drift, drift...)
+	}
+
+	if opts.ReportExtra {
+		err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, p)
+			if err != nil || rel == "." {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if !expected[rel] {
+				report.Drift = append(report.Drift, Drift{Path: rel, Kind: DriftExtra})
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk extraction directory: %w", err)
+		}
+	}
+
+	sort.Slice(report.Drift, func(i, j int) bool {
+		if report.Drift[i].Path != report.Drift[j].Path {
+			return report.Drift[i].Path < report.Drift[j].Path
+		}
+		return report.Drift[i].Kind < report.Drift[j].Kind
+	})
+	return report, nil
+}
+
+// verifyEntry compares a single image entry with the file on disk
+func verifyEntry(filesystem map[string]*fileEntry, dir, rel string, entry *fileEntry, opts *VerifyOptions) ([]Drift, error) {
+	header := entry.header
+	diskPath := filepath.Join(dir, filepath.FromSlash(rel))
+
+	info, err := os.Lstat(diskPath)
+	if os.IsNotExist(err) {
+		return []Drift{{Path: rel, Kind: DriftMissing}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", diskPath, err)
+	}
+
+	// Hardlinks must match the content of their target
+	if header.Typeflag == tar.TypeLink {
+		target, ok := lookupEntry(filesystem, strings.TrimPrefix(header.Linkname, "/"))
+		if !ok {
+			return nil, nil
+		}
+		entry, header = target, target.header
+	}
+
+	expectedType := tarTypeName(header.Typeflag)
+	actualType := fileModeTypeName(info.Mode())
+	if expectedType != actualType {
+		return []Drift{{Path: rel, Kind: DriftType, Expected: expectedType, Actual: actualType}}, nil
+	}
+
+	var drift []Drift
+	if !opts.IgnoreModes && header.Typeflag != tar.TypeSymlink {
+		expectedMode := fs.FileMode(header.Mode) & fs.ModePerm
+		if actualMode := info.Mode().Perm(); expectedMode != actualMode {
+			drift = append(drift, Drift{Path: rel, Kind: DriftMode, Expected: expectedMode.String(), Actual: actualMode.String()})
+		}
+	}
+
+	switch header.Typeflag {
+	case tar.TypeSymlink:
+		target, err := os.Readlink(diskPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read link %s: %w", diskPath, err)
+		}
+		if target != header.Linkname {
+			drift = append(drift, Drift{Path: rel, Kind: DriftLink, Expected: header.Linkname, Actual: target})
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		if info.Size() != header.Size {
+			drift = append(drift, Drift{Path: rel, Kind: DriftSize, Expected: fmt.Sprint(header.Size), Actual: fmt.Sprint(info.Size())})
+			break
+		}
+		expectedHash, err := hashReader(entry.content())
+		if err != nil {
+			return nil, err
+		}
+		file, err := os.Open(diskPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", diskPath, err)
+		}
+		actualHash, err := hashReader(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(expectedHash, actualHash) {
+			drift = append(drift, Drift{Path: rel, Kind: DriftContent,
+				Expected: "sha256:" + hex.EncodeToString(expectedHash), Actual: "sha256:" + hex.EncodeToString(actualHash)})
+		}
+	}
+
+	return drift, nil
+}
+
+// hashReader returns the SHA-256 of everything read from r
+func hashReader(r io.Reader) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, fmt.Errorf("failed to hash content: %w", err)
+	}
+	return h.Sum(nil), nil
+}
+
+// tarTypeName names the file type of a tar entry
+func tarTypeName(typeflag byte) string {
+	switch typeflag {
+	case tar.TypeDir:
+		return "directory"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeChar:
+		return "char device"
+	case tar.TypeBlock:
+		return "block device"
+	case tar.TypeFifo:
+		return "fifo"
+	default:
+		return "file"
+	}
+}
+
+// fileModeTypeName names the file type of an on-disk file
+func fileModeTypeName(mode fs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	case mode&fs.ModeCharDevice != 0:
+		return "char device"
+	case mode&fs.ModeDevice != 0:
+		return "block device"
+	case mode&fs.ModeNamedPipe != 0:
+		return "fifo"
+	default:
+		return "file"
+	}
+}
@@ -0,0 +1,126 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// extractTestTar extracts directories, regular files and symlinks into dir
+func extractTestTar(t *testing.T, data []byte, dir string) {
+	t.Helper()
+
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read tar: %v", err)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, os.FileMode(header.Mode).Perm())
+		case tar.TypeSymlink:
+			err = os.Symlink(header.Linkname, target)
+		case tar.TypeReg:
+			var content []byte
+			content, err = io.ReadAll(tr)
+			if err == nil {
+				err = os.WriteFile(target, content, os.FileMode(header.Mode).Perm())
+			}
+		}
+		if err != nil {
+			t.Fatalf("Failed to extract %s: %v", header.Name, err)
+		}
+	}
+}
+
+func TestVerifyExtraction(t *testing.T) {
+	layer := newTestLayer(t,
+		testEntry{name: "etc/", typeflag: tar.TypeDir},
+		testEntry{name: "etc/hostname", content: "device"},
+		testEntry{name: "etc/motd", content: "welcome"},
+		testEntry{name: "bin/", typeflag: tar.TypeDir},
+		testEntry{name: "bin/run", content: "#!/bin/sh", mode: 0755},
+		testEntry{name: "etc/link", typeflag: tar.TypeSymlink, linkname: "hostname"},
+	)
+	image, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+
+	host := newTestRegistry(t)
+	imageRef := host + "/test/verify:latest"
+	pushTestImage(t, imageRef, image)
+
+	exporter := NewImageExporter()
+	var buf bytes.Buffer
+	if err := exporter.ExportImageFilesystemToWriter(imageRef, &buf, nil); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	dir := t.TempDir()
+	extractTestTar(t, buf.Bytes(), dir)
+
+	report, err := exporter.VerifyExtraction(imageRef, dir, nil, &VerifyOptions{ReportExtra: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !report.OK() {
+		t.Fatalf("Expected clean tree, got %+v", report.Drift)
+	}
+	if report.Checked != 6 {
+		t.Errorf("Expected 6 checked paths, got %d", report.Checked)
+	}
+
+	// Introduce drift of every kind
+	os.WriteFile(filepath.Join(dir, "etc/hostname"), []byte("tamper"), 0644)
+	os.WriteFile(filepath.Join(dir, "etc/motd"), []byte("changed size"), 0644)
+	os.Remove(filepath.Join(dir, "etc/link"))
+	os.Symlink("motd", filepath.Join(dir, "etc/link"))
+	os.Chmod(filepath.Join(dir, "bin/run"), 0644)
+	os.WriteFile(filepath.Join(dir, "etc/extra"), []byte("x"), 0644)
+
+	report, err = exporter.VerifyExtraction(imageRef, dir, nil, &VerifyOptions{ReportExtra: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	got := make(map[string]DriftKind)
+	for _, drift := range report.Drift {
+		got[drift.Path] = drift.Kind
+	}
+	expected := map[string]DriftKind{
+		"etc/hostname": DriftContent,
+		"etc/motd":     DriftSize,
+		"etc/link":     DriftLink,
+		"bin/run":      DriftMode,
+		"etc/extra":    DriftExtra,
+	}
+	for path, kind := range expected {
+		if got[path] != kind {
+			t.Errorf("Expected %s drift for %s, got %q", kind, path, got[path])
+		}
+	}
+
+	os.Remove(filepath.Join(dir, "etc/motd"))
+	report, err = exporter.VerifyExtraction(imageRef, dir, nil, &VerifyOptions{IgnoreModes: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, drift := range report.Drift {
+		if drift.Path == "etc/motd" && drift.Kind != DriftMissing {
+			t.Errorf("Expected missing drift for etc/motd, got %s", drift.Kind)
+		}
+		if drift.Kind == DriftMode || drift.Kind == DriftExtra {
+			t.Errorf("Unexpected %s drift with IgnoreModes and no ReportExtra", drift.Kind)
+		}
+	}
+}
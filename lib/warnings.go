@@ -0,0 +1,258 @@
+package lib
+
+import (
+	"archive/tar"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// WarningCode identifies the kind of non-fatal problem reported in a Warning
+type WarningCode string
+
+const (
+	// WarningPlatformMismatch reports an image whose os/architecture cannot run on the host
+	WarningPlatformMismatch WarningCode = "platform_mismatch"
+
+	// WarningStagingLimit reports that the staging budget was exhausted
+	WarningStagingLimit WarningCode = "staging_limit"
+
+	// WarningUnknownEntryType reports a tar entry type imgex does not recognize
+	WarningUnknownEntryType WarningCode = "unknown_entry_type"
+
+	// WarningForeignLayerSkipped reports a foreign (non-distributable) layer left out by ForeignLayersSkip
+	WarningForeignLayerSkipped WarningCode = "foreign_layer_skipped"
+
+	// WarningSynthesizedDir reports a parent directory added because no layer contained it
+	WarningSynthesizedDir WarningCode = "synthesized_dir"
+
+	// WarningDanglingLink reports a symlink or hardlink whose target is not in the filesystem
+	WarningDanglingLink WarningCode = "dangling_link"
+
+	// WarningPlaintextCredentials reports that Login stored a password in the docker config file
+	WarningPlaintextCredentials WarningCode = "plaintext_credentials"
+
+	// WarningOutputStalled reports an export destination that has accepted no data for ExportOptions.StallWarning
+	WarningOutputStalled WarningCode = "output_stalled"
+
+	// WarningSignatureCheck reports a failed signature check that the notation trust policy level only logs
+	WarningSignatureCheck WarningCode = "signature_check"
+
+	// WarningRecordsDropped reports extended header records, such as extended
+	// attributes, that the tar format of the export cannot hold
+	WarningRecordsDropped WarningCode = "records_dropped"
+
+	// WarningUnsafePath reports an entry name or link target leaving the root of
+	// the filesystem, which was rewritten to stay below it (see ErrUnsafePath)
+	WarningUnsafePath WarningCode = "unsafe_path"
+
+	// WarningUnmappedID reports entries whose owner no uid or gid mapping
+	// covers, exported as owned by OverflowID
+	WarningUnmappedID WarningCode = "unmapped_id"
+
+	// WarningFileSkipped reports a file left out of the archive for being
+	// larger than ExportOptions.SkipFileSize
+	WarningFileSkipped WarningCode = "file_skipped"
+
+	// WarningOpenFileLimit reports parallel work reduced to stay within the
+	// limit on open files
+	WarningOpenFileLimit WarningCode = "open_file_limit"
+)
+
+// Warning describes a non-fatal problem encountered during an operation.
+// Warnings never stop an export; fatal problems are returned as errors.
+type Warning struct {
+	// Code identifies the kind of problem
+	Code WarningCode `json:"code"`
+
+	// Message is a human-readable description
+	Message string `json:"message"`
+
+	// Path is the affected filesystem path, if any
+	Path string `json:"path,omitempty"`
+
+	// Layer is the digest of the affected layer, if any
+	Layer string `json:"layer,omitempty"`
+}
+
+// String implements fmt.Stringer
+func (w Warning) String() string {
+	return w.Message
+}
+
+// WithWarnings registers a callback that receives every warning raised by the
+// exporter. ExportOptions.Warning, when set, takes precedence for that export.
+func WithWarnings(callback WarningCallback) ExporterOption {
+	return func(e *imageExporter) {
+		e.warnings = callback
+	}
+}
+
+// WarningCollector accumulates warnings for later inspection. Its Collect method
+// can be passed to WithWarnings or ExportOptions.Warning, and is safe for concurrent use.
+type WarningCollector struct {
+	mu       sync.Mutex
+	warnings []Warning
+}
+
+// Collect records a warning
+func (c *WarningCollector) Collect(w Warning) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.warnings = append(c.warnings, w)
+}
+
+// Warnings returns a copy of the warnings collected so far
+func (c *WarningCollector) Warnings() []Warning {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Warning(nil), c.warnings...)
+}
+
+// warn reports a warning to the export's callback, falling back to the exporter's
+func (e *imageExporter) warn(opts *ExportOptions, w Warning) {
+	if opts != nil && opts.Warning != nil {
+		opts.Warning(w)
+	} else if e.warnings != nil {
+		e.warnings(w)
+	}
+}
+
+// knownTypeflags lists the tar entry types that imgex understands
+var knownTypeflags = map[byte]bool{
+	tar.TypeReg:     true,
+	tar.TypeRegA:    true,
+	tar.TypeDir:     true,
+	tar.TypeSymlink: true,
+	tar.TypeLink:    true,
+	tar.TypeChar:    true,
+	tar.TypeBlock:   true,
+	tar.TypeFifo:    true,
+}
+
+// finalizeFilesystem checks the flattened filesystem before it is written,
+// warning about unknown entry types and dangling links, and synthesizing
+// parent directories that no layer provided. Hardlinks whose target a later
+// layer changed are repaired first (see repairHardlinks).
+func (e *imageExporter) finalizeFilesystem(filesystem map[string]*fileEntry, opts *ExportOptions) {
+	e.repairHardlinks(filesystem)
+
+	paths := make([]string, 0, len(filesystem))
+	for p := range filesystem {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		entry := filesystem[p]
+		header := entry.header
+
+		if !knownTypeflags[header.Typeflag] {
+			e.warn(opts, Warning{
+				Code:    WarningUnknownEntryType,
+				Message: fmt.Sprintf("unknown tar entry type %q for %s", header.Typeflag, p),
+				Path:    p,
+			})
+		}
+
+		// Synthesize missing parent directories so extraction does not depend on tar implicit dirs
+		for dir := path.Dir(strings.TrimSuffix(p, "/")); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if _, ok := lookupEntry(filesystem, dir); ok {
+				break
+			}
+			filesystem[dir+"/"] = &fileEntry{header: &tar.Header{
+				Name:     dir + "/",
+				Typeflag: tar.TypeDir,
+				Mode:     0755,
+			}, layer: -1}
+			e.warn(opts, Warning{
+				Code:    WarningSynthesizedDir,
+				Message: fmt.Sprintf("synthesized missing parent directory %s", dir),
+				Path:    dir,
+			})
+		}
+
+		if target, ok := linkTarget(p, header); ok {
+			var exists bool
+			if header.Typeflag == tar.TypeLink {
+				_, exists = lookupEntry(filesystem, target)
+			} else {
+				_, exists = resolveEntry(filesystem, target, 0)
+			}
+			if !exists {
+				e.warn(opts, Warning{
+					Code:    WarningDanglingLink,
+					Message: fmt.Sprintf("link %s points to missing %s", p, header.Linkname),
+					Path:    p,
+				})
+			}
+		}
+	}
+}
+
+// lookupEntry finds an entry by path, with or without a trailing slash
+func lookupEntry(filesystem map[string]*fileEntry, p string) (*fileEntry, bool) {
+	if entry, ok := filesystem[p]; ok {
+		return entry, true
+	}
+	entry, ok := filesystem[p+"/"]
+	return entry, ok
+}
+
+// maxSymlinkDepth bounds symlink resolution, matching the Linux ELOOP limit
+const maxSymlinkDepth = 40
+
+// resolveEntry looks up a path component by component, following symlinks in
+// the filesystem like the kernel would. It returns a nil entry for the root.
+func resolveEntry(filesystem map[string]*fileEntry, p string, depth int) (*fileEntry, bool) {
+	if depth > maxSymlinkDepth {
+		return nil, false
+	}
+
+	parts := strings.Split(path.Clean(strings.Trim(p, "/")), "/")
+	current := "."
+	for i, part := range parts {
+		if part == "." || part == "" {
+			continue
+		}
+		if part == ".." {
+			current = path.Dir(current)
+			continue
+		}
+
+		next := path.Join(current, part)
+		entry, ok := lookupEntry(filesystem, next)
+		if !ok {
+			return nil, false
+		}
+		if entry.header.Typeflag == tar.TypeSymlink {
+			target, _ := linkTarget(next, entry.header)
+			rest := append([]string{target}, parts[i+1:]...)
+			return resolveEntry(filesystem, path.Join(rest...), depth+1)
+		}
+		if i == len(parts)-1 {
+			return entry, true
+		}
+		current = next
+	}
+
+	return nil, true
+}
+
+// linkTarget returns the filesystem path a symlink or hardlink refers to
+func linkTarget(p string, header *tar.Header) (string, bool) {
+	switch header.Typeflag {
+	case tar.TypeLink:
+		// Hardlink targets are always relative to the archive root
+		return path.Clean(strings.TrimPrefix(header.Linkname, "/")), true
+	case tar.TypeSymlink:
+		if strings.HasPrefix(header.Linkname, "/") {
+			return path.Clean(strings.TrimPrefix(header.Linkname, "/")), true
+		}
+		return path.Join(path.Dir(strings.TrimSuffix(p, "/")), header.Linkname), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// warningCodes counts warnings by code
+func warningCodes(warnings []Warning) map[WarningCode]int {
+	counts := make(map[WarningCode]int)
+	for _, w := range warnings {
+		counts[w.Code]++
+	}
+	return counts
+}
+
+func TestExportWarnings(t *testing.T) {
+	layer := newTestLayer(t,
+		testEntry{name: "usr/", typeflag: tar.TypeDir},
+		testEntry{name: "usr/bin/", typeflag: tar.TypeDir},
+		testEntry{name: "usr/bin/sh", content: "shell"},
+		testEntry{name: "bin", typeflag: tar.TypeSymlink, linkname: "usr/bin"},
+		testEntry{name: "sbin/", typeflag: tar.TypeDir},
+		testEntry{name: "sbin/sh", typeflag: tar.TypeSymlink, linkname: "/bin/sh"},
+		testEntry{name: "sbin/gone", typeflag: tar.TypeSymlink, linkname: "../missing"},
+		testEntry{name: "opt/app/config", content: "orphan"},
+	)
+	image, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+
+	host := newTestRegistry(t)
+	imageRef := host + "/test/warnings:latest"
+	pushTestImage(t, imageRef, image)
+
+	var warnings WarningCollector
+	var buf bytes.Buffer
+	exporter := NewImageExporter(WithWarnings(warnings.Collect))
+	if err := exporter.ExportImageFilesystemToWriter(imageRef, &buf, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	counts := warningCodes(warnings.Warnings())
+	if counts[WarningDanglingLink] != 1 {
+		t.Errorf("Expected 1 dangling link warning (sbin/gone), got %v", warnings.Warnings())
+	}
+	if counts[WarningSynthesizedDir] != 2 {
+		t.Errorf("Expected opt/ and opt/app/ to be synthesized, got %v", warnings.Warnings())
+	}
+
+	// Synthesized directories must appear in the output
+	names := make(map[string]bool)
+	tr := tar.NewReader(&buf)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names[header.Name] = true
+	}
+	if !names["opt/"] || !names["opt/app/"] {
+		t.Errorf("Expected synthesized directories in output, got %v", names)
+	}
+}
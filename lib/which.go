@@ -0,0 +1,180 @@
+package lib
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Actions of a PathChange
+const (
+	PathAdded    = "added"
+	PathModified = "modified"
+	PathDeleted  = "deleted"
+)
+
+// WhichOptions configures Which
+type WhichOptions struct {
+	// Platform selects the image of a multi-platform index, e.g. "linux/arm64";
+	// empty for the default platform
+	Platform string
+}
+
+// PathBlame tells which layers of an image changed a path. It is returned by
+// the Which method.
+type PathBlame struct {
+	// SchemaVersion is the version of this JSON document (see SchemaVersion)
+	SchemaVersion int `json:"schema_version"`
+
+	// Reference is the image reference that was queried
+	Reference string `json:"reference"`
+
+	// Digest is the manifest digest of the image
+	Digest string `json:"digest"`
+
+	// Path is the absolute path that was looked up, with symlinked parent
+	// directories resolved as in the flattened filesystem
+	Path string `json:"path"`
+
+	// Exists reports whether the path is in the flattened filesystem
+	Exists bool `json:"exists"`
+
+	// Changes lists every layer that added, replaced or deleted the path, in
+	// layer order; the last one made the path what it is in the image
+	Changes []PathChange `json:"changes"`
+}
+
+// PathChange is a layer changing a path
+type PathChange struct {
+	// Layer is the zero-based index of the layer in the image
+	Layer int `json:"layer"`
+
+	// Digest is the digest of the layer
+	Digest string `json:"digest"`
+
+	// Action is PathAdded, PathModified (the layer holds the path again) or
+	// PathDeleted (by a whiteout of the path or of a parent directory)
+	Action string `json:"action"`
+
+	// Type is the type of the entry the layer holds, e.g. "file" or
+	// "directory"; empty for deletions
+	Type string `json:"type,omitempty"`
+
+	// Size is the size of a regular file the layer holds
+	Size int64 `json:"size,omitempty"`
+
+	// CreatedBy is the history line of the layer, e.g. the Dockerfile
+	// instruction that produced it
+	CreatedBy string `json:"created_by,omitempty"`
+}
+
+// Which tells which layer last wrote or deleted a path of an image, and every
+// layer that changed it before. The layers are replayed like when flattening
+// the image, following symlinked parent directories and whiteouts, but file
+// contents are skipped rather than kept.
+//
+// Parameters:
+//   - imageRef: Image reference (e.g., "nginx:alpine")
+//   - filePath: Path in the image (e.g., "/etc/nginx/nginx.conf")
+//   - auth: Optional authentication configuration for private registries
+//   - opts: Optional platform
+//
+// Returns:
+//   - *PathBlame: The changes of the path, none if no layer has it
+//   - error: Any error encountered during the operation
+//
+// Example:
+//
+//	blame, err := exporter.Which("nginx:alpine", "/etc/nginx/nginx.conf", nil, nil)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	if n := len(blame.Changes); n > 0 {
+//	    last := blame.Changes[n-1]
+//	    fmt.Println(last.Action, "by layer", last.Layer, last.CreatedBy)
+//	}
+func (e *imageExporter) Which(imageRef string, filePath string, auth *AuthConfig, opts *WhichOptions) (*PathBlame, error) {
+	if opts == nil {
+		opts = &WhichOptions{}
+	}
+
+	image, err := e.openPlatformImage(imageRef, auth, opts.Platform)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image digest: %w", err)
+	}
+	history, err := layerHistory(image)
+	if err != nil {
+		return nil, err
+	}
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image layers: %w", err)
+	}
+
+	target := strings.TrimSuffix(e.cleanPath(filePath), "/")
+	filesystem := make(map[string]*fileEntry)
+	paths := newPathTrie()
+	windows := newWindowsDetector(image)
+	headersOnly := func(header *tar.Header, _ io.Reader) (*fileEntry, error) {
+		return &fileEntry{header: header}, nil
+	}
+	blame := &PathBlame{
+		SchemaVersion: SchemaVersion,
+		Reference:     imageRef,
+		Digest:        digest.String(),
+		Changes:       []PathChange{},
+	}
+
+	for i, layer := range layers {
+		if e.foreignLayers == ForeignLayersSkip && isForeignLayer(layer) {
+			continue
+		}
+		before, _ := lookupEntry(filesystem, resolveParentPath(filesystem, target))
+
+		reader, err := e.openLayer(layer, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get layer %d content: %w", i, err)
+		}
+		err = e.applyLayer(filesystem, paths, reader, i, windows, &ExportOptions{}, nil, headersOnly)
+		closeErr := reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		if closeErr != nil {
+			return nil, fmt.Errorf("failed to read layer %d: %w", i, closeErr)
+		}
+
+		resolved := resolveParentPath(filesystem, target)
+		after, _ := lookupEntry(filesystem, resolved)
+		if after == before {
+			continue
+		}
+		change := PathChange{Layer: i, Digest: history[i].Digest, CreatedBy: history[i].CreatedBy}
+		switch {
+		case after == nil:
+			change.Action = PathDeleted
+		case before == nil:
+			change.Action = PathAdded
+		default:
+			change.Action = PathModified
+		}
+		if after != nil {
+			change.Type = tarTypeName(after.header.Typeflag)
+			if after.header.Typeflag == tar.TypeReg || after.header.Typeflag == tar.TypeRegA {
+				change.Size = after.header.Size
+			}
+		}
+		blame.Changes = append(blame.Changes, change)
+	}
+
+	resolved := resolveParentPath(filesystem, target)
+	_, blame.Exists = lookupEntry(filesystem, resolved)
+	blame.Path = "/" + resolved
+	e.log().Debug("path blamed", "image", imageRef, "path", blame.Path, "changes", len(blame.Changes))
+	return blame, nil
+}
@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"archive/tar"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestWhich(t *testing.T) {
+	host := newTestRegistry(t)
+	imageRef := host + "/test/app:v1"
+
+	base := newTestLayer(t,
+		testEntry{name: "etc/", typeflag: tar.TypeDir, mode: 0755},
+		testEntry{name: "etc/app.conf", content: "a"},
+		testEntry{name: "opt/data/", typeflag: tar.TypeDir, mode: 0755},
+		testEntry{name: "opt/data/cache", content: "c"},
+		testEntry{name: "srv", typeflag: tar.TypeSymlink, linkname: "opt/data"},
+	)
+	unrelated := newTestLayer(t, testEntry{name: "usr/bin/tool", content: "elf"})
+	changed := newTestLayer(t,
+		testEntry{name: "etc/app.conf", content: "abc"},
+		testEntry{name: "opt/.wh.data"},
+	)
+	image, err := mutate.AppendLayers(empty.Image, base, unrelated, changed)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	pushTestImage(t, imageRef, image)
+	baseDigest, _ := base.Digest()
+	changedDigest, _ := changed.Digest()
+
+	exporter := NewImageExporter()
+	blame, err := exporter.Which(imageRef, "/etc/app.conf", nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	want := []PathChange{
+		{Layer: 0, Digest: baseDigest.String(), Action: PathAdded, Type: "file", Size: 1},
+		{Layer: 2, Digest: changedDigest.String(), Action: PathModified, Type: "file", Size: 3},
+	}
+	if !blame.Exists || blame.Path != "/etc/app.conf" {
+		t.Errorf("Expected /etc/app.conf to exist, got %q exists=%v", blame.Path, blame.Exists)
+	}
+	if len(blame.Changes) != len(want) {
+		t.Fatalf("Expected changes %+v, got %+v", want, blame.Changes)
+	}
+	for i := range want {
+		if blame.Changes[i] != want[i] {
+			t.Errorf("Expected change %d to be %+v, got %+v", i, want[i], blame.Changes[i])
+		}
+	}
+
+	// Looked up through a symlinked parent, deleted by the whiteout of that parent
+	blame, err = exporter.Which(imageRef, "srv/cache", nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if blame.Exists || blame.Path != "/opt/data/cache" {
+		t.Errorf("Expected deleted /opt/data/cache, got %q exists=%v", blame.Path, blame.Exists)
+	}
+	if len(blame.Changes) != 2 || blame.Changes[0].Action != PathAdded || blame.Changes[1].Action != PathDeleted || blame.Changes[1].Layer != 2 {
+		t.Errorf("Expected cache added by layer 0 and deleted by layer 2, got %+v", blame.Changes)
+	}
+
+	blame, err = exporter.Which(imageRef, "/nope", nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if blame.Exists || len(blame.Changes) != 0 {
+		t.Errorf("Expected no changes for /nope, got %+v", blame.Changes)
+	}
+}
@@ -0,0 +1,150 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// TestWhiteoutConformance checks whiteout handling against the OCI image spec
+// (image-layout "Whiteouts") with crafted layer tars, listing the entries of
+// the flattened archive as "name" or "name=content"
+func TestWhiteoutConformance(t *testing.T) {
+	dir := func(name string) testEntry { return testEntry{name: name, typeflag: tar.TypeDir} }
+	file := func(name, content string) testEntry { return testEntry{name: name, content: content} }
+	marker := func(name string) testEntry { return testEntry{name: name} }
+
+	tests := []struct {
+		name     string
+		layers   [][]testEntry
+		expected string
+	}{
+		{
+			name: "whiteout hides a lower file",
+			layers: [][]testEntry{
+				{dir("etc/"), file("etc/a", "1"), file("etc/b", "1")},
+				{marker("etc/.wh.a")},
+			},
+			expected: "[etc/ etc/b=1]",
+		},
+		{
+			name: "whiteout hides a lower directory and its children",
+			layers: [][]testEntry{
+				{dir("opt/"), dir("opt/app/"), file("opt/app/bin", "1"), file("opt/keep", "1")},
+				{marker("opt/.wh.app")},
+			},
+			expected: "[opt/ opt/keep=1]",
+		},
+		{
+			name: "whiteout before a re-added path in the same layer",
+			layers: [][]testEntry{
+				{file("a", "old")},
+				{marker(".wh.a"), file("a", "new")},
+			},
+			expected: "[a=new]",
+		},
+		{
+			name: "whiteout after a re-added path in the same layer",
+			layers: [][]testEntry{
+				{dir("d/"), file("d/old", "1")},
+				{dir("d/"), file("d/new", "2"), marker(".wh.d")},
+			},
+			expected: "[d/ d/new=2]",
+		},
+		{
+			name: "opaque whiteout hides lower children only",
+			layers: [][]testEntry{
+				{dir("var/"), file("var/lower", "1"), dir("var/cache/"), file("var/cache/x", "1")},
+				{dir("var/"), marker("var/.wh..wh..opq"), file("var/upper", "2")},
+			},
+			expected: "[var/ var/upper=2]",
+		},
+		{
+			name: "opaque whiteout after entries of the same layer",
+			layers: [][]testEntry{
+				{dir("var/"), file("var/lower", "1")},
+				{dir("var/"), dir("var/cache/"), file("var/cache/y", "2"), file("var/upper", "2"), marker("var/.wh..wh..opq")},
+			},
+			expected: "[var/ var/cache/ var/cache/y=2 var/upper=2]",
+		},
+		{
+			name: "opaque whiteout does not reach higher layers",
+			layers: [][]testEntry{
+				{dir("srv/"), file("srv/a", "1")},
+				{marker("srv/.wh..wh..opq")},
+				{file("srv/b", "3")},
+			},
+			expected: "[srv/ srv/b=3]",
+		},
+		{
+			name: "whiteouts of missing paths are ignored",
+			layers: [][]testEntry{
+				{file("kept", "1")},
+				{marker(".wh.missing"), marker("nowhere/.wh.file"), marker("empty/.wh..wh..opq")},
+			},
+			expected: "[kept=1]",
+		},
+		{
+			name: "whiteout names are never emitted",
+			layers: [][]testEntry{
+				{file("bin", "1")},
+				{dir(".wh..wh.plnk/"), file(".wh..wh.plnk/1234.5678", "link"), marker(".wh.bin"), file("new", "2")},
+			},
+			expected: "[new=2]",
+		},
+	}
+
+	host := newTestRegistry(t)
+	exporter := NewImageExporter()
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layers := make([]v1.Layer, 0, len(tt.layers))
+			for _, entries := range tt.layers {
+				layers = append(layers, newTestLayer(t, entries...))
+			}
+			image, err := mutate.AppendLayers(empty.Image, layers...)
+			if err != nil {
+				t.Fatalf("Failed to build test image: %v", err)
+			}
+			imageRef := fmt.Sprintf("%s/test/whiteout%d:latest", host, i)
+			pushTestImage(t, imageRef, image)
+
+			var output bytes.Buffer
+			if err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &output, nil, &ExportOptions{}); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			var names []string
+			reader := tar.NewReader(&output)
+			for {
+				header, err := reader.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Failed to read archive: %v", err)
+				}
+				if strings.Contains("/"+header.Name, "/.wh.") {
+					t.Errorf("Expected no whiteout names, got %s", header.Name)
+				}
+				content, _ := io.ReadAll(reader)
+				if header.Typeflag == tar.TypeReg {
+					names = append(names, header.Name+"="+string(content))
+				} else {
+					names = append(names, header.Name)
+				}
+			}
+			sort.Strings(names)
+			if got := fmt.Sprint(names); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// newTestWindowsImage builds an image with the layer layout of Windows images
+func newTestWindowsImage(t *testing.T, osVersion, marker string) v1.Image {
+	t.Helper()
+	base := newTestLayer(t,
+		testEntry{name: "Files", typeflag: tar.TypeDir},
+		testEntry{name: "Files/Windows", typeflag: tar.TypeDir},
+		testEntry{name: "Files/Windows/System32", typeflag: tar.TypeDir},
+		testEntry{name: "Files/Windows/System32/cmd.exe", content: "cmd"},
+		testEntry{name: "Files/ProgramData", typeflag: tar.TypeDir},
+		testEntry{name: "Files/ProgramData/Setup.log", content: "setup"},
+		testEntry{name: "Hives", typeflag: tar.TypeDir},
+		testEntry{name: "Hives/Software_Base", content: "hive"},
+	)
+	app := newTestLayer(t,
+		testEntry{name: `Files\App`, typeflag: tar.TypeDir},
+		testEntry{name: `Files\App\app.exe`, content: marker},
+		testEntry{name: "Files/windows/system32/CMD.EXE", content: "patched"},
+		testEntry{name: "Files/programdata/.wh.SETUP.LOG"},
+	)
+	image, err := mutate.AppendLayers(empty.Image, base, app)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	configFile, err := image.ConfigFile()
+	if err != nil {
+		t.Fatalf("Failed to get test config: %v", err)
+	}
+	configFile.OS, configFile.Architecture, configFile.OSVersion = "windows", "amd64", osVersion
+	image, err = mutate.ConfigFile(image, configFile)
+	if err != nil {
+		t.Fatalf("Failed to set test config: %v", err)
+	}
+	return image
+}
+
+func TestWindowsImage(t *testing.T) {
+	index := v1.ImageIndex(empty.Index)
+	for version, marker := range map[string]string{"10.0.17763.5329": "ltsc2019", "10.0.20348.2227": "ltsc2022"} {
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{
+			Add: newTestWindowsImage(t, version, marker),
+			Descriptor: v1.Descriptor{Platform: &v1.Platform{
+				OS: "windows", Architecture: "amd64", OSVersion: version,
+			}},
+		})
+	}
+	imageRef := newTestRegistry(t) + "/test/nanoserver:latest"
+	ref, _ := name.ParseReference(imageRef)
+	if err := remote.WriteIndex(ref, index); err != nil {
+		t.Fatalf("Failed to push test index: %v", err)
+	}
+	exporter := NewImageExporter()
+
+	var out bytes.Buffer
+	opts := &ExportOptions{Platform: "windows/amd64:10.0.20348"}
+	if err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &out, nil, opts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	files := make(map[string]string)
+	names := make(map[string]int)
+	reader := tar.NewReader(&out)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read export: %v", err)
+		}
+		names[strings.ToLower(strings.TrimSuffix(header.Name, "/"))]++
+		content, _ := io.ReadAll(reader)
+		files[header.Name] = string(content)
+	}
+	if files["Files/App/app.exe"] != "ltsc2022" {
+		t.Errorf("Expected the 10.0.20348 image with backslash paths converted, got %v", files)
+	}
+	if files["Hives/Software_Base"] != "hive" {
+		t.Errorf("Expected the registry hives, got %v", files)
+	}
+	if names["files/windows/system32/cmd.exe"] != 1 || files["Files/Windows/System32/cmd.exe"] != "patched" {
+		t.Errorf("Expected cmd.exe replaced regardless of case, got %v", files)
+	}
+	if names["files/programdata/setup.log"] != 0 {
+		t.Errorf("Expected the whiteout to delete Setup.log regardless of case, got %v", files)
+	}
+	for path, count := range names {
+		if count > 1 {
+			t.Errorf("Expected one entry for %s, got %d", path, count)
+		}
+	}
+
+	if _, err := exporter.GetImageConfig(imageRef, nil); err == nil {
+		t.Error("Expected an error without a linux/amd64 image")
+	}
+	digest, err := index.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, child := range digest.Manifests {
+		config, err := exporter.GetImageConfig(ref.Context().Digest(child.Digest.String()).String(), nil)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if config.OS != "windows" || config.OSVersion != child.Platform.OSVersion {
+			t.Errorf("Expected windows %s, got %s %s", child.Platform.OSVersion, config.OS, config.OSVersion)
+		}
+	}
+
+	if platformMatches(&v1.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.203481.1"}, v1.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348"}) {
+		t.Error("Expected os.version to match whole components only")
+	}
+}
@@ -0,0 +1,223 @@
+package lib
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// EnvWorkDir overrides the default work directory, like the --workdir flag
+const EnvWorkDir = "IMGEX_WORKDIR"
+
+// WorkDir is the managed location for everything imgex keeps between runs.
+// Disposable data lives under CacheDir and data worth keeping under StateDir:
+//
+//	<CacheDir>/blobs/   content-addressed blob cache (see WithCache)
+//	<StateDir>/jobs/    batch and watch job state, export records
+//
+// Features create their directory on first use with Ensure.
+type WorkDir struct {
+	// CacheDir holds data that can be rebuilt by downloading it again
+	CacheDir string `json:"cache_dir"`
+
+	// StateDir holds job state
+	StateDir string `json:"state_dir"`
+}
+
+// Work directory areas, relative to CacheDir or StateDir
+const (
+	WorkDirBlobs = "blobs"
+	WorkDirJobs  = "jobs"
+)
+
+// NewWorkDir returns a work directory keeping everything under root, in
+// root/cache and root/state.
+func NewWorkDir(root string) *WorkDir {
+	return &WorkDir{
+		CacheDir: filepath.Join(root, "cache"),
+		StateDir: filepath.Join(root, "state"),
+	}
+}
+
+// DefaultWorkDir returns the work directory used when none is configured:
+// $IMGEX_WORKDIR if set, otherwise the platform's cache and state locations
+// following the XDG base directory specification ($XDG_CACHE_HOME/imgex and
+// $XDG_STATE_HOME/imgex, defaulting to ~/.cache/imgex and ~/.local/state/imgex).
+// macOS and Windows use their native per-user cache and application data folders.
+//
+// Returns:
+//   - *WorkDir: The default locations; nothing is created
+//   - error: The home directory could not be determined
+func DefaultWorkDir() (*WorkDir, error) {
+	if root := os.Getenv(EnvWorkDir); root != "" {
+		return NewWorkDir(root), nil
+	}
+	cacheHome, err := os.UserCacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate the cache directory: %w", err)
+	}
+	stateHome, err := userStateDir(runtime.GOOS)
+	if err != nil {
+		return nil, err
+	}
+	return &WorkDir{
+		CacheDir: filepath.Join(cacheHome, "imgex"),
+		StateDir: filepath.Join(stateHome, "imgex"),
+	}, nil
+}
+
+// userStateDir returns the per-user state directory, the counterpart of
+// os.UserCacheDir for XDG_STATE_HOME
+func userStateDir(goos string) (string, error) {
+	switch goos {
+	case "windows":
+		if dir := os.Getenv("LocalAppData"); dir != "" {
+			return dir, nil
+		}
+		return "", fmt.Errorf("failed to locate the state directory: %%LocalAppData%% is not set")
+	case "darwin", "ios":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to locate the state directory: %w", err)
+		}
+		return filepath.Join(home, "Library", "Application Support"), nil
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the state directory: %w", err)
+	}
+	return filepath.Join(home, ".local", "state"), nil
+}
+
+// Cache returns the blob cache directory, to pass to WithCache
+func (w *WorkDir) Cache() string {
+	return w.CacheDir
+}
+
+// Path returns the directory of a work directory area such as WorkDirJobs
+func (w *WorkDir) Path(area string) string {
+	switch area {
+	case WorkDirJobs:
+		return filepath.Join(w.StateDir, area)
+	default:
+		return filepath.Join(w.CacheDir, area)
+	}
+}
+
+// Ensure creates the directory of an area, readable only by the current user,
+// and returns its path
+func (w *WorkDir) Ensure(area string) (string, error) {
+	dir := w.Path(area)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create work directory %s: %w", dir, err)
+	}
+	return dir, nil
+}
+
+// CleanOptions selects what WorkDir.Clean removes
+type CleanOptions struct {
+	// Areas to clean, e.g. WorkDirBlobs; empty means every area
+	Areas []string
+
+	// OlderThan keeps files modified more recently than this; zero removes all
+	OlderThan time.Duration
+
+	// DryRun reports what would be removed without deleting anything
+	DryRun bool
+}
+
+// CleanResult reports what WorkDir.Clean removed from one area
+type CleanResult struct {
+	Area  string `json:"area"`
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Clean removes files from the work directory areas. Only the known area
+// directories are touched, never CacheDir or StateDir themselves, so a work
+// directory pointed at a shared location cannot lose unrelated files. Empty
+// directories left behind are removed.
+//
+// Parameters:
+//   - opts: Areas and age limit; nil cleans everything
+//
+// Returns:
+//   - []CleanResult: Files and bytes removed per area, in area order
+//   - error: Any error encountered while removing files
+func (w *WorkDir) Clean(opts *CleanOptions) ([]CleanResult, error) {
+	if opts == nil {
+		opts = &CleanOptions{}
+	}
+	areas := opts.Areas
+	if len(areas) == 0 {
+		areas = []string{WorkDirBlobs, WorkDirJobs}
+	}
+	cutoff := time.Now().Add(-opts.OlderThan)
+
+	var results []CleanResult
+	for _, area := range areas {
+		if !isWorkDirArea(area) {
+			return results, fmt.Errorf("unknown work directory area %q", area)
+		}
+		result := CleanResult{Area: area, Path: w.Path(area)}
+		var dirs []string
+		err := filepath.WalkDir(result.Path, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && path == result.Path {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if entry.IsDir() {
+				if path != result.Path {
+					dirs = append(dirs, path)
+				}
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if opts.OlderThan > 0 && info.ModTime().After(cutoff) {
+				return nil
+			}
+			if !opts.DryRun {
+				if err := os.Remove(path); err != nil {
+					return fmt.Errorf("failed to remove %s: %w", path, err)
+				}
+			}
+			result.Files++
+			result.Bytes += info.Size()
+			return nil
+		})
+		if err != nil {
+			return results, fmt.Errorf("failed to clean %s: %w", result.Path, err)
+		}
+		if !opts.DryRun {
+			// Deepest first, so that parents are empty when they are reached
+			sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+			for _, dir := range dirs {
+				os.Remove(dir) // fails harmlessly while the directory is not empty
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// isWorkDirArea reports whether area is one of the WorkDir* area names
+func isWorkDirArea(area string) bool {
+	switch area {
+	case WorkDirBlobs, WorkDirJobs:
+		return true
+	}
+	return false
+}
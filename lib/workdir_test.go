@@ -0,0 +1,131 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestDefaultWorkDir(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv(EnvWorkDir, root)
+	w, err := DefaultWorkDir()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if w.CacheDir != filepath.Join(root, "cache") || w.StateDir != filepath.Join(root, "state") {
+		t.Errorf("Expected cache and state under %s, got %+v", root, w)
+	}
+
+	t.Setenv(EnvWorkDir, "")
+	t.Setenv("XDG_STATE_HOME", "/xdg/state")
+	if dir, err := userStateDir("linux"); err != nil || dir != "/xdg/state" {
+		t.Errorf("Expected XDG_STATE_HOME to be used, got %s, %v", dir, err)
+	}
+	t.Setenv("XDG_STATE_HOME", "relative/state")
+	t.Setenv("HOME", "/home/ci")
+	if dir, err := userStateDir("linux"); err != nil || dir != filepath.Join("/home/ci", ".local", "state") {
+		t.Errorf("Expected a relative XDG_STATE_HOME to be ignored, got %s, %v", dir, err)
+	}
+}
+
+func TestWorkDirPaths(t *testing.T) {
+	w := NewWorkDir("/work")
+	for area, expected := range map[string]string{
+		WorkDirBlobs: filepath.Join("/work", "cache", "blobs"),
+		WorkDirJobs:  filepath.Join("/work", "state", "jobs"),
+	} {
+		if got := w.Path(area); got != expected {
+			t.Errorf("Expected %s at %s, got %s", area, expected, got)
+		}
+	}
+
+	// The blob cache of WithCache lands in the blobs area
+	cache := &blobCache{dir: w.Cache()}
+	digest := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("a", 64)}
+	if got := filepath.Dir(filepath.Dir(cache.path(digest))); got != w.Path(WorkDirBlobs) {
+		t.Errorf("Expected cached blobs under %s, got %s", w.Path(WorkDirBlobs), got)
+	}
+}
+
+func TestWorkDirClean(t *testing.T) {
+	root := t.TempDir()
+	w := NewWorkDir(root)
+	write := func(area, name string, age time.Duration) string {
+		t.Helper()
+		dir, err := w.Ensure(area)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("data"), 0600); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		stamp := time.Now().Add(-age)
+		if err := os.Chtimes(path, stamp, stamp); err != nil {
+			t.Fatalf("Failed to set file time: %v", err)
+		}
+		return path
+	}
+	oldBlob := write(WorkDirBlobs, "sha256/old", 48*time.Hour)
+	newBlob := write(WorkDirBlobs, "sha256/new", 0)
+	job := write(WorkDirJobs, "app.json", 48*time.Hour)
+	unrelated := filepath.Join(root, "cache", "keep.txt")
+	if err := os.WriteFile(unrelated, []byte("keep"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// A dry run reports without removing
+	results, err := w.Clean(&CleanOptions{Areas: []string{WorkDirBlobs}, DryRun: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(results) != 1 || results[0].Files != 2 || results[0].Bytes != 8 {
+		t.Errorf("Expected 2 files of 8 bytes in the dry run, got %+v", results)
+	}
+	if _, err := os.Stat(oldBlob); err != nil {
+		t.Errorf("Expected the dry run to keep %s, got %v", oldBlob, err)
+	}
+
+	results, err = w.Clean(&CleanOptions{Areas: []string{WorkDirBlobs}, OlderThan: 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(results) != 1 || results[0].Files != 1 {
+		t.Errorf("Expected 1 file older than a day, got %+v", results)
+	}
+	if _, err := os.Stat(oldBlob); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed, got %v", oldBlob, err)
+	}
+	for _, path := range []string{newBlob, job} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be kept, got %v", path, err)
+		}
+	}
+
+	// Everything, including areas that were never created
+	results, err = w.Clean(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(results) != 2 {
+		t.Errorf("Expected a result per area, got %+v", results)
+	}
+	if _, err := os.Stat(filepath.Join(w.Path(WorkDirBlobs), "sha256")); !os.IsNotExist(err) {
+		t.Errorf("Expected empty directories to be removed, got %v", err)
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Errorf("Expected files outside the areas to be kept, got %v", err)
+	}
+
+	if _, err := w.Clean(&CleanOptions{Areas: []string{".."}}); err == nil {
+		t.Error("Expected an error for an unknown area")
+	}
+}
@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/compression"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+// newTestZstdLayer builds a zstd-compressed OCI layer from the given entries
+func newTestZstdLayer(t *testing.T, entries ...testEntry) v1.Layer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		typeflag := entry.typeflag
+		if typeflag == 0 {
+			typeflag = tar.TypeReg
+		}
+		header := &tar.Header{Name: entry.name, Typeflag: typeflag, Linkname: entry.linkname, Mode: 0644}
+		if typeflag == tar.TypeReg {
+			header.Size = int64(len(entry.content))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("Failed to write test header %s: %v", entry.name, err)
+		}
+		tw.Write([]byte(entry.content))
+	}
+	tw.Close()
+	data := buf.Bytes()
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}, tarball.WithCompression(compression.ZStd), tarball.WithMediaType(types.OCILayerZStd))
+	if err != nil {
+		t.Fatalf("Failed to build zstd layer: %v", err)
+	}
+	return layer
+}
+
+func TestZstdLayers(t *testing.T) {
+	image, err := mutate.AppendLayers(mutate.MediaType(empty.Image, types.OCIManifestSchema1),
+		newTestLayer(t, testEntry{name: "etc/os-release", content: "gzip base"}, testEntry{name: "etc/old", content: "old"}),
+		newTestZstdLayer(t, testEntry{name: "app/run", content: "zstd app"}, testEntry{name: "etc/.wh.old"}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to build test image: %v", err)
+	}
+	image = mutate.ConfigMediaType(image, types.OCIConfigJSON)
+	imageRef := newTestRegistry(t) + "/test/zstd:v1"
+	pushTestImage(t, imageRef, image)
+	manifest, _ := image.Manifest()
+	if manifest.Layers[1].MediaType != types.OCILayerZStd {
+		t.Fatalf("Expected a zstd layer, got %s", manifest.Layers[1].MediaType)
+	}
+
+	check := func(name string, files map[string]string) {
+		t.Helper()
+		if files["app/run"] != "zstd app" || files["etc/os-release"] != "gzip base" {
+			t.Errorf("%s: expected both layers, got %v", name, files)
+		}
+		if _, ok := files["etc/old"]; ok {
+			t.Errorf("%s: expected the whiteout of the zstd layer applied, got %v", name, files)
+		}
+	}
+
+	// Streamed straight from the registry, through the cache, and with progress
+	cacheDir := t.TempDir()
+	for name, exporter := range map[string]ImageExporter{
+		"plain": NewImageExporter(),
+		"cache": NewImageExporter(WithCache(cacheDir)),
+	} {
+		var out bytes.Buffer
+		if err := exporter.ExportImageFilesystemToWriter(imageRef, &out, nil); err != nil {
+			t.Fatalf("%s: expected no error, got %v", name, err)
+		}
+		check(name, readTestTar(t, out.Bytes()))
+
+		out.Reset()
+		opts := &ExportOptions{LayerProgress: func(int, int, int64, int64) {}}
+		if err := exporter.ExportImageFilesystemToWriterWithOptions(imageRef, &out, nil, opts); err != nil {
+			t.Fatalf("%s: expected no error with progress, got %v", name, err)
+		}
+		check(name+" with progress", readTestTar(t, out.Bytes()))
+	}
+	var out bytes.Buffer
+	offline := NewImageExporter(WithCache(cacheDir), WithOffline())
+	if err := offline.ExportImageFilesystemToWriter(imageRef, &out, nil); err != nil {
+		t.Fatalf("Expected no error offline, got %v", err)
+	}
+	check("offline", readTestTar(t, out.Bytes()))
+
+	// Single files go through the same pipeline
+	file, _, err := NewImageExporter().OpenFile(imageRef, "/app/run", nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error opening a file, got %v", err)
+	}
+	defer file.Close()
+	if content, _ := io.ReadAll(file); string(content) != "zstd app" {
+		t.Errorf("Expected the file of the zstd layer, got %q", content)
+	}
+}
+
+func TestDecompressZstdSkippableFrame(t *testing.T) {
+	var compressed bytes.Buffer
+	// A skippable frame with 3 bytes of metadata, then the data frame
+	compressed.Write([]byte{0x5e, 0x2a, 0x4d, 0x18, 3, 0, 0, 0, 'm', 'e', 't'})
+	writer, err := zstd.NewWriter(&compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write([]byte("layer content"))
+	writer.Close()
+
+	reader, err := decompressStream(io.NopCloser(&compressed))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer reader.Close()
+	if content, err := io.ReadAll(reader); err != nil || string(content) != "layer content" {
+		t.Errorf("Expected the content after the skippable frame, got %q (%v)", content, err)
+	}
+}